@@ -0,0 +1,7 @@
+package main
+
+// Plugins are compiled into the gateway by importing their packages here for
+// their side effects. Each plugin registers itself with plugins.Register from
+// an init function, for example:
+//
+//	import _ "github.com/acme/flash-gateway-plugins/pii"
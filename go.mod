@@ -3,8 +3,20 @@ module github.com/NamanArora/flash-gateway
 go 1.20
 
 require (
+	github.com/abadojack/whatlanggo v1.0.1
+	github.com/andybalholm/brotli v1.1.0
 	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.17.4
 	github.com/lib/pq v1.10.9
+	github.com/pkoukk/tiktoken-go v0.1.7
+	github.com/pkoukk/tiktoken-go-loader v0.0.2
+	github.com/yalue/onnxruntime_go v1.13.0
+	golang.org/x/net v0.17.0
 	golang.org/x/sync v0.3.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/audit"
+)
+
+// SetAuditLog records admin actions to the log and serves them on
+// GET /admin/audit
+func (s *Server) SetAuditLog(auditLog audit.Log) {
+	s.auditLog = auditLog
+	s.HandleFuncRole("/admin/audit", RoleAnalyst, s.auditHandler)
+}
+
+// audited runs an authenticated admin request, recording it when it changes
+// state, was denied, or its handler asked for it to be recorded
+func (s *Server) audited(w http.ResponseWriter, r *http.Request, principal Principal, serve func(http.ResponseWriter, *http.Request)) {
+	if s.auditLog == nil {
+		serve(w, r)
+		return
+	}
+
+	event := &audit.Event{
+		Time:       time.Now().UTC(),
+		Actor:      principal.Name,
+		AuthMethod: principal.Method,
+		Role:       principal.Role.String(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	serve(recorder, r.WithContext(audit.WithEvent(r.Context(), event)))
+
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+	if readOnly && event.Action == "" && recorder.status != http.StatusForbidden {
+		return
+	}
+	if event.Action == "" {
+		event.Action = r.Method + " " + r.URL.Path
+	}
+	event.Status = recorder.status
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.auditLog.Append(ctx, event); err != nil {
+		log.Printf("[ADMIN] Failed to record %s by %s: %v", event.Action, event.Actor, err)
+	}
+}
+
+// auditHandler returns recorded admin actions, newest first, filtered by
+// actor, action, target, since and until (RFC 3339) and limit
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Limit:  100,
+	}
+	for name, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q: use RFC 3339", name, value))
+				return
+			}
+			*dest = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 1000 {
+			WriteError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := s.auditLog.Query(r.Context(), filter)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+// statusRecorder captures the status an admin handler responds with
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush passes through so exports can stream
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
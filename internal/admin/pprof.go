@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/audit"
+)
+
+// defaultDumpDir is where profile dumps are written unless configured otherwise
+const defaultDumpDir = "./data/dumps"
+
+// dumpProfiles are the profiles POST /admin/debug/dump can write
+var dumpProfiles = map[string]string{
+	"heap":      "heap.pprof",
+	"allocs":    "allocs.pprof",
+	"goroutine": "goroutine.txt", // Full stacks as text, readable without tooling
+}
+
+// registerProfiling serves pprof under /debug/pprof/ and on-demand dumps.
+// Both need the admin role: profiles expose internals, and CPU profiles and
+// traces slow the gateway down while they run.
+func (s *Server) registerProfiling() {
+	profiles := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+		switch name {
+		case "cmdline":
+			pprof.Cmdline(w, r)
+		case "symbol":
+			pprof.Symbol(w, r)
+		case "profile", "trace":
+			// Captures that cost CPU while they run are recorded
+			audit.Record(r.Context(), "profile.captured", name, nil, nil)
+			if seconds := r.URL.Query().Get("seconds"); seconds != "" {
+				audit.Detail(r.Context(), "seconds", seconds)
+			}
+			if name == "profile" {
+				pprof.Profile(w, r)
+			} else {
+				pprof.Trace(w, r)
+			}
+		default:
+			pprof.Index(w, r)
+		}
+	})
+	s.HandleRole("/debug/pprof/", RoleAdmin, profiles)
+	s.HandleFuncRole("/admin/debug/dump", RoleAdmin, s.dumpHandler)
+}
+
+// dumpHandler writes heap and goroutine profiles to the dump directory, so
+// memory growth can be compared across snapshots taken under load.
+// ?profiles=heap,allocs,goroutine picks the profiles (default heap and
+// goroutine) and ?gc=true collects garbage first, so the heap profile shows
+// only live memory.
+func (s *Server) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	names := []string{"heap", "goroutine"}
+	if requested := r.URL.Query().Get("profiles"); requested != "" {
+		names = strings.Split(requested, ",")
+	}
+	for _, name := range names {
+		if _, ok := dumpProfiles[name]; !ok {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("unknown profile %q", name))
+			return
+		}
+	}
+
+	dir := s.cfg.Admin.Profiling.DumpDir
+	if dir == "" {
+		dir = defaultDumpDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Sprintf("creating dump directory: %v", err))
+		return
+	}
+
+	if r.URL.Query().Get("gc") == "true" {
+		runtime.GC()
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	files := make(map[string]string, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, stamp+"-"+dumpProfiles[name])
+		if err := writeProfile(name, path); err != nil {
+			WriteError(w, http.StatusInternalServerError, fmt.Sprintf("writing %s profile: %v", name, err))
+			return
+		}
+		files[name] = path
+	}
+	audit.Record(r.Context(), "profile.dumped", strings.Join(names, ","), nil, nil)
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"files":      files,
+		"goroutines": runtime.NumGoroutine(),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes":    stats.HeapAlloc,
+			"heap_inuse_bytes":    stats.HeapInuse,
+			"heap_objects":        stats.HeapObjects,
+			"heap_released_bytes": stats.HeapReleased,
+			"sys_bytes":           stats.Sys,
+			"num_gc":              stats.NumGC,
+		},
+	})
+}
+
+// writeProfile writes one runtime profile to a file
+func writeProfile(name, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	debug := 0
+	if name == "goroutine" {
+		debug = 2
+	}
+	if err := runtimepprof.Lookup(name).WriteTo(file, debug); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/oidc"
+)
+
+// Role is what an admin API caller may do. Roles are ordered: each one can
+// do everything the roles below it can.
+type Role int
+
+const (
+	// RoleReadOnly can read gateway state, health and aggregated usage
+	RoleReadOnly Role = iota + 1
+	// RoleAnalyst can also read request logs, conversations and the config
+	RoleAnalyst
+	// RoleAdmin can also change the gateway: toggles, drains and reloads
+	RoleAdmin
+)
+
+// ParseRole parses a role name from configuration
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "read-only", "readonly":
+		return RoleReadOnly, nil
+	case "analyst":
+		return RoleAnalyst, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown role %q, use admin, analyst or read-only", name)
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleReadOnly:
+		return "read-only"
+	case RoleAnalyst:
+		return "analyst"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// Principal is an authenticated admin API caller
+type Principal struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"-"`
+	Method string `json:"method"` // "token" or "oidc"
+}
+
+// principalContextKey is the context key under which the caller is stored
+const principalContextKey = "admin_principal"
+
+// PrincipalFromContext returns the caller of an admin request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(Principal)
+	return principal, ok
+}
+
+// staticToken is a configured token and the caller it identifies
+type staticToken struct {
+	token     string
+	principal Principal
+}
+
+// authenticator identifies admin API callers from static tokens or OIDC JWTs
+type authenticator struct {
+	tokens    []staticToken
+	verifier  *oidc.Verifier
+	roleClaim string
+	roles     map[string]Role // Claim value -> role
+}
+
+// newAuthenticator builds the configured authentication methods
+func newAuthenticator(cfg config.AdminConfig) (*authenticator, error) {
+	a := &authenticator{}
+
+	token := cfg.Token
+	if token == "" || strings.Contains(token, "${") {
+		token = os.Getenv("ADMIN_TOKEN")
+	}
+	if token != "" {
+		a.tokens = append(a.tokens, staticToken{token: token, principal: Principal{Name: "admin", Role: RoleAdmin, Method: "token"}})
+	}
+	for i, tc := range cfg.Tokens {
+		if tc.Token == "" {
+			return nil, fmt.Errorf("tokens[%d]: token is required", i)
+		}
+		role, err := ParseRole(tc.Role)
+		if err != nil {
+			return nil, fmt.Errorf("tokens[%d]: %w", i, err)
+		}
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("token-%d", i)
+		}
+		a.tokens = append(a.tokens, staticToken{token: tc.Token, principal: Principal{Name: name, Role: role, Method: "token"}})
+	}
+
+	if cfg.OIDC != nil {
+		// The identity provider's role claim is taken only through an explicit
+		// mapping, so a group that happens to be called "admin" grants nothing
+		if len(cfg.OIDC.Roles) == 0 {
+			return nil, fmt.Errorf("oidc: roles must map role claim values to admin roles")
+		}
+		verifier, err := oidc.NewVerifier(cfg.OIDC.OIDCConfig)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+		a.verifier = verifier
+		a.roleClaim = cfg.OIDC.RoleClaim
+		if a.roleClaim == "" {
+			a.roleClaim = "roles"
+		}
+		a.roles = make(map[string]Role, len(cfg.OIDC.Roles))
+		for value, name := range cfg.OIDC.Roles {
+			role, err := ParseRole(name)
+			if err != nil {
+				return nil, fmt.Errorf("oidc roles[%s]: %w", value, err)
+			}
+			a.roles[value] = role
+		}
+	}
+
+	if len(a.tokens) == 0 && a.verifier == nil {
+		log.Println("[WARNING] No admin token configured, admin API will reject all requests")
+	}
+	return a, nil
+}
+
+// identify returns the caller presenting a bearer token or X-Admin-Token header
+func (a *authenticator) identify(r *http.Request) (Principal, error) {
+	provided := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); provided == "" && strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if provided == "" {
+		return Principal{}, fmt.Errorf("invalid or missing admin token")
+	}
+
+	// Compare against every token so timing doesn't reveal which one matched
+	var match *Principal
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(a.tokens[i].token)) == 1 && match == nil {
+			match = &a.tokens[i].principal
+		}
+	}
+	if match != nil {
+		return *match, nil
+	}
+
+	if a.verifier != nil && oidc.LooksLikeJWT(provided) {
+		claims, err := a.verifier.Verify(r.Context(), provided)
+		if err != nil {
+			return Principal{}, err
+		}
+		role := a.roleFromClaims(claims)
+		if role == 0 {
+			return Principal{}, fmt.Errorf("token grants no admin role")
+		}
+		name := claims.String("email")
+		if name == "" {
+			name = claims.String("sub")
+		}
+		return Principal{Name: name, Role: role, Method: "oidc"}, nil
+	}
+	return Principal{}, fmt.Errorf("invalid or missing admin token")
+}
+
+// roleFromClaims returns the highest role the configured mapping grants for
+// the role claim's values. Values without a mapping grant nothing.
+func (a *authenticator) roleFromClaims(claims oidc.Claims) Role {
+	var best Role
+	for _, value := range claims.Strings(a.roleClaim) {
+		if role, ok := a.roles[value]; ok && role > best {
+			best = role
+		}
+	}
+	return best
+}
+
+// requiredRole returns the role a request needs: reads need the route's role,
+// anything that changes state needs admin
+func requiredRole(r *http.Request, read Role) Role {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return read
+	}
+	return RoleAdmin
+}
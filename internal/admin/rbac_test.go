@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/oidc"
+)
+
+func TestNewAuthenticatorOIDCRoles(t *testing.T) {
+	base := config.OIDCConfig{Issuer: "https://issuer.example.com", Audience: "gateway"}
+
+	tests := []struct {
+		name    string
+		roles   map[string]string
+		wantErr bool
+	}{
+		{name: "mapping required", roles: nil, wantErr: true},
+		{name: "unknown role", roles: map[string]string{"gateway-admins": "root"}, wantErr: true},
+		{name: "valid mapping", roles: map[string]string{"gateway-admins": "admin", "everyone": "read-only"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newAuthenticator(config.AdminConfig{
+				Token: "token",
+				OIDC:  &config.AdminOIDCConfig{OIDCConfig: base, Roles: tt.roles},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAuthenticator() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoleFromClaims(t *testing.T) {
+	a := &authenticator{
+		roleClaim: "groups",
+		roles: map[string]Role{
+			"gateway-admins":   RoleAdmin,
+			"gateway-analysts": RoleAnalyst,
+			"everyone":         RoleReadOnly,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		claims oidc.Claims
+		want   Role
+	}{
+		{name: "single mapped value", claims: oidc.Claims{"groups": "gateway-analysts"}, want: RoleAnalyst},
+		{name: "highest of several", claims: oidc.Claims{"groups": []interface{}{"everyone", "gateway-admins", "gateway-analysts"}}, want: RoleAdmin},
+		{name: "unmapped values ignored", claims: oidc.Claims{"groups": []interface{}{"engineering", "everyone"}}, want: RoleReadOnly},
+		{name: "role name is not a mapping", claims: oidc.Claims{"groups": "admin"}, want: 0},
+		{name: "other claim ignored", claims: oidc.Claims{"roles": "gateway-admins"}, want: 0},
+		{name: "non-string values ignored", claims: oidc.Claims{"groups": []interface{}{1, true}}, want: 0},
+		{name: "no claim", claims: oidc.Claims{}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.roleFromClaims(tt.claims); got != tt.want {
+				t.Fatalf("roleFromClaims() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method string
+		read   Role
+		want   Role
+	}{
+		{method: http.MethodGet, read: RoleReadOnly, want: RoleReadOnly},
+		{method: http.MethodHead, read: RoleAnalyst, want: RoleAnalyst},
+		{method: http.MethodPost, read: RoleReadOnly, want: RoleAdmin},
+		{method: http.MethodPut, read: RoleAnalyst, want: RoleAdmin},
+		{method: http.MethodPatch, read: RoleReadOnly, want: RoleAdmin},
+		{method: http.MethodDelete, read: RoleReadOnly, want: RoleAdmin},
+		{method: http.MethodOptions, read: RoleReadOnly, want: RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.read.String(), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/admin/state", nil)
+			if got := requiredRole(r, tt.read); got != tt.want {
+				t.Fatalf("requiredRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
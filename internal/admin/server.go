@@ -0,0 +1,415 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// StatusFunc returns a JSON-serializable snapshot of a subsystem's state
+type StatusFunc func() interface{}
+
+// Toggle is a runtime switch exposed under /admin/toggles/{name}
+type Toggle struct {
+	Get func() interface{}
+	Set func(value string) error
+}
+
+// Server is the admin API listener, separate from client traffic
+type Server struct {
+	cfg        *config.Config
+	auth       *authenticator
+	mux        *http.ServeMux
+	httpServer *http.Server
+	startedAt  time.Time
+	auditLog   audit.Log
+
+	secretPaths [][]string // Config values resolved from secret references
+
+	mu       sync.RWMutex
+	statuses map[string]StatusFunc
+	toggles  map[string]Toggle
+}
+
+// New creates a new admin server
+func New(cfg *config.Config) (*Server, error) {
+	auth, err := newAuthenticator(cfg.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		auth:      auth,
+		mux:       http.NewServeMux(),
+		startedAt: time.Now(),
+		statuses:  make(map[string]StatusFunc),
+		toggles:   make(map[string]Toggle),
+	}
+
+	s.HandleFunc("/admin/health", s.healthHandler)
+	s.HandleFunc("/admin/whoami", s.whoamiHandler)
+	s.HandleFuncRole("/admin/config", RoleAnalyst, s.configHandler)
+	s.HandleFunc("/admin/state", s.stateHandler)
+	s.HandleFunc("/admin/state/", s.stateHandler)
+	s.HandleFunc("/admin/toggles", s.togglesHandler)
+	s.HandleFunc("/admin/toggles/", s.togglesHandler)
+	if cfg.Admin.Profiling.Enabled {
+		s.registerProfiling()
+	}
+
+	s.httpServer = &http.Server{
+		Addr:         cfg.Admin.Port,
+		Handler:      s.mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
+
+	return s, nil
+}
+
+// Handle registers an additional admin route. Any authenticated caller may
+// read it; requests that change state need the admin role.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.HandleRole(pattern, RoleReadOnly, handler)
+}
+
+// HandleFunc registers an additional admin route handler function
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// HandleRole registers a route whose reads need at least the given role, for
+// routes that expose request content
+func (s *Server) HandleRole(pattern string, role Role, handler http.Handler) {
+	s.mux.Handle(pattern, s.authenticate(role, handler))
+}
+
+// HandleFuncRole registers a handler function whose reads need at least the given role
+func (s *Server) HandleFuncRole(pattern string, role Role, handler func(http.ResponseWriter, *http.Request)) {
+	s.HandleRole(pattern, role, http.HandlerFunc(handler))
+}
+
+// HandlePublic registers a route that does not require the admin token.
+// Only use this for static assets that carry no gateway data.
+func (s *Server) HandlePublic(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddStatus exposes a subsystem's state under /admin/state/{name}
+func (s *Server) AddStatus(name string, fn StatusFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[name] = fn
+}
+
+// AddToggle exposes a runtime switch under /admin/toggles/{name}
+func (s *Server) AddToggle(name string, toggle Toggle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.toggles[name] = toggle
+}
+
+// Start begins serving the admin API in the background
+func (s *Server) Start() {
+	go func() {
+		log.Printf("🔐 Admin API listening on %s", s.httpServer.Addr)
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server failed: %v", err)
+		}
+	}()
+}
+
+// Shutdown gracefully stops the admin listener
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// authenticate identifies the caller from a bearer token or X-Admin-Token
+// header, checks their role allows the request and records it in the audit log
+func (s *Server) authenticate(read Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.auth.identify(r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		s.audited(w, r, principal, func(w http.ResponseWriter, r *http.Request) {
+			if required := requiredRole(r, read); principal.Role < required {
+				log.Printf("[ADMIN] Denied %s %s to %s (%s, needs %s)", r.Method, r.URL.Path, principal.Name, principal.Role, required)
+				WriteError(w, http.StatusForbidden, fmt.Sprintf("role %s cannot %s %s", principal.Role, r.Method, r.URL.Path))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, principal)))
+		})
+	})
+}
+
+// whoamiHandler reports the caller's identity and role
+func (s *Server) whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	principal, _ := PrincipalFromContext(r.Context())
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"name":   principal.Name,
+		"role":   principal.Role.String(),
+		"method": principal.Method,
+	})
+}
+
+// healthHandler reports admin listener liveness
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "healthy",
+		"uptime_seconds": int64(time.Since(s.startedAt).Seconds()),
+	})
+}
+
+// configHandler dumps the effective configuration with secrets redacted
+func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	dump, err := RedactedConfig(s.cfg, s.secretPaths)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Sprintf("failed to render config: %v", err))
+		return
+	}
+	WriteJSON(w, http.StatusOK, dump)
+}
+
+// stateHandler returns all registered subsystem states, or one by name
+func (s *Server) stateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/state"), "/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if name != "" {
+		fn, ok := s.statuses[name]
+		if !ok {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("unknown state %s", name))
+			return
+		}
+		WriteJSON(w, http.StatusOK, fn())
+		return
+	}
+
+	state := make(map[string]interface{}, len(s.statuses))
+	for key, fn := range s.statuses {
+		state[key] = fn()
+	}
+	WriteJSON(w, http.StatusOK, state)
+}
+
+// togglesHandler lists toggles on GET and flips one on POST/PUT with {"value": "..."}
+func (s *Server) togglesHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/toggles"), "/")
+
+	s.mu.RLock()
+	toggle, exists := s.toggles[name]
+	names := make([]string, 0, len(s.toggles))
+	for key := range s.toggles {
+		names = append(names, key)
+	}
+	s.mu.RUnlock()
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sort.Strings(names)
+		values := make(map[string]interface{}, len(names))
+		s.mu.RLock()
+		for _, key := range names {
+			values[key] = s.toggles[key].Get()
+		}
+		s.mu.RUnlock()
+		WriteJSON(w, http.StatusOK, values)
+		return
+	}
+
+	if !exists {
+		WriteError(w, http.StatusNotFound, fmt.Sprintf("unknown toggle %s", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"name": name, "value": toggle.Get()})
+	case http.MethodPost, http.MethodPut:
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			WriteError(w, http.StatusBadRequest, "request body must be {\"value\": \"...\"}")
+			return
+		}
+		before := toggle.Get()
+		if err := toggle.Set(body.Value); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("[ADMIN] Toggle %s set to %q", name, body.Value)
+		audit.Record(r.Context(), "toggle.set", name, before, toggle.Get())
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"name": name, "value": toggle.Get()})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// sensitiveKeys are config keys whose values are never dumped. Webhook URLs
+// carry their own credentials, as Slack's do.
+var sensitiveKeys = []string{"password", "secret", "token", "api_key", "apikey", "private_key", "credential", "webhook", "slack"}
+
+// SetSecretPaths marks config values that were resolved from secret
+// references, given as paths of YAML keys, so the config dump masks them
+// whatever their keys are called
+func (s *Server) SetSecretPaths(paths [][]string) {
+	s.secretPaths = paths
+}
+
+// RedactedConfig renders the configuration as a generic map with secrets
+// masked, including the values at secretPaths
+func RedactedConfig(cfg *config.Config, secretPaths [][]string) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var dump map[string]interface{}
+	if err := yaml.Unmarshal(data, &dump); err != nil {
+		return nil, err
+	}
+
+	for _, path := range secretPaths {
+		redactPath(dump, path)
+	}
+	return redactValue("", dump).(map[string]interface{}), nil
+}
+
+// redactPath masks the value at path, a list of map keys and list indexes
+func redactPath(value interface{}, path []string) {
+	for i, key := range path {
+		last := i == len(path)-1
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			if _, ok := typed[key]; !ok {
+				return
+			}
+			if last {
+				typed[key] = "[REDACTED]"
+				return
+			}
+			value = typed[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(typed) {
+				return
+			}
+			if last {
+				typed[index] = "[REDACTED]"
+				return
+			}
+			value = typed[index]
+		default:
+			return
+		}
+	}
+}
+
+// redactValue walks a decoded config tree masking sensitive keys, header
+// values and URL credentials
+func redactValue(key string, value interface{}) interface{} {
+	lowerKey := strings.ToLower(key)
+
+	// Header maps carry keys under any name, such as Authorization or x-api-key
+	if headers, ok := value.(map[string]interface{}); ok && lowerKey == "headers" {
+		for name, v := range headers {
+			if str, ok := v.(string); !ok || str != "" {
+				headers[name] = "[REDACTED]"
+			}
+		}
+		return headers
+	}
+
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lowerKey, sensitive) {
+			if str, ok := value.(string); ok && str == "" {
+				return str
+			}
+			return "[REDACTED]"
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for k, v := range typed {
+			typed[k] = redactValue(k, v)
+		}
+		return typed
+	case []interface{}:
+		for i, v := range typed {
+			typed[i] = redactValue(key, v)
+		}
+		return typed
+	case string:
+		// Connection strings carry credentials in their userinfo
+		if parsed, err := url.Parse(typed); err == nil && parsed.User != nil {
+			if _, hasPassword := parsed.User.Password(); hasPassword {
+				parsed.User = url.UserPassword(parsed.User.Username(), "REDACTED")
+				return parsed.String()
+			}
+		}
+		return typed
+	default:
+		return typed
+	}
+}
+
+// WriteJSON writes a JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error encoding admin response: %v", err)
+	}
+}
+
+// WriteError writes a JSON error response
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"message": message,
+	})
+}
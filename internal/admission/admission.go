@@ -0,0 +1,288 @@
+package admission
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Errors returned when a request is not admitted
+var (
+	ErrQueueFull    = errors.New("admission queue full")
+	ErrShed         = errors.New("shed for higher priority traffic")
+	ErrQueueTimeout = errors.New("timed out waiting in admission queue")
+)
+
+// Controller limits how many requests are proxied at once. Requests beyond the
+// limit wait in a bounded queue ordered by priority; when the queue is full
+// the lowest-priority waiters are shed so higher-priority traffic gets through.
+type Controller struct {
+	mu              sync.Mutex
+	maxConcurrent   int
+	maxQueue        int
+	queueTimeout    time.Duration
+	defaultPriority int
+	keys            map[string]int
+
+	inFlight int
+	queue    waitQueue
+	seq      uint64
+
+	admitted uint64
+	queued   uint64
+	rejected uint64
+	shed     uint64
+	timedOut uint64
+}
+
+// New creates an admission controller from configuration
+func New(cfg config.AdmissionConfig) (*Controller, error) {
+	if cfg.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("max_concurrent must be positive")
+	}
+	if cfg.MaxQueue < 0 {
+		return nil, fmt.Errorf("max_queue must not be negative")
+	}
+
+	queueTimeout, err := time.ParseDuration(cfg.QueueTimeout)
+	if err != nil || queueTimeout <= 0 {
+		queueTimeout = 30 * time.Second
+	}
+
+	return &Controller{
+		maxConcurrent:   cfg.MaxConcurrent,
+		maxQueue:        cfg.MaxQueue,
+		queueTimeout:    queueTimeout,
+		defaultPriority: cfg.DefaultPriority,
+		keys:            cfg.Keys,
+	}, nil
+}
+
+// Priority returns the priority of the API key a request was sent with
+func (c *Controller) Priority(r *http.Request) int {
+	return keyPriority(r, c.keys, c.defaultPriority)
+}
+
+// KeyPriority ranks requests by API key as admission does, for layers that
+// need priorities without queueing requests
+func KeyPriority(cfg config.AdmissionConfig) func(*http.Request) int {
+	return func(r *http.Request) int {
+		return keyPriority(r, cfg.Keys, cfg.DefaultPriority)
+	}
+}
+
+// Priorities lists every priority the configuration assigns, unsorted
+func Priorities(cfg config.AdmissionConfig) []int {
+	priorities := []int{cfg.DefaultPriority}
+	for _, priority := range cfg.Keys {
+		priorities = append(priorities, priority)
+	}
+	return priorities
+}
+
+func keyPriority(r *http.Request, keys map[string]int, defaultPriority int) int {
+	key := r.Header.Get("x-api-key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if priority, ok := keys[key]; ok && key != "" {
+		return priority
+	}
+	return defaultPriority
+}
+
+// Acquire waits for a slot to proxy a request, returning a function that
+// releases it. Requests are rejected when the queue is full of equal or
+// higher priority work, shed when higher priority work displaces them, and
+// time out after the queue timeout.
+func (c *Controller) Acquire(ctx context.Context, priority int) (func(), error) {
+	c.mu.Lock()
+	if c.inFlight < c.maxConcurrent && c.queue.Len() == 0 {
+		c.inFlight++
+		c.admitted++
+		c.mu.Unlock()
+		return c.release, nil
+	}
+
+	if c.queue.Len() >= c.maxQueue {
+		lowest := c.queue.lowest()
+		if lowest == nil || lowest.priority >= priority {
+			c.rejected++
+			c.mu.Unlock()
+			return nil, ErrQueueFull
+		}
+		// Make room by shedding the newest of the lowest-priority waiters
+		heap.Remove(&c.queue, lowest.index)
+		lowest.shed = true
+		close(lowest.ready)
+		c.shed++
+	}
+
+	c.seq++
+	w := &waiter{priority: priority, seq: c.seq, ready: make(chan struct{})}
+	heap.Push(&c.queue, w)
+	c.queued++
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		if w.shed {
+			return nil, ErrShed
+		}
+		return c.release, nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted or shed while giving up
+		if !w.shed {
+			c.releaseLocked()
+		}
+	default:
+		heap.Remove(&c.queue, w.index)
+	}
+	if err == ErrQueueTimeout {
+		c.timedOut++
+	}
+	return nil, err
+}
+
+// release frees a slot, handing it straight to the highest-priority waiter
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+}
+
+func (c *Controller) releaseLocked() {
+	if c.queue.Len() == 0 {
+		c.inFlight--
+		return
+	}
+	w := heap.Pop(&c.queue).(*waiter)
+	c.admitted++
+	close(w.ready)
+}
+
+// Middleware queues requests for a slot before passing them on, answering 429
+// when they are not admitted
+func (c *Controller) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := c.Priority(r)
+		start := time.Now()
+
+		release, err := c.Acquire(r.Context(), priority)
+		waited := time.Since(start)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return // The client went away while queued
+			}
+			middleware.AddLogMetadata(r.Context(), "admission", map[string]interface{}{
+				"priority":  priority,
+				"queued_ms": waited.Milliseconds(),
+				"rejected":  err.Error(),
+			})
+			log.Printf("[ADMISSION] Rejected %s %s (priority %d): %v", r.Method, r.URL.Path, priority, err)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Gateway is at capacity, please retry", http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+
+		if waited >= time.Millisecond {
+			middleware.AddLogMetadata(r.Context(), "admission", map[string]interface{}{
+				"priority":  priority,
+				"queued_ms": waited.Milliseconds(),
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Status returns the controller state for status endpoints
+func (c *Controller) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"in_flight":      c.inFlight,
+		"max_concurrent": c.maxConcurrent,
+		"queued":         c.queue.Len(),
+		"max_queue":      c.maxQueue,
+		"admitted":       c.admitted,
+		"total_queued":   c.queued,
+		"rejected":       c.rejected,
+		"shed":           c.shed,
+		"timed_out":      c.timedOut,
+	}
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	priority int
+	seq      uint64 // Arrival order, so equal priorities are first come, first served
+	ready    chan struct{}
+	shed     bool
+	index    int
+}
+
+// waitQueue is a heap of waiters, highest priority and earliest arrival first
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
+
+// lowest returns the waiter that would be served last
+func (q waitQueue) lowest() *waiter {
+	var lowest *waiter
+	for _, w := range q {
+		if lowest == nil || w.priority < lowest.priority || (w.priority == lowest.priority && w.seq > lowest.seq) {
+			lowest = w
+		}
+	}
+	return lowest
+}
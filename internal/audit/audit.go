@@ -0,0 +1,136 @@
+// Package audit keeps an append-only record of admin API actions: who did
+// what, when, and what changed.
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// eventContextKey is the context key under which an admin request's event is stored
+const eventContextKey = "audit_event"
+
+// Event is one admin action
+type Event struct {
+	ID         int64                  `json:"id"`
+	Time       time.Time              `json:"time"`
+	Actor      string                 `json:"actor"`
+	AuthMethod string                 `json:"auth_method,omitempty"` // "token" or "oidc"
+	Role       string                 `json:"role,omitempty"`
+	Action     string                 `json:"action"` // e.g. "key.created"; "METHOD /path" when the handler names none
+	Target     string                 `json:"target,omitempty"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Status     int                    `json:"status"`
+	RemoteAddr string                 `json:"remote_addr,omitempty"`
+	Diff       *Diff                  `json:"diff,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// Diff is the state an action changed, before and after
+type Diff struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Filter selects events. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// matches reports whether an event passes the filter
+func (f Filter) matches(e *Event) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Log stores events. There is no way to change or remove one.
+type Log interface {
+	Append(ctx context.Context, event *Event) error
+	// Query returns matching events, newest first
+	Query(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+// Record names the action an admin request takes and what it changed. Reads
+// that are recorded, such as exports, call it too; other reads aren't logged.
+func Record(ctx context.Context, action, target string, before, after interface{}) {
+	event, ok := ctx.Value(eventContextKey).(*Event)
+	if !ok {
+		return
+	}
+	event.Action = action
+	event.Target = target
+	if before != nil || after != nil {
+		event.Diff = &Diff{Before: before, After: after}
+	}
+}
+
+// Detail attaches a field to an admin request's event, e.g. export filters
+func Detail(ctx context.Context, key string, value interface{}) {
+	event, ok := ctx.Value(eventContextKey).(*Event)
+	if !ok {
+		return
+	}
+	if event.Details == nil {
+		event.Details = make(map[string]interface{})
+	}
+	event.Details[key] = value
+}
+
+// WithEvent attaches the event being built for an admin request
+func WithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventContextKey, event)
+}
+
+// MemoryLog keeps the most recent events in memory, for gateways without
+// PostgreSQL storage. Events are lost on restart.
+type MemoryLog struct {
+	mu     sync.Mutex
+	events []Event
+	max    int
+	nextID int64
+}
+
+// NewMemoryLog creates a log holding up to max events
+func NewMemoryLog(max int) *MemoryLog {
+	if max <= 0 {
+		max = 10000
+	}
+	return &MemoryLog{max: max, nextID: 1}
+}
+
+func (l *MemoryLog) Append(ctx context.Context, event *Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event.ID = l.nextID
+	l.nextID++
+	l.events = append(l.events, *event)
+	if len(l.events) > l.max {
+		l.events = append([]Event(nil), l.events[len(l.events)-l.max:]...)
+	}
+	return nil
+}
+
+func (l *MemoryLog) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []Event
+	for i := len(l.events) - 1; i >= 0; i-- {
+		if filter.matches(&l.events[i]) {
+			events = append(events, l.events[i])
+			if filter.Limit > 0 && len(events) == filter.Limit {
+				break
+			}
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PostgresLog keeps events in the admin_audit_log table, where a trigger
+// rejects updates and deletes
+type PostgresLog struct {
+	db *sql.DB
+}
+
+// NewPostgresLog creates a log on the gateway's database
+func NewPostgresLog(db *sql.DB) *PostgresLog {
+	return &PostgresLog{db: db}
+}
+
+func (l *PostgresLog) Append(ctx context.Context, event *Event) error {
+	var diff, details []byte
+	var err error
+	if event.Diff != nil {
+		if diff, err = json.Marshal(event.Diff); err != nil {
+			return fmt.Errorf("failed to marshal audit diff: %w", err)
+		}
+	}
+	if event.Details != nil {
+		if details, err = json.Marshal(event.Details); err != nil {
+			return fmt.Errorf("failed to marshal audit details: %w", err)
+		}
+	}
+
+	err = l.db.QueryRowContext(ctx, `
+		INSERT INTO admin_audit_log (time, actor, auth_method, role, action, target, method, path, status, remote_addr, diff, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`,
+		event.Time, event.Actor, event.AuthMethod, event.Role, event.Action, event.Target,
+		event.Method, event.Path, event.Status, event.RemoteAddr, diff, details).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to append audit event: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLog) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		add("target = $%d", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		add("time >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("time < $%d", filter.Until)
+	}
+
+	query := `SELECT id, time, actor, auth_method, role, action, target, method, path, status, remote_addr, diff, details
+		FROM admin_audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var authMethod, role, target, method, path, remoteAddr sql.NullString
+		var status sql.NullInt64
+		var diff, details []byte
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &authMethod, &role, &e.Action, &target,
+			&method, &path, &status, &remoteAddr, &diff, &details); err != nil {
+			return nil, fmt.Errorf("failed to read audit event: %w", err)
+		}
+		e.AuthMethod, e.Role, e.Target = authMethod.String, role.String, target.String
+		e.Method, e.Path, e.RemoteAddr = method.String, path.String, remoteAddr.String
+		e.Status = int(status.Int64)
+		if len(diff) > 0 {
+			json.Unmarshal(diff, &e.Diff)
+		}
+		if len(details) > 0 {
+			json.Unmarshal(details, &e.Details)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
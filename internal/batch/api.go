@@ -0,0 +1,346 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/jwtauth"
+	"github.com/NamanArora/flash-gateway/internal/respond"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/vkeys"
+)
+
+// Paths the manager serves on the main listener
+const (
+	FilesPath   = "/v1/files"
+	BatchesPath = "/v1/batches"
+)
+
+// maxMetadata is the most metadata pairs a batch can carry
+const maxMetadata = 16
+
+// forwardSkippedHeaders are creator request headers batch requests don't carry
+var forwardSkippedHeaders = map[string]bool{
+	"content-length":    true,
+	"content-type":      true,
+	"accept-encoding":   true, // Results are stored uncompressed
+	"connection":        true,
+	"cookie":            true,
+	"host":              true,
+	"transfer-encoding": true,
+}
+
+// ServeHTTP serves the files and batches endpoints
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == FilesPath:
+		m.filesHandler(w, r)
+	case strings.HasPrefix(r.URL.Path, FilesPath+"/"):
+		m.fileHandler(w, r, strings.TrimPrefix(r.URL.Path, FilesPath+"/"))
+	case r.URL.Path == BatchesPath:
+		m.batchesHandler(w, r)
+	case strings.HasPrefix(r.URL.Path, BatchesPath+"/"):
+		m.batchHandler(w, r, strings.TrimPrefix(r.URL.Path, BatchesPath+"/"))
+	default:
+		respond.Error(w, http.StatusNotFound, "Not found", "")
+	}
+}
+
+// filesHandler serves POST /v1/files (multipart, with file and purpose) and GET /v1/files
+func (m *Manager) filesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		purpose := r.URL.Query().Get("purpose")
+		owner := ownerOf(r)
+		m.mu.Lock()
+		files := []File{}
+		for _, f := range m.files {
+			if f.Owner == owner && (purpose == "" || f.Purpose == purpose) {
+				files = append(files, f.File)
+			}
+		}
+		m.mu.Unlock()
+		sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt > files[j].CreatedAt })
+		respond.JSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": files, "has_more": false})
+	case http.MethodPost:
+		m.upload(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		respond.Error(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// upload stores a batch input file, streaming it to disk
+func (m *Manager) upload(w http.ResponseWriter, r *http.Request) {
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, m.maxFileSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respond.Error(w, http.StatusBadRequest, "Expected a multipart/form-data upload with file and purpose", "")
+		return
+	}
+
+	f := &fileRecord{
+		File:  File{ID: newID("file-"), Object: "file", CreatedAt: time.Now().Unix()},
+		Owner: ownerOf(r),
+	}
+	uploaded := false
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.Remove(m.store.contentPath(f.ID))
+			respond.Error(w, http.StatusBadRequest, "Invalid upload: "+err.Error(), "")
+			return
+		}
+		switch part.FormName() {
+		case "purpose":
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			f.Purpose = strings.TrimSpace(string(value))
+		case "file":
+			f.Filename = part.FileName()
+			f.Bytes, err = m.store.writeContent(f.ID, part, m.maxFileSize)
+			if err != nil {
+				respond.Error(w, http.StatusBadRequest, "Invalid upload: "+err.Error(), "")
+				return
+			}
+			if f.Bytes > m.maxFileSize {
+				respond.Error(w, http.StatusRequestEntityTooLarge, "File is larger than "+strconv.FormatInt(m.maxFileSize>>20, 10)+" MB", "")
+				return
+			}
+			uploaded = true
+		}
+		part.Close()
+	}
+	switch {
+	case !uploaded:
+		respond.Error(w, http.StatusBadRequest, "file is required", "")
+		return
+	case f.Purpose != PurposeBatch:
+		os.Remove(m.store.contentPath(f.ID))
+		respond.Error(w, http.StatusBadRequest, `Only purpose "batch" is supported`, "")
+		return
+	}
+
+	m.mu.Lock()
+	m.files[f.ID] = f
+	err = m.store.save(filesDir, f.ID, f)
+	m.mu.Unlock()
+	if err != nil {
+		log.Printf("[BATCH] %v", err)
+		respond.Error(w, http.StatusInternalServerError, "Failed to store file", "")
+		return
+	}
+	respond.JSON(w, http.StatusOK, f.File)
+}
+
+// fileHandler serves GET and DELETE /v1/files/{id} and GET /v1/files/{id}/content
+func (m *Manager) fileHandler(w http.ResponseWriter, r *http.Request, rest string) {
+	id, sub, _ := strings.Cut(rest, "/")
+	if sub != "" && sub != "content" {
+		respond.Error(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	m.mu.Lock()
+	f := m.files[id]
+	m.mu.Unlock()
+	if f == nil || f.Owner != ownerOf(r) {
+		respond.Error(w, http.StatusNotFound, "No such file: "+id, "")
+		return
+	}
+
+	switch {
+	case sub == "content" && r.Method == http.MethodGet:
+		content, err := os.Open(m.store.contentPath(id))
+		if err != nil {
+			respond.Error(w, http.StatusNotFound, "No such file: "+id, "")
+			return
+		}
+		defer content.Close()
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Length", strconv.FormatInt(f.Bytes, 10))
+		io.Copy(w, content)
+	case sub == "" && r.Method == http.MethodGet:
+		respond.JSON(w, http.StatusOK, f.File)
+	case sub == "" && r.Method == http.MethodDelete:
+		m.mu.Lock()
+		delete(m.files, id)
+		m.store.remove(filesDir, id)
+		m.mu.Unlock()
+		respond.JSON(w, http.StatusOK, map[string]interface{}{"id": id, "object": "file", "deleted": true})
+	default:
+		respond.Error(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// batchesHandler serves POST /v1/batches with {"input_file_id": "...",
+// "endpoint": "/v1/chat/completions", "completion_window": "24h"} and
+// GET /v1/batches?limit=20&after=batch_...
+func (m *Manager) batchesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		m.list(w, r)
+	case http.MethodPost:
+		m.start(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		respond.Error(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// start validates a batch request and starts the batch
+func (m *Manager) start(w http.ResponseWriter, r *http.Request) {
+	var params Batch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&params); err != nil {
+		respond.Error(w, http.StatusBadRequest, "Invalid batch: "+err.Error(), "")
+		return
+	}
+	owner := ownerOf(r)
+	m.mu.Lock()
+	f := m.files[params.InputFileID]
+	m.mu.Unlock()
+	switch {
+	case f == nil || f.Owner != owner:
+		respond.Error(w, http.StatusBadRequest, "No such file: "+params.InputFileID, "")
+		return
+	case f.Purpose != PurposeBatch:
+		respond.Error(w, http.StatusBadRequest, `input_file_id must be a file uploaded with purpose "batch"`, "")
+		return
+	case !Endpoints[params.Endpoint]:
+		respond.Error(w, http.StatusBadRequest, "Unsupported endpoint: "+params.Endpoint, "")
+		return
+	case params.CompletionWindow != completionWindow:
+		respond.Error(w, http.StatusBadRequest, `completion_window must be "24h"`, "")
+		return
+	case len(params.Metadata) > maxMetadata:
+		respond.Error(w, http.StatusBadRequest, "metadata can have at most 16 pairs", "")
+		return
+	}
+
+	// Batch requests run as the creator, with their tenant, identity and credentials
+	ctx := context.Background()
+	if t := tenant.FromContext(r.Context()); t != nil {
+		ctx = tenant.WithTenant(ctx, t)
+	}
+	if identity := jwtauth.FromContext(r.Context()); identity != nil {
+		ctx = jwtauth.WithIdentity(ctx, identity)
+	}
+	if key := vkeys.FromContext(r.Context()); key != nil {
+		ctx = vkeys.WithKey(ctx, key)
+	}
+	header := make(http.Header)
+	for name, values := range r.Header {
+		if !forwardSkippedHeaders[strings.ToLower(name)] {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+
+	respond.JSON(w, http.StatusOK, m.create(ctx, header, owner, params))
+}
+
+// list returns the caller's batches, newest first
+func (m *Manager) list(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			respond.Error(w, http.StatusBadRequest, "limit must be between 1 and 100", "")
+			return
+		}
+		limit = parsed
+	}
+	owner := ownerOf(r)
+	m.mu.Lock()
+	batches := []Batch{}
+	for _, b := range m.batches {
+		if b.Owner == owner {
+			batches = append(batches, b.Batch)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(batches, func(i, j int) bool {
+		if batches[i].CreatedAt != batches[j].CreatedAt {
+			return batches[i].CreatedAt > batches[j].CreatedAt
+		}
+		return batches[i].ID > batches[j].ID
+	})
+
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, b := range batches {
+			if b.ID == after {
+				batches = batches[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	response := map[string]interface{}{"object": "list", "data": batches, "has_more": hasMore}
+	if len(batches) > 0 {
+		response["first_id"] = batches[0].ID
+		response["last_id"] = batches[len(batches)-1].ID
+	}
+	respond.JSON(w, http.StatusOK, response)
+}
+
+// batchHandler serves GET /v1/batches/{id} and POST /v1/batches/{id}/cancel
+func (m *Manager) batchHandler(w http.ResponseWriter, r *http.Request, rest string) {
+	id, sub, _ := strings.Cut(rest, "/")
+	owner := ownerOf(r)
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		m.mu.Lock()
+		var batch *Batch
+		if b := m.batches[id]; b != nil && b.Owner == owner {
+			snapshot := b.Batch
+			batch = &snapshot
+		}
+		m.mu.Unlock()
+		if batch == nil {
+			respond.Error(w, http.StatusNotFound, "No such batch: "+id, "")
+			return
+		}
+		respond.JSON(w, http.StatusOK, batch)
+	case sub == "cancel" && r.Method == http.MethodPost:
+		batch, err := m.cancel(id, owner)
+		switch {
+		case err != nil:
+			respond.Error(w, http.StatusConflict, err.Error(), "")
+		case batch == nil:
+			respond.Error(w, http.StatusNotFound, "No such batch: "+id, "")
+		default:
+			respond.JSON(w, http.StatusOK, batch)
+		}
+	case sub == "" || sub == "cancel":
+		respond.Error(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	default:
+		respond.Error(w, http.StatusNotFound, "Not found", "")
+	}
+}
+
+// ownerOf identifies who a file or batch belongs to: the fingerprint of the
+// caller's API key, or of their JWT subject. Subjects are hashed after a NUL
+// byte, which header values can't carry, so no API key can pass for one.
+func ownerOf(r *http.Request) string {
+	if identity := jwtauth.FromContext(r.Context()); identity != nil {
+		return storage.APIKeyID("\x00jwt:" + identity.Subject)
+	}
+	key := r.Header.Get("x-api-key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return storage.APIKeyID(key)
+}
@@ -0,0 +1,721 @@
+// Package batch implements the OpenAI Batch API: clients upload a JSONL file
+// of requests to /v1/files, start a batch of them on /v1/batches, and collect
+// the results as another file once it finishes. Batch requests are sent
+// through the gateway like live traffic, so guardrails, routing and logging
+// apply to each one, but only a fixed number run at a time across all batches.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// Batch statuses, as the Batch API reports them
+const (
+	StatusValidating = "validating"
+	StatusFailed     = "failed"
+	StatusInProgress = "in_progress"
+	StatusFinalizing = "finalizing"
+	StatusCompleted  = "completed"
+	StatusExpired    = "expired"
+	StatusCancelling = "cancelling"
+	StatusCancelled  = "cancelled"
+)
+
+// File purposes
+const (
+	PurposeBatch       = "batch"        // Uploaded batch input
+	PurposeBatchOutput = "batch_output" // Results and errors written by a batch
+)
+
+// completionWindow is the only completion window the Batch API offers
+const completionWindow = "24h"
+
+// maxErrors caps the validation errors reported for one input file
+const maxErrors = 100
+
+// Endpoints batches can target
+var Endpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+	"/v1/moderations":      true,
+}
+
+// File is an uploaded batch input or a batch's results
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// Batch is a group of requests processed asynchronously
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *Errors           `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     *string           `json:"output_file_id"`
+	ErrorFileID      *string           `json:"error_file_id"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     *int64            `json:"in_progress_at"`
+	ExpiresAt        int64             `json:"expires_at"`
+	FinalizingAt     *int64            `json:"finalizing_at"`
+	CompletedAt      *int64            `json:"completed_at"`
+	FailedAt         *int64            `json:"failed_at"`
+	ExpiredAt        *int64            `json:"expired_at"`
+	CancellingAt     *int64            `json:"cancelling_at"`
+	CancelledAt      *int64            `json:"cancelled_at"`
+	RequestCounts    RequestCounts     `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+// RequestCounts tracks a batch's progress
+type RequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Errors lists why a batch failed
+type Errors struct {
+	Object string  `json:"object"`
+	Data   []Error `json:"data"`
+}
+
+// Error is a problem with a batch, or with one of its requests
+type Error struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Param   *string `json:"param,omitempty"`
+	Line    *int    `json:"line,omitempty"`
+}
+
+// finished reports whether a batch will not change again
+func (b *Batch) finished() bool {
+	switch b.Status {
+	case StatusCompleted, StatusFailed, StatusExpired, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// fileRecord is a file and who may read it
+type fileRecord struct {
+	File
+	Owner string `json:"owner"`
+}
+
+// batchRecord is a batch, who may read it, and where its results go while it runs
+type batchRecord struct {
+	Batch
+	Owner      string `json:"owner"`
+	OutputFile string `json:"output_file,omitempty"`
+	ErrorFile  string `json:"error_file,omitempty"`
+}
+
+// request is one line of an input file
+type request struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// resultLine is one line of an output or error file
+type resultLine struct {
+	ID       string          `json:"id"`
+	CustomID string          `json:"custom_id"`
+	Response *resultResponse `json:"response"`
+	Error    *Error          `json:"error"`
+}
+
+// resultResponse is the gateway's response to one batch request
+type resultResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// job is a batch this process is working on. Its context carries the
+// creator's tenant and identity, and header their credentials, which are
+// never written to disk.
+type job struct {
+	record *batchRecord
+	ctx    context.Context
+	header http.Header
+	cancel chan struct{}
+	once   sync.Once
+}
+
+// Manager stores batch files and runs batches
+type Manager struct {
+	store       *store
+	handler     http.Handler
+	slots       chan struct{} // One per batch request in flight
+	maxFileSize int64
+	maxRequests int
+	retention   time.Duration
+
+	mu      sync.Mutex
+	files   map[string]*fileRecord
+	batches map[string]*batchRecord
+	jobs    map[string]*job
+	saved   map[string]time.Time // When each running batch's progress was last saved
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a batch manager that sends batch requests to handler. Batches
+// that were running when the gateway last stopped are marked failed, keeping
+// the results they had produced.
+func New(cfg config.BatchesConfig, handler http.Handler) (*Manager, error) {
+	retention, err := time.ParseDuration(cfg.Retention)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batches retention: %w", err)
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	maxFileSizeMB := cfg.MaxFileSizeMB
+	if maxFileSizeMB <= 0 {
+		maxFileSizeMB = 100
+	}
+	maxRequests := cfg.MaxRequests
+	if maxRequests <= 0 {
+		maxRequests = 50000
+	}
+	s, err := newStore(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		store:       s,
+		handler:     handler,
+		slots:       make(chan struct{}, concurrency),
+		maxFileSize: int64(maxFileSizeMB) << 20,
+		maxRequests: maxRequests,
+		retention:   retention,
+		files:       make(map[string]*fileRecord),
+		batches:     make(map[string]*batchRecord),
+		jobs:        make(map[string]*job),
+		saved:       make(map[string]time.Time),
+		closing:     make(chan struct{}),
+	}
+	err = s.load(filesDir, func(data []byte) error {
+		var f fileRecord
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		m.files[f.ID] = &f
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch files: %w", err)
+	}
+	err = s.load(batchesDir, func(data []byte) error {
+		var b batchRecord
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		m.batches[b.ID] = &b
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batches: %w", err)
+	}
+
+	for _, b := range m.batches {
+		if b.finished() {
+			continue
+		}
+		log.Printf("[BATCH] %s was interrupted by a restart, marking it failed", b.ID)
+		m.registerResults(b)
+		m.fail(b, Error{Code: "interrupted", Message: "The gateway restarted while this batch was running"})
+	}
+
+	m.wg.Add(1)
+	go m.cleanupLoop()
+	return m, nil
+}
+
+// Close stops starting batch requests and waits for those in flight. Batches
+// left unfinished are marked failed when the gateway starts again.
+func (m *Manager) Close() {
+	close(m.closing)
+	m.wg.Wait()
+}
+
+// create starts a batch of the requests in an uploaded file
+func (m *Manager) create(ctx context.Context, header http.Header, owner string, b Batch) *Batch {
+	now := time.Now().Unix()
+	record := &batchRecord{
+		Batch: Batch{
+			ID:               newID("batch_"),
+			Object:           "batch",
+			Endpoint:         b.Endpoint,
+			InputFileID:      b.InputFileID,
+			CompletionWindow: completionWindow,
+			Status:           StatusValidating,
+			CreatedAt:        now,
+			ExpiresAt:        now + int64(24*time.Hour/time.Second),
+			Metadata:         b.Metadata,
+		},
+		Owner: owner,
+	}
+	j := &job{record: record, ctx: ctx, header: header, cancel: make(chan struct{})}
+
+	m.mu.Lock()
+	m.batches[record.ID] = record
+	m.jobs[record.ID] = j
+	m.save(record)
+	snapshot := record.Batch
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.run(j)
+	return &snapshot
+}
+
+// cancel stops a batch from starting more requests. Those in flight finish
+// and their results are kept.
+func (m *Manager) cancel(id, owner string) (*Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.batches[id]
+	if b == nil || b.Owner != owner {
+		return nil, nil
+	}
+	if b.Status != StatusValidating && b.Status != StatusInProgress {
+		return nil, fmt.Errorf("cannot cancel a batch that is %s", b.Status)
+	}
+	b.Status = StatusCancelling
+	b.CancellingAt = timestamp()
+	m.save(b)
+	if j := m.jobs[id]; j != nil {
+		j.once.Do(func() { close(j.cancel) })
+	}
+	snapshot := b.Batch
+	return &snapshot, nil
+}
+
+// run validates a batch's input and sends its requests
+func (m *Manager) run(j *job) {
+	defer m.wg.Done()
+	b := j.record
+	defer func() {
+		m.mu.Lock()
+		delete(m.jobs, b.ID)
+		delete(m.saved, b.ID)
+		m.mu.Unlock()
+	}()
+
+	requests, errs := m.readInput(b.InputFileID, b.Endpoint)
+	if len(errs) > 0 {
+		m.mu.Lock()
+		m.fail(b, errs...)
+		m.mu.Unlock()
+		return
+	}
+
+	m.mu.Lock()
+	b.RequestCounts.Total = len(requests)
+	if b.Status == StatusCancelling {
+		m.finish(b, StatusCancelled)
+		m.mu.Unlock()
+		return
+	}
+	b.Status = StatusInProgress
+	b.InProgressAt = timestamp()
+	b.OutputFile = newID("file-")
+	b.ErrorFile = newID("file-")
+	m.save(b)
+	m.mu.Unlock()
+
+	results, err := newResultWriter(m.store, b.OutputFile, b.ErrorFile)
+	if err != nil {
+		log.Printf("[BATCH] %s: %v", b.ID, err)
+		m.mu.Lock()
+		m.fail(b, Error{Code: "internal_error", Message: "Failed to create result files"})
+		m.mu.Unlock()
+		return
+	}
+
+	// Stop starting requests when the batch is cancelled, expires or the gateway stops
+	expiry := time.NewTimer(time.Until(time.Unix(b.ExpiresAt, 0)))
+	defer expiry.Stop()
+	var inFlight sync.WaitGroup
+	dispatched, stopped := 0, ""
+dispatch:
+	for _, req := range requests {
+		select {
+		case m.slots <- struct{}{}:
+		case <-j.cancel:
+			stopped = StatusCancelled
+			break dispatch
+		case <-expiry.C:
+			stopped = StatusExpired
+			break dispatch
+		case <-m.closing:
+			stopped = "closing"
+			break dispatch
+		}
+		dispatched++
+		inFlight.Add(1)
+		go func(req request) {
+			defer inFlight.Done()
+			defer func() { <-m.slots }()
+			line := m.send(j, req)
+			results.write(line)
+			m.progress(b, line.Error == nil)
+		}(req)
+	}
+	inFlight.Wait()
+
+	if stopped == "closing" {
+		results.close()
+		m.mu.Lock()
+		m.save(b)
+		m.mu.Unlock()
+		return
+	}
+
+	// Requests never sent are reported in the error file
+	code, message := "batch_cancelled", "This request was not executed because the batch was cancelled."
+	if stopped == StatusExpired {
+		code, message = "batch_expired", "This request could not be executed before the completion window expired."
+	}
+	for _, req := range requests[dispatched:] {
+		results.write(resultLine{
+			ID:       newID("batch_req_"),
+			CustomID: req.CustomID,
+			Error:    &Error{Code: code, Message: message},
+		})
+	}
+
+	m.mu.Lock()
+	b.Status = StatusFinalizing
+	b.FinalizingAt = timestamp()
+	b.RequestCounts.Failed += len(requests) - dispatched
+	m.save(b)
+	m.mu.Unlock()
+
+	results.close()
+	m.mu.Lock()
+	m.registerResults(b)
+	switch {
+	case stopped != "":
+		m.finish(b, stopped)
+	case b.CancellingAt != nil:
+		// Cancelled after the last request was sent
+		m.finish(b, StatusCancelled)
+	default:
+		m.finish(b, StatusCompleted)
+	}
+	m.mu.Unlock()
+}
+
+// send makes one batch request through the gateway
+func (m *Manager) send(j *job, req request) resultLine {
+	line := resultLine{ID: newID("batch_req_"), CustomID: req.CustomID}
+	ctx := middleware.WithLogMetadata(j.ctx, map[string]interface{}{
+		"batch": map[string]interface{}{
+			"id":        j.record.ID,
+			"custom_id": req.CustomID,
+		},
+	})
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		line.Error = &Error{Code: "internal_error", Message: err.Error()}
+		return line
+	}
+	httpReq.Header = j.header.Clone()
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	recorder := middleware.NewRecorder()
+	m.handler.ServeHTTP(recorder, httpReq)
+
+	body := recorder.Body()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	line.Response = &resultResponse{
+		StatusCode: recorder.Status(),
+		RequestID:  recorder.Header().Get("X-Flash-Request-ID"),
+		Body:       body,
+	}
+	if line.Response.StatusCode < 200 || line.Response.StatusCode >= 300 {
+		line.Error = &Error{Code: "request_failed", Message: fmt.Sprintf("Request failed with status %d", line.Response.StatusCode)}
+	}
+	return line
+}
+
+// progress counts a finished request, saving the counts at most once a second
+func (m *Manager) progress(b *batchRecord, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok {
+		b.RequestCounts.Completed++
+	} else {
+		b.RequestCounts.Failed++
+	}
+	if time.Since(m.saved[b.ID]) >= time.Second {
+		m.save(b)
+	}
+}
+
+// readInput parses and checks a batch's input file
+func (m *Manager) readInput(fileID, endpoint string) ([]request, []Error) {
+	f, err := os.Open(m.store.contentPath(fileID))
+	if err != nil {
+		return nil, []Error{{Code: "invalid_file", Message: "The input file could not be read"}}
+	}
+	defer f.Close()
+
+	var requests []request
+	var errs []Error
+	seen := make(map[string]bool)
+	reader := bufio.NewReader(f)
+	for number := 1; len(errs) < maxErrors; number++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, []Error{{Code: "invalid_file", Message: "The input file could not be read"}}
+		}
+		if line := bytes.TrimSpace(data); len(line) > 0 {
+			req, problem := parseRequest(line, endpoint, seen)
+			switch {
+			case problem != nil:
+				problem.Line = intPtr(number)
+				errs = append(errs, *problem)
+			case len(requests) == m.maxRequests:
+				return nil, []Error{{Code: "too_many_requests", Message: fmt.Sprintf("A batch can have at most %d requests", m.maxRequests)}}
+			default:
+				requests = append(requests, req)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(errs) == 0 && len(requests) == 0 {
+		errs = append(errs, Error{Code: "empty_file", Message: "The input file has no requests"})
+	}
+	return requests, errs
+}
+
+// parseRequest parses one input line
+func parseRequest(line []byte, endpoint string, seen map[string]bool) (request, *Error) {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return req, &Error{Code: "invalid_json_line", Message: "This line is not valid JSON"}
+	}
+	switch {
+	case req.CustomID == "":
+		return req, &Error{Code: "missing_required_parameter", Message: "custom_id is required", Param: stringPtr("custom_id")}
+	case seen[req.CustomID]:
+		return req, &Error{Code: "duplicate_custom_id", Message: fmt.Sprintf("custom_id %q is used more than once", req.CustomID), Param: stringPtr("custom_id")}
+	case !strings.EqualFold(req.Method, http.MethodPost):
+		return req, &Error{Code: "invalid_method", Message: "method must be POST", Param: stringPtr("method")}
+	case req.URL != endpoint:
+		return req, &Error{Code: "mismatched_endpoint", Message: fmt.Sprintf("url must be the batch endpoint %s", endpoint), Param: stringPtr("url")}
+	case len(req.Body) == 0 || req.Body[0] != '{':
+		return req, &Error{Code: "invalid_body", Message: "body must be a JSON object", Param: stringPtr("body")}
+	}
+	seen[req.CustomID] = true
+	return req, nil
+}
+
+// registerResults makes a batch's non-empty result files readable. Callers hold m.mu.
+func (m *Manager) registerResults(b *batchRecord) {
+	register := func(id, name string) *string {
+		if id == "" {
+			return nil
+		}
+		info, err := os.Stat(m.store.contentPath(id))
+		if err != nil || info.Size() == 0 {
+			os.Remove(m.store.contentPath(id))
+			return nil
+		}
+		f := &fileRecord{
+			File: File{
+				ID:        id,
+				Object:    "file",
+				Bytes:     info.Size(),
+				CreatedAt: time.Now().Unix(),
+				Filename:  name,
+				Purpose:   PurposeBatchOutput,
+			},
+			Owner: b.Owner,
+		}
+		m.files[id] = f
+		if err := m.store.save(filesDir, id, f); err != nil {
+			log.Printf("[BATCH] Failed to save file %s: %v", id, err)
+		}
+		return &f.ID
+	}
+	b.OutputFileID = register(b.OutputFile, b.ID+"_output.jsonl")
+	b.ErrorFileID = register(b.ErrorFile, b.ID+"_error.jsonl")
+}
+
+// fail ends a batch with errors. Callers hold m.mu.
+func (m *Manager) fail(b *batchRecord, errs ...Error) {
+	b.Errors = &Errors{Object: "list", Data: errs}
+	m.finish(b, StatusFailed)
+}
+
+// finish moves a batch to a final status. Callers hold m.mu.
+func (m *Manager) finish(b *batchRecord, status string) {
+	b.Status = status
+	switch status {
+	case StatusCompleted:
+		b.CompletedAt = timestamp()
+	case StatusFailed:
+		b.FailedAt = timestamp()
+	case StatusExpired:
+		b.ExpiredAt = timestamp()
+	case StatusCancelled:
+		b.CancelledAt = timestamp()
+	}
+	m.save(b)
+	log.Printf("[BATCH] %s %s (%d completed, %d failed)", b.ID, status, b.RequestCounts.Completed, b.RequestCounts.Failed)
+}
+
+// save persists a batch. Callers hold m.mu.
+func (m *Manager) save(b *batchRecord) {
+	if err := m.store.save(batchesDir, b.ID, b); err != nil {
+		log.Printf("[BATCH] Failed to save %s: %v", b.ID, err)
+	}
+	m.saved[b.ID] = time.Now()
+}
+
+// cleanupLoop deletes files and finished batches past the retention period
+func (m *Manager) cleanupLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		m.cleanup()
+		select {
+		case <-ticker.C:
+		case <-m.closing:
+			return
+		}
+	}
+}
+
+// cleanup deletes files and finished batches created before the retention
+// period. Input files of running batches are kept.
+func (m *Manager) cleanup() {
+	cutoff := time.Now().Add(-m.retention).Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inUse := make(map[string]bool)
+	for id, b := range m.batches {
+		switch {
+		case !b.finished():
+			inUse[b.InputFileID] = true
+		case b.CreatedAt < cutoff:
+			delete(m.batches, id)
+			m.store.remove(batchesDir, id)
+		}
+	}
+	for id, f := range m.files {
+		if f.CreatedAt < cutoff && !inUse[id] {
+			delete(m.files, id)
+			m.store.remove(filesDir, id)
+		}
+	}
+}
+
+// resultWriter appends result lines to a batch's output and error files
+type resultWriter struct {
+	mu           sync.Mutex
+	output, errs *os.File
+}
+
+// newResultWriter creates a batch's result files
+func newResultWriter(s *store, outputID, errorID string) (*resultWriter, error) {
+	output, err := os.Create(s.contentPath(outputID))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := os.Create(s.contentPath(errorID))
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	return &resultWriter{output: output, errs: errs}, nil
+}
+
+// write appends a line to the error file if the request failed, or the output file
+func (w *resultWriter) write(line resultLine) {
+	data, err := json.Marshal(line)
+	if err != nil {
+		log.Printf("[BATCH] Failed to marshal result %s: %v", line.CustomID, err)
+		return
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	target := w.output
+	if line.Error != nil {
+		target = w.errs
+	}
+	if _, err := target.Write(data); err != nil {
+		log.Printf("[BATCH] Failed to write result %s: %v", line.CustomID, err)
+	}
+}
+
+// close flushes the result files
+func (w *resultWriter) close() {
+	for _, f := range []*os.File{w.output, w.errs} {
+		if err := f.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Printf("[BATCH] Failed to close %s: %v", f.Name(), err)
+		}
+	}
+}
+
+// newID returns a random ID with a Batch API style prefix
+func newID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+func timestamp() *int64 {
+	now := time.Now().Unix()
+	return &now
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,95 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Directories under the configured batches directory
+const (
+	filesDir   = "files"   // <id>.json metadata and <id>.jsonl content
+	batchesDir = "batches" // <id>.json batch state
+)
+
+// store keeps uploaded files, results and batch state on disk, so finished
+// batches and their results survive restarts
+type store struct {
+	dir string
+}
+
+// newStore creates the store's directories
+func newStore(dir string) (*store, error) {
+	for _, sub := range []string{filesDir, batchesDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create batches directory: %w", err)
+		}
+	}
+	return &store{dir: dir}, nil
+}
+
+// contentPath returns where a file's content is kept
+func (s *store) contentPath(id string) string {
+	return filepath.Join(s.dir, filesDir, id+".jsonl")
+}
+
+// save writes a record atomically, so a crash never leaves half of one
+func (s *store) save(kind, id string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", id, err)
+	}
+	path := filepath.Join(s.dir, kind, id+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", id, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", id, err)
+	}
+	return nil
+}
+
+// load calls fn with every record of a kind
+func (s *store) load(kind string, fn func(data []byte) error) error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, kind, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return fmt.Errorf("invalid record %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// remove deletes a record, and a file's content
+func (s *store) remove(kind, id string) {
+	os.Remove(filepath.Join(s.dir, kind, id+".json"))
+	if kind == filesDir {
+		os.Remove(s.contentPath(id))
+	}
+}
+
+// writeContent stores up to limit bytes of a file's content and returns how
+// many bytes were read, which is more than limit when the content was too large
+func (s *store) writeContent(id string, r io.Reader, limit int64) (int64, error) {
+	f, err := os.Create(s.contentPath(id))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || n > limit {
+		os.Remove(s.contentPath(id))
+	}
+	return n, err
+}
@@ -0,0 +1,273 @@
+package brownout
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Features that can be shed while the gateway is browned out. Guardrails are
+// not among them: shedding them would return unchecked model output exactly
+// when the gateway is busiest.
+const (
+	FeatureBodyLogging  = "body_logging"  // Log request metadata only, no bodies
+	FeatureCostTracking = "cost_tracking" // Skip usage parsing and cost breakdowns
+)
+
+// Mode controls how brownout is activated
+type Mode string
+
+const (
+	ModeAuto Mode = "auto" // Activated and cleared by load thresholds
+	ModeOn   Mode = "on"   // Forced on by an operator
+	ModeOff  Mode = "off"  // Forced off by an operator
+)
+
+// PressureFunc reports utilization of a resource in the range [0, 1]
+type PressureFunc func() float64
+
+// Controller tracks gateway load and decides which optional features are shed
+type Controller struct {
+	mu             sync.RWMutex
+	mode           Mode
+	autoActive     bool
+	activatedAt    time.Time
+	calmSince      time.Time
+	features       map[string]bool
+	pressure       map[string]PressureFunc
+	maxInFlight    int64
+	queueThreshold float64
+	recoverRatio   float64
+	recoveryPeriod time.Duration
+	checkInterval  time.Duration
+
+	inFlight int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a brownout controller from configuration
+func New(cfg config.BrownoutConfig) (*Controller, error) {
+	mode := Mode(cfg.Mode)
+	if mode == "" {
+		mode = ModeAuto
+	}
+	if mode != ModeAuto && mode != ModeOn && mode != ModeOff {
+		return nil, fmt.Errorf("unknown brownout mode: %s", cfg.Mode)
+	}
+
+	checkInterval, err := time.ParseDuration(cfg.CheckInterval)
+	if err != nil || checkInterval <= 0 {
+		checkInterval = time.Second
+	}
+	recoveryPeriod, err := time.ParseDuration(cfg.RecoveryPeriod)
+	if err != nil || recoveryPeriod < 0 {
+		recoveryPeriod = 30 * time.Second
+	}
+
+	queueThreshold := cfg.QueueThreshold
+	if queueThreshold <= 0 || queueThreshold > 1 {
+		queueThreshold = 0.8
+	}
+	recoverRatio := cfg.RecoverRatio
+	if recoverRatio <= 0 || recoverRatio >= 1 {
+		recoverRatio = 0.5
+	}
+
+	features := make(map[string]bool)
+	for _, feature := range cfg.Features {
+		switch feature {
+		case FeatureBodyLogging, FeatureCostTracking:
+			features[feature] = true
+		default:
+			return nil, fmt.Errorf("unknown brownout feature: %s", feature)
+		}
+	}
+
+	return &Controller{
+		mode:           mode,
+		features:       features,
+		pressure:       make(map[string]PressureFunc),
+		maxInFlight:    int64(cfg.MaxInFlight),
+		queueThreshold: queueThreshold,
+		recoverRatio:   recoverRatio,
+		recoveryPeriod: recoveryPeriod,
+		checkInterval:  checkInterval,
+		stop:           make(chan struct{}),
+	}, nil
+}
+
+// AddPressureSource registers a resource whose utilization can trigger brownout
+func (c *Controller) AddPressureSource(name string, fn PressureFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pressure[name] = fn
+}
+
+// Track is a middleware that counts in-flight requests for load evaluation
+func (c *Controller) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&c.inFlight, 1)
+		defer atomic.AddInt64(&c.inFlight, -1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start begins periodic load evaluation
+func (c *Controller) Start() {
+	go func() {
+		ticker := time.NewTicker(c.checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.evaluate(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop ends load evaluation
+func (c *Controller) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// evaluate enters brownout when any signal crosses its threshold and leaves it
+// once every signal has stayed below the recovery level for the recovery period
+func (c *Controller) evaluate(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inFlight := atomic.LoadInt64(&c.inFlight)
+	overloaded, calm := false, true
+
+	if c.maxInFlight > 0 {
+		if inFlight >= c.maxInFlight {
+			overloaded = true
+		}
+		if float64(inFlight) > float64(c.maxInFlight)*c.recoverRatio {
+			calm = false
+		}
+	}
+	for _, fn := range c.pressure {
+		utilization := fn()
+		if utilization >= c.queueThreshold {
+			overloaded = true
+		}
+		if utilization > c.queueThreshold*c.recoverRatio {
+			calm = false
+		}
+	}
+
+	switch {
+	case overloaded:
+		c.calmSince = time.Time{}
+		if !c.autoActive {
+			c.autoActive = true
+			c.activatedAt = now
+			log.Printf("[BROWNOUT] Entering brownout (in-flight: %d)", inFlight)
+		}
+	case c.autoActive && calm:
+		if c.calmSince.IsZero() {
+			c.calmSince = now
+		}
+		if now.Sub(c.calmSince) >= c.recoveryPeriod {
+			c.autoActive = false
+			c.calmSince = time.Time{}
+			log.Printf("[BROWNOUT] Load subsided, restoring features after %v", now.Sub(c.activatedAt).Round(time.Second))
+		}
+	case !calm:
+		c.calmSince = time.Time{}
+	}
+}
+
+// SetMode changes the brownout mode at runtime
+func (c *Controller) SetMode(mode Mode) error {
+	if mode != ModeAuto && mode != ModeOn && mode != ModeOff {
+		return fmt.Errorf("unknown brownout mode: %s", mode)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode != mode {
+		log.Printf("[BROWNOUT] Mode changed from %s to %s", c.mode, mode)
+	}
+	c.mode = mode
+	return nil
+}
+
+// Mode returns the current brownout mode
+func (c *Controller) Mode() Mode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mode
+}
+
+// Active reports whether the gateway is currently browned out
+func (c *Controller) Active() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.activeLocked()
+}
+
+func (c *Controller) activeLocked() bool {
+	switch c.mode {
+	case ModeOn:
+		return true
+	case ModeOff:
+		return false
+	default:
+		return c.autoActive
+	}
+}
+
+// Disabled reports whether a feature is currently shed
+func (c *Controller) Disabled(feature string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.features[feature] && c.activeLocked()
+}
+
+// Status returns the controller state for status endpoints
+func (c *Controller) Status() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	features := make([]string, 0, len(c.features))
+	for feature := range c.features {
+		features = append(features, feature)
+	}
+
+	pressure := make(map[string]float64, len(c.pressure))
+	for name, fn := range c.pressure {
+		pressure[name] = fn()
+	}
+
+	return map[string]interface{}{
+		"mode":          c.mode,
+		"active":        c.activeLocked(),
+		"auto_active":   c.autoActive,
+		"in_flight":     atomic.LoadInt64(&c.inFlight),
+		"max_in_flight": c.maxInFlight,
+		"pressure":      pressure,
+		"features":      features,
+	}
+}
@@ -0,0 +1,336 @@
+package budget
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// What happens to requests once a budget's limit is reached
+const (
+	ActionNotify   = "notify"   // Keep serving, alerts only
+	ActionFallback = "fallback" // Switch requests to the fallback model
+	ActionBlock    = "block"    // Reject requests until the next period
+)
+
+// GlobalBudget names the budget that covers all traffic
+const GlobalBudget = "global"
+
+// modelBudgetPrefix starts the names of per-model budgets
+const modelBudgetPrefix = "model:"
+
+// Tracker accumulates spend against per-key, per-model and global budgets,
+// alerting as thresholds are crossed. Spend is kept in memory for the current period.
+type Tracker struct {
+	period   string
+	notifier *Notifier
+
+	mu     sync.Mutex
+	global *budget
+	keys   map[string]*budget // API key fingerprint -> budget
+	models map[string]*budget // Model or prefix ending in "*" -> budget shared by every key
+}
+
+// budget is one limit and the spend counted against it this period
+type budget struct {
+	name        string
+	cfg         config.BudgetConfig
+	periodStart time.Time
+	spend       float64
+	tokens      int64
+	requests    int64
+	alerted     map[float64]bool // Thresholds already alerted this period
+}
+
+// Decision is how a request must be handled given the budgets it falls under
+type Decision struct {
+	Budget        string // Budget that caused the decision
+	Blocked       bool
+	FallbackModel string
+}
+
+// New creates a budget tracker from configuration
+func New(cfg config.BudgetsConfig) (*Tracker, error) {
+	switch cfg.Period {
+	case "day", "month":
+	default:
+		return nil, fmt.Errorf("period must be day or month, got %q", cfg.Period)
+	}
+
+	t := &Tracker{
+		period:   cfg.Period,
+		notifier: NewNotifier(cfg.Notify),
+		keys:     make(map[string]*budget),
+		models:   make(map[string]*budget),
+	}
+	if cfg.Global != nil {
+		b, err := newBudget(GlobalBudget, *cfg.Global)
+		if err != nil {
+			return nil, fmt.Errorf("global budget: %w", err)
+		}
+		t.global = b
+	}
+	for key, budgetCfg := range cfg.Keys {
+		// Keys may be listed by fingerprint so the key itself stays out of config
+		id := key
+		if !strings.HasPrefix(key, "key_") {
+			id = storage.APIKeyID(key)
+		}
+		b, err := newBudget(id, budgetCfg)
+		if err != nil {
+			return nil, fmt.Errorf("budget for %s: %w", id, err)
+		}
+		t.keys[id] = b
+	}
+	for model, budgetCfg := range cfg.Models {
+		b, err := newBudget(modelBudgetPrefix+model, budgetCfg)
+		if err != nil {
+			return nil, fmt.Errorf("budget for model %s: %w", model, err)
+		}
+		t.models[model] = b
+	}
+	return t, nil
+}
+
+func newBudget(name string, cfg config.BudgetConfig) (*budget, error) {
+	if cfg.Limit < 0 || cfg.TokenLimit < 0 || (cfg.Limit == 0 && cfg.TokenLimit == 0) {
+		return nil, fmt.Errorf("limit or token_limit must be positive")
+	}
+	for _, threshold := range cfg.Thresholds {
+		if threshold <= 0 {
+			return nil, fmt.Errorf("thresholds must be positive fractions of the limit")
+		}
+	}
+	if len(cfg.Thresholds) == 0 {
+		cfg.Thresholds = []float64{1.0}
+	}
+	sort.Float64s(cfg.Thresholds)
+
+	switch cfg.Action {
+	case "":
+		cfg.Action = ActionNotify
+	case ActionNotify, ActionBlock:
+	case ActionFallback:
+		if cfg.FallbackModel == "" {
+			return nil, fmt.Errorf("action fallback requires fallback_model")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+	return &budget{name: name, cfg: cfg, alerted: make(map[float64]bool)}, nil
+}
+
+// APIKey returns the API key a request was sent with
+func APIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("x-api-key")
+}
+
+// Check decides how a request sent with key for model is handled. A blocking
+// budget wins over a fallback, and the key's own budget over the model's cap,
+// which wins over the global budget. A fallback model that is over its own
+// cap is followed in turn, so requests are never switched to a capped model.
+func (t *Tracker) Check(key, model string) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	var decision Decision
+	for _, b := range []*budget{t.keys[storage.APIKeyID(key)], t.modelBudget(model), t.global} {
+		if b == nil {
+			continue
+		}
+		t.roll(b, now)
+		if b.used() < 1 {
+			continue
+		}
+		switch b.cfg.Action {
+		case ActionBlock:
+			return Decision{Budget: b.name, Blocked: true}
+		case ActionFallback:
+			if decision.FallbackModel == "" {
+				decision = Decision{Budget: b.name, FallbackModel: b.cfg.FallbackModel}
+			}
+		}
+	}
+
+	visited := make(map[string]bool)
+	for decision.FallbackModel != "" {
+		b := t.modelBudget(decision.FallbackModel)
+		if b == nil {
+			break
+		}
+		t.roll(b, now)
+		if b.used() < 1 {
+			break
+		}
+		if visited[decision.FallbackModel] {
+			// Every model the fallbacks lead to is over its cap
+			return Decision{Budget: b.name, Blocked: true}
+		}
+		visited[decision.FallbackModel] = true
+		switch b.cfg.Action {
+		case ActionBlock:
+			return Decision{Budget: b.name, Blocked: true}
+		case ActionFallback:
+			decision = Decision{Budget: b.name, FallbackModel: b.cfg.FallbackModel}
+		default:
+			return decision
+		}
+	}
+	return decision
+}
+
+// Record counts a request's cost and tokens against the budgets it falls
+// under, alerting on any threshold it crosses
+func (t *Tracker) Record(key, model string, cost float64, tokens int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, b := range []*budget{t.keys[storage.APIKeyID(key)], t.modelBudget(model), t.global} {
+		if b == nil {
+			continue
+		}
+		t.roll(b, now)
+		b.spend += cost
+		b.tokens += tokens
+		b.requests++
+
+		used := b.used()
+		for _, threshold := range b.cfg.Thresholds {
+			if b.alerted[threshold] || used < threshold {
+				continue
+			}
+			b.alerted[threshold] = true
+			t.notifier.Notify(Alert{
+				Event:       "budget_threshold",
+				Budget:      b.name,
+				Threshold:   threshold,
+				Spend:       b.spend,
+				Limit:       b.cfg.Limit,
+				Tokens:      b.tokens,
+				TokenLimit:  b.cfg.TokenLimit,
+				Period:      t.period,
+				PeriodStart: b.periodStart,
+				Action:      b.actionAt(threshold),
+				Model:       b.cfg.FallbackModel,
+				Timestamp:   now,
+			})
+		}
+	}
+}
+
+// modelBudget returns the cap covering a model: its own, or else the one with
+// the longest matching prefix. Callers hold t.mu.
+func (t *Tracker) modelBudget(model string) *budget {
+	if model == "" {
+		return nil
+	}
+	if b, ok := t.models[model]; ok {
+		return b
+	}
+	var match *budget
+	longest := -1
+	for pattern, b := range t.models {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			match, longest = b, len(prefix)
+		}
+	}
+	return match
+}
+
+// used is the fraction of the budget spent, by whichever of its limits is closer
+func (b *budget) used() float64 {
+	used := 0.0
+	if b.cfg.Limit > 0 {
+		used = b.spend / b.cfg.Limit
+	}
+	if b.cfg.TokenLimit > 0 {
+		if tokens := float64(b.tokens) / float64(b.cfg.TokenLimit); tokens > used {
+			used = tokens
+		}
+	}
+	return used
+}
+
+// actionAt describes what crossing a threshold does to requests
+func (b *budget) actionAt(threshold float64) string {
+	if threshold < 1 {
+		return ActionNotify
+	}
+	return b.cfg.Action
+}
+
+// roll starts a new period's count once the current period has ended
+func (t *Tracker) roll(b *budget, now time.Time) {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if t.period == "day" {
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if b.periodStart.Equal(start) {
+		return
+	}
+	b.periodStart = start
+	b.spend = 0
+	b.tokens = 0
+	b.requests = 0
+	b.alerted = make(map[float64]bool)
+}
+
+// Status returns spend against every budget for status endpoints
+func (t *Tracker) Status() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	describe := func(b *budget) map[string]interface{} {
+		t.roll(b, now)
+		status := map[string]interface{}{
+			"spend":        b.spend,
+			"tokens":       b.tokens,
+			"requests":     b.requests,
+			"used":         b.used(),
+			"period_start": b.periodStart,
+			"action":       b.cfg.Action,
+			"exceeded":     b.used() >= 1,
+		}
+		if b.cfg.Limit > 0 {
+			status["limit"] = b.cfg.Limit
+		}
+		if b.cfg.TokenLimit > 0 {
+			status["token_limit"] = b.cfg.TokenLimit
+		}
+		if b.cfg.FallbackModel != "" {
+			status["fallback_model"] = b.cfg.FallbackModel
+		}
+		return status
+	}
+
+	keys := make(map[string]interface{}, len(t.keys))
+	for id, b := range t.keys {
+		keys[id] = describe(b)
+	}
+	models := make(map[string]interface{}, len(t.models))
+	for model, b := range t.models {
+		models[model] = describe(b)
+	}
+	status := map[string]interface{}{
+		"period": t.period,
+		"keys":   keys,
+		"models": models,
+	}
+	if t.global != nil {
+		status[GlobalBudget] = describe(t.global)
+	}
+	return status
+}
@@ -0,0 +1,124 @@
+package budget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Alert is sent when spend crosses a budget threshold
+type Alert struct {
+	Event       string    `json:"event"`
+	Budget      string    `json:"budget"` // "global", an API key fingerprint or "model:" and the model
+	Threshold   float64   `json:"threshold"`
+	Spend       float64   `json:"spend"`
+	Limit       float64   `json:"limit"`
+	Tokens      int64     `json:"tokens"`
+	TokenLimit  int64     `json:"token_limit,omitempty"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"period_start"`
+	Action      string    `json:"action"`          // What now happens to requests
+	Model       string    `json:"model,omitempty"` // Fallback model, when requests are switched
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Message describes the alert for people
+func (a Alert) Message() string {
+	period := "monthly"
+	if a.Period == "day" {
+		period = "daily"
+	}
+	var used []string
+	if a.Limit > 0 {
+		used = append(used, fmt.Sprintf("$%.2f of $%.2f", a.Spend, a.Limit))
+	}
+	if a.TokenLimit > 0 {
+		used = append(used, fmt.Sprintf("%d of %d tokens", a.Tokens, a.TokenLimit))
+	}
+	msg := fmt.Sprintf("Budget %s has reached %.0f%% of its %s limit: %s.",
+		a.Budget, a.Threshold*100, period, strings.Join(used, ", "))
+	switch a.Action {
+	case ActionBlock:
+		msg += " Requests are blocked until the next period."
+	case ActionFallback:
+		if a.Model != "" {
+			msg += fmt.Sprintf(" Requests are switched to %s until the next period.", a.Model)
+		}
+	}
+	return msg
+}
+
+// Notifier delivers alerts to webhooks, Slack and email
+type Notifier struct {
+	webhooks []string
+	slack    string
+	email    *config.BudgetEmailConfig
+	client   *http.Client
+}
+
+// NewNotifier creates a notifier for the configured destinations
+func NewNotifier(cfg config.BudgetNotifyConfig) *Notifier {
+	return &Notifier{
+		webhooks: cfg.Webhooks,
+		slack:    cfg.Slack,
+		email:    cfg.Email,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify sends an alert to every destination in the background, so request
+// handling never waits on delivery
+func (n *Notifier) Notify(alert Alert) {
+	log.Printf("[BUDGET] %s", alert.Message())
+
+	for _, url := range n.webhooks {
+		go n.post(url, alert)
+	}
+	if n.slack != "" {
+		go n.post(n.slack, map[string]string{"text": ":warning: " + alert.Message()})
+	}
+	if n.email != nil {
+		go n.sendEmail(alert)
+	}
+}
+
+func (n *Notifier) post(url string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[BUDGET] Failed to deliver alert to %s: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[BUDGET] Alert delivery to %s returned %d", url, resp.StatusCode)
+	}
+}
+
+func (n *Notifier) sendEmail(alert Alert) {
+	cfg := n.email
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host := cfg.SMTPAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Flash Gateway budget alert: %s at %.0f%%\r\n\r\n%s\r\n",
+		cfg.From, strings.Join(cfg.To, ", "), alert.Budget, alert.Threshold*100, alert.Message())
+	if err := smtp.SendMail(cfg.SMTPAddr, auth, cfg.From, cfg.To, []byte(msg)); err != nil {
+		log.Printf("[BUDGET] Failed to email alert: %v", err)
+	}
+}
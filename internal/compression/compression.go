@@ -0,0 +1,165 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content-Encoding values the gateway can decode, and encode
+const (
+	Gzip    = "gzip"
+	Brotli  = "br"
+	Zstd    = "zstd"
+	Deflate = "deflate"
+)
+
+// Encodings parses a Content-Encoding header into the list of codings in the
+// order they were applied, leaving out identity
+func Encodings(header string) []string {
+	var encodings []string
+	for _, part := range strings.Split(header, ",") {
+		encoding := strings.ToLower(strings.TrimSpace(part))
+		if encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// IsSupported reports whether every coding in a Content-Encoding header can be decoded
+func IsSupported(header string) bool {
+	for _, encoding := range Encodings(header) {
+		switch encoding {
+		case Gzip, "x-gzip", Brotli, Zstd, Deflate:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Decode reverses a Content-Encoding header's codings. Bodies without an
+// encoding are returned as is.
+func Decode(header string, data []byte) ([]byte, error) {
+	encodings := Encodings(header)
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoded, err := decode(encodings[i], data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s body: %w", encodings[i], err)
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+// Encode applies a single coding
+func Encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case Gzip:
+		writer = gzip.NewWriter(&buf)
+	case Brotli:
+		writer = brotli.NewWriter(&buf)
+	case Zstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(data, nil), nil
+	case Deflate:
+		writer = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Negotiate picks the coding to send from those offered, in order of the
+// server's preference, given a request's Accept-Encoding header. Returns ""
+// when the client accepts none of them.
+func Negotiate(acceptEncoding string, offered []string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = Gzip
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, ok := accepted[coding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// decode removes a single coding
+func decode(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case Gzip, "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case Brotli:
+		return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	case Zstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(data, nil)
+	case Deflate:
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw DEFLATE
+		if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			defer reader.Close()
+			if decoded, err := io.ReadAll(reader); err == nil {
+				return decoded, nil
+			}
+		}
+		reader := flate.NewReader(bytes.NewReader(data))
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("unsupported content encoding")
+}
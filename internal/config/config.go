@@ -9,18 +9,195 @@ import (
 
 // Config holds the entire application configuration
 type Config struct {
-	Server     ServerConfig     `yaml:"server"`
-	Storage    StorageConfig    `yaml:"storage"`
-	Logging    LoggingConfig    `yaml:"logging"`
-	Guardrails GuardrailsConfig `yaml:"guardrails"`
-	Providers  []ProviderConfig `yaml:"providers"`
+	Server      ServerConfig      `yaml:"server"`
+	Health      HealthConfig      `yaml:"health"`
+	Compression CompressionConfig `yaml:"compression"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Guardrails  GuardrailsConfig  `yaml:"guardrails"`
+	Cost        CostConfig        `yaml:"cost"`
+	Tokens      TokensConfig      `yaml:"tokens"`
+	Usage       UsageConfig       `yaml:"usage"`
+	Leader      LeaderConfig      `yaml:"leader"`
+	Feedback    FeedbackConfig    `yaml:"feedback"`
+	Batches     BatchesConfig     `yaml:"batches"`
+	Budgets     BudgetsConfig     `yaml:"budgets"`
+	Tenants     TenantsConfig     `yaml:"tenants"`
+	Auth        AuthConfig        `yaml:"auth"`
+	IPFilter    IPFilterConfig    `yaml:"ip_filter"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Brownout    BrownoutConfig    `yaml:"brownout"`
+	Admission   AdmissionConfig   `yaml:"admission"`
+	LoadShed    LoadShedConfig    `yaml:"load_shed"`
+	SLO         SLOConfig         `yaml:"slo"`
+	Dedup       DedupConfig       `yaml:"dedup"`
+	UserLimit   UserLimitConfig   `yaml:"user_rate_limit"`
+	RateLimits  RateLimitsConfig  `yaml:"rate_limits"`
+	PromptCache PromptCacheConfig `yaml:"prompt_cache"`
+	WebSocket   WebSocketConfig   `yaml:"websocket"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	Models      ModelsConfig      `yaml:"models"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Transforms  TransformsConfig  `yaml:"transforms"`
+
+	// ModelAliases maps client-facing model names to vendor models (e.g. "fast" -> "gpt-4o-mini").
+	// The request's model field is rewritten before routing, transforms and guardrails.
+	ModelAliases map[string]string `yaml:"model_aliases"`
+
+	Transport TransportConfig  `yaml:"transport"` // HTTP client shared by all providers
+	Providers []ProviderConfig `yaml:"providers"`
+	Routing   RoutingConfig    `yaml:"routing"`
+
+	// Plugins configures compiled-in plugins by name
+	Plugins map[string]PluginConfig `yaml:"plugins,omitempty"`
+
+	// Secrets configures the managers secret://source/path references in this
+	// file are read from
+	Secrets SecretsConfig `yaml:"secrets"`
+}
+
+// SecretsConfig configures secret managers. Any string in the config can be a
+// reference: secret://env/NAME, secret://file/path, secret://vault/mount/path,
+// secret://aws/name or secret://gcp/name, with #field to pick a field of a
+// JSON secret. API keys are refetched every refresh_interval; other secrets
+// are read once at startup.
+type SecretsConfig struct {
+	RefreshInterval string `yaml:"refresh_interval"` // duration string, "0" to never refetch
+
+	Vault *VaultSecretsConfig `yaml:"vault,omitempty"`
+	AWS   *AWSSecretsConfig   `yaml:"aws,omitempty"`
+	GCP   *GCPSecretsConfig   `yaml:"gcp,omitempty"`
+}
+
+// VaultSecretsConfig reads secrets from HashiCorp Vault's KV version 2 engine
+type VaultSecretsConfig struct {
+	Address   string `yaml:"address"`    // falls back to VAULT_ADDR
+	Token     string `yaml:"token"`      // falls back to VAULT_TOKEN
+	TokenFile string `yaml:"token_file"` // read on every fetch, e.g. a Vault Agent sink
+	Namespace string `yaml:"namespace"`  // Vault Enterprise namespace
+}
+
+// AWSSecretsConfig reads secrets from AWS Secrets Manager. Credentials fall
+// back to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+type AWSSecretsConfig struct {
+	Region          string `yaml:"region"` // falls back to AWS_REGION or AWS_DEFAULT_REGION
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	Endpoint        string `yaml:"endpoint"` // default https://secretsmanager.<region>.amazonaws.com
+}
+
+// GCPSecretsConfig reads secrets from Google Cloud Secret Manager
+type GCPSecretsConfig struct {
+	Project     string `yaml:"project"`      // falls back to GOOGLE_CLOUD_PROJECT
+	AccessToken string `yaml:"access_token"` // default: the instance's service account, from the metadata server
+	Endpoint    string `yaml:"endpoint"`     // default https://secretmanager.googleapis.com
+}
+
+// PluginConfig configures one compiled-in plugin
+type PluginConfig struct {
+	Enabled *bool                  `yaml:"enabled,omitempty"` // Compiled-in plugins run unless set to false
+	Config  map[string]interface{} `yaml:"config,omitempty"`  // Passed to the plugin's Init
 }
 
 // ProviderConfig holds configuration for a provider
 type ProviderConfig struct {
 	Name      string           `yaml:"name"`
+	Type      string           `yaml:"type,omitempty"` // implementation, "openai", "mock" or a plugin provider (default: name)
 	BaseURL   string           `yaml:"base_url"`
 	Endpoints []EndpointConfig `yaml:"endpoints"`
+
+	// Serves the endpoints under this prefix only, e.g. "/anthropic" for
+	// /anthropic/v1/messages. The prefix is stripped before proxying, so
+	// providers with the same upstream paths can share the gateway.
+	PathPrefix string `yaml:"path_prefix,omitempty"`
+
+	MaxConcurrent   int    `yaml:"max_concurrent,omitempty"`   // in-flight requests to this provider, 0 for no limit
+	ConcurrencyWait string `yaml:"concurrency_wait,omitempty"` // how long a request may wait for a slot before a 503 (default: no wait)
+
+	// TLS settings for this provider's connections, on top of the shared transport's
+	TLS *ProviderTLSConfig `yaml:"tls,omitempty"`
+
+	// Egress proxy for this provider, e.g. "http://proxy.corp:3128". Hosts matching
+	// no_proxy (default: the NO_PROXY environment variable) are reached directly.
+	ProxyURL string   `yaml:"proxy_url,omitempty"`
+	NoProxy  []string `yaml:"no_proxy,omitempty"`
+
+	// Active probes and error-rate tracking that eject an unhealthy provider; nil disables them
+	HealthCheck *HealthCheckConfig `yaml:"health_check,omitempty"`
+
+	// Models this provider serves, listed by GET /v1/models when models.enabled
+	Models []string `yaml:"models,omitempty"`
+
+	// Upstream keys held by the gateway and sent instead of the client's,
+	// rotated round-robin. Without them the client's key is forwarded.
+	APIKeys   []APIKeyConfig `yaml:"api_keys,omitempty"`
+	KeyHeader string         `yaml:"key_header,omitempty"` // header the key is sent in; other than Authorization it is sent without "Bearer " (default: Authorization)
+
+	// Canned responses of a provider with type "mock"
+	Mock *MockConfig `yaml:"mock,omitempty"`
+}
+
+// MockConfig shapes the answers of a mock provider, which serves chat
+// completions itself for local runs and CI without upstream API keys
+type MockConfig struct {
+	Response    string  `yaml:"response,omitempty"`     // content of every completion (default: the last user message)
+	Latency     string  `yaml:"latency,omitempty"`      // wait before answering, e.g. "300ms"
+	ChunkDelay  string  `yaml:"chunk_delay,omitempty"`  // between streamed chunks (default 20ms)
+	ErrorRate   float64 `yaml:"error_rate,omitempty"`   // fraction of requests answered with error_status
+	ErrorStatus int     `yaml:"error_status,omitempty"` // default 500
+}
+
+// APIKeyConfig is one upstream API key, given inline or read from an
+// environment variable
+type APIKeyConfig struct {
+	Name              string `yaml:"name,omitempty"` // shown in status and logs (default: the key's fingerprint)
+	Key               string `yaml:"key,omitempty"`  // the key or a secret:// reference, refetched as it rotates
+	Env               string `yaml:"env,omitempty"`
+	RequestsPerMinute int    `yaml:"requests_per_minute,omitempty"` // the key's own limit, 0 for none
+	Burst             int    `yaml:"burst,omitempty"`
+}
+
+// ProviderType returns the implementation that serves the provider
+func (p ProviderConfig) ProviderType() string {
+	if p.Type != "" {
+		return p.Type
+	}
+	return p.Name
+}
+
+// RoutingConfig holds rules that pick how requests are served from their
+// headers, before a provider is chosen
+type RoutingConfig struct {
+	Rules []RoutingRuleConfig `yaml:"rules"` // Checked in order; the first match applies
+}
+
+// RoutingRuleConfig matches requests carrying every listed header. Values
+// compare case-insensitively; "*" matches any value and a trailing "*"
+// matches by prefix.
+type RoutingRuleConfig struct {
+	Name    string            `yaml:"name"`
+	Headers map[string]string `yaml:"headers"`
+
+	Provider   string           `yaml:"provider,omitempty"`   // serves matching requests instead of the endpoint's default provider
+	Credential string           `yaml:"credential,omitempty"` // upstream API key sent instead of the client's
+	RateLimit  *RateLimitConfig `yaml:"rate_limit,omitempty"` // shared by all matching requests
+}
+
+// HealthCheckConfig decides when a provider is ejected from routing and re-admitted.
+// Durations are strings like "10s".
+type HealthCheckConfig struct {
+	Path               string `yaml:"path"`                // probed with GET, e.g. "/v1/models"; empty disables probes
+	Interval           string `yaml:"interval"`            // time between probes (default "10s")
+	Timeout            string `yaml:"timeout"`             // probe timeout (default "5s")
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold"` // consecutive failed probes that eject (default 3)
+	HealthyThreshold   int    `yaml:"healthy_threshold"`   // consecutive passing probes that re-admit (default 2)
+
+	ErrorRate    float64 `yaml:"error_rate"`    // share of failed requests in the window that ejects, 0 disables
+	MinRequests  int     `yaml:"min_requests"`  // requests needed in the window before error_rate applies (default 20)
+	Window       string  `yaml:"window"`        // rolling window for error_rate (default "30s")
+	EjectionTime string  `yaml:"ejection_time"` // minimum time ejected (default "30s")
 }
 
 // EndpointConfig defines how an endpoint should be handled
@@ -28,21 +205,151 @@ type EndpointConfig struct {
 	Path    string            `yaml:"path"`
 	Methods []string          `yaml:"methods"`
 	Headers map[string]string `yaml:"headers,omitempty"`
-	Timeout int               `yaml:"timeout,omitempty"` // seconds
+	Timeout int               `yaml:"timeout,omitempty"` // seconds, whole request for non-streamed responses (default 60)
+
+	HeaderTimeout     int `yaml:"header_timeout,omitempty"`      // seconds to wait for response headers, defaults to timeout
+	StreamIdleTimeout int `yaml:"stream_idle_timeout,omitempty"` // seconds allowed between stream chunks, defaults to timeout
+
+	// Mutations applied to this endpoint's responses before guardrails and clients see them
+	Response *ResponseTransformConfig `yaml:"response,omitempty"`
+
+	// Retries of failed upstream calls; nil disables them
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+
+	// Hedged requests for slow upstream calls; nil disables them
+	Hedge *HedgeConfig `yaml:"hedge,omitempty"`
+
+	// Largest request body accepted, in bytes; defaults to server.max_request_body_size
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+
+	// CORS policy replacing the global one for this endpoint
+	CORS *CORSConfig `yaml:"cors,omitempty"`
+
+	// Serves the endpoint from an upstream speaking another API format:
+	// "openai-to-anthropic" sends OpenAI chat completions to Anthropic's
+	// /v1/messages, "anthropic-to-openai" the reverse
+	Translate string `yaml:"translate,omitempty"`
+}
+
+// HedgeConfig sends a second copy of a slow request and uses whichever
+// response arrives first
+type HedgeConfig struct {
+	Delay       string            `yaml:"delay"`        // duration string to wait for the first response before hedging
+	BaseURL     string            `yaml:"base_url"`     // secondary target, e.g. another region (default: the provider's base_url)
+	Headers     map[string]string `yaml:"headers"`      // headers set on the hedged copy only, e.g. the secondary's API key
+	BudgetRatio float64           `yaml:"budget_ratio"` // hedges allowed per request over time (default 0.1)
+	BudgetMin   int               `yaml:"budget_min"`   // hedges always available to quiet endpoints (default 10)
+
+	// Whether POSTs to this endpoint may be sent twice, as for retries
+	ReplaySafe *bool `yaml:"replay_safe"`
+}
+
+// RetryConfig controls gateway-level retries of upstream 429 and 5xx responses
+type RetryConfig struct {
+	MaxRetries     int     `yaml:"max_retries"`
+	InitialBackoff string  `yaml:"initial_backoff"` // duration string, doubled per retry (default "250ms")
+	MaxBackoff     string  `yaml:"max_backoff"`     // caps backoff and Retry-After (default "5s")
+	RetryOn        []int   `yaml:"retry_on"`        // statuses to retry (default 429, 500, 502, 503, 504)
+	BudgetRatio    float64 `yaml:"budget_ratio"`    // retries allowed per request over time (default 0.2)
+	BudgetMin      int     `yaml:"budget_min"`      // retries always available to quiet endpoints (default 10)
+
+	// Whether POSTs to this endpoint may be sent twice. Unset uses the built-in
+	// list of inference endpoints; requests with an Idempotency-Key are always replay-safe.
+	ReplaySafe *bool `yaml:"replay_safe"`
+}
+
+// ResponseTransformConfig mutates an endpoint's responses
+type ResponseTransformConfig struct {
+	StripFields    []string          `yaml:"strip_fields"`    // JSON paths removed from bodies, e.g. "choices[].logprobs"
+	ModelRename    map[string]string `yaml:"model_rename"`    // Upstream model name -> name reported to clients
+	Headers        map[string]string `yaml:"headers"`         // Set on every response
+	RemoveHeaders  []string          `yaml:"remove_headers"`  // Dropped from every response
+	GatewayHeaders bool              `yaml:"gateway_headers"` // Add X-Gateway-Provider and X-Gateway-Latency-Ms
+}
+
+// TransportConfig tunes connection pooling for the HTTP transport shared by providers
+type TransportConfig struct {
+	MaxIdleConns        int    `yaml:"max_idle_conns"`          // Across all hosts
+	MaxIdleConnsPerHost int    `yaml:"max_idle_conns_per_host"` // Go's default of 2 is far too low for a gateway
+	MaxConnsPerHost     int    `yaml:"max_conns_per_host"`      // 0 means unlimited
+	IdleConnTimeout     string `yaml:"idle_conn_timeout"`       // duration string like "90s"
+	DialTimeout         string `yaml:"dial_timeout"`
+	KeepAlive           string `yaml:"keep_alive"` // TCP keep-alive probe interval
+	DisableKeepAlives   bool   `yaml:"disable_keep_alives"`
+	HTTP2               bool   `yaml:"http2"` // Negotiate HTTP/2 with providers that support it
+	TLSHandshakeTimeout string `yaml:"tls_handshake_timeout"`
+	TLSMinVersion       string `yaml:"tls_min_version"` // "1.2" or "1.3"
+	CAFile              string `yaml:"ca_file"`         // Extra PEM roots, e.g. for a private provider endpoint
+	InsecureSkipVerify  bool   `yaml:"insecure_skip_verify"`
+}
+
+// ProviderTLSConfig customizes TLS for one provider, e.g. a self-hosted
+// inference server behind internal PKI. Unset fields follow the transport.
+type ProviderTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`     // PEM roots trusted in addition to the system pool
+	CertFile           string `yaml:"cert_file"`   // Client certificate for mTLS
+	KeyFile            string `yaml:"key_file"`    // Client certificate's private key
+	ServerName         string `yaml:"server_name"` // Overrides the name verified in the server certificate
+	MinVersion         string `yaml:"min_version"` // "1.2" or "1.3"
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
 // ServerConfig holds server-specific configuration
 type ServerConfig struct {
 	Port         string `yaml:"port"`
-	ReadTimeout  int    `yaml:"read_timeout"`   // seconds
-	WriteTimeout int    `yaml:"write_timeout"`  // seconds
-	IdleTimeout  int    `yaml:"idle_timeout"`   // seconds
+	ReadTimeout  int    `yaml:"read_timeout"`  // seconds
+	WriteTimeout int    `yaml:"write_timeout"` // seconds
+	IdleTimeout  int    `yaml:"idle_timeout"`  // seconds
+
+	MaxRequestBodySize int64 `yaml:"max_request_body_size"` // bytes, for endpoints without their own limit; 0 for no limit
+
+	// Middleware orders the listener's middleware by name, outermost first.
+	// Leaving one out drops it; empty means the built-in chain.
+	Middleware []string `yaml:"middleware,omitempty"`
+}
+
+// WebSocketConfig controls proxied WebSocket connections, such as the OpenAI
+// Realtime API. Any endpoint that allows GET accepts an upgrade.
+type WebSocketConfig struct {
+	MaxMessageSize    int64 `yaml:"max_message_size"`    // bytes per message in either direction (default 16MB)
+	Guardrails        bool  `yaml:"guardrails"`          // check client text events and finished response text with guardrails
+	MaxLoggedMessages int   `yaml:"max_logged_messages"` // messages recorded in a connection's log (default 200)
+}
+
+// GRPCConfig serves chat completions over gRPC on a separate listener, for
+// internal services. Calls go through the same routing, guardrails and
+// logging as HTTP requests.
+type GRPCConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Port           string `yaml:"port"`          // separate listener (default ":50051")
+	TLSCertFile    string `yaml:"tls_cert_file"` // serve TLS instead of plaintext HTTP/2 when set with tls_key_file
+	TLSKeyFile     string `yaml:"tls_key_file"`
+	MaxMessageSize int    `yaml:"max_message_size"` // bytes per request message (default 4MB)
+
+	// Middleware orders this listener's middleware like server.middleware,
+	// which it defaults to
+	Middleware []string `yaml:"middleware,omitempty"`
+}
+
+// ModelsConfig has the gateway answer GET /v1/models itself, listing the
+// models of every provider rather than only the one /v1/models routes to
+type ModelsConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	Aliases       bool `yaml:"aliases"`        // list model_aliases as models (default true)
+	MergeUpstream bool `yaml:"merge_upstream"` // add the models of the provider /v1/models is routed to
 }
 
 // StorageConfig holds database configuration
 type StorageConfig struct {
-	Type       string           `yaml:"type"`       // "postgres", "memory"
-	Postgres   PostgresConfig   `yaml:"postgres"`
+	Type     string         `yaml:"type"` // "postgres", "memory"
+	Postgres PostgresConfig `yaml:"postgres"`
+	Memory   MemoryConfig   `yaml:"memory"`
+}
+
+// MemoryConfig bounds the request logs memory storage keeps
+type MemoryConfig struct {
+	MaxLogs int    `yaml:"max_logs"` // Oldest logs are dropped past this many (default 10000)
+	MaxAge  string `yaml:"max_age"`  // Logs older than this are dropped, e.g. "24h"; empty keeps them
 }
 
 // PostgresConfig holds PostgreSQL-specific configuration
@@ -61,25 +368,63 @@ type PostgresConfig struct {
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
-	Enabled         bool   `yaml:"enabled"`
-	BufferSize      int    `yaml:"buffer_size"`
-	BatchSize       int    `yaml:"batch_size"`
-	FlushInterval   string `yaml:"flush_interval"` // duration string like "1s"
-	Workers         int    `yaml:"workers"`
-	MaxBodySize     int    `yaml:"max_body_size"`     // bytes
-	SkipHealthCheck bool   `yaml:"skip_health_check"`
-	SkipOnError     bool   `yaml:"skip_on_error"`
+	Enabled         bool            `yaml:"enabled"`
+	BufferSize      int             `yaml:"buffer_size"`
+	BatchSize       int             `yaml:"batch_size"`
+	FlushInterval   string          `yaml:"flush_interval"` // duration string like "1s"
+	Workers         int             `yaml:"workers"`
+	MaxBodySize     int             `yaml:"max_body_size"` // bytes
+	SkipHealthCheck bool            `yaml:"skip_health_check"`
+	SkipOnError     bool            `yaml:"skip_on_error"`
+	RedactionRules  []RedactionRule `yaml:"redaction_rules"`
+	Spill           SpillConfig     `yaml:"spill"`
+	// Outbox writes guardrail metrics in the same transaction as their request
+	// log, so neither exists without the other
+	Outbox bool `yaml:"outbox"`
+}
+
+// SpillConfig keeps logs on disk when the log channel is full or the storage
+// backend fails, replaying them once the backend recovers
+type SpillConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Dir            string `yaml:"dir"`
+	MaxSizeMB      int    `yaml:"max_size_mb"`     // Logs beyond this are dropped
+	ReplayInterval string `yaml:"replay_interval"` // duration string like "5s"
+}
+
+// RedactionRule removes or masks a JSON field in logged bodies
+type RedactionRule struct {
+	Path   string `yaml:"path"`   // e.g. "messages[].content", "user", "metadata.*"
+	Action string `yaml:"action"` // "mask" (default) or "remove"
+	Target string `yaml:"target"` // "request", "response" or "both" (default)
 }
 
 // GuardrailsConfig holds guardrails configuration
 type GuardrailsConfig struct {
-	Enabled          bool                     `yaml:"enabled"`
-	Timeout          string                   `yaml:"timeout"` // duration string like "5s"
-	MetricsBufferSize int                    `yaml:"metrics_buffer_size"`
-	MetricsBatchSize  int                    `yaml:"metrics_batch_size"`
-	MetricsWorkers    int                    `yaml:"metrics_workers"`
-	InputGuardrails   []GuardrailConfig       `yaml:"input_guardrails"`
-	OutputGuardrails  []GuardrailConfig       `yaml:"output_guardrails"`
+	Enabled           bool                  `yaml:"enabled"`
+	Timeout           string                `yaml:"timeout"`           // duration string like "5s"
+	StreamCheckpoint  int                   `yaml:"stream_checkpoint"` // run output guardrails every N stream events
+	MetricsBufferSize int                   `yaml:"metrics_buffer_size"`
+	MetricsBatchSize  int                   `yaml:"metrics_batch_size"`
+	MetricsWorkers    int                   `yaml:"metrics_workers"`
+	InputGuardrails   []GuardrailConfig     `yaml:"input_guardrails"`
+	OutputGuardrails  []GuardrailConfig     `yaml:"output_guardrails"`
+	Bypass            BypassConfig          `yaml:"bypass"`
+	BlockedResponse   BlockedResponseConfig `yaml:"blocked_response"` // Default for guardrails without their own
+	UserHeader        string                `yaml:"user_header"`      // Names the end user for users filters and metrics
+}
+
+// BlockedResponseConfig customizes what clients receive when a guardrail blocks
+type BlockedResponseConfig struct {
+	Message    string `yaml:"message"`     // text/template; fields: .Guardrail .Layer .Reason .Category .Model
+	StatusCode int    `yaml:"status_code"` // 0 or 200 returns an API-compatible completion, 4xx an error body
+}
+
+// BypassConfig controls the signed X-Guardrail-Bypass header
+type BypassConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Secret  string `yaml:"secret"`  // HMAC-SHA256 key; falls back to GUARDRAIL_BYPASS_SECRET
+	MaxAge  string `yaml:"max_age"` // duration string; signatures older than this are rejected
 }
 
 // GuardrailConfig holds configuration for a single guardrail
@@ -89,6 +434,501 @@ type GuardrailConfig struct {
 	Enabled  bool                   `yaml:"enabled"`
 	Priority int                    `yaml:"priority"`
 	Config   map[string]interface{} `yaml:"config"`
+
+	// Applicability filters; an empty list matches everything. Entries ending
+	// in "*" match by prefix (e.g. "gpt-4o*").
+	Endpoints []string `yaml:"endpoints"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+	Tenants   []string `yaml:"tenants"`
+	APIKeys   []string `yaml:"api_keys"` // Keys or their key_ fingerprints
+	Users     []string `yaml:"users"`    // Values of guardrails.user_header
+
+	// Overrides guardrails.blocked_response for blocks by this guardrail
+	BlockedResponse *BlockedResponseConfig `yaml:"blocked_response"`
+
+	// Timeout bounds this guardrail's check within guardrails.timeout, and
+	// OnError decides whether its errors and timeouts "block" (default) or
+	// "allow" the request
+	Timeout string `yaml:"timeout"`
+	OnError string `yaml:"on_error"`
+
+	// Modifies marks a guardrail that rewrites content, so it runs after the
+	// rest of its priority group, one at a time in configuration order
+	Modifies bool `yaml:"modifies"`
+
+	// Skips the guardrail for a cooldown while its checks keep failing
+	CircuitBreaker *GuardrailCircuitConfig `yaml:"circuit_breaker"`
+}
+
+// GuardrailCircuitConfig decides when a failing guardrail is skipped. Errors
+// and timeouts count as failures; rejections don't.
+type GuardrailCircuitConfig struct {
+	ErrorRate   float64 `yaml:"error_rate"`   // Open when this share of recent checks fail (default 0.5)
+	MinRequests int     `yaml:"min_requests"` // Checks in the window before the rate counts (default 10)
+	Window      string  `yaml:"window"`       // Error-rate window (default 30s)
+	Cooldown    string  `yaml:"cooldown"`     // How long the guardrail is skipped before a trial check (default 30s)
+	SlowCall    string  `yaml:"slow_call"`    // Checks slower than this also count as failures (optional)
+}
+
+// CostConfig holds pricing used to compute per-request cost breakdowns
+type CostConfig struct {
+	Enabled        bool                    `yaml:"enabled"`
+	ResponseHeader bool                    `yaml:"response_header"` // Emit x-flash-cost on responses
+	Models         map[string]ModelPricing `yaml:"models"`          // Keyed by model name or prefix
+	GuardrailCosts map[string]float64      `yaml:"guardrail_costs"` // USD per call, keyed by guardrail name
+}
+
+// TokensConfig controls prompt token estimation. Estimates are made before a
+// request is proxied and compared with the usage the provider reports.
+type TokensConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	ResponseHeader bool `yaml:"response_header"` // Emit X-Flash-Prompt-Tokens-Estimate on responses
+}
+
+// BatchesConfig controls the OpenAI-compatible /v1/files and /v1/batches
+// endpoints. Batch requests run asynchronously through the same guardrails and
+// providers as live traffic, sharing a fixed number of concurrent slots.
+type BatchesConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Dir           string `yaml:"dir"`              // where input files, results and batch state are kept (default "./data/batches")
+	Concurrency   int    `yaml:"concurrency"`      // batch requests in flight at once, across all batches (default 8)
+	MaxFileSizeMB int    `yaml:"max_file_size_mb"` // largest input file accepted (default 100)
+	MaxRequests   int    `yaml:"max_requests"`     // most requests in one batch (default 50000)
+	Retention     string `yaml:"retention"`        // how long files and finished batches are kept (default "720h")
+}
+
+// FeedbackConfig controls the POST /v1/feedback endpoint, where clients rate
+// responses by the request ID the gateway returned. Requires PostgreSQL storage.
+type FeedbackConfig struct {
+	Enabled          bool `yaml:"enabled"`
+	MaxCommentLength int  `yaml:"max_comment_length"` // bytes (default 4000)
+}
+
+// UsageConfig controls rolling request logs up into hourly and daily usage
+// tables for billing and chargeback. Requires PostgreSQL storage.
+type UsageConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Interval string `yaml:"interval"` // how often the current periods are re-aggregated (default "5m")
+	Backfill string `yaml:"backfill"` // how far back the first run aggregates (default "168h")
+}
+
+// LeaderConfig elects one of several gateway instances sharing a PostgreSQL
+// database to run background jobs that should run once, such as usage
+// aggregation. Requires PostgreSQL storage.
+type LeaderConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Name     string `yaml:"name"`     // lock name; deployments sharing a database need their own (default "flash-gateway")
+	Interval string `yaml:"interval"` // how often followers try to take over and the leader checks its lock (default "10s")
+}
+
+// TenantsConfig lets one deployment serve several teams. A request's tenant is
+// resolved from its API key, or from a header for tenants without keys.
+type TenantsConfig struct {
+	Enabled  bool           `yaml:"enabled"`
+	Header   string         `yaml:"header"`   // names the tenant of keyless requests (default "X-Tenant-ID")
+	Required bool           `yaml:"required"` // reject requests that resolve to no tenant
+	Tenants  []TenantConfig `yaml:"tenants"`
+}
+
+// TenantConfig scopes providers, credentials and rate limits to one tenant.
+// Guardrails are scoped to tenants with their own tenants filter.
+type TenantConfig struct {
+	ID          string            `yaml:"id"`
+	Keys        []string          `yaml:"keys"`        // API keys or key_ fingerprints that belong to the tenant
+	Providers   []string          `yaml:"providers"`   // providers the tenant may use; empty allows all
+	Credentials map[string]string `yaml:"credentials"` // provider -> upstream API key sent instead of the client's
+	RateLimit   *RateLimitConfig  `yaml:"rate_limit,omitempty"`
+}
+
+// CORSConfig is the cross-origin policy for browser clients
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	AllowedOrigins   []string `yaml:"allowed_origins"` // "*", exact origins, or patterns like https://*.example.com
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"` // "*" allows any requested header
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"` // seconds browsers may cache a preflight
+}
+
+// IPFilterConfig limits which networks may send requests through the gateway.
+// Entries are CIDRs or single addresses.
+type IPFilterConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Allow          []string `yaml:"allow"`           // when set, only these networks are admitted
+	Deny           []string `yaml:"deny"`            // always rejected, even when also allowed
+	TrustedProxies []string `yaml:"trusted_proxies"` // load balancers whose X-Forwarded-For is used
+}
+
+// AuthConfig holds how client requests are authenticated by the gateway
+type AuthConfig struct {
+	JWT         JWTAuthConfig     `yaml:"jwt"`
+	VirtualKeys VirtualKeysConfig `yaml:"virtual_keys"`
+}
+
+// VirtualKeysConfig lets clients authenticate with keys the gateway issues,
+// stored only as salted hashes. The gateway sends its own upstream
+// credentials for virtual key callers.
+type VirtualKeysConfig struct {
+	Enabled  bool               `yaml:"enabled"`
+	Required bool               `yaml:"required"`  // reject requests without a virtual key or JWT
+	CacheTTL string             `yaml:"cache_ttl"` // how long keys read from the database are trusted, e.g. "30s"
+	Keys     []VirtualKeyConfig `yaml:"keys"`      // keys defined in this file, in addition to those created on the admin API
+	Revoked  []string           `yaml:"revoked"`   // key IDs rejected wherever they are defined
+}
+
+// VirtualKeyConfig is a virtual key's ID and hash, as printed by
+// "server -new-virtual-key <name>"
+type VirtualKeyConfig struct {
+	ID        string `yaml:"id"`
+	Name      string `yaml:"name"`
+	Hash      string `yaml:"hash"`                 // salt:hash, both hex
+	ExpiresAt string `yaml:"expires_at,omitempty"` // RFC 3339 time
+}
+
+// JWTAuthConfig accepts JWTs from an OIDC issuer as an alternative to API
+// keys. The gateway sends its own upstream credentials for JWT callers.
+type JWTAuthConfig struct {
+	OIDCConfig `yaml:",inline"`
+
+	Enabled      bool              `yaml:"enabled"`
+	Required     bool              `yaml:"required"`       // reject requests without a valid JWT
+	Claims       []string          `yaml:"claims"`         // claims recorded in log metadata (default sub, org)
+	RateLimitKey []string          `yaml:"rate_limit_key"` // claims that identify a caller for rate limits (default sub)
+	RateLimit    *RateLimitConfig  `yaml:"rate_limit,omitempty"`
+	Credentials  map[string]string `yaml:"credentials"` // provider -> upstream API key sent for JWT callers
+}
+
+// RateLimitConfig caps a request rate
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"` // requests allowed at once (default: requests_per_minute)
+}
+
+// BudgetsConfig sets spend limits per API key and across the gateway. Spend
+// comes from cost tracking, so cost must be enabled.
+type BudgetsConfig struct {
+	Enabled bool                    `yaml:"enabled"`
+	Period  string                  `yaml:"period"` // "day" or "month" (default), in UTC
+	Global  *BudgetConfig           `yaml:"global,omitempty"`
+	Keys    map[string]BudgetConfig `yaml:"keys"`   // API key or its key_ fingerprint -> budget
+	Models  map[string]BudgetConfig `yaml:"models"` // model, "*" suffix matches by prefix -> cap across all keys
+	Notify  BudgetNotifyConfig      `yaml:"notify"`
+}
+
+// BudgetConfig is one spend limit and what happens as it is approached and exceeded
+type BudgetConfig struct {
+	Limit         float64   `yaml:"limit"`                    // USD per period
+	TokenLimit    int64     `yaml:"token_limit,omitempty"`    // prompt and completion tokens per period
+	Thresholds    []float64 `yaml:"thresholds"`               // fractions of the limit that notify, e.g. [0.5, 0.8, 1.0]
+	Action        string    `yaml:"action"`                   // once the limit is reached: "notify" (default), "fallback" or "block"
+	FallbackModel string    `yaml:"fallback_model,omitempty"` // model requests are switched to with action "fallback"
+}
+
+// BudgetNotifyConfig lists where budget alerts are sent
+type BudgetNotifyConfig struct {
+	Webhooks []string           `yaml:"webhooks"`        // receive a JSON event by POST
+	Slack    string             `yaml:"slack,omitempty"` // Slack incoming webhook URL
+	Email    *BudgetEmailConfig `yaml:"email,omitempty"`
+}
+
+// BudgetEmailConfig sends budget alerts through an SMTP server
+type BudgetEmailConfig struct {
+	SMTPAddr string   `yaml:"smtp_addr"` // host:port
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// ModelPricing holds unit prices for a model in USD per million tokens
+type ModelPricing struct {
+	InputPerMillion       float64 `yaml:"input_per_million"`
+	OutputPerMillion      float64 `yaml:"output_per_million"`
+	CachedInputPerMillion float64 `yaml:"cached_input_per_million"`
+	CacheWritePerMillion  float64 `yaml:"cache_write_per_million"` // Anthropic cache writes; defaults to the input rate
+}
+
+// BrownoutConfig controls shedding of optional features under load
+type BrownoutConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Mode           string   `yaml:"mode"`            // "auto", "on" or "off"
+	CheckInterval  string   `yaml:"check_interval"`  // duration string like "1s"
+	MaxInFlight    int      `yaml:"max_in_flight"`   // in-flight requests that trigger brownout, 0 disables
+	QueueThreshold float64  `yaml:"queue_threshold"` // log queue utilization (0-1) that triggers brownout
+	RecoverRatio   float64  `yaml:"recover_ratio"`   // fraction of thresholds load must drop below to recover
+	RecoveryPeriod string   `yaml:"recovery_period"` // how long load must stay low before restoring
+	Features       []string `yaml:"features"`        // "body_logging", "cost_tracking"
+}
+
+// AdmissionConfig holds configuration for priority queueing of proxied requests
+type AdmissionConfig struct {
+	Enabled         bool           `yaml:"enabled"`
+	MaxConcurrent   int            `yaml:"max_concurrent"`   // requests proxied at once; the rest wait in the queue
+	MaxQueue        int            `yaml:"max_queue"`        // waiting requests before the lowest priorities are shed with 429
+	QueueTimeout    string         `yaml:"queue_timeout"`    // longest wait for a slot, duration string like "30s"
+	DefaultPriority int            `yaml:"default_priority"` // priority of keys not listed in keys
+	Keys            map[string]int `yaml:"keys"`             // API key -> priority; higher priorities are served first
+}
+
+// LoadShedConfig rejects the lowest-priority requests with 503 while goroutine
+// count, heap or log queue depth is over its limit. Priorities come from
+// admission's keys and default_priority, whether or not admission is enabled.
+type LoadShedConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	CheckInterval     string  `yaml:"check_interval"`     // how often pressure is read and shedding adjusted, default "1s"
+	MaxGoroutines     int     `yaml:"max_goroutines"`     // 0 disables the signal
+	MaxHeapMB         int     `yaml:"max_heap_mb"`        // live heap in megabytes, 0 disables the signal
+	MaxLogQueue       float64 `yaml:"max_log_queue"`      // log queue utilization (0-1), 0 disables the signal
+	RecoverRatio      float64 `yaml:"recover_ratio"`      // fraction of limits every signal must drop below to shed less, default 0.8
+	ProtectedPriority *int    `yaml:"protected_priority"` // requests at or above this priority are never shed
+}
+
+// CompressionConfig compresses responses the gateway writes or rewrites
+// itself, such as guardrail overrides, for clients that accept it. Responses
+// passed through from upstream keep the upstream's encoding.
+type CompressionConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Encodings []string `yaml:"encodings"` // offered in order of preference: "gzip", "br", "zstd", "deflate"; default ["gzip"]
+	MinSize   int      `yaml:"min_size"`  // smaller bodies are sent uncompressed, default 1024 bytes
+}
+
+// HealthConfig configures the readiness probe at /health/ready, which checks
+// the log database and queue, and optionally provider reachability
+type HealthConfig struct {
+	Timeout             string  `yaml:"timeout"`               // per-dependency probe timeout, default "2s"
+	MaxQueueUtilization float64 `yaml:"max_queue_utilization"` // log queue fill at which the gateway is not ready, default 0.9
+	Providers           bool    `yaml:"providers"`             // also report whether each provider is reachable
+	RequireProviders    bool    `yaml:"require_providers"`     // an unreachable provider makes the gateway not ready, not just degraded
+}
+
+// DedupConfig coalesces identical requests from the same caller into one
+// upstream call whose response they all share
+type DedupConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Window          string   `yaml:"window"`            // how long a finished response answers identical requests, default "2s"
+	Endpoints       []string `yaml:"endpoints"`         // endpoints to deduplicate, "*" suffix matches by prefix; empty means every POST
+	MaxBodySize     int64    `yaml:"max_body_size"`     // larger requests are never deduplicated, default 1MB
+	MaxResponseSize int      `yaml:"max_response_size"` // larger responses are not shared, default 10MB
+}
+
+// UserLimitConfig gives each end user behind an API key their own request
+// rate. Users are named by the request body's "user" field; requests without
+// one are limited by API key.
+type UserLimitConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+	Burst             int      `yaml:"burst"`         // requests allowed at once (default: requests_per_minute)
+	Endpoints         []string `yaml:"endpoints"`     // endpoints to limit, "*" suffix matches by prefix; empty means all
+	MaxBodySize       int64    `yaml:"max_body_size"` // larger requests are limited by API key, default 1MB
+}
+
+// RateLimitsConfig chooses where rate limit counters are kept. In memory,
+// each gateway instance enforces limits on its own; in Redis, limits hold
+// across all instances. This covers tenant, routing rule, JWT, per-user and
+// provider API key limits.
+type RateLimitsConfig struct {
+	Backend   string      `yaml:"backend"`    // "memory" (default) or "redis"
+	KeyPrefix string      `yaml:"key_prefix"` // prefix of Redis keys (default "flash-gateway:ratelimit:")
+	Redis     RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig connects to a Redis server
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`     // host:port
+	Username string `yaml:"username"` // ACL user, Redis 6 and later
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+	Timeout  string `yaml:"timeout"`   // per command, duration string (default "100ms")
+	PoolSize int    `yaml:"pool_size"` // idle connections kept open (default 16)
+}
+
+// SLOConfig tracks rolling latency percentiles and error rates per provider
+// and model, and alerts when they miss their objectives
+type SLOConfig struct {
+	Enabled     bool                 `yaml:"enabled"`
+	Window      string               `yaml:"window"`       // rolling window, duration string like "5m"
+	MinRequests int                  `yaml:"min_requests"` // requests in the window before objectives are judged
+	Objectives  []SLOObjectiveConfig `yaml:"objectives"`
+	Webhooks    []string             `yaml:"webhooks"` // receive a JSON event by POST when an objective is breached or recovers
+}
+
+// SLOObjectiveConfig sets latency and error thresholds for matching providers
+// and models. Provider and model filters work like guardrail filters; unset
+// thresholds are not checked.
+type SLOObjectiveConfig struct {
+	Name      string   `yaml:"name"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+
+	P50Latency string  `yaml:"p50_latency"` // duration string like "800ms"
+	P95Latency string  `yaml:"p95_latency"`
+	P99Latency string  `yaml:"p99_latency"`
+	ErrorRate  float64 `yaml:"error_rate"` // fraction of requests, e.g. 0.05
+}
+
+// PromptCacheConfig surfaces provider prompt caching (OpenAI cached_tokens,
+// Anthropic cache reads and writes) per request and per model, and can mark
+// Anthropic Messages requests for caching
+type PromptCacheConfig struct {
+	Enabled        bool                      `yaml:"enabled"`
+	ResponseHeader bool                      `yaml:"response_header"` // emit X-Flash-Cached-Tokens on non-streamed responses
+	Inject         []PromptCacheInjectConfig `yaml:"inject"`
+}
+
+// PromptCacheInjectConfig adds cache_control breakpoints to matching Anthropic
+// Messages requests that don't set any themselves. Endpoint, provider and model
+// filters work like guardrail filters.
+type PromptCacheInjectConfig struct {
+	Name      string   `yaml:"name"`
+	Endpoints []string `yaml:"endpoints"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+
+	System       bool   `yaml:"system"`       // cache through the end of the system prompt
+	Tools        bool   `yaml:"tools"`        // cache through the last tool definition
+	Conversation bool   `yaml:"conversation"` // cache through the newest message, so the next turn reads the conversation so far
+	MinChars     int    `yaml:"min_chars"`    // skip bodies shorter than this; short prompts can't be cached
+	TTL          string `yaml:"ttl"`          // "5m" (provider default) or "1h"
+}
+
+// AdminConfig holds configuration for the admin API listener
+type AdminConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Port    string             `yaml:"port"`   // separate listener, e.g. ":9090"
+	Token   string             `yaml:"token"`  // admin-role token; falls back to ADMIN_TOKEN env var
+	Tokens  []AdminTokenConfig `yaml:"tokens"` // additional tokens with their own roles
+	OIDC    *AdminOIDCConfig   `yaml:"oidc,omitempty"`
+
+	// Serves pprof under /debug/pprof/ and heap and goroutine dumps, for the admin role only
+	Profiling AdminProfilingConfig `yaml:"profiling"`
+}
+
+// AdminProfilingConfig exposes runtime profiles on the admin listener
+type AdminProfilingConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	DumpDir string `yaml:"dump_dir"` // where POST /admin/debug/dump writes profiles, default "./data/dumps"
+}
+
+// AdminTokenConfig is a static admin API token granted one role
+type AdminTokenConfig struct {
+	Name  string `yaml:"name"` // who the token belongs to, shown in logs
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"` // admin | analyst | read-only
+}
+
+// OIDCConfig identifies an OpenID Connect issuer whose JWTs are accepted
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	JWKSURL  string `yaml:"jwks_url"` // defaults to the issuer's discovery document
+}
+
+// AdminOIDCConfig accepts JWTs from an identity provider on the admin API,
+// deriving each caller's role from a claim
+type AdminOIDCConfig struct {
+	OIDCConfig `yaml:",inline"`
+	RoleClaim  string            `yaml:"role_claim"` // claim holding roles or groups (default "roles")
+	Roles      map[string]string `yaml:"roles"`      // claim value -> role, required; unmapped values grant nothing
+}
+
+// TransformsConfig holds rules that rewrite traffic passing through the gateway
+type TransformsConfig struct {
+	Request []RequestTransformConfig `yaml:"request"` // Applied in order before guardrails and proxying
+	Splits  []TrafficSplitConfig     `yaml:"splits"`  // A/B tests and canaries, applied after model aliases
+
+	ContextFallback ContextFallbackConfig `yaml:"context_fallback"` // Retries of requests over the model's context window
+	Truncation      TruncationConfig      `yaml:"truncation"`       // Shortening of requests estimated to overflow the model's context window
+}
+
+// TruncationConfig shortens requests whose estimated prompt tokens exceed the
+// model's context window before they are proxied
+type TruncationConfig struct {
+	Enabled        bool                   `yaml:"enabled"`
+	ContextWindows map[string]int         `yaml:"context_windows"` // model -> context window in tokens, matched by exact name then longest prefix
+	Reserve        int                    `yaml:"reserve"`         // tokens kept free on top of the request's max_tokens
+	Rules          []TruncationRuleConfig `yaml:"rules"`           // the first matching rule applies
+}
+
+// TruncationRuleConfig picks how matching requests are shortened. Endpoint,
+// provider and model filters work like guardrail filters.
+type TruncationRuleConfig struct {
+	Name      string   `yaml:"name"`
+	Endpoints []string `yaml:"endpoints"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+
+	Strategy      string `yaml:"strategy"`       // drop_oldest | summarize | hard_truncate
+	SummaryModel  string `yaml:"summary_model"`  // cheap model that summarizes, for summarize
+	SummaryPrompt string `yaml:"summary_prompt"` // instructions placed before the transcript
+	KeepRecent    int    `yaml:"keep_recent"`    // newest messages summarize keeps verbatim, default 4
+}
+
+// ContextFallbackConfig retries requests the provider rejects for exceeding
+// the model's context window, on a larger-context model or with fewer messages
+type ContextFallbackConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Models      map[string]string `yaml:"models"`       // model -> larger-context model, matched by exact name then longest prefix
+	Truncate    string            `yaml:"truncate"`     // "drop_oldest" drops the oldest messages once no larger model is left; empty returns the error
+	MaxAttempts int               `yaml:"max_attempts"` // retries per request, default 3
+}
+
+// TrafficSplitConfig divides matching requests between model variants by
+// weight. Endpoint, provider and model filters work like guardrail filters;
+// models filters on the model the client asked for.
+type TrafficSplitConfig struct {
+	Name      string   `yaml:"name"`
+	Endpoints []string `yaml:"endpoints"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+
+	// Request attributes that keep a client on the same variant, tried in order:
+	// "header:<name>", "api_key" or "user" (the body's user field).
+	// Defaults to header:X-Session-ID then api_key; requests with none are assigned randomly.
+	StickyBy []string               `yaml:"sticky_by"`
+	Variants []TrafficVariantConfig `yaml:"variants"`
+
+	// AvoidBreached sends no traffic to variants whose model is missing an SLO
+	// objective, while another variant is meeting its objectives
+	AvoidBreached bool `yaml:"avoid_breached"`
+
+	// PinTTL keeps every turn of a conversation on the variant its first turn
+	// was given, so provider prompt caches stay warm. Pins lapse this long
+	// after a conversation's last turn; duration string, empty disables.
+	PinTTL string `yaml:"pin_ttl"`
+
+	// Strategy is "weighted" (default), splitting by the variants' weights, or
+	// "adaptive", shifting traffic toward the variants with the best recent
+	// latency and success rate as measured by SLO tracking
+	Strategy string `yaml:"strategy"`
+}
+
+// TrafficVariantConfig is one arm of a traffic split
+type TrafficVariantConfig struct {
+	Name   string `yaml:"name"`
+	Model  string `yaml:"model"`  // Model sent upstream; empty keeps the requested model
+	Weight int    `yaml:"weight"` // Relative share of traffic, e.g. 5 and 95
+
+	// Bounds on an adaptive split's share of traffic for this variant, 0-1
+	MinShare float64 `yaml:"min_share"`
+	MaxShare float64 `yaml:"max_share"` // 0 means 1
+}
+
+// RequestTransformConfig rewrites matching requests. Endpoint, provider and model
+// filters work like guardrail filters; an empty list matches everything.
+type RequestTransformConfig struct {
+	Name      string   `yaml:"name"`
+	Endpoints []string `yaml:"endpoints"`
+	Providers []string `yaml:"providers"`
+	Models    []string `yaml:"models"`
+
+	SystemPrompt     string                 `yaml:"system_prompt"`      // text/template
+	SystemPromptMode string                 `yaml:"system_prompt_mode"` // "replace" (default), "prepend" or "append"
+	PromptTemplate   string                 `yaml:"prompt_template"`    // text/template wrapping the last user prompt
+	Defaults         map[string]interface{} `yaml:"defaults"`           // Parameters set when the client omitted them
+	Vars             map[string]string      `yaml:"vars"`               // Exposed to templates as .Vars
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -100,6 +940,8 @@ func LoadConfig(configPath string) (*Config, error) {
 			ReadTimeout:  30,
 			WriteTimeout: 30,
 			IdleTimeout:  120,
+
+			MaxRequestBodySize: 32 << 20, // 32MB, above the 25MB audio upload limit
 		},
 		Storage: StorageConfig{
 			Type: "postgres",
@@ -125,15 +967,127 @@ func LoadConfig(configPath string) (*Config, error) {
 			MaxBodySize:     64 * 1024, // 64KB
 			SkipHealthCheck: true,
 			SkipOnError:     true,
+			Spill: SpillConfig{
+				Dir:            "./data/log-spill",
+				MaxSizeMB:      1024,
+				ReplayInterval: "5s",
+			},
 		},
 		Guardrails: GuardrailsConfig{
-			Enabled:          false, // Disabled by default
-			Timeout:          "5s",
+			Enabled:           false, // Disabled by default
+			Timeout:           "5s",
+			StreamCheckpoint:  20,
+			UserHeader:        "X-User-ID",
 			MetricsBufferSize: 1000,
 			MetricsBatchSize:  10,
 			MetricsWorkers:    2,
 			InputGuardrails:   []GuardrailConfig{},
 			OutputGuardrails:  []GuardrailConfig{},
+			Bypass: BypassConfig{
+				Enabled: false,
+				MaxAge:  "5m",
+			},
+		},
+		Cost: CostConfig{
+			Enabled:        false,
+			ResponseHeader: false,
+			Models:         map[string]ModelPricing{},
+			GuardrailCosts: map[string]float64{},
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageSize:    16 << 20,
+			Guardrails:        false,
+			MaxLoggedMessages: 200,
+		},
+		Models: ModelsConfig{
+			Enabled: false,
+			Aliases: true,
+		},
+		GRPC: GRPCConfig{
+			Enabled:        false,
+			Port:           ":50051",
+			MaxMessageSize: 4 << 20,
+		},
+		Batches: BatchesConfig{
+			Enabled:       false,
+			Dir:           "./data/batches",
+			Concurrency:   8,
+			MaxFileSizeMB: 100,
+			MaxRequests:   50000,
+			Retention:     "720h",
+		},
+		Feedback: FeedbackConfig{
+			Enabled:          false,
+			MaxCommentLength: 4000,
+		},
+		Usage: UsageConfig{
+			Enabled:  false,
+			Interval: "5m",
+			Backfill: "168h",
+		},
+		Tenants: TenantsConfig{
+			Enabled: false,
+			Header:  "X-Tenant-ID",
+		},
+		CORS: CORSConfig{
+			Enabled:        true,
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-Requested-With"},
+			MaxAge:         86400,
+		},
+		Auth: AuthConfig{
+			JWT: JWTAuthConfig{
+				Enabled:      false,
+				Claims:       []string{"sub", "org"},
+				RateLimitKey: []string{"sub"},
+			},
+			VirtualKeys: VirtualKeysConfig{
+				Enabled:  false,
+				CacheTTL: "30s",
+			},
+		},
+		Budgets: BudgetsConfig{
+			Enabled: false,
+			Period:  "month",
+		},
+		Brownout: BrownoutConfig{
+			Enabled:        false,
+			Mode:           "auto",
+			CheckInterval:  "1s",
+			MaxInFlight:    0,
+			QueueThreshold: 0.8,
+			RecoverRatio:   0.5,
+			RecoveryPeriod: "30s",
+			Features:       []string{"body_logging"},
+		},
+		Admission: AdmissionConfig{
+			Enabled:       false,
+			MaxConcurrent: 100,
+			MaxQueue:      500,
+			QueueTimeout:  "30s",
+		},
+		SLO: SLOConfig{
+			Enabled:     false,
+			Window:      "5m",
+			MinRequests: 20,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Port:    ":9090",
+		},
+		Secrets: SecretsConfig{
+			RefreshInterval: "5m",
+		},
+		Transport: TransportConfig{
+			MaxIdleConns:        200,
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     "90s",
+			DialTimeout:         "10s",
+			KeepAlive:           "30s",
+			HTTP2:               true,
+			TLSHandshakeTimeout: "10s",
+			TLSMinVersion:       "1.2",
 		},
 	}
 
@@ -0,0 +1,138 @@
+package conversation
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// maxTurns caps how many requests of one conversation are returned
+const maxTurns = 1000
+
+// API serves logged conversations from storage
+type API struct {
+	backend storage.StorageBackend
+}
+
+// NewAPI creates a conversation API backed by the given storage
+func NewAPI(backend storage.StorageBackend) *API {
+	return &API{backend: backend}
+}
+
+// Register mounts the conversation endpoints on the admin server
+func (a *API) Register(server *admin.Server) {
+	server.HandleFuncRole("/admin/conversations/", admin.RoleAnalyst, a.conversationHandler)
+}
+
+// Turn is one logged request of a conversation
+type Turn struct {
+	Turn             int                    `json:"turn,omitempty"`
+	RequestID        string                 `json:"request_id"`
+	Timestamp        time.Time              `json:"timestamp"`
+	Endpoint         string                 `json:"endpoint"`
+	Model            string                 `json:"model,omitempty"`
+	StatusCode       *int                   `json:"status_code,omitempty"`
+	LatencyMs        *int64                 `json:"latency_ms,omitempty"`
+	PromptTokens     int64                  `json:"prompt_tokens"`
+	CompletionTokens int64                  `json:"completion_tokens"`
+	Cost             float64                `json:"cost"`
+	Error            *string                `json:"error,omitempty"`
+	RequestBody      *string                `json:"request_body,omitempty"`
+	ResponseBody     *string                `json:"response_body,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Totals sums usage across a conversation
+type Totals struct {
+	Turns            int     `json:"turns"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// conversationHandler returns a conversation's requests, oldest first, with
+// token and cost totals. Bodies are included unless ?bodies=false.
+func (a *API) conversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/conversations/")
+	if id == "" || strings.Contains(id, "/") {
+		admin.WriteError(w, http.StatusNotFound, "conversation id required")
+		return
+	}
+
+	filter := storage.LogFilter{
+		ConversationID: &id,
+		Limit:          maxTurns,
+		OrderBy:        "timestamp",
+		OrderDir:       "ASC",
+	}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		filter.TenantID = &tenant
+	}
+	logs, err := a.backend.GetRequestLogs(r.Context(), filter)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(logs) == 0 {
+		admin.WriteError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	withBodies := r.URL.Query().Get("bodies") != "false"
+	turns := make([]Turn, 0, len(logs))
+	var totals Totals
+	for _, entry := range logs {
+		turn := Turn{
+			RequestID:  entry.RequestID.String(),
+			Timestamp:  entry.Timestamp,
+			Endpoint:   entry.Endpoint,
+			StatusCode: entry.StatusCode,
+			LatencyMs:  entry.LatencyMs,
+			Error:      entry.Error,
+			Metadata:   entry.Metadata,
+		}
+		if withBodies {
+			turn.RequestBody = entry.RequestBody
+			turn.ResponseBody = entry.ResponseBody
+		}
+		if n, ok := entry.Metadata["conversation_turn"].(float64); ok {
+			turn.Turn = int(n)
+		}
+		if breakdown, ok := entry.Metadata["cost"].(map[string]interface{}); ok {
+			turn.Model, _ = breakdown["model"].(string)
+			turn.PromptTokens = int64(number(breakdown["prompt_tokens"]))
+			turn.CompletionTokens = int64(number(breakdown["completion_tokens"]))
+			turn.Cost = number(breakdown["total_cost"])
+		}
+
+		totals.Turns++
+		totals.PromptTokens += turn.PromptTokens
+		totals.CompletionTokens += turn.CompletionTokens
+		totals.Cost += turn.Cost
+		turns = append(turns, turn)
+	}
+	totals.TotalTokens = totals.PromptTokens + totals.CompletionTokens
+
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"started":   logs[0].Timestamp,
+		"last":      logs[len(logs)-1].Timestamp,
+		"turns":     turns,
+		"totals":    totals,
+		"truncated": len(logs) == maxTurns,
+	})
+}
+
+// number reads a JSON number decoded from log metadata
+func number(value interface{}) float64 {
+	n, _ := value.(float64)
+	return n
+}
@@ -0,0 +1,176 @@
+package conversation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Header lets clients name the conversation a request belongs to. The
+// resolved ID is echoed on the response under the same header.
+const Header = "X-Conversation-ID"
+
+// maxIDLength matches the conversation_id column
+const maxIDLength = 255
+
+// trackedResponses bounds how many Responses API response IDs are remembered
+// for linking previous_response_id chains
+const trackedResponses = 10000
+
+// Thread identifies the conversation a request belongs to and its place in it
+type Thread struct {
+	ID      string `json:"id"`
+	Turn    int    `json:"turn"`              // Number of user turns up to and including this request
+	Derived bool   `json:"derived,omitempty"` // The ID was derived from the opening of the conversation rather than supplied
+}
+
+// Tracker threads requests into conversations. An ID is taken, in order, from
+// the X-Conversation-ID header, a Responses API "conversation", or the
+// conversation a previous_response_id belongs to; otherwise one is derived
+// from the caller's credential, system prompt and first user message, which
+// stay the same on every turn of a Chat Completions or Messages conversation.
+type Tracker struct {
+	mu        sync.Mutex
+	responses map[string]Thread // Responses API response ID -> thread it answered
+	order     []string          // Response IDs oldest first, for eviction
+}
+
+// NewTracker creates a conversation tracker
+func NewTracker() *Tracker {
+	return &Tracker{responses: make(map[string]Thread)}
+}
+
+// Identify returns the conversation a request belongs to, or nil when it
+// carries none, such as embeddings or a request without user messages
+func (t *Tracker) Identify(headers http.Header, body string, scope guardrails.Scope) *Thread {
+	var fields struct {
+		Conversation       json.RawMessage `json:"conversation"`
+		PreviousResponseID string          `json:"previous_response_id"`
+	}
+	json.Unmarshal([]byte(body), &fields)
+
+	input := guardrails.ParseInput("input", body, scope)
+	turns := 0
+	for _, msg := range input.Messages {
+		if msg.Role == "user" {
+			turns++
+		}
+	}
+
+	thread := &Thread{ID: strings.TrimSpace(headers.Get(Header)), Turn: turns}
+	if len(thread.ID) > maxIDLength {
+		thread.ID = ""
+	}
+	if thread.ID == "" {
+		thread.ID = responsesConversation(fields.Conversation)
+	}
+
+	if previous, ok := t.lookup(fields.PreviousResponseID); ok {
+		if thread.ID == "" {
+			thread.ID = previous.ID
+		}
+		thread.Turn = previous.Turn + turns
+		if turns == 0 {
+			thread.Turn++ // Input given only as items without a user role
+		}
+	}
+
+	if thread.ID == "" {
+		if input.Batch || turns == 0 {
+			return nil
+		}
+		thread.ID = deriveID(headers, input.Messages)
+		thread.Derived = true
+	}
+	if thread.Turn == 0 {
+		thread.Turn = 1
+	}
+	return thread
+}
+
+// Remember links a Responses API response to the thread it answered, so a
+// follow-up sent with previous_response_id joins the same conversation
+func (t *Tracker) Remember(responseBody []byte, thread *Thread) {
+	if thread == nil {
+		return
+	}
+	var response struct {
+		ID     string `json:"id"`
+		Object string `json:"object"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil || response.Object != "response" || response.ID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.responses[response.ID]; !ok {
+		t.order = append(t.order, response.ID)
+	}
+	t.responses[response.ID] = *thread
+	for len(t.order) > trackedResponses {
+		delete(t.responses, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+func (t *Tracker) lookup(responseID string) (Thread, bool) {
+	if responseID == "" {
+		return Thread{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	thread, ok := t.responses[responseID]
+	return thread, ok
+}
+
+// responsesConversation reads the Responses API conversation field, which is
+// either an ID or an object holding one
+func responsesConversation(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var id string
+	if err := json.Unmarshal(raw, &id); err != nil {
+		var obj struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(raw, &obj)
+		id = obj.ID
+	}
+	if len(id) > maxIDLength {
+		return ""
+	}
+	return id
+}
+
+// deriveID hashes what stays fixed across a conversation's turns. The
+// credential is included so identical prompts from different callers don't
+// share a conversation.
+func deriveID(headers http.Header, messages []guardrails.Message) string {
+	hash := sha256.New()
+	credential := headers.Get("Authorization")
+	if credential == "" {
+		credential = headers.Get("x-api-key")
+	}
+	hash.Write([]byte(credential))
+	for _, msg := range messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			hash.Write([]byte{0})
+			hash.Write([]byte(msg.Content))
+		}
+	}
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			hash.Write([]byte{1})
+			hash.Write([]byte(msg.Content))
+			break
+		}
+	}
+	return "conv_" + hex.EncodeToString(hash.Sum(nil)[:12])
+}
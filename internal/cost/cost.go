@@ -0,0 +1,260 @@
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Usage holds token usage reported by the provider for a single request
+type Usage struct {
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CachedTokens     int    `json:"cached_tokens"`                // Prompt tokens read from the provider's cache
+	CacheWriteTokens int    `json:"cache_write_tokens,omitempty"` // Prompt tokens written to the cache (Anthropic)
+}
+
+// Merge folds a later usage report from the same stream into u. Anthropic
+// streams report input and cache tokens in message_start and output tokens
+// in message_delta, so counts the later report leaves at zero are kept.
+func (u *Usage) Merge(next *Usage) {
+	if next.Model != "" {
+		u.Model = next.Model
+	}
+	if next.PromptTokens > 0 {
+		u.PromptTokens = next.PromptTokens
+	}
+	if next.CompletionTokens > 0 {
+		u.CompletionTokens = next.CompletionTokens
+	}
+	if next.CachedTokens > 0 {
+		u.CachedTokens = next.CachedTokens
+	}
+	if next.CacheWriteTokens > 0 {
+		u.CacheWriteTokens = next.CacheWriteTokens
+	}
+}
+
+// Breakdown is the per-request cost attached to log metadata and the x-flash-cost header
+type Breakdown struct {
+	Model                string             `json:"model,omitempty"`
+	PricingModel         string             `json:"pricing_model,omitempty"` // Pricing entry that matched the model
+	PromptTokens         int                `json:"prompt_tokens"`
+	CompletionTokens     int                `json:"completion_tokens"`
+	CachedTokens         int                `json:"cached_tokens"`
+	CacheWriteTokens     int                `json:"cache_write_tokens,omitempty"`
+	InputPerMillion      float64            `json:"input_per_million"`
+	OutputPerMillion     float64            `json:"output_per_million"`
+	CachedPerMillion     float64            `json:"cached_input_per_million"`
+	CacheWritePerMillion float64            `json:"cache_write_per_million,omitempty"`
+	PromptCost           float64            `json:"prompt_cost"`
+	CompletionCost       float64            `json:"completion_cost"`
+	CacheSavings         float64            `json:"cache_savings"`
+	GuardrailCost        float64            `json:"guardrail_cost"`
+	GuardrailBreakdown   map[string]float64 `json:"guardrail_breakdown,omitempty"`
+	TotalCost            float64            `json:"total_cost"`
+	Currency             string             `json:"currency"`
+	Priced               bool               `json:"priced"` // False when no pricing entry matched the model
+}
+
+// Calculator computes cost breakdowns from configured unit prices
+type Calculator struct {
+	models         map[string]config.ModelPricing
+	guardrailCosts map[string]float64
+	responseHeader bool
+}
+
+// NewCalculator creates a new cost calculator
+func NewCalculator(cfg config.CostConfig) *Calculator {
+	models := cfg.Models
+	if models == nil {
+		models = make(map[string]config.ModelPricing)
+	}
+	guardrailCosts := cfg.GuardrailCosts
+	if guardrailCosts == nil {
+		guardrailCosts = make(map[string]float64)
+	}
+
+	return &Calculator{
+		models:         models,
+		guardrailCosts: guardrailCosts,
+		responseHeader: cfg.ResponseHeader,
+	}
+}
+
+// ResponseHeaderEnabled reports whether the x-flash-cost header should be emitted
+func (c *Calculator) ResponseHeaderEnabled() bool {
+	return c.responseHeader
+}
+
+// Calculate builds a cost breakdown for the given usage and executed guardrails.
+// usage may be nil when the request never reached the provider (e.g. input blocks).
+func (c *Calculator) Calculate(usage *Usage, guardrailNames []string) *Breakdown {
+	breakdown := &Breakdown{Currency: "USD"}
+
+	if usage != nil {
+		breakdown.Model = usage.Model
+		breakdown.PromptTokens = usage.PromptTokens
+		breakdown.CompletionTokens = usage.CompletionTokens
+		breakdown.CachedTokens = usage.CachedTokens
+		breakdown.CacheWriteTokens = usage.CacheWriteTokens
+
+		if name, pricing, ok := c.lookupPricing(usage.Model); ok {
+			breakdown.Priced = true
+			breakdown.PricingModel = name
+			breakdown.InputPerMillion = pricing.InputPerMillion
+			breakdown.OutputPerMillion = pricing.OutputPerMillion
+			breakdown.CachedPerMillion = pricing.CachedInputPerMillion
+			breakdown.CacheWritePerMillion = pricing.CacheWritePerMillion
+
+			// Cached prompt tokens are billed at the cached rate when one is configured
+			cachedRate := pricing.CachedInputPerMillion
+			if cachedRate <= 0 {
+				cachedRate = pricing.InputPerMillion
+			}
+			// Cache writes are billed at their own rate when one is configured
+			writeRate := pricing.CacheWritePerMillion
+			if writeRate <= 0 {
+				writeRate = pricing.InputPerMillion
+			}
+			uncached := usage.PromptTokens - usage.CachedTokens - usage.CacheWriteTokens
+			if uncached < 0 {
+				uncached = 0
+			}
+
+			breakdown.PromptCost = perMillion(uncached, pricing.InputPerMillion) + perMillion(usage.CachedTokens, cachedRate) +
+				perMillion(usage.CacheWriteTokens, writeRate)
+			breakdown.CompletionCost = perMillion(usage.CompletionTokens, pricing.OutputPerMillion)
+			breakdown.CacheSavings = perMillion(usage.CachedTokens, pricing.InputPerMillion-cachedRate)
+		}
+	}
+
+	for _, name := range guardrailNames {
+		if callCost, ok := c.guardrailCosts[name]; ok && callCost > 0 {
+			if breakdown.GuardrailBreakdown == nil {
+				breakdown.GuardrailBreakdown = make(map[string]float64)
+			}
+			breakdown.GuardrailBreakdown[name] += callCost
+			breakdown.GuardrailCost += callCost
+		}
+	}
+
+	breakdown.TotalCost = breakdown.PromptCost + breakdown.CompletionCost + breakdown.GuardrailCost
+	return breakdown
+}
+
+// lookupPricing finds pricing for a model by exact name, then by longest prefix
+// so that dated snapshots (gpt-4o-2024-08-06) resolve to their family entry
+func (c *Calculator) lookupPricing(model string) (string, config.ModelPricing, bool) {
+	if model == "" {
+		return "", config.ModelPricing{}, false
+	}
+	if pricing, ok := c.models[model]; ok {
+		return model, pricing, true
+	}
+
+	bestName := ""
+	for name := range c.models {
+		if strings.HasPrefix(model, name) && len(name) > len(bestName) {
+			bestName = name
+		}
+	}
+	if bestName == "" {
+		return "", config.ModelPricing{}, false
+	}
+	return bestName, c.models[bestName], true
+}
+
+// HeaderValue renders the breakdown in a compact form for the x-flash-cost header
+func (b *Breakdown) HeaderValue() string {
+	parts := []string{
+		fmt.Sprintf("total=%.8f", b.TotalCost),
+		fmt.Sprintf("prompt=%.8f", b.PromptCost),
+		fmt.Sprintf("completion=%.8f", b.CompletionCost),
+		fmt.Sprintf("guardrails=%.8f", b.GuardrailCost),
+		fmt.Sprintf("cache_savings=%.8f", b.CacheSavings),
+		fmt.Sprintf("prompt_tokens=%d", b.PromptTokens),
+		fmt.Sprintf("completion_tokens=%d", b.CompletionTokens),
+		"currency=" + b.Currency,
+	}
+	return strings.Join(parts, "; ")
+}
+
+// usageFields is the usage object of the provider response shapes ParseUsage understands
+type usageFields struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	InputTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+
+	// Anthropic reports cache reads and writes apart from input_tokens
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens"`
+}
+
+// ParseUsage extracts model and token usage from a provider response body.
+// The Chat/Completions shape (prompt_tokens/completion_tokens), the Responses
+// API shape (input_tokens/output_tokens) and Anthropic Messages, including its
+// message_start stream event, are understood. PromptTokens always counts
+// cached tokens, as OpenAI reports it.
+func ParseUsage(body []byte) (*Usage, bool) {
+	var parsed struct {
+		Model   string       `json:"model"`
+		Usage   *usageFields `json:"usage"`
+		Message *struct {
+			Model string       `json:"model"`
+			Usage *usageFields `json:"usage"`
+		} `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, false
+	}
+	fields, model := parsed.Usage, parsed.Model
+	if fields == nil && parsed.Message != nil {
+		fields, model = parsed.Message.Usage, parsed.Message.Model
+	}
+	if fields == nil {
+		return nil, false
+	}
+
+	usage := &Usage{
+		Model:            model,
+		PromptTokens:     fields.PromptTokens,
+		CompletionTokens: fields.CompletionTokens,
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		usage.PromptTokens = fields.InputTokens
+		usage.CompletionTokens = fields.OutputTokens
+	}
+	switch {
+	case fields.PromptTokensDetails != nil:
+		usage.CachedTokens = fields.PromptTokensDetails.CachedTokens
+	case fields.InputTokensDetails != nil:
+		usage.CachedTokens = fields.InputTokensDetails.CachedTokens
+	case fields.CacheReadInputTokens != nil || fields.CacheCreationInputTokens != nil:
+		if fields.CacheReadInputTokens != nil {
+			usage.CachedTokens = *fields.CacheReadInputTokens
+		}
+		if fields.CacheCreationInputTokens != nil {
+			usage.CacheWriteTokens = *fields.CacheCreationInputTokens
+		}
+		usage.PromptTokens += usage.CachedTokens + usage.CacheWriteTokens
+	}
+
+	return usage, true
+}
+
+// perMillion converts a token count and a per-million-token price into a cost
+func perMillion(tokens int, price float64) float64 {
+	return float64(tokens) * price / 1_000_000
+}
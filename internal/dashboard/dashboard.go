@@ -0,0 +1,196 @@
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// Dashboard serves the embedded single-page dashboard and its data API
+type Dashboard struct {
+	backend storage.StorageBackend
+}
+
+// New creates a new dashboard backed by the given storage
+func New(backend storage.StorageBackend) *Dashboard {
+	return &Dashboard{backend: backend}
+}
+
+// Register mounts the dashboard on the admin server.
+// Static assets are public; the data API requires the admin token.
+func (d *Dashboard) Register(server *admin.Server) {
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err) // embedded directory is always present
+	}
+
+	server.HandlePublic("/dashboard/", http.StripPrefix("/dashboard/", http.FileServer(http.FS(static))))
+	server.HandlePublic("/dashboard", http.RedirectHandler("/dashboard/", http.StatusMovedPermanently))
+	server.HandleFuncRole("/dashboard/api/logs", admin.RoleAnalyst, d.logsHandler)
+	server.HandleFunc("/dashboard/api/stats", d.statsHandler)
+	server.HandleFuncRole("/admin/logs/export", admin.RoleAnalyst, d.exportHandler)
+	server.HandleFuncRole("/admin/datasets/finetune", admin.RoleAnalyst, d.datasetHandler)
+}
+
+// logsHandler returns recent request logs, newest first
+func (d *Dashboard) logsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limit := queryInt(r, "limit", 200)
+	if limit > 1000 {
+		limit = 1000
+	}
+	start := time.Now().Add(-time.Duration(queryInt(r, "hours", 24)) * time.Hour)
+
+	filter, err := searchFilter(r)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.StartTime = &start
+	filter.Limit = limit
+	filter.OrderBy = "timestamp"
+	filter.OrderDir = "DESC"
+
+	logs, err := d.backend.GetRequestLogs(r.Context(), filter)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Bodies are large and not shown in the overview
+	for _, entry := range logs {
+		entry.RequestBody = nil
+		entry.ResponseBody = nil
+		entry.RequestHeaders = nil
+		entry.ResponseHeaders = nil
+	}
+
+	if logs == nil {
+		logs = []*storage.RequestLog{}
+	}
+	admin.WriteJSON(w, http.StatusOK, logs)
+}
+
+// statsHandler returns aggregated statistics for the requested window
+func (d *Dashboard) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	start := time.Now().Add(-time.Duration(queryInt(r, "hours", 24)) * time.Hour)
+	filter, err := searchFilter(r)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.StartTime = &start
+
+	stats, err := d.backend.GetLogStats(r.Context(), filter)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, stats)
+}
+
+// searchFilter reads the log search parameters shared by the logs and stats APIs
+func searchFilter(r *http.Request) (storage.LogFilter, error) {
+	filter := storage.LogFilter{
+		TenantID: queryString(r, "tenant"),
+		Endpoint: queryString(r, "endpoint"),
+		Provider: queryString(r, "provider"),
+		Model:    queryString(r, "model"),
+		Search:   queryString(r, "q"),
+	}
+	if status := queryString(r, "guardrail"); status != nil {
+		switch *status {
+		case storage.GuardrailPassed, storage.GuardrailModified, storage.GuardrailBlocked:
+			filter.GuardrailStatus = status
+		case "any":
+		default:
+			return filter, fmt.Errorf("guardrail must be %s, %s or %s", storage.GuardrailPassed, storage.GuardrailModified, storage.GuardrailBlocked)
+		}
+	}
+	if rating := queryString(r, "rating"); rating != nil {
+		switch *rating {
+		case "up", "down", "none":
+			filter.Rating = rating
+		default:
+			return filter, fmt.Errorf("rating must be up, down or none")
+		}
+	}
+	var err error
+	if filter.MinScore, err = queryFloat(r, "min_score"); err != nil {
+		return filter, err
+	}
+	if filter.MinTokens, err = queryInt64(r, "min_tokens"); err != nil {
+		return filter, err
+	}
+	if filter.MaxTokens, err = queryInt64(r, "max_tokens"); err != nil {
+		return filter, err
+	}
+	if filter.MinCost, err = queryFloat(r, "min_cost"); err != nil {
+		return filter, err
+	}
+	if filter.MaxCost, err = queryFloat(r, "max_cost"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// queryInt64 reads an optional integer query parameter, nil when absent
+func queryInt64(r *http.Request, name string) (*int64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return &value, nil
+}
+
+// queryFloat reads an optional numeric query parameter, nil when absent
+func queryFloat(r *http.Request, name string) (*float64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return &value, nil
+}
+
+// queryString reads an optional query parameter, nil when absent
+func queryString(r *http.Request, name string) *string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return &value
+	}
+	return nil
+}
+
+// queryInt reads a positive integer query parameter with a default
+func queryInt(r *http.Request, name string, fallback int) int {
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,87 @@
+package dashboard
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/NamanArora/flash-gateway/internal/finetune"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// datasetHandler streams a fine-tuning dataset built from logged request and
+// response pairs matching the search parameters, oldest first. Only successful
+// requests that no guardrail blocked or rewrote are used unless ?guardrail=
+// says otherwise (any to include all); ?limit= caps the number of examples.
+func (d *Dashboard) datasetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	format, err := finetune.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := searchFilter(r)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("guardrail") == "" {
+		passed := storage.GuardrailPassed
+		filter.GuardrailStatus = &passed
+	}
+	if err := timeRange(r, &filter); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	ok, noError := http.StatusOK, false
+	filter.StatusCode = &ok
+	filter.HasError = &noError
+	filter.OrderDir = "ASC"
+	filter.Limit = exportPageSize
+
+	audit.Record(r.Context(), "dataset.exported", "", nil, nil)
+	audit.Detail(r.Context(), "query", r.URL.Query())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="finetune-%s.jsonl"`, time.Now().UTC().Format("20060102-150405")))
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	dataset := finetune.NewWriter(w, format)
+	for limit == 0 || dataset.Written() < limit {
+		logs, err := d.backend.GetRequestLogs(r.Context(), filter)
+		if err != nil {
+			if dataset.Written() == 0 {
+				admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		for _, entry := range logs {
+			if err := dataset.Write(entry); err != nil {
+				return // Client went away
+			}
+			if limit > 0 && dataset.Written() >= limit {
+				break
+			}
+		}
+		controller.Flush()
+		if len(logs) < filter.Limit {
+			break
+		}
+		filter.After = storage.CursorAfter(logs[len(logs)-1])
+	}
+}
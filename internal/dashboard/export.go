@@ -0,0 +1,320 @@
+package dashboard
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/NamanArora/flash-gateway/internal/parquet"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// exportPageSize is how many logs are read from storage at a time
+const exportPageSize = 500
+
+// exportColumns are the fields of each exported log in CSV and Parquet
+var exportColumns = []parquet.Column{
+	{Name: "id", Type: parquet.String},
+	{Name: "request_id", Type: parquet.String},
+	{Name: "timestamp", Type: parquet.Timestamp},
+	{Name: "endpoint", Type: parquet.String},
+	{Name: "method", Type: parquet.String},
+	{Name: "status_code", Type: parquet.Int64},
+	{Name: "latency_ms", Type: parquet.Int64},
+	{Name: "provider", Type: parquet.String},
+	{Name: "model", Type: parquet.String},
+	{Name: "tenant_id", Type: parquet.String},
+	{Name: "conversation_id", Type: parquet.String},
+	{Name: "session_id", Type: parquet.String},
+	{Name: "prompt_tokens", Type: parquet.Int64},
+	{Name: "completion_tokens", Type: parquet.Int64},
+	{Name: "cost", Type: parquet.Double},
+	{Name: "error", Type: parquet.String},
+	{Name: "request_body", Type: parquet.String},
+	{Name: "response_body", Type: parquet.String},
+	{Name: "metadata", Type: parquet.String}, // JSON
+}
+
+// rowWriter writes exported logs in one format
+type rowWriter interface {
+	write(entry *storage.RequestLog) error
+	close() error
+}
+
+// exportHandler streams logs matching the search parameters, oldest first, as
+// CSV, NDJSON or Parquet. Storage is read a page at a time by cursor, so
+// exports of any size use constant memory; ?after= resumes past a given log
+// and ?limit= caps the rows, with the cursor to continue from sent as the
+// X-Next-Cursor trailer.
+func (d *Dashboard) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := searchFilter(r)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := timeRange(r, &filter); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if raw := r.URL.Query().Get("after"); raw != "" {
+		if filter.After, err = storage.ParseLogCursor(raw); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	withBodies := r.URL.Query().Get("bodies") != "false"
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	var rows rowWriter
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rows = &ndjsonRows{encoder: json.NewEncoder(w), bodies: withBodies}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		rows = newCSVRows(w, withBodies)
+	case "parquet":
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		rows = &parquetRows{out: w, bodies: withBodies}
+	default:
+		admin.WriteError(w, http.StatusBadRequest, "format must be csv, ndjson or parquet")
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+	w.Header().Set("Trailer", "X-Next-Cursor")
+	audit.Record(r.Context(), "logs.exported", "", nil, nil)
+	audit.Detail(r.Context(), "query", r.URL.Query())
+
+	// Large exports outlast the admin listener's write timeout
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	filter.OrderDir = "ASC"
+	written := 0
+	for {
+		filter.Limit = exportPageSize
+		if limit > 0 && limit-written < exportPageSize {
+			filter.Limit = limit - written
+		}
+		logs, err := d.backend.GetRequestLogs(r.Context(), filter)
+		if err != nil {
+			// Headers are sent with the first row, so a late failure can only cut the export short
+			if written == 0 {
+				admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		for _, entry := range logs {
+			if err := rows.write(entry); err != nil {
+				return // Client went away
+			}
+		}
+		written += len(logs)
+		if len(logs) > 0 {
+			filter.After = storage.CursorAfter(logs[len(logs)-1])
+		}
+		controller.Flush()
+		if len(logs) < filter.Limit {
+			break // Nothing more to export
+		}
+		if limit > 0 && written >= limit {
+			w.Header().Set("X-Next-Cursor", filter.After.String())
+			break
+		}
+	}
+	rows.close()
+}
+
+// timeRange reads the optional start and end parameters into a filter
+func timeRange(r *http.Request, filter *storage.LogFilter) error {
+	for name, target := range map[string]**time.Time{"start": &filter.StartTime, "end": &filter.EndTime} {
+		if raw := r.URL.Query().Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: use an RFC 3339 time", name)
+			}
+			*target = &t
+		}
+	}
+	return nil
+}
+
+// ndjsonRows writes each log as a JSON object per line
+type ndjsonRows struct {
+	encoder *json.Encoder
+	bodies  bool
+}
+
+func (n *ndjsonRows) write(entry *storage.RequestLog) error {
+	if !n.bodies {
+		entry.RequestBody, entry.ResponseBody = nil, nil
+	}
+	return n.encoder.Encode(entry)
+}
+
+func (n *ndjsonRows) close() error { return nil }
+
+// csvRows writes logs as CSV with a header row
+type csvRows struct {
+	writer *csv.Writer
+	bodies bool
+	header bool
+}
+
+func newCSVRows(w http.ResponseWriter, bodies bool) *csvRows {
+	return &csvRows{writer: csv.NewWriter(w), bodies: bodies}
+}
+
+// writeHeader writes the column names once
+func (c *csvRows) writeHeader() {
+	if c.header {
+		return
+	}
+	names := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		names[i] = column.Name
+	}
+	c.writer.Write(names)
+	c.header = true
+}
+
+func (c *csvRows) write(entry *storage.RequestLog) error {
+	c.writeHeader()
+	values := exportRow(entry, c.bodies)
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			record[i] = v
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if err := c.writer.Write(record); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvRows) close() error {
+	c.writeHeader()
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// parquetRows writes logs as a Parquet file, started on the first row so a
+// storage error before then can still be reported as JSON
+type parquetRows struct {
+	out    http.ResponseWriter
+	writer *parquet.Writer
+	bodies bool
+}
+
+// start begins the file on the first row, or on close for an empty export
+func (p *parquetRows) start() error {
+	if p.writer != nil {
+		return nil
+	}
+	var err error
+	p.writer, err = parquet.NewWriter(p.out, exportColumns, 0)
+	return err
+}
+
+func (p *parquetRows) write(entry *storage.RequestLog) error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	return p.writer.Write(exportRow(entry, p.bodies))
+}
+
+func (p *parquetRows) close() error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	return p.writer.Close()
+}
+
+// exportRow flattens a log into values for exportColumns
+func exportRow(entry *storage.RequestLog, bodies bool) []interface{} {
+	row := []interface{}{
+		entry.ID.String(),
+		entry.RequestID.String(),
+		entry.Timestamp,
+		entry.Endpoint,
+		entry.Method,
+		nil, nil, // status_code, latency_ms
+		optional(entry.Provider),
+		nil, // model
+		optional(entry.TenantID),
+		optional(entry.ConversationID),
+		optional(entry.SessionID),
+		nil, nil, nil, // prompt_tokens, completion_tokens, cost
+		optional(entry.Error),
+		nil, nil, // request_body, response_body
+		nil, // metadata
+	}
+	if entry.StatusCode != nil {
+		row[5] = int64(*entry.StatusCode)
+	}
+	if entry.LatencyMs != nil {
+		row[6] = *entry.LatencyMs
+	}
+	if breakdown, ok := entry.Metadata["cost"].(map[string]interface{}); ok {
+		if model, ok := breakdown["model"].(string); ok && model != "" {
+			row[8] = model
+		}
+		if n, ok := breakdown["prompt_tokens"].(float64); ok {
+			row[12] = int64(n)
+		}
+		if n, ok := breakdown["completion_tokens"].(float64); ok {
+			row[13] = int64(n)
+		}
+		if n, ok := breakdown["total_cost"].(float64); ok {
+			row[14] = n
+		}
+	}
+	if bodies {
+		row[16] = optional(entry.RequestBody)
+		row[17] = optional(entry.ResponseBody)
+	}
+	if entry.Metadata != nil {
+		if metadata, err := json.Marshal(entry.Metadata); err == nil {
+			row[18] = string(metadata)
+		}
+	}
+	return row
+}
+
+// optional returns a string pointer's value, or nil for a null column
+func optional(value *string) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
@@ -0,0 +1,177 @@
+(function () {
+  'use strict';
+
+  const TOKEN_KEY = 'flash-gateway-admin-token';
+  const $ = (id) => document.getElementById(id);
+
+  function token() {
+    return localStorage.getItem(TOKEN_KEY);
+  }
+
+  async function api(path) {
+    const resp = await fetch(path, { headers: { Authorization: 'Bearer ' + token() } });
+    if (resp.status === 401) {
+      localStorage.removeItem(TOKEN_KEY);
+      showLogin();
+      throw new Error('Admin token rejected');
+    }
+    if (!resp.ok) {
+      const body = await resp.json().catch(() => ({}));
+      throw new Error(body.message || resp.statusText);
+    }
+    return resp.json();
+  }
+
+  function showLogin() {
+    $('login').hidden = false;
+    $('content').hidden = true;
+  }
+
+  function showContent() {
+    $('login').hidden = true;
+    $('content').hidden = false;
+  }
+
+  function cell(text, className) {
+    const td = document.createElement('td');
+    td.textContent = text === undefined || text === null ? '-' : String(text);
+    if (className) td.className = className;
+    return td;
+  }
+
+  function fillTable(id, rows) {
+    const body = document.querySelector('#' + id + ' tbody');
+    body.replaceChildren(...rows.map((cells) => {
+      const tr = document.createElement('tr');
+      tr.append(...cells);
+      return tr;
+    }));
+  }
+
+  function pct(value) {
+    return (value * 100).toFixed(1) + '%';
+  }
+
+  function isError(entry) {
+    return (entry.status_code || 0) >= 400 || !!entry.error;
+  }
+
+  function blockOf(entry) {
+    return entry.metadata && entry.metadata.guardrail_block;
+  }
+
+  function renderStats(stats) {
+    $('total').textContent = stats.total_requests;
+    $('rph').textContent = stats.requests_per_hour;
+    $('latency').textContent = Math.round(stats.average_latency_ms) + ' ms';
+    $('errors').textContent = pct(stats.error_rate);
+
+    fillTable('endpoints', (stats.top_endpoints || []).map((e) => [
+      cell(e.endpoint),
+      cell(e.request_count),
+      cell(Math.round(e.average_latency_ms) + ' ms'),
+      cell(pct(e.error_rate), e.error_rate > 0.05 ? 'status-error' : ''),
+    ]));
+  }
+
+  function renderLogs(logs) {
+    const blocks = logs.filter(blockOf);
+    $('blocks').textContent = blocks.length;
+
+    fillTable('guardrails', blocks.slice(0, 50).map((entry) => {
+      const block = blockOf(entry);
+      return [
+        cell(new Date(entry.timestamp).toLocaleString()),
+        cell(block.layer),
+        cell(block.guardrail, 'blocked'),
+        cell(block.reason, 'reason'),
+      ];
+    }));
+
+    const providers = {};
+    logs.forEach((entry) => {
+      const name = entry.provider || 'unknown';
+      providers[name] = providers[name] || { total: 0, errors: 0 };
+      providers[name].total++;
+      if (isError(entry)) providers[name].errors++;
+    });
+    fillTable('providers', Object.keys(providers).sort().map((name) => {
+      const p = providers[name];
+      const rate = p.total ? p.errors / p.total : 0;
+      return [cell(name), cell(p.total), cell(p.errors), cell(pct(rate), rate > 0.05 ? 'status-error' : '')];
+    }));
+
+    fillTable('requests', logs.slice(0, 100).map((entry) => {
+      const block = blockOf(entry);
+      return [
+        cell(new Date(entry.timestamp).toLocaleString()),
+        cell(entry.method),
+        cell(entry.endpoint),
+        cell(entry.status_code, isError(entry) ? 'status-error' : 'status-ok'),
+        cell(entry.latency_ms !== undefined ? entry.latency_ms + ' ms' : null),
+        cell(entry.provider),
+        cell(block ? block.guardrail : '', block ? 'blocked' : ''),
+      ];
+    }));
+
+    renderLatencyChart(logs.slice(0, 120).reverse());
+  }
+
+  function renderLatencyChart(logs) {
+    const svg = $('latency-chart');
+    const ns = 'http://www.w3.org/2000/svg';
+    const max = Math.max(1, ...logs.map((e) => e.latency_ms || 0));
+    const width = 600 / Math.max(logs.length, 1);
+
+    svg.replaceChildren(...logs.map((entry, i) => {
+      const height = Math.max(1, ((entry.latency_ms || 0) / max) * 150);
+      const rect = document.createElementNS(ns, 'rect');
+      rect.setAttribute('x', i * width);
+      rect.setAttribute('y', 160 - height);
+      rect.setAttribute('width', Math.max(width - 1, 1));
+      rect.setAttribute('height', height);
+      if (isError(entry)) rect.setAttribute('class', 'error');
+      const title = document.createElementNS(ns, 'title');
+      title.textContent = entry.endpoint + ' - ' + (entry.latency_ms || 0) + ' ms';
+      rect.appendChild(title);
+      return rect;
+    }));
+  }
+
+  async function refresh() {
+    if (!token()) {
+      showLogin();
+      return;
+    }
+    const hours = $('window').value;
+    try {
+      const [stats, logs] = await Promise.all([
+        api('api/stats?hours=' + hours),
+        api('api/logs?limit=500&hours=' + hours),
+      ]);
+      showContent();
+      renderStats(stats);
+      renderLogs(logs);
+      $('error').hidden = true;
+    } catch (err) {
+      $('error').textContent = err.message;
+      $('error').hidden = false;
+    }
+  }
+
+  $('login-form').addEventListener('submit', (event) => {
+    event.preventDefault();
+    localStorage.setItem(TOKEN_KEY, $('token').value);
+    $('token').value = '';
+    refresh();
+  });
+  $('logout').addEventListener('click', () => {
+    localStorage.removeItem(TOKEN_KEY);
+    showLogin();
+  });
+  $('refresh').addEventListener('click', refresh);
+  $('window').addEventListener('change', refresh);
+
+  refresh();
+  setInterval(refresh, 15000);
+})();
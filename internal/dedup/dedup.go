@@ -0,0 +1,339 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// Header marks a response shared from an identical request, naming that
+// request when it was logged
+const Header = "X-Flash-Deduplicated"
+
+// requestIDHeader is the canonical form of the header capture sets
+const requestIDHeader = "X-Flash-Request-Id"
+
+const (
+	defaultWindow          = 2 * time.Second
+	defaultMaxBodySize     = 1 << 20
+	defaultMaxResponseSize = 10 << 20
+)
+
+// call is one upstream call and the identical requests waiting on it
+type call struct {
+	done      chan struct{}
+	requestID string
+
+	// Set before done is closed
+	status  int
+	header  http.Header
+	body    []byte
+	shared  bool // The response was recorded whole and can be replayed
+	expires time.Time
+}
+
+// Coalescer collapses identical requests from the same caller into one
+// upstream call. Requests arriving while the call is in flight wait for it,
+// and those arriving within the window after it finished get its response.
+type Coalescer struct {
+	window          time.Duration
+	endpoints       guardrails.Applicability
+	maxBodySize     int64
+	maxResponseSize int
+
+	mu        sync.Mutex
+	calls     map[string]*call
+	lastSweep time.Time
+
+	upstream  uint64 // Requests that made their own call
+	coalesced uint64 // Requests that waited on an in-flight call
+	replayed  uint64 // Requests answered from a finished call
+}
+
+// New creates a coalescer from configuration
+func New(cfg config.DedupConfig) (*Coalescer, error) {
+	window := defaultWindow
+	if cfg.Window != "" {
+		parsed, err := time.ParseDuration(cfg.Window)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid window %q", cfg.Window)
+		}
+		window = parsed
+	}
+	if cfg.MaxBodySize < 0 || cfg.MaxResponseSize < 0 {
+		return nil, fmt.Errorf("max_body_size and max_response_size must not be negative")
+	}
+
+	c := &Coalescer{
+		window:          window,
+		endpoints:       guardrails.Applicability{Endpoints: cfg.Endpoints},
+		maxBodySize:     cfg.MaxBodySize,
+		maxResponseSize: cfg.MaxResponseSize,
+		calls:           make(map[string]*call),
+		lastSweep:       time.Now(),
+	}
+	if c.maxBodySize == 0 {
+		c.maxBodySize = defaultMaxBodySize
+	}
+	if c.maxResponseSize == 0 {
+		c.maxResponseSize = defaultMaxResponseSize
+	}
+	return c, nil
+}
+
+// Middleware answers requests identical to one in flight, or one that
+// finished within the window, with that request's response. Only buffered
+// POST requests are deduplicated; streamed responses are never shared.
+func (c *Coalescer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.eligible(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := middleware.ReadBody(r, c.maxBodySize)
+		if err != nil {
+			log.Printf("[DEDUP] Error reading request body: %v", err)
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		if middleware.RequestBodyFromContext(r.Context()) == nil {
+			// Without capture the body isn't shared, so hand the handler a fresh copy
+			r.Body = io.NopCloser(strings.NewReader(body))
+		}
+		if isStreaming(body) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := requestKey(r, body)
+		leader, existing := c.join(key)
+		if existing != nil {
+			if c.follow(w, r, existing) {
+				return
+			}
+			// The response couldn't be shared, so make the call after all
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.lead(w, r, next, key, leader)
+	})
+}
+
+// eligible reports whether a request may be deduplicated at all
+func (c *Coalescer) eligible(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.ContentLength <= 0 || r.ContentLength > c.maxBodySize {
+		return false
+	}
+	if !middleware.IsTextContent(r.Header.Get("Content-Type")) {
+		return false
+	}
+	return c.endpoints.IsEmpty() || c.endpoints.Matches(guardrails.Scope{Endpoint: r.URL.Path})
+}
+
+// join returns the call an identical request is making or made within the
+// window, or registers a new call for this request to make
+func (c *Coalescer) join(key string) (*call, *call) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.calls[key]; ok {
+		select {
+		case <-existing.done:
+			if now.Before(existing.expires) {
+				c.replayed++
+				return nil, existing
+			}
+		default:
+			c.coalesced++
+			return nil, existing
+		}
+	}
+
+	if now.Sub(c.lastSweep) >= c.window {
+		for k, finished := range c.calls {
+			select {
+			case <-finished.done:
+				if !now.Before(finished.expires) {
+					delete(c.calls, k)
+				}
+			default:
+			}
+		}
+		c.lastSweep = now
+	}
+
+	leader := &call{done: make(chan struct{})}
+	c.calls[key] = leader
+	c.upstream++
+	return leader, nil
+}
+
+// lead makes the upstream call for a request, recording the response for
+// identical requests
+func (c *Coalescer) lead(w http.ResponseWriter, r *http.Request, next http.Handler, key string, leader *call) {
+	if requestID, ok := r.Context().Value("request_id").(uuid.UUID); ok {
+		leader.requestID = requestID.String()
+	}
+	recorder := &teeWriter{ResponseWriter: w, status: http.StatusOK, limit: c.maxResponseSize}
+	completed := false
+
+	defer func() {
+		leader.status = recorder.status
+		leader.header = w.Header().Clone()
+		leader.body = recorder.body
+		// Streams and oversized bodies weren't recorded whole, and server
+		// errors and panics are left for retries to try again once the call is over
+		leader.shared = completed && !recorder.overflow && !recorder.flushed && recorder.status < http.StatusInternalServerError
+		leader.expires = time.Now().Add(c.window)
+		close(leader.done)
+		if !leader.shared {
+			c.mu.Lock()
+			if c.calls[key] == leader {
+				delete(c.calls, key)
+			}
+			c.mu.Unlock()
+		}
+	}()
+	next.ServeHTTP(recorder, r)
+	completed = true
+}
+
+// follow waits for a call and writes its response, reporting false when the
+// response can't be shared
+func (c *Coalescer) follow(w http.ResponseWriter, r *http.Request, existing *call) bool {
+	start := time.Now()
+	select {
+	case <-existing.done:
+	case <-r.Context().Done():
+		return true // The client went away while waiting
+	}
+	if !existing.shared {
+		return false
+	}
+
+	middleware.AddLogMetadata(r.Context(), "deduplicated", map[string]interface{}{
+		"request_id": existing.requestID,
+		"waited_ms":  time.Since(start).Milliseconds(),
+	})
+	for name, values := range existing.header {
+		// The follower keeps its own request ID, naming its own log entry
+		if name == requestIDHeader {
+			continue
+		}
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	if existing.requestID != "" {
+		w.Header().Set(Header, existing.requestID)
+	} else {
+		w.Header().Set(Header, "true")
+	}
+	w.WriteHeader(existing.status)
+	if _, err := w.Write(existing.body); err != nil {
+		log.Printf("[DEDUP] Error writing shared response: %v", err)
+	}
+	return true
+}
+
+// Status returns counts of deduplicated requests for status endpoints
+func (c *Coalescer) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inFlight := 0
+	for _, existing := range c.calls {
+		select {
+		case <-existing.done:
+		default:
+			inFlight++
+		}
+	}
+	return map[string]interface{}{
+		"window":    c.window.String(),
+		"in_flight": inFlight,
+		"upstream":  c.upstream,
+		"coalesced": c.coalesced,
+		"replayed":  c.replayed,
+	}
+}
+
+// requestKey hashes what makes two requests identical: the caller's
+// credentials, the method, the path and query, the encodings they accept
+// and the body
+func requestKey(r *http.Request, body string) string {
+	h := sha256.New()
+	for _, part := range []string{
+		r.Header.Get("Authorization"),
+		r.Header.Get("x-api-key"),
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get("Accept-Encoding"), // Shared bodies are encoded for the leader
+		body,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isStreaming reports whether a request asks for a streamed response
+func isStreaming(body string) bool {
+	var payload struct {
+		Stream bool `json:"stream"`
+	}
+	return json.Unmarshal([]byte(body), &payload) == nil && payload.Stream
+}
+
+// teeWriter writes a response through to the client while recording it
+type teeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        []byte
+	limit       int
+	overflow    bool // The body outgrew limit and was not recorded
+	flushed     bool // The response was streamed
+}
+
+func (t *teeWriter) WriteHeader(status int) {
+	if !t.wroteHeader {
+		t.status = status
+		t.wroteHeader = true
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	t.wroteHeader = true
+	if !t.overflow {
+		if len(t.body)+len(p) > t.limit {
+			t.overflow, t.body = true, nil
+		} else {
+			t.body = append(t.body, p...)
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *teeWriter) Flush() {
+	t.flushed = true
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the client's writer to http.ResponseController
+func (t *teeWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package drain
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// State of a drain
+const (
+	StateServing  = "serving"  // Accepting requests
+	StateDraining = "draining" // Rejecting new requests, waiting on in-flight work
+	StateDrained  = "drained"  // In-flight requests finished and buffered logs and metrics flushed
+)
+
+// Controller stops new proxy requests from being accepted while letting those
+// in flight finish, for zero-drop rollouts
+type Controller struct {
+	mu        sync.Mutex
+	state     string
+	inFlight  int
+	idle      chan struct{} // Closed when draining and nothing is in flight
+	startedAt time.Time
+	drainedAt time.Time
+	flushes   []func()
+	done      chan struct{} // Closed once the drain, including flushes, completes
+}
+
+// New creates a controller that is serving traffic
+func New() *Controller {
+	return &Controller{state: StateServing}
+}
+
+// OnDrained registers work to run once in-flight requests have finished, such
+// as flushing buffered logs and metrics
+func (c *Controller) OnDrained(flush func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushes = append(c.flushes, flush)
+}
+
+// Track is a middleware that counts in-flight requests and answers new ones
+// with 503 and Retry-After once draining has begun
+func (c *Controller) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if c.state != StateServing {
+			c.mu.Unlock()
+			w.Header().Set("Retry-After", "5")
+			w.Header().Set("Connection", "close") // Move keep-alive clients to another instance
+			http.Error(w, "Gateway is draining, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		c.inFlight++
+		c.mu.Unlock()
+
+		defer c.finish()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// finish marks a request as done
+func (c *Controller) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if c.inFlight == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
+// Drain stops accepting requests and waits for those in flight, then runs the
+// registered flushes. It is safe to call more than once; later callers wait
+// for the same drain.
+func (c *Controller) Drain(ctx context.Context) error {
+	select {
+	case <-c.Start():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start begins draining without waiting, returning a channel closed once the
+// drain completes
+func (c *Controller) Start() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StateServing {
+		c.state = StateDraining
+		c.startedAt = time.Now()
+		c.done = make(chan struct{})
+		idle := make(chan struct{})
+		if c.inFlight == 0 {
+			close(idle)
+		} else {
+			c.idle = idle
+		}
+		log.Printf("[DRAIN] Draining, waiting on %d in-flight requests", c.inFlight)
+		go c.complete(idle)
+	}
+	return c.done
+}
+
+// complete runs the flushes once nothing is in flight
+func (c *Controller) complete(idle chan struct{}) {
+	<-idle
+
+	c.mu.Lock()
+	flushes := c.flushes
+	c.mu.Unlock()
+	for _, flush := range flushes {
+		flush()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = StateDrained
+	c.drainedAt = time.Now()
+	close(c.done)
+	log.Printf("[DRAIN] Drained in %v", c.drainedAt.Sub(c.startedAt).Round(time.Millisecond))
+}
+
+// Draining reports whether new requests are being turned away
+func (c *Controller) Draining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state != StateServing
+}
+
+// Status returns the drain state for status endpoints
+func (c *Controller) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := map[string]interface{}{
+		"state":     c.state,
+		"in_flight": c.inFlight,
+	}
+	if !c.startedAt.IsZero() {
+		status["started_at"] = c.startedAt
+	}
+	if !c.drainedAt.IsZero() {
+		status["drained_at"] = c.drainedAt
+	}
+	return status
+}
@@ -0,0 +1,149 @@
+package feedback
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/google/uuid"
+)
+
+// dimensions maps summary group_by values to expressions over the joined
+// request log (l) and feedback (f)
+var dimensions = map[string]string{
+	"model":    "COALESCE(l.metadata->'cost'->>'model', l.metadata->'token_estimate'->>'model', '')",
+	"endpoint": "l.endpoint",
+	"provider": "COALESCE(l.provider, '')",
+	"key":      "COALESCE(f.api_key_id, '')",
+	"tenant":   "COALESCE(l.tenant_id, '')",
+	// Traffic split variant, for comparing prompt or model experiments
+	"variant": "COALESCE(l.metadata->'traffic_split'->>'split' || '/' || (l.metadata->'traffic_split'->>'variant'), '')",
+	"guardrail": `CASE
+		WHEN l.metadata->'guardrail_block' IS NOT NULL THEN 'blocked'
+		WHEN l.metadata->'response_modified_by' IS NOT NULL THEN 'modified'
+		ELSE 'passed' END`,
+}
+
+// SummaryRow is the feedback on one group of requests
+type SummaryRow struct {
+	Group      map[string]string `json:"group"`
+	Feedback   int64             `json:"feedback"`
+	Up         int64             `json:"up"`
+	Down       int64             `json:"down"`
+	UpRate     *float64          `json:"up_rate,omitempty"` // Up / (Up + Down)
+	AvgScore   *float64          `json:"avg_score,omitempty"`
+	AvgLatency *float64          `json:"avg_latency_ms,omitempty"`
+}
+
+// Register mounts the feedback lookup and summary on the admin server
+func (s *Store) Register(server *admin.Server) {
+	server.HandleFuncRole("/admin/feedback/", admin.RoleAnalyst, s.feedbackHandler)
+}
+
+// feedbackHandler serves GET /admin/feedback/summary and GET /admin/feedback/{request_id}
+func (s *Store) feedbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/feedback/")
+	if id == "summary" {
+		s.summaryHandler(w, r)
+		return
+	}
+
+	requestID, err := uuid.Parse(id)
+	if err != nil {
+		admin.WriteError(w, http.StatusNotFound, "request id required")
+		return
+	}
+	f, err := s.Get(r.Context(), requestID)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if f == nil {
+		admin.WriteError(w, http.StatusNotFound, "no feedback for this request")
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, f)
+}
+
+// summaryHandler correlates feedback with what the gateway logged about each
+// request, e.g. GET /admin/feedback/summary?group_by=model,guardrail&hours=168
+func (s *Store) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	groupBy := []string{"model"}
+	if value := r.URL.Query().Get("group_by"); value != "" {
+		groupBy = strings.Split(value, ",")
+	}
+	columns := make([]string, 0, len(groupBy))
+	for i, group := range groupBy {
+		groupBy[i] = strings.TrimSpace(group)
+		column, ok := dimensions[groupBy[i]]
+		if !ok {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("cannot group by %q, use model, endpoint, provider, key, tenant, variant or guardrail", group))
+			return
+		}
+		columns = append(columns, column)
+	}
+	hours := 24 * 7
+	if value := r.URL.Query().Get("hours"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			admin.WriteError(w, http.StatusBadRequest, "invalid hours")
+			return
+		}
+		hours = parsed
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %[1]s,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE f.rating > 0),
+			COUNT(*) FILTER (WHERE f.rating < 0),
+			AVG(f.score),
+			AVG(l.latency_ms)
+		FROM request_feedback f
+		JOIN request_logs l ON l.request_id = f.request_id
+		WHERE f.updated_at >= $1
+		GROUP BY %[1]s
+		ORDER BY COUNT(*) DESC`, strings.Join(columns, ", "))
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	rows, err := s.db.QueryContext(r.Context(), query, since)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("failed to summarize feedback: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	summary := []SummaryRow{}
+	for rows.Next() {
+		values := make([]string, len(columns))
+		row := SummaryRow{Group: make(map[string]string, len(columns))}
+		dest := make([]interface{}, 0, len(columns)+5)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &row.Feedback, &row.Up, &row.Down, &row.AvgScore, &row.AvgLatency)
+		if err := rows.Scan(dest...); err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read feedback summary: %v", err))
+			return
+		}
+		for i, group := range groupBy {
+			row.Group[group] = values[i]
+		}
+		if rated := row.Up + row.Down; rated > 0 {
+			rate := float64(row.Up) / float64(rated)
+			row.UpRate = &rate
+		}
+		summary = append(summary, row)
+	}
+	if err := rows.Err(); err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read feedback summary: %v", err))
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,212 @@
+// Package feedback records how clients rate the responses they were given. A
+// rating or score is tied to the gateway's request ID, which is returned in
+// the X-Flash-Request-ID header, so it can be joined to the request log.
+package feedback
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/respond"
+	"github.com/google/uuid"
+)
+
+// Endpoint is where clients submit feedback on the main listener
+const Endpoint = "/v1/feedback"
+
+// maxBodySize caps a feedback submission, comment and metadata included
+const maxBodySize = 64 << 10
+
+// Ratings a client can give a response
+const (
+	Up   = "up"
+	Down = "down"
+)
+
+// ErrNotOwner is returned for feedback on a request made with a different key
+var ErrNotOwner = errors.New("request was made with a different API key")
+
+// Feedback is a client's judgement of one response. At least one of Rating
+// and Score is set; submitting again for the same request replaces it.
+type Feedback struct {
+	RequestID uuid.UUID              `json:"request_id"`
+	Rating    *string                `json:"rating,omitempty"` // Up or Down
+	Score     *float64               `json:"score,omitempty"`  // Any scale the client uses, e.g. 1-5
+	Comment   *string                `json:"comment,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	APIKeyID  string                 `json:"api_key_id,omitempty"` // Fingerprint of the submitting key
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// Validate checks a submission before it is stored
+func (f *Feedback) Validate(maxComment int) error {
+	if f.RequestID == uuid.Nil {
+		return errors.New("request_id is required")
+	}
+	if f.Rating == nil && f.Score == nil {
+		return errors.New("rating or score is required")
+	}
+	if f.Rating != nil && *f.Rating != Up && *f.Rating != Down {
+		return fmt.Errorf("rating must be %s or %s", Up, Down)
+	}
+	if f.Score != nil && (math.IsNaN(*f.Score) || math.IsInf(*f.Score, 0)) {
+		return errors.New("score must be a finite number")
+	}
+	if f.Comment != nil && len(*f.Comment) > maxComment {
+		return fmt.Errorf("comment is longer than %d bytes", maxComment)
+	}
+	return nil
+}
+
+// Store saves feedback to the request_feedback table
+type Store struct {
+	db         *sql.DB
+	maxComment int
+}
+
+// New creates a feedback store from configuration
+func New(db *sql.DB, cfg config.FeedbackConfig) *Store {
+	maxComment := cfg.MaxCommentLength
+	if maxComment <= 0 {
+		maxComment = 4000
+	}
+	return &Store{db: db, maxComment: maxComment}
+}
+
+// Save records feedback, replacing any earlier feedback on the same request.
+// Only the key that made a request may rate it: Save returns ErrNotOwner when
+// the request's log names another key, or when feedback from another key is
+// already stored (the log may not be written yet).
+func (s *Store) Save(ctx context.Context, f *Feedback) error {
+	var owner string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(metadata->>'api_key_id', '') FROM request_logs
+		WHERE request_id = $1 LIMIT 1`, f.RequestID).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("failed to look up request: %w", err)
+	case owner != f.APIKeyID:
+		return ErrNotOwner
+	}
+
+	var metadata []byte
+	if f.Metadata != nil {
+		var err error
+		if metadata, err = json.Marshal(f.Metadata); err != nil {
+			return fmt.Errorf("failed to marshal feedback metadata: %w", err)
+		}
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO request_feedback (request_id, rating, score, comment, metadata, api_key_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (request_id) DO UPDATE SET
+			rating = EXCLUDED.rating,
+			score = EXCLUDED.score,
+			comment = EXCLUDED.comment,
+			metadata = EXCLUDED.metadata,
+			updated_at = NOW()
+		WHERE request_feedback.api_key_id IS NOT DISTINCT FROM EXCLUDED.api_key_id
+		RETURNING created_at, updated_at`,
+		f.RequestID, ratingValue(f.Rating), f.Score, f.Comment, metadata, f.APIKeyID)
+	err = row.Scan(&f.CreatedAt, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotOwner
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save feedback: %w", err)
+	}
+	return nil
+}
+
+// Get returns the feedback on a request, or nil when there is none
+func (s *Store) Get(ctx context.Context, requestID uuid.UUID) (*Feedback, error) {
+	f := &Feedback{RequestID: requestID}
+	var rating sql.NullInt16
+	var score sql.NullFloat64
+	var comment, keyID sql.NullString
+	var metadata []byte
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT rating, score, comment, metadata, api_key_id, created_at, updated_at
+		FROM request_feedback WHERE request_id = $1`, requestID).
+		Scan(&rating, &score, &comment, &metadata, &keyID, &f.CreatedAt, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feedback: %w", err)
+	}
+
+	if rating.Valid {
+		value := Down
+		if rating.Int16 > 0 {
+			value = Up
+		}
+		f.Rating = &value
+	}
+	if score.Valid {
+		f.Score = &score.Float64
+	}
+	if comment.Valid {
+		f.Comment = &comment.String
+	}
+	f.APIKeyID = keyID.String
+	if len(metadata) > 0 {
+		json.Unmarshal(metadata, &f.Metadata)
+	}
+	return f, nil
+}
+
+// ServeHTTP accepts feedback from clients: POST /v1/feedback with
+// {"request_id": "...", "rating": "up", "score": 4, "comment": "..."}
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respond.Error(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+
+	var f Feedback
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&f); err != nil {
+		respond.Error(w, http.StatusBadRequest, "Invalid feedback: "+err.Error(), "")
+		return
+	}
+	if err := f.Validate(s.maxComment); err != nil {
+		respond.Error(w, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+	f.APIKeyID = middleware.APIKeyID(r)
+
+	err := s.Save(r.Context(), &f)
+	if errors.Is(err, ErrNotOwner) {
+		respond.Error(w, http.StatusForbidden, "Feedback can only be given on your own requests", "")
+		return
+	}
+	if err != nil {
+		respond.Error(w, http.StatusInternalServerError, "Failed to record feedback", "")
+		return
+	}
+	respond.JSON(w, http.StatusOK, f)
+}
+
+// ratingValue stores a rating as 1 or -1
+func ratingValue(rating *string) interface{} {
+	if rating == nil {
+		return nil
+	}
+	if *rating == Up {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,165 @@
+package feedback
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/google/uuid"
+)
+
+// fakeDB answers the two queries Save makes from in-memory tables, with the
+// ownership semantics of the SQL
+type fakeDB struct {
+	mu       sync.Mutex
+	logs     map[string]string // request_logs: request ID -> api_key_id
+	feedback map[string]string // request_feedback: request ID -> api_key_id
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	requestID := args[0].Value.(string)
+	query = strings.TrimSpace(query)
+
+	switch {
+	case strings.HasPrefix(query, "SELECT COALESCE(metadata->>'api_key_id', '') FROM request_logs"):
+		owner, ok := c.db.logs[requestID]
+		if !ok {
+			return &fakeRows{columns: 1}, nil
+		}
+		return &fakeRows{columns: 1, values: [][]driver.Value{{owner}}}, nil
+
+	case strings.HasPrefix(query, "INSERT INTO request_feedback"):
+		key := args[5].Value.(string) // NULLIF($6, ''): no key is NULL, as is a stored empty key
+		if existing, ok := c.db.feedback[requestID]; ok && existing != key {
+			return &fakeRows{columns: 2}, nil // The conflict update's WHERE excluded the row
+		}
+		c.db.feedback[requestID] = key
+		now := time.Now()
+		return &fakeRows{columns: 2, values: [][]driver.Value{{now, now}}}, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeRows struct {
+	columns int
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return make([]string, r.columns) }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestServeHTTPOwnership(t *testing.T) {
+	logged := uuid.New()
+	rated := uuid.New()
+	ratedByJWT := uuid.New()
+	unlogged := uuid.New()
+	keyless := uuid.New()
+
+	alice := storage.APIKeyID("sk-alice")
+	jwtRequest := httptest.NewRequest(http.MethodPost, Endpoint, nil)
+	jwtAlice := middleware.APIKeyID(jwtRequest.WithContext(middleware.WithCallerKey(jwtRequest.Context(), "jwt:alice")))
+	db := &fakeDB{
+		logs: map[string]string{
+			logged.String():     alice,
+			ratedByJWT.String(): jwtAlice,
+			keyless.String():    "",
+		},
+		feedback: map[string]string{
+			rated.String(): alice,
+		},
+	}
+	store := New(sql.OpenDB(db), config.FeedbackConfig{})
+
+	tests := []struct {
+		name      string
+		requestID uuid.UUID
+		apiKey    string
+		jwtKey    string // Set as the caller key, as JWT authentication does
+		want      int
+	}{
+		{name: "own logged request", requestID: logged, apiKey: "sk-alice", want: http.StatusOK},
+		{name: "another key's logged request", requestID: logged, apiKey: "sk-mallory", want: http.StatusForbidden},
+		{name: "unauthenticated on a logged request", requestID: logged, want: http.StatusForbidden},
+		{name: "rating again with the same key", requestID: rated, apiKey: "sk-alice", want: http.StatusOK},
+		{name: "overwriting another key's feedback before the log is written", requestID: rated, apiKey: "sk-mallory", want: http.StatusForbidden},
+		{name: "first feedback before the log is written", requestID: unlogged, apiKey: "sk-bob", want: http.StatusOK},
+		{name: "overwriting that feedback with another key", requestID: unlogged, apiKey: "sk-alice", want: http.StatusForbidden},
+		{name: "JWT caller on own request", requestID: ratedByJWT, apiKey: "eyJ.token.sig", jwtKey: "alice", want: http.StatusOK},
+		{name: "raw key can't pass for a JWT caller", requestID: ratedByJWT, apiKey: "jwt:alice", want: http.StatusForbidden},
+		{name: "keyless request by a keyless caller", requestID: keyless, want: http.StatusOK},
+		{name: "keyless request by a keyed caller", requestID: keyless, apiKey: "sk-alice", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"request_id": "` + tt.requestID.String() + `", "rating": "up"}`
+			r := httptest.NewRequest(http.MethodPost, Endpoint, strings.NewReader(body))
+			if tt.apiKey != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.apiKey)
+			}
+			if tt.jwtKey != "" {
+				r = r.WithContext(middleware.WithCallerKey(r.Context(), "jwt:"+tt.jwtKey))
+			}
+			w := httptest.NewRecorder()
+			store.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	up, sideways := Up, "sideways"
+	score, comment := 4.0, strings.Repeat("x", 11)
+
+	tests := []struct {
+		name     string
+		feedback Feedback
+		wantErr  bool
+	}{
+		{name: "rating", feedback: Feedback{RequestID: uuid.New(), Rating: &up}},
+		{name: "score", feedback: Feedback{RequestID: uuid.New(), Score: &score}},
+		{name: "missing request ID", feedback: Feedback{Rating: &up}, wantErr: true},
+		{name: "neither rating nor score", feedback: Feedback{RequestID: uuid.New()}, wantErr: true},
+		{name: "unknown rating", feedback: Feedback{RequestID: uuid.New(), Rating: &sideways}, wantErr: true},
+		{name: "comment too long", feedback: Feedback{RequestID: uuid.New(), Rating: &up, Comment: &comment}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.feedback.Validate(10); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
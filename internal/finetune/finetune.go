@@ -0,0 +1,193 @@
+// Package finetune turns logged request/response pairs into fine-tuning
+// datasets. Each usable log becomes one training example: the conversation
+// the client sent, followed by the reply the model gave.
+package finetune
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// Format is the layout of each line of a dataset
+type Format string
+
+const (
+	// Chat is OpenAI's chat fine-tuning format: {"messages": [...]}
+	Chat Format = "chat"
+	// Completion is the prompt/completion format: {"prompt": "...", "completion": "..."}
+	Completion Format = "completion"
+)
+
+// ParseFormat validates a format name, defaulting to Chat when empty
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "":
+		return Chat, nil
+	case Chat, Completion:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("format must be %s or %s", Chat, Completion)
+}
+
+// Example extracts a log's conversation, ending with the model's reply. It
+// reports false for logs that do not make a training example: missing bodies,
+// streamed or unparseable responses, embeddings, and replies without text.
+func Example(log *storage.RequestLog) ([]guardrails.Message, bool) {
+	if log.RequestBody == nil || log.ResponseBody == nil {
+		return nil, false
+	}
+	request := guardrails.ParseInput("input", *log.RequestBody, guardrails.Scope{})
+	if request.Batch {
+		return nil, false // Independent inputs, not a conversation
+	}
+
+	var messages []guardrails.Message
+	if system := anthropicSystem(*log.RequestBody); system != "" {
+		messages = append(messages, guardrails.Message{Role: "system", Content: system})
+	}
+	hasUser := false
+	for _, m := range request.Messages {
+		switch m.Role {
+		case "developer":
+			m.Role = "system"
+		case "system", "user", "assistant":
+		default:
+			continue // Tool results lose their call structure when flattened
+		}
+		if strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+		hasUser = hasUser || m.Role == "user"
+		messages = append(messages, m)
+	}
+	if !hasUser {
+		return nil, false
+	}
+
+	reply := replyText(*log.ResponseBody)
+	if strings.TrimSpace(reply) == "" {
+		return nil, false
+	}
+	return append(messages, guardrails.Message{Role: "assistant", Content: reply}), true
+}
+
+// replyText returns the first generated message of an OpenAI or Anthropic response
+func replyText(body string) string {
+	for _, m := range guardrails.ParseInput("output", body, guardrails.Scope{}).Messages {
+		if m.Role == "assistant" && m.Content != "" {
+			return m.Content
+		}
+	}
+
+	// Anthropic Messages responses carry a list of content blocks
+	var response struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil || response.Type != "message" {
+		return ""
+	}
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+// anthropicSystem returns the top-level system prompt of an Anthropic Messages request
+func anthropicSystem(body string) string {
+	var request struct {
+		System json.RawMessage `json:"system"`
+	}
+	if err := json.Unmarshal([]byte(body), &request); err != nil || len(request.System) == 0 {
+		return ""
+	}
+	var system string
+	if json.Unmarshal(request.System, &system) == nil {
+		return system
+	}
+	var blocks []struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(request.System, &blocks)
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		parts = append(parts, block.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Writer writes examples as JSON lines in one format
+type Writer struct {
+	encoder *json.Encoder
+	format  Format
+	written int
+	skipped int
+}
+
+// NewWriter creates a dataset writer
+func NewWriter(out io.Writer, format Format) *Writer {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	return &Writer{encoder: encoder, format: format}
+}
+
+// Write adds a log to the dataset, or skips it when it is not a usable example
+func (w *Writer) Write(log *storage.RequestLog) error {
+	messages, ok := Example(log)
+	if !ok {
+		w.skipped++
+		return nil
+	}
+
+	var line interface{}
+	switch w.format {
+	case Completion:
+		line = map[string]string{
+			"prompt":     completionPrompt(messages[:len(messages)-1]),
+			"completion": messages[len(messages)-1].Content,
+		}
+	default:
+		line = map[string][]guardrails.Message{"messages": messages}
+	}
+	if err := w.encoder.Encode(line); err != nil {
+		return err
+	}
+	w.written++
+	return nil
+}
+
+// Written returns how many examples have been written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Skipped returns how many logs were not usable examples
+func (w *Writer) Skipped() int {
+	return w.skipped
+}
+
+// completionPrompt renders a conversation as a single prompt. A lone user
+// message is used as is; longer conversations are written as a role-labelled
+// transcript ending with the assistant's turn.
+func completionPrompt(messages []guardrails.Message) string {
+	if len(messages) == 1 {
+		return messages[0].Content
+	}
+	var prompt strings.Builder
+	for _, m := range messages {
+		prompt.WriteString(strings.ToUpper(m.Role[:1]) + m.Role[1:] + ": " + m.Content + "\n\n")
+	}
+	prompt.WriteString("Assistant:")
+	return prompt.String()
+}
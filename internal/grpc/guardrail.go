@@ -0,0 +1,404 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"golang.org/x/net/http2"
+)
+
+// Methods of the GuardrailService in proto/flashgateway/v1/guardrail.proto
+const (
+	guardrailServiceName = "flashgateway.v1.GuardrailService"
+	checkMethod          = "/" + guardrailServiceName + "/Check"
+	checkStreamMethod    = "/" + guardrailServiceName + "/CheckStream"
+)
+
+// defaultMaxMessageSize caps a guardrail service's response messages
+const defaultMaxMessageSize = 4 << 20
+
+// GuardrailConfig configures a guardrail that calls an external GuardrailService
+type GuardrailConfig struct {
+	Address        string                 `json:"address"`          // host:port of the service
+	TLS            bool                   `json:"tls"`              // Connect with TLS instead of plaintext HTTP/2
+	CAFile         string                 `json:"ca_file"`          // Extra trusted roots for TLS
+	Stream         bool                   `json:"stream"`           // Call CheckStream instead of Check
+	Metadata       map[string]string      `json:"metadata"`         // Sent with every call, e.g. authorization
+	MaxMessageSize int                    `json:"max_message_size"` // Bytes per response message, default 4MB
+	Settings       map[string]interface{} `json:"settings"`         // Sent to the service as config_json
+}
+
+// Guardrail checks content by calling an external GuardrailService, such as
+// a Python ML classifier running as a sidecar
+type Guardrail struct {
+	name      string
+	priority  int
+	config    GuardrailConfig
+	baseURL   string
+	settings  string
+	transport *http2.Transport
+}
+
+// NewGuardrail creates a guardrail that calls the service at config's address
+func NewGuardrail(name string, priority int, config map[string]interface{}) (*Guardrail, error) {
+	var cfg GuardrailConfig
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid grpc guardrail config: %w", err)
+		}
+	}
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("grpc guardrail requires an address")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("grpc guardrail address must be host:port: %w", err)
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	var settings string
+	if len(cfg.Settings) > 0 {
+		data, err := json.Marshal(cfg.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grpc guardrail settings: %w", err)
+		}
+		settings = string(data)
+	}
+
+	g := &Guardrail{
+		name:     name,
+		priority: priority,
+		config:   cfg,
+		settings: settings,
+	}
+	if cfg.TLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ca_file: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in ca_file %s", cfg.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		g.baseURL = "https://" + cfg.Address
+		g.transport = &http2.Transport{TLSClientConfig: tlsConfig}
+	} else {
+		// Plaintext HTTP/2 (h2c), as sidecars on localhost usually serve
+		var dialer net.Dialer
+		g.baseURL = "http://" + cfg.Address
+		g.transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+	}
+	return g, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (g *Guardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *Guardrail) Priority() int {
+	return g.priority
+}
+
+// Check sends a raw request body to the service
+func (g *Guardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return g.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
+
+// CheckRequest sends the content and its parsed messages to the service and
+// returns its verdict. The time left on ctx is sent as the call's deadline.
+func (g *Guardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	request := &checkRequest{
+		Guardrail:  g.name,
+		Layer:      input.Layer,
+		Content:    input.Raw,
+		Messages:   input.Messages,
+		Endpoint:   input.Endpoint,
+		Provider:   input.Provider,
+		Model:      input.Model,
+		Tenant:     input.Client.Tenant,
+		ConfigJSON: g.settings,
+		UserID:     input.Client.UserID,
+		APIKeyID:   input.Client.APIKeyID,
+	}
+
+	method := checkMethod
+	if g.config.Stream {
+		method = checkStreamMethod
+	}
+
+	// Cancelling the call when a verdict ends a stream early resets the stream,
+	// which tells the service to stop
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	response, err := g.call(ctx, method, request)
+	if err != nil {
+		return nil, err
+	}
+	return response.result()
+}
+
+// call sends one CheckRequest and reads verdicts until one decides the check
+func (g *Guardrail) call(ctx context.Context, method string, request *checkRequest) (*checkResponse, error) {
+	e := encoder{buf: make([]byte, 5, 512)}
+	request.encode(&e)
+	binary.BigEndian.PutUint32(e.buf[1:5], uint32(len(e.buf)-5))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+method, bytes.NewReader(e.buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	for name, value := range g.config.Metadata {
+		req.Header.Set(name, value)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		req.Header.Set("Grpc-Timeout", formatTimeout(remaining))
+	}
+
+	resp, err := g.transport.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("grpc call to %s failed: %w", g.config.Address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("grpc call to %s failed: HTTP status %d", g.config.Address, resp.StatusCode)
+	}
+	// A call that fails before sending anything has its status in the headers
+	if err := callStatus(resp.Header); err != nil {
+		return nil, err
+	}
+
+	var last *checkResponse
+	for {
+		data, err := readFrame(resp.Body, g.config.MaxMessageSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("grpc call to %s failed: %w", g.config.Address, err)
+		}
+		var response checkResponse
+		if err := response.decode(data); err != nil {
+			return nil, fmt.Errorf("invalid CheckResponse: %w", err)
+		}
+		last = &response
+		if method == checkStreamMethod && (!response.Passed || response.Final) {
+			return last, nil
+		}
+	}
+
+	if err := callStatus(resp.Trailer); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, fmt.Errorf("guardrail service at %s returned no verdict", g.config.Address)
+	}
+	return last, nil
+}
+
+// callStatus returns the error a call's grpc-status reports, if any
+func callStatus(metadata http.Header) error {
+	status := metadata.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("invalid grpc-status %q", status)
+	}
+	message, err := url.PathUnescape(metadata.Get("Grpc-Message"))
+	if err != nil {
+		message = metadata.Get("Grpc-Message")
+	}
+	if code == codeDeadlineExceeded {
+		return fmt.Errorf("guardrail service deadline exceeded: %s", message)
+	}
+	return fmt.Errorf("guardrail service returned status %d: %s", code, message)
+}
+
+// readFrame reads one response message, or io.EOF at the end of the stream.
+// No grpc-accept-encoding is sent, so messages must not be compressed.
+func readFrame(r io.Reader, limit int) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTruncated
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed response message, but no compression was offered")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > int64(limit) {
+		return nil, fmt.Errorf("response message larger than %d bytes", limit)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errTruncated
+	}
+	return data, nil
+}
+
+// formatTimeout writes a grpc-timeout header, which allows at most 8 digits
+func formatTimeout(d time.Duration) string {
+	if ms := d.Milliseconds(); ms < 1e8 {
+		if ms == 0 {
+			return strconv.FormatInt(d.Microseconds(), 10) + "u"
+		}
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "S"
+}
+
+// checkRequest is a CheckRequest
+type checkRequest struct {
+	Guardrail  string
+	Layer      string
+	Content    string
+	Messages   []guardrails.Message
+	Endpoint   string
+	Provider   string
+	Model      string
+	Tenant     string
+	ConfigJSON string
+	UserID     string
+	APIKeyID   string
+}
+
+func (r *checkRequest) encode(e *encoder) {
+	e.string(1, r.Guardrail)
+	e.string(2, r.Layer)
+	e.string(3, r.Content)
+	for i := range r.Messages {
+		e.message(4, guardrailMessage(r.Messages[i]))
+	}
+	e.string(5, r.Endpoint)
+	e.string(6, r.Provider)
+	e.string(7, r.Model)
+	e.string(8, r.Tenant)
+	e.string(9, r.ConfigJSON)
+	e.string(10, r.UserID)
+	e.string(11, r.APIKeyID)
+}
+
+// guardrailMessage is a GuardrailMessage
+type guardrailMessage guardrails.Message
+
+func (m guardrailMessage) encode(e *encoder) {
+	e.string(1, m.Role)
+	e.string(2, m.Content)
+}
+
+// checkResponse is a CheckResponse
+type checkResponse struct {
+	Passed          bool
+	Score           *float64
+	Reason          string
+	Category        string
+	ModifiedContent *string
+	MetadataJSON    string
+	Final           bool
+}
+
+func (r *checkResponse) decode(data []byte) error {
+	d := decoder{buf: data}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireVarint:
+			var v uint64
+			v, err = d.varint()
+			r.Passed = v != 0
+		case field == 2 && wireType == wireFixed64:
+			r.Score, err = optionalDouble(&d)
+		case field == 3 && wireType == wireBytes:
+			r.Reason, err = d.string()
+		case field == 4 && wireType == wireBytes:
+			r.Category, err = d.string()
+		case field == 5 && wireType == wireBytes:
+			var content string
+			content, err = d.string()
+			r.ModifiedContent = &content
+		case field == 6 && wireType == wireBytes:
+			r.MetadataJSON, err = d.string()
+		case field == 7 && wireType == wireVarint:
+			var v uint64
+			v, err = d.varint()
+			r.Final = v != 0
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// result converts the service's verdict into a guardrail result
+func (r *checkResponse) result() (*guardrails.Result, error) {
+	metadata := make(map[string]interface{})
+	if r.MetadataJSON != "" {
+		if err := json.Unmarshal([]byte(r.MetadataJSON), &metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata_json in CheckResponse: %w", err)
+		}
+	}
+	if r.Category != "" {
+		metadata["category"] = r.Category
+	}
+
+	reason := r.Reason
+	if reason == "" {
+		reason = "Content passed external guardrail"
+		if !r.Passed {
+			reason = "Content rejected by external guardrail"
+		}
+	}
+	return &guardrails.Result{
+		Passed:          r.Passed,
+		Score:           r.Score,
+		Reason:          reason,
+		Metadata:        metadata,
+		ModifiedContent: r.ModifiedContent,
+	}, nil
+}
@@ -0,0 +1,442 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The messages of proto/flashgateway/v1/chat.proto. Requests are decoded from
+// protobuf and sent on as chat completions JSON; responses are decoded from
+// JSON and sent back as protobuf.
+
+// chatRequest is a ChatCompletionRequest
+type chatRequest struct {
+	Model            string
+	Messages         []message
+	Temperature      *float64
+	TopP             *float64
+	MaxTokens        *int64
+	Stop             []string
+	N                *int64
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	Seed             *int64
+	User             string
+	ExtraJSON        string
+}
+
+// message is a Message, in a request, a completion or a chunk's delta
+type message struct {
+	Role         string        `json:"role,omitempty"`
+	Content      string        `json:"-"`
+	ContentParts []contentPart `json:"-"`
+	Name         string        `json:"name,omitempty"`
+	ToolCallID   string        `json:"tool_call_id,omitempty"`
+	ToolCalls    []toolCall    `json:"tool_calls,omitempty"`
+	Refusal      string        `json:"refusal,omitempty"`
+}
+
+// contentPart is a ContentPart of a message with images
+type contentPart struct {
+	Type     string
+	Text     string
+	ImageURL string
+	Detail   string
+}
+
+// toolCall is a ToolCall
+type toolCall struct {
+	Index    int64        `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+// functionCall is a FunctionCall
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// chatCompletion is a ChatCompletion or, with deltas in its choices, a
+// ChatCompletionChunk. The two share their field numbers.
+type chatCompletion struct {
+	ID                string   `json:"id"`
+	Object            string   `json:"object"`
+	Created           int64    `json:"created"`
+	Model             string   `json:"model"`
+	Choices           []choice `json:"choices"`
+	Usage             *usage   `json:"usage"`
+	SystemFingerprint string   `json:"system_fingerprint"`
+}
+
+// choice is a Choice or a ChunkChoice
+type choice struct {
+	Index        int64    `json:"index"`
+	Message      *message `json:"message"`
+	Delta        *message `json:"delta"`
+	FinishReason string   `json:"finish_reason"`
+}
+
+// usage is a Usage
+type usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// body builds the chat completions request body. Parameters set in the
+// message win over the same ones in extra_json.
+func (r *chatRequest) body(stream bool) ([]byte, error) {
+	body := make(map[string]interface{})
+	if r.ExtraJSON != "" {
+		if err := json.Unmarshal([]byte(r.ExtraJSON), &body); err != nil {
+			return nil, fmt.Errorf("extra_json must be a JSON object: %w", err)
+		}
+	}
+
+	body["model"] = r.Model
+	body["messages"] = r.Messages
+	body["stream"] = stream
+	set := func(key string, value interface{}, ok bool) {
+		if ok {
+			body[key] = value
+		}
+	}
+	set("temperature", r.Temperature, r.Temperature != nil)
+	set("top_p", r.TopP, r.TopP != nil)
+	set("max_tokens", r.MaxTokens, r.MaxTokens != nil)
+	set("stop", r.Stop, len(r.Stop) > 0)
+	set("n", r.N, r.N != nil)
+	set("presence_penalty", r.PresencePenalty, r.PresencePenalty != nil)
+	set("frequency_penalty", r.FrequencyPenalty, r.FrequencyPenalty != nil)
+	set("seed", r.Seed, r.Seed != nil)
+	set("user", r.User, r.User != "")
+
+	return json.Marshal(body)
+}
+
+func (m message) MarshalJSON() ([]byte, error) {
+	type fields message
+	out, err := json.Marshal(fields(m))
+	if err != nil {
+		return nil, err
+	}
+
+	// Assistant messages that only call tools have no content
+	var content interface{}
+	switch {
+	case len(m.ContentParts) > 0:
+		parts := make([]map[string]interface{}, len(m.ContentParts))
+		for i, part := range m.ContentParts {
+			parts[i] = part.json()
+		}
+		content = parts
+	case m.Content != "" || len(m.ToolCalls) == 0:
+		content = m.Content
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Splice content into the object, after its opening brace
+	result := append([]byte(`{"content":`), encoded...)
+	if len(out) > 2 {
+		result = append(result, ',')
+	}
+	return append(result, out[1:]...), nil
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	type fields message
+	var decoded struct {
+		fields
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*m = message(decoded.fields)
+
+	if len(decoded.Content) == 0 || decoded.Content[0] != '[' {
+		// A string, or null
+		json.Unmarshal(decoded.Content, &m.Content)
+		return nil
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL    string `json:"url"`
+			Detail string `json:"detail"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(decoded.Content, &parts); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		m.ContentParts = append(m.ContentParts, contentPart{
+			Type:     part.Type,
+			Text:     part.Text,
+			ImageURL: part.ImageURL.URL,
+			Detail:   part.ImageURL.Detail,
+		})
+	}
+	return nil
+}
+
+// json returns the part as the chat completions API expects it
+func (p contentPart) json() map[string]interface{} {
+	part := map[string]interface{}{"type": p.Type}
+	if p.Type == "image_url" {
+		image := map[string]interface{}{"url": p.ImageURL}
+		if p.Detail != "" {
+			image["detail"] = p.Detail
+		}
+		part["image_url"] = image
+	} else {
+		part["text"] = p.Text
+	}
+	return part
+}
+
+func (r *chatRequest) decode(data []byte) error {
+	d := decoder{buf: data}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireBytes:
+			r.Model, err = d.string()
+		case field == 2 && wireType == wireBytes:
+			var m message
+			err = decodeMessage(&d, m.decode)
+			r.Messages = append(r.Messages, m)
+		case field == 3 && wireType == wireFixed64:
+			r.Temperature, err = optionalDouble(&d)
+		case field == 4 && wireType == wireFixed64:
+			r.TopP, err = optionalDouble(&d)
+		case field == 5 && wireType == wireVarint:
+			r.MaxTokens, err = optionalInt32(&d)
+		case field == 6 && wireType == wireBytes:
+			var stop string
+			stop, err = d.string()
+			r.Stop = append(r.Stop, stop)
+		case field == 7 && wireType == wireVarint:
+			r.N, err = optionalInt32(&d)
+		case field == 8 && wireType == wireFixed64:
+			r.PresencePenalty, err = optionalDouble(&d)
+		case field == 9 && wireType == wireFixed64:
+			r.FrequencyPenalty, err = optionalDouble(&d)
+		case field == 10 && wireType == wireVarint:
+			var seed uint64
+			seed, err = d.varint()
+			value := int64(seed)
+			r.Seed = &value
+		case field == 11 && wireType == wireBytes:
+			r.User, err = d.string()
+		case field == 15 && wireType == wireBytes:
+			r.ExtraJSON, err = d.string()
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *message) decode(d *decoder) error {
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireBytes:
+			m.Role, err = d.string()
+		case field == 2 && wireType == wireBytes:
+			m.Content, err = d.string()
+		case field == 3 && wireType == wireBytes:
+			var part contentPart
+			err = decodeMessage(d, part.decode)
+			m.ContentParts = append(m.ContentParts, part)
+		case field == 4 && wireType == wireBytes:
+			m.Name, err = d.string()
+		case field == 5 && wireType == wireBytes:
+			m.ToolCallID, err = d.string()
+		case field == 6 && wireType == wireBytes:
+			var call toolCall
+			err = decodeMessage(d, call.decode)
+			m.ToolCalls = append(m.ToolCalls, call)
+		case field == 7 && wireType == wireBytes:
+			m.Refusal, err = d.string()
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *contentPart) decode(d *decoder) error {
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireBytes:
+			p.Type, err = d.string()
+		case field == 2 && wireType == wireBytes:
+			p.Text, err = d.string()
+		case field == 3 && wireType == wireBytes:
+			p.ImageURL, err = d.string()
+		case field == 4 && wireType == wireBytes:
+			p.Detail, err = d.string()
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *toolCall) decode(d *decoder) error {
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireVarint:
+			var index uint64
+			index, err = d.varint()
+			c.Index = int64(int32(index))
+		case field == 2 && wireType == wireBytes:
+			c.ID, err = d.string()
+		case field == 3 && wireType == wireBytes:
+			c.Type, err = d.string()
+		case field == 4 && wireType == wireBytes:
+			err = decodeMessage(d, c.Function.decode)
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *functionCall) decode(d *decoder) error {
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == wireBytes:
+			f.Name, err = d.string()
+		case field == 2 && wireType == wireBytes:
+			f.Arguments, err = d.string()
+		default:
+			err = d.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeMessage decodes a nested message field with its own decoder
+func decodeMessage(d *decoder, decode func(*decoder) error) error {
+	data, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return decode(&decoder{buf: data})
+}
+
+func optionalDouble(d *decoder) (*float64, error) {
+	v, err := d.double()
+	return &v, err
+}
+
+func optionalInt32(d *decoder) (*int64, error) {
+	v, err := d.varint()
+	value := int64(int32(v))
+	return &value, err
+}
+
+func (c *chatCompletion) encode(e *encoder) {
+	e.string(1, c.ID)
+	e.string(2, c.Object)
+	e.int64(3, c.Created)
+	e.string(4, c.Model)
+	for i := range c.Choices {
+		e.message(5, &c.Choices[i])
+	}
+	if c.Usage != nil {
+		e.message(6, c.Usage)
+	}
+	e.string(7, c.SystemFingerprint)
+}
+
+func (c *choice) encode(e *encoder) {
+	e.int64(1, c.Index)
+	if c.Message != nil {
+		e.message(2, c.Message)
+	} else if c.Delta != nil {
+		e.message(2, c.Delta)
+	}
+	e.string(3, c.FinishReason)
+}
+
+func (m *message) encode(e *encoder) {
+	e.string(1, m.Role)
+	e.string(2, m.Content)
+	for i := range m.ContentParts {
+		e.message(3, &m.ContentParts[i])
+	}
+	e.string(4, m.Name)
+	e.string(5, m.ToolCallID)
+	for i := range m.ToolCalls {
+		e.message(6, &m.ToolCalls[i])
+	}
+	e.string(7, m.Refusal)
+}
+
+func (p *contentPart) encode(e *encoder) {
+	e.string(1, p.Type)
+	e.string(2, p.Text)
+	e.string(3, p.ImageURL)
+	e.string(4, p.Detail)
+}
+
+func (c *toolCall) encode(e *encoder) {
+	e.int64(1, c.Index)
+	e.string(2, c.ID)
+	e.string(3, c.Type)
+	e.message(4, &c.Function)
+}
+
+func (f *functionCall) encode(e *encoder) {
+	e.string(1, f.Name)
+	e.string(2, f.Arguments)
+}
+
+func (u *usage) encode(e *encoder) {
+	e.int64(1, u.PromptTokens)
+	e.int64(2, u.CompletionTokens)
+	e.int64(3, u.TotalTokens)
+}
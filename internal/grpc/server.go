@@ -0,0 +1,483 @@
+// Package grpc serves the chat completions API over gRPC, so internal services
+// can call the gateway with generated clients. Calls are turned into
+// /v1/chat/completions requests and go through the gateway's full handler,
+// with the same routing, guardrails, rate limits and logging as HTTP.
+//
+// It also provides the client side of the GuardrailService, for guardrails
+// that run as external services.
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Methods of the ChatService in proto/flashgateway/v1/chat.proto
+const (
+	serviceName  = "flashgateway.v1.ChatService"
+	createMethod = "/" + serviceName + "/CreateChatCompletion"
+	streamMethod = "/" + serviceName + "/StreamChatCompletion"
+)
+
+// chatPath is the HTTP endpoint that serves calls
+const chatPath = "/v1/chat/completions"
+
+// gRPC status codes
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeUnknown           = 2
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeAborted           = 10
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// skippedMetadata are call headers that belong to gRPC and are not passed on
+var skippedMetadata = map[string]bool{
+	"content-type":    true,
+	"content-length":  true,
+	"accept-encoding": true,
+	"te":              true,
+	"trailer":         true,
+}
+
+// Server is the gRPC listener
+type Server struct {
+	handler        http.Handler
+	maxMessageSize int
+	certFile       string
+	keyFile        string
+	httpServer     *http.Server
+}
+
+// New creates a gRPC server that serves calls with handler, the gateway's
+// main HTTP handler
+func New(cfg config.GRPCConfig, handler http.Handler) *Server {
+	s := &Server{
+		handler:        handler,
+		maxMessageSize: cfg.MaxMessageSize,
+		certFile:       cfg.TLSCertFile,
+		keyFile:        cfg.TLSKeyFile,
+	}
+
+	// Without TLS, clients speak HTTP/2 from the start (h2c)
+	var root http.Handler = s
+	if !s.tls() {
+		root = h2c.NewHandler(s, &http2.Server{})
+	}
+	s.httpServer = &http.Server{
+		Addr:              cfg.Port,
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// tls reports whether the listener serves TLS
+func (s *Server) tls() bool {
+	return s.certFile != "" && s.keyFile != ""
+}
+
+// Start begins listening in the background
+func (s *Server) Start() {
+	go func() {
+		log.Printf("📡 gRPC API listening on %s", s.httpServer.Addr)
+		var err error
+		if s.tls() {
+			err = s.httpServer.ListenAndServeTLS(s.certFile, s.keyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("gRPC server failed: %v", err)
+		}
+	}()
+}
+
+// Shutdown gracefully stops the listener, letting calls in flight finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// ServeHTTP serves one gRPC call
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// From here on every outcome is reported as a gRPC status in the trailers
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	var stream bool
+	switch r.URL.Path {
+	case createMethod:
+	case streamMethod:
+		stream = true
+	default:
+		finish(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	data, code, err := readMessage(r.Body, r.Header.Get("Grpc-Encoding"), s.maxMessageSize)
+	if err != nil {
+		finish(w, code, err.Error())
+		return
+	}
+	var call chatRequest
+	if err := call.decode(data); err != nil {
+		finish(w, codeInvalidArgument, "invalid request message: "+err.Error())
+		return
+	}
+	body, err := call.body(stream)
+	if err != nil {
+		finish(w, codeInvalidArgument, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = middleware.WithLogMetadata(ctx, map[string]interface{}{
+		"grpc": map[string]interface{}{"method": r.URL.Path},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chatPath, bytes.NewReader(body))
+	if err != nil {
+		finish(w, codeInternal, err.Error())
+		return
+	}
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		if skippedMetadata[lower] || strings.HasPrefix(lower, "grpc-") || strings.HasSuffix(lower, "-bin") {
+			continue
+		}
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = r.RemoteAddr
+	req.Host = r.Host
+
+	if stream {
+		s.serveStream(w, req)
+	} else {
+		s.serveUnary(w, req)
+	}
+}
+
+// serveUnary serves CreateChatCompletion with the completion as one message
+func (s *Server) serveUnary(w http.ResponseWriter, req *http.Request) {
+	recorder := middleware.NewRecorder()
+	s.handler.ServeHTTP(recorder, req)
+
+	copyMetadata(w.Header(), recorder.Header())
+	if recorder.Status() != http.StatusOK {
+		finish(w, codeFromHTTP(recorder.Status(), req.Context()), errorMessage(recorder.Status(), recorder.Body()))
+		return
+	}
+
+	var completion chatCompletion
+	if err := json.Unmarshal(recorder.Body(), &completion); err != nil {
+		finish(w, codeInternal, "invalid completion from provider: "+err.Error())
+		return
+	}
+	if err := writeMessage(w, &completion); err != nil {
+		return
+	}
+	finish(w, codeOK, "")
+}
+
+// serveStream serves StreamChatCompletion, sending each chunk as it arrives
+func (s *Server) serveStream(w http.ResponseWriter, req *http.Request) {
+	stream := &streamWriter{w: w, header: make(http.Header), status: http.StatusOK}
+	s.handler.ServeHTTP(stream, req)
+
+	switch {
+	case stream.status != http.StatusOK:
+		if !stream.wroteHeader {
+			copyMetadata(w.Header(), stream.header)
+		}
+		finish(w, codeFromHTTP(stream.status, req.Context()), errorMessage(stream.status, stream.errorBody.Bytes()))
+	case stream.failure != "":
+		finish(w, codeInternal, stream.failure)
+	case stream.err != nil:
+		finish(w, codeFromHTTP(http.StatusInternalServerError, req.Context()), stream.err.Error())
+	default:
+		finish(w, codeOK, "")
+	}
+}
+
+// streamWriter receives the gateway's SSE response to a streamed call and
+// sends every chunk on to the client as a message
+type streamWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	pending     []byte       // start of an SSE line not yet complete
+	errorBody   bytes.Buffer // body of a failed response
+	failure     string       // error event sent in place of a chunk
+	err         error        // the client went away
+}
+
+func (s *streamWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *streamWriter) WriteHeader(status int) {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	s.status = status
+	if status == http.StatusOK {
+		copyMetadata(s.w.Header(), s.header)
+	}
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if s.status != http.StatusOK {
+		return s.errorBody.Write(p)
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	s.pending = append(s.pending, p...)
+	for {
+		end := bytes.IndexByte(s.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimSpace(s.pending[:end])
+		s.pending = s.pending[end+1:]
+		if err := s.line(line); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op: every chunk is flushed as it is sent
+func (s *streamWriter) Flush() {}
+
+// line sends the chunk in an SSE data line; other lines are dropped
+func (s *streamWriter) line(line []byte) error {
+	if !bytes.HasPrefix(line, []byte("data:")) || s.failure != "" {
+		return nil
+	}
+	data := bytes.TrimSpace(line[len("data:"):])
+	if len(data) == 0 || string(data) == "[DONE]" {
+		return nil
+	}
+
+	// Providers report failures mid-stream as an event with an error
+	var event struct {
+		chatCompletion
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil
+	}
+	if len(event.Error) > 0 && string(event.Error) != "null" {
+		s.failure = errorMessage(http.StatusInternalServerError, data)
+		return nil
+	}
+
+	if err := writeMessage(s.w, &event.chatCompletion); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// readMessage reads a call's request message, which unary and server
+// streaming methods send exactly one of
+func readMessage(r io.Reader, encoding string, limit int) ([]byte, int, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("missing request message")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > int64(limit) {
+		return nil, codeResourceExhausted, fmt.Errorf("request message larger than %d bytes", limit)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("truncated request message")
+	}
+	if prefix[0] == 0 {
+		return data, codeOK, nil
+	}
+
+	if encoding != "gzip" {
+		return nil, codeUnimplemented, fmt.Errorf("unsupported grpc-encoding %q", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("invalid compressed message: %v", err)
+	}
+	data, err = io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("invalid compressed message: %v", err)
+	}
+	if len(data) > limit {
+		return nil, codeResourceExhausted, fmt.Errorf("request message larger than %d bytes", limit)
+	}
+	return data, codeOK, nil
+}
+
+// writeMessage sends one uncompressed response message
+func writeMessage(w io.Writer, m interface{ encode(*encoder) }) error {
+	e := encoder{buf: make([]byte, 5, 256)}
+	m.encode(&e)
+	binary.BigEndian.PutUint32(e.buf[1:5], uint32(len(e.buf)-5))
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// finish ends a call with its status
+func finish(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+}
+
+// encodeMessage percent-encodes a status message as grpc-message requires
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7E || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout reads a grpc-timeout header, such as "500m" for 500ms
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// copyMetadata passes the gateway's own response headers, such as
+// X-Flash-Request-ID and rate limit headers, on as response metadata
+func copyMetadata(dst, src http.Header) {
+	for name, values := range src {
+		if strings.HasPrefix(name, "X-") || name == "Retry-After" {
+			dst[name] = values
+		}
+	}
+}
+
+// codeFromHTTP maps the gateway's response status to a gRPC status code
+func codeFromHTTP(status int, ctx context.Context) int {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return codeDeadlineExceeded
+	case context.Canceled:
+		return codeCanceled
+	}
+
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeAborted
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusPaymentRequired:
+		return codeResourceExhausted
+	case 499:
+		return codeCanceled
+	case http.StatusNotImplemented:
+		return codeUnimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codeDeadlineExceeded
+	}
+	if status >= 500 {
+		return codeInternal
+	}
+	return codeUnknown
+}
+
+// errorMessage returns the message of an OpenAI-style error body
+func errorMessage(status int, body []byte) string {
+	var parsed struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Error) > 0 {
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(parsed.Error, &detail) == nil && detail.Message != "" {
+			return detail.Message
+		}
+		var message string
+		if json.Unmarshal(parsed.Error, &message) == nil && message != "" {
+			return message
+		}
+	}
+	if text := strings.TrimSpace(string(body)); text != "" && len(text) <= 512 {
+		return text
+	}
+	return fmt.Sprintf("request failed with status %d", status)
+}
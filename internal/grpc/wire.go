@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// encoder appends protocol buffer fields to a message. Fields holding their
+// zero value are left out, as proto3 does.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) tag(field int, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(uint64(v))
+}
+
+// message writes a nested message, which is kept even when empty so repeated
+// fields keep their length
+func (e *encoder) message(field int, m interface{ encode(*encoder) }) {
+	var nested encoder
+	m.encode(&nested)
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(nested.buf)))
+	e.buf = append(e.buf, nested.buf...)
+}
+
+// decoder reads the fields of a protocol buffer message in order
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) done() bool {
+	return len(d.buf) == 0
+}
+
+// next reads a field's tag
+func (d *decoder) next() (field int, wireType int, err error) {
+	v, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	length, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(d.buf)) {
+		return nil, errTruncated
+	}
+	v := d.buf[:length]
+	d.buf = d.buf[length:]
+	return v, nil
+}
+
+func (d *decoder) string() (string, error) {
+	v, err := d.bytes()
+	return string(v), err
+}
+
+func (d *decoder) double() (float64, error) {
+	if len(d.buf) < 8 {
+		return 0, errTruncated
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v, nil
+}
+
+// skip passes over a field this gateway doesn't know, so newer clients can
+// still talk to it
+func (d *decoder) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = d.varint()
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed64, wireFixed32:
+		size := 8
+		if wireType == wireFixed32 {
+			size = 4
+		}
+		if len(d.buf) < size {
+			return errTruncated
+		}
+		d.buf = d.buf[size:]
+	default:
+		err = errors.New("unsupported protobuf wire type")
+	}
+	return err
+}
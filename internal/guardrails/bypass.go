@@ -0,0 +1,174 @@
+package guardrails
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// BypassHeader carries a signed list of guardrails the caller may skip
+const BypassHeader = "X-Guardrail-Bypass"
+
+// BypassAll in the guardrail list skips every guardrail
+const BypassAll = "*"
+
+// bypassContextKey is the context key under which bypassed guardrail names are stored
+const bypassContextKey = "guardrail_bypass"
+
+// BypassVerifier validates X-Guardrail-Bypass headers.
+//
+// The header has the form
+// "guardrails=<names>; ts=<unix seconds>; nonce=<nonce>; sig=<hex>", where sig
+// is HMAC-SHA256(secret, "<names>:<ts>:<nonce>:<METHOD>:<path>:<body sha256 hex>")
+// and names is a comma-separated list of guardrail names or "*". A signature
+// covers one request, and each nonce is accepted once.
+type BypassVerifier struct {
+	secret []byte
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time // Nonces seen, until their signatures expire
+}
+
+// NewBypassVerifier creates a verifier from configuration
+func NewBypassVerifier(cfg config.BypassConfig) *BypassVerifier {
+	secret := cfg.Secret
+	if secret == "" || strings.Contains(secret, "${") {
+		secret = os.Getenv("GUARDRAIL_BYPASS_SECRET")
+	}
+	if secret == "" {
+		log.Println("[WARNING] Guardrail bypass enabled without a secret, bypass headers will be rejected")
+	}
+
+	maxAge, err := time.ParseDuration(cfg.MaxAge)
+	if err != nil || maxAge <= 0 {
+		maxAge = 5 * time.Minute
+	}
+
+	return &BypassVerifier{
+		secret: []byte(secret),
+		maxAge: maxAge,
+		now:    time.Now,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// BypassRequest is the part of a request a bypass signature covers
+type BypassRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// payload returns what is signed for the request, besides the list and nonce
+func (r BypassRequest) payload() string {
+	sum := sha256.Sum256([]byte(r.Body))
+	return strings.ToUpper(r.Method) + ":" + r.Path + ":" + hex.EncodeToString(sum[:])
+}
+
+// Sign produces a header value letting req skip the given guardrails
+func (v *BypassVerifier) Sign(names []string, at time.Time, nonce string, req BypassRequest) string {
+	list := strings.Join(names, ",")
+	ts := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("guardrails=%s; ts=%s; nonce=%s; sig=%s", list, ts, nonce, hex.EncodeToString(v.mac(list, ts, nonce, req)))
+}
+
+// Verify checks a header value sent with req and returns the guardrail names
+// it allows skipping
+func (v *BypassVerifier) Verify(header string, req BypassRequest) ([]string, error) {
+	if len(v.secret) == 0 {
+		return nil, fmt.Errorf("guardrail bypass secret not configured")
+	}
+
+	fields := make(map[string]string)
+	for _, part := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed bypass header")
+		}
+		fields[key] = value
+	}
+
+	list, ts, nonce, sig := fields["guardrails"], fields["ts"], fields["nonce"], fields["sig"]
+	if list == "" || ts == "" || nonce == "" || sig == "" {
+		return nil, fmt.Errorf("bypass header requires guardrails, ts, nonce and sig")
+	}
+
+	signedAt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bypass timestamp: %w", err)
+	}
+	age := v.now().Sub(time.Unix(signedAt, 0))
+	if age > v.maxAge || age < -v.maxAge {
+		return nil, fmt.Errorf("bypass signature expired")
+	}
+
+	expected, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(expected, v.mac(list, ts, nonce, req)) {
+		return nil, fmt.Errorf("invalid bypass signature")
+	}
+	if !v.claim(nonce, time.Unix(signedAt, 0).Add(v.maxAge)) {
+		return nil, fmt.Errorf("bypass nonce already used")
+	}
+
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// mac computes HMAC-SHA256 over "<list>:<ts>:<nonce>:<METHOD>:<path>:<body hash>"
+func (v *BypassVerifier) mac(list, ts, nonce string, req BypassRequest) []byte {
+	h := hmac.New(sha256.New, v.secret)
+	h.Write([]byte(list + ":" + ts + ":" + nonce + ":" + req.payload()))
+	return h.Sum(nil)
+}
+
+// claim records a nonce until expires, reporting false if it was already
+// used. Nonces whose signatures have expired are forgotten, since the
+// timestamp check rejects them anyway.
+func (v *BypassVerifier) claim(nonce string, expires time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	for n, until := range v.used {
+		if now.After(until) {
+			delete(v.used, n)
+		}
+	}
+	if _, ok := v.used[nonce]; ok {
+		return false
+	}
+	v.used[nonce] = expires
+	return true
+}
+
+// WithBypass marks guardrails to be skipped for the rest of the request
+func WithBypass(ctx context.Context, names []string) context.Context {
+	return context.WithValue(ctx, bypassContextKey, names)
+}
+
+// isBypassed reports whether the named guardrail is skipped for this request
+func isBypassed(ctx context.Context, name string) bool {
+	names, _ := ctx.Value(bypassContextKey).([]string)
+	for _, n := range names {
+		if n == BypassAll || n == name {
+			return true
+		}
+	}
+	return false
+}
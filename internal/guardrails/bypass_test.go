@@ -0,0 +1,192 @@
+package guardrails
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+func newTestBypassVerifier(now time.Time) *BypassVerifier {
+	v := NewBypassVerifier(config.BypassConfig{Secret: "test-secret", MaxAge: "5m"})
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestBypassVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	req := BypassRequest{Method: "POST", Path: "/v1/chat/completions", Body: `{"model":"gpt-4o"}`}
+	other := NewBypassVerifier(config.BypassConfig{Secret: "other-secret"})
+
+	tests := []struct {
+		name      string
+		header    func(v *BypassVerifier) string
+		req       BypassRequest
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "valid",
+			header:    func(v *BypassVerifier) string { return v.Sign([]string{"pii", "toxicity"}, now, "n1", req) },
+			req:       req,
+			wantNames: []string{"pii", "toxicity"},
+		},
+		{
+			name:      "all guardrails",
+			header:    func(v *BypassVerifier) string { return v.Sign([]string{BypassAll}, now, "n1", req) },
+			req:       req,
+			wantNames: []string{BypassAll},
+		},
+		{
+			name:      "method case ignored",
+			header:    func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now, "n1", req) },
+			req:       BypassRequest{Method: "post", Path: req.Path, Body: req.Body},
+			wantNames: []string{"pii"},
+		},
+		{
+			name:    "different body",
+			header:  func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now, "n1", req) },
+			req:     BypassRequest{Method: req.Method, Path: req.Path, Body: `{"model":"gpt-4o","messages":[]}`},
+			wantErr: "invalid bypass signature",
+		},
+		{
+			name:    "different path",
+			header:  func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now, "n1", req) },
+			req:     BypassRequest{Method: req.Method, Path: "/v1/embeddings", Body: req.Body},
+			wantErr: "invalid bypass signature",
+		},
+		{
+			name:    "different method",
+			header:  func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now, "n1", req) },
+			req:     BypassRequest{Method: "PUT", Path: req.Path, Body: req.Body},
+			wantErr: "invalid bypass signature",
+		},
+		{
+			name: "guardrail list widened",
+			header: func(v *BypassVerifier) string {
+				return strings.Replace(v.Sign([]string{"pii"}, now, "n1", req), "guardrails=pii", "guardrails=*", 1)
+			},
+			req:     req,
+			wantErr: "invalid bypass signature",
+		},
+		{
+			name:    "wrong secret",
+			header:  func(*BypassVerifier) string { return other.Sign([]string{"pii"}, now, "n1", req) },
+			req:     req,
+			wantErr: "invalid bypass signature",
+		},
+		{
+			name:    "expired",
+			header:  func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now.Add(-6*time.Minute), "n1", req) },
+			req:     req,
+			wantErr: "expired",
+		},
+		{
+			name:    "signed in the future",
+			header:  func(v *BypassVerifier) string { return v.Sign([]string{"pii"}, now.Add(6*time.Minute), "n1", req) },
+			req:     req,
+			wantErr: "expired",
+		},
+		{
+			name:    "missing nonce",
+			header:  func(*BypassVerifier) string { return "guardrails=pii; ts=1700000000; sig=00" },
+			req:     req,
+			wantErr: "requires guardrails, ts, nonce and sig",
+		},
+		{
+			name:    "malformed",
+			header:  func(*BypassVerifier) string { return "pii" },
+			req:     req,
+			wantErr: "malformed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestBypassVerifier(now)
+			names, err := v.Verify(tt.header(v), tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("Verify() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestBypassVerifyReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := newTestBypassVerifier(now)
+	req := BypassRequest{Method: "POST", Path: "/v1/chat/completions", Body: "{}"}
+	header := v.Sign([]string{"pii"}, now, "n1", req)
+
+	if _, err := v.Verify(header, req); err != nil {
+		t.Fatalf("first Verify() error = %v", err)
+	}
+	if _, err := v.Verify(header, req); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("replayed Verify() error = %v, want nonce already used", err)
+	}
+
+	// A rejected signature doesn't use up its nonce
+	if _, err := v.Verify(v.Sign([]string{"pii"}, now, "n2", req), BypassRequest{Method: "POST", Path: "/other"}); err == nil {
+		t.Fatal("Verify() with a mismatched path succeeded")
+	}
+	if _, err := v.Verify(v.Sign([]string{"pii"}, now, "n2", req), req); err != nil {
+		t.Fatalf("Verify() after a rejected attempt error = %v", err)
+	}
+
+	// Nonces are forgotten once their signatures expire, and the timestamp
+	// check rejects them from then on
+	v.now = func() time.Time { return now.Add(10 * time.Minute) }
+	if _, err := v.Verify(header, req); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Verify() after expiry error = %v, want expired", err)
+	}
+	v.claim("n3", now.Add(10*time.Minute))
+	if len(v.used) != 1 {
+		t.Fatalf("%d nonces remembered after expiry, want 1", len(v.used))
+	}
+}
+
+func TestBypassWithoutSecret(t *testing.T) {
+	t.Setenv("GUARDRAIL_BYPASS_SECRET", "")
+	v := NewBypassVerifier(config.BypassConfig{})
+	signer := newTestBypassVerifier(time.Now())
+	req := BypassRequest{Method: "POST", Path: "/v1/chat/completions"}
+	if _, err := v.Verify(signer.Sign([]string{"pii"}, time.Now(), "n1", req), req); err == nil {
+		t.Fatal("Verify() without a secret succeeded")
+	}
+}
+
+func TestIsBypassed(t *testing.T) {
+	tests := []struct {
+		name     string
+		bypassed []string
+		check    string
+		want     bool
+	}{
+		{name: "listed", bypassed: []string{"pii", "toxicity"}, check: "toxicity", want: true},
+		{name: "not listed", bypassed: []string{"pii"}, check: "toxicity", want: false},
+		{name: "all", bypassed: []string{BypassAll}, check: "toxicity", want: true},
+		{name: "none", bypassed: nil, check: "pii", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.bypassed != nil {
+				ctx = WithBypass(ctx, tt.bypassed)
+			}
+			if got := isBypassed(ctx, tt.check); got != tt.want {
+				t.Fatalf("isBypassed(%q) = %v, want %v", tt.check, got, tt.want)
+			}
+		})
+	}
+}
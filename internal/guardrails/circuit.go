@@ -0,0 +1,263 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"    // Checks run normally
+	CircuitOpen     = "open"      // Checks are skipped until the cooldown ends
+	CircuitHalfOpen = "half_open" // One trial check decides whether to close again
+)
+
+// healthBuckets is how many slices a guardrail's stats window is divided into
+const healthBuckets = 10
+
+// defaultHealthWindow covers the stats of guardrails without a circuit breaker
+const defaultHealthWindow = time.Minute
+
+// CircuitSettings decides when a guardrail's circuit opens and for how long
+type CircuitSettings struct {
+	ErrorRate   float64
+	MinRequests int
+	Window      time.Duration
+	Cooldown    time.Duration
+	SlowCall    time.Duration // Zero when latency alone never counts as failure
+}
+
+// newCircuitSettings applies defaults to a circuit breaker configuration
+func newCircuitSettings(cfg config.GuardrailCircuitConfig) (*CircuitSettings, error) {
+	settings := &CircuitSettings{
+		ErrorRate:   cfg.ErrorRate,
+		MinRequests: cfg.MinRequests,
+		Window:      30 * time.Second,
+		Cooldown:    30 * time.Second,
+	}
+	if settings.ErrorRate < 0 || settings.ErrorRate > 1 {
+		return nil, fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if settings.ErrorRate == 0 {
+		settings.ErrorRate = 0.5
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = 10
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"window", cfg.Window, &settings.Window},
+		{"cooldown", cfg.Cooldown, &settings.Cooldown},
+		{"slow_call", cfg.SlowCall, &settings.SlowCall},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.dest = parsed
+	}
+	return settings, nil
+}
+
+// Health tracks a guardrail's recent error rate and latency and, when it has
+// circuit settings, skips the guardrail while its checks keep failing
+type Health struct {
+	name        string
+	circuit     *CircuitSettings // nil tracks stats without ever opening
+	bucketWidth time.Duration
+
+	mu        sync.Mutex
+	buckets   [healthBuckets]healthBucket
+	state     string
+	openedAt  time.Time
+	reason    string
+	trial     bool // A half-open trial check is running
+	trips     int
+	skipped   int64
+	lastError string
+}
+
+// healthBucket counts check outcomes in one slice of the window
+type healthBucket struct {
+	epoch      int64
+	calls      int
+	failures   int
+	slow       int
+	latency    time.Duration // Total, for the average
+	maxLatency time.Duration
+}
+
+// NewHealth creates the health tracker for a guardrail
+func NewHealth(name string, circuit *CircuitSettings) *Health {
+	window := defaultHealthWindow
+	if circuit != nil {
+		window = circuit.Window
+	}
+	return &Health{
+		name:        name,
+		circuit:     circuit,
+		bucketWidth: window / healthBuckets,
+		state:       CircuitClosed,
+	}
+}
+
+// Allow reports whether the guardrail should run. An open circuit lets one
+// trial check through once its cooldown has passed.
+func (h *Health) Allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.state {
+	case CircuitOpen:
+		if time.Since(h.openedAt) < h.circuit.Cooldown {
+			h.skipped++
+			return false
+		}
+		h.state = CircuitHalfOpen
+		h.trial = true
+		return true
+	case CircuitHalfOpen:
+		if h.trial {
+			h.skipped++
+			return false
+		}
+		h.trial = true
+		return true
+	}
+	return true
+}
+
+// Record counts a check's outcome. Checks cancelled because the request
+// ended or another guardrail blocked it say nothing about the guardrail and
+// are not counted.
+func (h *Health) Record(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		h.trial = false
+		return
+	}
+
+	failed := err != nil
+	slow := h.circuit != nil && h.circuit.SlowCall > 0 && latency >= h.circuit.SlowCall
+	if failed {
+		h.lastError = err.Error()
+	}
+
+	epoch := time.Now().UnixNano() / int64(h.bucketWidth)
+	bucket := &h.buckets[epoch%healthBuckets]
+	if bucket.epoch != epoch {
+		*bucket = healthBucket{epoch: epoch}
+	}
+	bucket.calls++
+	bucket.latency += latency
+	if latency > bucket.maxLatency {
+		bucket.maxLatency = latency
+	}
+	if failed || slow {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+
+	if h.circuit == nil {
+		return
+	}
+	switch h.state {
+	case CircuitHalfOpen:
+		h.trial = false
+		if failed || slow {
+			h.openLocked("trial check failed")
+			return
+		}
+		log.Printf("[GUARDRAIL] Closing circuit for %s after %v", h.name, time.Since(h.openedAt).Round(time.Second))
+		h.state = CircuitClosed
+		h.reason = ""
+		h.buckets = [healthBuckets]healthBucket{} // Judge the guardrail on fresh checks
+	case CircuitClosed:
+		stats := h.windowLocked(epoch)
+		if stats.calls >= h.circuit.MinRequests && float64(stats.failures)/float64(stats.calls) >= h.circuit.ErrorRate {
+			h.openLocked(fmt.Sprintf("%d of %d recent checks failed", stats.failures, stats.calls))
+		}
+	}
+}
+
+func (h *Health) openLocked(reason string) {
+	h.state = CircuitOpen
+	h.openedAt = time.Now()
+	h.reason = reason
+	h.trips++
+	log.Printf("[GUARDRAIL] Opening circuit for %s for %v: %s", h.name, h.circuit.Cooldown, reason)
+}
+
+// windowLocked sums the buckets still inside the window
+func (h *Health) windowLocked(epoch int64) healthBucket {
+	var sum healthBucket
+	for _, b := range h.buckets {
+		if b.epoch <= epoch-healthBuckets {
+			continue
+		}
+		sum.calls += b.calls
+		sum.failures += b.failures
+		sum.slow += b.slow
+		sum.latency += b.latency
+		if b.maxLatency > sum.maxLatency {
+			sum.maxLatency = b.maxLatency
+		}
+	}
+	return sum
+}
+
+// State returns the circuit state
+func (h *Health) State() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// Status returns the guardrail's recent stats and circuit state for status
+// endpoints
+func (h *Health) Status() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := h.windowLocked(time.Now().UnixNano() / int64(h.bucketWidth))
+	status := map[string]interface{}{
+		"window":         (h.bucketWidth * healthBuckets).String(),
+		"checks":         stats.calls,
+		"failures":       stats.failures,
+		"slow_checks":    stats.slow,
+		"max_latency_ms": stats.maxLatency.Milliseconds(),
+		"skipped":        h.skipped,
+	}
+	if stats.calls > 0 {
+		status["error_rate"] = float64(stats.failures) / float64(stats.calls)
+		status["avg_latency_ms"] = (stats.latency / time.Duration(stats.calls)).Milliseconds()
+	}
+	if h.lastError != "" {
+		status["last_error"] = h.lastError
+	}
+	if h.circuit != nil {
+		status["circuit"] = h.state
+		status["trips"] = h.trips
+		if h.state != CircuitClosed {
+			status["opened_at"] = h.openedAt
+			status["reason"] = h.reason
+		}
+	}
+	return status
+}
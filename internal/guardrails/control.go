@@ -0,0 +1,242 @@
+package guardrails
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxChanges is how many runtime changes are kept for auditing
+const maxChanges = 200
+
+// ThresholdGuardrail is implemented by guardrails whose score threshold can be
+// changed while the gateway runs
+type ThresholdGuardrail interface {
+	Guardrail
+	Threshold() float64
+	SetThreshold(threshold float64) error
+}
+
+// Threshold holds a guardrail's score threshold so it can be changed while
+// checks are reading it
+type Threshold struct {
+	bits atomic.Uint64
+}
+
+// Load returns the current threshold
+func (t *Threshold) Load() float64 {
+	return math.Float64frombits(t.bits.Load())
+}
+
+// Store replaces the threshold
+func (t *Threshold) Store(threshold float64) {
+	t.bits.Store(math.Float64bits(threshold))
+}
+
+// Settings is a runtime change to a loaded guardrail; nil fields are left as they are
+type Settings struct {
+	Enabled   *bool    `json:"enabled,omitempty"`
+	Priority  *int     `json:"priority,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+}
+
+// Change records one runtime setting change and who made it
+type Change struct {
+	Time      time.Time   `json:"time"`
+	Guardrail string      `json:"guardrail"`
+	Setting   string      `json:"setting"`
+	From      interface{} `json:"from"`
+	To        interface{} `json:"to"`
+	By        string      `json:"by"`
+}
+
+// GuardrailState describes a loaded guardrail's current runtime settings
+type GuardrailState struct {
+	Name               string   `json:"name"`
+	Layer              string   `json:"layer"`
+	Enabled            bool     `json:"enabled"`
+	Priority           int      `json:"priority"`
+	ConfiguredPriority int      `json:"configured_priority"`
+	Threshold          *float64 `json:"threshold,omitempty"` // Only for guardrails with an adjustable threshold
+}
+
+// controls holds the runtime overrides made through the admin API. Overrides
+// are keyed by guardrail name and last until the gateway restarts.
+type controls struct {
+	mu         sync.RWMutex
+	disabled   map[string]bool
+	priorities map[string]int
+	changes    []Change
+}
+
+// asThresholdGuardrail returns the guardrail's adjustable threshold, looking
+// through applicability filters
+func asThresholdGuardrail(g Guardrail) (ThresholdGuardrail, bool) {
+	if scoped, ok := g.(*scopedGuardrail); ok {
+		g = scoped.Guardrail
+	}
+	threshold, ok := g.(ThresholdGuardrail)
+	return threshold, ok
+}
+
+// Enabled reports whether a guardrail is switched on
+func (e *Executor) Enabled(name string) bool {
+	e.controls.mu.RLock()
+	defer e.controls.mu.RUnlock()
+	return !e.controls.disabled[name]
+}
+
+// Priority returns a guardrail's priority, with any runtime override applied
+func (e *Executor) Priority(g Guardrail) int {
+	e.controls.mu.RLock()
+	defer e.controls.mu.RUnlock()
+	if priority, ok := e.controls.priorities[g.Name()]; ok {
+		return priority
+	}
+	return g.Priority()
+}
+
+// enabled returns the guardrails that are switched on
+func (e *Executor) enabled(guardrails []Guardrail) []Guardrail {
+	e.controls.mu.RLock()
+	defer e.controls.mu.RUnlock()
+	if len(e.controls.disabled) == 0 {
+		return guardrails
+	}
+
+	filtered := make([]Guardrail, 0, len(guardrails))
+	for _, g := range guardrails {
+		if !e.controls.disabled[g.Name()] {
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered
+}
+
+// States describes every loaded guardrail's runtime settings, input
+// guardrails first
+func (e *Executor) States() []GuardrailState {
+	states := make([]GuardrailState, 0, len(e.inputGuardrails)+len(e.outputGuardrails))
+	for _, layer := range []struct {
+		name string
+		list []Guardrail
+	}{{"input", e.inputGuardrails}, {"output", e.outputGuardrails}} {
+		for _, g := range layer.list {
+			states = append(states, e.state(layer.name, g))
+		}
+	}
+	return states
+}
+
+func (e *Executor) state(layer string, g Guardrail) GuardrailState {
+	state := GuardrailState{
+		Name:               g.Name(),
+		Layer:              layer,
+		Enabled:            e.Enabled(g.Name()),
+		Priority:           e.Priority(g),
+		ConfiguredPriority: g.Priority(),
+	}
+	if adjustable, ok := asThresholdGuardrail(g); ok {
+		threshold := adjustable.Threshold()
+		state.Threshold = &threshold
+	}
+	return state
+}
+
+// Update changes a loaded guardrail's settings, in both layers if it is in
+// both, and records each change with who made it. Nothing is changed if any
+// setting is invalid.
+func (e *Executor) Update(name string, settings Settings, by string) ([]GuardrailState, error) {
+	var loaded []Guardrail
+	for _, g := range append(append([]Guardrail{}, e.inputGuardrails...), e.outputGuardrails...) {
+		if g.Name() == name {
+			loaded = append(loaded, g)
+		}
+	}
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no guardrail named %q is loaded", name)
+	}
+
+	var adjustable []ThresholdGuardrail
+	if settings.Threshold != nil {
+		for _, g := range loaded {
+			if t, ok := asThresholdGuardrail(g); ok {
+				adjustable = append(adjustable, t)
+			}
+		}
+		if len(adjustable) == 0 {
+			return nil, fmt.Errorf("guardrail %s has no adjustable threshold", name)
+		}
+	}
+
+	e.controls.mu.Lock()
+	var changes []Change
+	record := func(setting string, from, to interface{}) {
+		changes = append(changes, Change{Time: time.Now(), Guardrail: name, Setting: setting, From: from, To: to, By: by})
+	}
+
+	if settings.Threshold != nil {
+		from := adjustable[0].Threshold()
+		for _, t := range adjustable {
+			if err := t.SetThreshold(*settings.Threshold); err != nil {
+				e.controls.mu.Unlock()
+				return nil, fmt.Errorf("guardrail %s: %w", name, err)
+			}
+		}
+		record("threshold", from, *settings.Threshold)
+	}
+	if settings.Enabled != nil {
+		from := !e.controls.disabled[name]
+		if *settings.Enabled {
+			delete(e.controls.disabled, name)
+		} else {
+			e.controls.disabled[name] = true
+		}
+		record("enabled", from, *settings.Enabled)
+	}
+	if settings.Priority != nil {
+		from, ok := e.controls.priorities[name]
+		if !ok {
+			from = loaded[0].Priority()
+		}
+		if *settings.Priority == loaded[0].Priority() {
+			delete(e.controls.priorities, name)
+		} else {
+			e.controls.priorities[name] = *settings.Priority
+		}
+		record("priority", from, *settings.Priority)
+	}
+
+	e.controls.changes = append(e.controls.changes, changes...)
+	if overflow := len(e.controls.changes) - maxChanges; overflow > 0 {
+		e.controls.changes = append([]Change(nil), e.controls.changes[overflow:]...)
+	}
+	e.controls.mu.Unlock()
+
+	for _, c := range changes {
+		log.Printf("[GUARDRAIL] %s changed %s of %s from %v to %v", c.By, c.Setting, c.Guardrail, c.From, c.To)
+	}
+
+	states := make([]GuardrailState, 0, len(loaded))
+	for _, g := range e.inputGuardrails {
+		if g.Name() == name {
+			states = append(states, e.state("input", g))
+		}
+	}
+	for _, g := range e.outputGuardrails {
+		if g.Name() == name {
+			states = append(states, e.state("output", g))
+		}
+	}
+	return states, nil
+}
+
+// Changes returns the recorded runtime changes, oldest first
+func (e *Executor) Changes() []Change {
+	e.controls.mu.RLock()
+	defer e.controls.mu.RUnlock()
+	return append([]Change{}, e.controls.changes...)
+}
@@ -0,0 +1,181 @@
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Evaluation is one guardrail's verdict in a dry run
+type Evaluation struct {
+	Name       string                 `json:"name"`
+	Priority   int                    `json:"priority"`
+	Passed     bool                   `json:"passed"`
+	Score      *float64               `json:"score,omitempty"`
+	Reason     string                 `json:"reason,omitempty"`
+	Category   string                 `json:"category,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Modified   bool                   `json:"modified,omitempty"`
+	LatencyMs  float64                `json:"latency_ms"`
+	Circuit    string                 `json:"circuit,omitempty"`     // Set when the circuit is not closed; live traffic would skip the guardrail
+	Disabled   bool                   `json:"disabled,omitempty"`    // Switched off at runtime; only run when named
+	AfterBlock bool                   `json:"after_block,omitempty"` // Live traffic would have stopped at an earlier block
+}
+
+// DryRun is the outcome of evaluating content against a guardrail chain
+// without proxying it
+type DryRun struct {
+	Layer           string       `json:"layer"`
+	Passed          bool         `json:"passed"`
+	BlockedBy       string       `json:"blocked_by,omitempty"`
+	Reason          string       `json:"reason,omitempty"`
+	Category        string       `json:"category,omitempty"`
+	ModifiedContent *string      `json:"modified_content,omitempty"`
+	ModifiedBy      []string     `json:"modified_by,omitempty"`
+	Guardrails      []Evaluation `json:"guardrails"`
+}
+
+// Evaluate runs content through a layer's guardrails the way live traffic
+// would, in priority groups with modifications applied, but runs every
+// guardrail rather than stopping at the first block. Nothing is proxied and
+// no metrics or health stats are recorded. names limits the run to some
+// guardrails, including ones switched off at runtime; the scope attached to
+// ctx decides which ones apply.
+func (e *Executor) Evaluate(ctx context.Context, layer, content string, names []string) (*DryRun, error) {
+	var list []Guardrail
+	switch layer {
+	case "input":
+		list = e.inputGuardrails
+	case "output":
+		list = e.outputGuardrails
+	default:
+		return nil, fmt.Errorf("unknown layer %q, use input or output", layer)
+	}
+
+	if len(names) > 0 {
+		byName := make(map[string]Guardrail, len(list))
+		for _, g := range list {
+			byName[g.Name()] = g
+		}
+		selected := make([]Guardrail, 0, len(names))
+		for _, name := range names {
+			g, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("no %s guardrail named %q", layer, name)
+			}
+			selected = append(selected, g)
+		}
+		list = selected
+	} else {
+		list = e.enabled(list)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	priorityGroups := make(map[int][]Guardrail)
+	var priorities []int
+	for _, g := range applicable(ctx, list) {
+		priority := e.Priority(g)
+		if _, ok := priorityGroups[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		priorityGroups[priority] = append(priorityGroups[priority], g)
+	}
+	sort.Ints(priorities)
+
+	run := &DryRun{Layer: layer, Passed: true, Guardrails: []Evaluation{}}
+	scope, _ := ScopeFromContext(ctx)
+	current := content
+	input := ParseInput(layer, current, scope)
+	for _, priority := range priorities {
+		parallel, chain := e.splitModifying(priorityGroups[priority])
+		stages := make([][]Guardrail, 0, len(chain)+1)
+		if len(parallel) > 0 {
+			stages = append(stages, parallel)
+		}
+		for _, g := range chain {
+			stages = append(stages, []Guardrail{g})
+		}
+
+		for _, stage := range stages {
+			evaluations, results := e.evaluateStage(ctx, input, stage, !run.Passed)
+			for _, ev := range evaluations {
+				if !ev.Passed && run.Passed {
+					run.Passed = false
+					run.BlockedBy = ev.Name
+					run.Reason = ev.Reason
+					run.Category = ev.Category
+				}
+			}
+			run.Guardrails = append(run.Guardrails, evaluations...)
+
+			if modification, _ := resolveModifications(results); modification != nil {
+				current = *modification.Result.ModifiedContent
+				input = ParseInput(layer, current, scope)
+				run.ModifiedBy = append(run.ModifiedBy, modification.Name)
+			}
+		}
+	}
+	if len(run.ModifiedBy) > 0 {
+		run.ModifiedContent = &current
+	}
+	return run, nil
+}
+
+// evaluateStage runs guardrails in parallel on the same input, without
+// cancelling the others when one blocks
+func (e *Executor) evaluateStage(ctx context.Context, input *GuardrailInput, stage []Guardrail, afterBlock bool) ([]Evaluation, []*GuardrailResult) {
+	evaluations := make([]Evaluation, len(stage))
+	results := make([]*GuardrailResult, len(stage))
+
+	var wg sync.WaitGroup
+	for i, g := range stage {
+		wg.Add(1)
+		go func(i int, g Guardrail) {
+			defer wg.Done()
+
+			policy := e.policy(g.Name())
+			start := time.Now()
+			result, err := checkWithTimeout(ctx, g, input, policy.Timeout)
+			duration := time.Since(start)
+
+			ev := Evaluation{
+				Name:       g.Name(),
+				Priority:   e.Priority(g),
+				LatencyMs:  float64(duration.Microseconds()) / 1000,
+				AfterBlock: afterBlock,
+				Disabled:   !e.Enabled(g.Name()),
+			}
+			if state := e.Health(g.Name()).State(); state != CircuitClosed {
+				ev.Circuit = state
+			}
+
+			if err != nil {
+				ev.Error = err.Error()
+				ev.Passed = policy.OnError == OnErrorAllow
+				ev.Reason = fmt.Sprintf("Guardrail error (on_error: %s): %v", policy.OnError, err)
+				evaluations[i] = ev
+				return
+			}
+
+			ev.Passed = result.Passed
+			ev.Score = result.Score
+			ev.Reason = result.Reason
+			ev.Metadata = result.Metadata
+			ev.Modified = result.ModifiedContent != nil
+			if category, ok := result.Metadata["category"].(string); ok {
+				ev.Category = category
+			}
+			evaluations[i] = ev
+			if result.Passed {
+				results[i] = &GuardrailResult{Name: g.Name(), Priority: ev.Priority, Result: result, Duration: duration}
+			}
+		}(i, g)
+	}
+	wg.Wait()
+	return evaluations, results
+}
@@ -61,4 +61,20 @@ func (g *InputExampleGuardrail) Check(ctx context.Context, content string) (*gua
 			"config_loaded":  len(g.config) > 0,
 		},
 	}, nil
+}
+
+// CheckRequest validates parsed input content
+// Implementing this method lets the executor hand over role-separated messages,
+// the endpoint, model, and headers instead of the raw request body
+func (g *InputExampleGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	result, err := g.Check(ctx, input.Raw)
+	if err != nil {
+		return nil, err
+	}
+	
+	result.Metadata["endpoint"] = input.Endpoint
+	result.Metadata["model"] = input.Model
+	result.Metadata["message_count"] = len(input.Messages)
+	
+	return result, nil
 }
\ No newline at end of file
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/NamanArora/flash-gateway/internal/storage"
 	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
 )
@@ -17,6 +18,14 @@ type Executor struct {
 	outputGuardrails []Guardrail
 	metricsWriter    *MetricsWriter
 	timeout          time.Duration
+	policies         map[string]Policy // Per-guardrail timeout and error handling, by name
+
+	healthMu sync.Mutex
+	health   map[string]*Health // Per-guardrail stats and circuit, by name
+
+	controls controls // Runtime enable/priority overrides from the admin API
+
+	redactResponse func(string) string // Applied to responses stored with metrics
 }
 
 // ExecutorConfig holds configuration for the executor
@@ -25,6 +34,7 @@ type ExecutorConfig struct {
 	OutputGuardrails []Guardrail
 	MetricsWriter    *MetricsWriter
 	Timeout          time.Duration
+	Policies         map[string]Policy
 }
 
 // NewExecutor creates a new guardrail executor
@@ -38,9 +48,21 @@ func NewExecutor(config ExecutorConfig) *Executor {
 		outputGuardrails: config.OutputGuardrails,
 		metricsWriter:    config.MetricsWriter,
 		timeout:          config.Timeout,
+		policies:         config.Policies,
+		health:           make(map[string]*Health),
+		controls: controls{
+			disabled:   make(map[string]bool),
+			priorities: make(map[string]int),
+		},
 	}
 }
 
+// SetResponseRedactor redacts the original and override responses that
+// blocking output guardrails store with their metrics, as request logs are
+func (e *Executor) SetResponseRedactor(redact func(string) string) {
+	e.redactResponse = redact
+}
+
 // ExecuteInput runs all input guardrails in parallel
 func (e *Executor) ExecuteInput(ctx context.Context, requestID uuid.UUID, content string) (*ExecutionResult, error) {
 	return e.executeParallel(ctx, requestID, content, e.inputGuardrails, "input", nil, nil)
@@ -58,6 +80,9 @@ func (e *Executor) ExecuteOutputWithResponses(ctx context.Context, requestID uui
 
 // executeParallel runs guardrails in priority groups - same priority runs in parallel, different priorities run sequentially
 func (e *Executor) executeParallel(ctx context.Context, requestID uuid.UUID, content string, guardrails []Guardrail, layer string, originalResponse, overrideResponse []byte) (*ExecutionResult, error) {
+	// Skip guardrails switched off at runtime or whose filters exclude this
+	// endpoint, provider, or model
+	guardrails = applicable(ctx, e.enabled(guardrails))
 	if len(guardrails) == 0 {
 		return &ExecutionResult{Passed: true, Results: []*GuardrailResult{}}, nil
 	}
@@ -69,7 +94,7 @@ func (e *Executor) executeParallel(ctx context.Context, requestID uuid.UUID, con
 	// Group guardrails by priority
 	priorityGroups := make(map[int][]Guardrail)
 	for _, g := range guardrails {
-		priority := g.Priority()
+		priority := e.Priority(g)
 		priorityGroups[priority] = append(priorityGroups[priority], g)
 	}
 	
@@ -83,53 +108,74 @@ func (e *Executor) executeParallel(ctx context.Context, requestID uuid.UUID, con
 	// Execute each priority group sequentially
 	var allResults []*GuardrailResult
 	currentContent := content // Track content modifications
+	scope, _ := ScopeFromContext(ctx)
+	input := ParseInput(layer, currentContent, scope) // Parsed once, shared by structured guardrails
 	
+	var modifiedBy, discarded []string
 	for _, priority := range priorities {
-		groupGuardrails := priorityGroups[priority]
-		
-		// Execute this priority group in parallel
-		groupResult, err := e.executeGroupParallel(ctx, requestID, currentContent, groupGuardrails, layer, originalResponse, overrideResponse)
-		if err != nil {
-			return &ExecutionResult{
-				Passed:        false,
-				FailureReason: fmt.Sprintf("Group execution failed: %v", err),
-				Results:       allResults,
-			}, nil
+		// Guardrails that modify content run one at a time after the rest of
+		// their group, so each sees the content the one before produced
+		parallel, chain := e.splitModifying(priorityGroups[priority])
+		stages := make([][]Guardrail, 0, len(chain)+1)
+		if len(parallel) > 0 {
+			stages = append(stages, parallel)
 		}
-		
-		// If any guardrail in this group failed, stop execution immediately
-		if !groupResult.Passed {
-			// Append results from this group and return failure
-			allResults = append(allResults, groupResult.Results...)
-			return &ExecutionResult{
-				Passed:          false,
-				FailedGuardrail: groupResult.FailedGuardrail,
-				FailureReason:   groupResult.FailureReason,
-				Results:         allResults,
-			}, nil
+		for _, g := range chain {
+			stages = append(stages, []Guardrail{g})
 		}
-		
-		// All guardrails in this group passed - append results
-		allResults = append(allResults, groupResult.Results...)
-		
-		// Check if any guardrail in this group modified the content
-		for _, result := range groupResult.Results {
-			if result != nil && result.Result != nil && result.Result.ModifiedContent != nil {
-				currentContent = *result.Result.ModifiedContent // Use modified content for next priority group
-				break // Use first modification found in this priority group
+
+		for _, stage := range stages {
+			groupResult, err := e.executeGroupParallel(ctx, requestID, input, stage, layer, originalResponse, overrideResponse)
+			if err != nil {
+				return &ExecutionResult{
+					Passed:        false,
+					FailureReason: fmt.Sprintf("Group execution failed: %v", err),
+					Results:       allResults,
+				}, nil
+			}
+			
+			// If any guardrail in this group failed, stop execution immediately
+			if !groupResult.Passed {
+				// Append results from this group and return failure
+				allResults = append(allResults, groupResult.Results...)
+				return &ExecutionResult{
+					Passed:          false,
+					FailedGuardrail: groupResult.FailedGuardrail,
+					FailureReason:   groupResult.FailureReason,
+					FailureCategory: groupResult.FailureCategory,
+					Results:         allResults,
+				}, nil
+			}
+			
+			// All guardrails in this group passed - append results
+			allResults = append(allResults, groupResult.Results...)
+			
+			// Apply this stage's modification, if any, for the guardrails after it
+			modification, conflicting := resolveModifications(groupResult.Results)
+			if modification != nil {
+				currentContent = *modification.Result.ModifiedContent
+				input = ParseInput(layer, currentContent, scope)
+				modifiedBy = append(modifiedBy, modification.Name)
+				discarded = append(discarded, conflicting...)
 			}
 		}
 	}
 	
 	// All guardrails in all priority groups passed
-	return &ExecutionResult{
-		Passed:  true,
-		Results: allResults,
-	}, nil
+	result := &ExecutionResult{
+		Passed:                 true,
+		Results:                allResults,
+		ModifiedBy:             modifiedBy,
+		DiscardedModifications: discarded,
+	}
+	if len(modifiedBy) > 0 {
+		result.ModifiedContent = &currentContent
+	}
+	return result, nil
 }
 
 // executeGroupParallel executes a group of guardrails (same priority) in parallel
-func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID, content string, guardrails []Guardrail, layer string, originalResponse, overrideResponse []byte) (*ExecutionResult, error) {
+func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID, input *GuardrailInput, guardrails []Guardrail, layer string, originalResponse, overrideResponse []byte) (*ExecutionResult, error) {
 	if len(guardrails) == 0 {
 		return &ExecutionResult{Passed: true, Results: []*GuardrailResult{}}, nil
 	}
@@ -151,6 +197,7 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 		
 		g.Go(func() error {
 			startTime := time.Now()
+			priority := e.Priority(guardrail)
 			
 			// Check if context already cancelled
 			select {
@@ -159,10 +206,37 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 			default:
 			}
 			
-			// Execute guardrail with instrumentation
-			result, err := guardrail.Check(ctx, content)
+			// Skip guardrails whose circuit is open, letting the request through
+			policy := e.policy(guardrail.Name())
+			health := e.Health(guardrail.Name())
+			if !health.Allow() {
+				result := &Result{
+					Passed:   true,
+					Reason:   "Skipped: circuit open",
+					Metadata: map[string]interface{}{"circuit": CircuitOpen, "skipped": true},
+				}
+				e.recordMetric(ctx, &Metric{
+					ID:            uuid.New(),
+					RequestID:     requestID,
+					GuardrailName: guardrail.Name(),
+					Layer:         layer,
+					Priority:      priority,
+					Passed:        true,
+					StartTime:     startTime,
+					EndTime:       time.Now(),
+					Metadata:      result.Metadata,
+				})
+				resultsMu.Lock()
+				results[i] = &GuardrailResult{Name: guardrail.Name(), Priority: priority, Result: result}
+				resultsMu.Unlock()
+				return nil
+			}
+
+			// Execute guardrail with instrumentation, under its own timeout if it has one
+			result, err := checkWithTimeout(ctx, guardrail, input, policy.Timeout)
 			
 			duration := time.Since(startTime)
+			health.Record(duration, err)
 			
 			// Create metric for this execution
 			metric := &Metric{
@@ -170,7 +244,7 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 				RequestID:     requestID,
 				GuardrailName: guardrail.Name(),
 				Layer:         layer,
-				Priority:      guardrail.Priority(),
+				Priority:      priority,
 				StartTime:     startTime,
 				EndTime:       time.Now(),
 				DurationMs:    duration.Milliseconds(),
@@ -181,18 +255,35 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 				errStr := err.Error()
 				metric.Error = &errStr
 				metric.Passed = false
-				
-				// Write metric asynchronously
-				if e.metricsWriter != nil {
-					e.metricsWriter.Write(metric)
+
+				// Fail open: record the error and let the request through
+				if policy.OnError == OnErrorAllow {
+					metric.Passed = true
+					e.recordMetric(ctx, metric)
+
+					resultsMu.Lock()
+					results[i] = &GuardrailResult{
+						Name:     guardrail.Name(),
+						Priority: priority,
+						Result: &Result{
+							Passed:   true,
+							Reason:   fmt.Sprintf("Guardrail error ignored: %v", err),
+							Metadata: map[string]interface{}{"error": errStr, "on_error": OnErrorAllow},
+						},
+						Duration: duration,
+					}
+					resultsMu.Unlock()
+					return nil
 				}
 				
+				e.recordMetric(ctx, metric)
+				
 				// Track failure if it's the highest priority so far
 				failureMu.Lock()
-				if firstFailure == nil || guardrail.Priority() < firstFailure.Priority {
+				if firstFailure == nil || priority < firstFailure.Priority {
 					firstFailure = &GuardrailFailure{
 						Name:     guardrail.Name(),
-						Priority: guardrail.Priority(),
+						Priority: priority,
 						Reason:   err.Error(),
 					}
 				}
@@ -210,26 +301,30 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 			if !result.Passed && layer == "output" && originalResponse != nil && overrideResponse != nil {
 				originalStr := string(originalResponse)
 				overrideStr := string(overrideResponse)
+				if e.redactResponse != nil {
+					originalStr = e.redactResponse(originalStr)
+					overrideStr = e.redactResponse(overrideStr)
+				}
 				metric.OriginalResponse = &originalStr
 				metric.OverrideResponse = &overrideStr
 				metric.ResponseOverridden = true
 			}
 			
-			// Write metric asynchronously
-			if e.metricsWriter != nil {
-				e.metricsWriter.Write(metric)
-			}
+			e.recordMetric(ctx, metric)
 			
 			// Check if guardrail passed
 			if !result.Passed {
 				// Track failure if it's the highest priority so far
 				failureMu.Lock()
-				if firstFailure == nil || guardrail.Priority() < firstFailure.Priority {
+				if firstFailure == nil || priority < firstFailure.Priority {
 					firstFailure = &GuardrailFailure{
 						Name:     guardrail.Name(),
-						Priority: guardrail.Priority(),
+						Priority: priority,
 						Reason:   result.Reason,
 					}
+					if category, ok := result.Metadata["category"].(string); ok {
+						firstFailure.Category = category
+					}
 				}
 				failureMu.Unlock()
 				
@@ -240,7 +335,7 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 			resultsMu.Lock()
 			results[i] = &GuardrailResult{
 				Name:     guardrail.Name(),
-				Priority: guardrail.Priority(),
+				Priority: priority,
 				Result:   result,
 				Duration: duration,
 			}
@@ -259,6 +354,7 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 			Passed:          false,
 			FailedGuardrail: firstFailure.Name,
 			FailureReason:   firstFailure.Reason,
+			FailureCategory: firstFailure.Category,
 			Results:         results,
 		}, nil
 	}
@@ -279,6 +375,51 @@ func (e *Executor) executeGroupParallel(ctx context.Context, requestID uuid.UUID
 	}, nil
 }
 
+// policy returns a guardrail's policy; guardrails without one block on errors
+func (e *Executor) policy(name string) Policy {
+	if policy, ok := e.policies[name]; ok {
+		return policy
+	}
+	return Policy{OnError: OnErrorBlock}
+}
+
+// Health returns a guardrail's stats and circuit, creating them on first use
+func (e *Executor) Health(name string) *Health {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+
+	health, ok := e.health[name]
+	if !ok {
+		health = NewHealth(name, e.policy(name).Circuit)
+		e.health[name] = health
+	}
+	return health
+}
+
+// recordMetric writes a metric with the request's log entry when the log
+// outbox is enabled, and asynchronously on its own otherwise
+func (e *Executor) recordMetric(ctx context.Context, metric *Metric) {
+	// Attribute the check to its caller, so metrics can be broken down by key, tenant or user
+	if client, ok := ClientFromContext(ctx); ok {
+		metadata := make(map[string]interface{}, len(metric.Metadata)+1)
+		for key, value := range metric.Metadata {
+			metadata[key] = value // Copied, as results share their metadata
+		}
+		metadata["client"] = client
+		metric.Metadata = metadata
+	}
+	if outbox := storage.OutboxFromContext(ctx); outbox != nil {
+		if metric.CreatedAt.IsZero() {
+			metric.CreatedAt = time.Now()
+		}
+		outbox.AddGuardrailMetric(metric)
+		return
+	}
+	if e.metricsWriter != nil {
+		e.metricsWriter.Write(metric)
+	}
+}
+
 // AddInputGuardrail adds an input guardrail to the executor
 func (e *Executor) AddInputGuardrail(guardrail Guardrail) {
 	e.inputGuardrails = append(e.inputGuardrails, guardrail)
@@ -309,6 +450,13 @@ func (e *Executor) GetOutputGuardrails() []Guardrail {
 	return e.outputGuardrails
 }
 
+// Flush waits for buffered guardrail metrics to be written
+func (e *Executor) Flush() {
+	if e.metricsWriter != nil {
+		e.metricsWriter.Flush()
+	}
+}
+
 // Close gracefully shuts down the executor
 func (e *Executor) Close() error {
 	if e.metricsWriter != nil {
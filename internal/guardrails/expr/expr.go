@@ -0,0 +1,867 @@
+// Package expr implements a small expression language for guardrail rules
+// written in YAML, such as
+//
+//	model == "gpt-4" && len(messages) > 50
+//
+// Expressions have numbers, strings, booleans, nil and lists; variables and
+// their fields (body.max_tokens, messages[0].content); the operators
+// || && ! == != < <= > >= + - * / %, in, contains, startsWith, endsWith and
+// matches (a regular expression); and the functions in functions. "and",
+// "or" and "not" may be used for &&, || and !.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression
+func Compile(source string) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+// String returns the expression's source
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression with the given variables
+func (p *Program) Eval(env map[string]interface{}) (interface{}, error) {
+	return p.root.eval(env)
+}
+
+// EvalBool evaluates an expression that must produce true or false
+func (p *Program) EvalBool(env map[string]interface{}) (bool, error) {
+	v, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression produced %s, not a boolean", typeName(v))
+	}
+	return b, nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{} // Numbers and strings
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value.(string))
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators lists the symbols the lexer knows, longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "(", ")", "[", "]", ",", ".", "!", "<", ">", "+", "-", "*", "/", "%"}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		r, size := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		case r >= '0' && r <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.' || source[i] == '_') {
+				i++
+			}
+			text := source[start:i]
+			n, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: n, pos: start})
+
+		case r == '"' || r == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(source) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				c := source[i]
+				if c == byte(r) {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(source[i])
+					}
+					i++
+					continue
+				}
+				b.WriteByte(c)
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[start:i], value: b.String(), pos: start})
+
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(source) {
+				r, size := utf8.DecodeRuneInString(source[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:i], pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// Parser
+
+// binaryPrecedence gives the binding power of infix operators; higher binds tighter
+var binaryPrecedence = map[string]int{
+	"||": 1, "or": 1,
+	"&&": 2, "and": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"in": 3, "contains": 3, "startsWith": 3, "endsWith": 3, "matches": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+// unaryPrecedence binds ! and - tighter than any infix operator
+const unaryPrecedence = 6
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(op string) error {
+	if tok := p.next(); tok.kind != tokenOperator || tok.text != op {
+		return fmt.Errorf("expected %q, found %s at offset %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+// infix returns the operator at the current token, if it is one
+func (p *parser) infix() (string, int, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOperator && tok.kind != tokenIdent {
+		return "", 0, false
+	}
+	precedence, ok := binaryPrecedence[tok.text]
+	return tok.text, precedence, ok
+}
+
+// expression parses operators binding tighter than minPrecedence
+func (p *parser) expression(minPrecedence int) (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, precedence, ok := p.infix()
+		if !ok || precedence <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.expression(precedence)
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinary(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if (tok.kind == tokenOperator && (tok.text == "!" || tok.text == "-")) || (tok.kind == tokenIdent && tok.text == "not") {
+		p.next()
+		operand, err := p.expression(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "-" {
+			return &negateNode{operand}, nil
+		}
+		return &notNode{operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokenOperator {
+			return n, nil
+		}
+		switch tok.text {
+		case ".":
+			p.next()
+			field := p.next()
+			if field.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a field name after \".\", found %s at offset %d", field, field.pos)
+			}
+			n = &indexNode{target: n, index: &literalNode{field.text}}
+		case "[":
+			p.next()
+			index, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{tok.value}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "nil", "null":
+			return &literalNode{nil}, nil
+		}
+		if next := p.peek(); next.kind == tokenOperator && next.text == "(" {
+			return p.call(tok)
+		}
+		return &variableNode{tok.text}, nil
+
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			n, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			list := &listNode{}
+			if next := p.peek(); next.kind == tokenOperator && next.text == "]" {
+				p.next()
+				return list, nil
+			}
+			for {
+				item, err := p.expression(0)
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				sep := p.next()
+				if sep.kind == tokenOperator && sep.text == "]" {
+					return list, nil
+				}
+				if sep.kind != tokenOperator || sep.text != "," {
+					return nil, fmt.Errorf("expected \",\" or \"]\", found %s at offset %d", sep, sep.pos)
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at offset %d", name.text, name.pos)
+	}
+	p.next() // (
+	c := &callNode{name: name.text, fn: fn}
+	if next := p.peek(); next.kind == tokenOperator && next.text == ")" {
+		p.next()
+	} else {
+		for {
+			arg, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			sep := p.next()
+			if sep.kind == tokenOperator && sep.text == ")" {
+				break
+			}
+			if sep.kind != tokenOperator || sep.text != "," {
+				return nil, fmt.Errorf("expected \",\" or \")\", found %s at offset %d", sep, sep.pos)
+			}
+		}
+	}
+	if fn.args >= 0 && len(c.args) != fn.args {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name.text, fn.args, len(c.args))
+	}
+	return c, nil
+}
+
+// Evaluation
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+// eval returns nil for variables that aren't set, so rules can test for them
+func (n *variableNode) eval(env map[string]interface{}) (interface{}, error) {
+	return normalize(env[n.name]), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(env map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type indexNode struct {
+	target node
+	index  node
+}
+
+// eval looks up a map key or list element. Missing keys and fields of nil
+// give nil; list indexes out of range are errors.
+func (n *indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, not %s", typeName(index))
+		}
+		return normalize(t[key]), nil
+	case []interface{}:
+		f, ok := index.(float64)
+		if !ok || f != float64(int(f)) {
+			return nil, fmt.Errorf("list index must be a whole number, not %v", index)
+		}
+		i := int(f)
+		if i < 0 {
+			i += len(t)
+		}
+		if i < 0 || i >= len(t) {
+			return nil, fmt.Errorf("list index %d out of range (length %d)", int(f), len(t))
+		}
+		return normalize(t[i]), nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(target))
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a boolean, not %s", typeName(v))
+	}
+	return !b, nil
+}
+
+type negateNode struct {
+	operand node
+}
+
+func (n *negateNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("- needs a number, not %s", typeName(v))
+	}
+	return -f, nil
+}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+// eval short-circuits, so the right side may assume the left held
+func (n *logicalNode) eval(env map[string]interface{}) (interface{}, error) {
+	for i, operand := range []node{n.left, n.right} {
+		v, err := operand.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			op := "||"
+			if n.and {
+				op = "&&"
+			}
+			return nil, fmt.Errorf("%s needs booleans, not %s", op, typeName(v))
+		}
+		if i == 0 && b != n.and {
+			return b, nil
+		}
+		if i == 1 {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+	pattern     *regexp.Regexp // matches with a literal pattern, compiled once
+}
+
+func newBinary(op string, left, right node) (node, error) {
+	switch op {
+	case "&&", "and":
+		return &logicalNode{and: true, left: left, right: right}, nil
+	case "||", "or":
+		return &logicalNode{left: left, right: right}, nil
+	}
+	n := &binaryNode{op: op, left: left, right: right}
+	if lit, ok := right.(*literalNode); ok && op == "matches" {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches needs a string pattern")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		n.pattern = re
+	}
+	return n, nil
+}
+
+func (n *binaryNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	case "contains":
+		return contains(left, right)
+	case "startsWith", "endsWith":
+		s, ok1 := left.(string)
+		prefix, ok2 := right.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s needs strings, not %s and %s", n.op, typeName(left), typeName(right))
+		}
+		if n.op == "startsWith" {
+			return strings.HasPrefix(s, prefix), nil
+		}
+		return strings.HasSuffix(s, prefix), nil
+	case "matches":
+		return match(left, right, n.pattern)
+	}
+
+	// Ordering against a missing field is false rather than an error, so
+	// body.max_tokens > 4096 holds only for requests that set max_tokens
+	if left == nil || right == nil {
+		switch n.op {
+		case "<", "<=", ">", ">=":
+			return false, nil
+		}
+	}
+
+	if n.op == "+" {
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			switch n.op {
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		}
+	}
+
+	l, ok1 := left.(float64)
+	r, ok2 := right.(float64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s needs numbers, not %s and %s", n.op, typeName(left), typeName(right))
+	}
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		// Floats throughout: converting to integers would truncate 0.5 to a zero divisor
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+// function is a built-in function; args is -1 for any number of arguments
+type function struct {
+	args int
+	call func(args []interface{}) (interface{}, error)
+}
+
+// functions are the built-in functions
+var functions = map[string]function{
+	"len": {1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("cannot take the length of %s", typeName(args[0]))
+	}},
+	"lower": {1, stringFunction(strings.ToLower)},
+	"upper": {1, stringFunction(strings.ToUpper)},
+	"trim":  {1, stringFunction(strings.TrimSpace)},
+	"contains": {2, func(args []interface{}) (interface{}, error) {
+		return contains(args[0], args[1])
+	}},
+	"startsWith": {2, func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		prefix, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("needs strings")
+		}
+		return strings.HasPrefix(s, prefix), nil
+	}},
+	"endsWith": {2, func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		suffix, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("needs strings")
+		}
+		return strings.HasSuffix(s, suffix), nil
+	}},
+	"matches": {2, func(args []interface{}) (interface{}, error) {
+		return match(args[0], args[1], nil)
+	}},
+	// count returns how many list elements equal a value, e.g. count(roles, "user")
+	"count": {2, func(args []interface{}) (interface{}, error) {
+		list, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("needs a list, not %s", typeName(args[0]))
+		}
+		n := 0
+		for _, item := range list {
+			if equal(normalize(item), args[1]) {
+				n++
+			}
+		}
+		return float64(n), nil
+	}},
+	// number converts a string such as a header value to a number, or nil
+	"number": {1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+func stringFunction(fn func(string) string) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			if args[0] == nil {
+				return "", nil
+			}
+			return nil, fmt.Errorf("needs a string, not %s", typeName(args[0]))
+		}
+		return fn(s), nil
+	}
+}
+
+// contains reports whether a string has a substring, a list has an element
+// or a map has a key
+func contains(container, item interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot look for %s in a string", typeName(item))
+		}
+		return strings.Contains(c, s), nil
+	case []interface{}:
+		for _, element := range c {
+			if equal(normalize(element), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c[key]
+		return found, nil
+	case nil:
+		return false, nil
+	}
+	return nil, fmt.Errorf("cannot look inside %s", typeName(container))
+}
+
+// match reports whether a string matches a regular expression
+func match(value, pattern interface{}, compiled *regexp.Regexp) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		if value == nil {
+			return false, nil
+		}
+		return nil, fmt.Errorf("matches needs a string, not %s", typeName(value))
+	}
+	if compiled == nil {
+		p, ok := pattern.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches needs a string pattern, not %s", typeName(pattern))
+		}
+		var err error
+		if compiled, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return compiled.MatchString(s), nil
+}
+
+// equal compares values; numbers compare by value whatever their Go type
+func equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	switch a.(type) {
+	case []interface{}, map[string]interface{}:
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+// normalize turns the Go values variables may hold into expression values:
+// every number becomes float64
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case []string:
+		list := make([]interface{}, len(n))
+		for i, s := range n {
+			list[i] = s
+		}
+		return list
+	}
+	return v
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", v)
+}
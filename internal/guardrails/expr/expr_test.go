@@ -0,0 +1,193 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{source: "", wantErr: "unexpected end of expression"},
+		{source: "model ==", wantErr: "unexpected end of expression"},
+		{source: "(model == \"a\"", wantErr: `expected ")"`},
+		{source: "model == \"a", wantErr: "unterminated string"},
+		{source: "model # 1", wantErr: "unexpected character"},
+		{source: "1.2.3 > 0", wantErr: "invalid number"},
+		{source: "model model", wantErr: `unexpected "model"`},
+		{source: "body.", wantErr: "expected a field name"},
+		{source: "[1, 2", wantErr: `expected "," or "]"`},
+		{source: "frobnicate(model)", wantErr: "unknown function frobnicate"},
+		{source: "len(a, b)", wantErr: "len takes 1 arguments, got 2"},
+		{source: "model matches \"[\"", wantErr: "invalid pattern"},
+		{source: "model matches 1", wantErr: "matches needs a string pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile(%q) error = %v, want error containing %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	env := map[string]interface{}{
+		"model": "gpt-4",
+		"body": map[string]interface{}{
+			"max_tokens":  8192,
+			"temperature": 0.5,
+		},
+		"messages": []interface{}{
+			map[string]interface{}{"role": "system", "content": "Be brief."},
+			map[string]interface{}{"role": "user", "content": "Hello, World"},
+		},
+		"roles":   []string{"system", "user", "user"},
+		"headers": map[string]interface{}{"x-priority": " 7 "},
+	}
+
+	tests := []struct {
+		source  string
+		want    interface{}
+		wantErr string
+	}{
+		// Literals and arithmetic
+		{source: "1 + 2 * 3", want: 7.0},
+		{source: "(1 + 2) * 3", want: 9.0},
+		{source: "10 - 4 - 3", want: 3.0},
+		{source: "-2 * 3", want: -6.0},
+		{source: "7 % 4", want: 3.0},
+		{source: "1.5 % 0.5", want: 0.0},
+		{source: "1_000 / 4", want: 250.0},
+		{source: `"a" + 'b'`, want: "ab"},
+		{source: `"line\n"`, want: "line\n"},
+		{source: "1 / 0", wantErr: "division by zero"},
+		{source: "1 % 0", wantErr: "division by zero"},
+		{source: `1 + "a"`, wantErr: "+ needs numbers"},
+
+		// Variables, fields and indexes
+		{source: "model", want: "gpt-4"},
+		{source: "body.max_tokens", want: 8192.0},
+		{source: `body["temperature"]`, want: 0.5},
+		{source: "messages[1].content", want: "Hello, World"},
+		{source: "messages[-1].role", want: "user"},
+		{source: "missing", want: nil},
+		{source: "missing.field", want: nil},
+		{source: "body.stop", want: nil},
+		{source: "messages[2]", wantErr: "out of range"},
+		{source: "messages[0.5]", wantErr: "whole number"},
+		{source: "model.field", wantErr: "cannot index a string"},
+
+		// Comparison
+		{source: `model == "gpt-4"`, want: true},
+		{source: `model != "gpt-4"`, want: false},
+		{source: "body.max_tokens > 4096", want: true},
+		{source: "body.max_tokens <= 4096", want: false},
+		{source: "body.stop > 4096", want: false},
+		{source: "body.stop == nil", want: true},
+		{source: `"a" < "b"`, want: true},
+		{source: "roles == [\"system\", \"user\", \"user\"]", want: true},
+		{source: `model > 1`, wantErr: "> needs numbers"},
+
+		// Logic
+		{source: `model == "gpt-4" && len(messages) > 1`, want: true},
+		{source: `model == "gpt-3" || body.max_tokens > 4096`, want: true},
+		{source: `model == "gpt-4" and not (body.max_tokens < 100)`, want: true},
+		{source: `!true or false`, want: false},
+		{source: `false && missing.field > 1`, want: false},
+		{source: `true || 1`, want: true},
+		{source: `false || 1`, wantErr: "|| needs booleans"},
+		{source: `!model`, wantErr: "! needs a boolean"},
+		{source: `-model`, wantErr: "- needs a number"},
+
+		// String and list operators
+		{source: `"user" in roles`, want: true},
+		{source: `"tool" in roles`, want: false},
+		{source: `"max_tokens" in body`, want: true},
+		{source: `messages[1].content contains "World"`, want: true},
+		{source: `model startsWith "gpt"`, want: true},
+		{source: `model endsWith "-3"`, want: false},
+		{source: `model matches "^gpt-[0-9]$"`, want: true},
+		{source: `missing matches "x"`, want: false},
+		{source: `model contains 1`, wantErr: "cannot look for a number in a string"},
+		{source: `1 in 2`, wantErr: "cannot look inside a number"},
+		{source: `model startsWith 1`, wantErr: "startsWith needs strings"},
+
+		// Functions
+		{source: "len(model)", want: 5.0},
+		{source: "len(messages)", want: 2.0},
+		{source: "len(missing)", want: 0.0},
+		{source: `lower(messages[1].content)`, want: "hello, world"},
+		{source: `upper(missing)`, want: ""},
+		{source: `trim("  x ")`, want: "x"},
+		{source: `count(roles, "user")`, want: 2.0},
+		{source: `number(headers["x-priority"]) >= 5`, want: true},
+		{source: `number("high")`, want: nil},
+		{source: `matches(model, "GPT")`, want: false},
+		{source: `contains(roles, "system")`, want: true},
+		{source: `startsWith(model, "gpt")`, want: true},
+		{source: `endsWith(model, "4")`, want: true},
+		{source: `len(1)`, wantErr: "len: cannot take the length of a number"},
+		{source: `count(model, "g")`, wantErr: "count: needs a list"},
+		{source: `matches(model, "[")`, wantErr: "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile(%q) error = %v", tt.source, err)
+			}
+			got, err := program.Eval(env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Eval() = %v, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Eval() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	env := map[string]interface{}{"model": "gpt-4"}
+
+	tests := []struct {
+		source  string
+		want    bool
+		wantErr string
+	}{
+		{source: `model == "gpt-4"`, want: true},
+		{source: `model == "gpt-3"`, want: false},
+		{source: `model`, wantErr: "produced a string, not a boolean"},
+		{source: `missing`, wantErr: "produced nil, not a boolean"},
+		{source: `1 / 0 > 1`, wantErr: "division by zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile(%q) error = %v", tt.source, err)
+			}
+			got, err := program.EvalBool(env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EvalBool() = %v, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("EvalBool() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package expr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Actions taken when a rule matches
+const (
+	ActionBlock = "block" // Fail the check at the first matching rule (default)
+	ActionFlag  = "flag"  // Pass, recording every matching rule in the metadata
+)
+
+// Rule is a condition that blocks or flags content when it holds
+type Rule struct {
+	Name     string `json:"name"`
+	When     string `json:"when"`
+	Reason   string `json:"reason"`
+	Category string `json:"category"`
+	Action   string `json:"action"` // Overrides the guardrail's action
+}
+
+// Config structure for the expression guardrail. A single rule may be given
+// inline with when, reason and category instead of a rules list.
+type Config struct {
+	Rules    []Rule `json:"rules"`
+	When     string `json:"when"`
+	Reason   string `json:"reason"`
+	Category string `json:"category"`
+	Action   string `json:"action"`
+}
+
+type compiledRule struct {
+	Rule
+	program *Program
+}
+
+// Guardrail evaluates operator-written rules against each request or response
+type Guardrail struct {
+	name     string
+	priority int
+	rules    []compiledRule
+}
+
+// NewGuardrail compiles the configured rules; a rule that doesn't parse is a
+// configuration error
+func NewGuardrail(name string, priority int, config map[string]interface{}) (*Guardrail, error) {
+	var cfg Config
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid expr config: %w", err)
+		}
+	}
+
+	rules := cfg.Rules
+	if cfg.When != "" {
+		rules = append([]Rule{{Name: name, When: cfg.When, Reason: cfg.Reason, Category: cfg.Category}}, rules...)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("expr guardrail requires when or rules")
+	}
+
+	g := &Guardrail{name: name, priority: priority}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Action == "" {
+			rule.Action = cfg.Action
+		}
+		switch rule.Action {
+		case ActionBlock, ActionFlag:
+		case "":
+			rule.Action = ActionBlock
+		default:
+			return nil, fmt.Errorf("%s: unknown action: %s", rule.Name, rule.Action)
+		}
+		if strings.TrimSpace(rule.When) == "" {
+			return nil, fmt.Errorf("%s: when is required", rule.Name)
+		}
+		program, err := Compile(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		g.rules = append(g.rules, compiledRule{Rule: rule, program: program})
+	}
+	return g, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (g *Guardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *Guardrail) Priority() int {
+	return g.priority
+}
+
+// Check evaluates the rules against a raw body
+func (g *Guardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return g.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
+
+// CheckRequest evaluates the rules in order. A rule that can't be evaluated,
+// for example comparing a string with a number, is a guardrail error.
+func (g *Guardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	env := Env(ctx, input)
+
+	var flagged []string
+	for _, rule := range g.rules {
+		matched, err := rule.program.EvalBool(env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		if !matched {
+			continue
+		}
+		if rule.Action == ActionFlag {
+			flagged = append(flagged, rule.Name)
+			continue
+		}
+
+		reason := rule.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("Matched rule %s", rule.Name)
+		}
+		metadata := map[string]interface{}{
+			"rule":       rule.Name,
+			"expression": rule.When,
+		}
+		if rule.Category != "" {
+			metadata["category"] = rule.Category
+		}
+		if len(flagged) > 0 {
+			metadata["flagged"] = flagged
+		}
+		return &guardrails.Result{
+			Passed:   false,
+			Reason:   reason,
+			Metadata: metadata,
+		}, nil
+	}
+
+	if len(flagged) > 0 {
+		return &guardrails.Result{
+			Passed: true,
+			Reason: fmt.Sprintf("Flagged by %s", strings.Join(flagged, ", ")),
+			Metadata: map[string]interface{}{
+				"flagged": flagged,
+			},
+		}, nil
+	}
+	return &guardrails.Result{
+		Passed: true,
+		Reason: "No rule matched",
+	}, nil
+}
+
+// Env returns the variables rules can use:
+//
+//	layer, endpoint, provider, model, tenant  strings
+//	user_id, api_key_id  the end user (guardrails.user_header) and key fingerprint
+//	messages       list of {role, content}
+//	roles          list of message roles
+//	message_count  number of messages
+//	last_user      content of the last user message
+//	text           every message's content, joined by newlines
+//	images         list of {role, url, media_type, inline}
+//	body           the parsed JSON body, or nil
+//	headers        request headers by lower-case name, first value only
+func Env(ctx context.Context, input *guardrails.GuardrailInput) map[string]interface{} {
+	messages := make([]interface{}, len(input.Messages))
+	roles := make([]interface{}, len(input.Messages))
+	texts := make([]string, len(input.Messages))
+	lastUser := ""
+	for i, m := range input.Messages {
+		messages[i] = map[string]interface{}{"role": m.Role, "content": m.Content}
+		roles[i] = m.Role
+		texts[i] = m.Content
+		if m.Role == "user" {
+			lastUser = m.Content
+		}
+	}
+
+	images := make([]interface{}, len(input.Images))
+	for i, img := range input.Images {
+		images[i] = map[string]interface{}{
+			"role":       img.Role,
+			"url":        img.URL,
+			"media_type": img.MediaType,
+			"inline":     img.Inline(),
+		}
+	}
+
+	headers := make(map[string]interface{}, len(input.Headers))
+	for name, values := range input.Headers {
+		if len(values) > 0 {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	var body interface{}
+	if input.Raw != "" {
+		if err := json.Unmarshal([]byte(input.Raw), &body); err != nil {
+			body = nil
+		}
+	}
+
+	return map[string]interface{}{
+		"layer":         input.Layer,
+		"endpoint":      input.Endpoint,
+		"provider":      input.Provider,
+		"model":         input.Model,
+		"tenant":        input.Client.Tenant,
+		"user_id":       input.Client.UserID,
+		"api_key_id":    input.Client.APIKeyID,
+		"messages":      messages,
+		"roles":         roles,
+		"message_count": float64(len(messages)),
+		"last_user":     lastUser,
+		"text":          strings.Join(texts, "\n"),
+		"images":        images,
+		"body":          body,
+		"headers":       headers,
+	}
+}
@@ -0,0 +1,175 @@
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ImageGuardrail is implemented by guardrails that check the images attached
+// to a request. The executor prefers CheckImages over CheckRequest and Check,
+// passing every image found in the request, possibly none.
+type ImageGuardrail interface {
+	Guardrail
+	CheckImages(ctx context.Context, images []Image, input *GuardrailInput) (*Result, error)
+}
+
+// Image is an image attached to a request, either a remote URL or inline
+// base64 data
+type Image struct {
+	Role      string `json:"role"`                 // Role of the message the image is attached to
+	URL       string `json:"url,omitempty"`        // Remote images only
+	MediaType string `json:"media_type,omitempty"` // Declared type of inline images, e.g. "image/png"
+	Data      string `json:"-"`                    // Base64 data of inline images
+	Detail    string `json:"detail,omitempty"`     // OpenAI detail hint, if given
+}
+
+// Inline reports whether the image data is in the request rather than at a URL
+func (img Image) Inline() bool {
+	return img.URL == ""
+}
+
+// Size returns the decoded size in bytes of an inline image, or 0 for
+// remote images
+func (img Image) Size() int {
+	data := strings.TrimRight(img.Data, "=")
+	return len(data) * 3 / 4
+}
+
+// Decode returns the bytes of an inline image
+func (img Image) Decode() ([]byte, error) {
+	if !img.Inline() {
+		return nil, fmt.Errorf("image is remote")
+	}
+	data, err := base64.StdEncoding.DecodeString(img.Data)
+	if err != nil {
+		// Some clients drop the padding
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(img.Data, "="))
+	}
+	return data, err
+}
+
+// DataURL returns the image as a URL: the remote URL, or a data URL for
+// inline images
+func (img Image) DataURL() string {
+	if !img.Inline() {
+		return img.URL
+	}
+	return "data:" + img.MediaType + ";base64," + img.Data
+}
+
+// Image formats recognised by ImageFormat
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+// ImageFormat identifies an image from its leading bytes, returning "" for
+// anything other than PNG, JPEG, GIF or WebP. Declared media types are not
+// trusted.
+func ImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return FormatWebP
+	}
+	return ""
+}
+
+// asImageGuardrail returns a guardrail's image checks, looking through
+// applicability filters
+func asImageGuardrail(g Guardrail) (ImageGuardrail, bool) {
+	if scoped, ok := g.(*scopedGuardrail); ok {
+		g = scoped.Guardrail
+	}
+	images, ok := g.(ImageGuardrail)
+	return images, ok
+}
+
+// parseImages extracts the images attached to a request's messages: Chat
+// Completions image_url parts, Responses API input_image parts and Anthropic
+// Messages image blocks
+func parseImages(body map[string]interface{}) []Image {
+	var images []Image
+	for _, key := range []string{"messages", "input"} {
+		list, ok := body[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, entry := range list {
+			item, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			role, _ := item["role"].(string)
+			parts, _ := item["content"].([]interface{})
+			for _, p := range parts {
+				part, ok := p.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if img, ok := partImage(part); ok {
+					img.Role = role
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	return images
+}
+
+// partImage reads an image content part in any of the supported shapes
+func partImage(part map[string]interface{}) (Image, bool) {
+	switch part["type"] {
+	case "image_url", "input_image":
+		var img Image
+		switch url := part["image_url"].(type) {
+		case string:
+			img = imageFromURL(url)
+		case map[string]interface{}:
+			u, _ := url["url"].(string)
+			img = imageFromURL(u)
+			img.Detail, _ = url["detail"].(string)
+		}
+		if detail, ok := part["detail"].(string); ok {
+			img.Detail = detail
+		}
+		return img, img.URL != "" || img.Data != ""
+	case "image":
+		source, ok := part["source"].(map[string]interface{})
+		if !ok {
+			return Image{}, false
+		}
+		var img Image
+		if source["type"] == "url" {
+			img.URL, _ = source["url"].(string)
+		} else {
+			img.MediaType, _ = source["media_type"].(string)
+			img.Data, _ = source["data"].(string)
+		}
+		return img, img.URL != "" || img.Data != ""
+	}
+	return Image{}, false
+}
+
+// imageFromURL splits base64 data URLs into their media type and data
+func imageFromURL(url string) Image {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return Image{URL: url}
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return Image{URL: url}
+	}
+	return Image{MediaType: strings.TrimSuffix(header, ";base64"), Data: data}
+}
@@ -0,0 +1,261 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// StructuredGuardrail is implemented by guardrails that want parsed request or
+// response data instead of the raw body. The executor prefers CheckRequest over
+// Check when a guardrail implements it.
+type StructuredGuardrail interface {
+	Guardrail
+	CheckRequest(ctx context.Context, input *GuardrailInput) (*Result, error)
+}
+
+// EmbeddingsEndpoint returns vectors rather than text, so only its input is checked
+const EmbeddingsEndpoint = "/v1/embeddings"
+
+// Message is a single role-tagged piece of text from a request or response
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// GuardrailInput is the parsed form of the content a guardrail is checking
+type GuardrailInput struct {
+	Layer    string      `json:"layer"` // "input" or "output"
+	Endpoint string      `json:"endpoint,omitempty"`
+	Provider string      `json:"provider,omitempty"`
+	Model    string      `json:"model,omitempty"`
+	Client   Client      `json:"client"`
+	Headers  http.Header `json:"-"`
+	Messages []Message   `json:"messages"`
+	Raw      string      `json:"-"` // Unparsed body, as passed to Check
+
+	// Images are the request's image attachments, for image guardrails
+	Images []Image `json:"images,omitempty"`
+
+	// Batch is set when Messages are independent inputs (embeddings or a list of
+	// prompts) rather than a conversation, so every one of them should be checked
+	Batch bool `json:"batch,omitempty"`
+}
+
+// ParseInput builds a GuardrailInput from a raw request or response body.
+// Chat Completions, Responses, legacy Completions, and Embeddings shapes are
+// understood; anything else yields no messages but keeps Raw. Images are
+// collected from requests only.
+func ParseInput(layer, content string, scope Scope) *GuardrailInput {
+	input := &GuardrailInput{
+		Layer:    layer,
+		Endpoint: scope.Endpoint,
+		Provider: scope.Provider,
+		Model:    scope.Model,
+		Client:   scope.Client,
+		Headers:  scope.Headers,
+		Raw:      content,
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &body); err != nil {
+		return input
+	}
+	if model, ok := body["model"].(string); ok && input.Model == "" {
+		input.Model = model
+	}
+
+	if layer == "output" {
+		input.Messages = parseOutputMessages(body)
+	} else {
+		input.Messages = parseInputMessages(body)
+		input.Images = parseImages(body)
+		input.Batch = isBatch(body)
+	}
+	return input
+}
+
+// isBatch reports whether a request carries a list of independent inputs:
+// embeddings input arrays or legacy Completions prompt arrays
+func isBatch(body map[string]interface{}) bool {
+	if _, ok := body["messages"]; ok {
+		return false
+	}
+	for _, key := range []string{"input", "prompt"} {
+		list, ok := body[key].([]interface{})
+		if !ok || len(list) < 2 {
+			continue
+		}
+		// A list of numbers is a single pre-tokenized input
+		switch list[0].(type) {
+		case string, []interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// LastUserMessage returns the most recent user-authored text, or ""
+func (in *GuardrailInput) LastUserMessage() string {
+	for i := len(in.Messages) - 1; i >= 0; i-- {
+		if in.Messages[i].Role == "user" {
+			return in.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// MessagesByRole returns the messages with the given role, in order
+func (in *GuardrailInput) MessagesByRole(role string) []Message {
+	var matched []Message
+	for _, m := range in.Messages {
+		if m.Role == role {
+			matched = append(matched, m)
+		}
+	}
+	return matched
+}
+
+// Text joins every message's content, one per line
+func (in *GuardrailInput) Text() string {
+	parts := make([]string, 0, len(in.Messages))
+	for _, m := range in.Messages {
+		if m.Content != "" {
+			parts = append(parts, m.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// parseInputMessages extracts messages from a request body
+func parseInputMessages(body map[string]interface{}) []Message {
+	var messages []Message
+
+	// Responses API instructions act as a system prompt
+	if instructions, ok := body["instructions"].(string); ok && instructions != "" {
+		messages = append(messages, Message{Role: "system", Content: instructions})
+	}
+
+	// Chat Completions messages
+	if list, ok := body["messages"].([]interface{}); ok {
+		messages = append(messages, parseMessageList(list)...)
+	}
+
+	// Responses API input (string or list of items) and Embeddings input
+	switch input := body["input"].(type) {
+	case string:
+		messages = append(messages, Message{Role: "user", Content: input})
+	case []interface{}:
+		if items := parseMessageList(input); len(items) > 0 {
+			messages = append(messages, items...)
+		} else {
+			for _, item := range input {
+				if text, ok := item.(string); ok {
+					messages = append(messages, Message{Role: "user", Content: text})
+				}
+			}
+		}
+	}
+
+	// Legacy Completions prompt (string or list of strings)
+	switch prompt := body["prompt"].(type) {
+	case string:
+		messages = append(messages, Message{Role: "user", Content: prompt})
+	case []interface{}:
+		for _, item := range prompt {
+			if text, ok := item.(string); ok {
+				messages = append(messages, Message{Role: "user", Content: text})
+			}
+		}
+	}
+
+	// Fall back to a bare content field
+	if len(messages) == 0 {
+		if content, ok := body["content"].(string); ok {
+			messages = append(messages, Message{Role: "user", Content: content})
+		}
+	}
+
+	return messages
+}
+
+// parseOutputMessages extracts generated text from a response body
+func parseOutputMessages(body map[string]interface{}) []Message {
+	var messages []Message
+
+	// Chat Completions and legacy Completions choices
+	if choices, ok := body["choices"].([]interface{}); ok {
+		for _, c := range choices {
+			choice, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if message, ok := choice["message"].(map[string]interface{}); ok {
+				role, _ := message["role"].(string)
+				if role == "" {
+					role = "assistant"
+				}
+				messages = append(messages, Message{Role: role, Content: contentText(message["content"])})
+			} else if text, ok := choice["text"].(string); ok {
+				messages = append(messages, Message{Role: "assistant", Content: text})
+			}
+		}
+	}
+
+	// Responses API output items
+	if output, ok := body["output"].([]interface{}); ok {
+		for _, o := range output {
+			item, ok := o.(map[string]interface{})
+			if !ok || item["type"] != "message" {
+				continue
+			}
+			role, _ := item["role"].(string)
+			if role == "" {
+				role = "assistant"
+			}
+			messages = append(messages, Message{Role: role, Content: contentText(item["content"])})
+		}
+	}
+
+	return messages
+}
+
+// parseMessageList converts a list of {role, content} objects into messages
+func parseMessageList(list []interface{}) []Message {
+	var messages []Message
+	for _, entry := range list {
+		item, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		role, ok := item["role"].(string)
+		if !ok {
+			continue
+		}
+		messages = append(messages, Message{Role: role, Content: contentText(item["content"])})
+	}
+	return messages
+}
+
+// contentText flattens string or multi-part content into plain text, skipping
+// non-text parts such as images
+func contentText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, p := range c {
+			part, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,195 @@
+package language
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/abadojack/whatlanggo"
+)
+
+// Actions taken when content is in a language outside the allow-list
+const (
+	ActionBlock = "block" // Fail the check (default)
+	ActionFlag  = "flag"  // Pass, but mark the result in metric metadata
+)
+
+// Config structure for the language guardrail
+type Config struct {
+	Allowed       []string `json:"allowed"`        // ISO 639-1 ("en") or 639-3 ("eng") codes
+	Action        string   `json:"action"`         // block | flag
+	MinConfidence float64  `json:"min_confidence"` // Detections below this are treated as unknown, default 0.5
+	MinLength     int      `json:"min_length"`     // Shorter texts are not checked, default 20 characters
+	Target        string   `json:"target"`         // "last_user" (default) or "all"
+}
+
+// LanguageGuardrail detects the language of user content and enforces an allow-list
+type LanguageGuardrail struct {
+	name          string
+	priority      int
+	allowed       map[whatlanggo.Lang]bool
+	allowedCodes  []string
+	action        string
+	minConfidence guardrails.Threshold
+	minLength     int
+	target        string
+}
+
+// NewLanguageGuardrail creates a new language allow-list guardrail
+func NewLanguageGuardrail(name string, priority int, config map[string]interface{}) (*LanguageGuardrail, error) {
+	var cfg Config
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid language config: %w", err)
+		}
+	}
+
+	if len(cfg.Allowed) == 0 {
+		return nil, fmt.Errorf("language guardrail requires at least one allowed language")
+	}
+
+	allowed := make(map[whatlanggo.Lang]bool, len(cfg.Allowed))
+	for _, code := range cfg.Allowed {
+		lang, ok := resolve(code)
+		if !ok {
+			return nil, fmt.Errorf("unknown language code: %s", code)
+		}
+		allowed[lang] = true
+	}
+
+	action := cfg.Action
+	switch action {
+	case ActionBlock, ActionFlag:
+	case "":
+		action = ActionBlock
+	default:
+		return nil, fmt.Errorf("unknown language action: %s", action)
+	}
+
+	if cfg.MinConfidence <= 0 {
+		cfg.MinConfidence = 0.5
+	}
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = 20
+	}
+
+	g := &LanguageGuardrail{
+		name:         name,
+		priority:     priority,
+		allowed:      allowed,
+		allowedCodes: cfg.Allowed,
+		action:       action,
+		minLength:    cfg.MinLength,
+		target:       cfg.Target,
+	}
+	g.minConfidence.Store(cfg.MinConfidence)
+	return g, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (g *LanguageGuardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *LanguageGuardrail) Priority() int {
+	return g.priority
+}
+
+// Threshold returns the minimum detection confidence acted on
+func (g *LanguageGuardrail) Threshold() float64 {
+	return g.minConfidence.Load()
+}
+
+// SetThreshold changes the minimum detection confidence while the gateway runs
+func (g *LanguageGuardrail) SetThreshold(threshold float64) error {
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("min_confidence must be above 0 and at most 1")
+	}
+	g.minConfidence.Store(threshold)
+	return nil
+}
+
+// Check detects the language of a raw request body
+func (g *LanguageGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return g.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
+
+// CheckRequest detects the language of parsed request or response text
+func (g *LanguageGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	text := input.LastUserMessage()
+	if g.target == "all" || input.Layer == "output" {
+		text = input.Text()
+	}
+
+	if utf8.RuneCountInString(strings.TrimSpace(text)) < g.minLength {
+		return &guardrails.Result{
+			Passed: true,
+			Reason: "Text too short for language detection",
+			Metadata: map[string]interface{}{
+				"detection": "skipped",
+			},
+		}, nil
+	}
+
+	info := whatlanggo.Detect(text)
+	confidence := info.Confidence
+	metadata := map[string]interface{}{
+		"language":      code(info.Lang),
+		"language_name": info.Lang.String(),
+		"confidence":    confidence,
+		"script":        whatlanggo.Scripts[info.Script],
+		"allowed":       g.allowedCodes,
+	}
+
+	// Low-confidence detections are not acted on
+	if confidence < g.minConfidence.Load() {
+		metadata["detection"] = "unreliable"
+		return &guardrails.Result{
+			Passed:   true,
+			Score:    &confidence,
+			Reason:   "Language could not be detected reliably",
+			Metadata: metadata,
+		}, nil
+	}
+
+	if g.allowed[info.Lang] {
+		return &guardrails.Result{
+			Passed:   true,
+			Score:    &confidence,
+			Reason:   fmt.Sprintf("Detected allowed language: %s", info.Lang.String()),
+			Metadata: metadata,
+		}, nil
+	}
+
+	reason := fmt.Sprintf("Detected language not in allow-list: %s", info.Lang.String())
+	metadata["flagged"] = true
+	return &guardrails.Result{
+		Passed:   g.action == ActionFlag,
+		Score:    &confidence,
+		Reason:   reason,
+		Metadata: metadata,
+	}, nil
+}
+
+// resolve maps an ISO 639-1 or 639-3 code to a whatlanggo language
+func resolve(value string) (whatlanggo.Lang, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for lang := range whatlanggo.Langs {
+		if lang.Iso6391() == value || lang.Iso6393() == value {
+			return lang, true
+		}
+	}
+	return 0, false
+}
+
+// code prefers the two-letter ISO 639-1 code, falling back to ISO 639-3
+func code(lang whatlanggo.Lang) string {
+	if c := lang.Iso6391(); c != "" {
+		return c
+	}
+	return lang.Iso6393()
+}
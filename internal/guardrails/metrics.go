@@ -132,6 +132,16 @@ func (m *MetricsWriter) worker() {
 	}
 }
 
+// Flush waits for queued metrics to be written
+func (m *MetricsWriter) Flush() {
+	// Let workers pick up queued metrics, then wait for their periodic flush
+	deadline := time.Now().Add(30 * time.Second)
+	for len(m.channel) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(time.Second + 100*time.Millisecond)
+}
+
 // flushBatch writes a batch of metrics to the database
 func (m *MetricsWriter) flushBatch(batch []*Metric) {
 	if len(batch) == 0 {
@@ -0,0 +1,71 @@
+package guardrails
+
+import (
+	"log"
+)
+
+// ModifyingGuardrail is implemented by guardrails that may rewrite the
+// content they check. Guardrails that modify, by this interface or
+// `modifies: true` in their configuration, run one at a time after the rest
+// of their priority group, each seeing the content the one before produced.
+type ModifyingGuardrail interface {
+	Guardrail
+	Modifies() bool
+}
+
+// modifies reports whether a guardrail should run in its group's chain
+func (e *Executor) modifies(g Guardrail) bool {
+	if e.policy(g.Name()).Modifies {
+		return true
+	}
+	if scoped, ok := g.(*scopedGuardrail); ok {
+		g = scoped.Guardrail
+	}
+	modifying, ok := g.(ModifyingGuardrail)
+	return ok && modifying.Modifies()
+}
+
+// splitModifying separates a priority group into guardrails that run in
+// parallel and those chained after them, keeping configuration order
+func (e *Executor) splitModifying(group []Guardrail) (parallel, chain []Guardrail) {
+	for _, g := range group {
+		if e.modifies(g) {
+			chain = append(chain, g)
+		} else {
+			parallel = append(parallel, g)
+		}
+	}
+	return parallel, chain
+}
+
+// resolveModifications picks the content modification from guardrails that
+// ran in parallel. One modification, or several identical ones, is applied.
+// Differing modifications conflict: the first in configuration order wins and
+// the rest are discarded and marked in their result metadata.
+func resolveModifications(results []*GuardrailResult) (*GuardrailResult, []string) {
+	var winner *GuardrailResult
+	var discarded []string
+	for _, r := range results {
+		if r == nil || r.Result == nil || r.Result.ModifiedContent == nil {
+			continue
+		}
+		if winner == nil {
+			winner = r
+			continue
+		}
+		if *r.Result.ModifiedContent == *winner.Result.ModifiedContent {
+			continue
+		}
+		if r.Result.Metadata == nil {
+			r.Result.Metadata = make(map[string]interface{})
+		}
+		r.Result.Metadata["modification_discarded"] = true
+		r.Result.Metadata["modification_conflict_with"] = winner.Name
+		discarded = append(discarded, r.Name)
+	}
+	if len(discarded) > 0 {
+		log.Printf("[GUARDRAIL] Conflicting modifications in priority group %d: kept %s, discarded %v (mark guardrails with modifies: true to chain them)",
+			winner.Priority, winner.Name, discarded)
+	}
+	return winner, discarded
+}
@@ -0,0 +1,243 @@
+package onnx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// runner executes a loaded classification model on one tokenized input and
+// returns the raw logits. The real implementation needs the onnx build tag.
+type runner interface {
+	Run(inputIDs, attentionMask []int64) ([]float32, error)
+	Close() error
+}
+
+// ClassifierConfig configures a local text classification guardrail
+type ClassifierConfig struct {
+	ModelPath   string   `json:"model_path"`   // Path to the .onnx model file
+	VocabPath   string   `json:"vocab_path"`   // WordPiece vocab.txt shipped with the model
+	LibraryPath string   `json:"library_path"` // Optional path to libonnxruntime
+	InputNames  []string `json:"input_names"`  // Default: input_ids, attention_mask
+	OutputName  string   `json:"output_name"`  // Default: logits
+	Labels      []string `json:"labels"`       // Output index -> label name
+	BlockLabels []string `json:"block_labels"` // Labels that fail the check
+	Threshold   float64  `json:"threshold"`    // Score at or above which a block label fails, default 0.5
+	Activation  string   `json:"activation"`   // "softmax" (default) or "sigmoid" for multi-label models
+	MaxLength   int      `json:"max_length"`   // Token limit including [CLS]/[SEP], default 256
+	Lowercase   *bool    `json:"lowercase"`    // Lowercase before tokenizing, default true
+	Target      string   `json:"target"`       // "last_user" (default) or "all"
+}
+
+// ClassifierGuardrail runs a small local model (toxicity, prompt injection, ...)
+// through ONNX Runtime so moderation needs no external API call
+type ClassifierGuardrail struct {
+	name      string
+	priority  int
+	config    ClassifierConfig
+	tokenizer *wordPieceTokenizer
+	runner    runner
+	threshold guardrails.Threshold // Starts at config.Threshold; adjustable at runtime
+}
+
+// NewClassifierGuardrail loads the model and vocabulary described by config
+func NewClassifierGuardrail(name string, priority int, config map[string]interface{}) (*ClassifierGuardrail, error) {
+	var cfg ClassifierConfig
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid onnx classifier config: %w", err)
+		}
+	}
+
+	if cfg.ModelPath == "" || cfg.VocabPath == "" {
+		return nil, fmt.Errorf("onnx classifier requires model_path and vocab_path")
+	}
+	if len(cfg.Labels) == 0 {
+		return nil, fmt.Errorf("onnx classifier requires labels")
+	}
+	if len(cfg.BlockLabels) == 0 {
+		return nil, fmt.Errorf("onnx classifier requires block_labels")
+	}
+	if len(cfg.InputNames) == 0 {
+		cfg.InputNames = []string{"input_ids", "attention_mask"}
+	}
+	if cfg.OutputName == "" {
+		cfg.OutputName = "logits"
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 0.5
+	}
+	if cfg.Activation == "" {
+		cfg.Activation = "softmax"
+	}
+	if cfg.MaxLength <= 2 {
+		cfg.MaxLength = 256
+	}
+	lowercase := cfg.Lowercase == nil || *cfg.Lowercase
+
+	tokenizer, err := loadWordPieceTokenizer(cfg.VocabPath, lowercase)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRunner(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ClassifierGuardrail{
+		name:      name,
+		priority:  priority,
+		config:    cfg,
+		tokenizer: tokenizer,
+		runner:    r,
+	}
+	c.threshold.Store(cfg.Threshold)
+	return c, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (c *ClassifierGuardrail) Name() string {
+	return c.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (c *ClassifierGuardrail) Priority() int {
+	return c.priority
+}
+
+// Threshold returns the score at or above which a block label fails
+func (c *ClassifierGuardrail) Threshold() float64 {
+	return c.threshold.Load()
+}
+
+// SetThreshold changes the block threshold while the gateway runs
+func (c *ClassifierGuardrail) SetThreshold(threshold float64) error {
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("threshold must be above 0 and at most 1")
+	}
+	c.threshold.Store(threshold)
+	return nil
+}
+
+// Check classifies a raw request or response body
+func (c *ClassifierGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return c.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
+
+// CheckRequest classifies the selected text from parsed request data
+func (c *ClassifierGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	text := c.selectText(input)
+	if text == "" {
+		return &guardrails.Result{
+			Passed: true,
+			Reason: "No text found to classify",
+			Metadata: map[string]interface{}{
+				"extraction": "empty",
+			},
+		}, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	start := time.Now()
+	inputIDs, attentionMask := c.tokenizer.Encode(text, c.config.MaxLength)
+	logits, err := c.runner.Run(inputIDs, attentionMask)
+	if err != nil {
+		return nil, fmt.Errorf("onnx inference failed: %w", err)
+	}
+	if len(logits) != len(c.config.Labels) {
+		return nil, fmt.Errorf("model returned %d scores for %d labels", len(logits), len(c.config.Labels))
+	}
+
+	probabilities := activate(logits, c.config.Activation)
+	scores := make(map[string]float64, len(probabilities))
+	for i, p := range probabilities {
+		scores[c.config.Labels[i]] = p
+	}
+
+	// The highest-scoring block label decides the outcome
+	threshold := c.threshold.Load()
+	var flagged []string
+	var maxScore float64
+	for _, label := range c.config.BlockLabels {
+		score := scores[label]
+		if score > maxScore {
+			maxScore = score
+		}
+		if score >= threshold {
+			flagged = append(flagged, label)
+		}
+	}
+	sort.Strings(flagged)
+
+	metadata := map[string]interface{}{
+		"scores":         scores,
+		"threshold":      threshold,
+		"tokens":         len(inputIDs),
+		"inference_ms":   float64(time.Since(start).Microseconds()) / 1000,
+		"model":          c.config.ModelPath,
+		"flagged_labels": flagged,
+	}
+
+	reason := "Content passed local classification"
+	if len(flagged) > 0 {
+		reason = fmt.Sprintf("Content classified as: %s", strings.Join(flagged, ", "))
+	}
+
+	return &guardrails.Result{
+		Passed:   len(flagged) == 0,
+		Score:    &maxScore,
+		Reason:   reason,
+		Metadata: metadata,
+	}, nil
+}
+
+// Close releases the model session
+func (c *ClassifierGuardrail) Close() error {
+	return c.runner.Close()
+}
+
+// selectText picks the text to classify from the parsed input
+func (c *ClassifierGuardrail) selectText(input *guardrails.GuardrailInput) string {
+	if c.config.Target == "all" || input.Layer == "output" {
+		return input.Text()
+	}
+	return input.LastUserMessage()
+}
+
+// activate converts logits to probabilities
+func activate(logits []float32, activation string) []float64 {
+	probabilities := make([]float64, len(logits))
+	if activation == "sigmoid" {
+		for i, l := range logits {
+			probabilities[i] = 1 / (1 + math.Exp(-float64(l)))
+		}
+		return probabilities
+	}
+
+	// Softmax, shifted by the max logit for numerical stability
+	maxLogit := math.Inf(-1)
+	for _, l := range logits {
+		maxLogit = math.Max(maxLogit, float64(l))
+	}
+	var sum float64
+	for i, l := range logits {
+		probabilities[i] = math.Exp(float64(l) - maxLogit)
+		sum += probabilities[i]
+	}
+	for i := range probabilities {
+		probabilities[i] /= sum
+	}
+	return probabilities
+}
@@ -0,0 +1,100 @@
+//go:build onnx
+
+package onnx
+
+import (
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+var (
+	// ONNX Runtime keeps one process-wide environment shared by every session
+	environmentOnce sync.Once
+	environmentErr  error
+)
+
+// ortRunner runs a model through ONNX Runtime
+type ortRunner struct {
+	session    *ort.DynamicAdvancedSession
+	inputNames []string
+}
+
+// newRunner initializes ONNX Runtime and loads the configured model
+func newRunner(cfg ClassifierConfig) (runner, error) {
+	environmentOnce.Do(func() {
+		if cfg.LibraryPath != "" {
+			ort.SetSharedLibraryPath(cfg.LibraryPath)
+		}
+		environmentErr = ort.InitializeEnvironment()
+	})
+	if environmentErr != nil {
+		return nil, fmt.Errorf("failed to initialize ONNX Runtime: %w", environmentErr)
+	}
+
+	for _, name := range cfg.InputNames {
+		switch name {
+		case "input_ids", "attention_mask", "token_type_ids":
+		default:
+			return nil, fmt.Errorf("unsupported model input %q", name)
+		}
+	}
+
+	session, err := ort.NewDynamicAdvancedSession(cfg.ModelPath, cfg.InputNames, []string{cfg.OutputName}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load model %s: %w", cfg.ModelPath, err)
+	}
+
+	return &ortRunner{session: session, inputNames: cfg.InputNames}, nil
+}
+
+// Run feeds one sequence through the model and returns its logits
+func (r *ortRunner) Run(inputIDs, attentionMask []int64) ([]float32, error) {
+	shape := ort.NewShape(1, int64(len(inputIDs)))
+
+	inputs := make([]ort.Value, 0, len(r.inputNames))
+	defer func() {
+		for _, v := range inputs {
+			v.Destroy()
+		}
+	}()
+	for _, name := range r.inputNames {
+		var data []int64
+		switch name {
+		case "input_ids":
+			data = inputIDs
+		case "attention_mask":
+			data = attentionMask
+		case "token_type_ids":
+			data = make([]int64, len(inputIDs))
+		}
+		tensor, err := ort.NewTensor(shape, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s tensor: %w", name, err)
+		}
+		inputs = append(inputs, tensor)
+	}
+
+	outputs := []ort.Value{nil}
+	if err := r.session.Run(inputs, outputs); err != nil {
+		return nil, err
+	}
+	defer outputs[0].Destroy()
+
+	logits, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return nil, fmt.Errorf("model output is not a float32 tensor")
+	}
+
+	// Copy out before the tensor is destroyed
+	data := logits.GetData()
+	result := make([]float32, len(data))
+	copy(result, data)
+	return result, nil
+}
+
+// Close destroys the session
+func (r *ortRunner) Close() error {
+	return r.session.Destroy()
+}
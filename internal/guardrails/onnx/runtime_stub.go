@@ -0,0 +1,10 @@
+//go:build !onnx
+
+package onnx
+
+import "fmt"
+
+// newRunner reports that ONNX Runtime support was not compiled in
+func newRunner(cfg ClassifierConfig) (runner, error) {
+	return nil, fmt.Errorf("gateway built without ONNX Runtime support, rebuild with -tags onnx")
+}
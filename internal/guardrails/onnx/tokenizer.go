@@ -0,0 +1,123 @@
+package onnx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// wordPieceTokenizer implements the BERT WordPiece scheme used by most small
+// classification models exported to ONNX
+type wordPieceTokenizer struct {
+	vocab     map[string]int64
+	lowercase bool
+	clsID     int64
+	sepID     int64
+	unkID     int64
+}
+
+// loadWordPieceTokenizer reads a vocab.txt file with one token per line
+func loadWordPieceTokenizer(path string, lowercase bool) (*wordPieceTokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vocab: %w", err)
+	}
+	defer file.Close()
+
+	vocab := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	var id int64
+	for scanner.Scan() {
+		vocab[strings.TrimRight(scanner.Text(), "\r")] = id
+		id++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vocab: %w", err)
+	}
+
+	t := &wordPieceTokenizer{vocab: vocab, lowercase: lowercase}
+	for token, dst := range map[string]*int64{"[CLS]": &t.clsID, "[SEP]": &t.sepID, "[UNK]": &t.unkID} {
+		tokenID, ok := vocab[token]
+		if !ok {
+			return nil, fmt.Errorf("vocab is missing %s", token)
+		}
+		*dst = tokenID
+	}
+	return t, nil
+}
+
+// Encode tokenizes text into input IDs and an attention mask, truncated to maxLength
+func (t *wordPieceTokenizer) Encode(text string, maxLength int) ([]int64, []int64) {
+	ids := []int64{t.clsID}
+	for _, word := range t.splitWords(text) {
+		ids = append(ids, t.wordPieces(word)...)
+		if len(ids) >= maxLength-1 {
+			ids = ids[:maxLength-1]
+			break
+		}
+	}
+	ids = append(ids, t.sepID)
+
+	mask := make([]int64, len(ids))
+	for i := range mask {
+		mask[i] = 1
+	}
+	return ids, mask
+}
+
+// splitWords separates text on whitespace and punctuation, keeping punctuation as words
+func (t *wordPieceTokenizer) splitWords(text string) []string {
+	if t.lowercase {
+		text = strings.ToLower(text)
+	}
+
+	var words []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			words = append(words, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			flush()
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// wordPieces greedily matches the longest vocabulary prefixes of a word
+func (t *wordPieceTokenizer) wordPieces(word string) []int64 {
+	runes := []rune(word)
+	var pieces []int64
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		var match int64 = -1
+		for ; end > start; end-- {
+			candidate := string(runes[start:end])
+			if start > 0 {
+				candidate = "##" + candidate
+			}
+			if id, ok := t.vocab[candidate]; ok {
+				match = id
+				break
+			}
+		}
+		if match < 0 {
+			return []int64{t.unkID}
+		}
+		pieces = append(pieces, match)
+		start = end
+	}
+	return pieces
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,37 +21,30 @@ type ModerationGuardrail struct {
 	apiKey      string
 	blockOnFlag bool
 	categories  []string
+	scope       string
+	lastN       int
 	httpClient  *http.Client
 }
 
+// Moderation scopes select which messages are sent to the moderation API
+const (
+	ScopeLastUser    = "last_user"    // Only the most recent user message (default)
+	ScopeAllMessages = "all_messages" // Every message, including system prompts
+	ScopeLastN       = "last_n"       // System prompts plus the last N other messages
+)
+
 // Config structure for moderation guardrail
 type ModerationConfig struct {
 	APIKey      string   `json:"api_key"`
 	BlockOnFlag bool     `json:"block_on_flag"`
 	Categories  []string `json:"categories,omitempty"`
-}
-
-// Request structures for different OpenAI endpoints
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type ChatCompletionRequest struct {
-	Messages []ChatMessage `json:"messages"`
-}
-
-type ResponsesRequest struct {
-	Input string `json:"input"`
-}
-
-type CompletionRequest struct {
-	Prompt string `json:"prompt"`
+	Scope       string   `json:"scope,omitempty"`
+	LastN       int      `json:"last_n,omitempty"`
 }
 
 // OpenAI Moderation API structures
 type ModerationRequest struct {
-	Input string `json:"input"`
+	Input []string `json:"input"`
 }
 
 type ModerationResponse struct {
@@ -85,12 +79,26 @@ func NewModerationGuardrail(name string, priority int, config map[string]interfa
 		blockOnFlag = true
 	}
 
+	// Default to moderating only the latest user turn
+	scope := modConfig.Scope
+	switch scope {
+	case ScopeAllMessages, ScopeLastN:
+	default:
+		scope = ScopeLastUser
+	}
+	lastN := modConfig.LastN
+	if lastN <= 0 {
+		lastN = 5
+	}
+
 	return &ModerationGuardrail{
 		name:        name,
 		priority:    priority,
 		apiKey:      apiKey,
 		blockOnFlag: blockOnFlag,
 		categories:  modConfig.Categories,
+		scope:       scope,
+		lastN:       lastN,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -107,34 +115,29 @@ func (m *ModerationGuardrail) Priority() int {
 	return m.priority
 }
 
-// Check performs the moderation validation
+// Check performs the moderation validation on a raw request body
 func (m *ModerationGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
-	// Extract user message from request
-	userMessage, err := m.extractUserMessage(content)
-	if err != nil {
-		return &guardrails.Result{
-			Passed: true, // Don't block on parsing errors
-			Reason: fmt.Sprintf("Failed to extract message: %v", err),
-			Metadata: map[string]interface{}{
-				"error":      err.Error(),
-				"extraction": "failed",
-			},
-		}, nil
-	}
+	return m.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
 
-	// Skip if no user message found
-	if userMessage == "" {
+// CheckRequest performs the moderation validation on parsed request data
+func (m *ModerationGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	texts := m.selectMessages(input)
+
+	// Skip if nothing found to moderate
+	if len(texts) == 0 {
 		return &guardrails.Result{
 			Passed: true,
 			Reason: "No user message found to moderate",
 			Metadata: map[string]interface{}{
 				"extraction": "empty",
+				"scope":      m.scope,
 			},
 		}, nil
 	}
 
 	// Call OpenAI moderation API
-	moderationResult, err := m.callModerationAPI(ctx, userMessage)
+	moderationResult, err := m.callModerationAPI(ctx, texts)
 	if err != nil {
 		// Don't block requests on API failures
 		return &guardrails.Result{
@@ -143,7 +146,8 @@ func (m *ModerationGuardrail) Check(ctx context.Context, content string) (*guard
 			Metadata: map[string]interface{}{
 				"error":        err.Error(),
 				"api_call":     "failed",
-				"user_message": userMessage,
+				"user_message": texts[len(texts)-1],
+				"scope":        m.scope,
 			},
 		}, nil
 	}
@@ -167,8 +171,10 @@ func (m *ModerationGuardrail) Check(ctx context.Context, content string) (*guard
 
 	// Build metadata with detailed results
 	metadata := map[string]interface{}{
-		"user_message":    userMessage,
-		"flagged":         moderationResult.Flagged,
+		"user_message":       texts[len(texts)-1],
+		"scope":              m.scope,
+		"moderated_messages": len(texts),
+		"flagged":            moderationResult.Flagged,
 		"categories":      moderationResult.Categories,
 		"category_scores": moderationResult.CategoryScores,
 		"api_call":        "success",
@@ -187,7 +193,11 @@ func (m *ModerationGuardrail) Check(ctx context.Context, content string) (*guard
 				violatedCategories = append(violatedCategories, category)
 			}
 		}
+		sort.Strings(violatedCategories)
 		reason = fmt.Sprintf("Content flagged for: %s", strings.Join(violatedCategories, ", "))
+		if len(violatedCategories) > 0 {
+			metadata["category"] = violatedCategories[0]
+		}
 	}
 
 	return &guardrails.Result{
@@ -197,59 +207,57 @@ func (m *ModerationGuardrail) Check(ctx context.Context, content string) (*guard
 	}, nil
 }
 
-// extractUserMessage extracts the user message from different request formats
-func (m *ModerationGuardrail) extractUserMessage(content string) (string, error) {
-	// Try to parse as different request types
-	
-	// 1. Try Chat Completion format
-	var chatReq ChatCompletionRequest
-	if err := json.Unmarshal([]byte(content), &chatReq); err == nil && len(chatReq.Messages) > 0 {
-		// Find the last user message
-		for i := len(chatReq.Messages) - 1; i >= 0; i-- {
-			if chatReq.Messages[i].Role == "user" {
-				return chatReq.Messages[i].Content, nil
+// selectMessages picks the message texts to moderate according to the configured scope
+func (m *ModerationGuardrail) selectMessages(input *guardrails.GuardrailInput) []string {
+	var texts []string
+	scope := m.scope
+	if input.Batch {
+		// Batched inputs (e.g. embeddings) are unrelated texts, not turns of a conversation
+		scope = ScopeAllMessages
+	}
+	switch scope {
+	case ScopeAllMessages:
+		for _, msg := range input.Messages {
+			if msg.Content != "" {
+				texts = append(texts, msg.Content)
 			}
 		}
-	}
-
-	// 2. Try Responses format
-	var respReq ResponsesRequest
-	if err := json.Unmarshal([]byte(content), &respReq); err == nil && respReq.Input != "" {
-		return respReq.Input, nil
-	}
-
-	// 3. Try Completion format
-	var compReq CompletionRequest
-	if err := json.Unmarshal([]byte(content), &compReq); err == nil && compReq.Prompt != "" {
-		return compReq.Prompt, nil
-	}
-
-	// If none of the above worked, try to extract any "content" field
-	var generic map[string]interface{}
-	if err := json.Unmarshal([]byte(content), &generic); err == nil {
-		if content, ok := generic["content"].(string); ok {
-			return content, nil
+	case ScopeLastN:
+		// System prompts are always included; the window covers the other turns
+		var recent []string
+		for _, msg := range input.Messages {
+			if msg.Content == "" {
+				continue
+			}
+			if msg.Role == "system" || msg.Role == "developer" {
+				texts = append(texts, msg.Content)
+				continue
+			}
+			recent = append(recent, msg.Content)
 		}
-		if input, ok := generic["input"].(string); ok {
-			return input, nil
+		if len(recent) > m.lastN {
+			recent = recent[len(recent)-m.lastN:]
 		}
-		if prompt, ok := generic["prompt"].(string); ok {
-			return prompt, nil
+		texts = append(texts, recent...)
+	default:
+		if userMessage := input.LastUserMessage(); userMessage != "" {
+			texts = append(texts, userMessage)
 		}
 	}
-
-	return "", fmt.Errorf("unable to extract user message from request")
+	return texts
 }
 
-// callModerationAPI calls OpenAI's moderation API
-func (m *ModerationGuardrail) callModerationAPI(ctx context.Context, text string) (*ModerationResult, error) {
+// callModerationAPI calls OpenAI's moderation API with one or more inputs and
+// merges the per-input results: flagged if any input is flagged, with each
+// category's highest score
+func (m *ModerationGuardrail) callModerationAPI(ctx context.Context, texts []string) (*ModerationResult, error) {
 	if m.apiKey == "" {
 		return nil, fmt.Errorf("OpenAI API key not configured")
 	}
 
 	// Prepare request
 	modReq := ModerationRequest{
-		Input: text,
+		Input: texts,
 	}
 
 	requestBody, err := json.Marshal(modReq)
@@ -285,12 +293,29 @@ func (m *ModerationGuardrail) callModerationAPI(ctx context.Context, text string
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	// Return first result (there should only be one for single input)
 	if len(modResp.Results) == 0 {
 		return nil, fmt.Errorf("no results in moderation response")
 	}
+	if len(modResp.Results) == 1 {
+		return &modResp.Results[0], nil
+	}
 
-	return &modResp.Results[0], nil
+	merged := &ModerationResult{
+		Categories:     make(map[string]bool),
+		CategoryScores: make(map[string]float64),
+	}
+	for _, result := range modResp.Results {
+		merged.Flagged = merged.Flagged || result.Flagged
+		for category, violated := range result.Categories {
+			merged.Categories[category] = merged.Categories[category] || violated
+		}
+		for category, score := range result.CategoryScores {
+			if score > merged.CategoryScores[category] {
+				merged.CategoryScores[category] = score
+			}
+		}
+	}
+	return merged, nil
 }
 
 // containsCategory checks if a category is in the configured categories list
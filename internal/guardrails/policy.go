@@ -0,0 +1,118 @@
+package guardrails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Error policies for guardrails whose check fails or times out
+const (
+	OnErrorBlock = "block" // Fail the request (default)
+	OnErrorAllow = "allow" // Let the request through and record the error
+)
+
+// Policy controls how long one guardrail may run, what its errors mean and
+// when it is skipped for failing
+type Policy struct {
+	Timeout time.Duration // Zero leaves only the executor's overall timeout
+	OnError string
+	Circuit *CircuitSettings // nil never skips the guardrail
+
+	// Modifies chains the guardrail after the rest of its priority group
+	Modifies bool
+}
+
+// NewPolicy builds the policy declared on a guardrail configuration
+func NewPolicy(cfg config.GuardrailConfig) (Policy, error) {
+	policy := Policy{OnError: cfg.OnError, Modifies: cfg.Modifies}
+	switch policy.OnError {
+	case OnErrorBlock, OnErrorAllow:
+	case "":
+		policy.OnError = OnErrorBlock
+	default:
+		return Policy{}, fmt.Errorf("guardrail %s: unknown on_error %q (want %s or %s)", cfg.Name, cfg.OnError, OnErrorAllow, OnErrorBlock)
+	}
+
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return Policy{}, fmt.Errorf("guardrail %s: invalid timeout %q", cfg.Name, cfg.Timeout)
+		}
+		policy.Timeout = timeout
+	}
+
+	if cfg.CircuitBreaker != nil {
+		circuit, err := newCircuitSettings(*cfg.CircuitBreaker)
+		if err != nil {
+			return Policy{}, fmt.Errorf("guardrail %s: circuit_breaker: %w", cfg.Name, err)
+		}
+		policy.Circuit = circuit
+	}
+	return policy, nil
+}
+
+// NewPolicies builds the policies of every configured guardrail, keyed by
+// name. Guardrails with invalid policies are left out and reported.
+func NewPolicies(cfg config.GuardrailsConfig) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	var errs []error
+	for _, list := range [][]config.GuardrailConfig{cfg.InputGuardrails, cfg.OutputGuardrails} {
+		for _, g := range list {
+			policy, err := NewPolicy(g)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			policies[g.Name] = policy
+		}
+	}
+	return policies, errors.Join(errs...)
+}
+
+// runCheck runs a guardrail with the input it prefers: images, parsed input
+// or the raw body
+func runCheck(ctx context.Context, guardrail Guardrail, input *GuardrailInput) (*Result, error) {
+	if images, ok := asImageGuardrail(guardrail); ok {
+		return images.CheckImages(ctx, input.Images, input)
+	}
+	if structured, ok := guardrail.(StructuredGuardrail); ok {
+		return structured.CheckRequest(ctx, input)
+	}
+	return guardrail.Check(ctx, input.Raw)
+}
+
+// checkWithTimeout runs a guardrail under its own timeout. The check runs in
+// its own goroutine, so a guardrail that ignores its context still can't hold
+// the request past the timeout.
+func checkWithTimeout(ctx context.Context, guardrail Guardrail, input *GuardrailInput, timeout time.Duration) (*Result, error) {
+	if timeout <= 0 {
+		return runCheck(ctx, guardrail, input)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result *Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := runCheck(checkCtx, guardrail, input)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-checkCtx.Done():
+		if ctx.Err() == nil {
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		return nil, ctx.Err()
+	}
+}
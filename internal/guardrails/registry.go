@@ -33,20 +33,28 @@ func Load(config config.GuardrailConfig) (Guardrail, error) {
 	}
 
 	// Handle built-in example guardrails
+	var guardrail Guardrail
+	var err error
 	if config.Type == "example" {
-		return loadExampleGuardrail(config)
+		guardrail, err = loadExampleGuardrail(config)
+	} else {
+		// Look for custom guardrail in registry
+		mu.RLock()
+		factory, exists := registry[config.Type]
+		mu.RUnlock()
+
+		if !exists {
+			return nil, fmt.Errorf("unknown guardrail type: %s", config.Type)
+		}
+
+		guardrail, err = factory(config.Name, config.Priority, config.Config)
 	}
-	
-	// Look for custom guardrail in registry
-	mu.RLock()
-	factory, exists := registry[config.Type]
-	mu.RUnlock()
-	
-	if !exists {
-		return nil, fmt.Errorf("unknown guardrail type: %s", config.Type)
+	if err != nil {
+		return nil, err
 	}
-	
-	return factory(config.Name, config.Priority, config.Config)
+
+	// Restrict to the endpoints, providers, and models the config declares
+	return WithApplicability(guardrail, NewApplicability(config)), nil
 }
 
 // LoadAll creates all guardrails from a slice of configurations
@@ -0,0 +1,180 @@
+package guardrails
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// scopeContextKey is the context key under which the request scope is stored
+const scopeContextKey = "guardrail_scope"
+
+// DefaultUserHeader names the end user behind a request when no other header is configured
+const DefaultUserHeader = "X-User-ID"
+
+// Client identifies who sent a request, so guardrail policies and metrics can
+// be scoped to an API key, a tenant or an end user
+type Client struct {
+	APIKeyID string `json:"api_key_id,omitempty"` // Fingerprint of the caller's key, never the key itself
+	Tenant   string `json:"tenant,omitempty"`
+	UserID   string `json:"user_id,omitempty"` // From the user header, e.g. X-User-ID
+}
+
+// IsEmpty reports whether nothing is known about the client
+func (c Client) IsEmpty() bool {
+	return c.APIKeyID == "" && c.Tenant == "" && c.UserID == ""
+}
+
+// Scope describes the request a guardrail is being evaluated for
+type Scope struct {
+	Endpoint string `json:"endpoint"`
+	Provider string `json:"provider"`
+	Model    string `json:"model,omitempty"`
+	Client
+
+	// Headers are the client's request headers, exposed to structured guardrails
+	Headers http.Header `json:"-"`
+}
+
+// WithScope attaches the request scope used to evaluate applicability filters
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeContextKey, scope)
+}
+
+// ScopeFromContext returns the request scope, if one was attached
+func ScopeFromContext(ctx context.Context) (Scope, bool) {
+	scope, ok := ctx.Value(scopeContextKey).(Scope)
+	return scope, ok
+}
+
+// ClientFromContext returns the client of the request scope attached to ctx
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	scope, ok := ScopeFromContext(ctx)
+	return scope.Client, ok && !scope.Client.IsEmpty()
+}
+
+// Applicability restricts a guardrail to certain endpoints, providers, models,
+// tenants, API keys or end users
+type Applicability struct {
+	Endpoints []string `json:"endpoints,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+	Models    []string `json:"models,omitempty"`
+	Tenants   []string `json:"tenants,omitempty"`
+	APIKeys   []string `json:"api_keys,omitempty"` // Key fingerprints
+	Users     []string `json:"users,omitempty"`
+}
+
+// NewApplicability builds the filter declared on a guardrail configuration.
+// API keys may be given as keys or as their key_ fingerprints.
+func NewApplicability(cfg config.GuardrailConfig) Applicability {
+	var apiKeys []string
+	for _, key := range cfg.APIKeys {
+		if !strings.HasPrefix(key, "key_") {
+			key = storage.APIKeyID(key)
+		}
+		apiKeys = append(apiKeys, key)
+	}
+	return Applicability{
+		Endpoints: cfg.Endpoints,
+		Providers: cfg.Providers,
+		Models:    cfg.Models,
+		Tenants:   cfg.Tenants,
+		APIKeys:   apiKeys,
+		Users:     cfg.Users,
+	}
+}
+
+// IsEmpty reports whether the filter matches every request
+func (a Applicability) IsEmpty() bool {
+	return len(a.Endpoints) == 0 && len(a.Providers) == 0 && len(a.Models) == 0 && len(a.Tenants) == 0 &&
+		len(a.APIKeys) == 0 && len(a.Users) == 0
+}
+
+// Matches reports whether a request with the given scope is covered by the filter.
+// A model, tenant, API key or user filter never matches a request without one.
+func (a Applicability) Matches(scope Scope) bool {
+	return matchAny(a.Endpoints, scope.Endpoint) &&
+		matchAny(a.Providers, scope.Provider) &&
+		matchAny(a.Models, scope.Model) &&
+		matchAny(a.Tenants, scope.Tenant) &&
+		matchAny(a.APIKeys, scope.APIKeyID) &&
+		matchAny(a.Users, scope.UserID)
+}
+
+// matchAny checks value against patterns; an empty pattern list matches anything
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if value != "" && strings.HasPrefix(value, prefix) {
+				return true
+			}
+			continue
+		}
+		if pattern == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopedGuardrail is implemented by guardrails that only apply to some requests
+type ScopedGuardrail interface {
+	Guardrail
+	AppliesTo(scope Scope) bool
+}
+
+// scopedGuardrail wraps a guardrail with an applicability filter
+type scopedGuardrail struct {
+	Guardrail
+	filter Applicability
+}
+
+// AppliesTo reports whether the wrapped guardrail should run for the scope
+func (s *scopedGuardrail) AppliesTo(scope Scope) bool {
+	return s.filter.Matches(scope)
+}
+
+// CheckRequest forwards structured input to the wrapped guardrail when it supports it
+func (s *scopedGuardrail) CheckRequest(ctx context.Context, input *GuardrailInput) (*Result, error) {
+	if structured, ok := s.Guardrail.(StructuredGuardrail); ok {
+		return structured.CheckRequest(ctx, input)
+	}
+	return s.Guardrail.Check(ctx, input.Raw)
+}
+
+// Applicability returns the filter so status endpoints can describe it
+func (s *scopedGuardrail) Applicability() Applicability {
+	return s.filter
+}
+
+// WithApplicability restricts a guardrail to requests matching the filter
+func WithApplicability(guardrail Guardrail, filter Applicability) Guardrail {
+	if filter.IsEmpty() {
+		return guardrail
+	}
+	return &scopedGuardrail{Guardrail: guardrail, filter: filter}
+}
+
+// applicable returns the guardrails that apply to the scope attached to ctx,
+// minus any skipped by a verified bypass. Without a scope every guardrail applies.
+func applicable(ctx context.Context, guardrails []Guardrail) []Guardrail {
+	scope, hasScope := ScopeFromContext(ctx)
+
+	filtered := make([]Guardrail, 0, len(guardrails))
+	for _, g := range guardrails {
+		if isBypassed(ctx, g.Name()) {
+			continue
+		}
+		if scoped, ok := g.(ScopedGuardrail); ok && hasScope && !scoped.AppliesTo(scope) {
+			continue
+		}
+		filtered = append(filtered, g)
+	}
+	return filtered
+}
@@ -0,0 +1,276 @@
+package tokenlimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+)
+
+// Actions taken when a request exceeds its token limit
+const (
+	ActionBlock    = "block"    // Reject the request (default)
+	ActionTruncate = "truncate" // Drop the oldest turns, then trim the prompt, until it fits
+)
+
+// Config structure for the max token guardrail
+type Config struct {
+	MaxTokens int            `json:"max_tokens"` // Limit for models without their own entry
+	Models    map[string]int `json:"models"`     // Per-model limits, matched by exact name then longest prefix
+	Action    string         `json:"action"`
+}
+
+// MaxTokensGuardrail counts prompt tokens with the model's tiktoken encoding
+// and blocks or truncates requests over the configured limit
+type MaxTokensGuardrail struct {
+	name      string
+	priority  int
+	maxTokens int
+	models    map[string]int
+	action    string
+}
+
+// NewMaxTokensGuardrail creates a new max token guardrail
+func NewMaxTokensGuardrail(name string, priority int, config map[string]interface{}) (*MaxTokensGuardrail, error) {
+	var cfg Config
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid max_tokens config: %w", err)
+		}
+	}
+
+	if cfg.MaxTokens <= 0 && len(cfg.Models) == 0 {
+		return nil, fmt.Errorf("max_tokens guardrail requires max_tokens or models")
+	}
+
+	action := cfg.Action
+	switch action {
+	case ActionBlock, ActionTruncate:
+	case "":
+		action = ActionBlock
+	default:
+		return nil, fmt.Errorf("unknown max_tokens action: %s", action)
+	}
+
+	return &MaxTokensGuardrail{
+		name:      name,
+		priority:  priority,
+		maxTokens: cfg.MaxTokens,
+		models:    cfg.Models,
+		action:    action,
+	}, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (g *MaxTokensGuardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *MaxTokensGuardrail) Priority() int {
+	return g.priority
+}
+
+// Modifies reports whether long requests are truncated, so the guardrail runs
+// after the rest of its priority group
+func (g *MaxTokensGuardrail) Modifies() bool {
+	return g.action == ActionTruncate
+}
+
+// Check counts tokens in a raw request body
+func (g *MaxTokensGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return g.CheckRequest(ctx, guardrails.ParseInput("input", content, guardrails.Scope{}))
+}
+
+// CheckRequest counts tokens in parsed request data
+func (g *MaxTokensGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	limit := g.limitFor(input.Model)
+	if limit <= 0 {
+		return &guardrails.Result{
+			Passed: true,
+			Reason: "No token limit configured for model",
+			Metadata: map[string]interface{}{
+				"model": input.Model,
+			},
+		}, nil
+	}
+
+	tokens := countInput(input)
+	metadata := map[string]interface{}{
+		"model":    input.Model,
+		"encoding": tokenizer.EncodingName(input.Model),
+		"tokens":   tokens,
+		"limit":    limit,
+		"action":   g.action,
+	}
+
+	if tokens <= limit {
+		return &guardrails.Result{
+			Passed:   true,
+			Reason:   fmt.Sprintf("Request uses %d of %d tokens", tokens, limit),
+			Metadata: metadata,
+		}, nil
+	}
+
+	if g.action == ActionTruncate {
+		truncated, removed, err := truncateBody(input, limit)
+		if err == nil {
+			metadata["truncated"] = true
+			metadata["removed_messages"] = removed
+			metadata["truncated_tokens"] = countInput(guardrails.ParseInput("input", truncated, guardrails.Scope{Model: input.Model}))
+			return &guardrails.Result{
+				Passed:          true,
+				Reason:          fmt.Sprintf("Request truncated from %d to fit %d tokens", tokens, limit),
+				Metadata:        metadata,
+				ModifiedContent: &truncated,
+			}, nil
+		}
+		metadata["truncate_error"] = err.Error()
+	}
+
+	return &guardrails.Result{
+		Passed:   false,
+		Reason:   fmt.Sprintf("Request uses %d tokens, exceeding the limit of %d", tokens, limit),
+		Metadata: metadata,
+	}, nil
+}
+
+// limitFor returns the token limit for a model
+func (g *MaxTokensGuardrail) limitFor(model string) int {
+	if limit, ok := g.models[model]; ok {
+		return limit
+	}
+
+	var best string
+	limit := g.maxTokens
+	for prefix, l := range g.models {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, limit = prefix, l
+		}
+	}
+	return limit
+}
+
+// countInput counts chat-formatted tokens for the parsed messages
+func countInput(input *guardrails.GuardrailInput) int {
+	messages := make([]tokenizer.Message, 0, len(input.Messages))
+	for _, m := range input.Messages {
+		messages = append(messages, tokenizer.Message{Role: m.Role, Content: m.Content})
+	}
+	return tokenizer.CountMessages(input.Model, messages)
+}
+
+// truncateBody rewrites the request so it fits in limit tokens. Chat histories
+// lose their oldest non-system turns first; if the newest turn alone is still
+// too long, or the prompt is a single string, the text itself is cut.
+func truncateBody(input *guardrails.GuardrailInput, limit int) (string, int, error) {
+	decoder := json.NewDecoder(strings.NewReader(input.Raw))
+	decoder.UseNumber() // Keep numeric fields exactly as the client sent them
+	var body map[string]interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("request is not JSON: %w", err)
+	}
+
+	count := func() (int, error) {
+		encoded, err := marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		return countInput(guardrails.ParseInput("input", encoded, guardrails.Scope{Model: input.Model})), nil
+	}
+
+	removed := 0
+	if messages, ok := body["messages"].([]interface{}); ok {
+		// Drop the oldest non-system message, always keeping the newest one
+		for {
+			tokens, err := count()
+			if err != nil {
+				return "", 0, err
+			}
+			if tokens <= limit {
+				encoded, err := marshal(body)
+				return encoded, removed, err
+			}
+			index := oldestDroppable(messages)
+			if index < 0 {
+				break
+			}
+			messages = append(messages[:index], messages[index+1:]...)
+			body["messages"] = messages
+			removed++
+		}
+
+		// The rest of the body, such as system or tools, is over the limit alone
+		if len(messages) == 0 {
+			return "", 0, fmt.Errorf("request exceeds the limit without any messages")
+		}
+		last, ok := messages[len(messages)-1].(map[string]interface{})
+		if !ok {
+			return "", 0, fmt.Errorf("cannot truncate message")
+		}
+		if err := trimField(last, "content", input.Model, limit, count); err != nil {
+			return "", 0, err
+		}
+	} else if _, ok := body["input"].(string); ok {
+		if err := trimField(body, "input", input.Model, limit, count); err != nil {
+			return "", 0, err
+		}
+	} else if _, ok := body["prompt"].(string); ok {
+		if err := trimField(body, "prompt", input.Model, limit, count); err != nil {
+			return "", 0, err
+		}
+	} else {
+		return "", 0, fmt.Errorf("request format cannot be truncated")
+	}
+
+	encoded, err := marshal(body)
+	return encoded, removed, err
+}
+
+// oldestDroppable returns the index of the oldest non-system message that is
+// not the final message, or -1
+func oldestDroppable(messages []interface{}) int {
+	for i := 0; i < len(messages)-1; i++ {
+		if m, ok := messages[i].(map[string]interface{}); ok {
+			if role, _ := m["role"].(string); role == "system" || role == "developer" {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// trimField cuts a string field by however many tokens the request is over the limit
+func trimField(obj map[string]interface{}, field, model string, limit int, count func() (int, error)) error {
+	text, ok := obj[field].(string)
+	if !ok {
+		return fmt.Errorf("%s is not plain text and cannot be truncated", field)
+	}
+
+	tokens, err := count()
+	if err != nil {
+		return err
+	}
+	keep := tokenizer.Count(model, text) - (tokens - limit)
+	if keep <= 0 {
+		return fmt.Errorf("fixed parts of the request alone exceed the limit")
+	}
+	obj[field] = tokenizer.Truncate(model, text, keep)
+	return nil
+}
+
+// marshal encodes the body without escaping HTML characters in prompts
+func marshal(body map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
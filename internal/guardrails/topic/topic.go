@@ -0,0 +1,269 @@
+package topic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Actions taken when banned topics or competitor names are found
+const (
+	ActionBlock   = "block"   // Fail the check (default)
+	ActionRewrite = "rewrite" // Replace matched terms and let the content through
+)
+
+// TopicConfig lists the terms that identify one banned topic
+type TopicConfig struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"` // Matched case-insensitively on word boundaries
+	Patterns []string `json:"patterns"` // Regular expressions, for anything keywords can't express
+}
+
+// Config structure for the topic guardrail
+type Config struct {
+	Topics      []TopicConfig `json:"topics"`
+	Competitors []string      `json:"competitors"` // Shorthand for a "competitors" topic
+	Action      string        `json:"action"`
+	Replacement string        `json:"replacement"` // Used by rewrite, default "[redacted]"
+}
+
+// topicMatcher is a compiled topic
+type topicMatcher struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// TopicGuardrail blocks or rewrites content that mentions banned topics or
+// competitor names, for brand-safe customer-facing chatbots
+type TopicGuardrail struct {
+	name        string
+	priority    int
+	topics      []topicMatcher
+	action      string
+	replacement string
+}
+
+// NewTopicGuardrail creates a new topic guardrail
+func NewTopicGuardrail(name string, priority int, config map[string]interface{}) (*TopicGuardrail, error) {
+	var cfg Config
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid topic config: %w", err)
+		}
+	}
+
+	if len(cfg.Competitors) > 0 {
+		cfg.Topics = append(cfg.Topics, TopicConfig{Name: "competitors", Keywords: cfg.Competitors})
+	}
+	if len(cfg.Topics) == 0 {
+		return nil, fmt.Errorf("topic guardrail requires topics or competitors")
+	}
+
+	action := cfg.Action
+	switch action {
+	case ActionBlock, ActionRewrite:
+	case "":
+		action = ActionBlock
+	default:
+		return nil, fmt.Errorf("unknown topic action: %s", action)
+	}
+
+	replacement := cfg.Replacement
+	if replacement == "" {
+		replacement = "[redacted]"
+	}
+
+	topics := make([]topicMatcher, 0, len(cfg.Topics))
+	for _, t := range cfg.Topics {
+		pattern, err := compileTopic(t)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topicMatcher{name: t.Name, pattern: pattern})
+	}
+
+	return &TopicGuardrail{
+		name:        name,
+		priority:    priority,
+		topics:      topics,
+		action:      action,
+		replacement: replacement,
+	}, nil
+}
+
+// compileTopic builds one case-insensitive alternation from a topic's keywords and patterns
+func compileTopic(t TopicConfig) (*regexp.Regexp, error) {
+	if t.Name == "" {
+		return nil, fmt.Errorf("topic requires a name")
+	}
+
+	var alternatives []string
+	for _, keyword := range t.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			alternatives = append(alternatives, wordPattern(keyword))
+		}
+	}
+	for _, p := range t.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid pattern for topic %s: %w", t.Name, err)
+		}
+		alternatives = append(alternatives, "(?:"+p+")")
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("topic %s has no keywords or patterns", t.Name)
+	}
+
+	return regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+}
+
+// wordPattern matches keyword as a whole word; boundaries are only required
+// next to word characters so terms like "C++" still match
+func wordPattern(keyword string) string {
+	pattern := regexp.QuoteMeta(keyword)
+	if isWordChar(keyword[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordChar(keyword[len(keyword)-1]) {
+		pattern += `\b`
+	}
+	return pattern
+}
+
+// isWordChar reports whether b is an ASCII word character as \b understands it
+func isWordChar(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// Name returns the guardrail's unique identifier
+func (g *TopicGuardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *TopicGuardrail) Priority() int {
+	return g.priority
+}
+
+// Modifies reports whether matches are rewritten, so the guardrail runs after
+// the rest of its priority group
+func (g *TopicGuardrail) Modifies() bool {
+	return g.action == ActionRewrite
+}
+
+// Check scans a raw body for banned topics
+func (g *TopicGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	return g.CheckRequest(ctx, guardrails.ParseInput("output", content, guardrails.Scope{}))
+}
+
+// CheckRequest scans parsed messages for banned topics
+func (g *TopicGuardrail) CheckRequest(ctx context.Context, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	text := input.Text()
+	if input.Layer != "output" {
+		text = input.LastUserMessage()
+	}
+
+	matches := g.findMatches(text)
+	if len(matches) == 0 {
+		return &guardrails.Result{
+			Passed: true,
+			Reason: "No banned topics found",
+		}, nil
+	}
+
+	topics := make([]string, 0, len(matches))
+	for name := range matches {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+
+	metadata := map[string]interface{}{
+		"matches":  matches,
+		"action":   g.action,
+		"category": topics[0],
+	}
+	reason := fmt.Sprintf("Content mentions banned topics: %s", strings.Join(topics, ", "))
+
+	if g.action == ActionRewrite {
+		rewritten, err := g.rewrite(input.Raw)
+		if err == nil {
+			metadata["rewritten"] = true
+			return &guardrails.Result{
+				Passed:          true,
+				Reason:          reason,
+				Metadata:        metadata,
+				ModifiedContent: &rewritten,
+			}, nil
+		}
+		metadata["rewrite_error"] = err.Error()
+	}
+
+	return &guardrails.Result{
+		Passed:   false,
+		Reason:   reason,
+		Metadata: metadata,
+	}, nil
+}
+
+// findMatches returns the distinct matched terms for each topic
+func (g *TopicGuardrail) findMatches(text string) map[string][]string {
+	matches := make(map[string][]string)
+	for _, t := range g.topics {
+		seen := make(map[string]bool)
+		for _, m := range t.pattern.FindAllString(text, -1) {
+			term := strings.ToLower(m)
+			if !seen[term] {
+				seen[term] = true
+				matches[t.name] = append(matches[t.name], term)
+			}
+		}
+	}
+	return matches
+}
+
+// rewrite replaces matched terms in every "content" and "text" string of a JSON body
+func (g *TopicGuardrail) rewrite(raw string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber() // Keep numeric fields exactly as the provider sent them
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return "", fmt.Errorf("content is not JSON: %w", err)
+	}
+
+	body = g.rewriteValue(body, false)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// rewriteValue walks a decoded JSON value, rewriting strings held by text fields
+func (g *TopicGuardrail) rewriteValue(value interface{}, textField bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = g.rewriteValue(child, key == "content" || key == "text")
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = g.rewriteValue(child, textField)
+		}
+	case string:
+		if textField {
+			for _, t := range g.topics {
+				v = t.pattern.ReplaceAllLiteralString(v, g.replacement)
+			}
+			return v
+		}
+	}
+	return value
+}
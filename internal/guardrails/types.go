@@ -4,7 +4,7 @@ import (
 	"context"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/NamanArora/flash-gateway/internal/storage"
 )
 
 // Guardrail is the main interface for all guardrails
@@ -31,32 +31,23 @@ type Result struct {
 	ModifiedContent *string               `json:"modified_content,omitempty"` // Optional modified content for next guardrails
 }
 
-// Metric captures performance data for a guardrail execution
-type Metric struct {
-	ID                 uuid.UUID             `json:"id" db:"id"`
-	RequestID          uuid.UUID             `json:"request_id" db:"request_id"`
-	GuardrailName      string                `json:"guardrail_name" db:"guardrail_name"`
-	Layer              string                `json:"layer" db:"layer"` // "input" or "output"
-	Priority           int                   `json:"priority" db:"priority"`
-	StartTime          time.Time             `json:"start_time" db:"start_time"`
-	EndTime            time.Time             `json:"end_time" db:"end_time"`
-	DurationMs         int64                 `json:"duration_ms" db:"duration_ms"`
-	Passed             bool                  `json:"passed" db:"passed"`
-	Score              *float64              `json:"score" db:"score"`
-	Error              *string               `json:"error" db:"error"`
-	Metadata           map[string]interface{} `json:"metadata" db:"metadata"`
-	OriginalResponse   *string               `json:"original_response" db:"original_response"`   // Original LLM response (output guardrails only)
-	OverrideResponse   *string               `json:"override_response" db:"override_response"`   // Override response sent to client
-	ResponseOverridden bool                  `json:"response_overridden" db:"response_overridden"` // Whether response was overridden
-	CreatedAt          time.Time             `json:"created_at" db:"created_at"`
-}
+// Metric captures performance data for a guardrail execution. It is defined
+// by storage so metrics can be written in the same transaction as request logs.
+type Metric = storage.GuardrailMetric
 
 // ExecutionResult represents the result of executing a set of guardrails
 type ExecutionResult struct {
 	Passed          bool              `json:"passed"`
 	FailedGuardrail string            `json:"failed_guardrail,omitempty"`
 	FailureReason   string            `json:"failure_reason,omitempty"`
+	FailureCategory string            `json:"failure_category,omitempty"` // From the failed result's "category" metadata
 	Results         []*GuardrailResult `json:"results"`
+
+	// ModifiedContent is the content after every applied modification, set
+	// when guardrails that passed modified it, in the order they did
+	ModifiedContent        *string  `json:"modified_content,omitempty"`
+	ModifiedBy             []string `json:"modified_by,omitempty"`
+	DiscardedModifications []string `json:"discarded_modifications,omitempty"` // Conflicting parallel modifications
 }
 
 // GuardrailResult represents the result of a single guardrail execution
@@ -72,6 +63,7 @@ type GuardrailFailure struct {
 	Name     string `json:"name"`
 	Priority int    `json:"priority"`
 	Reason   string `json:"reason"`
+	Category string `json:"category,omitempty"`
 }
 
 // GuardrailFactory is a function type for creating guardrails
@@ -0,0 +1,377 @@
+package vision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Defaults, matching what OpenAI's vision models accept
+const (
+	defaultMaxSizeBytes      = 20 << 20
+	defaultModerationURL     = "https://api.openai.com/v1/moderations"
+	defaultModerationModel   = "omni-moderation-latest"
+	defaultModerationTimeout = 10 * time.Second
+)
+
+// Failure categories reported in result metadata
+const (
+	CategoryCount   = "image_count"
+	CategorySize    = "image_size"
+	CategoryFormat  = "image_format"
+	CategoryURL     = "image_url"
+	CategoryFlagged = "image_flagged"
+)
+
+// Config structure for the image guardrail
+type Config struct {
+	MaxImages    int               `json:"max_images"`     // Per request; 0 means no limit
+	MaxSizeBytes int               `json:"max_size_bytes"` // Per inline image
+	Formats      []string          `json:"formats"`        // png, jpeg, gif, webp
+	AllowURLs    *bool             `json:"allow_urls"`     // Remote images, default true
+	AllowedHosts []string          `json:"allowed_hosts"`  // Remote image hosts; "*.example.com" matches subdomains
+	Moderation   *ModerationConfig `json:"moderation"`     // External moderation; omit to only validate
+}
+
+// ModerationConfig points at an image moderation service speaking the OpenAI
+// moderations API: images are sent as image_url inputs and the request is
+// flagged if any result is
+type ModerationConfig struct {
+	URL        string   `json:"url"`        // Default: OpenAI's moderations endpoint
+	APIKey     string   `json:"api_key"`    // Default: OPENAI_API_KEY for OpenAI's endpoint
+	Model      string   `json:"model"`      // Default: omni-moderation-latest
+	Categories []string `json:"categories"` // Only block on these; all when empty
+	Timeout    string   `json:"timeout"`    // Default: 10s
+	FailOpen   *bool    `json:"fail_open"`  // Pass images when the service fails, default true
+}
+
+// ImageGuardrail validates the images attached to vision requests and sends
+// them to an external moderation service
+type ImageGuardrail struct {
+	name         string
+	priority     int
+	maxImages    int
+	maxSizeBytes int
+	formats      map[string]bool
+	allowURLs    bool
+	allowedHosts []string
+	moderation   *moderator
+}
+
+// moderator calls the configured moderation service
+type moderator struct {
+	url        string
+	apiKey     string
+	model      string
+	categories []string
+	failOpen   bool
+	httpClient *http.Client
+}
+
+// moderationResult is one result of a moderations API response
+type moderationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+// NewImageGuardrail creates a new image guardrail
+func NewImageGuardrail(name string, priority int, config map[string]interface{}) (*ImageGuardrail, error) {
+	var cfg Config
+	if configBytes, err := json.Marshal(config); err == nil {
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid image config: %w", err)
+		}
+	}
+
+	if cfg.MaxImages < 0 || cfg.MaxSizeBytes < 0 {
+		return nil, fmt.Errorf("image guardrail limits must not be negative")
+	}
+	maxSize := cfg.MaxSizeBytes
+	if maxSize == 0 {
+		maxSize = defaultMaxSizeBytes
+	}
+
+	if len(cfg.Formats) == 0 {
+		cfg.Formats = []string{guardrails.FormatPNG, guardrails.FormatJPEG, guardrails.FormatGIF, guardrails.FormatWebP}
+	}
+	formats := make(map[string]bool, len(cfg.Formats))
+	for _, format := range cfg.Formats {
+		format = strings.ToLower(format)
+		if format == "jpg" {
+			format = guardrails.FormatJPEG
+		}
+		switch format {
+		case guardrails.FormatPNG, guardrails.FormatJPEG, guardrails.FormatGIF, guardrails.FormatWebP:
+			formats[format] = true
+		default:
+			return nil, fmt.Errorf("unknown image format: %s", format)
+		}
+	}
+
+	allowURLs := true
+	if cfg.AllowURLs != nil {
+		allowURLs = *cfg.AllowURLs
+	}
+
+	g := &ImageGuardrail{
+		name:         name,
+		priority:     priority,
+		maxImages:    cfg.MaxImages,
+		maxSizeBytes: maxSize,
+		formats:      formats,
+		allowURLs:    allowURLs,
+		allowedHosts: cfg.AllowedHosts,
+	}
+
+	if m := cfg.Moderation; m != nil {
+		moderation := &moderator{
+			url:        m.URL,
+			apiKey:     m.APIKey,
+			model:      m.Model,
+			categories: m.Categories,
+			failOpen:   m.FailOpen == nil || *m.FailOpen,
+			httpClient: &http.Client{Timeout: defaultModerationTimeout},
+		}
+		if moderation.url == "" {
+			moderation.url = defaultModerationURL
+			if moderation.apiKey == "" {
+				moderation.apiKey = os.Getenv("OPENAI_API_KEY")
+			}
+		}
+		if moderation.model == "" {
+			moderation.model = defaultModerationModel
+		}
+		if m.Timeout != "" {
+			timeout, err := time.ParseDuration(m.Timeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid image moderation timeout: %s", m.Timeout)
+			}
+			moderation.httpClient.Timeout = timeout
+		}
+		g.moderation = moderation
+	}
+
+	return g, nil
+}
+
+// Name returns the guardrail's unique identifier
+func (g *ImageGuardrail) Name() string {
+	return g.name
+}
+
+// Priority returns execution priority (lower = higher priority)
+func (g *ImageGuardrail) Priority() int {
+	return g.priority
+}
+
+// Check validates the images in a raw request body
+func (g *ImageGuardrail) Check(ctx context.Context, content string) (*guardrails.Result, error) {
+	input := guardrails.ParseInput("input", content, guardrails.Scope{})
+	return g.CheckImages(ctx, input.Images, input)
+}
+
+// CheckImages validates each image's size, format and host, then sends them
+// all to the moderation service
+func (g *ImageGuardrail) CheckImages(ctx context.Context, images []guardrails.Image, input *guardrails.GuardrailInput) (*guardrails.Result, error) {
+	if len(images) == 0 {
+		return &guardrails.Result{
+			Passed:   true,
+			Reason:   "No images found",
+			Metadata: map[string]interface{}{"images": 0},
+		}, nil
+	}
+
+	if g.maxImages > 0 && len(images) > g.maxImages {
+		return g.reject(images, CategoryCount, fmt.Sprintf("Request has %d images, over the limit of %d", len(images), g.maxImages)), nil
+	}
+	for i, img := range images {
+		if category, reason := g.validate(img); category != "" {
+			return g.reject(images, category, fmt.Sprintf("Image %d %s", i+1, reason)), nil
+		}
+	}
+
+	metadata := map[string]interface{}{"images": len(images)}
+	if g.moderation == nil {
+		return &guardrails.Result{Passed: true, Reason: "Images passed validation", Metadata: metadata}, nil
+	}
+
+	results, err := g.moderation.call(ctx, images)
+	if err != nil {
+		if !g.moderation.failOpen {
+			return nil, fmt.Errorf("image moderation failed: %w", err)
+		}
+		metadata["api_call"] = "failed"
+		metadata["error"] = err.Error()
+		return &guardrails.Result{
+			Passed:   true,
+			Reason:   fmt.Sprintf("Image moderation error: %v", err),
+			Metadata: metadata,
+		}, nil
+	}
+	metadata["api_call"] = "success"
+
+	flagged := g.moderation.flaggedCategories(results)
+	metadata["moderation_results"] = results
+	if len(flagged) > 0 {
+		metadata["category"] = CategoryFlagged
+		metadata["flagged_categories"] = flagged
+		return &guardrails.Result{
+			Passed:   false,
+			Reason:   fmt.Sprintf("Image flagged for: %s", strings.Join(flagged, ", ")),
+			Metadata: metadata,
+		}, nil
+	}
+	return &guardrails.Result{Passed: true, Reason: "Images passed moderation", Metadata: metadata}, nil
+}
+
+// validate checks one image, returning a failure category and reason
+func (g *ImageGuardrail) validate(img guardrails.Image) (string, string) {
+	if !img.Inline() {
+		if !g.allowURLs {
+			return CategoryURL, "is a URL; only inline images are allowed"
+		}
+		u, err := url.Parse(img.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return CategoryURL, "has an invalid URL"
+		}
+		if len(g.allowedHosts) > 0 && !hostAllowed(g.allowedHosts, u.Hostname()) {
+			return CategoryURL, fmt.Sprintf("is hosted on %s, which is not allowed", u.Hostname())
+		}
+		return "", ""
+	}
+
+	if size := img.Size(); size > g.maxSizeBytes {
+		return CategorySize, fmt.Sprintf("is %d bytes, over the limit of %d", size, g.maxSizeBytes)
+	}
+	data, err := img.Decode()
+	if err != nil {
+		return CategoryFormat, "is not valid base64"
+	}
+	format := guardrails.ImageFormat(data)
+	if format == "" {
+		return CategoryFormat, "is not a recognised image"
+	}
+	if !g.formats[format] {
+		return CategoryFormat, fmt.Sprintf("is a %s, which is not allowed", format)
+	}
+	return "", ""
+}
+
+// reject fails the check with a validation category
+func (g *ImageGuardrail) reject(images []guardrails.Image, category, reason string) *guardrails.Result {
+	return &guardrails.Result{
+		Passed: false,
+		Reason: reason,
+		Metadata: map[string]interface{}{
+			"images":   len(images),
+			"category": category,
+		},
+	}
+}
+
+// hostAllowed matches a hostname against exact names and "*." suffixes
+func hostAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// call sends the images to the moderation service as image_url inputs
+func (m *moderator) call(ctx context.Context, images []guardrails.Image) ([]moderationResult, error) {
+	inputs := make([]map[string]interface{}, len(images))
+	for i, img := range images {
+		inputs[i] = map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": img.DataURL()},
+		}
+	}
+	requestBody, err := json.Marshal(map[string]interface{}{"model": m.model, "input": inputs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var modResp struct {
+		Results []moderationResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&modResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(modResp.Results) == 0 {
+		return nil, fmt.Errorf("no results in moderation response")
+	}
+	return modResp.Results, nil
+}
+
+// flaggedCategories returns the sorted categories that should block the
+// request, with "flagged" standing in when a result is flagged without any
+func (m *moderator) flaggedCategories(results []moderationResult) []string {
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if !result.Flagged && len(m.categories) == 0 {
+			continue
+		}
+		for category, violated := range result.Categories {
+			if violated && (len(m.categories) == 0 || contains(m.categories, category)) {
+				seen[category] = true
+			}
+		}
+		if result.Flagged && len(m.categories) == 0 && len(seen) == 0 {
+			seen["flagged"] = true
+		}
+	}
+
+	flagged := make([]string, 0, len(seen))
+	for category := range seen {
+		flagged = append(flagged, category)
+	}
+	sort.Strings(flagged)
+	return flagged
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+)
+
+// SetUserHeader names the header that identifies the end user behind a
+// request, for guardrail users filters and metrics
+func (h *ProxyHandler) SetUserHeader(name string) {
+	if name != "" {
+		h.userHeader = name
+	}
+}
+
+// requestClient identifies who sent a request: the fingerprint of its API
+// key, its tenant and the end user named by the user header
+func (h *ProxyHandler) requestClient(r *http.Request, requestTenant *tenant.Tenant) guardrails.Client {
+	client := guardrails.Client{UserID: strings.TrimSpace(r.Header.Get(h.userHeader))}
+	client.APIKeyID = middleware.APIKeyID(r)
+	if requestTenant != nil {
+		client.Tenant = requestTenant.ID
+	}
+	return client
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/compression"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// defaultCompressionMinSize is the smallest body compressed unless configured otherwise
+const defaultCompressionMinSize = 1024
+
+// SetCompression compresses responses the gateway writes or rewrites itself
+// for clients whose Accept-Encoding allows it
+func (h *ProxyHandler) SetCompression(cfg config.CompressionConfig) error {
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = []string{compression.Gzip}
+	}
+	for _, encoding := range cfg.Encodings {
+		switch encoding {
+		case compression.Gzip, compression.Brotli, compression.Zstd, compression.Deflate:
+		default:
+			return fmt.Errorf("unsupported compression encoding %q", encoding)
+		}
+	}
+	if cfg.MinSize < 0 {
+		return fmt.Errorf("compression min_size must not be negative")
+	}
+	if cfg.MinSize == 0 {
+		cfg.MinSize = defaultCompressionMinSize
+	}
+	h.compression = &cfg
+	return nil
+}
+
+// writeGatewayBody writes a response body the gateway produced, compressed
+// with the best encoding the client accepts when compression is enabled.
+// Headers must be set, and the status not yet written; Content-Length is set here.
+func (h *ProxyHandler) writeGatewayBody(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	// HEAD responses and bodiless statuses keep the headers they describe
+	if r.Method != http.MethodHead && status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified {
+		body = h.compressBody(w, r, body)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error writing response body: %v", err)
+	}
+}
+
+// compressBody encodes a text body for the client, setting Content-Encoding
+// and Vary. Bodies that are small, binary or already encoded are returned as is.
+func (h *ProxyHandler) compressBody(w http.ResponseWriter, r *http.Request, body []byte) []byte {
+	if h.compression == nil || w.Header().Get("Content-Encoding") != "" ||
+		!middleware.IsTextContent(w.Header().Get("Content-Type")) {
+		return body
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	if len(body) < h.compression.MinSize {
+		return body
+	}
+	encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"), h.compression.Encodings)
+	if encoding == "" {
+		return body
+	}
+	encoded, err := compression.Encode(encoding, body)
+	if err != nil {
+		log.Printf("Warning: Failed to %s-encode response: %v", encoding, err)
+		return body
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	middleware.ResetEncodedHeaders(w.Header())
+	return encoded
+}
+
+// addVary adds a field to the Vary header unless it is already listed
+func addVary(header http.Header, field string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if name := strings.TrimSpace(existing); name == "*" || strings.EqualFold(name, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/NamanArora/flash-gateway/internal/compression"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/transform"
+)
+
+// SetContextFallback retries requests over their model's context window on a
+// larger-context model or with fewer messages
+func (h *ProxyHandler) SetContextFallback(fallback *transform.ContextFallback) {
+	h.contextFallback = fallback
+}
+
+// retryContextOverflow retries a request the provider rejected for exceeding
+// the model's context window, rewriting it as the context fallback config
+// allows. Returns the first response that isn't a context overflow, or the
+// last one when no retries are left, with the request body it answered.
+func (h *ProxyHandler) retryContextOverflow(r *http.Request, resp *http.Response, requestBody string, provider providers.Provider, requestTenant *tenant.Tenant, providerName string) (*http.Response, string, error) {
+	var steps []transform.ContextStep
+	defer func() {
+		if len(steps) > 0 {
+			middleware.AddLogMetadata(r.Context(), "context_fallback", steps)
+		}
+	}()
+
+	for len(steps) < h.contextFallback.MaxAttempts() {
+		if resp.StatusCode < 400 || resp.StatusCode >= 500 {
+			return resp, requestBody, nil
+		}
+
+		// Error bodies are small, so read one to check it and hand it back intact
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, requestBody, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			if decoded, err := compression.Decode(encoding, body); err == nil {
+				body = decoded
+			}
+		}
+		if !transform.IsContextOverflow(resp.StatusCode, body) {
+			return resp, requestBody, nil
+		}
+
+		rewritten, step, ok := h.contextFallback.Next(requestBody)
+		if !ok {
+			return resp, requestBody, nil
+		}
+		requestBody = rewritten
+		setRequestBody(r, rewritten)
+		outbound, key, err := h.upstreamRequest(r, requestTenant, providerName)
+		if err != nil {
+			return resp, requestBody, nil
+		}
+		log.Printf("Request exceeded the context window of %s, retrying: %s to %s", providerName, step.Action, step.Model)
+
+		resp.Body.Close()
+		retried, err := provider.ProxyRequest(r.Context(), r.URL.Path, outbound)
+		if key != nil {
+			key.Observe(retried)
+		}
+		steps = append(steps, step)
+		if err != nil {
+			return nil, requestBody, err
+		}
+		resp = retried
+	}
+	return resp, requestBody, nil
+}
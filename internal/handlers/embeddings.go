@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+)
+
+// embeddingUsage summarizes the inputs of an embeddings request for the request log
+type embeddingUsage struct {
+	Inputs      int `json:"inputs"`
+	InputTokens int `json:"input_tokens"`
+}
+
+// countEmbeddingInputs counts the inputs and input tokens of an embeddings request.
+// Input may be a string, a list of strings, a token array, or a list of token arrays.
+func countEmbeddingInputs(body string) (*embeddingUsage, bool) {
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(body), &req); err != nil || len(req.Input) == 0 {
+		return nil, false
+	}
+
+	var text string
+	if json.Unmarshal(req.Input, &text) == nil {
+		return &embeddingUsage{Inputs: 1, InputTokens: tokenizer.Count(req.Model, text)}, true
+	}
+
+	var texts []string
+	if json.Unmarshal(req.Input, &texts) == nil {
+		usage := &embeddingUsage{Inputs: len(texts)}
+		for _, t := range texts {
+			usage.InputTokens += tokenizer.Count(req.Model, t)
+		}
+		return usage, true
+	}
+
+	// Pre-tokenized input is already counted
+	var tokens []int
+	if json.Unmarshal(req.Input, &tokens) == nil {
+		return &embeddingUsage{Inputs: 1, InputTokens: len(tokens)}, true
+	}
+
+	var batches [][]int
+	if json.Unmarshal(req.Input, &batches) == nil {
+		usage := &embeddingUsage{Inputs: len(batches)}
+		for _, b := range batches {
+			usage.InputTokens += len(b)
+		}
+		return usage, true
+	}
+
+	return nil, false
+}
@@ -1,50 +1,230 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"text/template"
 	"time"
 
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
 	"github.com/google/uuid"
 )
 
+// defaultBlockedMessage is returned when no custom refusal is configured
+const defaultBlockedMessage = "I cannot service this request"
+
+// defaultBlockedModel is reported when the request did not name a model
+const defaultBlockedModel = "gpt-3.5-turbo"
+
+// BlockDetails describes a guardrail block, exposed to refusal message templates
+type BlockDetails struct {
+	Guardrail string
+	Layer     string
+	Reason    string
+	Category  string
+	Model     string // Model named in the request, echoed back in synthesized responses
+	Provider  string // Provider the request was routed to, selects the response format
+}
+
+// isAnthropic reports whether a blocked request should get an Anthropic Messages-shaped refusal
+func isAnthropic(endpoint string, details BlockDetails) bool {
+	return details.Provider == "anthropic" || endpoint == "/v1/messages"
+}
+
+// model returns the model to report in a synthesized response
+func (d BlockDetails) model() string {
+	if d.Model == "" {
+		return defaultBlockedModel
+	}
+	return d.Model
+}
+
+// blockedResponse is a compiled refusal configuration
+type blockedResponse struct {
+	message    *template.Template
+	statusCode int
+}
+
 // GuardrailResponseBuilder creates API-compatible responses for blocked content
-type GuardrailResponseBuilder struct{}
+type GuardrailResponseBuilder struct {
+	defaultResponse *blockedResponse
+	perGuardrail    map[string]*blockedResponse
+}
 
 // NewGuardrailResponseBuilder creates a new response builder
 func NewGuardrailResponseBuilder() *GuardrailResponseBuilder {
-	return &GuardrailResponseBuilder{}
+	return &GuardrailResponseBuilder{
+		perGuardrail: make(map[string]*blockedResponse),
+	}
+}
+
+// Configure loads the global and per-guardrail refusal messages and status codes
+func (b *GuardrailResponseBuilder) Configure(cfg config.GuardrailsConfig) error {
+	defaultResponse, err := compileBlockedResponse("default", cfg.BlockedResponse)
+	if err != nil {
+		return err
+	}
+	b.defaultResponse = defaultResponse
+
+	all := append(append([]config.GuardrailConfig{}, cfg.InputGuardrails...), cfg.OutputGuardrails...)
+	for _, g := range all {
+		if g.BlockedResponse == nil {
+			continue
+		}
+		response, err := compileBlockedResponse(g.Name, *g.BlockedResponse)
+		if err != nil {
+			return err
+		}
+		b.perGuardrail[g.Name] = response
+	}
+	return nil
 }
 
-// BuildResponse creates an appropriate API response based on the endpoint
-func (b *GuardrailResponseBuilder) BuildResponse(endpoint string) ([]byte, error) {
+// compileBlockedResponse parses a refusal message template and validates its status code
+func compileBlockedResponse(name string, cfg config.BlockedResponseConfig) (*blockedResponse, error) {
+	response := &blockedResponse{statusCode: cfg.StatusCode}
+	if response.statusCode == 0 {
+		response.statusCode = http.StatusOK
+	}
+	if response.statusCode != http.StatusOK && (response.statusCode < 400 || response.statusCode > 499) {
+		return nil, fmt.Errorf("blocked response for %s: status_code must be 200 or 4xx, got %d", name, cfg.StatusCode)
+	}
+
+	if cfg.Message != "" {
+		message, err := template.New(name).Option("missingkey=zero").Parse(cfg.Message)
+		if err != nil {
+			return nil, fmt.Errorf("blocked response for %s: invalid message template: %w", name, err)
+		}
+		response.message = message
+	}
+	return response, nil
+}
+
+// responseFor returns the refusal configuration for a guardrail, if any
+func (b *GuardrailResponseBuilder) responseFor(guardrail string) *blockedResponse {
+	if response, ok := b.perGuardrail[guardrail]; ok {
+		return response
+	}
+	return b.defaultResponse
+}
+
+// StatusCode returns the HTTP status clients receive when the guardrail blocks
+func (b *GuardrailResponseBuilder) StatusCode(guardrail string) int {
+	if response := b.responseFor(guardrail); response != nil {
+		return response.statusCode
+	}
+	return http.StatusOK
+}
+
+// Message renders the refusal text for a block
+func (b *GuardrailResponseBuilder) Message(details BlockDetails) string {
+	response := b.responseFor(details.Guardrail)
+	if response == nil || response.message == nil {
+		return b.GetBlockedMessage()
+	}
+
+	var buf bytes.Buffer
+	if err := response.message.Execute(&buf, details); err != nil {
+		return b.GetBlockedMessage()
+	}
+	return buf.String()
+}
+
+// BuildResponse creates an appropriate API response based on the endpoint.
+// Guardrails configured with a 4xx status get an OpenAI-style error body instead.
+func (b *GuardrailResponseBuilder) BuildResponse(endpoint string, details BlockDetails) ([]byte, error) {
+	message := b.Message(details)
+	anthropic := isAnthropic(endpoint, details)
+	if b.StatusCode(details.Guardrail) != http.StatusOK {
+		if anthropic {
+			return b.buildAnthropicErrorResponse(message)
+		}
+		return b.buildErrorResponse(message, details)
+	}
+	if anthropic {
+		return b.buildAnthropicMessageResponse(message, details.model())
+	}
+
 	switch endpoint {
 	case "/v1/chat/completions":
-		return b.buildChatCompletionResponse()
+		return b.buildChatCompletionResponse(message, details.model())
 	case "/v1/completions":
-		return b.buildLegacyCompletionResponse()
+		return b.buildLegacyCompletionResponse(message, details.model())
 	case "/v1/responses":
 		// Assume responses endpoint uses chat completion format
-		return b.buildChatCompletionResponse()
+		return b.buildChatCompletionResponse(message, details.model())
 	default:
 		// Default to chat completion format for unknown endpoints
-		return b.buildChatCompletionResponse()
+		return b.buildChatCompletionResponse(message, details.model())
 	}
 }
 
+// buildErrorResponse creates an OpenAI-style error body for 4xx refusals
+func (b *GuardrailResponseBuilder) buildErrorResponse(message string, details BlockDetails) ([]byte, error) {
+	response := map[string]interface{}{
+		"error": map[string]interface{}{
+			"message":   message,
+			"type":      "guardrail_blocked",
+			"code":      "content_filter",
+			"param":     nil,
+			"guardrail": details.Guardrail,
+		},
+	}
+
+	return json.Marshal(response)
+}
+
+// buildAnthropicErrorResponse creates an Anthropic-style error body for 4xx refusals
+func (b *GuardrailResponseBuilder) buildAnthropicErrorResponse(message string) ([]byte, error) {
+	response := map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "invalid_request_error",
+			"message": message,
+		},
+	}
+
+	return json.Marshal(response)
+}
+
+// buildAnthropicMessageResponse creates an Anthropic Messages API response
+func (b *GuardrailResponseBuilder) buildAnthropicMessageResponse(message, model string) ([]byte, error) {
+	response := map[string]interface{}{
+		"id":    fmt.Sprintf("msg_blocked_%s", uuid.New().String()[:8]),
+		"type":  "message",
+		"role":  "assistant",
+		"model": model,
+		"content": []map[string]interface{}{
+			{"type": "text", "text": message},
+		},
+		"stop_reason":   "refusal",
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":  0,
+			"output_tokens": tokenizer.Count(model, message),
+		},
+	}
+
+	return json.Marshal(response)
+}
+
 // buildChatCompletionResponse creates a chat completion response
-func (b *GuardrailResponseBuilder) buildChatCompletionResponse() ([]byte, error) {
+func (b *GuardrailResponseBuilder) buildChatCompletionResponse(message, model string) ([]byte, error) {
+	completionTokens := tokenizer.Count(model, message)
 	response := map[string]interface{}{
 		"id":      fmt.Sprintf("chatcmpl-blocked-%s", uuid.New().String()[:8]),
 		"object":  "chat.completion",
 		"created": time.Now().Unix(),
-		"model":   "gpt-3.5-turbo",
+		"model":   model,
 		"choices": []map[string]interface{}{
 			{
 				"index": 0,
 				"message": map[string]interface{}{
 					"role":    "assistant",
-					"content": "I cannot service this request",
+					"content": message,
 					"refusal": nil,
 				},
 				"logprobs":      nil,
@@ -53,8 +233,8 @@ func (b *GuardrailResponseBuilder) buildChatCompletionResponse() ([]byte, error)
 		},
 		"usage": map[string]interface{}{
 			"prompt_tokens":     0,
-			"completion_tokens": 6, // "I cannot service this request" is roughly 6 tokens
-			"total_tokens":      6,
+			"completion_tokens": completionTokens,
+			"total_tokens":      completionTokens,
 		},
 		"system_fingerprint": "fp_guardrail_blocked",
 	}
@@ -63,15 +243,16 @@ func (b *GuardrailResponseBuilder) buildChatCompletionResponse() ([]byte, error)
 }
 
 // buildLegacyCompletionResponse creates a legacy text completion response
-func (b *GuardrailResponseBuilder) buildLegacyCompletionResponse() ([]byte, error) {
+func (b *GuardrailResponseBuilder) buildLegacyCompletionResponse(message, model string) ([]byte, error) {
+	completionTokens := tokenizer.Count(model, message)
 	response := map[string]interface{}{
 		"id":      fmt.Sprintf("cmpl-blocked-%s", uuid.New().String()[:8]),
 		"object":  "text_completion",
 		"created": time.Now().Unix(),
-		"model":   "gpt-3.5-turbo",
+		"model":   model,
 		"choices": []map[string]interface{}{
 			{
-				"text":          "I cannot service this request",
+				"text":          message,
 				"index":         0,
 				"logprobs":      nil,
 				"finish_reason": "stop",
@@ -79,17 +260,102 @@ func (b *GuardrailResponseBuilder) buildLegacyCompletionResponse() ([]byte, erro
 		},
 		"usage": map[string]interface{}{
 			"prompt_tokens":     0,
-			"completion_tokens": 6, // "I cannot service this request" is roughly 6 tokens
-			"total_tokens":      6,
+			"completion_tokens": completionTokens,
+			"total_tokens":      completionTokens,
 		},
 	}
 
 	return json.Marshal(response)
 }
 
+// BuildStreamRefusal creates the server-sent events that end a stream blocked mid-flight
+func (b *GuardrailResponseBuilder) BuildStreamRefusal(endpoint string, details BlockDetails) []byte {
+	refusal := b.Message(details)
+	message := "\n\n" + refusal
+
+	if isAnthropic(endpoint, details) {
+		return b.buildAnthropicStreamRefusal(message, details.model())
+	}
+
+	var event map[string]interface{}
+	switch endpoint {
+	case "/v1/responses":
+		// The Responses API has no [DONE] marker, it signals failures with an error event
+		event = map[string]interface{}{
+			"type":    "error",
+			"code":    "content_filter",
+			"message": refusal,
+		}
+		data, _ := json.Marshal(event)
+		return []byte(fmt.Sprintf("event: error\ndata: %s\n\n", data))
+	case "/v1/completions":
+		event = map[string]interface{}{
+			"id":      fmt.Sprintf("cmpl-blocked-%s", uuid.New().String()[:8]),
+			"object":  "text_completion",
+			"created": time.Now().Unix(),
+			"model":   details.model(),
+			"choices": []map[string]interface{}{
+				{"text": message, "index": 0, "logprobs": nil, "finish_reason": "content_filter"},
+			},
+		}
+	default:
+		event = map[string]interface{}{
+			"id":      fmt.Sprintf("chatcmpl-blocked-%s", uuid.New().String()[:8]),
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   details.model(),
+			"choices": []map[string]interface{}{
+				{
+					"index":         0,
+					"delta":         map[string]interface{}{"content": message},
+					"logprobs":      nil,
+					"finish_reason": "content_filter",
+				},
+			},
+		}
+	}
+
+	data, _ := json.Marshal(event)
+	return []byte(fmt.Sprintf("data: %s\n\ndata: [DONE]\n\n", data))
+}
+
+// buildAnthropicStreamRefusal appends the refusal to the open text block and ends the
+// message with a refusal stop reason, as a Messages API stream would
+func (b *GuardrailResponseBuilder) buildAnthropicStreamRefusal(message, model string) []byte {
+	events := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{"content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]interface{}{"type": "text_delta", "text": message},
+		}},
+		{"content_block_stop", map[string]interface{}{
+			"type":  "content_block_stop",
+			"index": 0,
+		}},
+		{"message_delta", map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": "refusal", "stop_sequence": nil},
+			"usage": map[string]interface{}{"output_tokens": tokenizer.Count(model, message)},
+		}},
+		{"message_stop", map[string]interface{}{
+			"type": "message_stop",
+		}},
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		data, _ := json.Marshal(event.data)
+		fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", event.name, data)
+	}
+	return buf.Bytes()
+}
+
 // GetBlockedMessage returns the standard blocked message
 func (b *GuardrailResponseBuilder) GetBlockedMessage() string {
-	return "I cannot service this request"
+	return defaultBlockedMessage
 }
 
 // GuardrailBlockContext holds information about blocked requests
@@ -100,4 +366,4 @@ type GuardrailBlockContext struct {
 	GuardrailReason  string
 	OriginalResponse []byte // Only for output guardrails
 	OverrideResponse []byte // The fake response we generate
-}
\ No newline at end of file
+}
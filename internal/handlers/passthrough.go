@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// passthroughBufferSize is how much of a passed-through stream is read before it is flushed
+const passthroughBufferSize = 32 * 1024
+
+// uploadEndpoints take binary or multipart uploads, such as audio to
+// transcribe or images to edit
+var uploadEndpoints = map[string]bool{
+	"/v1/audio/transcriptions": true,
+	"/v1/audio/translations":   true,
+	"/v1/images/edits":         true,
+	"/v1/images/variations":    true,
+	"/v1/files":                true,
+}
+
+// isPassthroughUpload reports whether a request body streams to the provider
+// unbuffered, without input guardrails. Only non-text bodies sent to upload
+// endpoints do; any other endpoint's body is read and checked whatever
+// Content-Type the client claims.
+func isPassthroughUpload(r *http.Request) bool {
+	path := r.URL.Path
+	upload := uploadEndpoints[path] || (strings.HasPrefix(path, "/v1/uploads/") && strings.HasSuffix(path, "/parts"))
+	return upload && !middleware.IsTextContent(r.Header.Get("Content-Type"))
+}
+
+// isPassthroughStream reports whether a response arrives without a known
+// length, chunked or as an HTTP/2 stream, and carries nothing guardrails or
+// cost tracking can read, such as speech audio. These are forwarded as they
+// arrive instead of being buffered.
+func isPassthroughStream(resp *http.Response) bool {
+	return resp.ContentLength < 0 && !middleware.IsTextContent(resp.Header.Get("Content-Type"))
+}
+
+// servePassthrough forwards a response body chunk by chunk, followed by the
+// upstream's trailers once it has been read to the end
+func (h *ProxyHandler) servePassthrough(w http.ResponseWriter, r *http.Request, resp *http.Response, guardrailNames []string) {
+	copyResponseHeaders(w, resp.Header)
+	w.Header().Del("Content-Length")
+	declareTrailers(w, resp)
+	h.recordCost(w, r, nil, guardrailNames)
+	w.WriteHeader(resp.StatusCode)
+	middleware.AddLogMetadata(r.Context(), "streamed", true)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, passthroughBufferSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				log.Printf("Error writing stream to client: %v", werr)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			copyTrailers(w, resp)
+			return
+		}
+		if err != nil {
+			kind, _ := classifyUpstreamError(err)
+			log.Printf("Error reading upstream stream (%s): %v", kind, err)
+			return
+		}
+	}
+}
+
+// declareTrailers announces the trailers the upstream declared, so clients
+// know to expect them. Must be called before the status is written.
+func declareTrailers(w http.ResponseWriter, resp *http.Response) {
+	if len(resp.Trailer) == 0 {
+		return
+	}
+	names := make([]string, 0, len(resp.Trailer))
+	for name := range resp.Trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Trailer", strings.Join(names, ", "))
+}
+
+// copyTrailers sends the upstream's trailers, which are only known once its
+// body has been read to the end. Trailers it sent without declaring them
+// are passed on too.
+func copyTrailers(w http.ResponseWriter, resp *http.Response) {
+	declared := make(map[string]bool)
+	for _, value := range w.Header().Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for name, values := range resp.Trailer {
+		key := name
+		if !declared[name] {
+			key = http.TrailerPrefix + name
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
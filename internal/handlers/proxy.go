@@ -1,18 +1,37 @@
 package handlers
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/NamanArora/flash-gateway/internal/brownout"
+	"github.com/NamanArora/flash-gateway/internal/budget"
+	"github.com/NamanArora/flash-gateway/internal/compression"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/conversation"
+	"github.com/NamanArora/flash-gateway/internal/cost"
 	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/jwtauth"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/promptcache"
 	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/routing"
+	"github.com/NamanArora/flash-gateway/internal/slo"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+	"github.com/NamanArora/flash-gateway/internal/transform"
+	"github.com/NamanArora/flash-gateway/internal/translate"
+	"github.com/NamanArora/flash-gateway/internal/vkeys"
+	"github.com/NamanArora/flash-gateway/internal/websocket"
 	"github.com/google/uuid"
 )
 
@@ -20,8 +39,34 @@ import (
 type ProxyHandler struct {
 	providers        map[string]providers.Provider
 	routes          map[string]string // endpoint -> provider mapping
+	prefixes        map[string]string // path prefix -> provider, for namespaced providers
+	methods         map[endpointKey][]string // configured methods per provider endpoint
 	guardrailExecutor *guardrails.Executor
 	responseBuilder  *GuardrailResponseBuilder
+	costCalculator   *cost.Calculator
+	budgets          *budget.Tracker
+	tokenDrift       *tokenizer.DriftTracker // Set when prompt tokens are estimated before proxying
+	tokenHeader      bool
+	promptCache      *promptcache.Tracker // Prompt cache hit rates and cache_control injection
+	redactor         *middleware.BodyRedactor // Logging redaction rules, for responses kept with guardrail blocks
+	brownout         *brownout.Controller
+	bypassVerifier   *guardrails.BypassVerifier
+	requestTransformer *transform.RequestTransformer
+	modelAliases     *transform.ModelAliases
+	trafficSplitter  *transform.TrafficSplitter
+	contextFallback  *transform.ContextFallback // Retries of requests over the model's context window
+	truncator        *transform.Truncator       // Shortens requests estimated to overflow the model's context window
+	conversations    *conversation.Tracker
+	limiters         map[string]*providers.Limiter // provider -> in-flight request cap
+	healthCheckers   map[string]*providers.HealthChecker // provider -> health and ejection state
+	keyPools         map[string]*providers.KeyPool // provider -> upstream keys held by the gateway
+	slo              *slo.Tracker // Latency and error rates per provider and model
+	streamCheckpoint int // Run output guardrails every N stream events
+	userHeader       string // Names the end user behind a request
+	maxBodySize      int64            // Request body limit in bytes, 0 for none
+	endpointBodySize map[string]int64 // endpoint -> limit replacing maxBodySize
+	websocket        config.WebSocketConfig
+	compression      *config.CompressionConfig // Encodings for responses the gateway writes itself, when enabled
 }
 
 // NewProxyHandler creates a new proxy handler
@@ -29,7 +74,12 @@ func NewProxyHandler() *ProxyHandler {
 	return &ProxyHandler{
 		providers:       make(map[string]providers.Provider),
 		routes:          make(map[string]string),
+		prefixes:        make(map[string]string),
+		methods:         make(map[endpointKey][]string),
 		responseBuilder: NewGuardrailResponseBuilder(),
+		conversations:   conversation.NewTracker(),
+		streamCheckpoint: 20,
+		userHeader:       guardrails.DefaultUserHeader,
 	}
 }
 
@@ -38,12 +88,158 @@ func (h *ProxyHandler) SetGuardrailExecutor(executor *guardrails.Executor) {
 	h.guardrailExecutor = executor
 }
 
-// RegisterProvider registers a provider and its supported endpoints
+// SetStreamCheckpoint sets how many stream events pass between output guardrail runs
+func (h *ProxyHandler) SetStreamCheckpoint(events int) {
+	if events > 0 {
+		h.streamCheckpoint = events
+	}
+}
+
+// SetCostCalculator enables per-request cost breakdowns
+func (h *ProxyHandler) SetCostCalculator(calculator *cost.Calculator) {
+	h.costCalculator = calculator
+}
+
+// SetBudgets enables spend budgets, which alert as they fill up and can
+// block or downgrade requests once exceeded. Requires a cost calculator.
+func (h *ProxyHandler) SetBudgets(tracker *budget.Tracker) {
+	h.budgets = tracker
+}
+
+// SetTokenEstimation enables prompt token estimates before proxying, compared
+// against provider-reported usage by drift
+func (h *ProxyHandler) SetTokenEstimation(drift *tokenizer.DriftTracker, responseHeader bool) {
+	h.tokenDrift = drift
+	h.tokenHeader = responseHeader
+}
+
+// SetPromptCache records provider prompt cache usage and marks Anthropic
+// requests for caching
+func (h *ProxyHandler) SetPromptCache(tracker *promptcache.Tracker) {
+	h.promptCache = tracker
+}
+
+// SetRedactor applies logging redaction rules to the responses kept with
+// output guardrail blocks
+func (h *ProxyHandler) SetRedactor(redactor *middleware.BodyRedactor) {
+	h.redactor = redactor
+}
+
+// redactResponse applies response redaction rules to a body kept for logging
+func (h *ProxyHandler) redactResponse(body []byte) []byte {
+	if h.redactor == nil || len(body) == 0 {
+		return body
+	}
+	return []byte(h.redactor.RedactResponse(string(body)))
+}
+
+// SetBypassVerifier enables signed X-Guardrail-Bypass headers
+func (h *ProxyHandler) SetBypassVerifier(verifier *guardrails.BypassVerifier) {
+	h.bypassVerifier = verifier
+}
+
+// SetRequestTransformer enables system prompt injection, default parameters and
+// prompt templates on proxied requests
+func (h *ProxyHandler) SetRequestTransformer(transformer *transform.RequestTransformer) {
+	h.requestTransformer = transformer
+}
+
+// SetModelAliases enables rewriting of aliased model names in request bodies
+func (h *ProxyHandler) SetModelAliases(aliases *transform.ModelAliases) {
+	h.modelAliases = aliases
+}
+
+// SetTrafficSplitter enables A/B tests and canaries that send a share of
+// requests to other models
+func (h *ProxyHandler) SetTrafficSplitter(splitter *transform.TrafficSplitter) {
+	h.trafficSplitter = splitter
+}
+
+// SetBodyLimits caps request body sizes, by default and per endpoint.
+// Oversized requests are rejected before their bodies are buffered.
+func (h *ProxyHandler) SetBodyLimits(defaultLimit int64, endpoints map[string]int64) {
+	h.maxBodySize = defaultLimit
+	h.endpointBodySize = endpoints
+}
+
+// SetWebSocket configures how upgraded connections are relayed
+func (h *ProxyHandler) SetWebSocket(cfg config.WebSocketConfig) {
+	h.websocket = cfg
+}
+
+// SetProviderLimiter caps the requests in flight to a provider
+func (h *ProxyHandler) SetProviderLimiter(providerName string, limiter *providers.Limiter) {
+	if h.limiters == nil {
+		h.limiters = make(map[string]*providers.Limiter)
+	}
+	h.limiters[providerName] = limiter
+}
+
+// SetHealthChecker stops routing to a provider while it is ejected as unhealthy
+func (h *ProxyHandler) SetHealthChecker(providerName string, checker *providers.HealthChecker) {
+	if h.healthCheckers == nil {
+		h.healthCheckers = make(map[string]*providers.HealthChecker)
+	}
+	h.healthCheckers[providerName] = checker
+}
+
+// SetKeyPool sets the upstream API keys the gateway sends to a provider
+func (h *ProxyHandler) SetKeyPool(providerName string, pool *providers.KeyPool) {
+	if h.keyPools == nil {
+		h.keyPools = make(map[string]*providers.KeyPool)
+	}
+	h.keyPools[providerName] = pool
+}
+
+// SetBrownout lets optional features be shed while the gateway is browned out
+func (h *ProxyHandler) SetBrownout(controller *brownout.Controller) {
+	h.brownout = controller
+}
+
+// SetSLO records each upstream request's latency and outcome for SLO tracking
+func (h *ProxyHandler) SetSLO(tracker *slo.Tracker) {
+	h.slo = tracker
+}
+
+// endpointKey identifies one configured endpoint of one provider
+type endpointKey struct {
+	provider string
+	endpoint string
+}
+
+// SetEndpointMethods records the methods configured for a provider endpoint.
+// When several endpoint patterns match a path, the first one allowing the
+// request's method serves it.
+func (h *ProxyHandler) SetEndpointMethods(providerName, endpoint string, methods []string) {
+	h.methods[endpointKey{providerName, endpoint}] = methods
+}
+
+// SetPathPrefix namespaces a provider's endpoints under a path prefix. It
+// must be called before the provider is registered.
+func (h *ProxyHandler) SetPathPrefix(providerName, prefix string) {
+	h.prefixes[prefix] = providerName
+}
+
+// RegisterProvider registers a provider and its supported endpoints. An
+// endpoint listed by several providers is served by the first one registered,
+// unless a routing rule picks another. Namespaced providers are only reached
+// through their prefix.
 func (h *ProxyHandler) RegisterProvider(provider providers.Provider) {
 	h.providers[provider.GetName()] = provider
 	
+	if prefix := h.pathPrefix(provider.GetName()); prefix != "" {
+		for _, endpoint := range provider.SupportedEndpoints() {
+			log.Printf("Registered endpoint %s with provider %s", prefix+endpoint, provider.GetName())
+		}
+		return
+	}
+
 	// Register all supported endpoints for this provider
 	for _, endpoint := range provider.SupportedEndpoints() {
+		if owner, taken := h.routes[endpoint]; taken {
+			log.Printf("Endpoint %s is also served by provider %s when routing rules select it (default: %s)", endpoint, provider.GetName(), owner)
+			continue
+		}
 		h.routes[endpoint] = provider.GetName()
 		log.Printf("Registered endpoint %s with provider %s", endpoint, provider.GetName())
 	}
@@ -52,7 +248,24 @@ func (h *ProxyHandler) RegisterProvider(provider providers.Provider) {
 // ServeHTTP implements http.Handler interface
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Find the provider for this endpoint
-	providerName, exists := h.routes[r.URL.Path]
+	providerName, exists := h.route(r.URL.Path, r.Method)
+	if name, endpoint, ok := h.matchPathPrefix(r.URL.Path); ok {
+		// Namespaced paths name their provider and are served without the prefix
+		if _, served := h.endpointFor(h.providers[name], endpoint, r.Method); !served {
+			http.Error(w, fmt.Sprintf("Endpoint %s not found", r.URL.Path), http.StatusNotFound)
+			return
+		}
+		r = withPath(r, endpoint)
+		providerName, exists = name, true
+	} else if rule := routing.FromContext(r.Context()); rule != nil && rule.Provider != "" && exists {
+		providerName = rule.Provider
+	}
+	if replay := replayFromContext(r.Context()); replay != nil {
+		middleware.AddLogMetadata(r.Context(), "replay", replay)
+		if replay.Provider != "" {
+			providerName, exists = replay.Provider, true
+		}
+	}
 	if !exists {
 		http.Error(w, fmt.Sprintf("Endpoint %s not found", r.URL.Path), http.StatusNotFound)
 		return
@@ -63,31 +276,265 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Provider %s not available", providerName), http.StatusInternalServerError)
 		return
 	}
+	// The configured endpoint, possibly a pattern, that settings are looked up by
+	route, served := h.endpointFor(provider, r.URL.Path, r.Method)
+	if !served {
+		http.Error(w, fmt.Sprintf("Provider %s does not serve %s", providerName, r.URL.Path), http.StatusBadRequest)
+		return
+	}
+
+	// Answer OPTIONS for the route itself, unless an endpoint lists OPTIONS to
+	// have it proxied. CORS preflights were already answered by the CORS middleware.
+	if r.Method == http.MethodOptions && !h.isMethodAllowed(r.URL.Path, r.Method, provider) {
+		w.Header().Set("Allow", h.allowHeader(provider, r.URL.Path))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
 	// Validate HTTP method for this endpoint
 	if !h.isMethodAllowed(r.URL.Path, r.Method, provider) {
+		w.Header().Set("Allow", h.allowHeader(provider, r.URL.Path))
 		http.Error(w, fmt.Sprintf("Method %s not allowed for endpoint %s", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Tenants may only reach the providers they are configured for
+	requestTenant := tenant.FromContext(r.Context())
+	if requestTenant != nil && !requestTenant.AllowsProvider(providerName) {
+		http.Error(w, fmt.Sprintf("Tenant %s cannot use provider %s", requestTenant.ID, providerName), http.StatusForbidden)
+		return
+	}
+
+	// Reject oversized bodies up front, or as soon as reading passes the limit
+	bodyLimit := h.maxBodySize
+	if limit, ok := h.endpointBodySize[route]; ok {
+		bodyLimit = limit
+	}
+	if bodyLimit > 0 && r.Body != nil {
+		if r.ContentLength > bodyLimit {
+			middleware.AddLogMetadata(r.Context(), "body_too_large", r.ContentLength)
+			writeBodyTooLargeError(w, bodyLimit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+	}
+
+	// Fail fast while the provider is ejected instead of waiting on a broken upstream
+	checker := h.healthCheckers[providerName]
+	if checker != nil && !checker.Healthy() {
+		middleware.AddLogMetadata(r.Context(), "provider_ejected", providerName)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, fmt.Sprintf("Provider %s is unhealthy", providerName), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Take a provider slot before buffering anything, holding it until the response is written
+	if limiter, ok := h.limiters[providerName]; ok {
+		release, err := limiter.Acquire(r.Context())
+		if err != nil {
+			if errors.Is(err, providers.ErrConcurrencyLimit) {
+				middleware.AddLogMetadata(r.Context(), "provider_concurrency_limit", providerName)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, fmt.Sprintf("Provider %s is at its concurrency limit", providerName), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer release()
+	}
+
 	// Get request ID from context (set by capture middleware)
 	requestID := h.getRequestIDFromContext(r.Context())
+
+	// Upgraded connections are relayed message by message rather than proxied once
+	if websocket.IsUpgrade(r) {
+		h.serveWebSocket(w, r, provider, requestTenant, requestID)
+		return
+	}
 	
-	// Extract request body for guardrails (if applicable)
+	// Extract request body for guardrails (if applicable). Binary and multipart
+	// uploads to upload endpoints (audio, images) stream through to the provider unbuffered.
 	var requestBody string
-	if r.Body != nil && (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") {
-		bodyBytes, err := io.ReadAll(r.Body)
+	if r.Body != nil && (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") && !isPassthroughUpload(r) {
+		// Read once into a buffer the request log shares, instead of each keeping a copy
+		body, err := middleware.ReadBody(r, bodyLimit)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			middleware.AddLogMetadata(r.Context(), "body_too_large", true)
+			writeBodyTooLargeError(w, tooLarge.Limit)
+			return
+		}
 		if err != nil {
 			log.Printf("Error reading request body: %v", err)
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
-		requestBody = string(bodyBytes)
+		requestBody = body
 		
 		// Replace the body so it can be read again by the provider
-		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		setRequestBody(r, requestBody)
+	}
+
+	// Trusted callers may skip specific guardrails with a signed header
+	if header := r.Header.Get(guardrails.BypassHeader); header != "" {
+		r.Header.Del(guardrails.BypassHeader)
+		if h.bypassVerifier == nil {
+			h.returnGuardrailError(w, "guardrail_bypass_rejected", "Guardrail bypass is not enabled", "", http.StatusForbidden)
+			return
+		}
+		names, err := h.bypassVerifier.Verify(header, guardrails.BypassRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Body:   requestBody,
+		})
+		if err != nil {
+			log.Printf("Rejected guardrail bypass: %v", err)
+			h.returnGuardrailError(w, "guardrail_bypass_rejected", err.Error(), "", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(guardrails.WithBypass(r.Context(), names))
+		middleware.AddLogMetadata(r.Context(), "guardrail_bypass", names)
+		log.Printf("Guardrail bypass accepted for request %s: %v", requestID, names)
+	}
+
+	// Resolve model aliases first so everything downstream sees the vendor model
+	if h.modelAliases != nil && len(requestBody) > 0 {
+		requested := requestModel(requestBody)
+		if rewritten, resolved, ok := h.modelAliases.Apply(requestBody); ok {
+			requestBody = rewritten
+			setRequestBody(r, rewritten)
+			middleware.AddLogMetadata(r.Context(), "model_alias", map[string]interface{}{
+				"requested": requested,
+				"model":     resolved,
+			})
+		}
+	}
+
+	// Over-budget keys are turned away or moved to a cheaper model
+	if h.budgets != nil {
+		decision := h.budgets.Check(budget.APIKey(r), requestModel(requestBody))
+		if decision.Blocked {
+			middleware.AddLogMetadata(r.Context(), "budget_blocked", decision.Budget)
+			writeBudgetError(w, decision.Budget)
+			return
+		}
+		if requested := requestModel(requestBody); decision.FallbackModel != "" && requested != "" && requested != decision.FallbackModel {
+			if rewritten, ok := transform.SetModel(requestBody, decision.FallbackModel); ok {
+				requestBody = rewritten
+				setRequestBody(r, rewritten)
+				middleware.AddLogMetadata(r.Context(), "budget_fallback", map[string]interface{}{
+					"budget":    decision.Budget,
+					"requested": requested,
+					"model":     decision.FallbackModel,
+				})
+			}
+		}
 	}
 
+	scope := guardrails.Scope{
+		Endpoint: r.URL.Path,
+		Provider: providerName,
+		Model:    requestModel(requestBody),
+		Client:   h.requestClient(r, requestTenant),
+		Headers:  r.Header.Clone(),
+	}
+	if scope.UserID != "" {
+		middleware.AddLogMetadata(r.Context(), "user_id", scope.UserID)
+	}
+
+	// Thread multi-turn requests into conversations before the body is rewritten
+	var thread *conversation.Thread
+	if len(requestBody) > 0 {
+		headers := r.Header
+		if identity := jwtauth.FromContext(r.Context()); identity != nil {
+			// JWTs are reissued mid-conversation, so thread by the caller instead
+			headers = r.Header.Clone()
+			headers.Set("Authorization", "jwt:"+identity.Key)
+		}
+		if thread = h.conversations.Identify(headers, requestBody, scope); thread != nil {
+			w.Header().Set(conversation.Header, thread.ID)
+			middleware.AddLogMetadata(r.Context(), "conversation_id", thread.ID)
+			middleware.AddLogMetadata(r.Context(), "conversation_turn", thread.Turn)
+		}
+	}
+
+	// Assign A/B test and canary variants, recording the choice for analysis
+	if h.trafficSplitter != nil && len(requestBody) > 0 {
+		conversationID := r.Header.Get(transform.SessionHeader)
+		if thread != nil {
+			conversationID = thread.ID
+		}
+		if rewritten, assignment := h.trafficSplitter.Apply(scope, requestBody, conversationID); assignment != nil {
+			if rewritten != requestBody {
+				requestBody = rewritten
+				setRequestBody(r, rewritten)
+				scope.Model = assignment.Model
+			}
+			middleware.AddLogMetadata(r.Context(), "traffic_split", assignment)
+		}
+	}
+
+	// Rewrite the request before guardrails see it, so they check what is actually sent
+	if h.requestTransformer != nil && len(requestBody) > 0 {
+		transformed, applied, err := h.requestTransformer.Apply(scope, requestBody)
+		if err != nil {
+			log.Printf("Request transformation failed: %v", err)
+			http.Error(w, "Request transformation failed", http.StatusInternalServerError)
+			return
+		}
+		if len(applied) > 0 {
+			requestBody = transformed
+			setRequestBody(r, transformed)
+			scope.Model = requestModel(requestBody) // Defaults may have set the model
+			middleware.AddLogMetadata(r.Context(), "request_transforms", applied)
+		}
+	}
+
+	// Shorten conversations estimated to overflow the model's context window
+	if h.truncator != nil && len(requestBody) > 0 {
+		summarize := h.summarizer(r, provider, requestTenant, providerName)
+		if rewritten, truncation := h.truncator.Apply(r.Context(), scope, requestBody, promptTokenEstimator(scope), summarize); truncation != nil {
+			requestBody = rewritten
+			setRequestBody(r, rewritten)
+			middleware.AddLogMetadata(r.Context(), "truncation", truncation)
+			if !truncation.Fits {
+				log.Printf("Request to %s still exceeds the %d token context window after truncation", scope.Model, truncation.ContextWindow)
+			}
+		}
+	}
+
+	// Mark Anthropic prompts for caching after transforms, so injected system prompts are cached too
+	if h.promptCache != nil && len(requestBody) > 0 {
+		if rewritten, breakpoints := h.promptCache.Inject(scope, requestBody); len(breakpoints) > 0 {
+			requestBody = rewritten
+			setRequestBody(r, rewritten)
+			middleware.AddLogMetadata(r.Context(), "prompt_cache_breakpoints", breakpoints)
+		}
+	}
+
+	// Scope guardrails to this endpoint, provider, and model
+	r = r.WithContext(guardrails.WithScope(r.Context(), scope))
+
+	// Estimate prompt tokens from the body as sent, so budgets and rate limits can act before proxying
+	if h.tokenDrift != nil && len(requestBody) > 0 {
+		if estimate, ok := estimatePromptTokens(scope, requestBody); ok {
+			r = r.WithContext(tokenizer.WithEstimate(r.Context(), estimate))
+			middleware.AddLogMetadata(r.Context(), "token_estimate", estimate)
+			if h.tokenHeader {
+				w.Header().Set("X-Flash-Prompt-Tokens-Estimate", fmt.Sprintf("%d", estimate.PromptTokens))
+			}
+		}
+	}
+
+	// Embeddings carry no conversation, so record how much was embedded instead
+	if r.URL.Path == guardrails.EmbeddingsEndpoint {
+		if usage, ok := countEmbeddingInputs(requestBody); ok {
+			middleware.AddLogMetadata(r.Context(), "embeddings", usage)
+		}
+	}
+
+	// Track which guardrails ran so their API cost can be attributed
+	var executedGuardrailNames []string
+
 	// Run input guardrails if enabled and executor is available
 	if h.guardrailExecutor != nil && len(requestBody) > 0 {
 		result, err := h.guardrailExecutor.ExecuteInput(r.Context(), requestID, requestBody)
@@ -96,12 +543,16 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			h.returnGuardrailError(w, "input_guardrails_error", "Failed to execute input guardrails", "", http.StatusInternalServerError)
 			return
 		}
+		executedGuardrailNames = append(executedGuardrailNames, executedGuardrails(result)...)
 		
 		if !result.Passed {
 			log.Printf("Input guardrail failed: %s - %s", result.FailedGuardrail, result.FailureReason)
 			
 			// Generate API-compatible blocked response
-			overrideResponse, err := h.responseBuilder.BuildResponse(r.URL.Path)
+			details := blockDetails("input", result)
+			details.Model = requestModel(requestBody)
+			details.Provider = providerName
+			overrideResponse, err := h.responseBuilder.BuildResponse(r.URL.Path, details)
 			if err != nil {
 				log.Printf("Error building override response: %v", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -120,52 +571,101 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			
 			ctx := context.WithValue(r.Context(), "guardrail_block", guardrailCtx)
 			r = r.WithContext(ctx)
+			recordGuardrailBlock(ctx, guardrailCtx)
+			
+			// Input blocks never reach the provider, so only guardrail cost applies
+			h.recordCost(w, r, nil, executedGuardrailNames)
 			
 			// Write API-compatible response to client
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK) // Return 200, not error code
-			w.Write(overrideResponse)
+			h.writeGatewayBody(w, r, h.responseBuilder.StatusCode(details.Guardrail), overrideResponse) // 200 unless a 4xx refusal is configured
 			return
 		}
 		
-		// Check if any input guardrail modified the request content
-		for _, gr := range result.Results {
-			if gr != nil && gr.Result != nil && gr.Result.ModifiedContent != nil {
-				modifiedBody := *gr.Result.ModifiedContent
-				log.Printf("Input guardrail modified request content (guardrail: %s)", gr.Name)
-				
-				// Update request body with modified content
-				requestBody = modifiedBody
-				r.Body = io.NopCloser(bytes.NewReader([]byte(modifiedBody)))
-				break // Use first modification found
-			}
+		// Check if input guardrails modified the request content
+		if result.ModifiedContent != nil {
+			modifiedBody := *result.ModifiedContent
+			log.Printf("Input guardrails modified request content (guardrails: %s)", strings.Join(result.ModifiedBy, ", "))
+			
+			// Update request body with modified content
+			requestBody = modifiedBody
+			setRequestBody(r, modifiedBody)
+		}
+		if len(result.DiscardedModifications) > 0 {
+			middleware.AddLogMetadata(r.Context(), "request_modifications_discarded", result.DiscardedModifications)
 		}
 	}
 
+	// Streamed responses are parsed chunk by chunk, so ask for them uncompressed
+	if isStreamingRequest(requestBody) {
+		r.Header.Set("Accept-Encoding", "identity")
+	}
+
+	outbound, key, err := h.upstreamRequest(r, requestTenant, providerName)
+	if err != nil {
+		h.writeCredentialError(w, err, providerName)
+		return
+	}
+
 	// Proxy the request
-	resp, err := provider.ProxyRequest(r.Context(), r.URL.Path, r)
+	upstreamStart := time.Now()
+	resp, err := provider.ProxyRequest(r.Context(), r.URL.Path, outbound)
+	if key != nil {
+		key.Observe(resp)
+	}
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, translate.ErrInvalidRequest) {
+		succeeded := err == nil && resp.StatusCode < 500
+		if checker != nil {
+			checker.Record(succeeded)
+		}
+		if h.slo != nil {
+			h.slo.Observe(providerName, scope.Model, time.Since(upstreamStart), !succeeded)
+		}
+	}
 	if err != nil {
-		log.Printf("Proxy request failed: %v", err)
-		http.Error(w, "Proxy request failed", http.StatusBadGateway)
+		if errors.Is(err, translate.ErrInvalidRequest) {
+			log.Printf("Proxy request failed: %v", err)
+			writeTranslationError(w, err)
+			return
+		}
+		writeUpstreamError(w, r, providerName, err)
 		return
 	}
+
+	// Retry requests over the model's context window on a larger model, or with fewer messages
+	if h.contextFallback != nil && len(requestBody) > 0 {
+		resp, requestBody, err = h.retryContextOverflow(r, resp, requestBody, provider, requestTenant, providerName)
+		if err != nil {
+			writeUpstreamError(w, r, providerName, err)
+			return
+		}
+	}
 	defer resp.Body.Close()
 
+	// Server-sent event streams are forwarded as they arrive with checkpointed guardrails
+	if providers.IsEventStream(resp) {
+		h.serveStream(w, r, resp, requestID, executedGuardrailNames)
+		return
+	}
+	if isPassthroughStream(resp) {
+		h.servePassthrough(w, r, resp, executedGuardrailNames)
+		return
+	}
+
 	// Read response body for guardrails
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		http.Error(w, "Error reading response body", http.StatusInternalServerError)
+		writeUpstreamError(w, r, providerName, fmt.Errorf("reading response body: %w", err))
 		return
 	}
 
 	// Keep original response body for client (might be compressed)
 	originalResponseBody := responseBody
+	responseModified := false
 
 	// Check if response is compressed and decompress for guardrails
-	contentEncoding := resp.Header.Get("Content-Encoding")
-	if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
-		if decompressed, err := decompressGzip(responseBody); err == nil {
+	if contentEncoding := resp.Header.Get("Content-Encoding"); contentEncoding != "" {
+		if decompressed, err := compression.Decode(contentEncoding, responseBody); err == nil {
 			responseBody = decompressed // Use decompressed for guardrails
 		} else {
 			log.Printf("Warning: Failed to decompress response for guardrails: %v", err)
@@ -173,20 +673,43 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Give non-JSON upstream errors, such as HTML from a load balancer, the error format clients parse
+	if resp.StatusCode >= 400 && strings.TrimSpace(string(responseBody)) != "" && !json.Valid(responseBody) &&
+		middleware.IsTextContent(resp.Header.Get("Content-Type")) {
+		log.Printf("Provider %s returned %d with a non-JSON body", providerName, resp.StatusCode)
+		middleware.AddLogMetadata(r.Context(), "upstream_error", map[string]interface{}{
+			"kind":   upstreamHTTPError,
+			"status": resp.StatusCode,
+		})
+		responseBody = structuredUpstreamError(providerName, resp.StatusCode, responseBody)
+		originalResponseBody = responseBody
+		responseModified = true
+		resp.Header.Set("Content-Type", "application/json")
+	}
+
 	// Run output guardrails if enabled and executor is available (now on decompressed data)
-	if h.guardrailExecutor != nil && len(responseBody) > 0 {
-		result, err := h.guardrailExecutor.ExecuteOutput(r.Context(), requestID, string(responseBody))
+	// Embedding vectors and binary responses (e.g. speech audio) have no text for
+	// output guardrails to check
+	isEmbeddings := r.URL.Path == guardrails.EmbeddingsEndpoint
+	isText := middleware.IsTextContent(resp.Header.Get("Content-Type"))
+	if h.guardrailExecutor != nil && len(responseBody) > 0 && !isEmbeddings && isText {
+		responseText := string(responseBody) // Converted once for every guardrail pass
+		result, err := h.guardrailExecutor.ExecuteOutput(r.Context(), requestID, responseText)
 		if err != nil {
 			log.Printf("Output guardrails execution error: %v", err)
 			h.returnGuardrailError(w, "output_guardrails_error", "Failed to execute output guardrails", "", http.StatusInternalServerError)
 			return
 		}
+		executedGuardrailNames = append(executedGuardrailNames, executedGuardrails(result)...)
 		
 		if !result.Passed {
 			log.Printf("Output guardrail failed: %s - %s", result.FailedGuardrail, result.FailureReason)
 			
 			// Generate API-compatible blocked response
-			overrideResponse, err := h.responseBuilder.BuildResponse(r.URL.Path)
+			details := blockDetails("output", result)
+			details.Model = requestModel(requestBody)
+			details.Provider = providerName
+			overrideResponse, err := h.responseBuilder.BuildResponse(r.URL.Path, details)
 			if err != nil {
 				log.Printf("Error building override response: %v", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -195,7 +718,7 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			
 			// Re-run guardrails with response data for metrics collection
 			_, metricsErr := h.guardrailExecutor.ExecuteOutputWithResponses(
-				r.Context(), requestID, string(responseBody), 
+				r.Context(), requestID, responseText, 
 				originalResponseBody, overrideResponse)
 			if metricsErr != nil {
 				log.Printf("Error executing guardrails with response data: %v", metricsErr)
@@ -207,52 +730,167 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				Layer:            "output",
 				GuardrailName:    result.FailedGuardrail,
 				GuardrailReason:  result.FailureReason,
-				OriginalResponse: originalResponseBody, // Store original AI response
-				OverrideResponse: overrideResponse,
+				OriginalResponse: h.redactResponse(originalResponseBody), // Original AI response, redacted as logs are
+				OverrideResponse: h.redactResponse(overrideResponse),
 			}
 			
 			ctx := context.WithValue(r.Context(), "guardrail_block", guardrailCtx)
 			r = r.WithContext(ctx)
+			recordGuardrailBlock(ctx, guardrailCtx)
 			
 			// Override the response that will be written to client
 			originalResponseBody = overrideResponse
 			
-			// Copy response headers but update content length
-			corsHeaders := map[string]bool{
-				"Access-Control-Allow-Origin":      true,
-				"Access-Control-Allow-Methods":     true,
-				"Access-Control-Allow-Headers":     true,
-				"Access-Control-Max-Age":           true,
-				"Access-Control-Allow-Credentials": true,
-				"Access-Control-Expose-Headers":    true,
-			}
-			
-			for key, values := range resp.Header {
-				for _, value := range values {
-					if corsHeaders[key] {
-						w.Header().Set(key, value)
-					} else {
-						w.Header().Add(key, value)
-					}
-				}
-			}
-			
-			// Update content length for new response
-			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(overrideResponse)))
+			// Copy response headers, dropping those that describe the upstream's body
+			copyResponseHeaders(w, resp.Header)
+			middleware.ResetEntityHeaders(w.Header())
 			w.Header().Set("Content-Type", "application/json")
 			
-			// Set response status code - use 200 for blocked content
-			w.WriteHeader(http.StatusOK)
+			// The upstream call was still billed even though its output was replaced
+			h.recordCost(w, r, responseBody, executedGuardrailNames)
 			
-			// Write override response to client
-			if _, err := w.Write(overrideResponse); err != nil {
-				log.Printf("Error writing override response: %v", err)
-			}
+			// Write override response to client - 200 for blocked content unless a 4xx refusal is configured
+			h.writeGatewayBody(w, r, h.responseBuilder.StatusCode(details.Guardrail), overrideResponse)
 			return
 		}
+		
+		// Check if output guardrails rewrote the response content
+		if result.ModifiedContent != nil {
+			modifiedBy := strings.Join(result.ModifiedBy, ", ")
+			log.Printf("Output guardrails modified response content (guardrails: %s)", modifiedBy)
+			
+			// The rewrite is made on decoded content, so it is sent uncompressed
+			responseBody = []byte(*result.ModifiedContent)
+			originalResponseBody = responseBody
+			responseModified = true
+			middleware.AddLogMetadata(r.Context(), "response_modified_by", modifiedBy)
+		}
+		if len(result.DiscardedModifications) > 0 {
+			middleware.AddLogMetadata(r.Context(), "response_modifications_discarded", result.DiscardedModifications)
+		}
 	}
 
 	// Copy response headers
+	copyResponseHeaders(w, resp.Header)
+	if responseModified {
+		middleware.ResetEntityHeaders(w.Header())
+	}
+
+	h.recordCost(w, r, responseBody, executedGuardrailNames)
+	h.conversations.Remember(responseBody, thread)
+
+	// Write original response body (compressed if it was compressed), or the
+	// rewritten one compressed as the client asked
+	h.writeGatewayBody(w, r, resp.StatusCode, originalResponseBody)
+}
+
+// errNoCredential is returned for JWT and virtual key callers when the
+// gateway holds no key for the provider
+var errNoCredential = errors.New("no upstream credential")
+
+// upstreamRequest returns the request to send to a provider. Routing rules
+// and tenants with their own upstream key use it in place of the client's,
+// which stays on r for budgets and logs; a rule's key wins over a tenant's,
+// and either wins over the provider's api_keys, which are rotated and
+// returned so the response can be observed against the key. JWT and virtual
+// key callers never have their token forwarded, so they need one of the
+// gateway's keys for the provider.
+func (h *ProxyHandler) upstreamRequest(r *http.Request, requestTenant *tenant.Tenant, providerName string) (outbound *http.Request, key *providers.APIKey, err error) {
+	var credential string
+	if rule := routing.FromContext(r.Context()); rule != nil {
+		credential = rule.Credential
+	}
+	if requestTenant != nil && credential == "" {
+		credential = requestTenant.Credential(providerName)
+	}
+	identity := jwtauth.FromContext(r.Context())
+	if identity != nil && credential == "" {
+		credential = identity.Credential(providerName)
+	}
+	gatewayOnly := identity != nil || vkeys.FromContext(r.Context()) != nil
+	if credential == "" {
+		if pool := h.keyPools[providerName]; pool != nil {
+			if key, err = pool.Next(); err != nil {
+				middleware.AddLogMetadata(r.Context(), "upstream_keys_exhausted", true)
+				return nil, nil, err
+			}
+			middleware.AddLogMetadata(r.Context(), "upstream_key", key.Name)
+			outbound = r.Clone(r.Context())
+			pool.Apply(outbound, key)
+			return outbound, key, nil
+		}
+	}
+	if gatewayOnly && credential == "" {
+		return nil, nil, errNoCredential
+	}
+	if credential == "" {
+		return r, nil, nil
+	}
+	outbound = r.Clone(r.Context())
+	outbound.Header.Set("Authorization", "Bearer "+credential)
+	outbound.Header.Del("x-api-key")
+	return outbound, nil, nil
+}
+
+// writeCredentialError answers a request upstreamRequest couldn't prepare
+func (h *ProxyHandler) writeCredentialError(w http.ResponseWriter, err error, providerName string) {
+	if errors.Is(err, providers.ErrKeysExhausted) {
+		retry := h.keyPools[providerName].RetryAfter()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, fmt.Sprintf("All API keys for provider %s are rate limited", providerName), http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, fmt.Sprintf("No upstream credential for provider %s", providerName), http.StatusForbidden)
+}
+
+// pathPrefix returns the prefix a provider is namespaced under, if any
+func (h *ProxyHandler) pathPrefix(providerName string) string {
+	for prefix, name := range h.prefixes {
+		if name == providerName {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// matchPathPrefix finds the namespaced provider for a path by its longest
+// matching prefix, returning the path without the prefix
+func (h *ProxyHandler) matchPathPrefix(path string) (providerName, endpoint string, ok bool) {
+	best := ""
+	for prefix := range h.prefixes {
+		if strings.HasPrefix(path, prefix+"/") && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return "", "", false
+	}
+	return h.prefixes[best], strings.TrimPrefix(path, best), true
+}
+
+// withPath returns a shallow copy of r for another URL path
+func withPath(r *http.Request, path string) *http.Request {
+	stripped := r.WithContext(r.Context())
+	u := *r.URL
+	u.Path, u.RawPath = path, ""
+	stripped.URL = &u
+	return stripped
+}
+
+// setRequestBody replaces the request body, keeping its Content-Length in step
+// and letting retries replay it
+func setRequestBody(r *http.Request, body string) {
+	r.Body = io.NopCloser(strings.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(body)), nil
+	}
+}
+
+// copyResponseHeaders copies upstream response headers to the client response.
+// CORS headers use Set() to overwrite the gateway's own values (prevent duplicates),
+// everything else uses Add() to preserve multiple values.
+func copyResponseHeaders(w http.ResponseWriter, header http.Header) {
 	corsHeaders := map[string]bool{
 		"Access-Control-Allow-Origin":      true,
 		"Access-Control-Allow-Methods":     true,
@@ -261,11 +899,9 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"Access-Control-Allow-Credentials": true,
 		"Access-Control-Expose-Headers":    true,
 	}
-	
-	for key, values := range resp.Header {
+
+	for key, values := range header {
 		for _, value := range values {
-			// Use Set() for CORS headers to overwrite (prevent duplicates)
-			// Use Add() for other headers to preserve multiple values
 			if corsHeaders[key] {
 				w.Header().Set(key, value)
 			} else {
@@ -273,26 +909,99 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+}
 
-	// Set response status code
-	w.WriteHeader(resp.StatusCode)
+// route returns the default provider for a path. Exact endpoints are
+// preferred over patterns, and more specific patterns over less specific
+// ones, skipping endpoints whose configured methods exclude the request's.
+func (h *ProxyHandler) route(path, method string) (string, bool) {
+	endpoints := make([]string, 0, len(h.routes))
+	for endpoint := range h.routes {
+		endpoints = append(endpoints, endpoint)
+	}
+	matches := providers.MatchingEndpoints(endpoints, path)
+	if len(matches) == 0 {
+		return "", false
+	}
+	for _, endpoint := range matches {
+		if h.allowsMethod(h.routes[endpoint], endpoint, method) {
+			return h.routes[endpoint], true
+		}
+	}
+	return h.routes[matches[0]], true
+}
 
-	// Write original response body (compressed if it was compressed)
-	if _, err := w.Write(originalResponseBody); err != nil {
-		log.Printf("Error writing response body: %v", err)
+// endpointFor returns the provider endpoint serving a path, chosen the same
+// way as route
+func (h *ProxyHandler) endpointFor(provider providers.Provider, path, method string) (string, bool) {
+	matches := providers.MatchingEndpoints(provider.SupportedEndpoints(), path)
+	if len(matches) == 0 {
+		return "", false
 	}
+	for _, endpoint := range matches {
+		if h.allowsMethod(provider.GetName(), endpoint, method) {
+			return endpoint, true
+		}
+	}
+	return matches[0], true
+}
+
+// allowsMethod reports whether a provider endpoint is configured for a
+// method; endpoints without configured methods allow any
+func (h *ProxyHandler) allowsMethod(providerName, endpoint, method string) bool {
+	methods := h.methods[endpointKey{providerName, endpoint}]
+	if len(methods) == 0 {
+		return true
+	}
+	for _, allowed := range methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMethods are allowed on endpoints configured without methods
+var defaultMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH"}
+
+// allowedMethods returns the methods a provider accepts on a path: those
+// configured on every endpoint matching it, or defaultMethods for endpoints
+// without any
+func (h *ProxyHandler) allowedMethods(provider providers.Provider, path string) []string {
+	var allowed []string
+	seen := make(map[string]bool)
+	for _, endpoint := range providers.MatchingEndpoints(provider.SupportedEndpoints(), path) {
+		methods := h.methods[endpointKey{provider.GetName(), endpoint}]
+		if len(methods) == 0 {
+			methods = defaultMethods
+		}
+		for _, method := range methods {
+			method = strings.ToUpper(method)
+			if !seen[method] {
+				seen[method] = true
+				allowed = append(allowed, method)
+			}
+		}
+	}
+	return allowed
+}
+
+// allowHeader lists the methods accepted on a path for the Allow header,
+// including OPTIONS, which the gateway answers itself
+func (h *ProxyHandler) allowHeader(provider providers.Provider, path string) string {
+	allowed := h.allowedMethods(provider, path)
+	for _, method := range allowed {
+		if method == http.MethodOptions {
+			return strings.Join(allowed, ", ")
+		}
+	}
+	return strings.Join(append(allowed, http.MethodOptions), ", ")
 }
 
 // isMethodAllowed checks if the HTTP method is allowed for the endpoint
 func (h *ProxyHandler) isMethodAllowed(endpoint, method string, provider providers.Provider) bool {
-	// This is a simplified check - in a real implementation, you'd want to
-	// check the endpoint configuration from the provider
-	// For now, we'll allow all methods that make sense for AI APIs
-	allowedMethods := []string{"GET", "POST", "PUT", "DELETE", "PATCH"}
-	
-	method = strings.ToUpper(method)
-	for _, allowed := range allowedMethods {
-		if method == allowed {
+	for _, allowed := range h.allowedMethods(provider, endpoint) {
+		if strings.EqualFold(allowed, method) {
 			return true
 		}
 	}
@@ -305,6 +1014,13 @@ func (h *ProxyHandler) GetRegisteredEndpoints() []string {
 	for endpoint := range h.routes {
 		endpoints = append(endpoints, endpoint)
 	}
+	for prefix, name := range h.prefixes {
+		if provider, ok := h.providers[name]; ok {
+			for _, endpoint := range provider.SupportedEndpoints() {
+				endpoints = append(endpoints, prefix+endpoint)
+			}
+		}
+	}
 	return endpoints
 }
 
@@ -321,6 +1037,168 @@ func (h *ProxyHandler) getRequestIDFromContext(ctx context.Context) uuid.UUID {
 	return uuid.New()
 }
 
+// recordCost computes the request's cost breakdown, attaches it to the log
+// metadata and, when configured, to the x-flash-cost response header. The
+// reported usage also reconciles the request's prompt token estimate.
+// Must be called before the response status is written.
+func (h *ProxyHandler) recordCost(w http.ResponseWriter, r *http.Request, responseBody []byte, guardrailNames []string) {
+	if h.costCalculator == nil && h.tokenDrift == nil && h.promptCache == nil {
+		return
+	}
+
+	var usage *cost.Usage
+	if len(responseBody) > 0 {
+		if parsed, ok := cost.ParseUsage(responseBody); ok {
+			usage = parsed
+		}
+	}
+	h.recordCostFromUsage(w, r, usage, guardrailNames)
+}
+
+// recordCostFromUsage records a cost breakdown for already-parsed usage
+func (h *ProxyHandler) recordCostFromUsage(w http.ResponseWriter, r *http.Request, usage *cost.Usage, guardrailNames []string) {
+	h.reconcileTokens(r, usage)
+	if h.promptCache != nil {
+		if cached, ok := h.promptCache.Observe(usage); ok {
+			middleware.AddLogMetadata(r.Context(), "prompt_cache", cached)
+			if h.promptCache.ResponseHeaderEnabled() {
+				w.Header().Set(promptcache.Header, fmt.Sprintf("%d", cached.CachedTokens))
+			}
+		}
+	}
+	if h.costCalculator == nil {
+		return
+	}
+	// Budgets still need the spend when reporting it is shed
+	shed := h.brownout.Disabled(brownout.FeatureCostTracking)
+	if shed && h.budgets == nil {
+		return
+	}
+
+	// Nothing to report for calls without usage data or billable guardrails
+	breakdown := h.costCalculator.Calculate(usage, guardrailNames)
+	if usage == nil && breakdown.GuardrailCost == 0 {
+		return
+	}
+	if h.budgets != nil {
+		// Model caps are on the model requested, which providers may report under a dated name
+		model := breakdown.Model
+		if scope, ok := guardrails.ScopeFromContext(r.Context()); ok && scope.Model != "" {
+			model = scope.Model
+		}
+		h.budgets.Record(budget.APIKey(r), model, breakdown.TotalCost, int64(breakdown.PromptTokens+breakdown.CompletionTokens))
+	}
+	if shed {
+		return
+	}
+
+	middleware.AddLogMetadata(r.Context(), "cost", breakdown)
+	if h.costCalculator.ResponseHeaderEnabled() {
+		w.Header().Set("X-Flash-Cost", breakdown.HeaderValue())
+	}
+}
+
+// executedGuardrails returns the names of guardrails that ran for an execution result
+func executedGuardrails(result *guardrails.ExecutionResult) []string {
+	if result == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(result.Results)+1)
+	for _, gr := range result.Results {
+		if gr != nil {
+			names = append(names, gr.Name)
+		}
+	}
+	if result.FailedGuardrail != "" {
+		names = append(names, result.FailedGuardrail)
+	}
+	return names
+}
+
+// recordGuardrailBlock stores a guardrail block in the request log metadata
+func recordGuardrailBlock(ctx context.Context, block *GuardrailBlockContext) {
+	middleware.AddLogMetadata(ctx, "guardrail_block", map[string]interface{}{
+		"layer":     block.Layer,
+		"guardrail": block.GuardrailName,
+		"reason":    block.GuardrailReason,
+	})
+}
+
+// blockDetails describes a failed guardrail run for refusal message templates
+func blockDetails(layer string, result *guardrails.ExecutionResult) BlockDetails {
+	return BlockDetails{
+		Guardrail: result.FailedGuardrail,
+		Layer:     layer,
+		Reason:    result.FailureReason,
+		Category:  result.FailureCategory,
+	}
+}
+
+// SetBlockedResponses configures custom refusal messages and status codes
+func (h *ProxyHandler) SetBlockedResponses(cfg config.GuardrailsConfig) error {
+	return h.responseBuilder.Configure(cfg)
+}
+
+// requestModel extracts the model named in a JSON request body, if any
+func requestModel(body string) string {
+	if body == "" {
+		return ""
+	}
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		return ""
+	}
+	return payload.Model
+}
+
+// writeBodyTooLargeError rejects a request whose body is over the endpoint's
+// limit, in the OpenAI error format
+func writeBodyTooLargeError(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": fmt.Sprintf("Request body is larger than the %d byte limit", limit),
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "request_too_large",
+		},
+	})
+}
+
+// writeTranslationError rejects a request the endpoint's translation can't
+// express in the upstream API's format, in the OpenAI error format
+func writeTranslationError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+}
+
+// writeBudgetError rejects a request whose budget is exhausted, in the
+// OpenAI error format clients already handle
+func writeBudgetError(w http.ResponseWriter, budgetName string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": fmt.Sprintf("Spend budget %s is exhausted for this period", budgetName),
+			"type":    "budget_exceeded",
+			"code":    "budget_exceeded",
+		},
+	})
+}
+
+
 // returnGuardrailError returns a standardized error response for guardrail violations
 func (h *ProxyHandler) returnGuardrailError(w http.ResponseWriter, errorType, message, guardrailName string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -343,19 +1221,3 @@ func (h *ProxyHandler) returnGuardrailError(w http.ResponseWriter, errorType, me
 		log.Printf("Error encoding guardrail error response: %v", err)
 	}
 }
-
-// decompressGzip decompresses gzip-compressed data for guardrails processing
-func decompressGzip(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-	}
-	defer reader.Close()
-	
-	decompressed, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
-	}
-	
-	return decompressed, nil
-}
\ No newline at end of file
@@ -0,0 +1,27 @@
+package handlers
+
+import "context"
+
+// replayContextKey is the context key under which a replay's details are stored
+const replayContextKey = "replay"
+
+// Replay describes a request re-sent from a stored log. It is set only by
+// the gateway's replay tool, never from client input.
+type Replay struct {
+	Of        string `json:"of"`                 // ID of the replayed log
+	RequestID string `json:"request_id"`         // Request ID of the original request
+	Provider  string `json:"provider,omitempty"` // Provider used instead of the endpoint's own
+	Model     string `json:"model,omitempty"`    // Model used instead of the original's
+}
+
+// WithReplay marks a request as a replay, so it is tagged in its log and sent
+// to the replay's provider
+func WithReplay(ctx context.Context, replay *Replay) context.Context {
+	return context.WithValue(ctx, replayContextKey, replay)
+}
+
+// replayFromContext returns the replay a request belongs to, or nil
+func replayFromContext(ctx context.Context) *Replay {
+	replay, _ := ctx.Value(replayContextKey).(*Replay)
+	return replay
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/cost"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// streamChunk covers the delta shapes of chat, legacy completion, Responses API and
+// Anthropic Messages streams
+type streamChunk struct {
+	Type    string          `json:"type"`  // Responses API or Anthropic event type
+	Delta   json.RawMessage `json:"delta"` // Responses API text, or Anthropic delta object
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// isStreamingRequest reports whether the client asked for a streamed response
+func isStreamingRequest(body string) bool {
+	if body == "" {
+		return false
+	}
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return false
+	}
+	return req.Stream
+}
+
+// serveStream forwards an SSE response to the client as it arrives, running output
+// guardrails on the accumulated text every streamCheckpoint events and once more
+// before the terminating event. A tripped guardrail ends the stream with a refusal.
+func (h *ProxyHandler) serveStream(w http.ResponseWriter, r *http.Request, resp *http.Response, requestID uuid.UUID, guardrailNames []string) {
+	// Guardrails may end the stream early with a refusal, so the upstream's
+	// length and validators can't be relied on
+	copyResponseHeaders(w, resp.Header)
+	middleware.ResetBodyHeaders(w.Header())
+	declareTrailers(w, resp)
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	runGuardrails := h.guardrailExecutor != nil && resp.StatusCode < 400
+
+	var accumulated strings.Builder
+	var usage *cost.Usage
+	checkedLength := 0
+	eventsSinceCheck := 0
+	checkpoints := 0
+
+	// refuse ends the stream with a refusal and returns it
+	refuse := func(details BlockDetails) []byte {
+		if scope, ok := guardrails.ScopeFromContext(r.Context()); ok {
+			details.Model = scope.Model
+			details.Provider = scope.Provider
+		}
+		refusal := h.responseBuilder.BuildStreamRefusal(r.URL.Path, details)
+		if _, err := w.Write(refusal); err != nil {
+			log.Printf("Error writing stream refusal: %v", err)
+		}
+		flush()
+		return refusal
+	}
+
+	// check runs output guardrails on everything streamed so far
+	check := func() bool {
+		if !runGuardrails || accumulated.Len() == checkedLength {
+			return true
+		}
+		checkedLength = accumulated.Len()
+		eventsSinceCheck = 0
+		checkpoints++
+
+		result, err := h.guardrailExecutor.ExecuteOutput(r.Context(), requestID, streamSnapshot(accumulated.String()))
+		if err != nil {
+			// Content that couldn't be checked doesn't reach the client, as
+			// with a buffered response
+			log.Printf("Stream output guardrails execution error: %v", err)
+			middleware.AddLogMetadata(r.Context(), "output_guardrails_error", err.Error())
+			refuse(BlockDetails{Layer: "output", Reason: "Failed to execute output guardrails"})
+			return false
+		}
+		guardrailNames = append(guardrailNames, executedGuardrails(result)...)
+		if result.Passed {
+			return true
+		}
+
+		log.Printf("Output guardrail failed mid-stream: %s - %s", result.FailedGuardrail, result.FailureReason)
+		refusal := refuse(blockDetails("output", result))
+		recordGuardrailBlock(r.Context(), &GuardrailBlockContext{
+			Blocked:          true,
+			Layer:            "output",
+			GuardrailName:    result.FailedGuardrail,
+			GuardrailReason:  result.FailureReason,
+			OriginalResponse: h.redactResponse([]byte(accumulated.String())),
+			OverrideResponse: h.redactResponse(refusal),
+		})
+		return false
+	}
+
+	defer func() {
+		middleware.AddLogMetadata(r.Context(), "streamed", true)
+		if checkpoints > 0 {
+			middleware.AddLogMetadata(r.Context(), "stream_guardrail_checkpoints", checkpoints)
+		}
+		if usage != nil {
+			// Headers are already sent, so the cost only reaches the log
+			h.recordCostFromUsage(w, r, usage, guardrailNames)
+		}
+	}()
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			trimmed := bytes.TrimSpace(line)
+
+			if data, ok := sseData(trimmed); ok {
+				var chunk streamChunk
+				decoded := string(data) != "[DONE]" && json.Unmarshal(data, &chunk) == nil
+
+				// [DONE] ends OpenAI streams, message_stop ends Anthropic ones
+				if string(data) == "[DONE]" || chunk.Type == "message_stop" {
+					// Final verdict before letting the client see the end of the stream
+					if !check() {
+						return
+					}
+				} else {
+					if decoded {
+						accumulated.WriteString(chunkText(&chunk))
+					}
+					if parsed, ok := cost.ParseUsage(data); ok {
+						if usage == nil {
+							usage = parsed
+						} else {
+							usage.Merge(parsed)
+						}
+					}
+					eventsSinceCheck++
+				}
+			}
+
+			if _, werr := w.Write(line); werr != nil {
+				log.Printf("Error writing stream to client: %v", werr)
+				return
+			}
+
+			// A blank line terminates an SSE event
+			if len(trimmed) == 0 {
+				flush()
+				if eventsSinceCheck >= h.streamCheckpoint && !check() {
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				kind, _ := classifyUpstreamError(err)
+				log.Printf("Error reading upstream stream (%s): %v", kind, err)
+			}
+			// Streams without an end marker (Responses API) get their final check
+			// here. Trailers follow only a stream that was sent in full.
+			if check() && err == io.EOF {
+				copyTrailers(w, resp)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// sseData returns the payload of an SSE data line
+func sseData(line []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return nil, false
+	}
+	return bytes.TrimSpace(line[len("data:"):]), true
+}
+
+// chunkText extracts generated text from a stream chunk
+func chunkText(chunk *streamChunk) string {
+	switch chunk.Type {
+	case "response.output_text.delta":
+		var text string
+		json.Unmarshal(chunk.Delta, &text)
+		return text
+	case "content_block_delta":
+		var delta struct {
+			Text string `json:"text"`
+		}
+		json.Unmarshal(chunk.Delta, &delta)
+		return delta.Text
+	}
+
+	var text strings.Builder
+	for _, choice := range chunk.Choices {
+		text.WriteString(choice.Delta.Content)
+		text.WriteString(choice.Text)
+	}
+	return text.String()
+}
+
+// streamSnapshot wraps accumulated stream text in a chat completion body so output
+// guardrails see the same shape as for buffered responses
+func streamSnapshot(content string) string {
+	snapshot, _ := json.Marshal(map[string]interface{}{
+		"object": "chat.completion",
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": content,
+				},
+			},
+		},
+	})
+	return string(snapshot)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/NamanArora/flash-gateway/internal/cost"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+)
+
+// estimatePromptTokens counts the prompt tokens a request body will use.
+// Chat-style requests include the per-message overhead; embeddings and lists
+// of prompts are counted input by input.
+func estimatePromptTokens(scope guardrails.Scope, body string) (tokenizer.Estimate, bool) {
+	estimate := tokenizer.Estimate{Model: scope.Model, Encoding: tokenizer.EncodingName(scope.Model)}
+
+	if scope.Endpoint == guardrails.EmbeddingsEndpoint {
+		usage, ok := countEmbeddingInputs(body)
+		if !ok {
+			return estimate, false
+		}
+		estimate.PromptTokens = usage.InputTokens
+		return estimate, true
+	}
+
+	input := guardrails.ParseInput("input", body, scope)
+	if len(input.Messages) == 0 {
+		return estimate, false
+	}
+	if input.Batch {
+		for _, m := range input.Messages {
+			estimate.PromptTokens += tokenizer.Count(scope.Model, m.Content)
+		}
+		return estimate, true
+	}
+
+	messages := make([]tokenizer.Message, 0, len(input.Messages))
+	for _, m := range input.Messages {
+		messages = append(messages, tokenizer.Message{Role: m.Role, Content: m.Content})
+	}
+	estimate.PromptTokens = tokenizer.CountMessages(scope.Model, messages)
+	return estimate, true
+}
+
+// reconcileTokens compares the request's prompt token estimate with the
+// usage the provider reported, recording the drift
+func (h *ProxyHandler) reconcileTokens(r *http.Request, usage *cost.Usage) {
+	if h.tokenDrift == nil || usage == nil || usage.PromptTokens == 0 {
+		return
+	}
+	estimate, ok := tokenizer.EstimateFromContext(r.Context())
+	if !ok {
+		return
+	}
+	drift := h.tokenDrift.Record(estimate, usage.PromptTokens)
+	middleware.AddLogMetadata(r.Context(), "token_estimate", map[string]interface{}{
+		"encoding":             estimate.Encoding,
+		"prompt_tokens":        estimate.PromptTokens,
+		"actual_prompt_tokens": usage.PromptTokens,
+		"drift_pct":            drift,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/transform"
+)
+
+// summaryEndpoint is where truncation's summary requests are sent
+const summaryEndpoint = "/v1/chat/completions"
+
+// summaryTimeout bounds how long a request waits for its conversation to be summarized
+const summaryTimeout = 30 * time.Second
+
+// SetTruncator shortens requests estimated to overflow their model's context window
+func (h *ProxyHandler) SetTruncator(truncator *transform.Truncator) {
+	h.truncator = truncator
+}
+
+// promptTokenEstimator counts prompt tokens the way token estimation does
+func promptTokenEstimator(scope guardrails.Scope) transform.Estimator {
+	return func(body string) (int, bool) {
+		estimate, ok := estimatePromptTokens(scope, body)
+		return estimate.PromptTokens, ok
+	}
+}
+
+// summarizer sends summary prompts to the request's provider as chat
+// completions, with the same upstream credential as the request
+func (h *ProxyHandler) summarizer(r *http.Request, provider providers.Provider, requestTenant *tenant.Tenant, providerName string) transform.Summarizer {
+	return func(ctx context.Context, model, prompt string) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+		defer cancel()
+
+		body, err := json.Marshal(map[string]interface{}{
+			"model":    model,
+			"messages": []map[string]string{{"role": "user", "content": prompt}},
+		})
+		if err != nil {
+			return "", err
+		}
+		summaryRequest := r.Clone(ctx)
+		summaryRequest.URL.Path = summaryEndpoint
+		summaryRequest.URL.RawQuery = ""
+		summaryRequest.Header.Set("Content-Type", "application/json")
+		summaryRequest.Header.Set("Accept-Encoding", "identity")
+		setRequestBody(summaryRequest, string(body))
+
+		outbound, key, err := h.upstreamRequest(summaryRequest, requestTenant, providerName)
+		if err != nil {
+			return "", err
+		}
+		resp, err := provider.ProxyRequest(ctx, summaryEndpoint, outbound)
+		if key != nil {
+			key.Observe(resp)
+		}
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("upstream returned %d", resp.StatusCode)
+		}
+
+		var completion struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&completion); err != nil {
+			return "", fmt.Errorf("decoding summary: %w", err)
+		}
+		if len(completion.Choices) == 0 {
+			return "", fmt.Errorf("summary response had no choices")
+		}
+		return completion.Choices[0].Message.Content, nil
+	}
+}
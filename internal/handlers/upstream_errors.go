@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+)
+
+// Kinds of upstream failure, returned to clients as the error code
+const (
+	upstreamTimeout         = "upstream_timeout"
+	upstreamTLSError        = "upstream_tls_error"
+	upstreamConnectionError = "upstream_connection_error"
+	upstreamHTTPError       = "upstream_http_error" // The provider answered with a non-JSON error
+	upstreamError           = "upstream_error"
+)
+
+// maxUpstreamErrorText bounds how much of a non-JSON error body is passed on
+const maxUpstreamErrorText = 512
+
+// classifyUpstreamError names the kind of failure behind an error from a
+// provider call, with the status the client is answered with
+func classifyUpstreamError(err error) (string, int) {
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+
+	switch {
+	case errors.Is(err, providers.ErrUpstreamTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return upstreamTimeout, http.StatusGatewayTimeout
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr), strings.Contains(err.Error(), "tls: "):
+		return upstreamTLSError, http.StatusBadGateway
+	case errors.As(err, &dnsErr), errors.As(err, &opErr), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return upstreamConnectionError, http.StatusBadGateway
+	}
+	return upstreamError, http.StatusBadGateway
+}
+
+// upstreamErrorMessage describes a failure to the client without the
+// underlying error, which can name internal hosts
+func upstreamErrorMessage(kind, providerName string) string {
+	switch kind {
+	case upstreamTimeout:
+		return fmt.Sprintf("Provider %s did not respond in time", providerName)
+	case upstreamTLSError:
+		return fmt.Sprintf("TLS handshake with provider %s failed", providerName)
+	case upstreamConnectionError:
+		return fmt.Sprintf("Could not connect to provider %s", providerName)
+	}
+	return fmt.Sprintf("Request to provider %s failed", providerName)
+}
+
+// writeUpstreamError answers a request whose provider call failed, in the
+// OpenAI error format, logging which kind of failure it was
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, providerName string, err error) {
+	kind, status := classifyUpstreamError(err)
+	log.Printf("Proxy request to %s failed (%s): %v", providerName, kind, err)
+	middleware.AddLogMetadata(r.Context(), "upstream_error", map[string]interface{}{
+		"kind":  kind,
+		"error": err.Error(),
+	})
+	writeOpenAIError(w, status, upstreamErrorMessage(kind, providerName), kind)
+}
+
+// structuredUpstreamError wraps a provider's non-JSON error body, such as an
+// HTML page from a load balancer, in the OpenAI error format, keeping the
+// start of its text
+func structuredUpstreamError(providerName string, status int, body []byte) []byte {
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if runes := []rune(text); len(runes) > maxUpstreamErrorText {
+		text = string(runes[:maxUpstreamErrorText]) + "..."
+	}
+	message := fmt.Sprintf("Provider %s returned %d %s", providerName, status, http.StatusText(status))
+	if text != "" {
+		message += ": " + text
+	}
+	encoded, _ := json.Marshal(openAIError(message, upstreamHTTPError))
+	return encoded
+}
+
+// writeOpenAIError writes a gateway-side server error in the OpenAI error format
+func writeOpenAIError(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIError(message, code))
+}
+
+func openAIError(message, code string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "server_error",
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/websocket"
+	"github.com/google/uuid"
+)
+
+// loggedMessageSize caps the text of each message kept in a connection's log
+const loggedMessageSize = 4096
+
+// Directions a WebSocket message travels
+const (
+	fromClient = "client"
+	fromServer = "server"
+)
+
+// realtimeEvent is the part of a Realtime API event the gateway inspects
+type realtimeEvent struct {
+	Type    string          `json:"type"`
+	EventID string          `json:"event_id"`
+	Item    json.RawMessage `json:"item"`
+	Session *struct {
+		Instructions string `json:"instructions"`
+	} `json:"session"`
+	Response *struct {
+		Instructions string            `json:"instructions"`
+		Input        []json.RawMessage `json:"input"`
+	} `json:"response"`
+	Text       string `json:"text"`
+	Transcript string `json:"transcript"`
+}
+
+// wsLog summarizes a relayed connection for its request log
+type wsLog struct {
+	Subprotocol     string      `json:"subprotocol,omitempty"`
+	DurationMs      int64       `json:"duration_ms"`
+	ClientMessages  int         `json:"client_messages"`
+	ServerMessages  int         `json:"server_messages"`
+	ClientBytes     int64       `json:"client_bytes"`
+	ServerBytes     int64       `json:"server_bytes"`
+	CloseCode       int         `json:"close_code,omitempty"`
+	ClosedBy        string      `json:"closed_by,omitempty"`
+	Messages        []wsMessage `json:"messages"`
+	MessagesDropped int         `json:"messages_dropped,omitempty"` // Past max_logged_messages
+	GuardrailBlocks []wsBlock   `json:"guardrail_blocks,omitempty"`
+}
+
+// wsMessage is one logged message
+type wsMessage struct {
+	Direction string `json:"direction"`
+	Type      string `json:"type,omitempty"` // Event type of JSON messages
+	Size      int    `json:"size"`
+	AtMs      int64  `json:"at_ms"`          // Since the connection opened
+	Data      string `json:"data,omitempty"` // Text messages, except audio chunks
+}
+
+// wsBlock is a message a guardrail stopped
+type wsBlock struct {
+	Layer     string `json:"layer"`
+	EventType string `json:"event_type"`
+	Guardrail string `json:"guardrail"`
+	Reason    string `json:"reason"`
+}
+
+// wsCloseError ends a connection with a close code sent to both sides
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed with %d: %s", e.code, e.reason)
+}
+
+// wsSession relays one upgraded connection between a client and a provider
+type wsSession struct {
+	h         *ProxyHandler
+	ctx       context.Context
+	requestID uuid.UUID
+	start     time.Time
+
+	client       net.Conn
+	clientReader *bufio.Reader
+	clientMu     sync.Mutex // Held while writing to the client
+	upstream     io.ReadWriteCloser
+	upstreamMu   sync.Mutex // Held while writing upstream
+
+	mu  sync.Mutex // Guards log
+	log wsLog
+}
+
+// serveWebSocket upgrades the client connection once the provider accepts
+// the upgrade, then relays messages in both directions until either side
+// closes. Messages are forwarded whole, so text events can be checked by
+// guardrails before they are passed on; extensions such as compression are
+// not negotiated, so every message can be read.
+func (h *ProxyHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, provider providers.Provider, requestTenant *tenant.Tenant, requestID uuid.UUID) {
+	providerName := provider.GetName()
+	outbound, key, err := h.upstreamRequest(r, requestTenant, providerName)
+	if err != nil {
+		h.writeCredentialError(w, err, providerName)
+		return
+	}
+	outbound = outbound.Clone(r.Context())
+	outbound.Header.Del("Sec-WebSocket-Extensions")
+
+	scope := guardrails.Scope{
+		Endpoint: r.URL.Path,
+		Provider: providerName,
+		Model:    r.URL.Query().Get("model"), // Realtime sessions name their model in the URL
+		Client:   h.requestClient(r, requestTenant),
+		Headers:  r.Header.Clone(),
+	}
+	ctx := guardrails.WithScope(r.Context(), scope)
+
+	resp, err := provider.ProxyRequest(ctx, r.URL.Path, outbound)
+	if key != nil {
+		key.Observe(resp)
+	}
+	if checker := h.healthCheckers[providerName]; checker != nil && !errors.Is(err, context.Canceled) {
+		checker.Record(err == nil && resp.StatusCode < 500)
+	}
+	if err != nil {
+		writeUpstreamError(w, r, providerName, fmt.Errorf("websocket upgrade: %w", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	// A refused upgrade is an ordinary response, passed on as it is
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		copyResponseHeaders(w, resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		log.Printf("WebSocket upgrade failed: upstream connection is not writable")
+		writeOpenAIError(w, http.StatusBadGateway, upstreamErrorMessage(upstreamError, providerName), upstreamError)
+		return
+	}
+
+	copyResponseHeaders(w, resp.Header)
+	w.Header().Del("Sec-WebSocket-Extensions")
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	// Server read and write timeouts are meant for requests, not long-lived connections
+	conn.SetDeadline(time.Time{})
+
+	s := &wsSession{
+		h:            h,
+		ctx:          ctx,
+		requestID:    requestID,
+		start:        time.Now(),
+		client:       conn,
+		clientReader: buffered.Reader,
+		upstream:     upstream,
+		log: wsLog{
+			Subprotocol: resp.Header.Get("Sec-WebSocket-Protocol"),
+			Messages:    []wsMessage{},
+		},
+	}
+	s.run()
+
+	s.log.DurationMs = time.Since(s.start).Milliseconds()
+	middleware.AddLogMetadata(r.Context(), "websocket", s.log)
+}
+
+// run relays messages until a side closes or fails, then closes both
+func (s *wsSession) run() {
+	done := make(chan error, 2)
+	go func() { done <- s.relay(fromClient) }()
+	go func() { done <- s.relay(fromServer) }()
+
+	err := <-done
+	var closeErr *wsCloseError
+	if errors.As(err, &closeErr) {
+		s.closeBoth(closeErr.code, closeErr.reason)
+	}
+	s.client.Close()
+	s.upstream.Close()
+	<-done
+}
+
+// relay reads messages from one side and forwards them to the other.
+// Control frames are forwarded as they arrive; data frames are gathered into
+// whole messages first.
+func (s *wsSession) relay(direction string) error {
+	var src io.Reader = s.clientReader
+	if direction == fromServer {
+		src = bufio.NewReader(s.upstream)
+	}
+	limit := s.h.websocket.MaxMessageSize
+	if limit <= 0 {
+		limit = 16 << 20
+	}
+
+	var opcode byte
+	var message []byte
+	for {
+		f, err := websocket.ReadFrame(src, limit)
+		if errors.Is(err, websocket.ErrTooLarge) {
+			return &wsCloseError{code: websocket.CloseMessageTooBig, reason: "Message too large"}
+		}
+		if err != nil {
+			return err
+		}
+		if f.RSV != 0 {
+			return &wsCloseError{code: websocket.CloseProtocolError, reason: "No extensions were negotiated"}
+		}
+
+		if f.IsControl() {
+			if f.Opcode == websocket.OpClose {
+				s.mu.Lock()
+				if s.log.ClosedBy == "" {
+					s.log.ClosedBy = direction
+					s.log.CloseCode = websocket.CloseCode(f.Payload)
+				}
+				s.mu.Unlock()
+			}
+			if err := s.forward(direction, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case f.Opcode != websocket.OpContinuation && message != nil:
+			return &wsCloseError{code: websocket.CloseProtocolError, reason: "Expected a continuation frame"}
+		case f.Opcode == websocket.OpContinuation && message == nil:
+			return &wsCloseError{code: websocket.CloseProtocolError, reason: "Unexpected continuation frame"}
+		case f.Opcode != websocket.OpContinuation:
+			opcode = f.Opcode
+			message = make([]byte, 0, len(f.Payload))
+		}
+		if int64(len(message)+len(f.Payload)) > limit {
+			return &wsCloseError{code: websocket.CloseMessageTooBig, reason: "Message too large"}
+		}
+		message = append(message, f.Payload...)
+		if !f.Fin {
+			continue
+		}
+
+		payload := message
+		message = nil
+		forward, err := s.inspect(direction, opcode, payload)
+		if err != nil {
+			return err
+		}
+		if forward {
+			if err := s.forward(direction, &websocket.Frame{Fin: true, Opcode: opcode, Payload: payload}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// forward writes a frame to the side opposite direction
+func (s *wsSession) forward(direction string, f *websocket.Frame) error {
+	if direction == fromClient {
+		s.upstreamMu.Lock()
+		defer s.upstreamMu.Unlock()
+		return websocket.WriteFrame(s.upstream, f, true)
+	}
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	return websocket.WriteFrame(s.client, f, false)
+}
+
+// inspect logs a message and checks text events with guardrails. It reports
+// whether the message should be forwarded, or an error to end the connection.
+func (s *wsSession) inspect(direction string, opcode byte, payload []byte) (bool, error) {
+	var event realtimeEvent
+	if opcode == websocket.OpText {
+		json.Unmarshal(payload, &event)
+	}
+	s.record(direction, opcode, event.Type, payload)
+
+	executor := s.h.guardrailExecutor
+	if opcode != websocket.OpText || executor == nil || !s.h.websocket.Guardrails {
+		return true, nil
+	}
+
+	if direction == fromClient {
+		content := realtimeInput(&event)
+		if content == "" {
+			return true, nil
+		}
+		result, err := executor.ExecuteInput(s.ctx, s.requestID, content)
+		if err != nil {
+			log.Printf("Input guardrails execution error: %v", err)
+			s.reject(&event, "input", "", "Failed to execute input guardrails")
+			return false, nil
+		}
+		if !result.Passed {
+			log.Printf("Input guardrail failed on %s: %s - %s", event.Type, result.FailedGuardrail, result.FailureReason)
+			s.reject(&event, "input", result.FailedGuardrail, result.FailureReason)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	content := realtimeOutput(&event)
+	if content == "" {
+		return true, nil
+	}
+	result, err := executor.ExecuteOutput(s.ctx, s.requestID, content)
+	if err != nil {
+		log.Printf("Output guardrails execution error: %v", err)
+		s.reject(&event, "output", "", "Failed to execute output guardrails")
+		return false, &wsCloseError{code: websocket.CloseInternalError, reason: "Failed to execute output guardrails"}
+	}
+	if !result.Passed {
+		// Deltas of the response have already been relayed, so the connection ends
+		log.Printf("Output guardrail failed on %s: %s - %s", event.Type, result.FailedGuardrail, result.FailureReason)
+		s.reject(&event, "output", result.FailedGuardrail, result.FailureReason)
+		return false, &wsCloseError{code: websocket.ClosePolicyViolation, reason: "Response blocked by guardrail"}
+	}
+	return true, nil
+}
+
+// record adds a message to the connection's log
+func (s *wsSession) record(direction string, opcode byte, eventType string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if direction == fromClient {
+		s.log.ClientMessages++
+		s.log.ClientBytes += int64(len(payload))
+	} else {
+		s.log.ServerMessages++
+		s.log.ServerBytes += int64(len(payload))
+	}
+
+	maxLogged := s.h.websocket.MaxLoggedMessages
+	if maxLogged <= 0 {
+		maxLogged = 200
+	}
+	if len(s.log.Messages) >= maxLogged {
+		s.log.MessagesDropped++
+		return
+	}
+	message := wsMessage{
+		Direction: direction,
+		Type:      eventType,
+		Size:      len(payload),
+		AtMs:      time.Since(s.start).Milliseconds(),
+	}
+	if opcode == websocket.OpText && !isAudioChunk(eventType) {
+		message.Data = string(payload)
+		if len(payload) > loggedMessageSize {
+			message.Data = string(payload[:loggedMessageSize]) + "\n... [TRUNCATED]"
+		}
+	}
+	s.log.Messages = append(s.log.Messages, message)
+}
+
+// reject tells the client a message was blocked, with a Realtime API error event
+func (s *wsSession) reject(event *realtimeEvent, layer, guardrail, reason string) {
+	message := reason
+	if guardrail != "" {
+		message = fmt.Sprintf("Blocked by guardrail %s: %s", guardrail, reason)
+		s.mu.Lock()
+		s.log.GuardrailBlocks = append(s.log.GuardrailBlocks, wsBlock{
+			Layer:     layer,
+			EventType: event.Type,
+			Guardrail: guardrail,
+			Reason:    reason,
+		})
+		s.mu.Unlock()
+	}
+
+	errorEvent := map[string]interface{}{
+		"type":     "error",
+		"event_id": "event_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"error": map[string]interface{}{
+			"type":    "invalid_request_error",
+			"code":    "guardrail_blocked",
+			"message": message,
+		},
+	}
+	if layer == "input" && event.EventID != "" {
+		errorEvent["error"].(map[string]interface{})["event_id"] = event.EventID
+	}
+	payload, _ := json.Marshal(errorEvent)
+	if err := s.forward(fromServer, &websocket.Frame{Fin: true, Opcode: websocket.OpText, Payload: payload}); err != nil {
+		log.Printf("Error writing guardrail error event: %v", err)
+	}
+}
+
+// closeBoth sends a close frame to the client and the provider
+func (s *wsSession) closeBoth(code int, reason string) {
+	s.mu.Lock()
+	if s.log.ClosedBy == "" {
+		s.log.ClosedBy = "gateway"
+		s.log.CloseCode = code
+	}
+	s.mu.Unlock()
+	s.forward(fromServer, websocket.CloseFrame(code, reason))
+	s.forward(fromClient, websocket.CloseFrame(code, reason))
+}
+
+// realtimeInput returns the text a client event adds to the conversation, as
+// a request body input guardrails understand, or "" when it adds none
+func realtimeInput(event *realtimeEvent) string {
+	body := make(map[string]interface{})
+	switch event.Type {
+	case "conversation.item.create":
+		if len(event.Item) > 0 {
+			body["input"] = []json.RawMessage{event.Item}
+		}
+	case "session.update":
+		if event.Session != nil && event.Session.Instructions != "" {
+			body["instructions"] = event.Session.Instructions
+		}
+	case "response.create":
+		if event.Response != nil {
+			if event.Response.Instructions != "" {
+				body["instructions"] = event.Response.Instructions
+			}
+			if len(event.Response.Input) > 0 {
+				body["input"] = event.Response.Input
+			}
+		}
+	}
+	if len(body) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(body)
+	return string(content)
+}
+
+// realtimeOutput returns the finished text or transcript of a server event,
+// as a response body output guardrails understand, or "" when it has none
+func realtimeOutput(event *realtimeEvent) string {
+	var text string
+	switch event.Type {
+	case "response.text.done", "response.output_text.done":
+		text = event.Text
+	case "response.audio_transcript.done", "response.output_audio_transcript.done":
+		text = event.Transcript
+	}
+	if text == "" {
+		return ""
+	}
+	content, _ := json.Marshal(map[string]interface{}{
+		"output": []interface{}{map[string]interface{}{
+			"type":    "message",
+			"role":    "assistant",
+			"content": []interface{}{map[string]interface{}{"type": "output_text", "text": text}},
+		}},
+	})
+	return string(content)
+}
+
+// isAudioChunk reports whether an event carries base64 audio, which is too
+// bulky to log
+func isAudioChunk(eventType string) bool {
+	return eventType == "input_audio_buffer.append" || strings.HasSuffix(eventType, "audio.delta")
+}
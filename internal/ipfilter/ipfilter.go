@@ -0,0 +1,155 @@
+package ipfilter
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Filter admits requests by client IP. Deny entries win over allow entries,
+// and when any allow entries are configured, every other address is denied.
+type Filter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	trusted []*net.IPNet // Proxies whose X-Forwarded-For is believed
+
+	mu     sync.Mutex
+	denied map[string]int64 // Reason -> requests denied
+}
+
+// New creates an IP filter from configuration
+func New(cfg config.IPFilterConfig) (*Filter, error) {
+	f := &Filter{denied: make(map[string]int64)}
+	var err error
+	if f.allow, err = parseNetworks(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if f.deny, err = parseNetworks(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	if f.trusted, err = parseNetworks(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+	return f, nil
+}
+
+// parseNetworks parses CIDRs and single addresses
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", entry)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// Middleware rejects requests from denied addresses with 403
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := f.ClientIP(r)
+		if reason := f.check(ip); reason != "" {
+			f.mu.Lock()
+			f.denied[reason]++
+			f.mu.Unlock()
+
+			log.Printf("[IPFILTER] Denied %s %s from %s: %s", r.Method, r.URL.Path, ip, reason)
+			middleware.AddLogMetadata(r.Context(), "ip_denied", reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check returns why an address is denied, or "" when it is admitted
+func (f *Filter) check(ip net.IP) string {
+	if ip == nil {
+		return "unknown client address"
+	}
+	if network := match(f.deny, ip); network != nil {
+		return "deny " + network.String()
+	}
+	if len(f.allow) > 0 && match(f.allow, ip) == nil {
+		return "not in allow list"
+	}
+	return ""
+}
+
+// ClientIP returns the address a request came from. When it arrives through
+// a trusted proxy, X-Forwarded-For is followed back to the first untrusted hop.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || match(f.trusted, ip) == nil {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if match(f.trusted, hop) == nil {
+			break
+		}
+	}
+	return ip
+}
+
+// match returns the first network containing ip
+func match(networks []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// Status returns the configured lists and denial counts for status endpoints
+func (f *Filter) Status() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	denied := make(map[string]int64, len(f.denied))
+	for reason, count := range f.denied {
+		denied[reason] = count
+	}
+	return map[string]interface{}{
+		"allow":           networkStrings(f.allow),
+		"deny":            networkStrings(f.deny),
+		"trusted_proxies": networkStrings(f.trusted),
+		"denied":          denied,
+	}
+}
+
+func networkStrings(networks []*net.IPNet) []string {
+	values := make([]string, len(networks))
+	for i, network := range networks {
+		values[i] = network.String()
+	}
+	return values
+}
@@ -0,0 +1,207 @@
+// Package leader elects one gateway instance to run background jobs that
+// should run once per cluster rather than once per instance, such as usage
+// aggregation. The leader holds a PostgreSQL advisory lock on a connection of
+// its own; when it stops or loses the connection, the lock is released and
+// another instance takes over on its next attempt.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+const (
+	defaultName     = "flash-gateway"
+	defaultInterval = 10 * time.Second
+)
+
+// Elector takes the leader lock when it is free and keeps checking that it
+// still holds it
+type Elector struct {
+	db       *sql.DB
+	name     string
+	key      int64 // Advisory lock key, derived from name
+	interval time.Duration
+
+	mu        sync.Mutex
+	conn      *sql.Conn // Session holding the lock, while leading
+	since     time.Time
+	elections int64
+	lastError string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New creates an elector from configuration. Instances using the same
+// database and name elect one leader between them.
+func New(db *sql.DB, cfg config.LeaderConfig) (*Elector, error) {
+	name := cfg.Name
+	if name == "" {
+		name = defaultName
+	}
+	interval := defaultInterval
+	if cfg.Interval != "" {
+		parsed, err := time.ParseDuration(cfg.Interval)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid leader interval: %q", cfg.Interval)
+		}
+		interval = parsed
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return &Elector{
+		db:       db,
+		name:     name,
+		key:      int64(hash.Sum64()),
+		interval: interval,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Start tries for the lock before returning, so jobs started next know
+// whether they lead, and then keeps trying on every interval
+func (e *Elector) Start() {
+	e.check()
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+			}
+			e.check()
+		}
+	}()
+}
+
+// Stop ends the election and gives up the lock, if held
+func (e *Elector) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		if e.done != nil {
+			<-e.done
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.conn != nil {
+			e.release()
+			log.Printf("[LEADER] Stepped down as %s leader", e.name)
+		}
+	})
+}
+
+// IsLeader reports whether this instance holds the lock
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn != nil
+}
+
+// check confirms the leader's session is alive, or tries to take the lock
+func (e *Elector) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+
+	if conn != nil {
+		// The lock lives as long as the session, so a working session still holds it
+		var one int
+		if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+			e.mu.Lock()
+			e.lastError = err.Error()
+			e.release()
+			e.mu.Unlock()
+			log.Printf("[LEADER] Lost %s leadership: %v", e.name, err)
+		}
+		return
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		e.setError(err)
+		return
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired); err != nil {
+		discard(conn)
+		e.setError(err)
+		return
+	}
+	if !acquired {
+		// Another instance leads; return the session to the pool
+		conn.Close()
+		e.setError(nil)
+		return
+	}
+
+	e.mu.Lock()
+	e.conn = conn
+	e.since = time.Now()
+	e.elections++
+	e.lastError = ""
+	e.mu.Unlock()
+	log.Printf("[LEADER] Elected %s leader; this instance runs cluster-wide background jobs", e.name)
+}
+
+// release closes the session holding the lock, which releases it. Callers hold e.mu.
+func (e *Elector) release() {
+	discard(e.conn)
+	e.conn = nil
+	e.since = time.Time{}
+}
+
+// setError records the outcome of an attempt to take the lock
+func (e *Elector) setError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.lastError = err.Error()
+	} else {
+		e.lastError = ""
+	}
+}
+
+// discard closes a connection rather than returning it to the pool, so a
+// session that may hold the lock never serves other queries
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// Status returns the election state for status endpoints
+func (e *Elector) Status() map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := map[string]interface{}{
+		"name":      e.name,
+		"leader":    e.conn != nil,
+		"elections": e.elections,
+		"interval":  e.interval.String(),
+	}
+	if e.conn != nil {
+		status["since"] = e.since
+	}
+	if e.lastError != "" {
+		status["last_error"] = e.lastError
+	}
+	return status
+}
@@ -0,0 +1,269 @@
+package loadshed
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// noShedding is the threshold while no priority is being shed
+const noShedding = math.MinInt64
+
+// heapMetric is live and not yet swept heap memory, read without stopping the world
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// signal is one resource whose use can overload the gateway
+type signal struct {
+	name  string
+	limit float64
+	read  func() float64
+	last  float64
+}
+
+// Shedder rejects the lowest-priority requests while the gateway is under
+// pressure. Each check that finds a signal at or over its limit sheds one
+// more priority level, lowest first; each check with every signal below the
+// recovery level restores one.
+type Shedder struct {
+	mu            sync.Mutex
+	signals       []*signal
+	levels        []int // Sheddable priorities, lowest first
+	protected     *int  // Requests at or above this priority are never shed
+	level         int   // How many of the lowest levels are shed
+	recoverRatio  float64
+	checkInterval time.Duration
+	priority      func(*http.Request) int
+	since         time.Time
+
+	threshold int64  // Requests below this priority are shed; read on every request
+	shed      uint64 // Requests rejected
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a shedder from configuration. priorities lists every priority
+// requests can have, and priority ranks a request, as admission does.
+func New(cfg config.LoadShedConfig, priorities []int, priority func(*http.Request) int) (*Shedder, error) {
+	checkInterval := time.Second
+	if cfg.CheckInterval != "" {
+		parsed, err := time.ParseDuration(cfg.CheckInterval)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid check_interval %q", cfg.CheckInterval)
+		}
+		checkInterval = parsed
+	}
+	recoverRatio := cfg.RecoverRatio
+	if recoverRatio == 0 {
+		recoverRatio = 0.8
+	}
+	if recoverRatio <= 0 || recoverRatio >= 1 {
+		return nil, fmt.Errorf("recover_ratio must be between 0 and 1")
+	}
+	if cfg.MaxGoroutines < 0 || cfg.MaxHeapMB < 0 || cfg.MaxLogQueue < 0 || cfg.MaxLogQueue > 1 {
+		return nil, fmt.Errorf("max_goroutines and max_heap_mb must not be negative, and max_log_queue must be between 0 and 1")
+	}
+
+	s := &Shedder{
+		protected:     cfg.ProtectedPriority,
+		recoverRatio:  recoverRatio,
+		checkInterval: checkInterval,
+		priority:      priority,
+		threshold:     noShedding,
+		stop:          make(chan struct{}),
+	}
+	if cfg.MaxGoroutines > 0 {
+		s.AddSignal("goroutines", float64(cfg.MaxGoroutines), func() float64 {
+			return float64(runtime.NumGoroutine())
+		})
+	}
+	if cfg.MaxHeapMB > 0 {
+		s.AddSignal("heap_mb", float64(cfg.MaxHeapMB), heapMB)
+	}
+
+	seen := make(map[int]bool)
+	for _, p := range priorities {
+		if seen[p] || (s.protected != nil && p >= *s.protected) {
+			continue
+		}
+		seen[p] = true
+		s.levels = append(s.levels, p)
+	}
+	sort.Ints(s.levels)
+	return s, nil
+}
+
+// AddSignal registers a resource that sheds load once its reading reaches limit
+func (s *Shedder) AddSignal(name string, limit float64, read func() float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signals = append(s.signals, &signal{name: name, limit: limit, read: read})
+}
+
+// Signals reports how many signals are registered
+func (s *Shedder) Signals() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.signals)
+}
+
+// Start begins periodic pressure checks
+func (s *Shedder) Start() {
+	go func() {
+		ticker := time.NewTicker(s.checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.evaluate(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop ends pressure checks
+func (s *Shedder) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// evaluate reads every signal and moves the shedding level one step
+func (s *Shedder) evaluate(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pressure, worst := 0.0, ""
+	for _, sig := range s.signals {
+		sig.last = sig.read()
+		if utilization := sig.last / sig.limit; utilization > pressure {
+			pressure, worst = utilization, sig.name
+		}
+	}
+
+	level := s.level
+	switch {
+	case pressure >= 1 && level < s.maxLevel():
+		level++
+	case pressure < s.recoverRatio && level > 0:
+		level--
+	}
+	if level == s.level {
+		return
+	}
+
+	if s.level == 0 {
+		s.since = now
+	}
+	if level > s.level {
+		log.Printf("[LOADSHED] %s at %.0f%% of its limit, shedding priorities below %s", worst, pressure*100, describe(s.thresholdFor(level)))
+	} else if level == 0 {
+		log.Printf("[LOADSHED] Pressure subsided, no longer shedding after %v", now.Sub(s.since).Round(time.Second))
+	} else {
+		log.Printf("[LOADSHED] Pressure easing, shedding priorities below %s", describe(s.thresholdFor(level)))
+	}
+	s.level = level
+	atomic.StoreInt64(&s.threshold, s.thresholdFor(level))
+}
+
+// maxLevel is the level that sheds every sheddable priority
+func (s *Shedder) maxLevel() int {
+	return len(s.levels)
+}
+
+// thresholdFor returns the priority below which a level sheds requests
+func (s *Shedder) thresholdFor(level int) int64 {
+	switch {
+	case level == 0:
+		return noShedding
+	case level < len(s.levels):
+		return int64(s.levels[level])
+	case s.protected != nil:
+		return int64(*s.protected)
+	default:
+		return math.MaxInt64
+	}
+}
+
+// Middleware rejects requests whose priority is being shed with a 503
+func (s *Shedder) Middleware(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(math.Ceil(s.checkInterval.Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		threshold := atomic.LoadInt64(&s.threshold)
+		if threshold == noShedding {
+			next.ServeHTTP(w, r)
+			return
+		}
+		priority := s.priority(r)
+		if int64(priority) >= threshold {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		atomic.AddUint64(&s.shed, 1)
+		middleware.AddLogMetadata(r.Context(), "load_shed", map[string]interface{}{
+			"priority":       priority,
+			"shedding_below": describe(threshold),
+		})
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "Gateway is overloaded, please retry", http.StatusServiceUnavailable)
+	})
+}
+
+// Status returns the shedder state for status endpoints
+func (s *Shedder) Status() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pressure := make(map[string]interface{}, len(s.signals))
+	for _, sig := range s.signals {
+		pressure[sig.name] = map[string]interface{}{
+			"value":       sig.last,
+			"limit":       sig.limit,
+			"utilization": sig.last / sig.limit,
+		}
+	}
+	status := map[string]interface{}{
+		"shedding":   s.level > 0,
+		"level":      s.level,
+		"max_level":  s.maxLevel(),
+		"priorities": s.levels,
+		"pressure":   pressure,
+		"shed":       atomic.LoadUint64(&s.shed),
+	}
+	if s.level > 0 {
+		status["shedding_below"] = describe(s.thresholdFor(s.level))
+		status["since"] = s.since
+	}
+	return status
+}
+
+// describe names a shedding threshold for logs and status
+func describe(threshold int64) string {
+	if threshold == math.MaxInt64 {
+		return "any"
+	}
+	return strconv.FormatInt(threshold, 10)
+}
+
+// heapMB reads the heap in use, in megabytes
+func heapMB() float64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return float64(sample[0].Value.Uint64()) / (1 << 20)
+}
@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IsTextContent reports whether a body of this content type can be logged and
+// inspected as text. Bodies without a content type are assumed to be JSON.
+func IsTextContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/x-www-form-urlencoded" ||
+		mediaType == "application/x-ndjson"
+}
+
+// byteHeaders describe the exact bytes of a body rather than its content
+var byteHeaders = []string{"Content-MD5", "Digest", "Content-Digest", "Repr-Digest", "Content-Range", "Accept-Ranges"}
+
+// ResetBodyHeaders removes the headers that describe a response body the
+// gateway is rewriting, keeping its Content-Encoding: length, validators,
+// digests and ranges. The caller sets the new Content-Length if it knows it.
+func ResetBodyHeaders(header http.Header) {
+	header.Del("Content-Length")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	for _, name := range byteHeaders {
+		header.Del(name)
+	}
+}
+
+// ResetEntityHeaders removes the headers that describe a response body the
+// gateway has replaced with decoded content, Content-Encoding included
+func ResetEntityHeaders(header http.Header) {
+	header.Del("Content-Encoding")
+	ResetBodyHeaders(header)
+}
+
+// ResetEncodedHeaders updates the headers of a body the gateway has just
+// content-encoded. Digests and ranges of the plain bytes are removed, and a
+// strong ETag is weakened, since the content is the same but the bytes are not.
+func ResetEncodedHeaders(header http.Header) {
+	for _, name := range byteHeaders {
+		header.Del(name)
+	}
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// requestBodyContextKey is the context key under which the shared request body is stored
+const requestBodyContextKey = "request_body"
+
+// copyBuffers are the scratch buffers bodies are read through
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// RequestBody is the one copy of a request body shared by the pipeline.
+// Textual bodies are buffered once by the handler, and the request log takes
+// its capped view of that same buffer. Bodies the handler streams to the
+// provider instead are teed into a capped prefix as they pass through.
+type RequestBody struct {
+	src      io.ReadCloser
+	declared int64 // Content-Length sent by the client, or -1
+	logCap   int   // Bytes of the body kept for the request log
+
+	prefix   []byte // Teed from a streamed body, at most logCap bytes
+	read     int    // Bytes read from the client so far
+	text     string // The whole body, once buffered by Text
+	buffered bool
+}
+
+// newRequestBody wraps r.Body so it is shared through the request context
+func newRequestBody(r *http.Request, logCap int) (*RequestBody, *http.Request) {
+	body := &RequestBody{src: r.Body, declared: r.ContentLength, logCap: logCap}
+	r.Body = body
+	return body, r.WithContext(context.WithValue(r.Context(), requestBodyContextKey, body))
+}
+
+// RequestBodyFromContext returns the shared request body, or nil when the
+// request isn't being captured
+func RequestBodyFromContext(ctx context.Context) *RequestBody {
+	body, _ := ctx.Value(requestBodyContextKey).(*RequestBody)
+	return body
+}
+
+// Read implements io.Reader for bodies streamed to the provider
+func (b *RequestBody) Read(p []byte) (int, error) {
+	n, err := b.src.Read(p)
+	b.read += n
+	if keep := b.logCap - len(b.prefix); keep > 0 && n > 0 {
+		if keep > n {
+			keep = n
+		}
+		b.prefix = append(b.prefix, p[:keep]...)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *RequestBody) Close() error {
+	return b.src.Close()
+}
+
+// Text reads the whole body into a single buffer sized from Content-Length
+// and returns it without further copies. Later calls return the same text.
+// A body longer than limit (when positive) fails with *http.MaxBytesError.
+func (b *RequestBody) Text(limit int64) (string, error) {
+	if b.buffered {
+		// An earlier reader may have buffered it under a larger limit
+		if limit > 0 && int64(len(b.text)) > limit {
+			return "", &http.MaxBytesError{Limit: limit}
+		}
+		return b.text, nil
+	}
+	if b.read > 0 {
+		return "", fmt.Errorf("request body was already partially read")
+	}
+	text, n, err := readText(b.src, b.declared, limit)
+	b.read += n
+	if err != nil {
+		return "", err
+	}
+	b.text, b.buffered, b.prefix = text, true, nil
+	return text, nil
+}
+
+// ReadBody returns a request's textual body, buffered once and shared with the
+// request log when the request is being captured
+func ReadBody(r *http.Request, limit int64) (string, error) {
+	if body := RequestBodyFromContext(r.Context()); body != nil {
+		return body.Text(limit)
+	}
+	text, _, err := readText(r.Body, r.ContentLength, limit)
+	return text, err
+}
+
+// readText reads src into a string, growing it once up front when the size is declared
+func readText(src io.Reader, declared, limit int64) (string, int, error) {
+	if limit > 0 {
+		src = io.LimitReader(src, limit+1)
+	}
+	var text strings.Builder
+	if declared > 0 && (limit <= 0 || declared <= limit) {
+		text.Grow(int(declared))
+	}
+
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	for {
+		n, err := src.Read(*buf)
+		text.Write((*buf)[:n])
+		if limit > 0 && int64(text.Len()) > limit {
+			return "", text.Len(), &http.MaxBytesError{Limit: limit}
+		}
+		if err == io.EOF {
+			return text.String(), text.Len(), nil
+		}
+		if err != nil {
+			return "", text.Len(), err
+		}
+	}
+}
+
+// fill reads the start of a body the handler never read, e.g. because the
+// request was rejected, so it can still be logged
+func (b *RequestBody) fill() {
+	if b.read > 0 || b.buffered || b.logCap <= 0 {
+		return
+	}
+	// Read errors just leave less to log
+	prefix, _ := io.ReadAll(io.LimitReader(b.src, int64(b.logCap)))
+	b.prefix = prefix
+	b.read = len(prefix)
+}
+
+// logged returns the part of the body kept for the request log
+func (b *RequestBody) logged() string {
+	if b.buffered {
+		if len(b.text) > b.logCap {
+			return b.text[:b.logCap]
+		}
+		return b.text
+	}
+	return string(b.prefix)
+}
+
+// size returns the body size, as declared or as read by the handler
+func (b *RequestBody) size() int {
+	if int64(b.read) < b.declared {
+		return int(b.declared)
+	}
+	return b.read
+}
+
+// logText returns the logged body with a marker when it was cut short
+func (b *RequestBody) logText() string {
+	captured := b.logged()
+	if b.size() > len(captured) {
+		captured += "\n... [TRUNCATED]"
+	}
+	return captured
+}
+
+// metadata describes a body that is not logged: its type, size and, for
+// multipart forms, the fields and file names found in the captured prefix
+func (b *RequestBody) metadata(contentType string) map[string]interface{} {
+	info := map[string]interface{}{
+		"content_type": contentType,
+		"size":         b.size(),
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return info
+	}
+
+	var fields, files []string
+	reader := multipart.NewReader(bytes.NewReader(b.prefix), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break // End of form, or of the captured prefix
+		}
+		if filename := part.FileName(); filename != "" {
+			files = append(files, filename)
+		} else if name := part.FormName(); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) > 0 {
+		info["fields"] = fields
+	}
+	if len(files) > 0 {
+		info["filenames"] = files
+	}
+	return info
+}
@@ -3,16 +3,17 @@ package middleware
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/NamanArora/flash-gateway/internal/brownout"
+	"github.com/NamanArora/flash-gateway/internal/compression"
 	"github.com/NamanArora/flash-gateway/internal/storage"
 	"github.com/google/uuid"
 )
@@ -23,6 +24,9 @@ type CaptureMiddleware struct {
 	maxBodySize     int
 	sensitiveHeaders map[string]bool
 	skipHealthCheck bool
+	redactor        *BodyRedactor
+	brownout        *brownout.Controller
+	outbox          bool
 }
 
 // CaptureConfig holds configuration for the capture middleware
@@ -30,6 +34,7 @@ type CaptureConfig struct {
 	Writer           *storage.AsyncLogWriter
 	MaxBodySize      int    // Maximum body size to capture (bytes)
 	SkipHealthCheck  bool   // Skip logging for /health endpoint
+	Outbox           bool   // Write guardrail metrics in the same transaction as the log
 }
 
 // NewCaptureMiddleware creates a new capture middleware
@@ -44,6 +49,8 @@ func NewCaptureMiddleware(config CaptureConfig) *CaptureMiddleware {
 		"cookie":        true,
 		"x-auth-token":  true,
 		"bearer":        true,
+
+		"x-guardrail-bypass": true, // A signed bypass could be replayed from the logs
 	}
 
 	return &CaptureMiddleware{
@@ -51,9 +58,20 @@ func NewCaptureMiddleware(config CaptureConfig) *CaptureMiddleware {
 		maxBodySize:      config.MaxBodySize,
 		sensitiveHeaders: sensitiveHeaders,
 		skipHealthCheck:  config.SkipHealthCheck,
+		outbox:           config.Outbox,
 	}
 }
 
+// SetRedactor enables body redaction before logs are written
+func (c *CaptureMiddleware) SetRedactor(redactor *BodyRedactor) {
+	c.redactor = redactor
+}
+
+// SetBrownout lets body capture be shed while the gateway is browned out
+func (c *CaptureMiddleware) SetBrownout(controller *brownout.Controller) {
+	c.brownout = controller
+}
+
 // Capture wraps an HTTP handler to capture request/response data
 func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +82,7 @@ func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 		}
 
 		// Skip health check if configured
-		if c.skipHealthCheck && (r.URL.Path == "/health" || r.URL.Path == "/status") {
+		if c.skipHealthCheck && (r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/health/") || r.URL.Path == "/status") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -91,17 +109,19 @@ func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 		// Capture request headers (sanitized)
 		requestLog.RequestHeaders = c.captureHeaders(r.Header)
 
-		// Capture request body
-		var requestBody string
+		// Bodies are the expensive part of a log entry, drop them under brownout
+		maxBodySize := c.maxBodySize
+		bodiesShed := c.brownout.Disabled(brownout.FeatureBodyLogging)
+		if bodiesShed {
+			maxBodySize = 0
+		}
+
+		// Share the request body with the handler rather than copying it: the log keeps
+		// the start of whatever the handler buffers or streams, and binary uploads pass
+		// through byte for byte
+		var requestCapture *RequestBody
 		if r.Body != nil && (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") {
-			body, err := c.captureBody(r.Body, c.maxBodySize)
-			if err == nil {
-				requestBody = body
-				requestLog.RequestBody = &requestBody
-				
-				// Replace body with captured content
-				r.Body = io.NopCloser(strings.NewReader(requestBody))
-			}
+			requestCapture, r = newRequestBody(r, maxBodySize)
 		}
 
 		// Create response capture writer
@@ -109,15 +129,40 @@ func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 			ResponseWriter: w,
 			statusCode:     200,
 			body:          &bytes.Buffer{},
-			maxBodySize:   c.maxBodySize,
+			maxBodySize:   maxBodySize,
 		}
 
 		// Add request ID to context for guardrails
 		ctx := context.WithValue(r.Context(), "request_id", requestID)
+
+		// Handlers can attach extra fields to the log entry through AddLogMetadata
+		fields := &logFields{values: make(map[string]interface{})}
+		if seed, ok := r.Context().Value(logMetadataSeedKey).(map[string]interface{}); ok {
+			for key, value := range seed {
+				fields.values[key] = value
+			}
+		}
+		ctx = context.WithValue(ctx, logMetadataKey, fields)
+
+		// Guardrail metrics queued here are written with the log entry, never without it
+		var outbox *storage.Outbox
+		if c.outbox {
+			ctx, outbox = storage.WithOutbox(ctx)
+		}
 		r = r.WithContext(ctx)
 
-		// Process request
-		next.ServeHTTP(captureWriter, r)
+		// Clients quote the request ID to find its log or rate the response
+		w.Header().Set("X-Flash-Request-ID", requestID.String())
+
+		// Process request. A panic is logged with the request and then
+		// re-raised for Recovery to answer.
+		recovered := serveRecovering(next, captureWriter, r)
+		if recovered != nil {
+			defer panic(recovered)
+			if !captureWriter.wroteHeader && !captureWriter.wroteBody {
+				captureWriter.statusCode = http.StatusInternalServerError
+			}
+		}
 
 		// Calculate latency
 		latency := time.Since(start)
@@ -130,21 +175,45 @@ func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 		// Capture response headers
 		requestLog.ResponseHeaders = c.captureHeaders(captureWriter.Header())
 
+		// Textual request bodies are logged, anything else only by its metadata
+		requestSize := 0
+		var requestBodyInfo map[string]interface{}
+		if requestCapture != nil {
+			requestCapture.fill()
+			requestSize = requestCapture.size()
+			// Bodies the handler buffered are text to it, whatever their Content-Type
+			if requestCapture.buffered || IsTextContent(r.Header.Get("Content-Type")) {
+				if !bodiesShed {
+					loggedBody := requestCapture.logText()
+					// Only the logged copy is redacted, the provider gets the original
+					if c.redactor != nil {
+						loggedBody = c.redactor.RedactRequest(loggedBody)
+					}
+					requestLog.RequestBody = &loggedBody
+				}
+			} else {
+				requestBodyInfo = requestCapture.metadata(r.Header.Get("Content-Type"))
+			}
+		}
+
 		// Capture response body
 		if captureWriter.body.Len() > 0 {
 			responseBody := captureWriter.body.String()
-			log.Printf("[LOG] Response body 1: %v", responseBody)
 			
-			// Check if response is gzipped and decompress for logging
+			// Check if response is compressed and decompress for logging
 			contentEncoding := captureWriter.Header().Get("Content-Encoding")
-			if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
-				if decompressed, err := decompressGzip([]byte(responseBody)); err == nil {
+			if contentEncoding != "" {
+				if decompressed, err := compression.Decode(contentEncoding, captureWriter.body.Bytes()); err == nil {
 					responseBody = string(decompressed)
 				} else {
-					log.Printf("Warning: Failed to decompress gzipped response for logging: %v", err)
+					log.Printf("Warning: Failed to decompress response for logging: %v", err)
 				}
 			}
 			
+			if c.redactor != nil {
+				responseBody = c.redactor.RedactResponse(responseBody)
+			}
+			
 			requestLog.ResponseBody = &responseBody
 		}
 
@@ -155,16 +224,65 @@ func (c *CaptureMiddleware) Capture(next http.Handler) http.Handler {
 
 		// Add metadata
 		requestLog.Metadata = map[string]interface{}{
-			"request_size":  len(requestBody),
-			"response_size": captureWriter.body.Len(),
+			"request_size":  requestSize,
+			"response_size": captureWriter.size,
 			"content_type":  r.Header.Get("Content-Type"),
 		}
+		if keyID := APIKeyID(r); keyID != "" {
+			requestLog.Metadata["api_key_id"] = keyID
+		}
+		if requestBodyInfo != nil {
+			requestLog.Metadata["request_body"] = requestBodyInfo
+		}
+		if captureWriter.binary {
+			requestLog.Metadata["response_body"] = map[string]interface{}{
+				"content_type": captureWriter.Header().Get("Content-Type"),
+				"size":         captureWriter.size,
+			}
+		}
+		if bodiesShed {
+			requestLog.Metadata["bodies_shed"] = true
+		}
+		if recovered != nil {
+			message := recovered.Error()
+			requestLog.Error = &message
+			requestLog.Metadata["panic"] = map[string]interface{}{
+				"value": fmt.Sprint(recovered.Value),
+				"stack": string(recovered.Stack),
+			}
+		}
+		// Hedged attempts may still be adding fields, so copy them under the lock
+		logMetadata := fields.snapshot()
+		for key, value := range logMetadata {
+			requestLog.Metadata[key] = value
+		}
+		if conversationID, ok := logMetadata["conversation_id"].(string); ok {
+			requestLog.ConversationID = &conversationID
+		}
+		if tenantID, ok := logMetadata["tenant"].(string); ok {
+			requestLog.TenantID = &tenantID
+		}
+
+		if outbox != nil {
+			requestLog.GuardrailMetrics = outbox.GuardrailMetrics()
+		}
 
 		// Write log asynchronously
 		c.writer.WriteLog(requestLog)
 	})
 }
 
+// serveRecovering runs a handler, returning the panic it raised, if any
+func serveRecovering(next http.Handler, w http.ResponseWriter, r *http.Request) (recovered *Panic) {
+	defer func() {
+		if value := recover(); value != nil {
+			recovered = recoverPanic(value)
+		}
+	}()
+	next.ServeHTTP(w, r)
+	return nil
+}
+
 // captureHeaders captures and sanitizes HTTP headers
 func (c *CaptureMiddleware) captureHeaders(headers http.Header) map[string]interface{} {
 	captured := make(map[string]interface{})
@@ -187,28 +305,45 @@ func (c *CaptureMiddleware) captureHeaders(headers http.Header) map[string]inter
 	return captured
 }
 
-// captureBody captures request/response body with size limit
-func (c *CaptureMiddleware) captureBody(body io.ReadCloser, maxSize int) (string, error) {
-	defer body.Close()
-	
-	// Use LimitReader to prevent reading too much data
-	limitReader := io.LimitReader(body, int64(maxSize))
-	
-	buf := &bytes.Buffer{}
-	_, err := buf.ReadFrom(limitReader)
-	if err != nil {
-		return "", err
+// logMetadataKey is the context key for the fields of the request's log entry
+const logMetadataKey = "log_metadata"
+
+// logMetadataSeedKey is the context key for log fields set before capture runs
+const logMetadataSeedKey = "log_metadata_seed"
+
+// logFields holds the fields handlers attach to a request's log entry. They
+// may be added from goroutines other than the request's own, such as hedged
+// upstream attempts.
+type logFields struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// snapshot copies the fields added so far
+func (f *logFields) snapshot() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make(map[string]interface{}, len(f.values))
+	for key, value := range f.values {
+		values[key] = value
 	}
-	
-	captured := buf.String()
-	log.Printf("Extracted body: %v", captured)
-	
-	// Add truncation marker if we hit the limit
-	if buf.Len() >= maxSize {
-		captured += "\n... [TRUNCATED]"
+	return values
+}
+
+// AddLogMetadata attaches a field to the request log entry, when the request
+// is being captured. It is safe to call from any goroutine serving the request.
+func AddLogMetadata(ctx context.Context, key string, value interface{}) {
+	if fields, ok := ctx.Value(logMetadataKey).(*logFields); ok {
+		fields.mu.Lock()
+		fields.values[key] = value
+		fields.mu.Unlock()
 	}
-	
-	return captured, nil
+}
+
+// WithLogMetadata attaches fields to the log of a request the gateway makes
+// itself, such as a batch request. They override the fields capture derives.
+func WithLogMetadata(ctx context.Context, fields map[string]interface{}) context.Context {
+	return context.WithValue(ctx, logMetadataSeedKey, fields)
 }
 
 // extractSessionID extracts session ID from various headers
@@ -226,21 +361,43 @@ func extractSessionID(r *http.Request) string {
 		return sessionID
 	}
 	
-	// Try to extract from Authorization header (last part after space)
+	// Group by the Authorization token's fingerprint; no part of the token is stored
 	if auth := r.Header.Get("Authorization"); auth != "" {
 		parts := strings.Split(auth, " ")
 		if len(parts) > 1 {
-			// Use last 8 characters as session identifier (for grouping)
-			token := parts[len(parts)-1]
-			if len(token) > 8 {
-				return token[len(token)-8:]
-			}
+			return storage.APIKeyID(parts[len(parts)-1])
 		}
 	}
 	
 	return ""
 }
 
+// callerKeyContextKey is the context key for the key of a caller that
+// authenticated without an API key
+const callerKeyContextKey = "caller_key"
+
+// WithCallerKey identifies a caller that authenticated without an API key,
+// such as with a JWT, by a stable key APIKeyID fingerprints instead
+func WithCallerKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, callerKeyContextKey, key)
+}
+
+// APIKeyID fingerprints the caller's API key, so usage can be attributed to
+// a key without the key itself being stored. JWT callers are fingerprinted
+// by their identity, since their tokens are reissued. Caller keys are hashed
+// after a NUL byte, which header values can't carry, so no API key can pass
+// for a JWT identity.
+func APIKeyID(r *http.Request) string {
+	if key, ok := r.Context().Value(callerKeyContextKey).(string); ok && key != "" {
+		return storage.APIKeyID("\x00" + key)
+	}
+	key := r.Header.Get("x-api-key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return storage.APIKeyID(key)
+}
+
 // extractProvider determines the AI provider from the request path
 func extractProvider(path string) string {
 	if strings.HasPrefix(path, "/v1/") {
@@ -265,11 +422,16 @@ type captureResponseWriter struct {
 	statusCode  int
 	body        *bytes.Buffer
 	maxBodySize int
+	size        int  // Bytes written to the client, captured or not
+	wroteHeader bool // The status has been sent
+	wroteBody   bool // Content type has been checked
+	binary      bool // Non-textual response, only its metadata is logged
 }
 
 // WriteHeader captures the status code
 func (w *captureResponseWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
+	w.wroteHeader = true
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
@@ -277,7 +439,17 @@ func (w *captureResponseWriter) WriteHeader(statusCode int) {
 func (w *captureResponseWriter) Write(data []byte) (int, error) {
 	// Write to client first
 	n, err := w.ResponseWriter.Write(data)
-	
+	w.size += n
+
+	// Binary responses (e.g. generated audio) are not captured
+	if !w.wroteBody {
+		w.wroteBody = true
+		w.binary = !IsTextContent(w.Header().Get("Content-Type"))
+	}
+	if w.binary {
+		return n, err
+	}
+
 	// Capture response body if under size limit
 	if w.body.Len()+len(data) <= w.maxBodySize {
 		w.body.Write(data)
@@ -310,20 +482,3 @@ func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	}
 	return nil, nil, fmt.Errorf("hijacking not supported")
 }
-
-// decompressGzip decompresses gzip-compressed data for logging purposes
-func decompressGzip(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-	}
-	defer reader.Close()
-	
-	decompressed, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
-	}
-	
-	return decompressed, nil
-}
-
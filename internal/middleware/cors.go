@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// CORSMiddleware answers preflights and adds CORS headers using a global
+// policy, which endpoints may replace with their own
+type CORSMiddleware struct {
+	global    *corsPolicy
+	endpoints map[string]*corsPolicy // Path -> policy replacing the global one
+	paths     []string               // Keys of endpoints, for matching patterns
+	best      EndpointMatcher
+}
+
+// EndpointMatcher returns the most specific of endpoints, which may be
+// patterns, that matches a request path
+type EndpointMatcher func(endpoints []string, path string) (string, bool)
+
+// corsPolicy is a parsed config.CORSConfig
+type corsPolicy struct {
+	enabled       bool
+	anyOrigin     bool
+	origins       []string // Exact origins, or "scheme://*.domain" suffix patterns
+	methods       map[string]bool
+	allowMethods  string
+	anyHeader     bool
+	headers       map[string]bool // Lowercased
+	allowHeaders  string
+	exposeHeaders string
+	credentials   bool
+	maxAge        string
+}
+
+// NewCORS parses the global CORS policy and per-endpoint overrides, whose
+// paths are matched to requests by best
+func NewCORS(global config.CORSConfig, endpoints map[string]*config.CORSConfig, best EndpointMatcher) (*CORSMiddleware, error) {
+	c := &CORSMiddleware{endpoints: make(map[string]*corsPolicy, len(endpoints)), best: best}
+	var err error
+	if c.global, err = newCORSPolicy(global); err != nil {
+		return nil, err
+	}
+	for path, cfg := range endpoints {
+		if c.endpoints[path], err = newCORSPolicy(*cfg); err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", path, err)
+		}
+		c.paths = append(c.paths, path)
+	}
+	return c, nil
+}
+
+// policyFor returns the policy of the endpoint serving a path: its own, or
+// the most specific matching pattern's, or the global policy
+func (c *CORSMiddleware) policyFor(path string) *corsPolicy {
+	if policy, ok := c.endpoints[path]; ok {
+		return policy
+	}
+	if len(c.paths) > 0 {
+		if endpoint, ok := c.best(c.paths, path); ok {
+			return c.endpoints[endpoint]
+		}
+	}
+	return c.global
+}
+
+func newCORSPolicy(cfg config.CORSConfig) (*corsPolicy, error) {
+	p := &corsPolicy{
+		enabled:       cfg.Enabled,
+		methods:       make(map[string]bool, len(cfg.AllowedMethods)),
+		headers:       make(map[string]bool, len(cfg.AllowedHeaders)),
+		exposeHeaders: strings.Join(cfg.ExposedHeaders, ", "),
+		credentials:   cfg.AllowCredentials,
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		if strings.Count(origin, "*") > 1 || (strings.Contains(origin, "*") && !strings.Contains(origin, "://*.")) {
+			return nil, fmt.Errorf("invalid origin pattern %q, use e.g. https://*.example.com", origin)
+		}
+		p.origins = append(p.origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, method := range cfg.AllowedMethods {
+		method = strings.ToUpper(method)
+		p.methods[method] = true
+		methods = append(methods, method)
+	}
+	p.allowMethods = strings.Join(methods, ", ")
+
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[strings.ToLower(header)] = true
+	}
+	p.allowHeaders = strings.Join(cfg.AllowedHeaders, ", ")
+	return p, nil
+}
+
+// allowsOrigin reports whether an Origin header matches the policy
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.origins {
+		if allowed == origin {
+			return true
+		}
+		// "https://*.example.com" matches subdomains of example.com
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			scheme, suffix := allowed[:i], allowed[i+1:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handle applies the CORS policy of the requested endpoint. Preflights are
+// answered here and never proxied; when CORS is disabled they are refused.
+func (c *CORSMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := c.policyFor(r.URL.Path)
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !policy.enabled || origin == "" {
+			if preflight {
+				http.Error(w, "CORS is not enabled for this endpoint", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !policy.allowsOrigin(origin) {
+			if preflight {
+				http.Error(w, fmt.Sprintf("Origin %s is not allowed", origin), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r) // Without CORS headers, the browser withholds the response
+			return
+		}
+
+		// A wildcard can't be combined with credentials, so echo the origin instead
+		if policy.anyOrigin && !policy.credentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if policy.exposeHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", policy.exposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		if !policy.methods[method] {
+			http.Error(w, fmt.Sprintf("Method %s is not allowed by CORS policy", method), http.StatusForbidden)
+			return
+		}
+		requested := r.Header.Get("Access-Control-Request-Headers")
+		for _, header := range strings.Split(requested, ",") {
+			header = strings.ToLower(strings.TrimSpace(header))
+			if header != "" && !policy.anyHeader && !policy.headers[header] {
+				http.Error(w, fmt.Sprintf("Header %s is not allowed by CORS policy", header), http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", policy.allowMethods)
+		if policy.anyHeader && requested != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requested) // "*" isn't honored with credentials
+		} else if policy.allowHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", policy.allowHeaders)
+		}
+		if policy.maxAge != "" {
+			w.Header().Set("Access-Control-Max-Age", policy.maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -1,8 +1,13 @@
 package middleware
 
 import (
+	"bufio"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -50,36 +55,74 @@ func Logger(next http.Handler) http.Handler {
 	})
 }
 
-// CORS middleware handles Cross-Origin Resource Sharing
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
-		w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
-		
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		
-		next.ServeHTTP(w, r)
-	})
+// Panic is a panic recovered from a handler, with the stack it was raised on.
+// Capture re-raises panics as a Panic after logging them, so the stack still
+// points at the handler by the time Recovery answers the request.
+type Panic struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error describes the panic value
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
 }
 
-// Recovery middleware recovers from panics
+// recoverPanic converts a recovered value to a Panic, capturing the stack
+// unless it already was one. http.ErrAbortHandler is re-raised, as net/http
+// uses it to abort a response on purpose.
+func recoverPanic(value interface{}) *Panic {
+	if value == http.ErrAbortHandler {
+		panic(value)
+	}
+	if p, ok := value.(*Panic); ok {
+		return p
+	}
+	return &Panic{Value: value, Stack: debug.Stack()}
+}
+
+// panicsRecovered counts panics Recovery has answered
+var panicsRecovered uint64
+
+// PanicsRecovered returns how many handler panics have been recovered
+func PanicsRecovered() uint64 {
+	return atomic.LoadUint64(&panicsRecovered)
+}
+
+// Recovery middleware recovers from panics, logging them with their stack
+// and request, and answers with a 500 in the OpenAI error format. A response
+// already under way can't be replaced, so its connection is aborted instead.
 func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapper := &responseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
 		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			value := recover()
+			if value == nil {
+				return
+			}
+			p := recoverPanic(value)
+			atomic.AddUint64(&panicsRecovered, 1)
+
+			// Capture sets the request ID header before handing the request on
+			requestID := w.Header().Get("X-Flash-Request-ID")
+			if requestID == "" {
+				requestID = "-"
 			}
+			log.Printf("Panic recovered: %v (request_id=%s endpoint=%s %s)\n%s", p.Value, requestID, r.Method, r.URL.Path, p.Stack)
+
+			if wrapper.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			ResetEntityHeaders(w.Header())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"Internal server error","type":"server_error","param":null,"code":"internal_error"}}` + "\n"))
 		}()
 		
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(wrapper, r)
 	})
 }
 
@@ -125,11 +168,36 @@ func Chain(middlewares ...func(http.Handler) http.Handler) func(http.Handler) ht
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
 }
 
 // WriteHeader captures the status code
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
+	rw.wroteHeader = true
 	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write notes that the response has started
+func (rw *responseWriter) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter supports it
+func (rw *responseWriter) Flush() {
+	rw.wroteHeader = true
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, so upgraded connections can be taken over
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.wroteHeader = true
+	if hijacker, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijacking not supported")
 }
\ No newline at end of file
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// Recorder buffers the response to a request the gateway makes on a client's
+// behalf, such as a replay or a batch request
+type Recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+// NewRecorder creates an empty response recorder
+func NewRecorder() *Recorder {
+	return &Recorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (r *Recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status = status
+		r.wrote = true
+	}
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.wrote = true
+	return r.body.Write(p)
+}
+
+// Flush lets streamed responses be recorded; they are collected whole
+func (r *Recorder) Flush() {}
+
+// Status returns the response status code
+func (r *Recorder) Status() int {
+	return r.status
+}
+
+// Body returns the response body
+func (r *Recorder) Body() []byte {
+	return r.body.Bytes()
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+const redactedValue = "[REDACTED]"
+
+// BodyRedactor applies field-based redaction rules to JSON bodies before they are logged
+type BodyRedactor struct {
+	requestRules  []redactionRule
+	responseRules []redactionRule
+}
+
+// redactionRule is a parsed config.RedactionRule
+type redactionRule struct {
+	segments []pathSegment
+	remove   bool
+}
+
+// pathSegment is one dot-separated element of a rule path
+type pathSegment struct {
+	key     string // Object key, "*" matches every key
+	iterate bool   // Key is followed by [] and holds an array to descend into
+}
+
+// NewBodyRedactor parses redaction rules from configuration
+func NewBodyRedactor(rules []config.RedactionRule) (*BodyRedactor, error) {
+	redactor := &BodyRedactor{}
+
+	for _, rule := range rules {
+		segments, err := parseRedactionPath(rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction path %q: %w", rule.Path, err)
+		}
+
+		var remove bool
+		switch strings.ToLower(rule.Action) {
+		case "", "mask":
+			remove = false
+		case "remove":
+			remove = true
+		default:
+			return nil, fmt.Errorf("unknown redaction action %q for path %q", rule.Action, rule.Path)
+		}
+
+		parsed := redactionRule{segments: segments, remove: remove}
+		switch strings.ToLower(rule.Target) {
+		case "", "both":
+			redactor.requestRules = append(redactor.requestRules, parsed)
+			redactor.responseRules = append(redactor.responseRules, parsed)
+		case "request":
+			redactor.requestRules = append(redactor.requestRules, parsed)
+		case "response":
+			redactor.responseRules = append(redactor.responseRules, parsed)
+		default:
+			return nil, fmt.Errorf("unknown redaction target %q for path %q", rule.Target, rule.Path)
+		}
+	}
+
+	return redactor, nil
+}
+
+// RedactRequest applies request rules to a captured request body
+func (b *BodyRedactor) RedactRequest(body string) string {
+	return redactBody(body, b.requestRules)
+}
+
+// RedactResponse applies response rules to a captured response body
+func (b *BodyRedactor) RedactResponse(body string) string {
+	return redactBody(body, b.responseRules)
+}
+
+// redactBody rewrites a JSON body with the given rules applied.
+// Bodies that cannot be parsed (truncated or non-JSON) are replaced entirely,
+// since we can't prove the sensitive fields aren't present.
+func redactBody(body string, rules []redactionRule) string {
+	if len(rules) == 0 || body == "" {
+		return body
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber() // Keep numeric values exactly as sent
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return redactedValue
+	}
+
+	for _, rule := range rules {
+		document = applyRedaction(document, rule.segments, rule.remove)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return redactedValue
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// applyRedaction walks the document along the path and masks or removes the final field
+func applyRedaction(node interface{}, segments []pathSegment, remove bool) interface{} {
+	if len(segments) == 0 {
+		return node
+	}
+
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+
+	segment := segments[0]
+	keys := []string{segment.key}
+	if segment.key == "*" {
+		keys = keys[:0]
+		for key := range object {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		child, exists := object[key]
+		if !exists {
+			continue
+		}
+
+		if segment.iterate {
+			items, ok := child.([]interface{})
+			if !ok {
+				continue
+			}
+			if len(segments) == 1 {
+				// Path ends at the array itself - redact each element
+				for i := range items {
+					items[i] = redactedValue
+				}
+				if remove {
+					delete(object, key)
+				}
+				continue
+			}
+			for i := range items {
+				items[i] = applyRedaction(items[i], segments[1:], remove)
+			}
+			continue
+		}
+
+		if len(segments) == 1 {
+			if remove {
+				delete(object, key)
+			} else {
+				object[key] = redactedValue
+			}
+			continue
+		}
+		object[key] = applyRedaction(child, segments[1:], remove)
+	}
+
+	return object
+}
+
+// parseRedactionPath parses paths such as "messages[].content" or "$.user"
+func parseRedactionPath(path string) ([]pathSegment, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+	if path == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	parts := strings.Split(path, ".")
+	segments := make([]pathSegment, 0, len(parts))
+	for _, part := range parts {
+		segment := pathSegment{key: part}
+		if strings.HasSuffix(part, "[]") || strings.HasSuffix(part, "[*]") {
+			segment.iterate = true
+			segment.key = part[:strings.Index(part, "[")]
+		}
+		if segment.key == "" || strings.ContainsAny(segment.key, "[]") {
+			return nil, fmt.Errorf("invalid segment %q", part)
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// registered is a middleware added by an extension, in registration order
+type registered struct {
+	name    string
+	handler func(http.Handler) http.Handler
+}
+
+var (
+	extensions   []registered
+	extensionsMu sync.RWMutex
+)
+
+// Register adds a middleware to every proxied request. Registered middleware
+// runs in registration order where a listener's chain lists "plugins", by
+// default after the built-in CORS and content type middleware and before
+// request capture. This should be called during application
+// initialization, before the router builds its handler.
+func Register(name string, handler func(http.Handler) http.Handler) {
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+
+	if handler == nil {
+		panic(fmt.Sprintf("middleware %s is nil", name))
+	}
+	for _, ext := range extensions {
+		if ext.name == name {
+			panic(fmt.Sprintf("middleware %s registered twice", name))
+		}
+	}
+	extensions = append(extensions, registered{name: name, handler: handler})
+}
+
+// Registered returns the registered middleware in registration order
+func Registered() []func(http.Handler) http.Handler {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+
+	handlers := make([]func(http.Handler) http.Handler, len(extensions))
+	for i, ext := range extensions {
+		handlers[i] = ext.handler
+	}
+	return handlers
+}
@@ -0,0 +1,173 @@
+// Package models answers GET /v1/models from configuration, so SDK model
+// discovery sees every model the gateway can reach rather than only those of
+// the provider /v1/models happens to route to.
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/respond"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+)
+
+// Endpoint lists models; GET Endpoint/{id} retrieves one
+const Endpoint = "/v1/models"
+
+// aliasOwner is reported as the owner of model aliases
+const aliasOwner = "flash-gateway"
+
+// Model is a model list entry in the OpenAI format
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// entry is a model in a response: configured, or as the upstream listed it
+type entry struct {
+	id    string
+	value interface{}
+}
+
+// configured is a model from configuration and the provider serving it,
+// empty for aliases
+type configured struct {
+	model    Model
+	provider string
+}
+
+// Catalog serves the model list
+type Catalog struct {
+	models   []configured
+	upstream http.Handler // Proxies to the provider /v1/models routes to; nil unless merging
+}
+
+// New builds the catalog from providers' models and, when enabled, model
+// aliases. A non-nil upstream handler adds the routed provider's own list.
+func New(cfg *config.Config, upstream http.Handler) *Catalog {
+	c := &Catalog{upstream: upstream}
+	created := time.Now().Unix()
+	seen := make(map[string]bool)
+
+	for _, provider := range cfg.Providers {
+		for _, id := range provider.Models {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			c.models = append(c.models, configured{
+				model:    Model{ID: id, Object: "model", Created: created, OwnedBy: provider.Name},
+				provider: provider.Name,
+			})
+		}
+	}
+
+	if cfg.Models.Aliases {
+		aliases := make([]string, 0, len(cfg.ModelAliases))
+		for alias := range cfg.ModelAliases {
+			if !seen[alias] {
+				aliases = append(aliases, alias)
+			}
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			c.models = append(c.models, configured{
+				model: Model{ID: alias, Object: "model", Created: created, OwnedBy: aliasOwner},
+			})
+		}
+	}
+
+	return c
+}
+
+// ServeHTTP serves GET /v1/models and GET /v1/models/{id}
+func (c *Catalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		respond.Error(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed", r.Method), "method_not_allowed")
+		return
+	}
+
+	entries := c.list(r)
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, Endpoint), "/")
+	if id == "" {
+		data := make([]interface{}, len(entries))
+		for i, e := range entries {
+			data[i] = e.value
+		}
+		respond.JSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+		return
+	}
+
+	for _, e := range entries {
+		if e.id == id {
+			respond.JSON(w, http.StatusOK, e.value)
+			return
+		}
+	}
+	respond.Error(w, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", id), "model_not_found")
+}
+
+// list returns the models the caller can use: configured ones, minus those
+// of providers the caller's tenant may not use, then any upstream adds
+func (c *Catalog) list(r *http.Request) []entry {
+	requestTenant := tenant.FromContext(r.Context())
+	entries := make([]entry, 0, len(c.models))
+	seen := make(map[string]bool, len(c.models))
+	for _, m := range c.models {
+		if m.provider != "" && requestTenant != nil && !requestTenant.AllowsProvider(m.provider) {
+			continue
+		}
+		seen[m.model.ID] = true
+		entries = append(entries, entry{id: m.model.ID, value: m.model})
+	}
+
+	for _, raw := range c.fetchUpstream(r) {
+		var model struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(raw, &model) != nil || model.ID == "" || seen[model.ID] {
+			continue
+		}
+		seen[model.ID] = true
+		entries = append(entries, entry{id: model.ID, value: raw})
+	}
+	return entries
+}
+
+// fetchUpstream asks the provider /v1/models routes to for its list, with
+// the caller's credentials. Failures leave the list to configuration.
+func (c *Catalog) fetchUpstream(r *http.Request) []json.RawMessage {
+	if c.upstream == nil {
+		return nil
+	}
+
+	req := r.Clone(r.Context())
+	req.URL.Path = Endpoint
+	req.URL.RawQuery = ""
+	req.Header.Del("Accept-Encoding") // The list is decoded here
+	recorder := middleware.NewRecorder()
+	c.upstream.ServeHTTP(recorder, req)
+	if recorder.Status() != http.StatusOK {
+		log.Printf("Upstream model list failed with status %d, serving configured models only", recorder.Status())
+		return nil
+	}
+
+	var list struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body(), &list); err != nil {
+		log.Printf("Invalid upstream model list: %v", err)
+		return nil
+	}
+	return list.Data
+}
@@ -0,0 +1,406 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// keysMaxAge is how long fetched signing keys are used before refetching
+	keysMaxAge = time.Hour
+	// refetchInterval limits refetches triggered by tokens with unknown key IDs
+	refetchInterval = time.Minute
+	// clockSkew is allowed between the issuer's clock and ours
+	clockSkew = time.Minute
+)
+
+// ErrInvalidToken is returned for tokens that fail verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the payload of a verified token
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" when it is missing or not a string
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns a claim that may be a single string or a list of strings
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Verifier checks JWTs signed by an OIDC issuer against its published keys.
+// RS256/384/512 and ES256/384/512 signatures are supported.
+type Verifier struct {
+	issuer   string
+	audience string
+	jwksURL  string
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // Key ID -> key
+	fetchedAt time.Time
+	fetching  singleflight.Group // One key fetch at a time, shared by waiting requests
+}
+
+// NewVerifier creates a verifier from configuration. Keys are fetched on
+// first use, from jwks_url or the issuer's discovery document.
+func NewVerifier(cfg config.OIDCConfig) (*Verifier, error) {
+	if cfg.Issuer == "" && cfg.JWKSURL == "" {
+		return nil, fmt.Errorf("issuer or jwks_url is required")
+	}
+	if cfg.Audience == "" {
+		// Without an audience any token from the issuer is accepted, including
+		// ones minted for other applications
+		return nil, fmt.Errorf("audience is required")
+	}
+	return &Verifier{
+		issuer:   strings.TrimSuffix(cfg.Issuer, "/"),
+		audience: cfg.Audience,
+		jwksURL:  cfg.JWKSURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// LooksLikeJWT reports whether a bearer token has the shape of a JWT, so
+// callers can tell them apart from opaque keys without verifying
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// Verify checks a token's signature, issuer, audience and validity period
+// and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.validate(claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims
+func (v *Verifier) validate(claims Claims, now time.Time) error {
+	if v.issuer != "" && strings.TrimSuffix(claims.String("iss"), "/") != v.issuer {
+		return fmt.Errorf("issuer %q not accepted", claims.String("iss"))
+	}
+	found := false
+	for _, aud := range claims.Strings("aud") {
+		if aud == v.audience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("audience not accepted")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("exp claim is required")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not yet valid")
+	}
+	return nil
+}
+
+// key returns the signing key with the given ID, fetching keys when they are
+// stale or the ID is unknown (e.g. after the issuer rotated keys). The fetch
+// runs without holding the lock so a slow issuer doesn't stall verification
+// of tokens whose keys are already cached, and concurrent requests share one
+// fetch so tokens with unknown key IDs can't flood the issuer.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := lookupKey(v.keys, kid)
+	age := time.Since(v.fetchedAt)
+	cached := v.keys != nil
+	v.mu.Unlock()
+
+	if ok && age < keysMaxAge {
+		return key, nil
+	}
+	if !ok && cached && age < refetchInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	keys, err := v.refreshKeys(ctx)
+	if err != nil {
+		if ok {
+			return key, nil // Keep using the cached key while the issuer is unreachable
+		}
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+
+	if key, ok = lookupKey(keys, kid); !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the issuer's keys and caches them. Requests arriving
+// while a fetch is running wait for its result instead of starting another.
+func (v *Verifier) refreshKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	result := v.fetching.DoChan("keys", func() (interface{}, error) {
+		// Not bound to the first caller's context, since others share the result;
+		// the HTTP client's timeout bounds it
+		keys, err := v.fetchKeys(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.keys = keys
+		v.fetchedAt = time.Now()
+		v.mu.Unlock()
+		return keys, nil
+	})
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[string]crypto.PublicKey), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookupKey finds a key by ID. Issuers with a single key may leave the ID out
+// of their tokens.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys downloads and parses the issuer's JSON Web Key Set
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := v.jwksURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue // Skip key types we can't use
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys at %s", jwksURL)
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jwk is one entry of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks a signature made with alg by the given key
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match EC key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key")
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
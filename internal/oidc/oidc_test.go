@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	v := &Verifier{issuer: "https://issuer.example.com", audience: "gateway"}
+	now := time.Unix(1700000000, 0)
+	exp := float64(now.Add(time.Hour).Unix())
+
+	tests := []struct {
+		name    string
+		claims  Claims
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			claims: Claims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": exp},
+		},
+		{
+			name:   "issuer with trailing slash",
+			claims: Claims{"iss": "https://issuer.example.com/", "aud": "gateway", "exp": exp},
+		},
+		{
+			name:   "audience in a list",
+			claims: Claims{"iss": "https://issuer.example.com", "aud": []interface{}{"other", "gateway"}, "exp": exp},
+		},
+		{
+			name:    "wrong issuer",
+			claims:  Claims{"iss": "https://evil.example.com", "aud": "gateway", "exp": exp},
+			wantErr: "issuer",
+		},
+		{
+			name:    "missing issuer",
+			claims:  Claims{"aud": "gateway", "exp": exp},
+			wantErr: "issuer",
+		},
+		{
+			name:    "wrong audience",
+			claims:  Claims{"iss": "https://issuer.example.com", "aud": "other", "exp": exp},
+			wantErr: "audience",
+		},
+		{
+			name:    "missing audience",
+			claims:  Claims{"iss": "https://issuer.example.com", "exp": exp},
+			wantErr: "audience",
+		},
+		{
+			name:    "missing exp",
+			claims:  Claims{"iss": "https://issuer.example.com", "aud": "gateway"},
+			wantErr: "exp claim is required",
+		},
+		{
+			name:    "expired",
+			claims:  Claims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": float64(now.Add(-2 * clockSkew).Unix())},
+			wantErr: "expired",
+		},
+		{
+			name:   "expired within clock skew",
+			claims: Claims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": float64(now.Add(-clockSkew / 2).Unix())},
+		},
+		{
+			name:    "not yet valid",
+			claims:  Claims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": exp, "nbf": float64(now.Add(2 * clockSkew).Unix())},
+			wantErr: "not yet valid",
+		},
+		{
+			name:   "not yet valid within clock skew",
+			claims: Claims{"iss": "https://issuer.example.com", "aud": "gateway", "exp": exp, "nbf": float64(now.Add(clockSkew / 2).Unix())},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.validate(tt.claims, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const signed = "header.payload"
+	digest := sha256.Sum256([]byte(signed))
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSignature := make([]byte, 64)
+	r.FillBytes(ecSignature[:32])
+	s.FillBytes(ecSignature[32:])
+
+	tests := []struct {
+		name      string
+		alg       string
+		key       crypto.PublicKey
+		signed    string
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "RS256", alg: "RS256", key: &rsaKey.PublicKey, signed: signed, signature: rsaSignature},
+		{name: "ES256", alg: "ES256", key: &ecKey.PublicKey, signed: signed, signature: ecSignature},
+		{name: "RSA signed content changed", alg: "RS256", key: &rsaKey.PublicKey, signed: signed + "x", signature: rsaSignature, wantErr: true},
+		{name: "EC signed content changed", alg: "ES256", key: &ecKey.PublicKey, signed: signed + "x", signature: ecSignature, wantErr: true},
+		{name: "RSA wrong key", alg: "RS256", key: &otherRSAKey.PublicKey, signed: signed, signature: rsaSignature, wantErr: true},
+		{name: "RSA hash mismatch", alg: "RS384", key: &rsaKey.PublicKey, signed: signed, signature: rsaSignature, wantErr: true},
+		{name: "EC algorithm with RSA key", alg: "ES256", key: &rsaKey.PublicKey, signed: signed, signature: rsaSignature, wantErr: true},
+		{name: "RSA algorithm with EC key", alg: "RS256", key: &ecKey.PublicKey, signed: signed, signature: ecSignature, wantErr: true},
+		{name: "EC signature truncated", alg: "ES256", key: &ecKey.PublicKey, signed: signed, signature: ecSignature[:63], wantErr: true},
+		{name: "none", alg: "none", key: &rsaKey.PublicKey, signed: signed, signature: nil, wantErr: true},
+		{name: "HMAC", alg: "HS256", key: &rsaKey.PublicKey, signed: signed, signature: rsaSignature, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.alg, tt.key, tt.signed, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignature() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol used by Parquet metadata
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int16 // Last field id written, per open struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	t.buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// zigzag encodes a signed integer as compact protocol varints expect
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// structBegin opens a struct, either top level or as a list element
+func (t *thriftWriter) structBegin() {
+	t.fields = append(t.fields, 0)
+}
+
+// structEnd writes the stop byte closing the current struct
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+// fieldHeader writes a field id, as a delta from the previous one when it fits
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.fields[len(t.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+// i32 writes a bare i32, as a list element
+func (t *thriftWriter) i32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) stringField(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary([]byte(v))
+}
+
+func (t *thriftWriter) binary(v []byte) {
+	t.varint(uint64(len(v)))
+	t.buf.Write(v)
+}
+
+// structField opens a struct-valued field, closed with structEnd
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.structBegin()
+}
+
+// listField writes a list header; the caller then writes size elements
+func (t *thriftWriter) listField(id int16, elem byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xF0 | elem)
+		t.varint(uint64(size))
+	}
+}
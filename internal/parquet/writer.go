@@ -0,0 +1,304 @@
+// Package parquet writes flat tables as Apache Parquet files. It supports
+// what log exports need and no more: optional string, integer, float and
+// timestamp columns, PLAIN encoding, no compression, one page per column chunk.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// maxRowGroupBytes flushes a row group early once its values grow this large
+const maxRowGroupBytes = 64 << 20
+
+// Type is a column's logical type
+type Type int
+
+const (
+	// String is UTF-8 text, written as BYTE_ARRAY
+	String Type = iota
+	// Int64 is a signed 64-bit integer
+	Int64
+	// Double is a 64-bit float
+	Double
+	// Timestamp is a time, written as INT64 milliseconds since the Unix epoch
+	Timestamp
+)
+
+// Parquet physical and converted types, and other format enums
+const (
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+// Column describes one column of the table
+type Column struct {
+	Name string
+	Type Type
+}
+
+// column buffers one column's values for the current row group
+type column struct {
+	Column
+	defined []bool // Definition level per row: false for null
+	values  bytes.Buffer
+}
+
+// chunkMeta is what the footer records about a written column chunk
+type chunkMeta struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// rowGroupMeta is what the footer records about a written row group
+type rowGroupMeta struct {
+	rows   int64
+	size   int64
+	chunks []chunkMeta
+}
+
+// Writer streams rows to a Parquet file. Rows are buffered into row groups,
+// which are written as they fill; the footer is written by Close.
+type Writer struct {
+	out          io.Writer
+	offset       int64
+	columns      []*column
+	rowGroupSize int
+	rows         int
+	bufferedSize int
+	rowGroups    []rowGroupMeta
+	totalRows    int64
+}
+
+// NewWriter starts a Parquet file on out. Row groups hold up to rowGroupSize rows.
+func NewWriter(out io.Writer, columns []Column, rowGroupSize int) (*Writer, error) {
+	if rowGroupSize <= 0 {
+		rowGroupSize = 10000
+	}
+	w := &Writer{out: out, rowGroupSize: rowGroupSize}
+	for _, c := range columns {
+		w.columns = append(w.columns, &column{Column: c})
+	}
+	if err := w.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.out.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// Write appends a row. Values must match the column types: string, int64,
+// float64 or time.Time, or nil for null.
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("row has %d values, table has %d columns", len(row), len(w.columns))
+	}
+	for i, value := range row {
+		c := w.columns[i]
+		if value == nil {
+			c.defined = append(c.defined, false)
+			continue
+		}
+		before := c.values.Len()
+		switch v := value.(type) {
+		case string:
+			if c.Type != String {
+				return fmt.Errorf("column %s: got string", c.Name)
+			}
+			binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+			c.values.WriteString(v)
+		case int64:
+			if c.Type != Int64 {
+				return fmt.Errorf("column %s: got int64", c.Name)
+			}
+			binary.Write(&c.values, binary.LittleEndian, v)
+		case float64:
+			if c.Type != Double {
+				return fmt.Errorf("column %s: got float64", c.Name)
+			}
+			binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+		case time.Time:
+			if c.Type != Timestamp {
+				return fmt.Errorf("column %s: got time.Time", c.Name)
+			}
+			binary.Write(&c.values, binary.LittleEndian, v.UnixMilli())
+		default:
+			return fmt.Errorf("column %s: unsupported value type %T", c.Name, value)
+		}
+		c.defined = append(c.defined, true)
+		w.bufferedSize += c.values.Len() - before
+	}
+
+	w.rows++
+	if w.rows >= w.rowGroupSize || w.bufferedSize >= maxRowGroupBytes {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+// flushRowGroup writes the buffered rows as a row group
+func (w *Writer) flushRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+	group := rowGroupMeta{rows: int64(w.rows)}
+	for _, c := range w.columns {
+		levels := encodeLevels(c.defined)
+		var page bytes.Buffer
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+		page.Write(c.values.Bytes())
+
+		var header thriftWriter
+		header.structBegin()
+		header.i32Field(1, pageTypeData)
+		header.i32Field(2, int32(page.Len()))
+		header.i32Field(3, int32(page.Len()))
+		header.structField(5)
+		header.i32Field(1, int32(len(c.defined)))
+		header.i32Field(2, encodingPlain)
+		header.i32Field(3, encodingRLE)
+		header.i32Field(4, encodingRLE)
+		header.structEnd()
+		header.structEnd()
+
+		chunk := chunkMeta{offset: w.offset, numValues: int64(len(c.defined))}
+		if err := w.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := w.write(page.Bytes()); err != nil {
+			return err
+		}
+		chunk.size = w.offset - chunk.offset
+		group.size += chunk.size
+		group.chunks = append(group.chunks, chunk)
+
+		c.defined = c.defined[:0]
+		c.values.Reset()
+	}
+	w.rowGroups = append(w.rowGroups, group)
+	w.totalRows += group.rows
+	w.rows, w.bufferedSize = 0, 0
+	return nil
+}
+
+// encodeLevels encodes definition levels (bit width 1) as RLE runs
+func encodeLevels(defined []bool) []byte {
+	var out []byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1) // Low bit 0 marks an RLE run
+		if defined[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// Close writes any buffered rows and the file footer
+func (w *Writer) Close() error {
+	if err := w.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.structBegin()
+	meta.i32Field(1, 1) // Format version
+	meta.listField(2, thriftStruct, len(w.columns)+1)
+	meta.structBegin()
+	meta.stringField(4, "schema")
+	meta.i32Field(5, int32(len(w.columns)))
+	meta.structEnd()
+	for _, c := range w.columns {
+		meta.structBegin()
+		meta.i32Field(1, physicalType(c.Type))
+		meta.i32Field(3, repetitionOptional)
+		meta.stringField(4, c.Name)
+		switch c.Type {
+		case String:
+			meta.i32Field(6, convertedUTF8)
+		case Timestamp:
+			meta.i32Field(6, convertedTimestampMillis)
+		}
+		meta.structEnd()
+	}
+	meta.i64Field(3, w.totalRows)
+	meta.listField(4, thriftStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		meta.structBegin()
+		meta.listField(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			c := w.columns[i]
+			meta.structBegin()
+			meta.i64Field(2, chunk.offset)
+			meta.structField(3)
+			meta.i32Field(1, physicalType(c.Type))
+			meta.listField(2, thriftI32, 2)
+			meta.i32(encodingPlain)
+			meta.i32(encodingRLE)
+			meta.listField(3, thriftBinary, 1)
+			meta.binary([]byte(c.Name))
+			meta.i32Field(4, codecUncompressed)
+			meta.i64Field(5, chunk.numValues)
+			meta.i64Field(6, chunk.size)
+			meta.i64Field(7, chunk.size)
+			meta.i64Field(9, chunk.offset)
+			meta.structEnd()
+			meta.structEnd()
+		}
+		meta.i64Field(2, group.size)
+		meta.i64Field(3, group.rows)
+		meta.structEnd()
+	}
+	meta.stringField(6, "flash-gateway")
+	meta.structEnd()
+
+	footer := meta.buf.Bytes()
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if err := w.write(length[:]); err != nil {
+		return err
+	}
+	return w.write([]byte(magic))
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Int64, Timestamp:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	}
+	return physicalByteArray
+}
@@ -0,0 +1,122 @@
+package promptcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Breakpoints Inject may add
+const (
+	BreakpointTools        = "tools"
+	BreakpointSystem       = "system"
+	BreakpointConversation = "conversation"
+)
+
+// rule is a compiled injection rule
+type rule struct {
+	name         string
+	filter       guardrails.Applicability
+	system       bool
+	tools        bool
+	conversation bool
+	minChars     int
+	control      map[string]interface{} // The cache_control value set on each breakpoint
+}
+
+// Inject adds cache_control breakpoints to an Anthropic Messages request, as
+// the first rule matching it asks. Requests that already set cache_control
+// anywhere are left alone, since the client is managing its own cache.
+// Returns the body and the breakpoints added.
+func (t *Tracker) Inject(scope guardrails.Scope, body string) (string, []string) {
+	if !strings.HasSuffix(scope.Endpoint, "/messages") || strings.Contains(body, `"cache_control"`) {
+		return body, nil
+	}
+	for _, r := range t.rules {
+		if !r.filter.Matches(scope) {
+			continue
+		}
+		if len(body) < r.minChars {
+			return body, nil
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(body))
+		decoder.UseNumber()
+		var payload map[string]interface{}
+		if err := decoder.Decode(&payload); err != nil || payload == nil {
+			return body, nil
+		}
+
+		// Anthropic caches the prefix tools, then system, then messages, so
+		// breakpoints are added in that order
+		var added []string
+		if r.tools {
+			if tools, ok := payload["tools"].([]interface{}); ok && markLast(tools, r.control) {
+				added = append(added, BreakpointTools)
+			}
+		}
+		if r.system {
+			if system, ok := markContent(payload["system"], r.control); ok {
+				payload["system"] = system
+				added = append(added, BreakpointSystem)
+			}
+		}
+		if r.conversation {
+			if messages, ok := payload["messages"].([]interface{}); ok && len(messages) > 0 {
+				if message, ok := messages[len(messages)-1].(map[string]interface{}); ok {
+					if content, ok := markContent(message["content"], r.control); ok {
+						message["content"] = content
+						added = append(added, BreakpointConversation)
+					}
+				}
+			}
+		}
+		if len(added) == 0 {
+			return body, nil
+		}
+
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(payload); err != nil {
+			return body, nil
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), added
+	}
+	return body, nil
+}
+
+// markContent sets cache_control on the last block of a system prompt or
+// message content. String content becomes a single text block, since only
+// blocks can carry cache_control.
+func markContent(content interface{}, control map[string]interface{}) (interface{}, bool) {
+	switch content := content.(type) {
+	case string:
+		if content == "" {
+			return nil, false
+		}
+		return []interface{}{map[string]interface{}{
+			"type":          "text",
+			"text":          content,
+			"cache_control": control,
+		}}, true
+	case []interface{}:
+		return content, markLast(content, control)
+	}
+	return nil, false
+}
+
+// markLast sets cache_control on the last of a list of blocks or tools
+func markLast(blocks []interface{}, control map[string]interface{}) bool {
+	if len(blocks) == 0 {
+		return false
+	}
+	last, ok := blocks[len(blocks)-1].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	last["cache_control"] = control
+	return true
+}
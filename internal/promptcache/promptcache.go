@@ -0,0 +1,165 @@
+package promptcache
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/cost"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Header carries a non-streamed response's cached prompt tokens when response_header is set
+const Header = "X-Flash-Cached-Tokens"
+
+// maxModels bounds how many models hit rates are kept for
+const maxModels = 1000
+
+// Request is one request's prompt cache usage, recorded in its log metadata
+type Request struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	CacheWriteTokens int     `json:"cache_write_tokens,omitempty"`
+	HitRatio         float64 `json:"hit_ratio"` // Share of prompt tokens read from the cache
+}
+
+// counts accumulates prompt cache usage for one model
+type counts struct {
+	requests         int64
+	hits             int64 // Requests that read anything from the cache
+	promptTokens     int64
+	cachedTokens     int64
+	cacheWriteTokens int64
+}
+
+// Tracker records provider prompt cache usage per model and marks Anthropic
+// Messages requests for caching
+type Tracker struct {
+	responseHeader bool
+	rules          []rule
+
+	mu     sync.Mutex
+	models map[string]*counts
+}
+
+// New creates a tracker, compiling its injection rules
+func New(cfg config.PromptCacheConfig) (*Tracker, error) {
+	t := &Tracker{
+		responseHeader: cfg.ResponseHeader,
+		models:         make(map[string]*counts),
+	}
+	for i, c := range cfg.Inject {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("inject_%d", i)
+		}
+		if !c.System && !c.Tools && !c.Conversation {
+			return nil, fmt.Errorf("rule %s: set at least one of system, tools or conversation", name)
+		}
+		control := map[string]interface{}{"type": "ephemeral"}
+		switch c.TTL {
+		case "", "5m":
+		case "1h":
+			control["ttl"] = c.TTL
+		default:
+			return nil, fmt.Errorf("rule %s: ttl must be 5m or 1h, got %q", name, c.TTL)
+		}
+		t.rules = append(t.rules, rule{
+			name: name,
+			filter: guardrails.Applicability{
+				Endpoints: c.Endpoints,
+				Providers: c.Providers,
+				Models:    c.Models,
+			},
+			system:       c.System,
+			tools:        c.Tools,
+			conversation: c.Conversation,
+			minChars:     c.MinChars,
+			control:      control,
+		})
+	}
+	return t, nil
+}
+
+// ResponseHeaderEnabled reports whether the X-Flash-Cached-Tokens header should be emitted
+func (t *Tracker) ResponseHeaderEnabled() bool {
+	return t.responseHeader
+}
+
+// Observe records a request's usage against its model and returns the
+// request's cache usage. Responses without prompt tokens aren't counted.
+func (t *Tracker) Observe(usage *cost.Usage) (Request, bool) {
+	if usage == nil || usage.PromptTokens <= 0 {
+		return Request{}, false
+	}
+	request := Request{
+		PromptTokens:     usage.PromptTokens,
+		CachedTokens:     usage.CachedTokens,
+		CacheWriteTokens: usage.CacheWriteTokens,
+		HitRatio:         ratio(int64(usage.CachedTokens), int64(usage.PromptTokens)),
+	}
+
+	model := usage.Model
+	if model == "" {
+		model = "unknown"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.models[model]
+	if !ok {
+		if len(t.models) >= maxModels {
+			return request, true
+		}
+		c = &counts{}
+		t.models[model] = c
+	}
+	c.requests++
+	if usage.CachedTokens > 0 {
+		c.hits++
+	}
+	c.promptTokens += int64(usage.PromptTokens)
+	c.cachedTokens += int64(usage.CachedTokens)
+	c.cacheWriteTokens += int64(usage.CacheWriteTokens)
+	return request, true
+}
+
+// Status returns hit rates per model and across all models
+func (t *Tracker) Status() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total counts
+	models := make(map[string]interface{}, len(t.models))
+	for model, c := range t.models {
+		models[model] = c.status()
+		total.requests += c.requests
+		total.hits += c.hits
+		total.promptTokens += c.promptTokens
+		total.cachedTokens += c.cachedTokens
+		total.cacheWriteTokens += c.cacheWriteTokens
+	}
+	status := total.status()
+	status["models"] = models
+	status["inject_rules"] = len(t.rules)
+	return status
+}
+
+func (c *counts) status() map[string]interface{} {
+	return map[string]interface{}{
+		"requests":           c.requests,
+		"hits":               c.hits,
+		"hit_rate":           ratio(c.hits, c.requests),
+		"prompt_tokens":      c.promptTokens,
+		"cached_tokens":      c.cachedTokens,
+		"cache_write_tokens": c.cacheWriteTokens,
+		"cached_token_ratio": ratio(c.cachedTokens, c.promptTokens),
+	}
+}
+
+// ratio divides, rounding to four places, and is 0 when there is nothing to divide by
+func ratio(n, d int64) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(d)*10000) / 10000
+}
@@ -0,0 +1,286 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// windowBuckets is how many slices the error-rate window is divided into
+const windowBuckets = 10
+
+// HealthChecker tracks whether a provider is fit to receive traffic. A
+// provider is ejected after consecutive failed probes or when too many of its
+// recent requests fail, and re-admitted once the ejection time has passed and,
+// when probes are configured, they pass again.
+type HealthChecker struct {
+	name               string
+	probeURL           string
+	client             *http.Client
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+	errorRate          float64
+	minRequests        int
+	bucketWidth        time.Duration
+	ejectionTime       time.Duration
+
+	mu          sync.Mutex
+	ejected     bool
+	ejectedAt   time.Time
+	reason      string
+	ejections   int
+	probeFails  int
+	probePasses int
+	lastProbe   time.Time
+	lastError   string
+	buckets     [windowBuckets]healthBucket
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// healthBucket counts request outcomes in one slice of the window
+type healthBucket struct {
+	epoch     int64
+	successes int
+	failures  int
+}
+
+// NewHealthChecker creates a health checker for a provider. Probes are sent
+// to the provider's base URL using transport.
+func NewHealthChecker(provider config.ProviderConfig, transport http.RoundTripper) (*HealthChecker, error) {
+	cfg := provider.HealthCheck
+	if cfg == nil {
+		return nil, fmt.Errorf("health_check is not configured")
+	}
+
+	interval, err := durationOrDefault("interval", cfg.Interval, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := durationOrDefault("timeout", cfg.Timeout, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	window, err := durationOrDefault("window", cfg.Window, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ejectionTime, err := durationOrDefault("ejection_time", cfg.EjectionTime, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return nil, fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if cfg.Path == "" && cfg.ErrorRate == 0 {
+		return nil, fmt.Errorf("health_check requires a probe path or an error_rate")
+	}
+
+	h := &HealthChecker{
+		name:               provider.Name,
+		client:             &http.Client{Transport: transport, Timeout: timeout},
+		interval:           interval,
+		timeout:            timeout,
+		unhealthyThreshold: cfg.UnhealthyThreshold,
+		healthyThreshold:   cfg.HealthyThreshold,
+		errorRate:          cfg.ErrorRate,
+		minRequests:        cfg.MinRequests,
+		bucketWidth:        window / windowBuckets,
+		ejectionTime:       ejectionTime,
+		stop:               make(chan struct{}),
+	}
+	if cfg.Path != "" {
+		baseURL := provider.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.openai.com"
+		}
+		h.probeURL = strings.TrimSuffix(baseURL, "/") + cfg.Path
+	}
+	if h.unhealthyThreshold <= 0 {
+		h.unhealthyThreshold = 3
+	}
+	if h.healthyThreshold <= 0 {
+		h.healthyThreshold = 2
+	}
+	if h.minRequests <= 0 {
+		h.minRequests = 20
+	}
+	return h, nil
+}
+
+// durationOrDefault parses an optional duration setting, using def when unset
+func durationOrDefault(name, value string, def time.Duration) (time.Duration, error) {
+	d, err := parseDuration(name, value)
+	if err != nil || d > 0 {
+		return d, err
+	}
+	return def, nil
+}
+
+// Start begins periodic probing, when a probe path is configured
+func (h *HealthChecker) Start() {
+	if h.probeURL == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.probe()
+			}
+		}
+	}()
+}
+
+// Stop ends probing
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// probe checks the provider once. Any response below 500 counts as healthy:
+// an unauthenticated 401 still shows the provider is up.
+func (h *HealthChecker) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	var probeErr string
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.probeURL, nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = h.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				probeErr = fmt.Sprintf("probe returned %d", resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		probeErr = err.Error()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastProbe = time.Now()
+	h.lastError = probeErr
+
+	if probeErr != "" {
+		h.probePasses = 0
+		h.probeFails++
+		if !h.ejected && h.probeFails >= h.unhealthyThreshold {
+			h.ejectLocked(fmt.Sprintf("%d failed probes: %s", h.probeFails, probeErr))
+		}
+		return
+	}
+
+	h.probeFails = 0
+	h.probePasses++
+	if h.ejected && h.probePasses >= h.healthyThreshold && time.Since(h.ejectedAt) >= h.ejectionTime {
+		h.readmitLocked()
+	}
+}
+
+// Record counts the outcome of a proxied request towards the error rate
+func (h *HealthChecker) Record(success bool) {
+	if h.errorRate == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	epoch := time.Now().UnixNano() / int64(h.bucketWidth)
+	bucket := &h.buckets[epoch%windowBuckets]
+	if bucket.epoch != epoch {
+		*bucket = healthBucket{epoch: epoch}
+	}
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+
+	if h.ejected {
+		return
+	}
+	var successes, failures int
+	for _, b := range h.buckets {
+		if b.epoch > epoch-windowBuckets {
+			successes += b.successes
+			failures += b.failures
+		}
+	}
+	total := successes + failures
+	if total >= h.minRequests && float64(failures)/float64(total) >= h.errorRate {
+		h.ejectLocked(fmt.Sprintf("%d of %d recent requests failed", failures, total))
+	}
+}
+
+// Healthy reports whether the provider may receive traffic
+func (h *HealthChecker) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Without probes there is nothing to wait for, so re-admit once the ejection time is up
+	if h.ejected && h.probeURL == "" && time.Since(h.ejectedAt) >= h.ejectionTime {
+		h.readmitLocked()
+	}
+	return !h.ejected
+}
+
+func (h *HealthChecker) ejectLocked(reason string) {
+	h.ejected = true
+	h.ejectedAt = time.Now()
+	h.reason = reason
+	h.ejections++
+	h.probePasses = 0
+	log.Printf("[HEALTH] Ejecting provider %s: %s", h.name, reason)
+}
+
+func (h *HealthChecker) readmitLocked() {
+	log.Printf("[HEALTH] Re-admitting provider %s after %v", h.name, time.Since(h.ejectedAt).Round(time.Second))
+	h.ejected = false
+	h.reason = ""
+	h.probeFails = 0
+	h.buckets = [windowBuckets]healthBucket{} // Judge the provider on fresh traffic
+}
+
+// Status returns the provider's health for status endpoints
+func (h *HealthChecker) Status() map[string]interface{} {
+	healthy := h.Healthy()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := map[string]interface{}{
+		"healthy":   healthy,
+		"ejections": h.ejections,
+	}
+	if h.ejected {
+		status["ejected_at"] = h.ejectedAt
+		status["reason"] = h.reason
+	}
+	if !h.lastProbe.IsZero() {
+		status["last_probe"] = h.lastProbe
+		if h.lastError != "" {
+			status["last_probe_error"] = h.lastError
+		}
+	}
+	return status
+}
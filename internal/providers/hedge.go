@@ -0,0 +1,216 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Hedger cuts tail latency by sending a second copy of requests that haven't
+// been answered within a delay, within a budget that bounds the extra load
+type Hedger struct {
+	delay      time.Duration
+	baseURL    string
+	headers    map[string]string
+	replaySafe *bool
+	budget     *retryBudget
+}
+
+// NewHedger creates a hedger from an endpoint's hedge configuration
+func NewHedger(cfg config.HedgeConfig) (*Hedger, error) {
+	delay, err := parseDuration("delay", cfg.Delay)
+	if err != nil {
+		return nil, err
+	}
+	if delay <= 0 {
+		return nil, fmt.Errorf("delay is required")
+	}
+
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid base_url: %s", cfg.BaseURL)
+		}
+	}
+
+	ratio := cfg.BudgetRatio
+	if ratio <= 0 {
+		ratio = 0.1
+	}
+	minHedges := cfg.BudgetMin
+	if minHedges <= 0 {
+		minHedges = 10
+	}
+
+	return &Hedger{
+		delay:      delay,
+		baseURL:    baseURL,
+		headers:    cfg.Headers,
+		replaySafe: cfg.ReplaySafe,
+		budget:     newRetryBudget(ratio, float64(minHedges)),
+	}, nil
+}
+
+// hedgeResult is the outcome of one of the racing attempts
+type hedgeResult struct {
+	resp  *http.Response
+	err   error
+	hedge bool
+}
+
+// Do sends a request through send. If no response has arrived after the
+// delay, a copy goes to the secondary target and the first successful
+// response wins; the other attempt is canceled. Only replay-safe requests are
+// hedged. Whether a hedge was sent and which attempt won is recorded in the
+// request log metadata.
+func (h *Hedger) Do(endpoint string, req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	h.budget.deposit()
+	if !IsReplaySafe(endpoint, req, h.replaySafe) {
+		return send(req)
+	}
+
+	results := make(chan hedgeResult, 2)
+	cancels := make(map[bool]context.CancelFunc, 2)
+	launch := func(attempt *http.Request, hedge bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[hedge] = cancel
+		go func() {
+			resp, err := send(attempt.WithContext(ctx))
+			results <- hedgeResult{resp: resp, err: err, hedge: hedge}
+		}()
+	}
+	launch(req, false)
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	inflight, hedged := 1, false
+	var fallback *hedgeResult
+	for {
+		select {
+		case <-timer.C:
+			if !h.budget.withdraw() {
+				middleware.AddLogMetadata(req.Context(), "hedge_budget_exhausted", true)
+				continue
+			}
+			hedgeReq, err := h.hedgeRequest(endpoint, req)
+			if err != nil {
+				continue // Keep waiting on the primary
+			}
+			launch(hedgeReq, true)
+			inflight++
+			hedged = true
+			middleware.AddLogMetadata(req.Context(), "hedged", true)
+
+		case result := <-results:
+			inflight--
+			failed := result.err != nil || result.resp.StatusCode == http.StatusTooManyRequests || result.resp.StatusCode >= 500
+			if failed && inflight > 0 {
+				// The other attempt may still succeed
+				if fallback == nil {
+					fallback = &result
+				} else {
+					h.discard(result, cancels[result.hedge])
+				}
+				continue
+			}
+			if failed && fallback == nil && !hedged {
+				// The primary failed before the delay; leave failures to retries
+				return h.winner(result, cancels[result.hedge])
+			}
+
+			if failed && fallback != nil {
+				// Both attempts failed, so report the first failure
+				h.discard(result, cancels[result.hedge])
+				result, fallback = *fallback, nil
+			}
+			if fallback != nil {
+				h.discard(*fallback, cancels[fallback.hedge])
+			}
+			if inflight > 0 {
+				// Cancel the loser and close its response once it returns
+				cancels[!result.hedge]()
+				go func() {
+					loser := <-results
+					if loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}()
+			}
+			if hedged {
+				winner := "primary"
+				if result.hedge {
+					winner = "hedge"
+				}
+				middleware.AddLogMetadata(req.Context(), "hedge_winner", winner)
+			}
+			return h.winner(result, cancels[result.hedge])
+		}
+	}
+}
+
+// winner returns an attempt's outcome, keeping its context alive until the
+// response body is closed
+func (h *Hedger) winner(result hedgeResult, cancel context.CancelFunc) (*http.Response, error) {
+	if result.err != nil {
+		cancel()
+		return nil, result.err
+	}
+	result.resp.Body = &cancelBody{ReadCloser: result.resp.Body, cancel: cancel}
+	return result.resp, nil
+}
+
+// discard releases an attempt that lost the race
+func (h *Hedger) discard(result hedgeResult, cancel context.CancelFunc) {
+	if result.resp != nil {
+		result.resp.Body.Close()
+	}
+	cancel()
+}
+
+// hedgeRequest copies req for the secondary target
+func (h *Hedger) hedgeRequest(endpoint string, req *http.Request) (*http.Request, error) {
+	hedgeReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+		hedgeReq.Body = body
+	}
+
+	if h.baseURL != "" {
+		target, err := url.Parse(h.baseURL + endpoint)
+		if err != nil {
+			return nil, err
+		}
+		target.RawQuery = req.URL.RawQuery
+		hedgeReq.URL = target
+		hedgeReq.Host = target.Host
+	}
+	for key, value := range h.headers {
+		hedgeReq.Header.Set(key, value)
+	}
+	return hedgeReq, nil
+}
+
+// cancelBody cancels the winning attempt's context once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
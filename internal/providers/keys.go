@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/ratelimit"
+	"github.com/NamanArora/flash-gateway/internal/secrets"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// ErrKeysExhausted is returned when every key of a provider is rate limited
+var ErrKeysExhausted = errors.New("all provider API keys are rate limited")
+
+// defaultKeyCooldown is how long a key rests after a 429 without Retry-After
+const defaultKeyCooldown = 10 * time.Second
+
+// APIKey is one upstream key held by the gateway and its rate-limit state
+type APIKey struct {
+	Name string // Shown in status and logs in place of the key
+
+	limiter *ratelimit.Bucket // Configured requests_per_minute, if any
+
+	mu            sync.Mutex
+	value         string    // Replaced when a secret:// key rotates
+	coolUntil     time.Time // Skipped until then after a 429 or exhausted upstream limit
+	remaining     int       // Upstream x-ratelimit-remaining-requests, -1 when unknown
+	requests      int64
+	rateLimited   int64
+	lastRateLimit time.Time
+}
+
+// available reports whether the key can take a request now
+func (k *APIKey) available(now time.Time) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return !now.Before(k.coolUntil)
+}
+
+// setValue replaces a rotated key, which starts without the old key's
+// upstream limits
+func (k *APIKey) setValue(value string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.value = value
+	k.coolUntil = time.Time{}
+	k.remaining = -1
+}
+
+// Observe records the upstream's rate-limit headers and 429s for the key
+func (k *APIKey) Observe(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	now := time.Now()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-Ratelimit-Remaining-Requests")); err == nil {
+		k.remaining = remaining
+		if remaining == 0 {
+			if reset, err := time.ParseDuration(resp.Header.Get("X-Ratelimit-Reset-Requests")); err == nil {
+				k.coolUntil = now.Add(reset)
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, ok := retryAfter(resp.Header)
+		if !ok {
+			wait = defaultKeyCooldown
+		}
+		k.coolUntil = now.Add(wait)
+		k.rateLimited++
+		k.lastRateLimit = now
+	}
+}
+
+// KeyPool rotates requests across a provider's upstream keys
+type KeyPool struct {
+	header string // Header the key is sent in
+	bearer bool   // Sent as "Bearer <key>"
+	keys   []*APIKey
+	next   atomic.Uint64
+}
+
+// NewKeyPool loads a provider's api_keys, resolving secret:// keys through
+// store and following them as they rotate. It returns nil when the provider
+// has none, in which case the client's key is forwarded.
+func NewKeyPool(cfg config.ProviderConfig, store *secrets.Manager) (*KeyPool, error) {
+	if len(cfg.APIKeys) == 0 {
+		return nil, nil
+	}
+
+	p := &KeyPool{header: "Authorization", bearer: true}
+	if cfg.KeyHeader != "" && !strings.EqualFold(cfg.KeyHeader, "Authorization") {
+		p.header, p.bearer = http.CanonicalHeaderKey(cfg.KeyHeader), false
+	}
+
+	for i, kc := range cfg.APIKeys {
+		key := &APIKey{Name: kc.Name, remaining: -1}
+		value := kc.Key
+		if secrets.IsReference(value) {
+			if key.Name == "" {
+				key.Name = value // Names the key across rotations, unlike its fingerprint
+			}
+			if store == nil {
+				return nil, fmt.Errorf("api key %d: secret references need a secrets manager", i+1)
+			}
+			resolved, err := store.Watch(context.Background(), value, key.setValue)
+			if err != nil {
+				return nil, fmt.Errorf("api key %d: %w", i+1, err)
+			}
+			value = resolved
+		}
+		if kc.Env != "" {
+			if value != "" {
+				return nil, fmt.Errorf("api key %d sets both key and env", i+1)
+			}
+			value = os.Getenv(kc.Env)
+			if value == "" {
+				return nil, fmt.Errorf("api key %d: environment variable %s is not set", i+1, kc.Env)
+			}
+		}
+		if value == "" {
+			return nil, fmt.Errorf("api key %d needs key or env", i+1)
+		}
+
+		key.value = value
+		if key.Name == "" {
+			key.Name = storage.APIKeyID(value)
+		}
+		if kc.RequestsPerMinute < 0 {
+			return nil, fmt.Errorf("api key %s: requests_per_minute must not be negative", key.Name)
+		}
+		if kc.RequestsPerMinute > 0 {
+			key.limiter = ratelimit.NewBucket(kc.RequestsPerMinute, kc.Burst)
+		}
+		p.keys = append(p.keys, key)
+	}
+	return p, nil
+}
+
+// Next returns the next key in round-robin order that isn't cooling down or
+// over its own limit
+func (p *KeyPool) Next() (*APIKey, error) {
+	now := time.Now()
+	start := p.next.Add(1) - 1
+	for i := 0; i < len(p.keys); i++ {
+		key := p.keys[(start+uint64(i))%uint64(len(p.keys))]
+		if !key.available(now) {
+			continue
+		}
+		if key.limiter != nil && !key.limiter.Allow() {
+			continue
+		}
+		key.mu.Lock()
+		key.requests++
+		key.mu.Unlock()
+		return key, nil
+	}
+	return nil, ErrKeysExhausted
+}
+
+// Apply sets the key on an upstream request, removing the client's own
+func (p *KeyPool) Apply(req *http.Request, key *APIKey) {
+	key.mu.Lock()
+	value := key.value
+	key.mu.Unlock()
+
+	req.Header.Del("Authorization")
+	req.Header.Del("X-Api-Key")
+	if p.bearer {
+		req.Header.Set(p.header, "Bearer "+value)
+	} else {
+		req.Header.Set(p.header, value)
+	}
+}
+
+// RetryAfter returns how long until the first cooling key is usable again
+func (p *KeyPool) RetryAfter() time.Duration {
+	now := time.Now()
+	var soonest time.Duration
+	for _, key := range p.keys {
+		key.mu.Lock()
+		wait := key.coolUntil.Sub(now)
+		key.mu.Unlock()
+		if wait > 0 && (soonest == 0 || wait < soonest) {
+			soonest = wait
+		}
+	}
+	if soonest < time.Second {
+		soonest = time.Second
+	}
+	return soonest
+}
+
+// ShareLimits keeps the keys' requests_per_minute limits in backend, so that
+// gateway instances sharing a key stay within its limit together. provider
+// names the pool's provider, since key names need only be unique within it.
+func (p *KeyPool) ShareLimits(backend ratelimit.Backend, provider string) {
+	for _, key := range p.keys {
+		if key.limiter != nil {
+			key.limiter.Share(backend, "key:"+provider+":"+key.Name)
+		}
+	}
+}
+
+// Status describes each key's usage and rate-limit state, never the key
+func (p *KeyPool) Status() []map[string]interface{} {
+	now := time.Now()
+	status := make([]map[string]interface{}, 0, len(p.keys))
+	for _, key := range p.keys {
+		key.mu.Lock()
+		described := map[string]interface{}{
+			"name":         key.Name,
+			"requests":     key.requests,
+			"rate_limited": key.rateLimited,
+			"available":    !now.Before(key.coolUntil),
+		}
+		if key.remaining >= 0 {
+			described["remaining_requests"] = key.remaining
+		}
+		if now.Before(key.coolUntil) {
+			described["cooling_until"] = key.coolUntil
+		}
+		if !key.lastRateLimit.IsZero() {
+			described["last_rate_limited"] = key.lastRateLimit
+		}
+		key.mu.Unlock()
+		if key.limiter != nil {
+			described["rate_limit"] = key.limiter.Status()
+		}
+		status = append(status, described)
+	}
+	return status
+}
@@ -0,0 +1,75 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// ErrConcurrencyLimit is returned when a provider has no free request slot
+var ErrConcurrencyLimit = errors.New("provider concurrency limit reached")
+
+// Limiter caps the requests in flight to one provider, so a slow upstream
+// can't tie up an unbounded number of buffered requests
+type Limiter struct {
+	slots    chan struct{}
+	wait     time.Duration
+	rejected atomic.Int64
+}
+
+// NewLimiter creates a limiter from a provider's configuration, returning nil
+// when the provider has no limit
+func NewLimiter(cfg config.ProviderConfig) (*Limiter, error) {
+	if cfg.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("max_concurrent must not be negative")
+	}
+	wait, err := parseDuration("concurrency_wait", cfg.ConcurrencyWait)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrent == 0 {
+		return nil, nil
+	}
+	return &Limiter{slots: make(chan struct{}, cfg.MaxConcurrent), wait: wait}, nil
+}
+
+// Acquire takes a slot, waiting up to the configured wait for one to free up.
+// The returned function releases the slot.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.wait <= 0 {
+		l.rejected.Add(1)
+		return nil, ErrConcurrencyLimit
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.rejected.Add(1)
+		return nil, ErrConcurrencyLimit
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Status returns the limiter state for status endpoints
+func (l *Limiter) Status() map[string]interface{} {
+	return map[string]interface{}{
+		"in_flight":      len(l.slots),
+		"max_concurrent": cap(l.slots),
+		"rejected":       l.rejected.Load(),
+	}
+}
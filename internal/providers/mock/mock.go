@@ -0,0 +1,336 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultModel names the model of requests that don't name one
+	defaultModel = "mock"
+	// defaultResponse is the completion when there is no user message to echo
+	defaultResponse = "This is a mock response."
+	// defaultChunkDelay paces streamed chunks unless configured otherwise
+	defaultChunkDelay = 20 * time.Millisecond
+)
+
+// Provider implements the providers.Provider interface with canned chat
+// completions, so the gateway can be run and tested without upstream API keys
+type Provider struct {
+	config      config.ProviderConfig
+	response    string
+	latency     time.Duration
+	chunkDelay  time.Duration
+	errorRate   float64
+	errorStatus int
+}
+
+// New creates a mock provider from its configuration
+func New(cfg config.ProviderConfig) (*Provider, error) {
+	mockCfg := config.MockConfig{}
+	if cfg.Mock != nil {
+		mockCfg = *cfg.Mock
+	}
+	if mockCfg.ErrorRate < 0 || mockCfg.ErrorRate > 1 {
+		return nil, fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	p := &Provider{
+		config:      cfg,
+		response:    mockCfg.Response,
+		chunkDelay:  defaultChunkDelay,
+		errorRate:   mockCfg.ErrorRate,
+		errorStatus: mockCfg.ErrorStatus,
+	}
+	if p.errorStatus == 0 {
+		p.errorStatus = http.StatusInternalServerError
+	}
+	if p.errorStatus < 400 || p.errorStatus > 599 {
+		return nil, fmt.Errorf("error_status must be a 4xx or 5xx status")
+	}
+
+	var err error
+	if mockCfg.Latency != "" {
+		if p.latency, err = time.ParseDuration(mockCfg.Latency); err != nil {
+			return nil, fmt.Errorf("invalid latency: %w", err)
+		}
+	}
+	if mockCfg.ChunkDelay != "" {
+		if p.chunkDelay, err = time.ParseDuration(mockCfg.ChunkDelay); err != nil {
+			return nil, fmt.Errorf("invalid chunk_delay: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// GetName returns the provider name
+func (p *Provider) GetName() string {
+	return p.config.Name
+}
+
+// GetBaseURL returns the configured base URL, which the mock never calls
+func (p *Provider) GetBaseURL() string {
+	return p.config.BaseURL
+}
+
+// SupportedEndpoints returns the configured endpoints
+func (p *Provider) SupportedEndpoints() []string {
+	endpoints := make([]string, len(p.config.Endpoints))
+	for i, endpoint := range p.config.Endpoints {
+		endpoints[i] = endpoint.Path
+	}
+	return endpoints
+}
+
+// ProxyRequest answers the request itself, after the configured latency.
+// Chat completions get a canned answer, plain or streamed; a share of requests
+// given by the error rate fail instead.
+func (p *Provider) ProxyRequest(ctx context.Context, endpoint string, req *http.Request) (*http.Response, error) {
+	if p.latency > 0 {
+		timer := time.NewTimer(p.latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	if p.errorRate > 0 && rand.Float64() < p.errorRate {
+		return errorResponse(req, p.errorStatus, "server_error", "Mock provider error"), nil
+	}
+
+	switch {
+	case strings.HasSuffix(endpoint, "/chat/completions") && req.Method == http.MethodPost:
+		return p.chatCompletion(ctx, req, body), nil
+	case strings.HasSuffix(endpoint, "/models") && req.Method == http.MethodGet:
+		return p.models(req), nil
+	default:
+		return errorResponse(req, http.StatusNotFound, "invalid_request_error",
+			fmt.Sprintf("The mock provider does not serve %s %s", req.Method, endpoint)), nil
+	}
+}
+
+// TransformRequest is a no-op for the mock provider
+func (p *Provider) TransformRequest(endpoint string, req *http.Request) error {
+	return nil
+}
+
+// TransformResponse is a no-op for the mock provider
+func (p *Provider) TransformResponse(endpoint string, resp *http.Response) error {
+	return nil
+}
+
+// chatRequest is the part of a chat completion request the mock reads
+type chatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	Stream        bool `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// chatCompletion answers with the configured response, or else echoes the
+// last user message, with usage counted as OpenAI would
+func (p *Provider) chatCompletion(ctx context.Context, req *http.Request, body []byte) *http.Response {
+	var chat chatRequest
+	if err := json.Unmarshal(body, &chat); err != nil {
+		return errorResponse(req, http.StatusBadRequest, "invalid_request_error", "Request body is not valid JSON")
+	}
+	model := chat.Model
+	if model == "" {
+		model = defaultModel
+	}
+
+	messages := make([]tokenizer.Message, 0, len(chat.Messages))
+	lastUser := ""
+	for _, message := range chat.Messages {
+		text := messageText(message.Content)
+		messages = append(messages, tokenizer.Message{Role: message.Role, Content: text})
+		if message.Role == "user" && text != "" {
+			lastUser = text
+		}
+	}
+	content := p.response
+	if content == "" {
+		content = lastUser
+	}
+	if content == "" {
+		content = defaultResponse
+	}
+
+	id := "chatcmpl-mock-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:20]
+	created := time.Now().Unix()
+	promptTokens := tokenizer.CountMessages(model, messages)
+	completionTokens := tokenizer.Count(model, content)
+	usage := map[string]interface{}{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+	}
+
+	if chat.Stream {
+		includeUsage := chat.StreamOptions != nil && chat.StreamOptions.IncludeUsage
+		return p.stream(ctx, req, id, created, model, content, usage, includeUsage)
+	}
+	return jsonResponse(req, http.StatusOK, map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]interface{}{"role": "assistant", "content": content},
+			"finish_reason": "stop",
+		}},
+		"usage": usage,
+	})
+}
+
+// stream sends the completion as server-sent events a word at a time, paced
+// by the chunk delay, and stops early when the client goes away
+func (p *Provider) stream(ctx context.Context, req *http.Request, id string, created int64, model, content string, usage map[string]interface{}, includeUsage bool) *http.Response {
+	chunk := func(delta map[string]interface{}, finishReason interface{}) map[string]interface{} {
+		choices := []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}}
+		return map[string]interface{}{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": model, "choices": choices,
+		}
+	}
+
+	events := []map[string]interface{}{chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil)}
+	for _, word := range strings.SplitAfter(content, " ") {
+		events = append(events, chunk(map[string]interface{}{"content": word}, nil))
+	}
+	events = append(events, chunk(map[string]interface{}{}, "stop"))
+	if includeUsage {
+		final := chunk(nil, nil)
+		final["choices"] = []interface{}{}
+		final["usage"] = usage
+		events = append(events, final)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		for i, event := range events {
+			if i > 0 && p.chunkDelay > 0 {
+				select {
+				case <-ctx.Done():
+					writer.CloseWithError(ctx.Err())
+					return
+				case <-time.After(p.chunkDelay):
+				}
+			}
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(writer, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		io.WriteString(writer, "data: [DONE]\n\n")
+		writer.Close()
+	}()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          reader,
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+// models lists the configured models, or the default mock model
+func (p *Provider) models(req *http.Request) *http.Response {
+	names := p.config.Models
+	if len(names) == 0 {
+		names = []string{defaultModel}
+	}
+	data := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		data[i] = map[string]interface{}{"id": name, "object": "model", "created": 0, "owned_by": p.config.Name}
+	}
+	return jsonResponse(req, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// messageText returns the text of a message's content, either a string or
+// an array of parts of which the text ones are joined
+func messageText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// errorResponse builds an error in OpenAI's format
+func errorResponse(req *http.Request, status int, errorType, message string) *http.Response {
+	return jsonResponse(req, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errorType,
+			"code":    nil,
+		},
+	})
+}
+
+func jsonResponse(req *http.Request, status int, payload interface{}) *http.Response {
+	data, _ := json.Marshal(payload)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
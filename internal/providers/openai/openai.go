@@ -1,31 +1,102 @@
 package openai
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/transform"
+	"github.com/NamanArora/flash-gateway/internal/translate"
 )
 
 // Provider implements the providers.Provider interface for OpenAI
 type Provider struct {
-	config config.ProviderConfig
-	client *http.Client
+	config             config.ProviderConfig
+	client             *http.Client
+	responseTransforms map[string]*transform.ResponseTransformer // endpoint -> transform
+	retriers           map[string]*providers.Retrier             // endpoint -> retry policy and budget
+	hedgers            map[string]*providers.Hedger              // endpoint -> hedge policy and budget
+	translators        map[string]translate.Translator           // endpoint -> API format translation
 }
 
-// New creates a new OpenAI provider instance
-func New(cfg config.ProviderConfig) *Provider {
+// New creates a new OpenAI provider instance. Transport is normally the pool
+// shared by all providers; nil falls back to a dedicated transport.
+func New(cfg config.ProviderConfig, transport http.RoundTripper) *Provider {
+	if transport == nil {
+		transport = &http.Transport{
+			DisableCompression: true, // Don't auto-decompress gzip responses for true pass-through proxy
+		}
+	}
+
+	responseTransforms := make(map[string]*transform.ResponseTransformer)
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint.Response == nil {
+			continue
+		}
+		// Configs are validated by the router, so errors here are not expected
+		t, err := transform.NewResponseTransformer(*endpoint.Response)
+		if err != nil {
+			log.Printf("Ignoring response transform for %s: %v", endpoint.Path, err)
+			continue
+		}
+		responseTransforms[endpoint.Path] = t
+	}
+
+	retriers := make(map[string]*providers.Retrier)
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint.Retry == nil {
+			continue
+		}
+		retrier, err := providers.NewRetrier(*endpoint.Retry)
+		if err != nil {
+			log.Printf("Ignoring retry config for %s: %v", endpoint.Path, err)
+			continue
+		}
+		retriers[endpoint.Path] = retrier
+	}
+
+	hedgers := make(map[string]*providers.Hedger)
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint.Hedge == nil {
+			continue
+		}
+		hedger, err := providers.NewHedger(*endpoint.Hedge)
+		if err != nil {
+			log.Printf("Ignoring hedge config for %s: %v", endpoint.Path, err)
+			continue
+		}
+		hedgers[endpoint.Path] = hedger
+	}
+
+	translators := make(map[string]translate.Translator)
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint.Translate == "" {
+			continue
+		}
+		translator, err := translate.New(endpoint.Translate)
+		if err != nil {
+			log.Printf("Ignoring translation for %s: %v", endpoint.Path, err)
+			continue
+		}
+		translators[endpoint.Path] = translator
+	}
+
 	return &Provider{
 		config: cfg,
 		client: &http.Client{
-			Transport: &http.Transport{
-				DisableCompression: true, // Don't auto-decompress gzip responses for true pass-through proxy
-			},
-			Timeout: 60 * time.Second, // Default timeout
+			Transport: transport, // Timeouts are applied per endpoint in ProxyRequest
 		},
+		responseTransforms: responseTransforms,
+		retriers:           retriers,
+		hedgers:            hedgers,
+		translators:        translators,
 	}
 }
 
@@ -53,8 +124,24 @@ func (p *Provider) SupportedEndpoints() []string {
 
 // ProxyRequest proxies the request to OpenAI API
 func (p *Provider) ProxyRequest(ctx context.Context, endpoint string, req *http.Request) (*http.Response, error) {
-	// Create target URL
-	targetURL := p.GetBaseURL() + endpoint
+	// Settings are kept by configured endpoint, which may be a pattern such as /v1/files/{id}
+	route := p.endpointFor(endpoint, req.Method)
+
+	// Translated endpoints are served by the upstream API's own endpoint, in its format
+	upstreamPath := endpoint
+	translator := p.translators[route]
+	if translator != nil {
+		upstreamPath = translator.Path()
+		if err := translateRequest(translator, req); err != nil {
+			return nil, fmt.Errorf("request translation failed: %w", err)
+		}
+	}
+
+	// Create target URL, keeping the query (e.g. the Realtime API's ?model=)
+	targetURL := p.GetBaseURL() + upstreamPath
+	if req.URL.RawQuery != "" {
+		targetURL += "?" + req.URL.RawQuery
+	}
 	
 	// Create new request with context
 	proxyReq, err := http.NewRequestWithContext(ctx, req.Method, targetURL, req.Body)
@@ -62,6 +149,11 @@ func (p *Provider) ProxyRequest(ctx context.Context, endpoint string, req *http.
 		return nil, fmt.Errorf("failed to create proxy request: %w", err)
 	}
 
+	// Forward the body length so uploads are not re-sent chunked, and let
+	// buffered bodies be replayed by retries
+	proxyReq.ContentLength = req.ContentLength
+	proxyReq.GetBody = req.GetBody
+
 	// Copy all headers from original request to proxy request
 	for key, values := range req.Header {
 		for _, value := range values {
@@ -69,35 +161,48 @@ func (p *Provider) ProxyRequest(ctx context.Context, endpoint string, req *http.
 		}
 	}
 
-	// TODO: Add support for Brotli (br), Zstandard (zstd), and deflate compression formats
-	// Currently only gzip is supported for response decompression in logging
-	// Force gzip by removing other compression formats from Accept-Encoding
-	acceptEncoding := proxyReq.Header.Get("Accept-Encoding")
-	if strings.Contains(acceptEncoding, "br") || strings.Contains(acceptEncoding, "zstd") || strings.Contains(acceptEncoding, "deflate") {
-		// Remove unsupported compression formats: 'br' (Brotli), 'zstd' (Zstandard), 'deflate'
-		acceptEncoding = strings.ReplaceAll(acceptEncoding, "br", "")
-		acceptEncoding = strings.ReplaceAll(acceptEncoding, "zstd", "")
-		acceptEncoding = strings.ReplaceAll(acceptEncoding, "deflate", "")
-		// Clean up any double commas or leading/trailing commas
-		acceptEncoding = strings.ReplaceAll(acceptEncoding, ",,", ",")
-		acceptEncoding = strings.Trim(acceptEncoding, ", ")
-		if acceptEncoding == "" {
-			acceptEncoding = "gzip"  // Only gzip to ensure we can decompress for logging
-		}
-		proxyReq.Header.Set("Accept-Encoding", acceptEncoding)
-	}
-
 	// Apply request transformations
 	if err := p.TransformRequest(endpoint, proxyReq); err != nil {
 		return nil, fmt.Errorf("request transformation failed: %w", err)
 	}
+	if translator != nil {
+		translator.Header(proxyReq.Header)
+		proxyReq.Header.Set("Content-Type", "application/json")
+		proxyReq.Header.Del("Accept-Encoding") // Responses are rewritten, so ask for them uncompressed
+	}
 
-	// Make the request
-	resp, err := p.client.Do(proxyReq)
+	// Make the request, remembering when each attempt was sent for latency headers
+	timeouts := providers.EndpointTimeouts(p.getEndpointConfig(route, req.Method))
+	send := func(attempt *http.Request) (*http.Response, error) {
+		attempt = attempt.WithContext(transform.WithUpstreamStart(attempt.Context(), time.Now()))
+		return providers.Do(p.client, attempt, timeouts)
+	}
+	if hedger, ok := p.hedgers[route]; ok {
+		// Each attempt, including retries, races a hedged copy when slow
+		direct := send
+		send = func(attempt *http.Request) (*http.Response, error) {
+			return hedger.Do(endpoint, attempt, direct)
+		}
+	}
+
+	var resp *http.Response
+	if retrier, ok := p.retriers[route]; ok {
+		resp, err = retrier.Do(endpoint, proxyReq, send)
+	} else {
+		resp, err = send(proxyReq)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("proxy request failed: %w", err)
 	}
 
+	// Responses come back in the client's format, before any configured mutations
+	if translator != nil {
+		if err := translator.Response(resp); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("response translation failed: %w", err)
+		}
+	}
+
 	// Apply response transformations
 	if err := p.TransformResponse(endpoint, resp); err != nil {
 		resp.Body.Close()
@@ -115,7 +220,7 @@ func (p *Provider) TransformRequest(endpoint string, req *http.Request) error {
 	}
 
 	// Apply endpoint-specific headers from config
-	endpointConfig := p.getEndpointConfig(endpoint)
+	endpointConfig := p.getEndpointConfig(endpoint, req.Method)
 	if endpointConfig != nil {
 		for key, value := range endpointConfig.Headers {
 			req.Header.Set(key, value)
@@ -127,13 +232,76 @@ func (p *Provider) TransformRequest(endpoint string, req *http.Request) error {
 
 // TransformResponse applies OpenAI-specific response transformations
 func (p *Provider) TransformResponse(endpoint string, resp *http.Response) error {
-	// For now, we don't need any OpenAI-specific response transformations
-	// This method is here for future extensibility
+	// Apply the endpoint's configured response mutations, if any
+	method := ""
+	if resp.Request != nil {
+		method = resp.Request.Method
+	}
+	if t, ok := p.responseTransforms[p.endpointFor(endpoint, method)]; ok {
+		return t.Apply(resp, p.GetName())
+	}
 	return nil
 }
 
-// getEndpointConfig returns the configuration for a specific endpoint
-func (p *Provider) getEndpointConfig(endpoint string) *config.EndpointConfig {
+// translateRequest replaces a request's body with its translation
+func translateRequest(translator translate.Translator, req *http.Request) error {
+	if req.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	if body, err = translator.Request(body); err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// endpointFor returns the configured endpoint serving a request path, which
+// is the path itself unless it matched a pattern. Of several matching
+// patterns, the most specific one configured for the method is used.
+func (p *Provider) endpointFor(path, method string) string {
+	matches := providers.MatchingEndpoints(p.SupportedEndpoints(), path)
+	for _, endpoint := range matches {
+		if ep := p.endpointConfig(endpoint); ep != nil && allowsMethod(ep.Methods, method) {
+			return endpoint
+		}
+	}
+	if len(matches) > 0 {
+		return matches[0]
+	}
+	return path
+}
+
+// allowsMethod reports whether an endpoint's configured methods include
+// method; an empty list allows any
+func allowsMethod(methods []string, method string) bool {
+	if len(methods) == 0 || method == "" {
+		return true
+	}
+	for _, allowed := range methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// getEndpointConfig returns the configuration for the endpoint serving a
+// request path and method
+func (p *Provider) getEndpointConfig(path, method string) *config.EndpointConfig {
+	return p.endpointConfig(p.endpointFor(path, method))
+}
+
+// endpointConfig returns the configuration of a configured endpoint path
+func (p *Provider) endpointConfig(endpoint string) *config.EndpointConfig {
 	for _, ep := range p.config.Endpoints {
 		if ep.Path == endpoint {
 			return &ep
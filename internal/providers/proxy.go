@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyFunc routes requests through proxyURL unless their host matches noProxy.
+// Entries follow NO_PROXY conventions: "*" matches everything, a domain matches
+// itself and its subdomains (with or without a leading dot), and IPs or CIDR
+// ranges match addresses. An entry may carry a port to match only that port.
+func proxyFunc(proxyURL string, noProxy []string) (func(*http.Request) (*url.URL, error), error) {
+	target, err := url.Parse(proxyURL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url: %s", proxyURL)
+	}
+	switch target.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy_url scheme: %s", target.Scheme)
+	}
+
+	if noProxy == nil {
+		env := os.Getenv("NO_PROXY")
+		if env == "" {
+			env = os.Getenv("no_proxy")
+		}
+		for _, entry := range strings.Split(env, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				noProxy = append(noProxy, entry)
+			}
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return target, nil
+	}, nil
+}
+
+// bypassProxy reports whether a request URL matches a no_proxy entry
+func bypassProxy(u *url.URL, noProxy []string) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		domain := strings.TrimPrefix(entryHost, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Factory creates a provider from its configuration. Requests should go
+// through transport, which carries the shared connection pool and the
+// provider's TLS and proxy settings.
+type Factory func(cfg config.ProviderConfig, transport http.RoundTripper) (Provider, error)
+
+var (
+	// Provider implementations beyond the built-in ones, by provider type
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+// Register makes a provider implementation available to providers configured
+// with the given type, which defaults to the provider's name. This should be
+// called during application initialization.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic(fmt.Sprintf("provider factory for %s is nil", name))
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory registered for a provider type
+func Lookup(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[name]
+	return factory, ok
+}
@@ -0,0 +1,260 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// IdempotencyKeyHeader marks a client request as safe to replay
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// replaySafeEndpoints are POST endpoints whose calls have no side effects beyond
+// usage, so sending them twice is harmless
+var replaySafeEndpoints = map[string]bool{
+	"/v1/chat/completions":     true,
+	"/v1/completions":          true,
+	"/v1/responses":            true,
+	"/v1/embeddings":           true,
+	"/v1/moderations":          true,
+	"/v1/messages":             true,
+	"/v1/audio/transcriptions": true,
+	"/v1/audio/translations":   true,
+	"/v1/audio/speech":         true,
+}
+
+// defaultRetryStatuses are the upstream statuses retried when retry_on is not set
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Retrier retries failed upstream calls with exponential backoff, within a
+// budget that keeps retries to a fraction of the endpoint's traffic
+type Retrier struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryOn        map[int]bool
+	replaySafe     *bool
+	budget         *retryBudget
+}
+
+// NewRetrier creates a retrier from an endpoint's retry configuration
+func NewRetrier(cfg config.RetryConfig) (*Retrier, error) {
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("max_retries must not be negative")
+	}
+
+	initialBackoff, err := parseDuration("initial_backoff", cfg.InitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+	if initialBackoff <= 0 {
+		initialBackoff = 250 * time.Millisecond
+	}
+	maxBackoff, err := parseDuration("max_backoff", cfg.MaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+
+	statuses := cfg.RetryOn
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	retryOn := make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		retryOn[status] = true
+	}
+
+	ratio := cfg.BudgetRatio
+	if ratio <= 0 {
+		ratio = 0.2
+	}
+	minRetries := cfg.BudgetMin
+	if minRetries <= 0 {
+		minRetries = 10
+	}
+
+	return &Retrier{
+		maxRetries:     cfg.MaxRetries,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		retryOn:        retryOn,
+		replaySafe:     cfg.ReplaySafe,
+		budget:         newRetryBudget(ratio, float64(minRetries)),
+	}, nil
+}
+
+// Do sends a request through send, retrying retryable failures. Requests are
+// only retried when they are idempotent or replay-safe and their body can be
+// sent again. The retry count is recorded in the request log metadata.
+func (r *Retrier) Do(endpoint string, req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	r.budget.deposit()
+	canRetry := r.maxRetries > 0 && IsReplaySafe(endpoint, req, r.replaySafe)
+
+	retries := 0
+	defer func() {
+		if retries > 0 {
+			middleware.AddLogMetadata(req.Context(), "upstream_retries", retries)
+		}
+	}()
+
+	attempt := req
+	for {
+		resp, err := send(attempt)
+		if !canRetry || retries >= r.maxRetries || !r.retryable(resp, err) {
+			return resp, err
+		}
+		if !r.budget.withdraw() {
+			middleware.AddLogMetadata(req.Context(), "retry_budget_exhausted", true)
+			return resp, err
+		}
+
+		delay := r.backoff(retries, resp)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // Let the connection be reused
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		attempt = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %w", err)
+			}
+			attempt.Body = body
+		}
+		retries++
+	}
+}
+
+// IsReplaySafe reports whether sending the request twice is harmless and its
+// body can be sent again. override, when set, decides for POST requests.
+func IsReplaySafe(endpoint string, req *http.Request, override *bool) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if req.Header.Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+	if override != nil {
+		return *override
+	}
+	return replaySafeEndpoints[endpoint]
+}
+
+// retryable reports whether an attempt failed in a way worth retrying.
+// Timeouts are not retried, the caller has already waited long enough.
+func (r *Retrier) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrUpstreamTimeout) && !errors.Is(err, context.Canceled)
+	}
+	return r.retryOn[resp.StatusCode]
+}
+
+// backoff returns how long to wait before the next attempt: the provider's
+// Retry-After when given, otherwise exponential backoff with jitter
+func (r *Retrier) backoff(retries int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header); ok {
+			if delay > r.maxBackoff {
+				delay = r.maxBackoff
+			}
+			return delay
+		}
+	}
+
+	delay := r.initialBackoff << retries
+	if delay <= 0 || delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	// Up to 50% jitter so clients failing together don't retry together
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses retry-after-ms (sent by OpenAI) or Retry-After, in seconds or as an HTTP date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// retryBudget is a token bucket: every request adds ratio tokens and every
+// retry spends one, so retries stay near ratio of traffic. A floor of
+// minRetries tokens keeps low-traffic endpoints able to retry.
+type retryBudget struct {
+	mu         sync.Mutex
+	ratio      float64
+	minRetries float64
+	tokens     float64
+}
+
+// newRetryBudget creates a budget that starts full
+func newRetryBudget(ratio, minRetries float64) *retryBudget {
+	return &retryBudget{ratio: ratio, minRetries: minRetries, tokens: minRetries}
+}
+
+// deposit credits the budget for one request
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if limit := b.minRetries + b.ratio*100; b.tokens > limit {
+		b.tokens = limit
+	}
+}
+
+// withdraw spends one retry, reporting false when the budget is exhausted
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
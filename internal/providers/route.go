@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Endpoint paths in provider configs are matched exactly, or may be patterns:
+// a {name} segment matches any one path segment, as in /v1/files/{id}, and a
+// final * segment matches one or more, as in /v1/models/*.
+
+// IsPattern reports whether an endpoint path has {param} or * segments
+func IsPattern(endpoint string) bool {
+	return strings.Contains(endpoint, "{") || strings.Contains(endpoint, "*")
+}
+
+// ValidateEndpoint checks an endpoint path's pattern syntax
+func ValidateEndpoint(endpoint string) error {
+	if !strings.HasPrefix(endpoint, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	segments := strings.Split(endpoint, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			if i != len(segments)-1 {
+				return fmt.Errorf("* must be the last segment")
+			}
+		case strings.Contains(segment, "*"):
+			return fmt.Errorf("* must be a whole segment")
+		case strings.HasPrefix(segment, "{"):
+			if !strings.HasSuffix(segment, "}") || len(segment) < 3 || strings.ContainsAny(segment[1:len(segment)-1], "{}") {
+				return fmt.Errorf("invalid parameter segment %q", segment)
+			}
+		case strings.ContainsAny(segment, "{}"):
+			return fmt.Errorf("parameters must be whole segments, not %q", segment)
+		}
+	}
+	return nil
+}
+
+// MatchEndpoint reports whether a request path matches an endpoint path
+func MatchEndpoint(endpoint, path string) bool {
+	if !IsPattern(endpoint) {
+		return endpoint == path
+	}
+	want := strings.Split(endpoint, "/")
+	got := strings.Split(path, "/")
+	for i, segment := range want {
+		if segment == "*" {
+			return len(got) > i && got[i] != ""
+		}
+		if i >= len(got) {
+			return false
+		}
+		if segmentKind(segment) == paramSegment {
+			if got[i] == "" {
+				return false
+			}
+		} else if segment != got[i] {
+			return false
+		}
+	}
+	return len(got) == len(want)
+}
+
+// MatchingEndpoints returns the endpoint paths matching a request path, most
+// specific first: an exact match, then patterns by their literal segments
+func MatchingEndpoints(endpoints []string, path string) []string {
+	var matches []string
+	for _, endpoint := range endpoints {
+		if MatchEndpoint(endpoint, path) {
+			matches = append(matches, endpoint)
+		}
+	}
+	SortBySpecificity(matches)
+	return matches
+}
+
+// BestEndpoint returns the most specific endpoint path matching a request path
+func BestEndpoint(endpoints []string, path string) (string, bool) {
+	matches := MatchingEndpoints(endpoints, path)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// SortBySpecificity orders endpoint paths so that, segment by segment,
+// literals come before parameters and parameters before wildcards, and longer
+// paths come before their prefixes
+func SortBySpecificity(endpoints []string) {
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		a, b := strings.Split(endpoints[i], "/"), strings.Split(endpoints[j], "/")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if ka, kb := segmentKind(a[k]), segmentKind(b[k]); ka != kb {
+				return ka > kb
+			}
+		}
+		return len(a) > len(b)
+	})
+}
+
+// Segment kinds, ordered by specificity
+const (
+	wildcardSegment = iota
+	paramSegment
+	literalSegment
+)
+
+func segmentKind(segment string) int {
+	switch {
+	case segment == "*":
+		return wildcardSegment
+	case strings.HasPrefix(segment, "{"):
+		return paramSegment
+	}
+	return literalSegment
+}
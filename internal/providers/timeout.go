@@ -0,0 +1,144 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// DefaultTimeout applies to endpoints without a configured timeout
+const DefaultTimeout = 60 * time.Second
+
+// ErrUpstreamTimeout is returned, wrapped, when a provider exceeds one of its timeouts
+var ErrUpstreamTimeout = errors.New("upstream timeout")
+
+// Timeouts bound one upstream call. Connection setup is bounded separately by
+// the shared transport's dial and TLS handshake timeouts.
+type Timeouts struct {
+	Total      time.Duration // Whole request, including the body, for non-streamed responses
+	Header     time.Duration // Waiting for response headers
+	StreamIdle time.Duration // Longest gap between reads of a streamed response
+}
+
+// EndpointTimeouts resolves an endpoint's configured timeouts. Header and
+// stream idle timeouts default to the endpoint's overall timeout.
+func EndpointTimeouts(endpoint *config.EndpointConfig) Timeouts {
+	t := Timeouts{Total: DefaultTimeout}
+	if endpoint == nil {
+		t.Header, t.StreamIdle = t.Total, t.Total
+		return t
+	}
+
+	if endpoint.Timeout > 0 {
+		t.Total = time.Duration(endpoint.Timeout) * time.Second
+	}
+	t.Header = t.Total
+	if endpoint.HeaderTimeout > 0 {
+		t.Header = time.Duration(endpoint.HeaderTimeout) * time.Second
+	}
+	t.StreamIdle = t.Total
+	if endpoint.StreamIdleTimeout > 0 {
+		t.StreamIdle = time.Duration(endpoint.StreamIdleTimeout) * time.Second
+	}
+	return t
+}
+
+// Do sends req with the given timeouts. Non-streamed responses must be read
+// within the total timeout; event streams are not bounded overall, but fail
+// once no data arrives for StreamIdle.
+func Do(client *http.Client, req *http.Request, t Timeouts) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	var expired atomic.Bool
+	expire := func() {
+		expired.Store(true)
+		cancel()
+	}
+
+	start := time.Now()
+	timer := time.AfterFunc(minTimeout(t.Header, t.Total), expire)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		cancel()
+		if expired.Load() {
+			return nil, fmt.Errorf("%w: no response headers after %s", ErrUpstreamTimeout, time.Since(start).Round(time.Millisecond))
+		}
+		return nil, err
+	}
+
+	// An upgraded connection lives as long as its peers keep it open, and its
+	// body must stay writable
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		timer.Stop()
+		return resp, nil
+	}
+
+	body := &timeoutBody{ReadCloser: resp.Body, timer: timer, cancel: cancel, expired: &expired}
+	if IsEventStream(resp) {
+		// Streams may run for as long as data keeps arriving
+		body.idle = t.StreamIdle
+		if body.idle > 0 {
+			timer.Reset(body.idle)
+		} else {
+			timer.Stop()
+		}
+	} else if t.Total > 0 {
+		timer.Reset(t.Total - time.Since(start))
+	} else {
+		timer.Stop()
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// minTimeout returns the smaller non-zero duration
+func minTimeout(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// IsEventStream reports whether the response is a server-sent event stream
+func IsEventStream(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// timeoutBody cancels the upstream request when its deadline passes and
+// reports the timeout instead of a bare context error
+type timeoutBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired *atomic.Bool
+	idle    time.Duration // Re-armed after every read for streams
+}
+
+// Read implements io.Reader
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.expired.Load() {
+		return n, fmt.Errorf("%w: %v", ErrUpstreamTimeout, err)
+	}
+	if b.idle > 0 && n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,183 @@
+package providers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// NewTransport builds the pooled HTTP transport shared by every provider, so
+// connections to the same upstream are reused across providers and endpoints
+func NewTransport(cfg config.TransportConfig) (*http.Transport, error) {
+	idleConnTimeout, err := parseDuration("idle_conn_timeout", cfg.IdleConnTimeout)
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout, err := parseDuration("dial_timeout", cfg.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	keepAlive, err := parseDuration("keep_alive", cfg.KeepAlive)
+	if err != nil {
+		return nil, err
+	}
+	tlsHandshakeTimeout, err := parseDuration("tls_handshake_timeout", cfg.TLSHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		TLSClientConfig:     tlsConfig,
+		DisableKeepAlives:   cfg.DisableKeepAlives,
+		// A custom dialer and TLS config turn off Go's automatic HTTP/2, so opt back in
+		ForceAttemptHTTP2:     cfg.HTTP2,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableCompression:    true, // Don't auto-decompress responses for true pass-through proxy
+	}, nil
+}
+
+// ProviderTransport returns the transport a provider should use: the shared
+// one, or a copy of it when the provider has its own TLS or proxy settings.
+// Copies keep their own connection pool.
+func ProviderTransport(shared *http.Transport, provider config.ProviderConfig) (*http.Transport, error) {
+	if provider.TLS == nil && provider.ProxyURL == "" {
+		return shared, nil
+	}
+
+	transport := shared.Clone()
+	if provider.ProxyURL != "" {
+		proxy, err := proxyFunc(provider.ProxyURL, provider.NoProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+	if provider.TLS == nil {
+		return transport, nil
+	}
+
+	tlsConfig := transport.TLSClientConfig.Clone()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	cfg := provider.TLS
+
+	if cfg.MinVersion != "" {
+		version, err := tlsVersion(cfg.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = version
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	if cfg.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// newTLSConfig builds the client TLS settings for provider connections
+func newTLSConfig(cfg config.TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	version, err := tlsVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = version
+
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsVersion parses a minimum TLS version setting
+func tlsVersion(value string) (uint16, error) {
+	switch value {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls_min_version: %s", value)
+}
+
+// loadCAFile returns the system roots plus the certificates in a PEM file
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca_file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ca_file %s contains no certificates", path)
+	}
+	return pool, nil
+}
+
+// parseDuration parses an optional duration setting; empty means zero (no limit)
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many buckets a Keyed limiter holds before dropping
+// ones that have refilled, which behave the same as new buckets
+const maxIdleBuckets = 10000
+
+// Backend keeps rate limit state outside the process, so that every gateway
+// instance draws on the same limits. Allow takes a token from the bucket
+// named key, which has the given rate and burst.
+type Backend interface {
+	Allow(key string, perMinute, burst int) (bool, error)
+}
+
+// Bucket is a token bucket refilled at a steady per-minute rate
+type Bucket struct {
+	mu       sync.Mutex
+	rate     float64 // Tokens per second
+	burst    float64
+	tokens   float64
+	last     time.Time
+	rejected int64
+
+	// Shared state, when limits hold across instances
+	backend Backend
+	key     string
+}
+
+// NewBucket creates a full bucket. A burst of 0 allows a minute's worth of
+// requests at once.
+func NewBucket(perMinute, burst int) *Bucket {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &Bucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Share keeps the bucket's tokens in backend under key. While the backend
+// fails, the bucket falls back to limiting this instance alone.
+func (b *Bucket) Share(backend Backend, key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backend = backend
+	b.key = key
+}
+
+// Allow takes a token if one is available
+func (b *Bucket) Allow() bool {
+	b.mu.Lock()
+	backend, key := b.backend, b.key
+	b.mu.Unlock()
+	if backend != nil {
+		if allowed, err := backend.Allow(key, int(math.Round(b.rate*60)), int(b.burst)); err == nil {
+			if !allowed {
+				b.mu.Lock()
+				b.rejected++
+				b.mu.Unlock()
+			}
+			return allowed
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		b.rejected++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// full reports whether the bucket has refilled completely
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Status returns the bucket's limits and rejections for status endpoints
+func (b *Bucket) Status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"requests_per_minute": b.rate * 60,
+		"burst":               b.burst,
+		"rejected":            b.rejected,
+		"shared":              b.backend != nil,
+	}
+}
+
+// Keyed applies the same limit separately to each key, e.g. each user
+type Keyed struct {
+	perMinute int
+	burst     int
+
+	mu       sync.Mutex
+	buckets  map[string]*Bucket
+	rejected int64
+
+	// Shared state, when limits hold across instances
+	backend Backend
+	prefix  string
+}
+
+// NewKeyed creates a limiter that gives every key its own bucket
+func NewKeyed(perMinute, burst int) *Keyed {
+	return &Keyed{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[string]*Bucket),
+	}
+}
+
+// Share keeps every key's tokens in backend, under prefix followed by the
+// key. While the backend fails, keys fall back to limiting this instance alone.
+func (k *Keyed) Share(backend Backend, prefix string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.backend = backend
+	k.prefix = prefix
+}
+
+// Allow takes a token from the key's bucket if one is available
+func (k *Keyed) Allow(key string) bool {
+	k.mu.Lock()
+	backend, prefix := k.backend, k.prefix
+	k.mu.Unlock()
+	if backend != nil {
+		burst := k.burst
+		if burst <= 0 {
+			burst = k.perMinute
+		}
+		if allowed, err := backend.Allow(prefix+key, k.perMinute, burst); err == nil {
+			if !allowed {
+				k.mu.Lock()
+				k.rejected++
+				k.mu.Unlock()
+			}
+			return allowed
+		}
+	}
+
+	k.mu.Lock()
+	bucket, ok := k.buckets[key]
+	if !ok {
+		if len(k.buckets) >= maxIdleBuckets {
+			k.sweep()
+		}
+		bucket = NewBucket(k.perMinute, k.burst)
+		k.buckets[key] = bucket
+	}
+	k.mu.Unlock()
+
+	if bucket.Allow() {
+		return true
+	}
+	k.mu.Lock()
+	k.rejected++
+	k.mu.Unlock()
+	return false
+}
+
+// sweep drops buckets that have refilled. Callers hold k.mu.
+func (k *Keyed) sweep() {
+	now := time.Now()
+	for key, bucket := range k.buckets {
+		if bucket.full(now) {
+			delete(k.buckets, key)
+		}
+	}
+}
+
+// Status returns the limit and rejections across keys for status endpoints
+func (k *Keyed) Status() map[string]interface{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	burst := k.burst
+	if burst <= 0 {
+		burst = k.perMinute
+	}
+	return map[string]interface{}{
+		"requests_per_minute": k.perMinute,
+		"burst":               burst,
+		"active_keys":         len(k.buckets), // Keys limited in memory; with a shared backend, only while it fails
+		"rejected":            k.rejected,
+		"shared":              k.backend != nil,
+	}
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// allowN calls allow n times and returns how many calls were allowed
+func allowN(n int, allow func() bool) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if allow() {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		name        string
+		perMinute   int
+		burst       int
+		elapsed     time.Duration // Time passed after the burst is used up
+		wantBurst   int
+		wantRefills int
+	}{
+		{name: "burst", perMinute: 60, burst: 5, elapsed: 0, wantBurst: 5, wantRefills: 0},
+		{name: "default burst is a minute's worth", perMinute: 30, burst: 0, elapsed: 0, wantBurst: 30, wantRefills: 0},
+		{name: "refills at the rate", perMinute: 60, burst: 5, elapsed: 3 * time.Second, wantBurst: 5, wantRefills: 3},
+		{name: "partial tokens don't count", perMinute: 30, burst: 5, elapsed: 3 * time.Second, wantBurst: 5, wantRefills: 1},
+		{name: "refill capped at burst", perMinute: 60, burst: 5, elapsed: time.Hour, wantBurst: 5, wantRefills: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBucket(tt.perMinute, tt.burst)
+			if got := allowN(tt.wantBurst+10, b.Allow); got != tt.wantBurst {
+				t.Fatalf("allowed %d of a full bucket, want %d", got, tt.wantBurst)
+			}
+
+			b.mu.Lock()
+			b.last = b.last.Add(-tt.elapsed)
+			b.mu.Unlock()
+			if got := allowN(tt.wantBurst+10, b.Allow); got != tt.wantRefills {
+				t.Fatalf("allowed %d after %s, want %d", got, tt.elapsed, tt.wantRefills)
+			}
+
+			wantRejected := int64(20 + tt.wantBurst - tt.wantRefills)
+			if rejected := b.Status()["rejected"]; rejected != wantRejected {
+				t.Fatalf("rejected = %v, want %d", rejected, wantRejected)
+			}
+		})
+	}
+}
+
+func TestBucketFull(t *testing.T) {
+	b := NewBucket(60, 2)
+	now := time.Now()
+	if !b.full(now) {
+		t.Fatal("new bucket is not full")
+	}
+	b.Allow()
+	if b.full(now) {
+		t.Fatal("bucket with a token taken is full")
+	}
+	if !b.full(now.Add(2 * time.Second)) {
+		t.Fatal("bucket is not full after refilling")
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	k := NewKeyed(60, 3)
+
+	tests := []struct {
+		key  string
+		n    int
+		want int
+	}{
+		{key: "alice", n: 5, want: 3},
+		{key: "bob", n: 2, want: 2},
+		{key: "alice", n: 1, want: 0},
+		{key: "bob", n: 2, want: 1},
+		{key: "", n: 4, want: 3},
+	}
+	for _, tt := range tests {
+		if got := allowN(tt.n, func() bool { return k.Allow(tt.key) }); got != tt.want {
+			t.Fatalf("Allow(%q) allowed %d of %d, want %d", tt.key, got, tt.n, tt.want)
+		}
+	}
+
+	status := k.Status()
+	if status["active_keys"] != 3 {
+		t.Fatalf("active_keys = %v, want 3", status["active_keys"])
+	}
+	if status["rejected"] != int64(5) {
+		t.Fatalf("rejected = %v, want 5", status["rejected"])
+	}
+	if status["burst"] != 3 {
+		t.Fatalf("burst = %v, want 3", status["burst"])
+	}
+}
+
+func TestKeyedSweep(t *testing.T) {
+	k := NewKeyed(60, 1)
+	k.Allow("busy")
+	k.mu.Lock()
+	k.buckets["idle"] = NewBucket(60, 1)
+	k.sweep()
+	_, busy := k.buckets["busy"]
+	_, idle := k.buckets["idle"]
+	k.mu.Unlock()
+
+	if !busy {
+		t.Fatal("sweep dropped a bucket that hasn't refilled")
+	}
+	if idle {
+		t.Fatal("sweep kept a full bucket")
+	}
+}
+
+// fakeBackend answers from a fixed result, recording the keys asked for
+type fakeBackend struct {
+	allowed bool
+	err     error
+	keys    []string
+}
+
+func (f *fakeBackend) Allow(key string, perMinute, burst int) (bool, error) {
+	f.keys = append(f.keys, key)
+	return f.allowed, f.err
+}
+
+func TestShared(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend *fakeBackend
+		want    int // Allowed of 5 requests with a burst of 2
+	}{
+		{name: "backend allows", backend: &fakeBackend{allowed: true}, want: 5},
+		{name: "backend rejects", backend: &fakeBackend{allowed: false}, want: 0},
+		{name: "backend failing falls back to local limit", backend: &fakeBackend{err: errors.New("down")}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run("bucket "+tt.name, func(t *testing.T) {
+			b := NewBucket(60, 2)
+			b.Share(tt.backend, "tenant:acme")
+			if got := allowN(5, b.Allow); got != tt.want {
+				t.Fatalf("allowed %d, want %d", got, tt.want)
+			}
+			if tt.backend.keys[0] != "tenant:acme" {
+				t.Fatalf("backend key = %q, want %q", tt.backend.keys[0], "tenant:acme")
+			}
+		})
+		t.Run("keyed "+tt.name, func(t *testing.T) {
+			tt.backend.keys = nil
+			k := NewKeyed(60, 2)
+			k.Share(tt.backend, "user:")
+			if got := allowN(5, func() bool { return k.Allow("alice") }); got != tt.want {
+				t.Fatalf("allowed %d, want %d", got, tt.want)
+			}
+			if tt.backend.keys[0] != "user:alice" {
+				t.Fatalf("backend key = %q, want %q", tt.backend.keys[0], "user:alice")
+			}
+		})
+	}
+}
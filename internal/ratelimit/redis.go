@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/redis"
+)
+
+const defaultKeyPrefix = "flash-gateway:ratelimit:"
+
+// failureBackoff is how long requests skip Redis after it fails, before one
+// of them tries it again
+const failureBackoff = 5 * time.Second
+
+// errBackingOff is returned while Redis is skipped after a failure
+var errBackingOff = errors.New("redis failed recently, retrying later")
+
+// gcraScript is a token bucket as GCRA: the key holds the theoretical arrival
+// time of the next request in microseconds, and a request is allowed when
+// that is no further ahead than the burst allows. Time comes from the Redis
+// server, so instances' clocks don't need to agree.
+const gcraScript = `
+if redis.replicate_commands then redis.replicate_commands() end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tolerance = interval * tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then tat = now end
+local new_tat = tat + interval
+if new_tat - now > tolerance then return 0 end
+redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return 1
+`
+
+// RedisBackend keeps rate limits in Redis, shared by every gateway instance
+// using the same server and key prefix
+type RedisBackend struct {
+	client *redis.Client
+	prefix string
+
+	mu       sync.Mutex
+	failing  bool
+	failures int64
+	lastErr  string
+	retryAt  time.Time // While failing, when the next request may try Redis
+}
+
+// NewRedisBackend creates a Redis backend from configuration. Redis is first
+// contacted by the first request limited.
+func NewRedisBackend(cfg config.RateLimitsConfig) (*RedisBackend, error) {
+	client, err := redis.New(cfg.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis config: %w", err)
+	}
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = defaultKeyPrefix
+	}
+	return &RedisBackend{client: client, prefix: prefix}, nil
+}
+
+// Allow takes a token from the bucket named key
+func (b *RedisBackend) Allow(key string, perMinute, burst int) (bool, error) {
+	if perMinute <= 0 {
+		return false, fmt.Errorf("requests per minute must be positive")
+	}
+	interval := int64(time.Minute/time.Microsecond) / int64(perMinute)
+	if interval < 1 {
+		interval = 1
+	}
+	if !b.shouldTry() {
+		return false, errBackingOff
+	}
+	reply, err := b.client.Eval(context.Background(), gcraScript, []string{b.prefix + key}, interval, burst)
+	if err == nil {
+		if _, ok := reply.(int64); !ok {
+			err = fmt.Errorf("unexpected reply %v", reply)
+		}
+	}
+	b.record(err)
+	if err != nil {
+		return false, err
+	}
+	return reply.(int64) == 1, nil
+}
+
+// shouldTry reports whether a request should go to Redis. While it is
+// failing, one request tries it per backoff period and the rest are limited
+// locally without waiting on it.
+func (b *RedisBackend) shouldTry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.failing {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.retryAt) {
+		return false
+	}
+	b.retryAt = now.Add(failureBackoff)
+	return true
+}
+
+// record logs when Redis starts failing and when it recovers, rather than
+// every failed request
+func (b *RedisBackend) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.failures++
+		b.lastErr = err.Error()
+		if !b.failing {
+			log.Printf("[RATELIMIT] Redis at %s failed, limiting each instance on its own: %v", b.client.Addr(), err)
+		}
+		b.failing = true
+		b.retryAt = time.Now().Add(failureBackoff)
+		return
+	}
+	if b.failing {
+		log.Printf("[RATELIMIT] Redis at %s recovered, limits are shared again", b.client.Addr())
+	}
+	b.failing = false
+}
+
+// Ping checks that Redis answers, for readiness probes
+func (b *RedisBackend) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx)
+}
+
+// Close closes the backend's connections
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
+
+// Status returns the backend's health, address and last error for the admin
+// API. It is not served on the public /status endpoint.
+func (b *RedisBackend) Status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{
+		"backend":    "redis",
+		"addr":       b.client.Addr(),
+		"key_prefix": b.prefix,
+		"healthy":    !b.failing,
+		"failures":   b.failures,
+	}
+	if b.lastErr != "" {
+		status["last_error"] = b.lastErr
+	}
+	return status
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+func TestRedisBackendBackoff(t *testing.T) {
+	// An address nothing listens on, so every command fails at once
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	b, err := NewRedisBackend(config.RateLimitsConfig{Redis: config.RedisConfig{Addr: addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, err := b.Allow("key", 60, 1); err == nil || errors.Is(err, errBackingOff) {
+		t.Fatalf("first Allow() error = %v, want a connection error", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := b.Allow("key", 60, 1); !errors.Is(err, errBackingOff) {
+			t.Fatalf("Allow() while failing error = %v, want %v", err, errBackingOff)
+		}
+	}
+	if failures := b.Status()["failures"]; failures != int64(1) {
+		t.Fatalf("failures = %v, want 1", failures)
+	}
+
+	// Once the backoff is up, one request tries Redis again
+	b.mu.Lock()
+	b.retryAt = time.Now().Add(-time.Second)
+	b.mu.Unlock()
+	if _, err := b.Allow("key", 60, 1); err == nil || errors.Is(err, errBackingOff) {
+		t.Fatalf("Allow() after backoff error = %v, want a connection error", err)
+	}
+	if _, err := b.Allow("key", 60, 1); !errors.Is(err, errBackingOff) {
+		t.Fatalf("Allow() after a failed retry error = %v, want %v", err, errBackingOff)
+	}
+}
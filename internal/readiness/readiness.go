@@ -0,0 +1,233 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Overall readiness states
+const (
+	StatusReady    = "ready"
+	StatusDegraded = "degraded" // Only non-critical dependencies are failing
+	StatusNotReady = "not_ready"
+)
+
+// Dependency states
+const (
+	CheckOK      = "ok"
+	CheckFailing = "failing"
+)
+
+const (
+	defaultTimeout             = 2 * time.Second
+	defaultMaxQueueUtilization = 0.9
+)
+
+// CheckFunc probes one dependency, returning details worth reporting
+type CheckFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// Result is the outcome of probing one dependency
+type Result struct {
+	Status    string                 `json:"status"`
+	Critical  bool                   `json:"critical"` // A failure makes the gateway not ready
+	LatencyMs int64                  `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the outcome of a readiness check
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready reports whether every critical dependency passed
+func (r Report) Ready() bool {
+	return r.Status != StatusNotReady
+}
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Checker probes the gateway's dependencies to decide whether it can take traffic
+type Checker struct {
+	timeout             time.Duration
+	maxQueueUtilization float64
+	probeProviders      bool
+	requireProviders    bool
+	checks              []check
+}
+
+// New creates a readiness checker from configuration
+func New(cfg config.HealthConfig) (*Checker, error) {
+	c := &Checker{
+		timeout:             defaultTimeout,
+		maxQueueUtilization: cfg.MaxQueueUtilization,
+		probeProviders:      cfg.Providers,
+		requireProviders:    cfg.RequireProviders,
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid health timeout %q", cfg.Timeout)
+		}
+		c.timeout = timeout
+	}
+	if c.maxQueueUtilization < 0 || c.maxQueueUtilization > 1 {
+		return nil, fmt.Errorf("max_queue_utilization must be between 0 and 1")
+	}
+	if c.maxQueueUtilization == 0 {
+		c.maxQueueUtilization = defaultMaxQueueUtilization
+	}
+	return c, nil
+}
+
+// Add registers a dependency probe. A failing critical dependency makes the
+// gateway not ready; any other only degrades it.
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+// AddDatabase checks the log database answers a ping
+func (c *Checker) AddDatabase(ping func(ctx context.Context) error) {
+	c.Add("database", true, func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, ping(ctx)
+	})
+}
+
+// AddLogQueue checks the log writer's queue has headroom, so logs aren't dropped
+func (c *Checker) AddLogQueue(utilization func() float64) {
+	limit := c.maxQueueUtilization
+	c.Add("log_queue", true, func(ctx context.Context) (map[string]interface{}, error) {
+		used := utilization()
+		details := map[string]interface{}{"utilization": used, "max_utilization": limit}
+		if used >= limit {
+			return details, fmt.Errorf("log queue is %.0f%% full", used*100)
+		}
+		return details, nil
+	})
+}
+
+// AddProvider checks a provider is reachable, by its health checker's verdict
+// when it has one and otherwise by opening a TCP connection to its base URL,
+// or to its egress proxy. Skipped unless provider probes are enabled.
+func (c *Checker) AddProvider(provider config.ProviderConfig, healthy func() bool) error {
+	if !c.probeProviders {
+		return nil
+	}
+	name := "provider:" + provider.Name
+	if healthy != nil {
+		c.Add(name, c.requireProviders, func(ctx context.Context) (map[string]interface{}, error) {
+			if !healthy() {
+				return nil, fmt.Errorf("provider is ejected by its health check")
+			}
+			return nil, nil
+		})
+		return nil
+	}
+
+	target := provider.BaseURL
+	if provider.ProxyURL != "" {
+		target = provider.ProxyURL
+	}
+	address, err := dialAddress(target)
+	if err != nil {
+		return fmt.Errorf("provider %s: %w", provider.Name, err)
+	}
+	c.Add(name, c.requireProviders, func(ctx context.Context) (map[string]interface{}, error) {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return map[string]interface{}{"address": address}, err
+		}
+		conn.Close()
+		return map[string]interface{}{"address": address}, nil
+	})
+	return nil
+}
+
+// Check probes every dependency concurrently, each bounded by the timeout
+func (c *Checker) Check(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, dependency := range c.checks {
+		wg.Add(1)
+		go func(i int, dependency check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, dependency)
+		}(i, dependency)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]Result, len(c.checks))}
+	for i, dependency := range c.checks {
+		result := results[i]
+		report.Checks[dependency.name] = result
+		if result.Status == CheckOK {
+			continue
+		}
+		if dependency.critical {
+			report.Status = StatusNotReady
+		} else if report.Status == StatusReady {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run probes one dependency, treating a probe that outlives the timeout as failed
+func (c *Checker) run(ctx context.Context, dependency check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type outcome struct {
+		details map[string]interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		details, err := dependency.fn(ctx)
+		done <- outcome{details, err}
+	}()
+
+	result := Result{Status: CheckOK, Critical: dependency.critical}
+	select {
+	case o := <-done:
+		result.Details = o.details
+		if o.err != nil {
+			result.Status = CheckFailing
+			result.Error = o.err.Error()
+		}
+	case <-ctx.Done():
+		result.Status = CheckFailing
+		result.Error = fmt.Sprintf("no answer within %s", c.timeout)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// dialAddress returns the host:port a URL's connections go to
+func dialAddress(raw string) (string, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		return "", fmt.Errorf("invalid URL %q", raw)
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
@@ -0,0 +1,316 @@
+// Package redis is a minimal Redis client for state gateway instances share,
+// such as rate limit counters. It speaks RESP2 over a small connection pool
+// and supports only what the gateway needs: commands, their replies and
+// scripts.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+const (
+	defaultTimeout  = 100 * time.Millisecond
+	defaultPoolSize = 16
+)
+
+// Error is an error reply from the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// ErrNil is returned for a nil reply, such as GET of a missing key
+var ErrNil = errors.New("redis: nil reply")
+
+// Client sends commands to one Redis server. It is safe for concurrent use.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	timeout  time.Duration
+	idle     chan *conn
+}
+
+// conn is one connection to the server
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// New creates a client from configuration. Connections are made on first use.
+func New(cfg config.RedisConfig) (*Client, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("addr is required")
+	}
+	if cfg.DB < 0 || cfg.PoolSize < 0 {
+		return nil, fmt.Errorf("db and pool_size must not be negative")
+	}
+	c := &Client{
+		addr:     cfg.Addr,
+		username: cfg.Username,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  defaultTimeout,
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", cfg.Timeout)
+		}
+		c.timeout = timeout
+	}
+	if cfg.TLS {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid addr %q: %w", cfg.Addr, err)
+		}
+		c.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	poolSize := cfg.PoolSize
+	if poolSize == 0 {
+		poolSize = defaultPoolSize
+	}
+	c.idle = make(chan *conn, poolSize)
+	return c, nil
+}
+
+// Addr returns the server's address
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Do sends a command and returns its reply: a string, int64, []interface{}
+// of replies, or ErrNil. Error replies are returned as Error. The command
+// times out after the configured timeout unless ctx ends first.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// An idle connection may have been closed by the server since its last
+	// use, so a failure on one is retried once on a new connection
+	for attempt := 0; ; attempt++ {
+		cn, reused, err := c.get(ctx, attempt == 0)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := cn.do(ctx, args)
+		if err != nil {
+			var replyErr Error
+			if !errors.As(err, &replyErr) && !errors.Is(err, ErrNil) {
+				// The connection may be mid-reply; don't reuse it
+				cn.Close()
+				if reused && ctx.Err() == nil {
+					continue
+				}
+				return nil, err
+			}
+		}
+		c.put(cn)
+		return reply, err
+	}
+}
+
+// Eval runs a Lua script, by its SHA1 once the server has cached it
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	sum := sha1.Sum([]byte(script))
+	command := make([]interface{}, 0, 3+len(keys)+len(args))
+	command = append(command, "EVALSHA", hex.EncodeToString(sum[:]), len(keys))
+	for _, key := range keys {
+		command = append(command, key)
+	}
+	command = append(command, args...)
+
+	reply, err := c.Do(ctx, command...)
+	var replyErr Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		command[0], command[1] = "EVAL", script
+		return c.Do(ctx, command...)
+	}
+	return reply, err
+}
+
+// Ping checks that the server answers
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes idle connections. Connections in use are closed when returned.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get returns an idle connection when idle is set and one is available, or
+// dials a new one, reporting which it did
+func (c *Client) get(ctx context.Context, idle bool) (*conn, bool, error) {
+	if idle {
+		select {
+		case cn := <-c.idle:
+			return cn, true, nil
+		default:
+		}
+	}
+	cn, err := c.dial(ctx)
+	return cn, false, err
+}
+
+// dial connects, authenticates and selects the database
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		tlsConn := tls.Client(netConn, c.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+	cn := &conn{Conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+
+	if c.password != "" {
+		auth := []interface{}{"AUTH", c.password}
+		if c.username != "" {
+			auth = []interface{}{"AUTH", c.username, c.password}
+		}
+		if _, err := cn.do(ctx, auth); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(ctx, []interface{}{"SELECT", c.db}); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis select: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// do writes a command and reads its reply
+func (cn *conn) do(ctx context.Context, args []interface{}) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	}
+	if err := cn.write(args); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+// write sends a command as a RESP array of bulk strings
+func (cn *conn) write(args []interface{}) error {
+	cn.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+		cn.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+		cn.w.WriteString(s)
+		cn.w.WriteString("\r\n")
+	}
+	return cn.w.Flush()
+}
+
+// read parses one RESP reply
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(cn.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if count < 0 {
+			return nil, ErrNil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			item, err := cn.read()
+			if err != nil && !errors.Is(err, ErrNil) {
+				var replyErr Error
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				item = replyErr
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
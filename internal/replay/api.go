@@ -0,0 +1,59 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/google/uuid"
+)
+
+// Register mounts the replay endpoint on the admin server. Replays call
+// providers and cost money, so they need the admin role.
+func (p *Replayer) Register(server *admin.Server) {
+	server.HandleFuncRole("/admin/replay/", admin.RoleAdmin, p.replayHandler)
+}
+
+// replayHandler replays a logged request: POST /admin/replay/{log_id} with
+// optional {"provider": "...", "model": "...", "api_key": "..."}
+func (p *Replayer) replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/replay/")
+	if _, err := uuid.Parse(id); err != nil {
+		admin.WriteError(w, http.StatusNotFound, "log id required")
+		return
+	}
+
+	var opts Options
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&opts); err != nil && err != io.EOF {
+		admin.WriteError(w, http.StatusBadRequest, "invalid replay options: "+err.Error())
+		return
+	}
+
+	audit.Record(r.Context(), "request.replayed", id, nil, nil)
+	audit.Detail(r.Context(), "provider", opts.Provider)
+	audit.Detail(r.Context(), "model", opts.Model)
+
+	// Providers can take longer to answer than the admin listener's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	result, err := p.Replay(r.Context(), id, opts)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		admin.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrTruncated), errors.Is(err, ErrNoBody), errors.Is(err, ErrNotJSON):
+		admin.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+	default:
+		admin.WriteJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,191 @@
+// Package replay re-sends stored requests through the gateway, optionally to
+// another provider or model, so prompt and model changes can be regression
+// tested against real traffic. Replays are logged like any other request and
+// tagged with the log they repeat.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/handlers"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+)
+
+var (
+	// ErrNotFound is returned when no log has the given ID
+	ErrNotFound = errors.New("log not found")
+	// ErrTruncated is returned when the log holds only the start of the request body
+	ErrTruncated = errors.New("request body was truncated in the log, raise logging.max_body_size to replay it")
+	// ErrNoBody is returned when the log has no textual request body to send
+	ErrNoBody = errors.New("log has no request body to replay")
+	// ErrNotJSON is returned when a model is given for a request body that is not a JSON object
+	ErrNotJSON = errors.New("cannot replace the model of a request that is not a JSON object")
+)
+
+// skippedHeaders are logged request headers a replay does not copy
+var skippedHeaders = map[string]bool{
+	"content-length":    true,
+	"accept-encoding":   true, // Replays read the response, so take it uncompressed
+	"connection":        true,
+	"host":              true,
+	"transfer-encoding": true,
+}
+
+// Options change what a replay sends
+type Options struct {
+	Provider string `json:"provider,omitempty"` // Send to this provider instead of the endpoint's own
+	Model    string `json:"model,omitempty"`    // Replace the request's model
+
+	// APIKey authenticates upstream when the original request's tenant has no
+	// credential for the provider. Logged keys are redacted, so the original
+	// key is never reused.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Outcome is how a request turned out
+type Outcome struct {
+	StatusCode *int    `json:"status_code,omitempty"`
+	LatencyMs  *int64  `json:"latency_ms,omitempty"`
+	Response   *string `json:"response,omitempty"`
+}
+
+// Result compares a replay with the request it repeated
+type Result struct {
+	Of        string  `json:"of"`                   // ID of the replayed log
+	RequestID string  `json:"request_id,omitempty"` // Request ID of the replay, for finding its log
+	Replay    Outcome `json:"replay"`
+	Original  Outcome `json:"original"`
+}
+
+// Replayer replays logged requests through a gateway handler
+type Replayer struct {
+	backend storage.StorageBackend
+	handler http.Handler
+	tenants *tenant.Resolver
+}
+
+// New creates a replayer that reads logs from backend and sends requests to
+// handler. Replays run as the original request's tenant when tenants is set.
+func New(backend storage.StorageBackend, handler http.Handler, tenants *tenant.Resolver) *Replayer {
+	return &Replayer{backend: backend, handler: handler, tenants: tenants}
+}
+
+// Replay re-sends the request of a stored log and waits for the response
+func (p *Replayer) Replay(ctx context.Context, id string, opts Options) (*Result, error) {
+	entry, err := p.backend.GetRequestLogByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+
+	body, err := requestBody(entry, opts.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = handlers.WithReplay(ctx, &handlers.Replay{
+		Of:        entry.ID.String(),
+		RequestID: entry.RequestID.String(),
+		Provider:  opts.Provider,
+		Model:     opts.Model,
+	})
+	if entry.TenantID != nil && p.tenants != nil {
+		if t := p.tenants.Get(*entry.TenantID); t != nil {
+			ctx = tenant.WithTenant(ctx, t)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, entry.Method, entry.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build replay request: %w", err)
+	}
+	for name, value := range entry.RequestHeaders {
+		if skippedHeaders[strings.ToLower(name)] {
+			continue
+		}
+		for _, v := range headerValues(value) {
+			if v != "[REDACTED]" {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+	if opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+		req.Header.Del("x-api-key")
+	}
+
+	start := time.Now()
+	recorder := middleware.NewRecorder()
+	p.handler.ServeHTTP(recorder, req)
+	latency := time.Since(start).Milliseconds()
+
+	status, response := recorder.Status(), string(recorder.Body())
+	return &Result{
+		Of:        entry.ID.String(),
+		RequestID: recorder.Header().Get("X-Flash-Request-ID"),
+		Replay: Outcome{
+			StatusCode: &status,
+			LatencyMs:  &latency,
+			Response:   &response,
+		},
+		Original: Outcome{
+			StatusCode: entry.StatusCode,
+			LatencyMs:  entry.LatencyMs,
+			Response:   entry.ResponseBody,
+		},
+	}, nil
+}
+
+// requestBody returns the logged request body, with its model replaced when one is given
+func requestBody(entry *storage.RequestLog, model string) ([]byte, error) {
+	if entry.RequestBody == nil {
+		if entry.Method == http.MethodGet || entry.Method == http.MethodDelete {
+			return nil, nil
+		}
+		return nil, ErrNoBody
+	}
+	body := []byte(*entry.RequestBody)
+	if size, ok := entry.Metadata["request_size"].(float64); ok && int(size) > len(body) {
+		return nil, ErrTruncated
+	}
+	if model == "" {
+		return body, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, ErrNotJSON
+	}
+	fields["model"], _ = json.Marshal(model)
+	return json.Marshal(fields)
+}
+
+// headerValues returns a logged header's values, stored as a string or a list
+func headerValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package respond
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// JSON writes v as a JSON response with the given status code
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// Error writes an error in the OpenAI error format clients already handle.
+// 5xx statuses are server errors and the rest invalid requests; an empty
+// code is written as null.
+func Error(w http.ResponseWriter, status int, message, code string) {
+	errorType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errorType = "server_error"
+	}
+	var errorCode interface{}
+	if code != "" {
+		errorCode = code
+	}
+	JSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errorType,
+			"param":   nil,
+			"code":    errorCode,
+		},
+	})
+}
@@ -0,0 +1,200 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// DefaultMiddleware is the chain a listener gets without a middleware list,
+// outermost first. Middleware whose feature is disabled is skipped.
+var DefaultMiddleware = []string{
+	"recovery",     // Catches panics
+	"logger",       // Logs requests
+	"cors",         // CORS headers and preflights
+	"content_type", // Sets content type
+	"brownout",     // Counts in-flight requests for brownout load evaluation
+	"plugins",      // Middleware registered by plugins
+	"capture",      // Captures final request/response data for request logs
+
+	// Only proxied requests, and the endpoints the gateway serves itself, go
+	// through the rest
+	"ip_filter",       // Rejects unknown networks before any other work is done for them
+	"drain",           // Turns new requests away while draining; queued ones count as in flight
+	"load_shed",       // Sheds low-priority requests before authentication or queueing work
+	"auth",            // JWT and virtual key authentication, before anything keys off the caller
+	"tenants",         // Resolves and rate limits tenants before admission
+	"routing",         // Matches routing rules after a tenant's limit applies
+	"user_rate_limit", // Limits each end user, counting duplicates too
+	"dedup",           // Coalesces duplicates before admission, without taking queue slots
+	"admission",       // Queues proxied requests; health and status stay responsive
+}
+
+// proxyMiddleware holds the middleware that wraps proxied requests only
+var proxyMiddleware = map[string]bool{
+	"ip_filter": true, "drain": true, "load_shed": true, "auth": true, "tenants": true,
+	"routing": true, "user_rate_limit": true, "dedup": true, "admission": true,
+}
+
+// isMiddleware reports whether name is a known middleware
+func isMiddleware(name string) bool {
+	for _, known := range DefaultMiddleware {
+		if known == name {
+			return true
+		}
+	}
+	return false
+}
+
+// chain is a listener's middleware, split into what wraps every request and
+// what wraps proxied requests, each outermost first
+type chain struct {
+	listener []string
+	proxy    []string
+}
+
+// parseChain validates a listener's middleware list. Middleware that wraps
+// every request must come before middleware that wraps proxied requests,
+// since the latter runs inside the gateway's own routing.
+func parseChain(names []string) (chain, error) {
+	if len(names) == 0 {
+		names = DefaultMiddleware
+	}
+	var c chain
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !isMiddleware(name) {
+			return chain{}, fmt.Errorf("unknown middleware %q (known: %s)", name, strings.Join(DefaultMiddleware, ", "))
+		}
+		if seen[name] {
+			return chain{}, fmt.Errorf("middleware %q is listed twice", name)
+		}
+		seen[name] = true
+
+		if proxyMiddleware[name] {
+			c.proxy = append(c.proxy, name)
+		} else if len(c.proxy) > 0 {
+			return chain{}, fmt.Errorf("middleware %q wraps every request and must come before %q, which wraps proxied requests", name, c.proxy[0])
+		} else {
+			c.listener = append(c.listener, name)
+		}
+	}
+	return c, nil
+}
+
+// has reports whether the chain includes name
+func (c chain) has(name string) bool {
+	for _, n := range c.proxy {
+		if n == name {
+			return true
+		}
+	}
+	for _, n := range c.listener {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// warnDropped logs features that are enabled but that a listener's chain
+// leaves out, so they don't apply to its requests
+func (r *Router) warnDropped(listener string, c chain) {
+	enabled := map[string]bool{
+		"auth":            r.jwtAuth != nil || r.virtualKeys != nil,
+		"ip_filter":       r.ipFilter != nil,
+		"tenants":         r.tenants != nil,
+		"user_rate_limit": r.userLimit != nil,
+		"admission":       r.admission != nil,
+		"capture":         r.capture != nil,
+	}
+	for _, name := range DefaultMiddleware {
+		if enabled[name] && !c.has(name) {
+			log.Printf("Warning: %s is enabled but the %s middleware chain leaves it out", name, listener)
+		}
+	}
+}
+
+// listenerLayer returns the middleware name stands for on every request, or
+// nil when its feature is disabled
+func (r *Router) listenerLayer(name string) []func(http.Handler) http.Handler {
+	switch name {
+	case "recovery":
+		return []func(http.Handler) http.Handler{middleware.Recovery}
+	case "logger":
+		return []func(http.Handler) http.Handler{middleware.Logger}
+	case "cors":
+		return []func(http.Handler) http.Handler{r.cors.Handle}
+	case "content_type":
+		return []func(http.Handler) http.Handler{middleware.ContentType}
+	case "brownout":
+		if r.brownout != nil {
+			return []func(http.Handler) http.Handler{r.brownout.Track}
+		}
+	case "plugins":
+		return middleware.Registered()
+	case "capture":
+		if r.capture != nil {
+			return []func(http.Handler) http.Handler{r.capture.Capture}
+		}
+	}
+	return nil
+}
+
+// proxyLayer returns the middleware name stands for on proxied requests, or
+// nil when its feature is disabled
+func (r *Router) proxyLayer(name string) []func(http.Handler) http.Handler {
+	var layers []func(http.Handler) http.Handler
+	switch name {
+	case "ip_filter":
+		if r.ipFilter != nil {
+			layers = append(layers, r.ipFilter.Middleware)
+		}
+	case "drain":
+		layers = append(layers, r.drain.Track)
+	case "load_shed":
+		if r.loadShed != nil {
+			layers = append(layers, r.loadShed.Middleware)
+		}
+	case "auth":
+		if r.jwtAuth != nil {
+			layers = append(layers, r.jwtAuth.Middleware)
+		}
+		if r.virtualKeys != nil {
+			layers = append(layers, r.virtualKeys.Middleware)
+		}
+	case "tenants":
+		if r.tenants != nil {
+			layers = append(layers, r.tenants.Middleware)
+		}
+	case "routing":
+		if r.routing != nil {
+			layers = append(layers, r.routing.Middleware)
+		}
+	case "user_rate_limit":
+		if r.userLimit != nil {
+			layers = append(layers, r.userLimit.Middleware)
+		}
+	case "dedup":
+		if r.dedup != nil {
+			layers = append(layers, r.dedup.Middleware)
+		}
+	case "admission":
+		if r.admission != nil {
+			layers = append(layers, r.admission.Middleware)
+		}
+	}
+	return layers
+}
+
+// apply wraps handler in the layers of names, the first outermost
+func apply(handler http.Handler, names []string, layer func(string) []func(http.Handler) http.Handler) http.Handler {
+	var middlewares []func(http.Handler) http.Handler
+	for _, name := range names {
+		middlewares = append(middlewares, layer(name)...)
+	}
+	return middleware.ApplyChain(handler, middlewares...)
+}
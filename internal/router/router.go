@@ -1,17 +1,46 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/NamanArora/flash-gateway/internal/admission"
+	"github.com/NamanArora/flash-gateway/internal/batch"
+	"github.com/NamanArora/flash-gateway/internal/brownout"
+	"github.com/NamanArora/flash-gateway/internal/budget"
 	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/cost"
+	"github.com/NamanArora/flash-gateway/internal/dedup"
+	"github.com/NamanArora/flash-gateway/internal/drain"
+	"github.com/NamanArora/flash-gateway/internal/feedback"
 	"github.com/NamanArora/flash-gateway/internal/guardrails"
 	"github.com/NamanArora/flash-gateway/internal/handlers"
+	"github.com/NamanArora/flash-gateway/internal/ipfilter"
+	"github.com/NamanArora/flash-gateway/internal/jwtauth"
+	"github.com/NamanArora/flash-gateway/internal/loadshed"
 	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/models"
+	"github.com/NamanArora/flash-gateway/internal/promptcache"
 	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/providers/mock"
 	"github.com/NamanArora/flash-gateway/internal/providers/openai"
+	"github.com/NamanArora/flash-gateway/internal/ratelimit"
+	"github.com/NamanArora/flash-gateway/internal/readiness"
+	"github.com/NamanArora/flash-gateway/internal/routing"
+	"github.com/NamanArora/flash-gateway/internal/secrets"
+	"github.com/NamanArora/flash-gateway/internal/slo"
 	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/tenant"
+	"github.com/NamanArora/flash-gateway/internal/tokenizer"
+	"github.com/NamanArora/flash-gateway/internal/transform"
+	"github.com/NamanArora/flash-gateway/internal/translate"
+	"github.com/NamanArora/flash-gateway/internal/userlimit"
+	"github.com/NamanArora/flash-gateway/internal/vkeys"
 )
 
 // Router manages HTTP routing and provider registration
@@ -20,6 +49,39 @@ type Router struct {
 	config       *config.Config
 	logWriter    *storage.AsyncLogWriter
 	capture      *middleware.CaptureMiddleware
+	redactor     *middleware.BodyRedactor // Logging redaction rules, when configured
+	brownout     *brownout.Controller
+	admission    *admission.Controller
+	loadShed     *loadshed.Shedder // Rejects low-priority requests under memory, goroutine or log queue pressure
+	dedup        *dedup.Coalescer // Shares one upstream call among identical requests, when enabled
+	userLimit    *userlimit.Limiter // Rate limits each end user of a shared key, when enabled
+	rateLimits   *ratelimit.RedisBackend // Rate limits shared across instances, when kept in Redis
+	budgets      *budget.Tracker
+	tenants      *tenant.Resolver
+	routing      *routing.Rules
+	jwtAuth      *jwtauth.Authenticator
+	virtualKeys  *vkeys.Authenticator
+	keyStore     vkeys.Store // Virtual keys created on the admin API
+	ipFilter     *ipfilter.Filter
+	cors         *middleware.CORSMiddleware
+	drain        *drain.Controller
+	readiness    *readiness.Checker // Dependency probes behind /health/ready
+	limiters     map[string]*providers.Limiter // provider -> in-flight request cap
+	health       map[string]*providers.HealthChecker
+	keyPools     map[string]*providers.KeyPool // provider -> upstream keys held by the gateway
+	secrets      *secrets.Manager              // Resolves and refreshes secret:// API keys
+	guardrails   *guardrails.Executor
+	slo          *slo.Tracker            // Latency and error rates per provider and model, when enabled
+	splitter     *transform.TrafficSplitter
+	tokenDrift   *tokenizer.DriftTracker // Prompt token estimate accuracy, when estimation is enabled
+	promptCache  *promptcache.Tracker    // Prompt cache hit rates per model, when enabled
+	feedback     http.Handler            // Client feedback endpoint, when enabled
+	batches      http.Handler            // Batch API files and batches endpoints, when enabled
+	models       http.Handler            // Model list synthesized from config, when enabled
+	transport    *http.Transport
+	providerTransports []*http.Transport // Copies of transport with provider-specific settings
+	serverChain  chain // Middleware of the HTTP listener
+	grpcChain    chain // Middleware of the gRPC listener
 }
 
 // New creates a new router instance
@@ -30,71 +92,585 @@ func New(cfg *config.Config, logWriter *storage.AsyncLogWriter) *Router {
 			Writer:          logWriter,
 			MaxBodySize:     cfg.Logging.MaxBodySize,
 			SkipHealthCheck: cfg.Logging.SkipHealthCheck,
+			Outbox:          cfg.Logging.Outbox,
 		})
 	}
 
+	proxyHandler := handlers.NewProxyHandler()
+	proxyHandler.SetStreamCheckpoint(cfg.Guardrails.StreamCheckpoint)
+	proxyHandler.SetUserHeader(cfg.Guardrails.UserHeader)
+	proxyHandler.SetWebSocket(cfg.WebSocket)
+	if cfg.Cost.Enabled {
+		proxyHandler.SetCostCalculator(cost.NewCalculator(cfg.Cost))
+	}
+	var tokenDrift *tokenizer.DriftTracker
+	if cfg.Tokens.Enabled {
+		tokenDrift = tokenizer.NewDriftTracker()
+		proxyHandler.SetTokenEstimation(tokenDrift, cfg.Tokens.ResponseHeader)
+	}
+	if cfg.Guardrails.Bypass.Enabled {
+		proxyHandler.SetBypassVerifier(guardrails.NewBypassVerifier(cfg.Guardrails.Bypass))
+	}
+
+	// Draining waits for in-flight requests, then flushes their logs
+	drainer := drain.New()
+	if logWriter != nil {
+		drainer.OnDrained(logWriter.Flush)
+	}
+
 	return &Router{
-		proxyHandler: handlers.NewProxyHandler(),
+		proxyHandler: proxyHandler,
 		config:       cfg,
 		logWriter:    logWriter,
 		capture:      capture,
+		drain:        drainer,
+		tokenDrift:   tokenDrift,
 	}
 }
 
 // Initialize sets up all providers and routes
 func (r *Router) Initialize() error {
+	// One pooled transport is shared by every provider
+	transport, err := providers.NewTransport(r.config.Transport)
+	if err != nil {
+		return fmt.Errorf("invalid transport config: %w", err)
+	}
+	r.transport = transport
+
+	// Keep rate limits in Redis so they hold across gateway instances
+	switch r.config.RateLimits.Backend {
+	case "", "memory":
+	case "redis":
+		backend, err := ratelimit.NewRedisBackend(r.config.RateLimits)
+		if err != nil {
+			return fmt.Errorf("invalid rate_limits config: %w", err)
+		}
+		r.rateLimits = backend
+	default:
+		return fmt.Errorf("invalid rate_limits config: unknown backend %q", r.config.RateLimits.Backend)
+	}
+
 	// Initialize providers based on configuration
+	bodyLimits := make(map[string]int64)
+	prefixes := make(map[string]string)
 	for _, providerConfig := range r.config.Providers {
 		var provider providers.Provider
 
-		switch providerConfig.Name {
+		// Namespaced providers are reached under their own path prefix
+		if prefix := providerConfig.PathPrefix; prefix != "" {
+			if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+				return fmt.Errorf("invalid path_prefix for %s: must start with / and not end with one", providerConfig.Name)
+			}
+			if other, dup := prefixes[prefix]; dup {
+				return fmt.Errorf("path_prefix %s is used by %s and %s", prefix, other, providerConfig.Name)
+			}
+			prefixes[prefix] = providerConfig.Name
+			r.proxyHandler.SetPathPrefix(providerConfig.Name, prefix)
+		}
+
+		// Validate endpoint settings that providers compile themselves
+		for _, endpoint := range providerConfig.Endpoints {
+			if err := providers.ValidateEndpoint(endpoint.Path); err != nil {
+				return fmt.Errorf("invalid endpoint path for %s %s: %w", providerConfig.Name, endpoint.Path, err)
+			}
+			r.proxyHandler.SetEndpointMethods(providerConfig.Name, endpoint.Path, endpoint.Methods)
+			if endpoint.Response != nil {
+				if _, err := transform.NewResponseTransformer(*endpoint.Response); err != nil {
+					return fmt.Errorf("invalid response transform for %s %s: %w", providerConfig.Name, endpoint.Path, err)
+				}
+			}
+			if endpoint.Retry != nil {
+				if _, err := providers.NewRetrier(*endpoint.Retry); err != nil {
+					return fmt.Errorf("invalid retry config for %s %s: %w", providerConfig.Name, endpoint.Path, err)
+				}
+			}
+			if endpoint.Hedge != nil {
+				if _, err := providers.NewHedger(*endpoint.Hedge); err != nil {
+					return fmt.Errorf("invalid hedge config for %s %s: %w", providerConfig.Name, endpoint.Path, err)
+				}
+			}
+			if endpoint.Translate != "" {
+				if _, err := translate.New(endpoint.Translate); err != nil {
+					return fmt.Errorf("invalid translate for %s %s: %w", providerConfig.Name, endpoint.Path, err)
+				}
+			}
+			if endpoint.MaxBodySize < 0 {
+				return fmt.Errorf("invalid max_body_size for %s %s: must not be negative", providerConfig.Name, endpoint.Path)
+			}
+			if endpoint.MaxBodySize > 0 {
+				bodyLimits[endpoint.Path] = endpoint.MaxBodySize
+			}
+		}
+
+		// Providers with their own TLS or proxy settings get a copy of the shared transport
+		providerTransport, err := providers.ProviderTransport(transport, providerConfig)
+		if err != nil {
+			return fmt.Errorf("invalid transport settings for %s: %w", providerConfig.Name, err)
+		}
+		if providerTransport != transport {
+			r.providerTransports = append(r.providerTransports, providerTransport)
+		}
+
+		switch providerConfig.ProviderType() {
 		case "openai":
-			provider = openai.New(providerConfig)
+			provider = openai.New(providerConfig, providerTransport)
+		case "mock":
+			provider, err = mock.New(providerConfig)
+			if err != nil {
+				return fmt.Errorf("invalid mock provider %s: %w", providerConfig.Name, err)
+			}
 		default:
-			return fmt.Errorf("unsupported provider: %s", providerConfig.Name)
+			// Providers compiled in by plugins
+			factory, ok := providers.Lookup(providerConfig.ProviderType())
+			if !ok {
+				return fmt.Errorf("unsupported provider: %s", providerConfig.ProviderType())
+			}
+			provider, err = factory(providerConfig, providerTransport)
+			if err != nil {
+				return fmt.Errorf("failed to create provider %s: %w", providerConfig.Name, err)
+			}
 		}
 
 		// Register the provider
 		r.proxyHandler.RegisterProvider(provider)
+
+		// Cap in-flight requests so a slow provider can't hold every buffered request
+		limiter, err := providers.NewLimiter(providerConfig)
+		if err != nil {
+			return fmt.Errorf("invalid concurrency limit for %s: %w", providerConfig.Name, err)
+		}
+		if limiter != nil {
+			if r.limiters == nil {
+				r.limiters = make(map[string]*providers.Limiter)
+			}
+			r.limiters[providerConfig.Name] = limiter
+			r.proxyHandler.SetProviderLimiter(providerConfig.Name, limiter)
+		}
+
+		// Send the gateway's own keys instead of the client's
+		pool, err := providers.NewKeyPool(providerConfig, r.secrets)
+		if err != nil {
+			return fmt.Errorf("invalid api_keys for %s: %w", providerConfig.Name, err)
+		}
+		if pool != nil {
+			if r.keyPools == nil {
+				r.keyPools = make(map[string]*providers.KeyPool)
+			}
+			r.keyPools[providerConfig.Name] = pool
+			if r.rateLimits != nil {
+				pool.ShareLimits(r.rateLimits, providerConfig.Name)
+			}
+			r.proxyHandler.SetKeyPool(providerConfig.Name, pool)
+		}
+
+		// Probe the provider and eject it from routing while it is unhealthy
+		if providerConfig.HealthCheck != nil {
+			checker, err := providers.NewHealthChecker(providerConfig, providerTransport)
+			if err != nil {
+				return fmt.Errorf("invalid health check for %s: %w", providerConfig.Name, err)
+			}
+			if r.health == nil {
+				r.health = make(map[string]*providers.HealthChecker)
+			}
+			r.health[providerConfig.Name] = checker
+			r.proxyHandler.SetHealthChecker(providerConfig.Name, checker)
+			checker.Start()
+		}
+	}
+
+	// Cap request bodies so guardrails and logging never buffer oversized payloads
+	if r.config.Server.MaxRequestBodySize < 0 {
+		return fmt.Errorf("invalid server config: max_request_body_size must not be negative")
+	}
+	r.proxyHandler.SetBodyLimits(r.config.Server.MaxRequestBodySize, bodyLimits)
+
+	// Set up custom refusal messages for guardrail blocks
+	if err := r.proxyHandler.SetBlockedResponses(r.config.Guardrails); err != nil {
+		return fmt.Errorf("invalid guardrail blocked response: %w", err)
+	}
+	if _, err := guardrails.NewPolicies(r.config.Guardrails); err != nil {
+		return fmt.Errorf("invalid guardrail policy: %w", err)
+	}
+
+	// Set up model aliases, resolved before transforms and guardrails
+	if len(r.config.ModelAliases) > 0 {
+		aliases, err := transform.NewModelAliases(r.config.ModelAliases)
+		if err != nil {
+			return fmt.Errorf("invalid model aliases: %w", err)
+		}
+		r.proxyHandler.SetModelAliases(aliases)
+	}
+
+	// Track latency and error SLOs per provider and model
+	if r.config.SLO.Enabled {
+		tracker, err := slo.New(r.config.SLO)
+		if err != nil {
+			return fmt.Errorf("invalid slo config: %w", err)
+		}
+		r.slo = tracker
+		r.proxyHandler.SetSLO(tracker)
+		tracker.Start()
+	}
+
+	// Track provider prompt caching and mark Anthropic prompts for caching
+	if r.config.PromptCache.Enabled {
+		tracker, err := promptcache.New(r.config.PromptCache)
+		if err != nil {
+			return fmt.Errorf("invalid prompt_cache config: %w", err)
+		}
+		r.promptCache = tracker
+		r.proxyHandler.SetPromptCache(tracker)
+	}
+
+	// Compress guardrail overrides and other responses the gateway rewrites
+	if r.config.Compression.Enabled {
+		if err := r.proxyHandler.SetCompression(r.config.Compression); err != nil {
+			return fmt.Errorf("invalid compression config: %w", err)
+		}
+	}
+
+	// Set up traffic splits for A/B tests and canaries, assigned after aliases
+	if len(r.config.Transforms.Splits) > 0 {
+		splitter, err := transform.NewTrafficSplitter(r.config.Transforms.Splits)
+		if err != nil {
+			return fmt.Errorf("invalid traffic splits: %w", err)
+		}
+		for i, split := range r.config.Transforms.Splits {
+			if split.Strategy == transform.StrategyAdaptive && r.slo == nil {
+				return fmt.Errorf("invalid traffic splits: split %d: the adaptive strategy needs slo.enabled", i+1)
+			}
+		}
+		if r.slo != nil {
+			splitter.SetSLO(r.slo)
+		}
+		r.splitter = splitter
+		r.proxyHandler.SetTrafficSplitter(splitter)
+	}
+
+	// Retry requests over the model's context window on a larger model, or with fewer messages
+	if r.config.Transforms.ContextFallback.Enabled {
+		fallback, err := transform.NewContextFallback(r.config.Transforms.ContextFallback)
+		if err != nil {
+			return fmt.Errorf("invalid context fallback: %w", err)
+		}
+		r.proxyHandler.SetContextFallback(fallback)
+	}
+
+	// Shorten requests estimated to overflow the model's context window
+	if r.config.Transforms.Truncation.Enabled {
+		truncator, err := transform.NewTruncator(r.config.Transforms.Truncation)
+		if err != nil {
+			return fmt.Errorf("invalid truncation config: %w", err)
+		}
+		r.proxyHandler.SetTruncator(truncator)
+	}
+
+	// Set up request transforms (system prompts, default parameters, prompt templates)
+	if len(r.config.Transforms.Request) > 0 {
+		transformer, err := transform.NewRequestTransformer(r.config.Transforms.Request)
+		if err != nil {
+			return fmt.Errorf("invalid request transforms: %w", err)
+		}
+		r.proxyHandler.SetRequestTransformer(transformer)
+	}
+
+	// Set up body redaction for captured logs, and for the responses stored
+	// with output guardrail blocks and metrics
+	if len(r.config.Logging.RedactionRules) > 0 {
+		redactor, err := middleware.NewBodyRedactor(r.config.Logging.RedactionRules)
+		if err != nil {
+			return fmt.Errorf("invalid logging redaction rules: %w", err)
+		}
+		r.redactor = redactor
+		r.proxyHandler.SetRedactor(redactor)
+		if r.capture != nil {
+			r.capture.SetRedactor(redactor)
+		}
+	}
+
+	// Set up spend budgets, counted from cost breakdowns
+	if r.config.Budgets.Enabled {
+		if !r.config.Cost.Enabled {
+			return fmt.Errorf("budgets require cost tracking to be enabled")
+		}
+		tracker, err := budget.New(r.config.Budgets)
+		if err != nil {
+			return fmt.Errorf("invalid budgets: %w", err)
+		}
+		r.budgets = tracker
+		r.proxyHandler.SetBudgets(tracker)
+	}
+
+	// Set up the CORS policy, which endpoints may replace with their own
+	endpointCORS := make(map[string]*config.CORSConfig)
+	for _, providerConfig := range r.config.Providers {
+		for _, endpoint := range providerConfig.Endpoints {
+			if endpoint.CORS != nil {
+				endpointCORS[providerConfig.PathPrefix+endpoint.Path] = endpoint.CORS
+			}
+		}
+	}
+	cors, err := middleware.NewCORS(r.config.CORS, endpointCORS, providers.BestEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid cors config: %w", err)
+	}
+	r.cors = cors
+
+	// Set up the client IP allow/deny lists
+	if r.config.IPFilter.Enabled {
+		filter, err := ipfilter.New(r.config.IPFilter)
+		if err != nil {
+			return fmt.Errorf("invalid ip_filter: %w", err)
+		}
+		r.ipFilter = filter
+	}
+
+	// Set up JWT authentication for clients without API keys
+	if r.config.Auth.JWT.Enabled {
+		authenticator, err := jwtauth.New(r.config.Auth.JWT)
+		if err != nil {
+			return fmt.Errorf("invalid jwt auth: %w", err)
+		}
+		if r.rateLimits != nil {
+			authenticator.ShareLimits(r.rateLimits)
+		}
+		r.jwtAuth = authenticator
+	}
+
+	// Set up virtual keys, which the gateway issues and stores only as hashes
+	if r.config.Auth.VirtualKeys.Enabled {
+		authenticator, err := vkeys.New(r.config.Auth.VirtualKeys, r.keyStore)
+		if err != nil {
+			return fmt.Errorf("invalid virtual_keys: %w", err)
+		}
+		r.virtualKeys = authenticator
+	}
+
+	// Set up tenants, which scope providers, credentials and rate limits per team
+	if r.config.Tenants.Enabled {
+		resolver, err := tenant.New(r.config.Tenants)
+		if err != nil {
+			return fmt.Errorf("invalid tenants: %w", err)
+		}
+		if r.rateLimits != nil {
+			resolver.ShareLimits(r.rateLimits)
+		}
+		r.tenants = resolver
+	}
+
+	// Set up header routing rules, which pick providers, credentials and rate limits
+	if len(r.config.Routing.Rules) > 0 {
+		names := make([]string, len(r.config.Providers))
+		for i, providerConfig := range r.config.Providers {
+			names[i] = providerConfig.Name
+		}
+		rules, err := routing.New(r.config.Routing, names)
+		if err != nil {
+			return fmt.Errorf("invalid routing: %w", err)
+		}
+		if r.rateLimits != nil {
+			rules.ShareLimits(r.rateLimits)
+		}
+		r.routing = rules
+	}
+
+	// Set up brownout controller for shedding optional features under load
+	if r.config.Brownout.Enabled {
+		controller, err := brownout.New(r.config.Brownout)
+		if err != nil {
+			return fmt.Errorf("invalid brownout config: %w", err)
+		}
+		if r.logWriter != nil {
+			controller.AddPressureSource("log_queue", r.logWriter.QueueUtilization)
+		}
+
+		r.brownout = controller
+		r.proxyHandler.SetBrownout(controller)
+		if r.capture != nil {
+			r.capture.SetBrownout(controller)
+		}
+		controller.Start()
+	}
+
+	// Set up priority queueing so bursts wait for a slot instead of piling onto providers
+	if r.config.Admission.Enabled {
+		controller, err := admission.New(r.config.Admission)
+		if err != nil {
+			return fmt.Errorf("invalid admission config: %w", err)
+		}
+		r.admission = controller
+	}
+
+	// Shed the lowest priorities first when the gateway itself runs short of resources
+	if r.config.LoadShed.Enabled {
+		shedder, err := loadshed.New(r.config.LoadShed, admission.Priorities(r.config.Admission), admission.KeyPriority(r.config.Admission))
+		if err != nil {
+			return fmt.Errorf("invalid load_shed config: %w", err)
+		}
+		if r.logWriter != nil && r.config.LoadShed.MaxLogQueue > 0 {
+			shedder.AddSignal("log_queue", r.config.LoadShed.MaxLogQueue, r.logWriter.QueueUtilization)
+		}
+		if shedder.Signals() == 0 {
+			return fmt.Errorf("invalid load_shed config: set max_goroutines, max_heap_mb or max_log_queue")
+		}
+		r.loadShed = shedder
+		shedder.Start()
+	}
+
+	// Coalesce identical requests so client retry storms make one upstream call
+	if r.config.Dedup.Enabled {
+		coalescer, err := dedup.New(r.config.Dedup)
+		if err != nil {
+			return fmt.Errorf("invalid dedup config: %w", err)
+		}
+		r.dedup = coalescer
+	}
+
+	// Limit end users behind shared keys separately
+	if r.config.UserLimit.Enabled {
+		limiter, err := userlimit.New(r.config.UserLimit)
+		if err != nil {
+			return fmt.Errorf("invalid user_rate_limit config: %w", err)
+		}
+		if r.rateLimits != nil {
+			limiter.ShareLimits(r.rateLimits)
+		}
+		r.userLimit = limiter
+	}
+
+	// List every provider's models on /v1/models, not only the routed provider's
+	if r.config.Models.Enabled {
+		var upstream http.Handler
+		if r.config.Models.MergeUpstream {
+			upstream = r.proxyHandler
+		}
+		r.models = models.New(r.config, upstream)
+	}
+
+	// Probe the log database and queue, and optionally providers, for readiness
+	checker, err := readiness.New(r.config.Health)
+	if err != nil {
+		return fmt.Errorf("invalid health config: %w", err)
+	}
+	if r.logWriter != nil {
+		checker.AddDatabase(r.logWriter.Ping)
+		checker.AddLogQueue(r.logWriter.QueueUtilization)
+	}
+	for _, providerConfig := range r.config.Providers {
+		if providerConfig.ProviderType() == "mock" {
+			// Answers in process, so there is nothing to reach
+			continue
+		}
+		var healthy func() bool
+		if health, ok := r.health[providerConfig.Name]; ok {
+			healthy = health.Healthy
+		}
+		if err := checker.AddProvider(providerConfig, healthy); err != nil {
+			return fmt.Errorf("invalid health config: %w", err)
+		}
+	}
+	r.readiness = checker
+
+	// Middleware each listener runs, in the order configured
+	r.serverChain, err = parseChain(r.config.Server.Middleware)
+	if err != nil {
+		return fmt.Errorf("invalid server middleware: %w", err)
+	}
+	r.warnDropped("server", r.serverChain)
+	r.grpcChain = r.serverChain
+	if len(r.config.GRPC.Middleware) > 0 {
+		r.grpcChain, err = parseChain(r.config.GRPC.Middleware)
+		if err != nil {
+			return fmt.Errorf("invalid grpc middleware: %w", err)
+		}
+		if r.config.GRPC.Enabled {
+			r.warnDropped("grpc", r.grpcChain)
+		}
 	}
 
 	return nil
 }
 
-// Handler returns the main HTTP handler with all middleware applied
+// Handler returns the main HTTP handler with the server's middleware applied
 func (r *Router) Handler() http.Handler {
-	// Create base handler
-	handler := http.Handler(r.proxyHandler)
+	return r.handler(r.serverChain)
+}
+
+// GRPCHandler returns the handler gRPC calls are served through, with the
+// gRPC listener's middleware applied
+func (r *Router) GRPCHandler() http.Handler {
+	return r.handler(r.grpcChain)
+}
+
+// handler builds a listener's handler from its middleware chain
+func (r *Router) handler(c chain) http.Handler {
+	// Proxied requests go through the proxy middleware; health and status stay responsive
+	handler := apply(r.proxyHandler, c.proxy, r.proxyLayer)
 
 	// Add health check endpoint
 	mux := http.NewServeMux()
 	mux.Handle("/", handler)
 	mux.HandleFunc("/health", r.healthCheckHandler)
+	mux.HandleFunc("/health/live", r.livenessHandler)
+	mux.HandleFunc("/health/ready", r.readinessHandler)
 	mux.HandleFunc("/status", r.statusHandler)
 
+	// Feedback, batches and models are answered by the gateway itself, but callers are still filtered and authenticated
+	if r.feedback != nil {
+		mux.Handle(feedback.Endpoint, r.authenticated(r.feedback, c))
+	}
+	if r.batches != nil {
+		batches := r.authenticated(r.batches, c)
+		mux.Handle(batch.FilesPath, batches)
+		mux.Handle(batch.FilesPath+"/", batches)
+		mux.Handle(batch.BatchesPath, batches)
+		mux.Handle(batch.BatchesPath+"/", batches)
+	}
+	if r.models != nil {
+		catalog := r.authenticated(r.models, c)
+		mux.Handle(models.Endpoint, catalog)
+		mux.Handle(models.Endpoint+"/", catalog)
+	}
+
 	// Add metrics endpoint if logging is enabled
 	if r.logWriter != nil {
 		mux.HandleFunc("/metrics", r.metricsHandler)
 	}
+	mux.HandleFunc("/metrics/prometheus", r.prometheusHandler)
 
-	// Build middleware chain - order matters!
-	// First middleware listed runs first (outermost layer)
-	middlewares := []func(http.Handler) http.Handler{
-		middleware.Recovery,    // 1. Catches panics (outermost)
-		middleware.Logger,      // 2. Logs requests
-		middleware.CORS,     // 3. CORS headers (disabled)
-		middleware.ContentType, // 3. Sets content type
+	// Wrap every request in the listener-wide middleware; by default this is
+	// Recovery(Logger(CORS(ContentType(Brownout(Plugins(Capture(mux)))))))
+	return apply(mux, c.listener, r.listenerLayer)
+}
+
+// authenticated applies the IP filter, JWT and virtual key authentication and
+// tenant resolution of proxied requests to an endpoint the gateway serves
+// itself, as far as the listener's chain includes them
+func (r *Router) authenticated(handler http.Handler, c chain) http.Handler {
+	var names []string
+	for _, name := range c.proxy {
+		if name == "ip_filter" || name == "auth" || name == "tenants" {
+			names = append(names, name)
+		}
 	}
+	return apply(handler, names, r.proxyLayer)
+}
 
-	// Add capture middleware if logging is enabled
-	// This runs last (innermost) to capture final request/response data
+// InternalHandler serves requests the gateway makes on a client's behalf,
+// such as replays of stored logs and batch requests. They skip client
+// authentication and admission, which the client already passed, and are
+// logged like any other request.
+func (r *Router) InternalHandler() http.Handler {
+	handler := http.Handler(r.proxyHandler)
 	if r.capture != nil {
-		middlewares = append(middlewares, r.capture.Capture) // 4. Captures data
+		handler = r.capture.Capture(handler)
 	}
+	return middleware.Recovery(handler)
+}
 
-	// Apply middleware chain using the simplified approach
-	// This wraps: Recovery(Logger(ContentType(Capture(mux))))
-	return middleware.ApplyChain(mux, middlewares...)
+// Tenants returns the tenant resolver, or nil when tenants are disabled
+func (r *Router) Tenants() *tenant.Resolver {
+	return r.tenants
 }
 
 // healthCheckHandler provides a simple health check endpoint
@@ -105,10 +681,58 @@ func (r *Router) healthCheckHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+
+	// Fail health checks while draining so load balancers stop sending traffic
+	if r.drain.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status": "draining"}`))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status": "healthy"}`))
 }
 
+// livenessHandler reports the process is up and serving. It passes while
+// draining, so orchestrators don't restart a gateway that is shutting down.
+func (r *Router) livenessHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "alive"}`))
+}
+
+// readinessHandler reports whether the gateway can take traffic, with the
+// status of each dependency. It fails while draining or when a critical
+// dependency fails; failing optional dependencies only mark it degraded.
+func (r *Router) readinessHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := r.readiness.Check(req.Context())
+	response := map[string]interface{}{
+		"status": report.Status,
+		"checks": report.Checks,
+	}
+	status := http.StatusOK
+	if r.drain.Draining() {
+		response["status"] = "draining"
+		status = http.StatusServiceUnavailable
+	} else if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
 // statusHandler provides information about registered providers and endpoints
 func (r *Router) statusHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
@@ -118,16 +742,55 @@ func (r *Router) statusHandler(w http.ResponseWriter, req *http.Request) {
 
 	endpoints := r.proxyHandler.GetRegisteredEndpoints()
 
+	state := "running"
+	if r.drain.Draining() {
+		state = "draining"
+	}
+
+	response := map[string]interface{}{
+		"status":               state,
+		"registered_endpoints": len(endpoints),
+		"providers":            len(r.config.Providers),
+		"panics_recovered":     middleware.PanicsRecovered(),
+	}
+	if r.brownout != nil {
+		response["brownout"] = r.brownout.Status()
+	}
+	if r.admission != nil {
+		response["admission"] = r.admission.Status()
+	}
+	if r.loadShed != nil {
+		response["load_shed"] = r.loadShed.Status()
+	}
+	if len(r.health) > 0 {
+		health := make(map[string]interface{}, len(r.health))
+		for name, checker := range r.health {
+			health[name] = checker.Status()
+		}
+		response["provider_health"] = health
+	}
+	if r.tokenDrift != nil {
+		response["token_estimates"] = r.tokenDrift.Status()
+	}
+	if r.slo != nil {
+		response["slo"] = r.slo.Status()
+	}
+	if r.userLimit != nil {
+		response["user_rate_limit"] = r.userLimit.Status()
+	}
+	if r.dedup != nil {
+		response["dedup"] = r.dedup.Status()
+	}
+	if r.promptCache != nil {
+		response["prompt_cache"] = r.promptCache.Status()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	response := fmt.Sprintf(`{
-	"status": "running",
-	"registered_endpoints": %d,
-	"providers": %d
-}`, len(endpoints), len(r.config.Providers))
-
-	w.Write([]byte(response))
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode status", http.StatusInternalServerError)
+	}
 }
 
 // metricsHandler provides logging metrics
@@ -152,12 +815,403 @@ func (r *Router) metricsHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// prometheusHandler exposes recovered panics, and SLO latency and error
+// metrics when SLO tracking is enabled, for Prometheus to scrape
+func (r *Router) prometheusHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.slo != nil {
+		r.slo.WritePrometheus(w)
+	}
+	const panics = "flash_gateway_panics_recovered_total"
+	fmt.Fprintf(w, "# HELP %s Handler panics recovered and answered with a 500.\n# TYPE %s counter\n%s %d\n",
+		panics, panics, panics, middleware.PanicsRecovered())
+}
+
+// Brownout returns the brownout controller, or nil when brownout is disabled
+func (r *Router) Brownout() *brownout.Controller {
+	return r.brownout
+}
+
+// Drain stops accepting proxy requests and waits for in-flight requests to
+// finish and their logs and guardrail metrics to be flushed
+func (r *Router) Drain(ctx context.Context) error {
+	return r.drain.Drain(ctx)
+}
+
+// Close stops background work owned by the router
+func (r *Router) Close() {
+	if r.brownout != nil {
+		r.brownout.Stop()
+	}
+	if r.loadShed != nil {
+		r.loadShed.Stop()
+	}
+	if r.slo != nil {
+		r.slo.Stop()
+	}
+	for _, checker := range r.health {
+		checker.Stop()
+	}
+	if r.rateLimits != nil {
+		r.rateLimits.Close()
+	}
+	if r.transport != nil {
+		r.transport.CloseIdleConnections()
+	}
+	for _, transport := range r.providerTransports {
+		transport.CloseIdleConnections()
+	}
+}
+
 // SetGuardrailExecutor sets the guardrail executor for the proxy handler
 func (r *Router) SetGuardrailExecutor(executor interface{}) {
 	// Import guardrails package to use the executor type
 	if r.proxyHandler != nil {
 		if guardrailExecutor, ok := executor.(*guardrails.Executor); ok {
 			r.proxyHandler.SetGuardrailExecutor(guardrailExecutor)
+			r.guardrails = guardrailExecutor
+			if r.redactor != nil {
+				guardrailExecutor.SetResponseRedactor(r.redactor.RedactResponse)
+			}
+			r.drain.OnDrained(guardrailExecutor.Flush)
+		}
+	}
+}
+
+// SetFeedback serves client feedback on POST /v1/feedback
+func (r *Router) SetFeedback(store *feedback.Store) {
+	r.feedback = store
+}
+
+// SetSecrets resolves secret:// provider API keys; call before Initialize
+func (r *Router) SetSecrets(manager *secrets.Manager) {
+	r.secrets = manager
+}
+
+// SetKeyStore keeps virtual keys created on the admin API; call before Initialize
+func (r *Router) SetKeyStore(store vkeys.Store) {
+	r.keyStore = store
+}
+
+// SetBatches serves the Batch API on /v1/files and /v1/batches
+func (r *Router) SetBatches(manager *batch.Manager) {
+	r.batches = manager
+}
+
+// RegisterAdmin exposes router state and runtime toggles on the admin API
+func (r *Router) RegisterAdmin(server *admin.Server) {
+	server.AddStatus("providers", r.providerStatus)
+	server.AddStatus("guardrails", r.guardrailStatus)
+	server.AddStatus("drain", func() interface{} { return r.drain.Status() })
+	if r.slo != nil {
+		server.AddStatus("slo", func() interface{} { return r.slo.Status() })
+	}
+	if r.userLimit != nil {
+		server.AddStatus("user_rate_limit", func() interface{} { return r.userLimit.Status() })
+	}
+	if r.dedup != nil {
+		server.AddStatus("dedup", func() interface{} { return r.dedup.Status() })
+	}
+	if r.promptCache != nil {
+		server.AddStatus("prompt_cache", func() interface{} { return r.promptCache.Status() })
+	}
+	if r.splitter != nil {
+		server.AddStatus("traffic_splits", func() interface{} { return r.splitter.Status() })
+	}
+	if r.rateLimits != nil {
+		server.AddStatus("rate_limits", func() interface{} { return r.rateLimits.Status() })
+	}
+	if r.secrets != nil {
+		server.AddStatus("secrets", r.secrets.Status)
+	}
+	server.HandleFunc("/admin/drain", r.drainHandler)
+	server.HandleFunc("/admin/guardrails", r.guardrailsHandler)
+	server.HandleFunc("/admin/guardrails/", r.guardrailsHandler)
+	server.HandleFunc("/admin/guardrails/evaluate", r.evaluateGuardrailsHandler)
+
+	if r.logWriter != nil {
+		server.AddStatus("logging", func() interface{} { return r.logWriter.GetMetrics() })
+	}
+
+	if r.brownout != nil {
+		server.AddStatus("brownout", func() interface{} { return r.brownout.Status() })
+		server.AddToggle("brownout", admin.Toggle{
+			Get: func() interface{} { return r.brownout.Mode() },
+			Set: func(value string) error { return r.brownout.SetMode(brownout.Mode(value)) },
+		})
+	}
+
+	if r.admission != nil {
+		server.AddStatus("admission", func() interface{} { return r.admission.Status() })
+	}
+
+	if r.loadShed != nil {
+		server.AddStatus("load_shed", func() interface{} { return r.loadShed.Status() })
+	}
+
+	if r.budgets != nil {
+		server.AddStatus("budgets", func() interface{} { return r.budgets.Status() })
+	}
+
+	if r.ipFilter != nil {
+		server.AddStatus("ip_filter", func() interface{} { return r.ipFilter.Status() })
+	}
+
+	if r.virtualKeys != nil {
+		r.virtualKeys.Register(server)
+	}
+	if r.jwtAuth != nil {
+		server.AddStatus("jwt_auth", func() interface{} { return r.jwtAuth.Status() })
+	}
+
+	if r.tenants != nil {
+		server.AddStatus("tenants", func() interface{} { return r.tenants.Status() })
+	}
+
+	if r.routing != nil {
+		server.AddStatus("routing", func() interface{} { return r.routing.Status() })
+	}
+
+	if r.tokenDrift != nil {
+		server.AddStatus("tokens", func() interface{} { return r.tokenDrift.Status() })
+	}
+}
+
+// providerStatus describes registered providers and their endpoints
+func (r *Router) providerStatus() interface{} {
+	status := make(map[string]interface{}, len(r.config.Providers))
+	for _, provider := range r.config.Providers {
+		endpoints := make([]map[string]interface{}, 0, len(provider.Endpoints))
+		for _, endpoint := range provider.Endpoints {
+			endpoints = append(endpoints, map[string]interface{}{
+				"path":                endpoint.Path,
+				"methods":             endpoint.Methods,
+				"timeout":             endpoint.Timeout,
+				"header_timeout":      endpoint.HeaderTimeout,
+				"stream_idle_timeout": endpoint.StreamIdleTimeout,
+			})
+		}
+		providerStatus := map[string]interface{}{
+			"base_url":  provider.BaseURL,
+			"endpoints": endpoints,
+		}
+		if provider.PathPrefix != "" {
+			providerStatus["path_prefix"] = provider.PathPrefix
+		}
+		if limiter, ok := r.limiters[provider.Name]; ok {
+			providerStatus["concurrency"] = limiter.Status()
+		}
+		if checker, ok := r.health[provider.Name]; ok {
+			providerStatus["health"] = checker.Status()
+		}
+		if pool, ok := r.keyPools[provider.Name]; ok {
+			providerStatus["api_keys"] = pool.Status()
+		}
+		status[provider.Name] = providerStatus
+	}
+	return status
+}
+
+// guardrailStatus describes registered guardrail types and loaded guardrails
+func (r *Router) guardrailStatus() interface{} {
+	describe := func(list []guardrails.Guardrail) []map[string]interface{} {
+		described := make([]map[string]interface{}, 0, len(list))
+		for _, g := range list {
+			entry := map[string]interface{}{
+				"name":     g.Name(),
+				"enabled":  r.guardrails.Enabled(g.Name()),
+				"priority": r.guardrails.Priority(g),
+			}
+			if scoped, ok := g.(interface{ Applicability() guardrails.Applicability }); ok {
+				entry["applies_to"] = scoped.Applicability()
+			}
+			entry["health"] = r.guardrails.Health(g.Name()).Status()
+			described = append(described, entry)
+		}
+		return described
+	}
+
+	status := map[string]interface{}{
+		"enabled":          r.guardrails != nil,
+		"registered_types": guardrails.GetRegistered(),
+	}
+	if r.guardrails != nil {
+		status["input"] = describe(r.guardrails.GetInputGuardrails())
+		status["output"] = describe(r.guardrails.GetOutputGuardrails())
+	}
+	return status
+}
+
+// drainHandler starts a drain on POST and reports its progress on GET. POST
+// returns immediately unless ?wait=true, which blocks until the drain completes.
+func (r *Router) drainHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		admin.WriteJSON(w, http.StatusOK, r.drain.Status())
+	case http.MethodPost:
+		audit.Record(req.Context(), "drain.started", "", nil, nil)
+		if req.URL.Query().Get("wait") == "true" {
+			if err := r.drain.Drain(req.Context()); err != nil {
+				admin.WriteError(w, http.StatusGatewayTimeout, fmt.Sprintf("drain did not complete: %v", err))
+				return
+			}
+			admin.WriteJSON(w, http.StatusOK, r.drain.Status())
+			return
+		}
+		r.drain.Start()
+		admin.WriteJSON(w, http.StatusAccepted, r.drain.Status())
+	default:
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// guardrailsHandler lists loaded guardrails' runtime settings and recent
+// changes on GET /admin/guardrails. On /admin/guardrails/{name}, GET shows one
+// guardrail and PATCH, POST or PUT changes whether it is enabled, its priority
+// or its threshold until the gateway restarts.
+func (r *Router) guardrailsHandler(w http.ResponseWriter, req *http.Request) {
+	if r.guardrails == nil {
+		admin.WriteError(w, http.StatusConflict, "guardrails are not enabled")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/guardrails"), "/")
+	if name == "" {
+		if req.Method != http.MethodGet {
+			admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"guardrails": r.guardrails.States(),
+			"changes":    r.guardrails.Changes(),
+		})
+		return
+	}
+
+	var states []guardrails.GuardrailState
+	for _, state := range r.guardrails.States() {
+		if state.Name == name {
+			states = append(states, state)
+		}
+	}
+	if len(states) == 0 {
+		admin.WriteError(w, http.StatusNotFound, fmt.Sprintf("no guardrail named %s is loaded", name))
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		admin.WriteJSON(w, http.StatusOK, states)
+	case http.MethodPatch, http.MethodPost, http.MethodPut:
+		var settings guardrails.Settings
+		if err := json.NewDecoder(req.Body).Decode(&settings); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		if settings.Enabled == nil && settings.Priority == nil && settings.Threshold == nil {
+			admin.WriteError(w, http.StatusBadRequest, "set at least one of enabled, priority or threshold")
+			return
+		}
+		principal, _ := admin.PrincipalFromContext(req.Context())
+		before := states
+		states, err := r.guardrails.Update(name, settings, principal.Name)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		audit.Record(req.Context(), "guardrail.updated", name, before, states)
+		admin.WriteJSON(w, http.StatusOK, states)
+	default:
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// evaluateRequest is the body of a guardrail dry run
+type evaluateRequest struct {
+	Layer      string          `json:"layer"`   // input (default) or output
+	Payload    json.RawMessage `json:"payload"` // Request or response body; a JSON string is used as-is
+	Endpoint   string          `json:"endpoint"`
+	Provider   string          `json:"provider"`
+	Model      string          `json:"model"`
+	Tenant     string          `json:"tenant"`
+	User       string          `json:"user"`       // End user, as sent in guardrails.user_header
+	APIKeyID   string          `json:"api_key_id"` // Fingerprint of the caller's key
+	Guardrails []string        `json:"guardrails"` // Limits the run to these guardrails
+}
+
+// evaluateGuardrailsHandler runs a payload through the input or output
+// guardrails on POST and returns every verdict without proxying anything
+func (r *Router) evaluateGuardrailsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.guardrails == nil {
+		admin.WriteError(w, http.StatusConflict, "guardrails are not enabled")
+		return
+	}
+
+	if limit := r.config.Server.MaxRequestBodySize; limit > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+	}
+	var body evaluateRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if len(body.Payload) == 0 {
+		admin.WriteError(w, http.StatusBadRequest, "payload is required")
+		return
+	}
+	if body.Layer == "" {
+		body.Layer = "input"
+	}
+
+	content := string(body.Payload)
+	var text string
+	if err := json.Unmarshal(body.Payload, &text); err == nil {
+		content = text
+	}
+
+	scope := guardrails.Scope{
+		Endpoint: body.Endpoint,
+		Provider: body.Provider,
+		Model:    body.Model,
+		Client: guardrails.Client{
+			APIKeyID: body.APIKeyID,
+			Tenant:   body.Tenant,
+			UserID:   body.User,
+		},
+	}
+	if provider, endpoint := r.providerForPath(scope.Endpoint); provider != "" {
+		scope.Endpoint = endpoint
+		if scope.Provider == "" {
+			scope.Provider = provider
+		}
+	}
+
+	result, err := r.guardrails.Evaluate(guardrails.WithScope(req.Context(), scope), body.Layer, content, body.Guardrails)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, result)
+}
+
+// providerForPath returns the provider serving a path by default, if any,
+// and the endpoint it serves, which is the path without a provider's prefix
+func (r *Router) providerForPath(path string) (provider, endpoint string) {
+	for _, providerConfig := range r.config.Providers {
+		for _, e := range providerConfig.Endpoints {
+			if providers.MatchEndpoint(providerConfig.PathPrefix+e.Path, path) {
+				return providerConfig.Name, strings.TrimPrefix(path, providerConfig.PathPrefix)
+			}
 		}
 	}
+	return "", path
 }
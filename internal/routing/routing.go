@@ -0,0 +1,187 @@
+// Package routing matches requests to routing rules by their headers, so
+// operators can send, say, X-Env: staging traffic to a staging provider or
+// give X-Team: research its own rate limit.
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/ratelimit"
+)
+
+// routeContextKey is the context key under which the matched rule is stored
+const routeContextKey = "route"
+
+// Rule is a compiled routing rule
+type Rule struct {
+	Name       string
+	Provider   string // Empty keeps the endpoint's default provider
+	Credential string // Empty keeps the client's or tenant's key
+	headers    map[string]string
+	limiter    *ratelimit.Bucket
+}
+
+// matches reports whether a request carries every header the rule lists
+func (rule *Rule) matches(req *http.Request) bool {
+	for name, want := range rule.headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			return false
+		}
+		if !matchAny(want, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether one of a header's values matches a pattern
+func matchAny(pattern string, values []string) bool {
+	for _, value := range values {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.HasSuffix(pattern, "*"):
+			prefix := strings.TrimSuffix(pattern, "*")
+			if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+				return true
+			}
+		case strings.EqualFold(value, pattern):
+			return true
+		}
+	}
+	return false
+}
+
+// Rules matches requests against routing rules in order
+type Rules struct {
+	rules []*Rule
+}
+
+// New compiles routing rules. providers lists the configured provider names
+// a rule may route to.
+func New(cfg config.RoutingConfig, providers []string) (*Rules, error) {
+	known := make(map[string]bool, len(providers))
+	for _, name := range providers {
+		known[name] = true
+	}
+
+	r := &Rules{}
+	names := make(map[string]bool)
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate routing rule %q", name)
+		}
+		names[name] = true
+
+		if len(rc.Headers) == 0 {
+			return nil, fmt.Errorf("routing rule %s: headers are required", name)
+		}
+		if rc.Provider != "" && !known[rc.Provider] {
+			return nil, fmt.Errorf("routing rule %s: unknown provider %s", name, rc.Provider)
+		}
+		if rc.Provider == "" && rc.Credential == "" && rc.RateLimit == nil {
+			return nil, fmt.Errorf("routing rule %s: needs a provider, credential or rate_limit", name)
+		}
+
+		rule := &Rule{
+			Name:       name,
+			Provider:   rc.Provider,
+			Credential: rc.Credential,
+			headers:    make(map[string]string, len(rc.Headers)),
+		}
+		for header, value := range rc.Headers {
+			rule.headers[http.CanonicalHeaderKey(header)] = value
+		}
+		if rc.RateLimit != nil {
+			if rc.RateLimit.RequestsPerMinute <= 0 {
+				return nil, fmt.Errorf("routing rule %s: requests_per_minute must be positive", name)
+			}
+			rule.limiter = ratelimit.NewBucket(rc.RateLimit.RequestsPerMinute, rc.RateLimit.Burst)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Match returns the first rule a request matches, or nil
+func (r *Rules) Match(req *http.Request) *Rule {
+	for _, rule := range r.rules {
+		if rule.matches(req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Middleware matches each request to a rule and applies the rule's rate
+// limit. The rule is attached to the request context for the proxy handler,
+// which routes to its provider and sends its credential.
+func (r *Rules) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rule := r.Match(req)
+		if rule == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		middleware.AddLogMetadata(req.Context(), "route", rule.Name)
+		if rule.limiter != nil && !rule.limiter.Allow() {
+			middleware.AddLogMetadata(req.Context(), "route_rate_limited", true)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Route %s is over its rate limit", rule.Name), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithRule(req.Context(), rule)))
+	})
+}
+
+// ShareLimits keeps rules' rate limits in backend, so they hold across
+// gateway instances
+func (r *Rules) ShareLimits(backend ratelimit.Backend) {
+	for _, rule := range r.rules {
+		if rule.limiter != nil {
+			rule.limiter.Share(backend, "route:"+rule.Name)
+		}
+	}
+}
+
+// Status returns the configured rules for status endpoints
+func (r *Rules) Status() []map[string]interface{} {
+	status := make([]map[string]interface{}, 0, len(r.rules))
+	for _, rule := range r.rules {
+		described := map[string]interface{}{
+			"name":       rule.Name,
+			"headers":    rule.headers,
+			"credential": rule.Credential != "", // Never the key itself
+		}
+		if rule.Provider != "" {
+			described["provider"] = rule.Provider
+		}
+		if rule.limiter != nil {
+			described["rate_limit"] = rule.limiter.Status()
+		}
+		status = append(status, described)
+	}
+	return status
+}
+
+// WithRule attaches the matched rule to a request context
+func WithRule(ctx context.Context, rule *Rule) context.Context {
+	return context.WithValue(ctx, routeContextKey, rule)
+}
+
+// FromContext returns the request's routing rule, or nil when none matched
+func FromContext(ctx context.Context) *Rule {
+	rule, _ := ctx.Value(routeContextKey).(*Rule)
+	return rule
+}
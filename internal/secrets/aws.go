@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// awsSource reads secrets from AWS Secrets Manager with GetSecretValue,
+// signing requests with Signature Version 4. A path is the secret's name or ARN.
+type awsSource struct {
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	endpoint        string
+	client          *http.Client
+}
+
+func newAWSSource(cfg config.AWSSecretsConfig, client *http.Client) (*awsSource, error) {
+	s := &awsSource{
+		region:          firstNonEmpty(cfg.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")),
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+		sessionToken:    cfg.SessionToken,
+		endpoint:        strings.TrimRight(cfg.Endpoint, "/"),
+		client:          client,
+	}
+	if s.accessKeyID == "" {
+		s.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.region == "" {
+		return nil, fmt.Errorf("region is required (or AWS_REGION)")
+	}
+	if s.accessKeyID == "" || s.secretAccessKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key are required (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://secretsmanager." + s.region + ".amazonaws.com"
+	}
+	return s, nil
+}
+
+func (s *awsSource) Fetch(ctx context.Context, path, field string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": path})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	s.sign(req, payload, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &failure)
+		return "", fmt.Errorf("secrets manager returned %s %s %s", resp.Status, failure.Type, failure.Message)
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	value := secret.SecretString
+	if value == "" && secret.SecretBinary != "" {
+		decoded, err := base64.StdEncoding.DecodeString(secret.SecretBinary)
+		if err != nil {
+			return "", fmt.Errorf("invalid SecretBinary: %w", err)
+		}
+		value = string(decoded)
+	}
+	return jsonField(value, field)
+}
+
+// sign adds a Signature Version 4 Authorization header for the
+// secretsmanager service
+func (s *awsSource) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(payload),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/secretsmanager/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "secretsmanager")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// metadataTokenURL serves access tokens for the instance's service account on GCP
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcpSource reads secrets from Secret Manager. A path is a secret name, read
+// at its latest version unless it ends in /versions/N, or a full
+// projects/.../secrets/... resource name.
+type gcpSource struct {
+	project     string
+	accessToken string
+	endpoint    string
+	client      *http.Client
+
+	mu          sync.Mutex
+	token       string // From the metadata server
+	tokenExpiry time.Time
+}
+
+func newGCPSource(cfg config.GCPSecretsConfig, client *http.Client) (*gcpSource, error) {
+	s := &gcpSource{
+		project:     firstNonEmpty(cfg.Project, os.Getenv("GOOGLE_CLOUD_PROJECT")),
+		accessToken: cfg.AccessToken,
+		endpoint:    strings.TrimRight(cfg.Endpoint, "/"),
+		client:      client,
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://secretmanager.googleapis.com"
+	}
+	return s, nil
+}
+
+func (s *gcpSource) Fetch(ctx context.Context, path, field string) (string, error) {
+	name := path
+	if !strings.HasPrefix(name, "projects/") {
+		if s.project == "" {
+			return "", fmt.Errorf("project is required for %s (or GOOGLE_CLOUD_PROJECT)", path)
+		}
+		name = "projects/" + s.project + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	token, err := s.bearerToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret manager returned %s", resp.Status)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", fmt.Errorf("invalid secret manager response: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+	return jsonField(string(data), field)
+}
+
+// bearerToken returns the configured access token, or one for the instance's
+// service account, reused until shortly before it expires
+func (s *gcpSource) bearerToken(ctx context.Context) (string, error) {
+	if s.accessToken != "" {
+		return s.accessToken, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get an access token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid metadata server token: %w", err)
+	}
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
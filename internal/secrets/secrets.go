@@ -0,0 +1,465 @@
+// Package secrets resolves secret:// references in the configuration from
+// environment variables, files and secret managers, so API keys, database
+// credentials and HMAC secrets don't have to live in the YAML file.
+//
+// A reference names a source and a path, and optionally a field of a JSON
+// secret:
+//
+//	secret://env/OPENAI_API_KEY
+//	secret://file/run/secrets/openai
+//	secret://vault/secret/gateway/openai#api_key
+//	secret://aws/prod/gateway#openai_key
+//	secret://gcp/openai-api-key
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// Prefix marks a config value as a reference to a secret
+const Prefix = "secret://"
+
+// fetchTimeout bounds each request to a secret manager
+const fetchTimeout = 10 * time.Second
+
+// Source reads secrets from one place
+type Source interface {
+	// Fetch returns the secret at path, or the named field of it when field is set
+	Fetch(ctx context.Context, path, field string) (string, error)
+}
+
+// IsReference reports whether a config value refers to a secret
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// parseReference splits secret://source/path#field
+func parseReference(ref string) (source, path, field string, err error) {
+	rest := strings.TrimPrefix(ref, Prefix)
+	if i := strings.LastIndex(rest, "#"); i >= 0 {
+		rest, field = rest[:i], rest[i+1:]
+	}
+	source, path, _ = strings.Cut(rest, "/")
+	if source == "" || path == "" {
+		return "", "", "", fmt.Errorf("invalid secret reference %s: want secret://source/path", ref)
+	}
+	return source, path, field, nil
+}
+
+// entry is a resolved reference and whoever wants to hear when it changes
+type entry struct {
+	value     string
+	fetchedAt time.Time
+	refreshes int
+	err       error // Last refetch error; the previous value stays in use
+	watchers  []func(string)
+}
+
+// Manager resolves references through the configured sources, caching each
+// value and refetching watched ones periodically
+type Manager struct {
+	sources  map[string]Source
+	interval time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	resolved [][]string // Config paths ResolveConfig replaced, by YAML key
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a manager with the env and file sources and any secret
+// managers the config sets up
+func New(cfg config.SecretsConfig) (*Manager, error) {
+	m := &Manager{
+		sources: map[string]Source{
+			"env":  envSource{},
+			"file": fileSource{},
+		},
+		entries: make(map[string]*entry),
+	}
+
+	if cfg.RefreshInterval != "" && cfg.RefreshInterval != "0" {
+		interval, err := time.ParseDuration(cfg.RefreshInterval)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid refresh_interval %q", cfg.RefreshInterval)
+		}
+		m.interval = interval
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	if cfg.Vault != nil {
+		source, err := newVaultSource(*cfg.Vault, client)
+		if err != nil {
+			return nil, fmt.Errorf("vault: %w", err)
+		}
+		m.sources["vault"] = source
+	}
+	if cfg.AWS != nil {
+		source, err := newAWSSource(*cfg.AWS, client)
+		if err != nil {
+			return nil, fmt.Errorf("aws: %w", err)
+		}
+		m.sources["aws"] = source
+	}
+	if cfg.GCP != nil {
+		source, err := newGCPSource(*cfg.GCP, client)
+		if err != nil {
+			return nil, fmt.Errorf("gcp: %w", err)
+		}
+		m.sources["gcp"] = source
+	}
+	return m, nil
+}
+
+// AddSource registers a source for secret://name/... references, e.g. one
+// provided by a plugin
+func (m *Manager) AddSource(name string, source Source) {
+	m.sources[name] = source
+}
+
+// Resolve returns the secret a reference names, or value itself when it
+// isn't a reference
+func (m *Manager) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+
+	m.mu.Lock()
+	if e, ok := m.entries[value]; ok {
+		m.mu.Unlock()
+		return e.value, nil
+	}
+	m.mu.Unlock()
+
+	secret, err := m.fetch(ctx, value)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[value]; ok {
+		return e.value, nil
+	}
+	m.entries[value] = &entry{value: secret, fetchedAt: time.Now()}
+	return secret, nil
+}
+
+// Watch resolves a reference and calls fn with its new value whenever a
+// refetch finds it changed, so rotated secrets take effect without a restart
+func (m *Manager) Watch(ctx context.Context, ref string, fn func(string)) (string, error) {
+	value, err := m.Resolve(ctx, ref)
+	if err != nil || !IsReference(ref) {
+		return value, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[ref]
+	e.watchers = append(e.watchers, fn)
+	return e.value, nil
+}
+
+// fetch reads a reference from its source
+func (m *Manager) fetch(ctx context.Context, ref string) (string, error) {
+	name, path, field, err := parseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	source, ok := m.sources[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s: source %s is not configured", ref, name)
+	}
+	value, err := source.Fetch(ctx, path, field)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", ref, err)
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return value, nil
+}
+
+// ResolveConfig replaces every reference in the config with its secret. API
+// keys are left for their key pools, which watch them for rotation.
+func (m *Manager) ResolveConfig(ctx context.Context, cfg *config.Config) error {
+	return m.resolveValue(ctx, reflect.ValueOf(cfg).Elem(), nil)
+}
+
+// ResolvedPaths returns where ResolveConfig put secrets, as paths of YAML
+// keys and list indexes, so config dumps can redact them whatever they're called
+func (m *Manager) ResolvedPaths() [][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]string(nil), m.resolved...)
+}
+
+var (
+	apiKeyConfigType  = reflect.TypeOf(config.APIKeyConfig{})
+	secretsConfigType = reflect.TypeOf(config.SecretsConfig{})
+)
+
+// resolveValue walks structs, pointers, slices, maps and interfaces,
+// resolving each string it finds. path is where v sits in the YAML config.
+func (m *Manager) resolveValue(ctx context.Context, v reflect.Value, path []string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !IsReference(v.String()) {
+			return nil
+		}
+		secret, err := m.Resolve(ctx, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(secret)
+		m.mu.Lock()
+		m.resolved = append(m.resolved, append([]string(nil), path...))
+		m.mu.Unlock()
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return m.resolveValue(ctx, v.Elem(), path)
+		}
+	case reflect.Struct:
+		if v.Type() == apiKeyConfigType || v.Type() == secretsConfigType {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, inline, skip := yamlKey(field)
+			if skip {
+				continue
+			}
+			fieldPath := path
+			if !inline {
+				fieldPath = append(path[:len(path):len(path)], name)
+			}
+			if err := m.resolveValue(ctx, v.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := m.resolveValue(ctx, v.Index(i), append(path[:len(path):len(path)], strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map values can't be set in place, so each is resolved in a copy
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+			if err := m.resolveValue(ctx, value, append(path[:len(path):len(path)], fmt.Sprint(iter.Key()))); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), value)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		value := reflect.New(v.Elem().Type()).Elem()
+		value.Set(v.Elem())
+		if err := m.resolveValue(ctx, value, path); err != nil {
+			return err
+		}
+		v.Set(value)
+	}
+	return nil
+}
+
+// yamlKey returns the key a struct field is written under in YAML, whether
+// its fields are inlined into the parent's, or that it isn't written at all
+func yamlKey(field reflect.StructField) (name string, inline, skip bool) {
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false, true
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	for _, option := range strings.Split(options, ",") {
+		if option == "inline" {
+			return "", true, false
+		}
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, false, false
+}
+
+// Start refetches watched references every refresh_interval
+func (m *Manager) Start() {
+	if m.interval == 0 || m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.Refresh(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop ends periodic refetching
+func (m *Manager) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+// Refresh refetches watched references and notifies their watchers of
+// changes. A failed refetch keeps the previous value.
+func (m *Manager) Refresh(ctx context.Context) {
+	m.mu.Lock()
+	refs := make([]string, 0, len(m.entries))
+	for ref, e := range m.entries {
+		if len(e.watchers) > 0 {
+			refs = append(refs, ref)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ref := range refs {
+		value, err := m.fetch(ctx, ref)
+
+		m.mu.Lock()
+		e := m.entries[ref]
+		e.err = err
+		changed := err == nil && value != e.value
+		if err == nil {
+			e.value = value
+			e.fetchedAt = time.Now()
+		}
+		if changed {
+			e.refreshes++
+		}
+		watchers := append([]func(string){}, e.watchers...)
+		m.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Warning: Failed to refresh %v", err)
+			continue
+		}
+		if changed {
+			log.Printf("🔑 Secret %s changed, applying the new value", ref)
+			for _, fn := range watchers {
+				fn(value)
+			}
+		}
+	}
+}
+
+// Status describes resolved references for status endpoints, never their values
+func (m *Manager) Status() interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sources := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+
+	refs := make(map[string]interface{}, len(m.entries))
+	for ref, e := range m.entries {
+		described := map[string]interface{}{
+			"fetched_at": e.fetchedAt,
+			"watched":    len(e.watchers) > 0,
+			"changes":    e.refreshes,
+		}
+		if e.err != nil {
+			described["error"] = e.err.Error()
+		}
+		refs[ref] = described
+	}
+
+	status := map[string]interface{}{
+		"sources":    sources,
+		"references": refs,
+	}
+	if m.interval > 0 {
+		status["refresh_interval"] = m.interval.String()
+	}
+	return status
+}
+
+// envSource reads secrets from environment variables
+type envSource struct{}
+
+func (envSource) Fetch(ctx context.Context, path, field string) (string, error) {
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", path)
+	}
+	return jsonField(value, field)
+}
+
+// fileSource reads secrets from files, such as mounted Kubernetes or Docker
+// secrets. The path after secret://file is absolute.
+type fileSource struct{}
+
+func (fileSource) Fetch(ctx context.Context, path, field string) (string, error) {
+	data, err := os.ReadFile("/" + path)
+	if err != nil {
+		return "", err
+	}
+	return jsonField(strings.TrimRight(string(data), "\r\n"), field)
+}
+
+// jsonField returns a field of a JSON object secret, or the secret itself
+// when no field is named
+func jsonField(secret, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &object); err != nil {
+		return "", fmt.Errorf("field %s requested but the secret isn't a JSON object", field)
+	}
+	return fieldOf(object, field)
+}
+
+// fieldOf returns a field of a decoded secret, encoding values that aren't strings
+func fieldOf(object map[string]interface{}, field string) (string, error) {
+	value, ok := object[field]
+	if !ok {
+		return "", fmt.Errorf("no field %s", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
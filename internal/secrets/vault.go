@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// vaultSource reads secrets from Vault's KV version 2 engine. A path's first
+// segment is the mount, as with vault kv get: secret://vault/secret/gateway
+// reads gateway from the mount named secret.
+type vaultSource struct {
+	address   string
+	token     string
+	tokenFile string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultSource(cfg config.VaultSecretsConfig, client *http.Client) (*vaultSource, error) {
+	s := &vaultSource{
+		address:   strings.TrimRight(cfg.Address, "/"),
+		token:     cfg.Token,
+		tokenFile: cfg.TokenFile,
+		namespace: cfg.Namespace,
+		client:    client,
+	}
+	if s.address == "" {
+		s.address = strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	}
+	if s.address == "" {
+		return nil, fmt.Errorf("address is required (or VAULT_ADDR)")
+	}
+	if s.token == "" && s.tokenFile == "" {
+		s.token = os.Getenv("VAULT_TOKEN")
+	}
+	if s.token == "" && s.tokenFile == "" {
+		return nil, fmt.Errorf("token or token_file is required (or VAULT_TOKEN)")
+	}
+	return s, nil
+}
+
+func (s *vaultSource) Fetch(ctx context.Context, path, field string) (string, error) {
+	mount, name, ok := strings.Cut(path, "/")
+	if !ok || name == "" {
+		return "", fmt.Errorf("vault path must be mount/path")
+	}
+
+	token := s.token
+	if s.tokenFile != "" {
+		data, err := os.ReadFile(s.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.address+"/v1/"+mount+"/data/"+name, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	data := secret.Data.Data
+
+	// A secret with one field needs no #field
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret has %d fields, name one with #field", len(data))
+		}
+		for name := range data {
+			field = name
+		}
+	}
+	return fieldOf(data, field)
+}
@@ -0,0 +1,90 @@
+package slo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Alert events
+const (
+	EventBreached  = "slo.breached"
+	EventRecovered = "slo.recovered"
+)
+
+// Alert is sent when a provider and model start or stop missing an objective
+type Alert struct {
+	Event     string    `json:"event"`
+	Objective string    `json:"objective"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"` // When the breach started
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newAlert(event string, key seriesKey, breach Breach, now time.Time) Alert {
+	return Alert{
+		Event:     event,
+		Objective: breach.Objective,
+		Provider:  key.provider,
+		Model:     key.model,
+		Metric:    breach.Metric,
+		Value:     breach.Value,
+		Threshold: breach.Threshold,
+		Since:     breach.Since,
+		Timestamp: now,
+	}
+}
+
+// notifier logs alerts and posts them to webhooks
+type notifier struct {
+	webhooks []string
+	client   *http.Client
+}
+
+func newNotifier(webhooks []string) *notifier {
+	return &notifier{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// notify delivers an alert in the background, so evaluation never waits on it
+func (n *notifier) notify(alert Alert) {
+	logBreach(alert)
+	for _, url := range n.webhooks {
+		go n.post(url, alert)
+	}
+}
+
+func (n *notifier) post(url string, alert Alert) {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("[SLO] Failed to encode alert: %v", err)
+		return
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("[SLO] Failed to deliver alert to %s: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[SLO] Alert webhook %s returned %d", url, resp.StatusCode)
+	}
+}
+
+// logBreach describes an alert for the log
+func logBreach(alert Alert) {
+	unit := "ms"
+	if alert.Metric == MetricErrorRate {
+		unit = ""
+	}
+	log.Printf("[SLO] %s %s/%s: %s %.4g%s against %.4g%s (objective %s)",
+		alert.Event, alert.Provider, alert.Model, alert.Metric, alert.Value, unit, alert.Threshold, unit, alert.Objective)
+}
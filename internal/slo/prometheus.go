@@ -0,0 +1,55 @@
+package slo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WritePrometheus writes the window's stats in the Prometheus text exposition format
+func (t *Tracker) WritePrometheus(w io.Writer) {
+	snapshot := t.Snapshot()
+
+	gauge := func(name, help string, value func(Stats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, stats := range snapshot {
+			fmt.Fprintf(w, "%s{%s} %g\n", name, labels(stats), value(stats))
+		}
+	}
+	gauge("flash_gateway_upstream_requests", "Upstream requests in the SLO window.",
+		func(s Stats) float64 { return float64(s.Requests) })
+	gauge("flash_gateway_upstream_errors", "Failed upstream requests in the SLO window.",
+		func(s Stats) float64 { return float64(s.Errors) })
+	gauge("flash_gateway_upstream_error_rate", "Fraction of upstream requests in the SLO window that failed.",
+		func(s Stats) float64 { return s.ErrorRate })
+
+	const latency = "flash_gateway_upstream_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Upstream latency percentiles over the SLO window, until response headers.\n# TYPE %s gauge\n", latency, latency)
+	for _, stats := range snapshot {
+		for _, q := range []struct {
+			quantile string
+			ms       float64
+		}{{"0.5", stats.P50Ms}, {"0.95", stats.P95Ms}, {"0.99", stats.P99Ms}} {
+			fmt.Fprintf(w, "%s{%s,quantile=%q} %g\n", latency, labels(stats), q.quantile, q.ms/1000)
+		}
+	}
+
+	const breached = "flash_gateway_slo_breached"
+	fmt.Fprintf(w, "# HELP %s Objectives a provider and model are currently missing.\n# TYPE %s gauge\n", breached, breached)
+	for _, stats := range snapshot {
+		for _, breach := range stats.Breaches {
+			fmt.Fprintf(w, "%s{%s,objective=\"%s\",metric=\"%s\"} 1\n", breached, labels(stats), escapeLabel(breach.Objective), breach.Metric)
+		}
+	}
+}
+
+// labels renders a series' provider and model labels
+func labels(stats Stats) string {
+	return fmt.Sprintf("provider=\"%s\",model=\"%s\"", escapeLabel(stats.Provider), escapeLabel(stats.Model))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
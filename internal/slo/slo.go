@@ -0,0 +1,419 @@
+// Package slo tracks rolling latency percentiles and error rates for each
+// provider and model, and alerts when they miss configured objectives.
+// Routing reads the same breaches to steer traffic away from slow or failing
+// models.
+package slo
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// windowBuckets is how many slices the rolling window is divided into
+const windowBuckets = 10
+
+// Latencies are counted in exponential histogram buckets from 1ms, each 20%
+// wider than the last, so percentiles are within 10% up to half an hour
+const (
+	histogramBuckets = 80
+	histogramBase    = float64(time.Millisecond)
+	histogramGrowth  = 1.2
+)
+
+// Metrics an objective can set thresholds on
+const (
+	MetricP50       = "p50_latency"
+	MetricP95       = "p95_latency"
+	MetricP99       = "p99_latency"
+	MetricErrorRate = "error_rate"
+)
+
+// objective is a compiled SLO objective
+type objective struct {
+	name      string
+	filter    guardrails.Applicability
+	latency   map[string]time.Duration // metric -> threshold
+	errorRate float64
+}
+
+// seriesKey identifies the requests a series counts
+type seriesKey struct {
+	provider string
+	model    string
+}
+
+// bucket counts requests in one slice of the window
+type bucket struct {
+	epoch     int64
+	requests  int
+	errors    int
+	latencies [histogramBuckets + 1]uint32 // the last counts anything slower
+}
+
+// series is one provider and model's window and its current breaches
+type series struct {
+	buckets  [windowBuckets]bucket
+	breaches map[string]Breach // objective/metric -> breach
+}
+
+// Breach is an objective a provider and model are currently missing
+type Breach struct {
+	Objective string    `json:"objective"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`     // milliseconds for latencies
+	Threshold float64   `json:"threshold"` // milliseconds for latencies
+	Since     time.Time `json:"since"`
+}
+
+// Stats summarizes one provider and model over the window
+type Stats struct {
+	Provider  string   `json:"provider"`
+	Model     string   `json:"model"`
+	Requests  int      `json:"requests"`
+	Errors    int      `json:"errors"`
+	ErrorRate float64  `json:"error_rate"`
+	P50Ms     float64  `json:"p50_ms"`
+	P95Ms     float64  `json:"p95_ms"`
+	P99Ms     float64  `json:"p99_ms"`
+	Breaches  []Breach `json:"breaches,omitempty"`
+}
+
+// Tracker records upstream request outcomes per provider and model
+type Tracker struct {
+	window      time.Duration
+	bucketWidth time.Duration
+	minRequests int
+	objectives  []objective
+	notifier    *notifier
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a tracker from configuration
+func New(cfg config.SLOConfig) (*Tracker, error) {
+	window := 5 * time.Minute
+	if cfg.Window != "" {
+		parsed, err := time.ParseDuration(cfg.Window)
+		if err != nil || parsed < windowBuckets*time.Second {
+			return nil, fmt.Errorf("invalid window %q: must be at least %ds", cfg.Window, windowBuckets)
+		}
+		window = parsed
+	}
+	if cfg.MinRequests < 0 {
+		return nil, fmt.Errorf("min_requests must not be negative")
+	}
+
+	t := &Tracker{
+		window:      window,
+		bucketWidth: window / windowBuckets,
+		minRequests: cfg.MinRequests,
+		notifier:    newNotifier(cfg.Webhooks),
+		series:      make(map[seriesKey]*series),
+		stop:        make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for i, oc := range cfg.Objectives {
+		name := oc.Name
+		if name == "" {
+			name = fmt.Sprintf("objective_%d", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate objective %s", name)
+		}
+		names[name] = true
+
+		o := objective{
+			name:      name,
+			filter:    guardrails.Applicability{Providers: oc.Providers, Models: oc.Models},
+			latency:   make(map[string]time.Duration),
+			errorRate: oc.ErrorRate,
+		}
+		for metric, value := range map[string]string{MetricP50: oc.P50Latency, MetricP95: oc.P95Latency, MetricP99: oc.P99Latency} {
+			if value == "" {
+				continue
+			}
+			threshold, err := time.ParseDuration(value)
+			if err != nil || threshold <= 0 {
+				return nil, fmt.Errorf("objective %s: invalid %s %q", name, metric, value)
+			}
+			o.latency[metric] = threshold
+		}
+		if o.errorRate < 0 || o.errorRate > 1 {
+			return nil, fmt.Errorf("objective %s: error_rate must be between 0 and 1", name)
+		}
+		if len(o.latency) == 0 && o.errorRate == 0 {
+			return nil, fmt.Errorf("objective %s: set a latency threshold or error_rate", name)
+		}
+		t.objectives = append(t.objectives, o)
+	}
+	return t, nil
+}
+
+// Observe records an upstream request. latency is the time until the
+// response headers arrived, so streams count their time to first byte.
+func (t *Tracker) Observe(provider, model string, latency time.Duration, failed bool) {
+	epoch := time.Now().UnixNano() / int64(t.bucketWidth)
+	key := seriesKey{provider: provider, model: model}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[key]
+	if !ok {
+		s = &series{breaches: make(map[string]Breach)}
+		t.series[key] = s
+	}
+	b := &s.buckets[epoch%windowBuckets]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+	b.latencies[histogramIndex(latency)]++
+}
+
+// histogramIndex returns the latency bucket a duration falls in
+func histogramIndex(latency time.Duration) int {
+	if float64(latency) <= histogramBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(latency)/histogramBase) / math.Log(histogramGrowth)))
+	if i > histogramBuckets {
+		return histogramBuckets
+	}
+	return i
+}
+
+// histogramBound returns the upper bound of a latency bucket
+func histogramBound(i int) float64 {
+	return histogramBase * math.Pow(histogramGrowth, float64(i))
+}
+
+// Stats returns a provider and model's stats over the window, reporting
+// false when the window holds fewer than min_requests requests
+func (t *Tracker) Stats(provider, model string) (Stats, bool) {
+	key := seriesKey{provider: provider, model: model}
+	epoch := t.currentEpoch(time.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[key]
+	if !ok {
+		return Stats{Provider: provider, Model: model}, false
+	}
+	stats := s.stats(key, epoch)
+	return stats, stats.Requests > 0 && stats.Requests >= t.minRequests
+}
+
+// Breached reports whether a provider and model are missing any objective
+func (t *Tracker) Breached(provider, model string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[seriesKey{provider: provider, model: model}]
+	return ok && len(s.breaches) > 0
+}
+
+// Start begins judging objectives every slice of the window, or 10s if sooner
+func (t *Tracker) Start() {
+	interval := t.bucketWidth
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.evaluate(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop ends objective evaluation
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// evaluate compares every series with its objectives, alerting on breaches
+// that start or end. A series without min_requests in the window is not
+// judged, so its breaches clear once traffic moves away from it.
+func (t *Tracker) evaluate(now time.Time) {
+	var alerts []Alert
+
+	t.mu.Lock()
+	for key, s := range t.series {
+		stats := s.stats(key, t.currentEpoch(now))
+		if stats.Requests == 0 {
+			delete(t.series, key)
+		}
+
+		current := make(map[string]Breach)
+		if stats.Requests > 0 && stats.Requests >= t.minRequests {
+			for _, o := range t.objectives {
+				if !o.filter.Matches(guardrails.Scope{Provider: key.provider, Model: key.model}) {
+					continue
+				}
+				for _, breach := range o.check(stats) {
+					id := breach.Objective + "/" + breach.Metric
+					if previous, ok := s.breaches[id]; ok {
+						breach.Since = previous.Since
+					} else {
+						breach.Since = now
+						alerts = append(alerts, newAlert(EventBreached, key, breach, now))
+					}
+					current[id] = breach
+				}
+			}
+		}
+		for id, breach := range s.breaches {
+			if _, ok := current[id]; !ok {
+				alerts = append(alerts, newAlert(EventRecovered, key, breach, now))
+			}
+		}
+		s.breaches = current
+	}
+	t.mu.Unlock()
+
+	for _, alert := range alerts {
+		t.notifier.notify(alert)
+	}
+}
+
+// check returns the thresholds stats exceed
+func (o objective) check(stats Stats) []Breach {
+	var breaches []Breach
+	values := map[string]float64{MetricP50: stats.P50Ms, MetricP95: stats.P95Ms, MetricP99: stats.P99Ms}
+	for _, metric := range []string{MetricP50, MetricP95, MetricP99} {
+		threshold, ok := o.latency[metric]
+		if !ok {
+			continue
+		}
+		limit := float64(threshold) / float64(time.Millisecond)
+		if values[metric] > limit {
+			breaches = append(breaches, Breach{Objective: o.name, Metric: metric, Value: values[metric], Threshold: limit})
+		}
+	}
+	if o.errorRate > 0 && stats.ErrorRate > o.errorRate {
+		breaches = append(breaches, Breach{Objective: o.name, Metric: MetricErrorRate, Value: stats.ErrorRate, Threshold: o.errorRate})
+	}
+	return breaches
+}
+
+func (t *Tracker) currentEpoch(now time.Time) int64 {
+	return now.UnixNano() / int64(t.bucketWidth)
+}
+
+// stats merges the buckets still inside the window
+func (s *series) stats(key seriesKey, epoch int64) Stats {
+	stats := Stats{Provider: key.provider, Model: key.model}
+	var latencies [histogramBuckets + 1]uint32
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if epoch-b.epoch >= windowBuckets {
+			continue
+		}
+		stats.Requests += b.requests
+		stats.Errors += b.errors
+		for j, count := range b.latencies {
+			latencies[j] += count
+		}
+	}
+	if stats.Requests == 0 {
+		return stats
+	}
+
+	stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	stats.P50Ms = percentile(latencies[:], stats.Requests, 0.50)
+	stats.P95Ms = percentile(latencies[:], stats.Requests, 0.95)
+	stats.P99Ms = percentile(latencies[:], stats.Requests, 0.99)
+	for _, breach := range s.breaches {
+		stats.Breaches = append(stats.Breaches, breach)
+	}
+	sort.Slice(stats.Breaches, func(i, j int) bool {
+		if stats.Breaches[i].Objective != stats.Breaches[j].Objective {
+			return stats.Breaches[i].Objective < stats.Breaches[j].Objective
+		}
+		return stats.Breaches[i].Metric < stats.Breaches[j].Metric
+	})
+	return stats
+}
+
+// percentile estimates a quantile in milliseconds from the histogram,
+// interpolating within the bucket it falls in
+func percentile(latencies []uint32, total int, q float64) float64 {
+	rank := q * float64(total)
+	var seen float64
+	for i, count := range latencies {
+		if count == 0 {
+			continue
+		}
+		if seen+float64(count) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = histogramBound(i - 1)
+			}
+			upper := histogramBound(i)
+			fraction := (rank - seen) / float64(count)
+			return math.Round((lower+(upper-lower)*fraction)/float64(time.Millisecond)*10) / 10
+		}
+		seen += float64(count)
+	}
+	return math.Round(histogramBound(len(latencies)-1)/float64(time.Millisecond)*10) / 10
+}
+
+// Snapshot returns stats for every provider and model seen in the window
+func (t *Tracker) Snapshot() []Stats {
+	epoch := t.currentEpoch(time.Now())
+
+	t.mu.Lock()
+	snapshot := make([]Stats, 0, len(t.series))
+	for key, s := range t.series {
+		if stats := s.stats(key, epoch); stats.Requests > 0 {
+			snapshot = append(snapshot, stats)
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Provider != snapshot[j].Provider {
+			return snapshot[i].Provider < snapshot[j].Provider
+		}
+		return snapshot[i].Model < snapshot[j].Model
+	})
+	return snapshot
+}
+
+// Status returns the tracker's settings and current stats for status endpoints
+func (t *Tracker) Status() map[string]interface{} {
+	breached := 0
+	snapshot := t.Snapshot()
+	for _, stats := range snapshot {
+		if len(stats.Breaches) > 0 {
+			breached++
+		}
+	}
+	return map[string]interface{}{
+		"window":       t.window.String(),
+		"min_requests": t.minRequests,
+		"objectives":   len(t.objectives),
+		"breached":     breached,
+		"models":       snapshot,
+	}
+}
@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultMemoryMaxLogs is how many logs memory storage keeps unless configured otherwise
+const defaultMemoryMaxLogs = 10000
+
+// MemoryStorage implements StorageBackend in memory, for development and
+// tests without PostgreSQL. It keeps the most recent logs, up to a count and
+// optionally an age, and loses them on restart.
+type MemoryStorage struct {
+	maxLogs int
+	maxAge  time.Duration
+
+	mu   sync.RWMutex
+	logs []*RequestLog // In the order they were saved
+	ids  map[uuid.UUID]bool
+}
+
+// MemoryConfig holds configuration for memory storage
+type MemoryConfig struct {
+	MaxLogs int           // Oldest logs are dropped past this many
+	MaxAge  time.Duration // Logs older than this are dropped; 0 keeps them until MaxLogs
+}
+
+// NewMemoryStorage creates a new memory storage backend
+func NewMemoryStorage(config MemoryConfig) *MemoryStorage {
+	if config.MaxLogs <= 0 {
+		config.MaxLogs = defaultMemoryMaxLogs
+	}
+	return &MemoryStorage{
+		maxLogs: config.MaxLogs,
+		maxAge:  config.MaxAge,
+		ids:     make(map[uuid.UUID]bool),
+	}
+}
+
+// SaveRequestLog saves a single request log
+func (m *MemoryStorage) SaveRequestLog(ctx context.Context, requestLog *RequestLog) error {
+	return m.SaveRequestLogsBatch(ctx, []*RequestLog{requestLog})
+}
+
+// SaveRequestLogsBatch saves copies of logs, ignoring any already stored so
+// spill replays are idempotent as they are with PostgreSQL
+func (m *MemoryStorage) SaveRequestLogsBatch(ctx context.Context, logs []*RequestLog) error {
+	stored := make([]*RequestLog, 0, len(logs))
+	for _, log := range logs {
+		copied, err := copyLog(log)
+		if err != nil {
+			return fmt.Errorf("failed to store log %s: %w", log.ID, err)
+		}
+		stored = append(stored, copied)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, log := range stored {
+		if m.ids[log.ID] {
+			continue
+		}
+		m.ids[log.ID] = true
+		m.logs = append(m.logs, log)
+	}
+	m.prune(time.Now())
+	return nil
+}
+
+// prune drops logs past the count and age limits. Callers hold m.mu for writing.
+func (m *MemoryStorage) prune(now time.Time) {
+	drop := 0
+	if len(m.logs) > m.maxLogs {
+		drop = len(m.logs) - m.maxLogs
+	}
+	if m.maxAge > 0 {
+		cutoff := now.Add(-m.maxAge)
+		for drop < len(m.logs) && m.logs[drop].Timestamp.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	for _, log := range m.logs[:drop] {
+		delete(m.ids, log.ID)
+	}
+	m.logs = append([]*RequestLog(nil), m.logs[drop:]...)
+}
+
+// GetRequestLogs retrieves request logs based on filter criteria
+func (m *MemoryStorage) GetRequestLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, error) {
+	m.mu.RLock()
+	logs := make([]*RequestLog, 0, len(m.logs))
+	for _, log := range m.logs {
+		if m.live(log) && matchesFilter(log, filter) {
+			logs = append(logs, log)
+		}
+	}
+	m.mu.RUnlock()
+
+	// Order as PostgreSQL does, including the cursor's timestamp order
+	orderBy := "timestamp"
+	if sortableColumns[filter.OrderBy] && filter.After == nil {
+		orderBy = filter.OrderBy
+	}
+	ascending := strings.EqualFold(filter.OrderDir, "ASC")
+	sort.SliceStable(logs, func(i, j int) bool {
+		c := compareLogs(logs[i], logs[j], orderBy)
+		if ascending {
+			return c < 0
+		}
+		return c > 0
+	})
+
+	if filter.After != nil {
+		after := &RequestLog{Timestamp: filter.After.Timestamp, ID: filter.After.ID}
+		start := sort.Search(len(logs), func(i int) bool {
+			c := compareLogs(logs[i], after, orderBy)
+			if ascending {
+				return c > 0
+			}
+			return c < 0
+		})
+		logs = logs[start:]
+	}
+	if filter.Limit > 0 {
+		if filter.Offset > 0 {
+			if filter.Offset > len(logs) {
+				filter.Offset = len(logs)
+			}
+			logs = logs[filter.Offset:]
+		}
+		if len(logs) > filter.Limit {
+			logs = logs[:filter.Limit]
+		}
+	}
+
+	result := make([]*RequestLog, len(logs))
+	for i, log := range logs {
+		copied := *log
+		result[i] = &copied
+	}
+	return result, nil
+}
+
+// GetRequestLogByID retrieves a single request log by ID
+func (m *MemoryStorage) GetRequestLogByID(ctx context.Context, id string) (*RequestLog, error) {
+	logID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, log := range m.logs {
+		if log.ID == logID && m.live(log) {
+			copied := *log
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetLogStats retrieves aggregated statistics over the logs matching filter
+func (m *MemoryStorage) GetLogStats(ctx context.Context, filter LogFilter) (*LogStats, error) {
+	stats := &LogStats{
+		TopEndpoints:     []EndpointStats{},
+		StatusCodeCounts: make(map[string]int64),
+		ProviderStats:    make(map[string]int64),
+	}
+
+	type endpointTotals struct {
+		requests, errors, latencies int64
+		latency                     float64
+	}
+	endpoints := make(map[string]*endpointTotals)
+	var errorCount, successes int64
+	var successLatency float64
+	var first, last time.Time
+
+	m.mu.RLock()
+	for _, log := range m.logs {
+		if !m.live(log) || !matchesFilter(log, filter) {
+			continue
+		}
+		stats.TotalRequests++
+		if first.IsZero() || log.Timestamp.Before(first) {
+			first = log.Timestamp
+		}
+		if log.Timestamp.After(last) {
+			last = log.Timestamp
+		}
+
+		failed := (log.StatusCode != nil && *log.StatusCode >= 400) || log.Error != nil
+		if failed {
+			errorCount++
+		}
+		if log.LatencyMs != nil && log.StatusCode != nil && *log.StatusCode < 400 {
+			successes++
+			successLatency += float64(*log.LatencyMs)
+		}
+
+		endpoint := endpoints[log.Endpoint]
+		if endpoint == nil {
+			endpoint = &endpointTotals{}
+			endpoints[log.Endpoint] = endpoint
+		}
+		endpoint.requests++
+		if failed {
+			endpoint.errors++
+		}
+		if log.LatencyMs != nil {
+			endpoint.latencies++
+			endpoint.latency += float64(*log.LatencyMs)
+		}
+
+		code := "unknown"
+		if log.StatusCode != nil {
+			code = fmt.Sprintf("%d", *log.StatusCode)
+		}
+		stats.StatusCodeCounts[code]++
+		provider := "unknown"
+		if log.Provider != nil {
+			provider = *log.Provider
+		}
+		stats.ProviderStats[provider]++
+	}
+	m.mu.RUnlock()
+
+	if stats.TotalRequests > 0 {
+		stats.ErrorRate = float64(errorCount) / float64(stats.TotalRequests)
+		spanHours := last.Sub(first).Hours()
+		if spanHours < 1 {
+			spanHours = 1
+		}
+		stats.RequestsPerHour = int64(float64(stats.TotalRequests) / spanHours)
+	}
+	if successes > 0 {
+		stats.AverageLatency = successLatency / float64(successes)
+	}
+
+	for name, totals := range endpoints {
+		endpoint := EndpointStats{
+			Endpoint:     name,
+			RequestCount: totals.requests,
+			ErrorRate:    float64(totals.errors) / float64(totals.requests),
+		}
+		if totals.latencies > 0 {
+			endpoint.AverageLatency = totals.latency / float64(totals.latencies)
+		}
+		stats.TopEndpoints = append(stats.TopEndpoints, endpoint)
+	}
+	sort.Slice(stats.TopEndpoints, func(i, j int) bool {
+		a, b := stats.TopEndpoints[i], stats.TopEndpoints[j]
+		if a.RequestCount != b.RequestCount {
+			return a.RequestCount > b.RequestCount
+		}
+		return a.Endpoint < b.Endpoint
+	})
+	if len(stats.TopEndpoints) > 10 {
+		stats.TopEndpoints = stats.TopEndpoints[:10]
+	}
+	return stats, nil
+}
+
+// Close releases nothing; logs are kept until the process exits
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// live reports whether a log is still within the age limit. Reads check
+// it so expired logs disappear even when nothing is being saved.
+func (m *MemoryStorage) live(log *RequestLog) bool {
+	return m.maxAge <= 0 || !log.Timestamp.Before(time.Now().Add(-m.maxAge))
+}
+
+// copyLog stores a log as PostgreSQL would return it: headers and metadata
+// round-tripped through JSON, and guardrail metrics, which have their own
+// table there, left off
+func copyLog(log *RequestLog) (*RequestLog, error) {
+	copied := *log
+	copied.GuardrailMetrics = nil
+	for _, field := range []*map[string]interface{}{&copied.RequestHeaders, &copied.ResponseHeaders, &copied.Metadata} {
+		if *field == nil {
+			continue
+		}
+		data, err := json.Marshal(*field)
+		if err != nil {
+			return nil, err
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return nil, err
+		}
+		*field = decoded
+	}
+	return &copied, nil
+}
+
+// matchesFilter applies the conditions filterConditions renders for PostgreSQL.
+// Feedback is stored in PostgreSQL only, so no log has a rating or score here.
+func matchesFilter(log *RequestLog, filter LogFilter) bool {
+	if filter.StartTime != nil && log.Timestamp.Before(*filter.StartTime) {
+		return false
+	}
+	if filter.EndTime != nil && log.Timestamp.After(*filter.EndTime) {
+		return false
+	}
+	if filter.Endpoint != nil && log.Endpoint != *filter.Endpoint {
+		return false
+	}
+	if filter.Method != nil && log.Method != *filter.Method {
+		return false
+	}
+	if filter.StatusCode != nil && (log.StatusCode == nil || *log.StatusCode != *filter.StatusCode) {
+		return false
+	}
+	if !stringMatches(log.Provider, filter.Provider) || !stringMatches(log.SessionID, filter.SessionID) ||
+		!stringMatches(log.ConversationID, filter.ConversationID) || !stringMatches(log.TenantID, filter.TenantID) {
+		return false
+	}
+	if filter.HasError != nil && *filter.HasError != (log.Error != nil) {
+		return false
+	}
+
+	if filter.Search != nil && strings.TrimSpace(*filter.Search) != "" {
+		search := strings.ToLower(*filter.Search)
+		if !strings.Contains(strings.ToLower(derefString(log.RequestBody)), search) &&
+			!strings.Contains(strings.ToLower(derefString(log.ResponseBody)), search) {
+			return false
+		}
+	}
+	if filter.Model != nil && logModel(log) != *filter.Model {
+		return false
+	}
+	if filter.MinTokens != nil || filter.MaxTokens != nil {
+		tokens := int64(metadataNumber(log.Metadata, "cost", "prompt_tokens") + metadataNumber(log.Metadata, "cost", "completion_tokens"))
+		if (filter.MinTokens != nil && tokens < *filter.MinTokens) || (filter.MaxTokens != nil && tokens > *filter.MaxTokens) {
+			return false
+		}
+	}
+	if filter.MinCost != nil || filter.MaxCost != nil {
+		cost, ok := metadataValue(log.Metadata, "cost", "total_cost").(float64)
+		if !ok || (filter.MinCost != nil && cost < *filter.MinCost) || (filter.MaxCost != nil && cost > *filter.MaxCost) {
+			return false
+		}
+	}
+	if filter.Rating != nil && (*filter.Rating == "up" || *filter.Rating == "down") {
+		return false
+	}
+	if filter.MinScore != nil {
+		return false
+	}
+	if filter.GuardrailStatus != nil {
+		blocked := log.Metadata["guardrail_block"] != nil
+		modified := log.Metadata["response_modified_by"] != nil
+		switch *filter.GuardrailStatus {
+		case GuardrailPassed:
+			return !blocked && !modified
+		case GuardrailModified:
+			return modified
+		case GuardrailBlocked:
+			return blocked
+		}
+	}
+	return true
+}
+
+// compareLogs orders two logs by a sortable column, breaking timestamp ties
+// by id. Missing values sort last, as NULLs do in PostgreSQL.
+func compareLogs(a, b *RequestLog, column string) int {
+	switch column {
+	case "latency_ms":
+		return compareOptional(a.LatencyMs, b.LatencyMs)
+	case "status_code":
+		return compareOptional(a.StatusCode, b.StatusCode)
+	case "endpoint":
+		return strings.Compare(a.Endpoint, b.Endpoint)
+	case "provider":
+		if a.Provider == nil || b.Provider == nil {
+			return compareMissing(a.Provider == nil, b.Provider == nil)
+		}
+		return strings.Compare(*a.Provider, *b.Provider)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+func compareOptional[T int | int64](a, b *T) int {
+	if a == nil || b == nil {
+		return compareMissing(a == nil, b == nil)
+	}
+	switch {
+	case *a < *b:
+		return -1
+	case *a > *b:
+		return 1
+	}
+	return 0
+}
+
+func compareMissing(aMissing, bMissing bool) int {
+	switch {
+	case aMissing && !bMissing:
+		return 1
+	case !aMissing && bMissing:
+		return -1
+	}
+	return 0
+}
+
+// logModel is the model cost tracking, or else token estimation, recorded
+func logModel(log *RequestLog) string {
+	if model, ok := metadataValue(log.Metadata, "cost", "model").(string); ok {
+		return model
+	}
+	model, _ := metadataValue(log.Metadata, "token_estimate", "model").(string)
+	return model
+}
+
+// metadataValue reads a field of an object in log metadata
+func metadataValue(metadata map[string]interface{}, object, field string) interface{} {
+	values, _ := metadata[object].(map[string]interface{})
+	return values[field]
+}
+
+// metadataNumber reads a numeric field of an object in log metadata, or 0
+func metadataNumber(metadata map[string]interface{}, object, field string) float64 {
+	number, _ := metadataValue(metadata, object, field).(float64)
+	return number
+}
+
+func stringMatches(value, want *string) bool {
+	return want == nil || (value != nil && *value == *want)
+}
+
+func derefString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,6 +32,30 @@ type RequestLog struct {
 	Metadata       map[string]interface{} `json:"metadata,omitempty" db:"metadata"`
 	CreatedAt      time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time              `json:"updated_at" db:"updated_at"`
+	ConversationID *string                `json:"conversation_id,omitempty" db:"conversation_id"`
+	TenantID       *string                `json:"tenant_id,omitempty" db:"tenant_id"`
+	// Guardrail metrics written in the same transaction as the log, when the outbox is enabled
+	GuardrailMetrics []*GuardrailMetric   `json:"guardrail_metrics,omitempty" db:"-"`
+}
+
+// GuardrailMetric captures performance data for a guardrail execution
+type GuardrailMetric struct {
+	ID                 uuid.UUID             `json:"id" db:"id"`
+	RequestID          uuid.UUID             `json:"request_id" db:"request_id"`
+	GuardrailName      string                `json:"guardrail_name" db:"guardrail_name"`
+	Layer              string                `json:"layer" db:"layer"` // "input" or "output"
+	Priority           int                   `json:"priority" db:"priority"`
+	StartTime          time.Time             `json:"start_time" db:"start_time"`
+	EndTime            time.Time             `json:"end_time" db:"end_time"`
+	DurationMs         int64                 `json:"duration_ms" db:"duration_ms"`
+	Passed             bool                  `json:"passed" db:"passed"`
+	Score              *float64              `json:"score" db:"score"`
+	Error              *string               `json:"error" db:"error"`
+	Metadata           map[string]interface{} `json:"metadata" db:"metadata"`
+	OriginalResponse   *string               `json:"original_response" db:"original_response"`   // Original LLM response (output guardrails only)
+	OverrideResponse   *string               `json:"override_response" db:"override_response"`   // Override response sent to client
+	ResponseOverridden bool                  `json:"response_overridden" db:"response_overridden"` // Whether response was overridden
+	CreatedAt          time.Time             `json:"created_at" db:"created_at"`
 }
 
 // LogFilter represents filtering options for querying logs
@@ -39,13 +67,66 @@ type LogFilter struct {
 	StatusCode  *int       `json:"status_code,omitempty"`
 	Provider    *string    `json:"provider,omitempty"`
 	SessionID   *string    `json:"session_id,omitempty"`
+	ConversationID *string `json:"conversation_id,omitempty"`
+	TenantID    *string    `json:"tenant_id,omitempty"`
 	HasError    *bool      `json:"has_error,omitempty"`
+	Search      *string    `json:"search,omitempty"` // Words to find in request or response bodies
+	Model       *string    `json:"model,omitempty"`
+	MinTokens   *int64     `json:"min_tokens,omitempty"` // Prompt plus completion tokens
+	MaxTokens   *int64     `json:"max_tokens,omitempty"`
+	MinCost     *float64   `json:"min_cost,omitempty"`
+	MaxCost     *float64   `json:"max_cost,omitempty"`
+	GuardrailStatus *string `json:"guardrail_status,omitempty"` // GuardrailPassed, GuardrailModified or GuardrailBlocked
+	Rating      *string    `json:"rating,omitempty"` // Client feedback: "up", "down" or "none" for logs without a rating
+	MinScore    *float64   `json:"min_score,omitempty"` // Client feedback score
+	After       *LogCursor `json:"after,omitempty"` // Keyset pagination: only logs past this one in the sort order
 	Limit       int        `json:"limit"`
 	Offset      int        `json:"offset"`
 	OrderBy     string     `json:"order_by"`
 	OrderDir    string     `json:"order_dir"`
 }
 
+// Guardrail outcomes a LogFilter can select, read from the log metadata
+const (
+	GuardrailPassed   = "passed"   // Neither blocked nor rewritten by a guardrail
+	GuardrailModified = "modified" // Response rewritten by an output guardrail
+	GuardrailBlocked  = "blocked"  // Refused by an input or output guardrail
+)
+
+// LogCursor marks a position in logs ordered by timestamp, so large result
+// sets can be paged through without OFFSET scans
+type LogCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// CursorAfter returns the cursor positioned just past a log
+func CursorAfter(log *RequestLog) *LogCursor {
+	return &LogCursor{Timestamp: log.Timestamp, ID: log.ID}
+}
+
+// String encodes the cursor as "<RFC 3339 timestamp>,<id>"
+func (c LogCursor) String() string {
+	return c.Timestamp.UTC().Format(time.RFC3339Nano) + "," + c.ID.String()
+}
+
+// ParseLogCursor decodes a cursor written by String
+func ParseLogCursor(value string) (*LogCursor, error) {
+	timestamp, id, ok := strings.Cut(value, ",")
+	if !ok {
+		return nil, fmt.Errorf("cursor must be <timestamp>,<id>")
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor timestamp: %w", err)
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor id: %w", err)
+	}
+	return &LogCursor{Timestamp: t, ID: parsed}, nil
+}
+
 // LogStats represents aggregated statistics about logs
 type LogStats struct {
 	TotalRequests    int64                  `json:"total_requests"`
@@ -96,10 +177,12 @@ func SanitizeForLog(headers map[string]interface{}) map[string]interface{} {
 		"cookie":        true,
 		"x-auth-token":  true,
 		"bearer":        true,
+
+		"x-guardrail-bypass": true,
 	}
 	
 	for key, value := range headers {
-		lowerKey := key
+		lowerKey := strings.ToLower(key)
 		if sensitiveHeaders[lowerKey] {
 			sanitized[key] = "[REDACTED]"
 		} else {
@@ -128,4 +211,14 @@ func NewRequestLog() *RequestLog {
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
-}
\ No newline at end of file
+}
+
+// APIKeyID fingerprints an API key so usage can be attributed to it without
+// the key itself being stored. Returns "" for an empty key.
+func APIKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:8])
+}
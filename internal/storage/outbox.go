@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// outboxContextKey is the context key under which a request's outbox is stored
+const outboxContextKey = "log_outbox"
+
+// Outbox collects records produced while a request is handled so they are
+// written in the same transaction as its log entry, and never without it
+type Outbox struct {
+	mu      sync.Mutex
+	metrics []*GuardrailMetric
+}
+
+// WithOutbox attaches a new outbox to a request context
+func WithOutbox(ctx context.Context) (context.Context, *Outbox) {
+	outbox := &Outbox{}
+	return context.WithValue(ctx, outboxContextKey, outbox), outbox
+}
+
+// OutboxFromContext returns the request's outbox, or nil when records should
+// be written on their own
+func OutboxFromContext(ctx context.Context) *Outbox {
+	outbox, _ := ctx.Value(outboxContextKey).(*Outbox)
+	return outbox
+}
+
+// AddGuardrailMetric queues a guardrail metric. Guardrails run in parallel,
+// so this is safe for concurrent use.
+func (o *Outbox) AddGuardrailMetric(metric *GuardrailMetric) {
+	o.mu.Lock()
+	o.metrics = append(o.metrics, metric)
+	o.mu.Unlock()
+}
+
+// GuardrailMetrics returns the queued guardrail metrics
+func (o *Outbox) GuardrailMetrics() []*GuardrailMetric {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.metrics
+}
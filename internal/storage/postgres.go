@@ -15,7 +15,8 @@ import (
 
 // PostgreSQLStorage implements StorageBackend for PostgreSQL
 type PostgreSQLStorage struct {
-	db *sql.DB
+	db             *sql.DB
+	fullTextSearch bool // Whether the body search index exists
 }
 
 // PostgreSQLConfig holds configuration for PostgreSQL connection
@@ -62,7 +63,16 @@ func NewPostgreSQLStorage(config PostgreSQLConfig) (*PostgreSQLStorage, error) {
 
 	log.Println("Connected to PostgreSQL successfully")
 
-	return &PostgreSQLStorage{db: db}, nil
+	pg := &PostgreSQLStorage{db: db}
+	// Body search uses the full-text index when upgrades have created it
+	err = db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_request_logs_body_search')",
+	).Scan(&pg.fullTextSearch)
+	if err != nil {
+		log.Printf("Warning: Could not check for the body search index, using ILIKE: %v", err)
+	}
+
+	return pg, nil
 }
 
 // SaveRequestLog saves a single request log
@@ -92,22 +102,22 @@ func (p *PostgreSQLStorage) SaveRequestLogsBatch(ctx context.Context, logs []*Re
 			id, timestamp, session_id, request_id, endpoint, method, 
 			status_code, latency_ms, provider, user_agent, remote_addr,
 			request_headers, request_body, response_headers, response_body,
-			error, metadata, created_at, updated_at
+			error, metadata, created_at, updated_at, conversation_id, tenant_id
 		) VALUES `
 
-	values := make([]interface{}, 0, len(logs)*19)
+	values := make([]interface{}, 0, len(logs)*21)
 	placeholders := make([]string, 0, len(logs))
 	t := log.Printf
 
 	for i, log := range logs {
-		placeholderStart := i*19 + 1
+		placeholderStart := i*21 + 1
 		placeholders = append(placeholders, fmt.Sprintf(
-			"($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			"($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
 			placeholderStart, placeholderStart+1, placeholderStart+2, placeholderStart+3,
 			placeholderStart+4, placeholderStart+5, placeholderStart+6, placeholderStart+7,
 			placeholderStart+8, placeholderStart+9, placeholderStart+10, placeholderStart+11,
 			placeholderStart+12, placeholderStart+13, placeholderStart+14, placeholderStart+15,
-			placeholderStart+16, placeholderStart+17, placeholderStart+18,
+			placeholderStart+16, placeholderStart+17, placeholderStart+18, placeholderStart+19, placeholderStart+20,
 		))
 
 		// Convert headers to JSON
@@ -135,19 +145,30 @@ func (p *PostgreSQLStorage) SaveRequestLogsBatch(ctx context.Context, logs []*Re
 			metadataJSON,
 			log.CreatedAt,
 			log.UpdatedAt,
+			log.ConversationID,
+			log.TenantID,
 		)
-		t("[LOG] Response body: %v", *log.ResponseBody)
+		if log.ResponseBody != nil {
+			t("[LOG] Response body: %v", *log.ResponseBody)
+		}
 	}
 
 	
 
 	query += strings.Join(placeholders, ", ")
+	// Logs replayed from the spill may already have been written once
+	query += " ON CONFLICT (id) DO NOTHING"
 
 	_, err = tx.ExecContext(ctx, query, values...)
 	if err != nil {
 		return fmt.Errorf("failed to insert logs: %w", err)
 	}
 
+	// Outbox records go in the same transaction, after the logs they reference
+	if err = insertGuardrailMetrics(ctx, tx, logs); err != nil {
+		return fmt.Errorf("failed to insert guardrail metrics: %w", err)
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -155,80 +176,214 @@ func (p *PostgreSQLStorage) SaveRequestLogsBatch(ctx context.Context, logs []*Re
 	return nil
 }
 
-// GetRequestLogs retrieves request logs based on filter criteria
-func (p *PostgreSQLStorage) GetRequestLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, error) {
+// insertGuardrailMetrics writes the guardrail metrics carried by a batch of logs
+func insertGuardrailMetrics(ctx context.Context, tx *sql.Tx, logs []*RequestLog) error {
+	var metrics []*GuardrailMetric
+	for _, log := range logs {
+		metrics = append(metrics, log.GuardrailMetrics...)
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(metrics))
+	values := make([]interface{}, 0, len(metrics)*16)
+	for i, metric := range metrics {
+		args := make([]string, 16)
+		for j := range args {
+			args[j] = fmt.Sprintf("$%d", i*16+j+1)
+		}
+		placeholders = append(placeholders, "("+strings.Join(args, ", ")+")")
+
+		var metadataJSON []byte
+		if metric.Metadata != nil {
+			metadataJSON, _ = json.Marshal(metric.Metadata)
+		}
+		values = append(values,
+			metric.ID,
+			metric.RequestID,
+			metric.GuardrailName,
+			metric.Layer,
+			metric.Priority,
+			metric.StartTime,
+			metric.EndTime,
+			metric.DurationMs,
+			metric.Passed,
+			metric.Score,
+			metric.Error,
+			metadataJSON,
+			metric.OriginalResponse,
+			metric.OverrideResponse,
+			metric.ResponseOverridden,
+			metric.CreatedAt,
+		)
+	}
+
 	query := `
-		SELECT id, timestamp, session_id, request_id, endpoint, method,
-			   status_code, latency_ms, provider, user_agent, remote_addr,
-			   request_headers, request_body, response_headers, response_body,
-			   error, metadata, created_at, updated_at
-		FROM request_logs
-		WHERE 1=1`
+		INSERT INTO guardrail_metrics (
+			id, request_id, guardrail_name, layer, priority,
+			start_time, end_time, duration_ms, passed, score,
+			error, metadata, original_response, override_response,
+			response_overridden, created_at
+		) VALUES ` + strings.Join(placeholders, ", ") + `
+		ON CONFLICT (id) DO NOTHING`
+	_, err := tx.ExecContext(ctx, query, values...)
+	return err
+}
 
+// sortableColumns are the request log columns results may be ordered by
+var sortableColumns = map[string]bool{
+	"timestamp": true, "latency_ms": true, "status_code": true,
+	"endpoint": true, "provider": true, "created_at": true,
+}
+
+// Expressions over the cost breakdown that cost tracking adds to log metadata
+const (
+	modelExpr  = "COALESCE(metadata->'cost'->>'model', metadata->'token_estimate'->>'model')"
+	tokensExpr = "(COALESCE((metadata->'cost'->>'prompt_tokens')::BIGINT, 0) + COALESCE((metadata->'cost'->>'completion_tokens')::BIGINT, 0))"
+	costExpr   = "(metadata->'cost'->>'total_cost')::NUMERIC"
+	// Client feedback on a log, from the table the feedback endpoint writes
+	feedbackQuery = "SELECT 1 FROM request_feedback f WHERE f.request_id = request_logs.request_id"
+	// Must match idx_request_logs_body_search in migrations/upgrades.sql
+	bodySearchExpr = "to_tsvector('simple', COALESCE(request_body, '') || ' ' || COALESCE(response_body, ''))"
+)
+
+// filterConditions renders a filter as " AND ..." conditions and their arguments
+func (p *PostgreSQLStorage) filterConditions(filter LogFilter) (string, []interface{}) {
+	var conditions strings.Builder
 	args := make([]interface{}, 0)
-	argCount := 0
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions.WriteString(" AND " + strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
 
-	// Apply filters
 	if filter.StartTime != nil {
-		argCount++
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
-		args = append(args, *filter.StartTime)
+		add("timestamp >= ?", *filter.StartTime)
 	}
-	
 	if filter.EndTime != nil {
-		argCount++
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
-		args = append(args, *filter.EndTime)
+		add("timestamp <= ?", *filter.EndTime)
 	}
-	
 	if filter.Endpoint != nil {
-		argCount++
-		query += fmt.Sprintf(" AND endpoint = $%d", argCount)
-		args = append(args, *filter.Endpoint)
+		add("endpoint = ?", *filter.Endpoint)
 	}
-	
 	if filter.Method != nil {
-		argCount++
-		query += fmt.Sprintf(" AND method = $%d", argCount)
-		args = append(args, *filter.Method)
+		add("method = ?", *filter.Method)
 	}
-	
 	if filter.StatusCode != nil {
-		argCount++
-		query += fmt.Sprintf(" AND status_code = $%d", argCount)
-		args = append(args, *filter.StatusCode)
+		add("status_code = ?", *filter.StatusCode)
 	}
-	
 	if filter.Provider != nil {
-		argCount++
-		query += fmt.Sprintf(" AND provider = $%d", argCount)
-		args = append(args, *filter.Provider)
+		add("provider = ?", *filter.Provider)
 	}
-	
 	if filter.SessionID != nil {
-		argCount++
-		query += fmt.Sprintf(" AND session_id = $%d", argCount)
-		args = append(args, *filter.SessionID)
+		add("session_id = ?", *filter.SessionID)
+	}
+	if filter.ConversationID != nil {
+		add("conversation_id = ?", *filter.ConversationID)
+	}
+	if filter.TenantID != nil {
+		add("tenant_id = ?", *filter.TenantID)
 	}
-	
 	if filter.HasError != nil && *filter.HasError {
-		query += " AND error IS NOT NULL"
+		conditions.WriteString(" AND error IS NOT NULL")
 	} else if filter.HasError != nil && !*filter.HasError {
-		query += " AND error IS NULL"
+		conditions.WriteString(" AND error IS NULL")
 	}
 
-	// Order by
+	if filter.Search != nil && strings.TrimSpace(*filter.Search) != "" {
+		if p.fullTextSearch {
+			add(bodySearchExpr+" @@ websearch_to_tsquery('simple', ?)", *filter.Search)
+		} else {
+			// Without the search index, match the phrase as a substring
+			pattern := "%" + likeEscaper.Replace(*filter.Search) + "%"
+			add("(request_body ILIKE ? OR response_body ILIKE ?)", pattern)
+		}
+	}
+	if filter.Model != nil {
+		add(modelExpr+" = ?", *filter.Model)
+	}
+	if filter.MinTokens != nil {
+		add(tokensExpr+" >= ?", *filter.MinTokens)
+	}
+	if filter.MaxTokens != nil {
+		add(tokensExpr+" <= ?", *filter.MaxTokens)
+	}
+	if filter.MinCost != nil {
+		add(costExpr+" >= ?", *filter.MinCost)
+	}
+	if filter.MaxCost != nil {
+		add(costExpr+" <= ?", *filter.MaxCost)
+	}
+	if filter.Rating != nil {
+		switch *filter.Rating {
+		case "up":
+			conditions.WriteString(" AND EXISTS (" + feedbackQuery + " AND f.rating > 0)")
+		case "down":
+			conditions.WriteString(" AND EXISTS (" + feedbackQuery + " AND f.rating < 0)")
+		case "none":
+			conditions.WriteString(" AND NOT EXISTS (" + feedbackQuery + " AND f.rating IS NOT NULL)")
+		}
+	}
+	if filter.MinScore != nil {
+		add("EXISTS ("+feedbackQuery+" AND f.score >= ?)", *filter.MinScore)
+	}
+	if filter.GuardrailStatus != nil {
+		switch *filter.GuardrailStatus {
+		case GuardrailPassed:
+			conditions.WriteString(" AND metadata->'guardrail_block' IS NULL AND metadata->'response_modified_by' IS NULL")
+		case GuardrailModified:
+			conditions.WriteString(" AND metadata->'response_modified_by' IS NOT NULL")
+		case GuardrailBlocked:
+			conditions.WriteString(" AND metadata->'guardrail_block' IS NOT NULL")
+		}
+	}
+	return conditions.String(), args
+}
+
+// likeEscaper escapes LIKE wildcards in search text
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetRequestLogs retrieves request logs based on filter criteria
+func (p *PostgreSQLStorage) GetRequestLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, error) {
+	query := `
+		SELECT id, timestamp, session_id, request_id, endpoint, method,
+			   status_code, latency_ms, provider, user_agent, remote_addr,
+			   request_headers, request_body, response_headers, response_body,
+			   error, metadata, created_at, updated_at, conversation_id, tenant_id
+		FROM request_logs
+		WHERE 1=1`
+
+	conditions, args := p.filterConditions(filter)
+	query += conditions
+	argCount := len(args)
+
+	// Order by; both are interpolated, so only known columns and directions are used
 	orderBy := "timestamp"
-	if filter.OrderBy != "" {
+	if sortableColumns[filter.OrderBy] {
 		orderBy = filter.OrderBy
 	}
 	
 	orderDir := "DESC"
-	if filter.OrderDir != "" {
-		orderDir = filter.OrderDir
+	if strings.EqualFold(filter.OrderDir, "ASC") {
+		orderDir = "ASC"
+	}
+
+	// Cursors page through timestamp order, with the id breaking ties
+	if filter.After != nil {
+		orderBy = "timestamp"
+		comparison := "<"
+		if orderDir == "ASC" {
+			comparison = ">"
+		}
+		query += fmt.Sprintf(" AND (timestamp, id) %s ($%d, $%d)", comparison, argCount+1, argCount+2)
+		args = append(args, filter.After.Timestamp, filter.After.ID)
+		argCount += 2
+	}
+	if orderBy == "timestamp" {
+		query += fmt.Sprintf(" ORDER BY timestamp %s, id %s", orderDir, orderDir)
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s", orderBy, orderDir)
 	}
-	
-	query += fmt.Sprintf(" ORDER BY %s %s", orderBy, orderDir)
 
 	// Limit and offset
 	if filter.Limit > 0 {
@@ -274,6 +429,8 @@ func (p *PostgreSQLStorage) GetRequestLogs(ctx context.Context, filter LogFilter
 			&metadataJSON,
 			&log.CreatedAt,
 			&log.UpdatedAt,
+			&log.ConversationID,
+			&log.TenantID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan log: %w", err)
@@ -307,7 +464,7 @@ func (p *PostgreSQLStorage) GetRequestLogByID(ctx context.Context, id string) (*
 		SELECT id, timestamp, session_id, request_id, endpoint, method,
 			   status_code, latency_ms, provider, user_agent, remote_addr,
 			   request_headers, request_body, response_headers, response_body,
-			   error, metadata, created_at, updated_at
+			   error, metadata, created_at, updated_at, conversation_id, tenant_id
 		FROM request_logs
 		WHERE id = $1`
 
@@ -334,6 +491,8 @@ func (p *PostgreSQLStorage) GetRequestLogByID(ctx context.Context, id string) (*
 		&metadataJSON,
 		&log.CreatedAt,
 		&log.UpdatedAt,
+		&log.ConversationID,
+		&log.TenantID,
 	)
 	
 	if err != nil {
@@ -358,30 +517,109 @@ func (p *PostgreSQLStorage) GetRequestLogByID(ctx context.Context, id string) (*
 }
 
 // GetLogStats retrieves aggregated statistics
+// Only the StartTime/EndTime fields of the filter are applied
 func (p *PostgreSQLStorage) GetLogStats(ctx context.Context, filter LogFilter) (*LogStats, error) {
-	// This is a simplified implementation - in production you'd want more sophisticated aggregations
 	stats := &LogStats{
+		TopEndpoints:     []EndpointStats{},
 		StatusCodeCounts: make(map[string]int64),
 		ProviderStats:    make(map[string]int64),
 	}
 
-	// Get total count
-	err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM request_logs").Scan(&stats.TotalRequests)
+	conditions, args := p.filterConditions(filter)
+	where := " WHERE 1=1" + conditions
+
+	// Get totals, error rate and time span in one pass
+	var errorCount int64
+	var spanHours float64
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			   COUNT(*) FILTER (WHERE status_code >= 400 OR error IS NOT NULL),
+			   COALESCE(EXTRACT(EPOCH FROM (MAX(timestamp) - MIN(timestamp))) / 3600, 0)
+		FROM request_logs`+where, args...,
+	).Scan(&stats.TotalRequests, &errorCount, &spanHours)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total count: %w", err)
 	}
+	if stats.TotalRequests > 0 {
+		stats.ErrorRate = float64(errorCount) / float64(stats.TotalRequests)
+		if spanHours < 1 {
+			spanHours = 1
+		}
+		stats.RequestsPerHour = int64(float64(stats.TotalRequests) / spanHours)
+	}
 
 	// Get average latency (for successful requests)
-	err = p.db.QueryRowContext(ctx, 
-		"SELECT COALESCE(AVG(latency_ms), 0) FROM request_logs WHERE latency_ms IS NOT NULL AND status_code < 400",
+	err = p.db.QueryRowContext(ctx,
+		"SELECT COALESCE(AVG(latency_ms), 0) FROM request_logs"+where+" AND latency_ms IS NOT NULL AND status_code < 400",
+		args...,
 	).Scan(&stats.AverageLatency)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get average latency: %w", err)
 	}
 
+	// Get top endpoints
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT endpoint, COUNT(*),
+			   COALESCE(AVG(latency_ms), 0),
+			   COUNT(*) FILTER (WHERE status_code >= 400 OR error IS NOT NULL)::FLOAT / COUNT(*)
+		FROM request_logs`+where+`
+		GROUP BY endpoint
+		ORDER BY COUNT(*) DESC
+		LIMIT 10`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoint stats: %w", err)
+	}
+	for rows.Next() {
+		var endpoint EndpointStats
+		if err := rows.Scan(&endpoint.Endpoint, &endpoint.RequestCount, &endpoint.AverageLatency, &endpoint.ErrorRate); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan endpoint stats: %w", err)
+		}
+		stats.TopEndpoints = append(stats.TopEndpoints, endpoint)
+	}
+	rows.Close()
+
+	// Get status code distribution
+	rows, err = p.db.QueryContext(ctx,
+		"SELECT COALESCE(status_code::TEXT, 'unknown'), COUNT(*) FROM request_logs"+where+" GROUP BY 1", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status code counts: %w", err)
+	}
+	for rows.Next() {
+		var code string
+		var count int64
+		if err := rows.Scan(&code, &count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan status code counts: %w", err)
+		}
+		stats.StatusCodeCounts[code] = count
+	}
+	rows.Close()
+
+	// Get per-provider request counts
+	rows, err = p.db.QueryContext(ctx,
+		"SELECT COALESCE(provider, 'unknown'), COUNT(*) FROM request_logs"+where+" GROUP BY 1", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider stats: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var provider string
+		var count int64
+		if err := rows.Scan(&provider, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan provider stats: %w", err)
+		}
+		stats.ProviderStats[provider] = count
+	}
+
 	return stats, nil
 }
 
+// Ping checks the database connection
+func (p *PostgreSQLStorage) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
 // Close closes the database connection
 func (p *PostgreSQLStorage) Close() error {
 	if p.db != nil {
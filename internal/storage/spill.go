@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spillSegmentSize is the size at which the spill starts a new segment file
+const spillSegmentSize = 16 << 20
+
+// ErrSpillFull is returned when writing would take the spill past its size limit
+var ErrSpillFull = errors.New("log spill is full")
+
+// spillRecord is one line of a segment file
+type spillRecord struct {
+	SpilledAt time.Time   `json:"spilled_at"`
+	Log       *RequestLog `json:"log"`
+}
+
+// Spill is an on-disk queue of request logs that couldn't be written when
+// they arrived. Logs are appended as JSON lines to numbered segment files and
+// replayed oldest first; the replay position is saved after every batch, so a
+// restart resumes where it left off. Replays are at-least-once.
+type Spill struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	segments  []int    // Segment numbers on disk, oldest first
+	current   *os.File // Last segment, open for appends
+	written   int64    // Size of the current segment
+	bytes     int64    // Unreplayed bytes across segments
+	records   int64    // Unreplayed records across segments
+	offset    int64    // Replay position in the oldest segment
+	oldest    time.Time
+	spilled   int64
+	replayed  int64
+	rejected  int64
+	lastLag   time.Duration
+	replaying sync.Mutex // Held for the length of a replay
+}
+
+// OpenSpill opens the spill in dir, creating it if needed, and picks up any
+// segments left by a previous run
+func OpenSpill(dir string, maxBytes int64) (*Spill, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	s := &Spill{dir: dir, maxBytes: maxBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spill directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".wal") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, ".wal")); err == nil {
+			s.segments = append(s.segments, n)
+		}
+	}
+	sort.Ints(s.segments)
+
+	if data, err := os.ReadFile(s.offsetPath()); err == nil {
+		s.offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	segments := s.segments
+	s.segments = s.segments[:0]
+	for _, n := range segments {
+		records, size, err := countRecords(s.segmentPath(n))
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			os.Remove(s.segmentPath(n)) // Opened by a previous run but never written
+			continue
+		}
+		s.segments = append(s.segments, n)
+		if len(s.segments) == 1 {
+			skipped, _, _ := countRecordsUpTo(s.segmentPath(n), s.offset)
+			records -= skipped
+			size -= s.offset
+		}
+		s.records += records
+		s.bytes += size
+	}
+
+	if s.records > 0 {
+		// Replay lag counts from the oldest log still waiting
+		if first, _, _, err := readRecords(s.segmentPath(s.segments[0]), s.offset, 1); err == nil && len(first) > 0 {
+			s.oldest = first[0].SpilledAt
+		}
+	}
+
+	next := 1
+	if len(s.segments) > 0 {
+		next = s.segments[len(s.segments)-1] + 1
+	}
+	if err := s.openSegment(next); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spill) segmentPath(n int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%012d.wal", n))
+}
+
+func (s *Spill) offsetPath() string {
+	return filepath.Join(s.dir, "replay.offset")
+}
+
+// openSegment starts a new segment for appends. Callers hold s.mu.
+func (s *Spill) openSegment(n int) error {
+	f, err := os.OpenFile(s.segmentPath(n), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spill segment: %w", err)
+	}
+	if s.current != nil {
+		s.current.Sync()
+		s.current.Close()
+	}
+	s.current, s.written = f, 0
+	s.segments = append(s.segments, n)
+	return nil
+}
+
+// Append writes logs to the end of the spill
+func (s *Spill) Append(logs []*RequestLog) error {
+	now := time.Now()
+	var buf []byte
+	for _, requestLog := range logs {
+		line, err := json.Marshal(spillRecord{SpilledAt: now, Log: requestLog})
+		if err != nil {
+			return fmt.Errorf("failed to encode spilled log: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return fmt.Errorf("log spill is closed")
+	}
+	if s.maxBytes > 0 && s.bytes+int64(len(buf)) > s.maxBytes {
+		s.rejected += int64(len(logs))
+		return ErrSpillFull
+	}
+	if s.written >= spillSegmentSize {
+		if err := s.openSegment(s.segments[len(s.segments)-1] + 1); err != nil {
+			return err
+		}
+	}
+	if _, err := s.current.Write(buf); err != nil {
+		return fmt.Errorf("failed to write spill segment: %w", err)
+	}
+	if s.records == 0 {
+		s.oldest = now
+	}
+	s.written += int64(len(buf))
+	s.bytes += int64(len(buf))
+	s.records += int64(len(logs))
+	s.spilled += int64(len(logs))
+	return nil
+}
+
+// Pending returns the number of logs waiting to be replayed
+func (s *Spill) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// Replay reads up to batchSize logs from the front of the spill and hands them
+// to save. They are removed from the spill only once save succeeds.
+func (s *Spill) Replay(batchSize int, save func([]*RequestLog) error) (int, error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	s.mu.Lock()
+	if s.records == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	// Segments are read only once they are no longer being appended to
+	if len(s.segments) == 1 {
+		if err := s.openSegment(s.segments[0] + 1); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+	}
+	segment, offset := s.segments[0], s.offset
+	s.mu.Unlock()
+
+	records, lines, next, err := readRecords(s.segmentPath(segment), offset, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) > 0 {
+		logs := make([]*RequestLog, len(records))
+		for i, record := range records {
+			logs[i] = record.Log
+		}
+		if err := save(logs); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes -= next - offset
+	s.records -= lines
+	s.replayed += int64(len(records))
+	if len(records) > 0 {
+		s.lastLag = time.Since(records[0].SpilledAt)
+	}
+
+	if int(lines) < batchSize {
+		// Reached the end of a closed segment
+		// Offset first, so a crash in between replays the segment rather than misapplying the offset
+		os.Remove(s.offsetPath())
+		os.Remove(s.segmentPath(segment))
+		s.segments, s.offset = s.segments[1:], 0
+	} else {
+		s.offset = next
+		if err := os.WriteFile(s.offsetPath(), []byte(strconv.FormatInt(next, 10)), 0o644); err != nil {
+			return len(records), fmt.Errorf("failed to save spill offset: %w", err)
+		}
+	}
+	if s.records <= 0 {
+		s.records, s.bytes, s.oldest = 0, 0, time.Time{}
+	} else if len(records) > 0 {
+		s.oldest = records[len(records)-1].SpilledAt // Close enough to the next one waiting
+	}
+	return len(records), nil
+}
+
+// readRecords decodes up to limit lines starting at offset, returning the
+// records among them and the offset just past the last one
+func readRecords(path string, offset int64, limit int) ([]spillRecord, int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, offset, fmt.Errorf("failed to open spill segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, offset, err
+	}
+
+	reader := bufio.NewReader(f)
+	records := make([]spillRecord, 0, limit)
+	var lines int64
+	for lines < int64(limit) {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break // A partial last line is from an interrupted write, skip it
+		}
+		offset += int64(len(line))
+		lines++
+		var record spillRecord
+		if json.Unmarshal(line, &record) != nil || record.Log == nil {
+			continue // Corrupt records are dropped rather than blocking the spill
+		}
+		records = append(records, record)
+	}
+	return records, lines, offset, nil
+}
+
+// countRecords returns the number of lines and the size of a segment
+func countRecords(path string) (int64, int64, error) {
+	return countRecordsUpTo(path, -1)
+}
+
+// countRecordsUpTo counts lines in the first limit bytes of a segment, or all
+// of it when limit is negative
+func countRecordsUpTo(path string, limit int64) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open spill segment: %w", err)
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if limit >= 0 {
+		reader = io.LimitReader(f, limit)
+	}
+	var lines, size int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		size += int64(n)
+		for _, c := range buf[:n] {
+			if c == '\n' {
+				lines++
+			}
+		}
+		if err != nil {
+			return lines, size, nil
+		}
+	}
+}
+
+// Metrics returns the spill's size and replay progress
+func (s *Spill) Metrics() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lag time.Duration
+	if !s.oldest.IsZero() {
+		lag = time.Since(s.oldest)
+	}
+	return map[string]interface{}{
+		"dir":                s.dir,
+		"pending_logs":       s.records,
+		"pending_bytes":      s.bytes,
+		"max_bytes":          s.maxBytes,
+		"segments":           len(s.segments),
+		"spilled_logs":       s.spilled,
+		"replayed_logs":      s.replayed,
+		"rejected_logs":      s.rejected,
+		"replay_lag_ms":      lag.Milliseconds(),
+		"last_replay_lag_ms": s.lastLag.Milliseconds(),
+	}
+}
+
+// Close flushes the current segment to disk
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	s.current.Sync()
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
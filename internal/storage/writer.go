@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -17,15 +18,22 @@ type StorageBackend interface {
 	Close() error
 }
 
+// Pinger is implemented by backends that can check their connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 // AsyncLogWriter handles asynchronous writing of request logs
 type AsyncLogWriter struct {
-	backend       StorageBackend
-	logChannel    chan *RequestLog
-	batchSize     int
-	flushInterval time.Duration
-	workers       int
-	enabled       bool
-	skipOnError   bool
+	backend        StorageBackend
+	logChannel     chan *RequestLog
+	batchSize      int
+	flushInterval  time.Duration
+	workers        int
+	enabled        bool
+	skipOnError    bool
+	spill          *Spill
+	replayInterval time.Duration
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -35,6 +43,7 @@ type AsyncLogWriter struct {
 	mutex         sync.RWMutex
 	totalLogs     int64
 	droppedLogs   int64
+	spilledLogs   int64
 	failedBatches int64
 	lastFlush     time.Time
 }
@@ -48,6 +57,10 @@ type AsyncLogWriterConfig struct {
 	Workers       int
 	Enabled       bool
 	SkipOnError   bool
+	// Spill, when set, takes logs the channel or backend can't and replays
+	// them every ReplayInterval once the backend keeps up again
+	Spill          *Spill
+	ReplayInterval time.Duration
 }
 
 // NewAsyncLogWriter creates a new async log writer
@@ -64,20 +77,25 @@ func NewAsyncLogWriter(config AsyncLogWriterConfig) *AsyncLogWriter {
 	if config.Workers <= 0 {
 		config.Workers = 3
 	}
+	if config.ReplayInterval <= 0 {
+		config.ReplayInterval = 5 * time.Second
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	writer := &AsyncLogWriter{
-		backend:       config.Backend,
-		logChannel:    make(chan *RequestLog, config.BufferSize),
-		batchSize:     config.BatchSize,
-		flushInterval: config.FlushInterval,
-		workers:       config.Workers,
-		enabled:       config.Enabled,
-		skipOnError:   config.SkipOnError,
-		ctx:           ctx,
-		cancel:        cancel,
-		lastFlush:     time.Now(),
+		backend:        config.Backend,
+		logChannel:     make(chan *RequestLog, config.BufferSize),
+		batchSize:      config.BatchSize,
+		flushInterval:  config.FlushInterval,
+		workers:        config.Workers,
+		enabled:        config.Enabled,
+		skipOnError:    config.SkipOnError,
+		spill:          config.Spill,
+		replayInterval: config.ReplayInterval,
+		ctx:            ctx,
+		cancel:         cancel,
+		lastFlush:      time.Now(),
 	}
 
 	if writer.enabled && writer.backend != nil {
@@ -99,7 +117,10 @@ func (w *AsyncLogWriter) WriteLog(requestLog *RequestLog) {
 		w.totalLogs++
 		w.mutex.Unlock()
 	default:
-		// Channel is full, drop the log to avoid blocking
+		// Channel is full: spill to disk if configured, otherwise drop the log to avoid blocking
+		if w.spillLogs([]*RequestLog{requestLog}) {
+			return
+		}
 		w.mutex.Lock()
 		w.droppedLogs++
 		w.mutex.Unlock()
@@ -116,6 +137,59 @@ func (w *AsyncLogWriter) start() {
 		w.wg.Add(1)
 		go w.worker()
 	}
+	if w.spill != nil {
+		w.wg.Add(1)
+		go w.replayer()
+	}
+}
+
+// spillLogs writes logs to the spill, reporting whether they were kept
+func (w *AsyncLogWriter) spillLogs(logs []*RequestLog) bool {
+	if w.spill == nil {
+		return false
+	}
+	if err := w.spill.Append(logs); err != nil {
+		if !w.skipOnError || !errors.Is(err, ErrSpillFull) {
+			log.Printf("[WARNING] Failed to spill %d log entries: %v", len(logs), err)
+		}
+		return false
+	}
+	w.mutex.Lock()
+	w.spilledLogs += int64(len(logs))
+	w.mutex.Unlock()
+	return true
+}
+
+// replayer moves spilled logs back to the backend. It holds off while the
+// channel is busy so live traffic keeps priority, and stops at the first
+// failure until the next interval.
+func (w *AsyncLogWriter) replayer() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.replayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for w.ctx.Err() == nil && w.spill.Pending() > 0 && w.QueueUtilization() < 0.5 {
+			_, err := w.spill.Replay(w.batchSize*10, func(logs []*RequestLog) error {
+				ctx, cancel := context.WithTimeout(w.ctx, 10*time.Second)
+				defer cancel()
+				return w.backend.SaveRequestLogsBatch(ctx, logs)
+			})
+			if err != nil {
+				if !w.skipOnError {
+					log.Printf("[WARNING] Replaying spilled logs failed, retrying in %v: %v", w.replayInterval, err)
+				}
+				break
+			}
+		}
+	}
 }
 
 // worker processes logs from the channel in batches
@@ -166,6 +240,10 @@ func (w *AsyncLogWriter) flushBatch(batch []*RequestLog) {
 	defer cancel()
 
 	if err := w.backend.SaveRequestLogsBatch(ctx, batch); err != nil {
+		// Keep the batch for replay once the backend recovers
+		if w.spillLogs(batch) {
+			return
+		}
 		w.mutex.Lock()
 		w.failedBatches++
 		log.Printf("[ERROR] Writing logs failed %v", err)
@@ -189,7 +267,7 @@ func (w *AsyncLogWriter) GetMetrics() map[string]interface{} {
 	w.mutex.RLock()
 	defer w.mutex.RUnlock()
 
-	return map[string]interface{}{
+	metrics := map[string]interface{}{
 		"enabled":           w.enabled,
 		"total_logs":        w.totalLogs,
 		"dropped_logs":      w.droppedLogs,
@@ -201,6 +279,19 @@ func (w *AsyncLogWriter) GetMetrics() map[string]interface{} {
 		"batch_size":        w.batchSize,
 		"flush_interval_ms": w.flushInterval.Milliseconds(),
 	}
+	if w.spill != nil {
+		metrics["spilled_logs"] = w.spilledLogs
+		metrics["spill"] = w.spill.Metrics()
+	}
+	return metrics
+}
+
+// Ping checks the backend's connection, when the backend can be checked
+func (w *AsyncLogWriter) Ping(ctx context.Context) error {
+	if pinger, ok := w.backend.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
 }
 
 // GetChannelDepth returns current channel depth (for monitoring)
@@ -208,6 +299,14 @@ func (w *AsyncLogWriter) GetChannelDepth() int {
 	return len(w.logChannel)
 }
 
+// QueueUtilization returns the fraction of the log channel currently in use
+func (w *AsyncLogWriter) QueueUtilization() float64 {
+	if cap(w.logChannel) == 0 {
+		return 0
+	}
+	return float64(len(w.logChannel)) / float64(cap(w.logChannel))
+}
+
 // GetDroppedCount returns the number of dropped logs
 func (w *AsyncLogWriter) GetDroppedCount() int64 {
 	w.mutex.RLock()
@@ -241,6 +340,12 @@ func (w *AsyncLogWriter) Close() error {
 		log.Println("Timeout waiting for log workers to finish")
 	}
 
+	if w.spill != nil {
+		if err := w.spill.Close(); err != nil {
+			log.Printf("Error closing log spill: %v", err)
+		}
+	}
+
 	// Close storage backend
 	if err := w.backend.Close(); err != nil {
 		log.Printf("Error closing storage backend: %v", err)
@@ -260,6 +365,10 @@ func (w *AsyncLogWriter) Flush() {
 		return
 	}
 
-	// Send signal to flush by waiting briefly
+	// Let workers pick up queued logs, then wait for their periodic flush
+	deadline := time.Now().Add(30 * time.Second)
+	for len(w.logChannel) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
 	time.Sleep(w.flushInterval + 100*time.Millisecond)
 }
@@ -0,0 +1,218 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/ratelimit"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+)
+
+// tenantContextKey is the context key under which the resolved tenant is stored
+const tenantContextKey = "tenant"
+
+// Errors returned when a request cannot be assigned to a tenant
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrKeyRequired   = errors.New("tenant requires one of its API keys")
+	ErrNoTenant      = errors.New("no tenant for request")
+)
+
+// Tenant is one team served by the gateway
+type Tenant struct {
+	ID          string
+	providers   map[string]bool
+	credentials map[string]string
+	limiter     *ratelimit.Bucket
+}
+
+// AllowsProvider reports whether the tenant may send requests to a provider
+func (t *Tenant) AllowsProvider(name string) bool {
+	return len(t.providers) == 0 || t.providers[name]
+}
+
+// Credential returns the upstream API key the tenant uses for a provider, if
+// it has its own
+func (t *Tenant) Credential(provider string) string {
+	return t.credentials[provider]
+}
+
+// Resolver assigns requests to tenants
+type Resolver struct {
+	header   string
+	required bool
+	byID     map[string]*Tenant
+	byKey    map[string]*Tenant // API key fingerprint -> tenant
+	keyed    map[string]bool    // Tenants that can only be reached with a key
+}
+
+// New creates a resolver from configuration
+func New(cfg config.TenantsConfig) (*Resolver, error) {
+	r := &Resolver{
+		header:   cfg.Header,
+		required: cfg.Required,
+		byID:     make(map[string]*Tenant),
+		byKey:    make(map[string]*Tenant),
+		keyed:    make(map[string]bool),
+	}
+	if r.header == "" {
+		r.header = "X-Tenant-ID"
+	}
+
+	for _, tc := range cfg.Tenants {
+		if tc.ID == "" {
+			return nil, fmt.Errorf("tenant id is required")
+		}
+		if _, dup := r.byID[tc.ID]; dup {
+			return nil, fmt.Errorf("duplicate tenant %q", tc.ID)
+		}
+
+		t := &Tenant{
+			ID:          tc.ID,
+			providers:   make(map[string]bool, len(tc.Providers)),
+			credentials: tc.Credentials,
+		}
+		for _, name := range tc.Providers {
+			t.providers[name] = true
+		}
+		if tc.RateLimit != nil {
+			if tc.RateLimit.RequestsPerMinute <= 0 {
+				return nil, fmt.Errorf("tenant %s: requests_per_minute must be positive", tc.ID)
+			}
+			t.limiter = ratelimit.NewBucket(tc.RateLimit.RequestsPerMinute, tc.RateLimit.Burst)
+		}
+
+		for _, key := range tc.Keys {
+			// Keys may be listed by fingerprint so the key itself stays out of config
+			id := key
+			if !strings.HasPrefix(key, "key_") {
+				id = storage.APIKeyID(key)
+			}
+			if other, dup := r.byKey[id]; dup {
+				return nil, fmt.Errorf("key %s belongs to tenants %s and %s", id, other.ID, tc.ID)
+			}
+			r.byKey[id] = t
+		}
+		r.byID[tc.ID] = t
+		r.keyed[tc.ID] = len(tc.Keys) > 0
+	}
+	return r, nil
+}
+
+// Get returns the tenant with the given ID, or nil
+func (r *Resolver) Get(id string) *Tenant {
+	return r.byID[id]
+}
+
+// Resolve returns the tenant a request belongs to. The API key is checked
+// first; the header is only trusted for tenants that have no keys, since
+// anyone can set it.
+func (r *Resolver) Resolve(req *http.Request) (*Tenant, error) {
+	key := req.Header.Get("x-api-key")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if t, ok := r.byKey[storage.APIKeyID(key)]; ok && key != "" {
+		return t, nil
+	}
+
+	if id := req.Header.Get(r.header); id != "" {
+		t, ok := r.byID[id]
+		if !ok {
+			return nil, ErrUnknownTenant
+		}
+		if r.keyed[id] {
+			return nil, ErrKeyRequired
+		}
+		return t, nil
+	}
+
+	if r.required {
+		return nil, ErrNoTenant
+	}
+	return nil, nil
+}
+
+// Middleware resolves the tenant of each request and applies its rate limit.
+// Requests without a tenant pass through unless tenants are required.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t, err := r.Resolve(req)
+		if err != nil {
+			log.Printf("[TENANT] Rejected %s %s: %v", req.Method, req.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		// The header only selects a tenant, it isn't passed upstream
+		req.Header.Del(r.header)
+		if t == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		middleware.AddLogMetadata(req.Context(), "tenant", t.ID)
+		if t.limiter != nil && !t.limiter.Allow() {
+			middleware.AddLogMetadata(req.Context(), "tenant_rate_limited", true)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Tenant %s is over its rate limit", t.ID), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithTenant(req.Context(), t)))
+	})
+}
+
+// ShareLimits keeps tenants' rate limits in backend, so they hold across
+// gateway instances
+func (r *Resolver) ShareLimits(backend ratelimit.Backend) {
+	for id, t := range r.byID {
+		if t.limiter != nil {
+			t.limiter.Share(backend, "tenant:"+id)
+		}
+	}
+}
+
+// Status returns the configured tenants for status endpoints
+func (r *Resolver) Status() map[string]interface{} {
+	tenants := make(map[string]interface{}, len(r.byID))
+	for id, t := range r.byID {
+		providers := make([]string, 0, len(t.providers))
+		for name := range t.providers {
+			providers = append(providers, name)
+		}
+		credentials := make([]string, 0, len(t.credentials))
+		for name := range t.credentials {
+			credentials = append(credentials, name)
+		}
+		status := map[string]interface{}{
+			"keyed":       r.keyed[id],
+			"providers":   providers,
+			"credentials": credentials, // Provider names only
+		}
+		if t.limiter != nil {
+			status["rate_limit"] = t.limiter.Status()
+		}
+		tenants[id] = status
+	}
+	return map[string]interface{}{
+		"header":   r.header,
+		"required": r.required,
+		"tenants":  tenants,
+	}
+}
+
+// WithTenant attaches the resolved tenant to a request context
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey, t)
+}
+
+// FromContext returns the request's tenant, or nil when it has none
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantContextKey).(*Tenant)
+	return t
+}
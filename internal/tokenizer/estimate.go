@@ -0,0 +1,107 @@
+package tokenizer
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// estimateContextKey is the context key under which a request's estimate is stored
+const estimateContextKey = "token_estimate"
+
+// Estimate is a request's prompt token count, made before it is proxied so
+// budgets and rate limits can act on it
+type Estimate struct {
+	Model        string `json:"model,omitempty"`
+	Encoding     string `json:"encoding"`
+	PromptTokens int    `json:"prompt_tokens"`
+}
+
+// WithEstimate attaches a prompt token estimate to a request context
+func WithEstimate(ctx context.Context, estimate Estimate) context.Context {
+	return context.WithValue(ctx, estimateContextKey, estimate)
+}
+
+// EstimateFromContext returns the request's prompt token estimate, if one was made
+func EstimateFromContext(ctx context.Context) (Estimate, bool) {
+	estimate, ok := ctx.Value(estimateContextKey).(Estimate)
+	return estimate, ok
+}
+
+// DriftTracker compares estimates with the prompt tokens providers report,
+// per model, to show how far estimates can be trusted
+type DriftTracker struct {
+	mu     sync.Mutex
+	models map[string]*modelDrift
+}
+
+// modelDrift accumulates estimate accuracy for one model
+type modelDrift struct {
+	requests       int64
+	estimated      int64
+	actual         int64
+	absErrorPct    float64 // Sum of per-request absolute errors, for the mean
+	maxAbsErrorPct float64
+	lastDriftPct   float64
+}
+
+// NewDriftTracker creates an empty drift tracker
+func NewDriftTracker() *DriftTracker {
+	return &DriftTracker{models: make(map[string]*modelDrift)}
+}
+
+// Record compares an estimate with the provider-reported prompt tokens and
+// returns the drift as a percentage of the actual count; positive means the
+// estimate was too low
+func (d *DriftTracker) Record(estimate Estimate, actual int) float64 {
+	if actual <= 0 {
+		return 0
+	}
+	driftPct := float64(actual-estimate.PromptTokens) / float64(actual) * 100
+
+	model := estimate.Model
+	if model == "" {
+		model = "unknown"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m, ok := d.models[model]
+	if !ok {
+		m = &modelDrift{}
+		d.models[model] = m
+	}
+	m.requests++
+	m.estimated += int64(estimate.PromptTokens)
+	m.actual += int64(actual)
+	m.absErrorPct += math.Abs(driftPct)
+	m.maxAbsErrorPct = math.Max(m.maxAbsErrorPct, math.Abs(driftPct))
+	m.lastDriftPct = driftPct
+	return driftPct
+}
+
+// Status returns per-model estimation drift for status endpoints
+func (d *DriftTracker) Status() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := make(map[string]interface{}, len(d.models))
+	for model, m := range d.models {
+		status[model] = map[string]interface{}{
+			"encoding":                EncodingName(model),
+			"requests":                m.requests,
+			"estimated_prompt_tokens": m.estimated,
+			"actual_prompt_tokens":    m.actual,
+			"drift_pct":               round(float64(m.actual-m.estimated) / float64(m.actual) * 100),
+			"mean_abs_error_pct":      round(m.absErrorPct / float64(m.requests)),
+			"max_abs_error_pct":       round(m.maxAbsErrorPct),
+			"last_drift_pct":          round(m.lastDriftPct),
+		}
+	}
+	return status
+}
+
+// round keeps two decimal places of a percentage
+func round(pct float64) float64 {
+	return math.Round(pct*100) / 100
+}
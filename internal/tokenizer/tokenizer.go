@@ -0,0 +1,135 @@
+package tokenizer
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// DefaultEncoding is used for models tiktoken does not know, including non-OpenAI models
+const DefaultEncoding = "cl100k_base"
+
+// Per-message overhead used by OpenAI chat models when formatting a conversation
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+var (
+	// Encodings are large; load each one once and share it
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+	encodingsMu sync.Mutex
+	loaderOnce  sync.Once
+)
+
+// Message is a role-tagged chat message for CountMessages
+type Message struct {
+	Role    string
+	Content string
+}
+
+// EncodingName returns the tiktoken encoding used for a model
+func EncodingName(model string) string {
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding
+	}
+
+	// Longest matching prefix wins, e.g. "gpt-4o-" over "gpt-4-"
+	var best, encoding string
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, encoding = prefix, name
+		}
+	}
+	if encoding != "" {
+		return encoding
+	}
+	return DefaultEncoding
+}
+
+// Count returns the number of tokens text encodes to for the given model
+func Count(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	enc := encodingFor(model)
+	if enc == nil {
+		return estimate(text)
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// CountMessages returns the prompt tokens a chat conversation uses, including
+// the per-message and reply-priming overhead OpenAI chat models add
+func CountMessages(model string, messages []Message) int {
+	total := tokensPerReply
+	for _, m := range messages {
+		total += tokensPerMessage + Count(model, m.Role) + Count(model, m.Content)
+	}
+	return total
+}
+
+// Truncate returns the longest prefix of text that fits in maxTokens
+func Truncate(model, text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	enc := encodingFor(model)
+	if enc == nil {
+		// Fall back to the same characters-per-token ratio estimate uses
+		if limit := maxTokens * 4; len(text) > limit {
+			// Back off to a rune boundary rather than cut a character in half
+			for limit > 0 && !utf8.RuneStart(text[limit]) {
+				limit--
+			}
+			return text[:limit]
+		}
+		return text
+	}
+	tokens := enc.EncodeOrdinary(text)
+	if len(tokens) <= maxTokens {
+		return text
+	}
+	// A character split across tokens may be cut partway; drop its bytes
+	truncated := enc.Decode(tokens[:maxTokens])
+	for {
+		r, size := utf8.DecodeLastRuneInString(truncated)
+		if r != utf8.RuneError || size != 1 {
+			return truncated
+		}
+		truncated = truncated[:len(truncated)-1]
+	}
+}
+
+// encodingFor returns the cached encoding for a model, or nil if it cannot be loaded
+func encodingFor(model string) *tiktoken.Tiktoken {
+	// Encodings are embedded in the binary so counting never hits the network
+	loaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+	})
+
+	name := EncodingName(model)
+
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if enc, ok := encodings[name]; ok {
+		return enc
+	}
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		log.Printf("Failed to load tokenizer encoding %s, estimating token counts: %v", name, err)
+		enc = nil
+	}
+	encodings[name] = enc
+	return enc
+}
+
+// estimate approximates token count at roughly four characters per token
+func estimate(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,209 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/slo"
+)
+
+// Traffic split strategies
+const (
+	StrategyWeighted = "weighted"
+	StrategyAdaptive = "adaptive"
+)
+
+const (
+	// adaptiveInterval is how often an adaptive split recomputes its shares
+	adaptiveInterval = time.Second
+	// adaptiveSmoothing is how far shares move toward their target on each
+	// recompute, so traffic shifts over seconds rather than flapping
+	adaptiveSmoothing = 0.2
+	// shareScale converts shares to the integer weights variants are picked by
+	shareScale = 10000
+)
+
+// adaptiveSplit holds an adaptive split's share bounds and current shares.
+// Shares are kept per provider, since a split may match several.
+type adaptiveSplit struct {
+	min []float64
+	max []float64
+
+	mu     sync.Mutex
+	shares map[string]*adaptiveShares
+}
+
+// adaptiveShares are the traffic shares on one provider
+type adaptiveShares struct {
+	shares  []float64
+	updated time.Time
+}
+
+// newAdaptiveSplit validates a split's share bounds
+func newAdaptiveSplit(name string, variants []config.TrafficVariantConfig) (*adaptiveSplit, error) {
+	a := &adaptiveSplit{
+		min:    make([]float64, len(variants)),
+		max:    make([]float64, len(variants)),
+		shares: make(map[string]*adaptiveShares),
+	}
+	var minTotal, maxTotal float64
+	for i, variant := range variants {
+		a.min[i], a.max[i] = variant.MinShare, variant.MaxShare
+		if a.max[i] == 0 {
+			a.max[i] = 1
+		}
+		if a.min[i] < 0 || a.max[i] > 1 || a.min[i] > a.max[i] {
+			return nil, fmt.Errorf("split %s: variant %s needs 0 <= min_share <= max_share <= 1", name, variant.Name)
+		}
+		minTotal += a.min[i]
+		maxTotal += a.max[i]
+	}
+	if minTotal > 1 {
+		return nil, fmt.Errorf("split %s: min_share values add up to more than 1", name)
+	}
+	if maxTotal < 1 {
+		return nil, fmt.Errorf("split %s: max_share values add up to less than 1", name)
+	}
+	return a, nil
+}
+
+// weighted returns the variants reweighted by their current shares on a
+// provider, recomputing the shares when they are due
+func (a *adaptiveSplit) weighted(variants []config.TrafficVariantConfig, provider, requested string, tracker *slo.Tracker) []config.TrafficVariantConfig {
+	now := time.Now()
+
+	a.mu.Lock()
+	state, ok := a.shares[provider]
+	if !ok {
+		state = &adaptiveShares{shares: a.initial(variants)}
+		a.shares[provider] = state
+	}
+	if now.Sub(state.updated) >= adaptiveInterval {
+		target := a.target(variants, provider, requested, tracker)
+		for i := range state.shares {
+			state.shares[i] += adaptiveSmoothing * (target[i] - state.shares[i])
+		}
+		state.updated = now
+	}
+	shares := append([]float64(nil), state.shares...)
+	a.mu.Unlock()
+
+	reweighted := make([]config.TrafficVariantConfig, len(variants))
+	for i, variant := range variants {
+		variant.Weight = int(math.Round(shares[i] * shareScale))
+		reweighted[i] = variant
+	}
+	return reweighted
+}
+
+// initial returns the configured weights as shares, within the bounds
+func (a *adaptiveSplit) initial(variants []config.TrafficVariantConfig) []float64 {
+	weights := make([]float64, len(variants))
+	for i, variant := range variants {
+		weights[i] = float64(variant.Weight)
+	}
+	return bound(weights, a.min, a.max)
+}
+
+// target computes the shares the split is moving toward. Each variant scores
+// its success rate divided by its p95 latency, and gets a share in proportion
+// to its score. Variants without enough recent requests to judge score the
+// average of the others, so they keep receiving traffic and get measured; if
+// none can be judged the configured weights are used.
+func (a *adaptiveSplit) target(variants []config.TrafficVariantConfig, provider, requested string, tracker *slo.Tracker) []float64 {
+	scores := make([]float64, len(variants))
+	known := make([]bool, len(variants))
+	var total float64
+	var count int
+	for i, variant := range variants {
+		model := variant.Model
+		if model == "" {
+			model = requested
+		}
+		stats, ok := tracker.Stats(provider, model)
+		if !ok {
+			continue
+		}
+		scores[i] = (1 - stats.ErrorRate) / math.Max(stats.P95Ms, 1)
+		known[i] = true
+		total += scores[i]
+		count++
+	}
+	if count == 0 {
+		return a.initial(variants)
+	}
+	for i := range scores {
+		if !known[i] {
+			scores[i] = total / float64(count)
+		}
+	}
+	return bound(scores, a.min, a.max)
+}
+
+// bound normalizes scores into shares that sum to 1 and lie within each
+// variant's bounds. Variants pushed past a bound are pinned to it and the
+// remaining share is divided among the rest by score.
+func bound(scores, min, max []float64) []float64 {
+	shares := make([]float64, len(scores))
+	pinned := make([]bool, len(scores))
+	for range scores {
+		free, freeScore := 1.0, 0.0
+		unpinned := 0
+		for i, score := range scores {
+			if pinned[i] {
+				free -= shares[i]
+			} else {
+				freeScore += score
+				unpinned++
+			}
+		}
+		if unpinned == 0 {
+			break
+		}
+
+		for i, score := range scores {
+			if pinned[i] {
+				continue
+			}
+			if freeScore > 0 {
+				shares[i] = free * score / freeScore
+			} else {
+				shares[i] = free / float64(unpinned)
+			}
+		}
+
+		changed := false
+		for i := range scores {
+			if pinned[i] {
+				continue
+			}
+			if shares[i] < min[i] {
+				shares[i], pinned[i], changed = min[i], true, true
+			} else if shares[i] > max[i] {
+				shares[i], pinned[i], changed = max[i], true, true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return shares
+}
+
+// status returns the current shares by provider and variant
+func (a *adaptiveSplit) status(variants []config.TrafficVariantConfig) map[string]map[string]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := make(map[string]map[string]float64, len(a.shares))
+	for provider, state := range a.shares {
+		shares := make(map[string]float64, len(variants))
+		for i, variant := range variants {
+			shares[variant.Name] = math.Round(state.shares[i]*1000) / 1000
+		}
+		status[provider] = shares
+	}
+	return status
+}
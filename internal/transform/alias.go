@@ -0,0 +1,86 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ModelAliases rewrites client-facing model names to vendor models
+type ModelAliases struct {
+	aliases map[string]string
+}
+
+// NewModelAliases validates an alias map
+func NewModelAliases(aliases map[string]string) (*ModelAliases, error) {
+	for alias, model := range aliases {
+		if alias == "" || model == "" {
+			return nil, fmt.Errorf("model alias %q -> %q: alias and model must be set", alias, model)
+		}
+		if _, chained := aliases[model]; chained && model != alias {
+			return nil, fmt.Errorf("model alias %q points at another alias %q", alias, model)
+		}
+	}
+	return &ModelAliases{aliases: aliases}, nil
+}
+
+// Resolve returns the model an alias stands for
+func (a *ModelAliases) Resolve(model string) (string, bool) {
+	resolved, ok := a.aliases[model]
+	return resolved, ok && resolved != model
+}
+
+// Apply rewrites the model field of a JSON request body when it names an alias,
+// returning the body unchanged otherwise
+func (a *ModelAliases) Apply(body string) (string, string, bool) {
+	payload, ok := decodeObject(body)
+	if !ok {
+		return body, "", false
+	}
+
+	requested, _ := payload["model"].(string)
+	resolved, ok := a.Resolve(requested)
+	if !ok {
+		return body, "", false
+	}
+	payload["model"] = resolved
+
+	rewritten, ok := encodeObject(payload)
+	if !ok {
+		return body, "", false
+	}
+	return rewritten, resolved, true
+}
+
+// SetModel rewrites the model field of a JSON request body
+func SetModel(body, model string) (string, bool) {
+	payload, ok := decodeObject(body)
+	if !ok {
+		return body, false
+	}
+	payload["model"] = model
+	return encodeObject(payload)
+}
+
+// decodeObject parses a JSON object body, keeping numbers exactly as the client sent them
+func decodeObject(body string) (map[string]interface{}, bool) {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil || payload == nil {
+		return nil, false
+	}
+	return payload, true
+}
+
+// encodeObject serializes a rewritten body without escaping HTML characters
+func encodeObject(payload map[string]interface{}) (string, bool) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
@@ -0,0 +1,225 @@
+package transform
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// TruncateDropOldest drops the oldest half of a conversation's messages on each retry
+const TruncateDropOldest = "drop_oldest"
+
+// Context fallback actions
+const (
+	ContextActionFallback = "fallback"
+	ContextActionTruncate = "truncate"
+)
+
+// defaultContextAttempts is how many times a request is retried when max_attempts is unset
+const defaultContextAttempts = 3
+
+// contextOverflowMarkers are phrases provider errors use for prompts longer
+// than the model's context window, matched case-insensitively
+var contextOverflowMarkers = []string{
+	"context_length_exceeded",              // OpenAI error code
+	"maximum context length",               // OpenAI message
+	"prompt is too long",                   // Anthropic
+	"input is too long",                    // Anthropic on Bedrock
+	"exceeds the context window",           // OpenAI Responses API
+	"exceeds the maximum number of tokens", // Gemini
+	"reduce the length of the messages",    // Azure OpenAI
+}
+
+// IsContextOverflow reports whether an upstream error response rejects the
+// request for exceeding the model's context window
+func IsContextOverflow(status int, body []byte) bool {
+	if status != http.StatusBadRequest && status != http.StatusRequestEntityTooLarge && status != http.StatusUnprocessableEntity {
+		return false
+	}
+	lower := strings.ToLower(string(body))
+	for _, marker := range contextOverflowMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextStep records one retry of a request over its model's context window
+type ContextStep struct {
+	Action  string `json:"action"`            // fallback or truncate
+	From    string `json:"from,omitempty"`    // The model that overflowed, for fallbacks
+	Model   string `json:"model"`             // The model retried on
+	Dropped int    `json:"dropped,omitempty"` // Messages dropped, for truncation
+}
+
+// ContextFallback rewrites requests that overflowed their model's context
+// window so they can be retried
+type ContextFallback struct {
+	models   map[string]string
+	truncate string
+	attempts int
+}
+
+// NewContextFallback validates a context fallback config
+func NewContextFallback(cfg config.ContextFallbackConfig) (*ContextFallback, error) {
+	for model, fallback := range cfg.Models {
+		if model == "" || fallback == "" {
+			return nil, fmt.Errorf("context fallback %q -> %q: model and fallback must be set", model, fallback)
+		}
+		if model == fallback {
+			return nil, fmt.Errorf("context fallback for %q points at itself", model)
+		}
+	}
+	switch cfg.Truncate {
+	case "", TruncateDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown context fallback truncate policy: %s", cfg.Truncate)
+	}
+	if len(cfg.Models) == 0 && cfg.Truncate == "" {
+		return nil, fmt.Errorf("context fallback needs models or a truncate policy")
+	}
+	if cfg.MaxAttempts < 0 {
+		return nil, fmt.Errorf("context fallback max_attempts must not be negative")
+	}
+
+	attempts := cfg.MaxAttempts
+	if attempts == 0 {
+		attempts = defaultContextAttempts
+	}
+	return &ContextFallback{models: cfg.Models, truncate: cfg.Truncate, attempts: attempts}, nil
+}
+
+// MaxAttempts returns how many times a request may be retried
+func (c *ContextFallback) MaxAttempts() int {
+	return c.attempts
+}
+
+// Next rewrites a request that overflowed its model's context window for the
+// next retry: onto the model's larger-context fallback when one is
+// configured, and otherwise by the truncate policy. Returns false when
+// neither applies.
+func (c *ContextFallback) Next(body string) (string, ContextStep, bool) {
+	payload, ok := decodeObject(body)
+	if !ok {
+		return body, ContextStep{}, false
+	}
+	model, _ := payload["model"].(string)
+
+	step := ContextStep{Model: model}
+	if fallback, ok := c.fallback(model); ok {
+		payload["model"] = fallback
+		step = ContextStep{Action: ContextActionFallback, From: model, Model: fallback}
+	} else if c.truncate == TruncateDropOldest {
+		dropped := dropOldest(payload)
+		if dropped == 0 {
+			return body, ContextStep{}, false
+		}
+		step.Action, step.Dropped = ContextActionTruncate, dropped
+	} else {
+		return body, ContextStep{}, false
+	}
+
+	rewritten, ok := encodeObject(payload)
+	if !ok {
+		return body, ContextStep{}, false
+	}
+	return rewritten, step, true
+}
+
+// fallback finds a model's larger-context fallback by exact name, then by
+// longest prefix so that dated snapshots resolve to their family entry
+func (c *ContextFallback) fallback(model string) (string, bool) {
+	if model == "" {
+		return "", false
+	}
+	if fallback, ok := c.models[model]; ok {
+		return fallback, true
+	}
+	best := ""
+	for name := range c.models {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" || c.models[best] == model {
+		return "", false
+	}
+	return c.models[best], true
+}
+
+// dropOldest drops the older half of a conversation's messages. Returns how
+// many messages were dropped.
+func dropOldest(payload map[string]interface{}) int {
+	messages, _ := payload["messages"].([]interface{})
+	return dropTurns(payload, countTurns(messages)/2)
+}
+
+// dropTurns drops at least n of a conversation's oldest messages, keeping
+// system and developer messages and the newest message. The conversation then
+// starts at a user message, so tool results are never separated from their
+// calls. Returns how many messages were dropped.
+func dropTurns(payload map[string]interface{}, n int) int {
+	messages, ok := payload["messages"].([]interface{})
+	if !ok || n <= 0 {
+		return 0
+	}
+
+	var turns []interface{}
+	for _, message := range messages {
+		if !isInstruction(message) {
+			turns = append(turns, message)
+		}
+	}
+	if len(turns) <= 1 {
+		return 0
+	}
+
+	drop := n
+	if drop > len(turns)-1 {
+		drop = len(turns) - 1
+	}
+	for drop < len(turns)-1 && messageRole(turns[drop]) != "user" {
+		drop++
+	}
+	kept := make([]interface{}, 0, len(messages)-drop)
+	turn := 0
+	for _, message := range messages {
+		if isInstruction(message) {
+			kept = append(kept, message)
+			continue
+		}
+		if turn >= drop {
+			kept = append(kept, message)
+		}
+		turn++
+	}
+	payload["messages"] = kept
+	return drop
+}
+
+// countTurns counts a conversation's messages other than system and developer ones
+func countTurns(messages []interface{}) int {
+	turns := 0
+	for _, message := range messages {
+		if !isInstruction(message) {
+			turns++
+		}
+	}
+	return turns
+}
+
+// isInstruction reports whether a chat message is a system or developer message
+func isInstruction(message interface{}) bool {
+	role := messageRole(message)
+	return role == "system" || role == "developer"
+}
+
+// messageRole returns the role of a chat message
+func messageRole(message interface{}) string {
+	m, _ := message.(map[string]interface{})
+	role, _ := m["role"].(string)
+	return role
+}
@@ -0,0 +1,73 @@
+package transform
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPins bounds how many conversations a split remembers. New
+// conversations are not pinned while the table is full of unexpired pins.
+const maxPins = 100000
+
+// pin is the variant a conversation was assigned and when that lapses
+type pin struct {
+	variant string
+	expires time.Time
+}
+
+// pinTable keeps each conversation on the variant its first turn was given,
+// so provider-side prompt caches keep being hit. A pin lapses ttl after the
+// conversation's last turn.
+type pinTable struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	pins      map[string]pin
+	lastSweep time.Time
+}
+
+func newPinTable(ttl time.Duration) *pinTable {
+	return &pinTable{ttl: ttl, pins: make(map[string]pin), lastSweep: time.Now()}
+}
+
+// get returns the variant a conversation is pinned to
+func (p *pinTable) get(key string, now time.Time) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pinned, ok := p.pins[key]
+	if !ok || now.After(pinned.expires) {
+		return "", false
+	}
+	return pinned.variant, true
+}
+
+// set pins a conversation to a variant, or extends its pin
+func (p *pinTable) set(key, variant string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.lastSweep) >= p.ttl || len(p.pins) >= maxPins {
+		for k, pinned := range p.pins {
+			if now.After(pinned.expires) {
+				delete(p.pins, k)
+			}
+		}
+		p.lastSweep = now
+	}
+	if _, ok := p.pins[key]; !ok && len(p.pins) >= maxPins {
+		return
+	}
+	p.pins[key] = pin{variant: variant, expires: now.Add(p.ttl)}
+}
+
+// size returns how many conversations are pinned
+func (p *pinTable) size(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	live := 0
+	for _, pinned := range p.pins {
+		if !now.After(pinned.expires) {
+			live++
+		}
+	}
+	return live
+}
@@ -0,0 +1,371 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// System prompt modes
+const (
+	ModeReplace = "replace" // Drop the client's system prompt (default)
+	ModePrepend = "prepend" // Put the configured prompt before the client's
+	ModeAppend  = "append"  // Put the configured prompt after the client's
+)
+
+// TemplateData is exposed to system prompt and prompt templates
+type TemplateData struct {
+	Endpoint string
+	Provider string
+	Model    string
+	System   string            // The client's system prompt, if any
+	Prompt   string            // The last user prompt, only set for prompt templates
+	Vars     map[string]string // Static values from the rule's vars
+
+	headers http.Header
+}
+
+// Header returns a client request header, e.g. {{.Header "X-Tenant"}}
+func (d TemplateData) Header(name string) string {
+	return d.headers.Get(name)
+}
+
+// requestRule is a compiled request transform
+type requestRule struct {
+	name           string
+	filter         guardrails.Applicability
+	systemPrompt   *template.Template
+	systemMode     string
+	promptTemplate *template.Template
+	defaults       map[string]interface{}
+	vars           map[string]string
+}
+
+// RequestTransformer rewrites request bodies before guardrails run and the
+// request is proxied: system prompt injection, default parameters and prompt templates
+type RequestTransformer struct {
+	rules []requestRule
+}
+
+// NewRequestTransformer compiles request transform rules
+func NewRequestTransformer(cfg []config.RequestTransformConfig) (*RequestTransformer, error) {
+	rules := make([]requestRule, 0, len(cfg))
+	for i, c := range cfg {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("request_transform_%d", i)
+		}
+
+		mode := c.SystemPromptMode
+		switch mode {
+		case ModeReplace, ModePrepend, ModeAppend:
+		case "":
+			mode = ModeReplace
+		default:
+			return nil, fmt.Errorf("transform %s: unknown system_prompt_mode: %s", name, mode)
+		}
+
+		rule := requestRule{
+			name: name,
+			filter: guardrails.Applicability{
+				Endpoints: c.Endpoints,
+				Providers: c.Providers,
+				Models:    c.Models,
+			},
+			systemMode: mode,
+			defaults:   c.Defaults,
+			vars:       c.Vars,
+		}
+
+		var err error
+		if c.SystemPrompt != "" {
+			if rule.systemPrompt, err = parseTemplate(name+".system_prompt", c.SystemPrompt); err != nil {
+				return nil, fmt.Errorf("transform %s: invalid system_prompt: %w", name, err)
+			}
+		}
+		if c.PromptTemplate != "" {
+			if rule.promptTemplate, err = parseTemplate(name+".prompt_template", c.PromptTemplate); err != nil {
+				return nil, fmt.Errorf("transform %s: invalid prompt_template: %w", name, err)
+			}
+		}
+		if rule.systemPrompt == nil && rule.promptTemplate == nil && len(rule.defaults) == 0 {
+			return nil, fmt.Errorf("transform %s: requires system_prompt, prompt_template or defaults", name)
+		}
+
+		rules = append(rules, rule)
+	}
+	return &RequestTransformer{rules: rules}, nil
+}
+
+// parseTemplate parses a template where missing map keys render as empty strings
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// Apply runs every rule matching the scope over a JSON request body, returning
+// the rewritten body and the names of the rules that changed it. Bodies that are
+// not JSON objects are returned unchanged.
+func (t *RequestTransformer) Apply(scope guardrails.Scope, body string) (string, []string, error) {
+	var matching []requestRule
+	for _, rule := range t.rules {
+		if rule.filter.Matches(scope) {
+			matching = append(matching, rule)
+		}
+	}
+	if len(matching) == 0 || body == "" {
+		return body, nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber() // Keep numeric fields exactly as the client sent them
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return body, nil, nil
+	}
+
+	var applied []string
+	for _, rule := range matching {
+		changed, err := rule.apply(scope, payload)
+		if err != nil {
+			return body, nil, fmt.Errorf("transform %s: %w", rule.name, err)
+		}
+		if changed {
+			applied = append(applied, rule.name)
+		}
+	}
+	if len(applied) == 0 {
+		return body, nil, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return body, nil, err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), applied, nil
+}
+
+// apply rewrites a decoded request body, reporting whether anything changed
+func (r requestRule) apply(scope guardrails.Scope, payload map[string]interface{}) (bool, error) {
+	changed := false
+
+	for key, value := range r.defaults {
+		if _, ok := payload[key]; !ok {
+			payload[key] = value
+			changed = true
+		}
+	}
+
+	// Defaults may have named the model
+	if model, ok := payload["model"].(string); ok {
+		scope.Model = model
+	}
+	data := TemplateData{
+		Endpoint: scope.Endpoint,
+		Provider: scope.Provider,
+		Model:    scope.Model,
+		Vars:     r.vars,
+		headers:  scope.Headers,
+	}
+	format := requestFormat(scope, payload)
+
+	if r.systemPrompt != nil && format != formatCompletion {
+		data.System = systemPrompt(format, payload)
+		rendered, err := render(r.systemPrompt, data)
+		if err != nil {
+			return false, err
+		}
+		setSystemPrompt(format, payload, combine(r.systemMode, data.System, rendered))
+		changed = true
+	}
+
+	if r.promptTemplate != nil {
+		prompt, set := lastPrompt(format, payload)
+		if set != nil {
+			data.Prompt = prompt
+			rendered, err := render(r.promptTemplate, data)
+			if err != nil {
+				return false, err
+			}
+			set(rendered)
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// render executes a template into a string
+func render(tmpl *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// combine merges the configured system prompt with the client's
+func combine(mode, existing, configured string) string {
+	if existing == "" {
+		return configured
+	}
+	switch mode {
+	case ModePrepend:
+		return configured + "\n\n" + existing
+	case ModeAppend:
+		return existing + "\n\n" + configured
+	default:
+		return configured
+	}
+}
+
+// Request body formats that place the system prompt differently
+const (
+	formatChat       = "chat"       // system/developer messages
+	formatAnthropic  = "anthropic"  // top-level "system"
+	formatResponses  = "responses"  // top-level "instructions"
+	formatCompletion = "completion" // plain "prompt", no system prompt
+)
+
+// requestFormat works out how the request carries its system prompt
+func requestFormat(scope guardrails.Scope, payload map[string]interface{}) string {
+	if scope.Provider == "anthropic" || scope.Endpoint == "/v1/messages" {
+		return formatAnthropic
+	}
+	if _, ok := payload["messages"]; ok {
+		return formatChat
+	}
+	if _, ok := payload["input"]; ok || scope.Endpoint == "/v1/responses" {
+		return formatResponses
+	}
+	if _, ok := payload["prompt"]; ok {
+		return formatCompletion
+	}
+	return formatChat
+}
+
+// systemPrompt returns the client's system prompt text
+func systemPrompt(format string, payload map[string]interface{}) string {
+	switch format {
+	case formatAnthropic:
+		return textOf(payload["system"])
+	case formatResponses:
+		instructions, _ := payload["instructions"].(string)
+		return instructions
+	}
+
+	messages, _ := payload["messages"].([]interface{})
+	var parts []string
+	for _, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok && isSystemRole(msg["role"]) {
+			if text := textOf(msg["content"]); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// setSystemPrompt replaces the request's system prompt with text
+func setSystemPrompt(format string, payload map[string]interface{}, text string) {
+	switch format {
+	case formatAnthropic:
+		payload["system"] = text
+		return
+	case formatResponses:
+		payload["instructions"] = text
+		return
+	}
+
+	messages, _ := payload["messages"].([]interface{})
+	kept := make([]interface{}, 0, len(messages)+1)
+	kept = append(kept, map[string]interface{}{"role": "system", "content": text})
+	for _, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok && isSystemRole(msg["role"]) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	payload["messages"] = kept
+}
+
+// lastPrompt returns the last user prompt and a setter for it, or a nil setter
+// when the request has no plain-text user prompt
+func lastPrompt(format string, payload map[string]interface{}) (string, func(string)) {
+	if format == formatCompletion {
+		if prompt, ok := payload["prompt"].(string); ok {
+			return prompt, func(s string) { payload["prompt"] = s }
+		}
+		return "", nil
+	}
+	if format == formatResponses {
+		if input, ok := payload["input"].(string); ok {
+			return input, func(s string) { payload["input"] = s }
+		}
+	}
+
+	key := "messages"
+	if format == formatResponses {
+		key = "input"
+	}
+	items, _ := payload[key].([]interface{})
+	for i := len(items) - 1; i >= 0; i-- {
+		msg, ok := items[i].(map[string]interface{})
+		if !ok || msg["role"] != "user" {
+			continue
+		}
+		return contentPrompt(msg)
+	}
+	return "", nil
+}
+
+// contentPrompt returns the text of a message's content, either a string or
+// the last text part of a content array
+func contentPrompt(msg map[string]interface{}) (string, func(string)) {
+	switch content := msg["content"].(type) {
+	case string:
+		return content, func(s string) { msg["content"] = s }
+	case []interface{}:
+		for i := len(content) - 1; i >= 0; i-- {
+			part, ok := content[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				return text, func(s string) { part["text"] = s }
+			}
+		}
+	}
+	return "", nil
+}
+
+// textOf flattens a string or an array of text blocks
+func textOf(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if block, ok := item.(map[string]interface{}); ok {
+				if text, ok := block["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// isSystemRole reports whether a chat role carries system instructions
+func isSystemRole(role interface{}) bool {
+	return role == "system" || role == "developer"
+}
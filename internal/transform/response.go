@@ -0,0 +1,237 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/compression"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Gateway metadata headers added when gateway_headers is enabled
+const (
+	HeaderProvider  = "X-Gateway-Provider"
+	HeaderLatencyMs = "X-Gateway-Latency-Ms"
+)
+
+// upstreamStartKey is the context key holding when the upstream request was sent
+const upstreamStartKey = "upstream_start"
+
+// WithUpstreamStart records when the upstream request was sent, for X-Gateway-Latency-Ms
+func WithUpstreamStart(ctx context.Context, start time.Time) context.Context {
+	return context.WithValue(ctx, upstreamStartKey, start)
+}
+
+// ResponseTransformer mutates an endpoint's responses: stripping JSON fields,
+// renaming models and setting headers
+type ResponseTransformer struct {
+	strip          *middleware.BodyRedactor
+	modelRename    map[string]string
+	headers        map[string]string
+	removeHeaders  []string
+	gatewayHeaders bool
+}
+
+// NewResponseTransformer compiles an endpoint's response transform
+func NewResponseTransformer(cfg config.ResponseTransformConfig) (*ResponseTransformer, error) {
+	t := &ResponseTransformer{
+		modelRename:    cfg.ModelRename,
+		headers:        cfg.Headers,
+		removeHeaders:  cfg.RemoveHeaders,
+		gatewayHeaders: cfg.GatewayHeaders,
+	}
+
+	if len(cfg.StripFields) > 0 {
+		rules := make([]config.RedactionRule, 0, len(cfg.StripFields))
+		for _, path := range cfg.StripFields {
+			rules = append(rules, config.RedactionRule{Path: path, Action: "remove", Target: "response"})
+		}
+		strip, err := middleware.NewBodyRedactor(rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strip_fields: %w", err)
+		}
+		t.strip = strip
+	}
+	return t, nil
+}
+
+// Apply transforms a provider response in place. JSON bodies are rewritten
+// whole; event streams are rewritten one data line at a time as they are read.
+func (t *ResponseTransformer) Apply(resp *http.Response, provider string) error {
+	for _, name := range t.removeHeaders {
+		resp.Header.Del(name)
+	}
+	for name, value := range t.headers {
+		resp.Header.Set(name, value)
+	}
+	if t.gatewayHeaders {
+		resp.Header.Set(HeaderProvider, provider)
+		if resp.Request != nil {
+			if start, ok := resp.Request.Context().Value(upstreamStartKey).(time.Time); ok {
+				resp.Header.Set(HeaderLatencyMs, strconv.FormatInt(time.Since(start).Milliseconds(), 10))
+			}
+		}
+	}
+
+	if t.strip == nil && len(t.modelRename) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/event-stream":
+		resp.Body = &eventStreamBody{
+			reader:    bufio.NewReader(resp.Body),
+			closer:    resp.Body,
+			transform: t.transformJSON,
+		}
+		middleware.ResetBodyHeaders(resp.Header)
+		resp.ContentLength = -1
+		return nil
+	case mediaType != "application/json":
+		return nil
+	}
+
+	encoding := resp.Header.Get("Content-Encoding")
+	if !compression.IsSupported(encoding) {
+		return nil // Can't decode it, so pass it through untouched
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	decoded, err := compression.Decode(encoding, body)
+	if err != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+
+	transformed, ok := t.transformJSON(decoded)
+	if !ok {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+
+	// The rewrite is made on decoded content, so it is sent uncompressed
+	middleware.ResetEntityHeaders(resp.Header)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(transformed)))
+	resp.ContentLength = int64(len(transformed))
+	resp.Body = io.NopCloser(bytes.NewReader(transformed))
+	return nil
+}
+
+// transformJSON strips fields from and renames models in a JSON document,
+// reporting false for anything that isn't JSON
+func (t *ResponseTransformer) transformJSON(data []byte) ([]byte, bool) {
+	if !json.Valid(data) {
+		return nil, false
+	}
+
+	body := string(data)
+	if t.strip != nil {
+		body = t.strip.RedactResponse(body)
+	}
+
+	if len(t.modelRename) > 0 {
+		decoder := json.NewDecoder(strings.NewReader(body))
+		decoder.UseNumber() // Keep numeric fields exactly as the provider sent them
+		var document interface{}
+		if err := decoder.Decode(&document); err != nil {
+			return nil, false
+		}
+		document = t.renameModels(document)
+
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(document); err != nil {
+			return nil, false
+		}
+		body = strings.TrimSuffix(buf.String(), "\n")
+	}
+	return []byte(body), true
+}
+
+// renameModels rewrites every "model" field, including those nested in stream
+// events such as response.model or message.model
+func (t *ResponseTransformer) renameModels(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if name, ok := child.(string); ok && key == "model" {
+				if renamed, ok := t.modelRename[name]; ok {
+					v[key] = renamed
+				}
+				continue
+			}
+			v[key] = t.renameModels(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = t.renameModels(child)
+		}
+	}
+	return value
+}
+
+// eventStreamBody rewrites the JSON payload of each SSE data line as the stream is read
+type eventStreamBody struct {
+	reader    *bufio.Reader
+	closer    io.Closer
+	transform func([]byte) ([]byte, bool)
+	pending   []byte
+	err       error
+}
+
+// Read implements io.Reader
+func (b *eventStreamBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		var line []byte
+		line, b.err = b.reader.ReadBytes('\n')
+		b.pending = b.transformLine(line)
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// transformLine rewrites a "data:" line, leaving everything else as is
+func (b *eventStreamBody) transformLine(line []byte) []byte {
+	trimmed := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(trimmed, []byte("data:")) {
+		return line
+	}
+	data := bytes.TrimSpace(trimmed[len("data:"):])
+	transformed, ok := b.transform(data)
+	if !ok {
+		return line // [DONE] and other non-JSON payloads
+	}
+
+	out := append([]byte("data: "), transformed...)
+	if bytes.HasSuffix(line, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// Close implements io.Closer
+func (b *eventStreamBody) Close() error {
+	return b.closer.Close()
+}
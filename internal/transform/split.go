@@ -0,0 +1,306 @@
+package transform
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/slo"
+)
+
+// SessionHeader is the default header keeping a client's session on one variant
+const SessionHeader = "X-Session-ID"
+
+// defaultStickyBy is used when a split does not set sticky_by
+var defaultStickyBy = []string{"header:" + SessionHeader, "api_key"}
+
+// Assignment records which variant of a traffic split a request was given
+type Assignment struct {
+	Split     string   `json:"split"`
+	Variant   string   `json:"variant"`
+	Requested string   `json:"requested,omitempty"` // The model the client asked for
+	Model     string   `json:"model"`               // The model sent upstream
+	StickyBy  string   `json:"sticky_by,omitempty"` // Attribute the assignment was derived from; empty when random
+	Avoided   []string `json:"avoided,omitempty"`   // Variants skipped for missing an SLO objective
+	Share     float64  `json:"share,omitempty"`     // The variant's share of traffic, for adaptive splits
+	Pinned    bool     `json:"pinned,omitempty"`    // The conversation was already pinned to this variant
+}
+
+// trafficSplit is a compiled traffic split
+type trafficSplit struct {
+	name     string
+	filter   guardrails.Applicability
+	stickyBy []string
+	variants []config.TrafficVariantConfig
+	total    int
+	avoid    bool           // Skip variants missing an SLO objective
+	adaptive *adaptiveSplit // Set for the adaptive strategy
+	pins     *pinTable      // Conversations kept on one variant; nil without pin_ttl
+}
+
+// TrafficSplitter assigns requests to A/B test and canary variants
+type TrafficSplitter struct {
+	splits []trafficSplit
+	slo    *slo.Tracker
+}
+
+// NewTrafficSplitter compiles traffic splits
+func NewTrafficSplitter(cfg []config.TrafficSplitConfig) (*TrafficSplitter, error) {
+	splits := make([]trafficSplit, 0, len(cfg))
+	for i, c := range cfg {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("split_%d", i)
+		}
+		if len(c.Variants) < 2 {
+			return nil, fmt.Errorf("split %s: requires at least two variants", name)
+		}
+
+		split := trafficSplit{
+			name: name,
+			filter: guardrails.Applicability{
+				Endpoints: c.Endpoints,
+				Providers: c.Providers,
+				Models:    c.Models,
+			},
+			stickyBy: c.StickyBy,
+			avoid:    c.AvoidBreached,
+		}
+		if len(split.stickyBy) == 0 {
+			split.stickyBy = defaultStickyBy
+		}
+		for _, source := range split.stickyBy {
+			if source != "api_key" && source != "user" && !strings.HasPrefix(source, "header:") {
+				return nil, fmt.Errorf("split %s: unknown sticky_by source: %s", name, source)
+			}
+		}
+
+		seen := make(map[string]bool)
+		for j, variant := range c.Variants {
+			if variant.Name == "" {
+				variant.Name = fmt.Sprintf("variant_%d", j)
+			}
+			if seen[variant.Name] {
+				return nil, fmt.Errorf("split %s: duplicate variant %s", name, variant.Name)
+			}
+			seen[variant.Name] = true
+			if variant.Weight < 0 {
+				return nil, fmt.Errorf("split %s: variant %s has a negative weight", name, variant.Name)
+			}
+			split.variants = append(split.variants, variant)
+			split.total += variant.Weight
+		}
+		if split.total == 0 {
+			return nil, fmt.Errorf("split %s: variant weights must not all be zero", name)
+		}
+
+		if c.PinTTL != "" {
+			ttl, err := time.ParseDuration(c.PinTTL)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("split %s: invalid pin_ttl %q", name, c.PinTTL)
+			}
+			split.pins = newPinTable(ttl)
+		}
+
+		switch c.Strategy {
+		case "", StrategyWeighted:
+		case StrategyAdaptive:
+			adaptive, err := newAdaptiveSplit(name, split.variants)
+			if err != nil {
+				return nil, err
+			}
+			split.adaptive = adaptive
+		default:
+			return nil, fmt.Errorf("split %s: unknown strategy %s", name, c.Strategy)
+		}
+
+		splits = append(splits, split)
+	}
+	return &TrafficSplitter{splits: splits}, nil
+}
+
+// SetSLO lets splits with avoid_breached skip variants missing an SLO
+// objective, and adaptive splits weigh variants by their latency and errors
+func (s *TrafficSplitter) SetSLO(tracker *slo.Tracker) {
+	s.slo = tracker
+}
+
+// Status returns adaptive splits' current shares by provider and variant, and
+// how many conversations each split has pinned
+func (s *TrafficSplitter) Status() map[string]interface{} {
+	status := make(map[string]interface{})
+	for _, split := range s.splits {
+		if split.adaptive == nil && split.pins == nil {
+			continue
+		}
+		entry := map[string]interface{}{"strategy": StrategyWeighted}
+		if split.adaptive != nil {
+			entry["strategy"] = StrategyAdaptive
+			entry["shares"] = split.adaptive.status(split.variants)
+		}
+		if split.pins != nil {
+			entry["pinned_conversations"] = split.pins.size(time.Now())
+			entry["pin_ttl"] = split.pins.ttl.String()
+		}
+		status[split.name] = entry
+	}
+	return status
+}
+
+// Apply assigns a request to a variant of the first matching split and rewrites
+// the body's model to the variant's. Clients are kept on the same variant by
+// hashing the split's sticky attributes, and with pin_ttl every turn of the
+// conversation named by conversationID stays on the variant it was first
+// given. Returns nil when no split matches.
+func (s *TrafficSplitter) Apply(scope guardrails.Scope, body, conversationID string) (string, *Assignment) {
+	for _, split := range s.splits {
+		if !split.filter.Matches(scope) {
+			continue
+		}
+
+		payload, ok := decodeObject(body)
+		if !ok {
+			return body, nil
+		}
+
+		variants, avoided := split.variants, []string(nil)
+		if split.adaptive != nil && s.slo != nil {
+			variants = split.adaptive.weighted(variants, scope.Provider, scope.Model, s.slo)
+		}
+		if split.avoid && s.slo != nil {
+			variants, avoided = split.healthy(scope, variants, s.slo)
+		}
+
+		now := time.Now()
+		var pinKey string
+		if split.pins != nil && conversationID != "" {
+			pinKey = scope.Provider + "\x00" + conversationID
+		}
+		key, source := split.stickyKey(scope, payload)
+		variant, pinned := split.pinned(pinKey, variants, now)
+		if !pinned {
+			variant = split.pick(variants, key, source != "")
+		}
+		if pinKey != "" {
+			split.pins.set(pinKey, variant.Name, now)
+		}
+		assignment := &Assignment{
+			Split:     split.name,
+			Variant:   variant.Name,
+			Requested: scope.Model,
+			Model:     scope.Model,
+			StickyBy:  source,
+			Avoided:   avoided,
+			Pinned:    pinned,
+		}
+		if split.adaptive != nil {
+			assignment.Share = math.Round(float64(variant.Weight)/shareScale*1000) / 1000
+		}
+		if variant.Model == "" || variant.Model == scope.Model {
+			return body, assignment
+		}
+
+		payload["model"] = variant.Model
+		rewritten, ok := encodeObject(payload)
+		if !ok {
+			return body, nil
+		}
+		assignment.Model = variant.Model
+		return rewritten, assignment
+	}
+	return body, nil
+}
+
+// stickyKey returns the first sticky attribute the request carries, and which one it was
+func (s *trafficSplit) stickyKey(scope guardrails.Scope, payload map[string]interface{}) (string, string) {
+	for _, source := range s.stickyBy {
+		var key string
+		switch {
+		case source == "api_key":
+			key = scope.Headers.Get("Authorization")
+		case source == "user":
+			key, _ = payload["user"].(string)
+		default:
+			key = scope.Headers.Get(strings.TrimPrefix(source, "header:"))
+		}
+		if key != "" {
+			return key, source
+		}
+	}
+	return "", ""
+}
+
+// pinned returns the variant a conversation is pinned to, when it is still
+// among the variants that may be picked
+func (s *trafficSplit) pinned(key string, variants []config.TrafficVariantConfig, now time.Time) (config.TrafficVariantConfig, bool) {
+	if key == "" {
+		return config.TrafficVariantConfig{}, false
+	}
+	name, ok := s.pins.get(key, now)
+	if !ok {
+		return config.TrafficVariantConfig{}, false
+	}
+	for _, variant := range variants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return config.TrafficVariantConfig{}, false
+}
+
+// healthy returns the variants meeting their SLO objectives and the names of
+// those that are not. When no weighted variant is healthy, every variant is
+// returned, since sending traffic somewhere beats failing it.
+func (s *trafficSplit) healthy(scope guardrails.Scope, variants []config.TrafficVariantConfig, tracker *slo.Tracker) ([]config.TrafficVariantConfig, []string) {
+	var healthy []config.TrafficVariantConfig
+	var avoided []string
+	weight := 0
+	for _, variant := range variants {
+		model := variant.Model
+		if model == "" {
+			model = scope.Model
+		}
+		if tracker.Breached(scope.Provider, model) {
+			avoided = append(avoided, variant.Name)
+			continue
+		}
+		healthy = append(healthy, variant)
+		weight += variant.Weight
+	}
+	if len(avoided) == 0 || weight == 0 {
+		return variants, nil
+	}
+	return healthy, avoided
+}
+
+// pick chooses one of variants by weight, deterministically for sticky keys
+func (s *trafficSplit) pick(variants []config.TrafficVariantConfig, key string, sticky bool) config.TrafficVariantConfig {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+
+	var bucket int
+	if sticky {
+		h := fnv.New64a()
+		h.Write([]byte(s.name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		bucket = int(h.Sum64() % uint64(total))
+	} else {
+		bucket = rand.Intn(total)
+	}
+
+	for _, variant := range variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return variants[len(variants)-1]
+}
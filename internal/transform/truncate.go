@@ -0,0 +1,346 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+)
+
+// Truncation strategies
+const (
+	TruncateSummarize = "summarize"
+	TruncateHard      = "hard_truncate"
+)
+
+const (
+	// defaultKeepRecent is how many of the newest messages summarize keeps verbatim
+	defaultKeepRecent = 4
+	// defaultSummaryPrompt asks the summary model for a summary that can stand in for the conversation
+	defaultSummaryPrompt = "Summarize the conversation below so the summary can replace it as context for an assistant continuing it. " +
+		"Keep facts, decisions, names, numbers and open questions. Be concise."
+	// summaryPrefix introduces a summary in place of the messages it replaces
+	summaryPrefix = "Summary of the earlier conversation:\n"
+	// truncatedMarker ends text cut short by hard_truncate
+	truncatedMarker = "\n[truncated]"
+	// maxHardCuts bounds how many times hard_truncate cuts and re-estimates
+	maxHardCuts = 8
+)
+
+// Truncation records how a request was shortened to fit its model's context window
+type Truncation struct {
+	Rule           string `json:"rule"`
+	Strategy       string `json:"strategy"`
+	Model          string `json:"model"`
+	ContextWindow  int    `json:"context_window"`
+	Limit          int    `json:"limit"` // Prompt tokens allowed after reserving completion tokens
+	TokensBefore   int    `json:"tokens_before"`
+	TokensAfter    int    `json:"tokens_after"`
+	Dropped        int    `json:"dropped,omitempty"`         // Messages dropped, or replaced by a summary
+	Summarized     bool   `json:"summarized,omitempty"`      // Older messages were replaced by a summary
+	TruncatedChars int    `json:"truncated_chars,omitempty"` // Characters cut by hard_truncate
+	Fits           bool   `json:"fits"`                      // False when the prompt could not be shortened enough
+	Error          string `json:"error,omitempty"`           // Why summarizing failed, when messages were dropped instead
+}
+
+// Estimator counts the prompt tokens of a request body
+type Estimator func(body string) (int, bool)
+
+// Summarizer asks a model for a completion of a single prompt
+type Summarizer func(ctx context.Context, model, prompt string) (string, error)
+
+// truncationRule is a compiled truncation rule
+type truncationRule struct {
+	name          string
+	filter        guardrails.Applicability
+	strategy      string
+	summaryModel  string
+	summaryPrompt string
+	keepRecent    int
+}
+
+// Truncator shortens requests estimated to overflow their model's context window
+type Truncator struct {
+	windows map[string]int
+	reserve int
+	rules   []truncationRule
+}
+
+// NewTruncator validates truncation rules
+func NewTruncator(cfg config.TruncationConfig) (*Truncator, error) {
+	for model, window := range cfg.ContextWindows {
+		if window <= 0 {
+			return nil, fmt.Errorf("context window for %s must be positive", model)
+		}
+	}
+	if cfg.Reserve < 0 {
+		return nil, fmt.Errorf("truncation reserve must not be negative")
+	}
+
+	t := &Truncator{windows: cfg.ContextWindows, reserve: cfg.Reserve}
+	for i, c := range cfg.Rules {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("rule_%d", i)
+		}
+		rule := truncationRule{
+			name: name,
+			filter: guardrails.Applicability{
+				Endpoints: c.Endpoints,
+				Providers: c.Providers,
+				Models:    c.Models,
+			},
+			strategy:      c.Strategy,
+			summaryModel:  c.SummaryModel,
+			summaryPrompt: c.SummaryPrompt,
+			keepRecent:    c.KeepRecent,
+		}
+		switch c.Strategy {
+		case TruncateDropOldest, TruncateHard:
+		case TruncateSummarize:
+			if c.SummaryModel == "" {
+				return nil, fmt.Errorf("truncation rule %s: summarize needs a summary_model", name)
+			}
+		default:
+			return nil, fmt.Errorf("truncation rule %s: unknown strategy %q", name, c.Strategy)
+		}
+		if rule.summaryPrompt == "" {
+			rule.summaryPrompt = defaultSummaryPrompt
+		}
+		if rule.keepRecent <= 0 {
+			rule.keepRecent = defaultKeepRecent
+		}
+		t.rules = append(t.rules, rule)
+	}
+	return t, nil
+}
+
+// Apply shortens a request whose estimated prompt tokens exceed its model's
+// context window, less the request's max_tokens and the configured reserve,
+// by the first matching rule's strategy. Returns nil when no rule matches, the
+// model's window is unknown or the request already fits.
+func (t *Truncator) Apply(ctx context.Context, scope guardrails.Scope, body string, estimate Estimator, summarize Summarizer) (string, *Truncation) {
+	var rule *truncationRule
+	for i := range t.rules {
+		if t.rules[i].filter.Matches(scope) {
+			rule = &t.rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return body, nil
+	}
+	window, ok := t.window(scope.Model)
+	if !ok {
+		return body, nil
+	}
+	payload, ok := decodeObject(body)
+	if !ok {
+		return body, nil
+	}
+	limit := window - t.reserve - maxTokens(payload)
+	tokens, ok := estimate(body)
+	if !ok || limit <= 0 || tokens <= limit {
+		return body, nil
+	}
+
+	result := &Truncation{
+		Rule:          rule.name,
+		Strategy:      rule.strategy,
+		Model:         scope.Model,
+		ContextWindow: window,
+		Limit:         limit,
+		TokensBefore:  tokens,
+		TokensAfter:   tokens,
+	}
+	// fits re-estimates the rewritten payload
+	fits := func() bool {
+		if rewritten, ok := encodeObject(payload); ok {
+			if tokens, ok := estimate(rewritten); ok {
+				result.TokensAfter = tokens
+			}
+		}
+		return result.TokensAfter <= limit
+	}
+
+	switch rule.strategy {
+	case TruncateSummarize:
+		if err := rule.summarizeOldest(ctx, payload, summarize, result); err != nil {
+			result.Error = err.Error()
+		}
+		if !fits() {
+			dropUntilFits(payload, result, fits)
+		}
+	case TruncateDropOldest:
+		dropUntilFits(payload, result, fits)
+	case TruncateHard:
+		hardTruncate(payload, result, limit, fits)
+	}
+	result.Fits = fits()
+
+	rewritten, ok := encodeObject(payload)
+	if !ok {
+		return body, nil
+	}
+	return rewritten, result
+}
+
+// window finds a model's context window by exact name, then by longest prefix
+// so that dated snapshots resolve to their family entry
+func (t *Truncator) window(model string) (int, bool) {
+	if model == "" {
+		return 0, false
+	}
+	if window, ok := t.windows[model]; ok {
+		return window, true
+	}
+	best := ""
+	for name := range t.windows {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return 0, false
+	}
+	return t.windows[best], true
+}
+
+// maxTokens returns the completion tokens a request asks to be reserved
+func maxTokens(payload map[string]interface{}) int {
+	for _, field := range []string{"max_completion_tokens", "max_tokens", "max_output_tokens"} {
+		if n, ok := payload[field].(json.Number); ok {
+			if v, err := n.Int64(); err == nil && v > 0 {
+				return int(v)
+			}
+		}
+	}
+	return 0
+}
+
+// dropUntilFits drops the oldest messages one turn at a time until the prompt fits
+func dropUntilFits(payload map[string]interface{}, result *Truncation, fits func() bool) {
+	for {
+		dropped := dropTurns(payload, 1)
+		if dropped == 0 {
+			return
+		}
+		result.Dropped += dropped
+		if fits() {
+			return
+		}
+	}
+}
+
+// summarizeOldest replaces all but the newest messages with a summary written
+// by the rule's summary model
+func (r *truncationRule) summarizeOldest(ctx context.Context, payload map[string]interface{}, summarize Summarizer, result *Truncation) error {
+	messages, _ := payload["messages"].([]interface{})
+	turns := countTurns(messages)
+	if turns <= r.keepRecent {
+		return fmt.Errorf("only %d messages to summarize from", turns)
+	}
+
+	// Trim a separate payload, so this one is untouched when summarizing fails
+	trimmed := map[string]interface{}{"messages": messages}
+	dropped := dropTurns(trimmed, turns-r.keepRecent)
+	if dropped == 0 {
+		return fmt.Errorf("no messages to summarize")
+	}
+	kept := trimmed["messages"].([]interface{})
+
+	var transcript strings.Builder
+	seen := 0
+	for _, message := range messages {
+		if isInstruction(message) {
+			continue
+		}
+		if seen >= dropped {
+			break
+		}
+		seen++
+		fmt.Fprintf(&transcript, "%s: %s\n\n", messageRole(message), messageText(message))
+	}
+
+	summary, err := summarize(ctx, r.summaryModel, r.summaryPrompt+"\n\n"+transcript.String())
+	if err != nil {
+		return fmt.Errorf("summarizing with %s: %w", r.summaryModel, err)
+	}
+	if strings.TrimSpace(summary) == "" {
+		return fmt.Errorf("summarizing with %s: empty summary", r.summaryModel)
+	}
+
+	// The summary goes where the dropped messages were: after the system
+	// messages, as a user message so Anthropic Messages accept it too
+	note := map[string]interface{}{"role": "user", "content": summaryPrefix + summary}
+	rewritten := make([]interface{}, 0, len(kept)+1)
+	placed := false
+	for _, message := range kept {
+		if !placed && !isInstruction(message) {
+			rewritten = append(rewritten, note)
+			placed = true
+		}
+		rewritten = append(rewritten, message)
+	}
+	payload["messages"] = rewritten
+	result.Dropped += dropped
+	result.Summarized = true
+	return nil
+}
+
+// hardTruncate cuts the end off the longest text message until the prompt
+// fits, moving on to the next longest when one is used up
+func hardTruncate(payload map[string]interface{}, result *Truncation, limit int, fits func() bool) {
+	messages, _ := payload["messages"].([]interface{})
+	for cut := 0; cut < maxHardCuts; cut++ {
+		longest, length := -1, 0
+		for i, message := range messages {
+			m, _ := message.(map[string]interface{})
+			if content, ok := m["content"].(string); ok && len([]rune(content)) > length {
+				longest, length = i, len([]rune(content))
+			}
+		}
+		if longest < 0 || length <= len(truncatedMarker) {
+			return
+		}
+
+		// Cut roughly four characters per excess token, at least a tenth of the text
+		m := messages[longest].(map[string]interface{})
+		text := []rune(strings.TrimSuffix(m["content"].(string), truncatedMarker))
+		remove := (result.TokensAfter - limit) * 4
+		if remove < len(text)/10 {
+			remove = len(text) / 10
+		}
+		if remove > len(text) {
+			remove = len(text)
+		}
+		m["content"] = string(text[:len(text)-remove]) + truncatedMarker
+		result.TruncatedChars += remove
+		if fits() {
+			return
+		}
+	}
+}
+
+// messageText returns a chat message's text, joining text content blocks
+func messageText(message interface{}) string {
+	m, _ := message.(map[string]interface{})
+	switch content := m["content"].(type) {
+	case string:
+		return content
+	case []interface{}:
+		var parts []string
+		for _, block := range content {
+			if b, ok := block.(map[string]interface{}); ok {
+				if text, ok := b["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,260 @@
+package translate
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// The parts of each API's request and response bodies that translation
+// maps. Fields without a counterpart in the other API are dropped.
+
+type openAIRequest struct {
+	Model               string               `json:"model"`
+	Messages            []openAIMessage      `json:"messages"`
+	MaxTokens           *int                 `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                 `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	Stop                json.RawMessage      `json:"stop,omitempty"` // string or array
+	Stream              bool                 `json:"stream,omitempty"`
+	StreamOptions       *openAIStreamOptions `json:"stream_options,omitempty"`
+	Tools               []openAITool         `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage      `json:"tool_choice,omitempty"` // string or object
+	ParallelToolCalls   *bool                `json:"parallel_tool_calls,omitempty"`
+	User                string               `json:"user,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role,omitempty"`
+	Content    json.RawMessage  `json:"content"` // string, array of parts, or null
+	Refusal    string           `json:"refusal,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	Index    *int               `json:"index,omitempty"` // stream deltas only
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *openAIMessage `json:"message,omitempty"`
+	Delta        *openAIMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *openAITokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+type openAITokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// apiError is the error object of both APIs' error bodies
+type apiError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        json.RawMessage      `json:"system,omitempty"` // string or text blocks
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *anthropicMetadata   `json:"metadata,omitempty"`
+}
+
+type anthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content anthropicContent `json:"content"`
+}
+
+// anthropicContent is a message's content blocks; requests may also give
+// the content as a plain string
+type anthropicContent []anthropicBlock
+
+func (c *anthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*c = anthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage  `json:"content,omitempty"` // tool results: string or blocks
+	IsError   bool             `json:"is_error,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"` // "base64" or "url"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type                   string `json:"type"` // auto, any, tool or none
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      []anthropicBlock `json:"content"`
+	StopReason   *string          `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// emptyObject stands in for missing tool inputs and schemas
+var emptyObject = json.RawMessage(`{}`)
+
+// openAIParts returns a message's content as parts; string content is one
+// text part
+func openAIParts(content json.RawMessage) ([]openAIPart, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return []openAIPart{{Type: "text", Text: text}}, nil
+	}
+	var parts []openAIPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return nil, invalid("message content must be a string or an array of parts")
+	}
+	return parts, nil
+}
+
+// openAIText joins the text of a message's content
+func openAIText(content json.RawMessage) string {
+	parts, _ := openAIParts(content)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// anthropicText joins the text of a system prompt or tool result, which may
+// be a string or text blocks
+func anthropicText(content json.RawMessage) string {
+	if len(content) == 0 {
+		return ""
+	}
+	var blocks anthropicContent
+	if json.Unmarshal(content, &blocks) != nil {
+		return ""
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// jsonString encodes a string as a JSON value
+func jsonString(s string) json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// jsonObject returns arguments or input that must be a JSON object, with {}
+// standing in for anything else
+func jsonObject(data []byte) json.RawMessage {
+	var object map[string]json.RawMessage
+	if len(data) == 0 || json.Unmarshal(data, &object) != nil || object == nil {
+		return emptyObject
+	}
+	return json.RawMessage(data)
+}
@@ -0,0 +1,396 @@
+package translate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openAIToAnthropic serves OpenAI chat completions clients from Anthropic's
+// Messages API
+type openAIToAnthropic struct{}
+
+func (openAIToAnthropic) Path() string {
+	return messagesPath
+}
+
+// Header moves a bearer token to x-api-key, which Anthropic authenticates
+// with, and pins the API version
+func (openAIToAnthropic) Header(header http.Header) {
+	if auth := header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && header.Get("X-Api-Key") == "" {
+		header.Set("X-Api-Key", strings.TrimPrefix(auth, "Bearer "))
+		header.Del("Authorization")
+	}
+	if header.Get("Anthropic-Version") == "" {
+		header.Set("Anthropic-Version", anthropicVersion)
+	}
+}
+
+func (openAIToAnthropic) Request(body []byte) ([]byte, error) {
+	var in openAIRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, invalid("request body is not a chat completions request: %v", err)
+	}
+
+	out := anthropicRequest{
+		Model:       in.Model,
+		MaxTokens:   defaultMaxTokens,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		Stream:      in.Stream,
+	}
+	switch {
+	case in.MaxCompletionTokens != nil:
+		out.MaxTokens = *in.MaxCompletionTokens
+	case in.MaxTokens != nil:
+		out.MaxTokens = *in.MaxTokens
+	}
+	// Anthropic's temperature range is 0 to 1, half of OpenAI's
+	if out.Temperature != nil && *out.Temperature > 1 {
+		one := 1.0
+		out.Temperature = &one
+	}
+	if in.User != "" {
+		out.Metadata = &anthropicMetadata{UserID: in.User}
+	}
+
+	if len(in.Stop) > 0 && string(in.Stop) != "null" {
+		var stop string
+		if json.Unmarshal(in.Stop, &stop) == nil {
+			out.StopSequences = []string{stop}
+		} else if err := json.Unmarshal(in.Stop, &out.StopSequences); err != nil {
+			return nil, invalid("stop must be a string or an array of strings")
+		}
+	}
+
+	var system []string
+	for _, m := range in.Messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, openAIText(m.Content))
+		case "user":
+			blocks, err := userBlocks(m.Content)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendMessage(out.Messages, "user", blocks)
+		case "assistant":
+			var blocks []anthropicBlock
+			if text := openAIText(m.Content); text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+			}
+			for _, call := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: jsonObject([]byte(call.Function.Arguments)),
+				})
+			}
+			out.Messages = appendMessage(out.Messages, "assistant", blocks)
+		case "tool":
+			// Tool results go back to Anthropic in a user turn
+			out.Messages = appendMessage(out.Messages, "user", []anthropicBlock{{
+				Type:      "tool_result",
+				ToolUseID: m.ToolCallID,
+				Content:   jsonString(openAIText(m.Content)),
+			}})
+		default:
+			return nil, invalid("unsupported message role %q", m.Role)
+		}
+	}
+	if len(system) > 0 {
+		out.System = jsonString(strings.Join(system, "\n\n"))
+	}
+
+	for _, tool := range in.Tools {
+		if tool.Type != "function" {
+			return nil, invalid("unsupported tool type %q", tool.Type)
+		}
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	if len(out.Tools) > 0 {
+		choice, err := anthropicChoice(in.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		if in.ParallelToolCalls != nil && !*in.ParallelToolCalls {
+			if choice == nil {
+				choice = &anthropicToolChoice{Type: "auto"}
+			}
+			choice.DisableParallelToolUse = true
+		}
+		out.ToolChoice = choice
+	}
+
+	return json.Marshal(out)
+}
+
+// userBlocks converts a user message's text and images
+func userBlocks(content json.RawMessage) ([]anthropicBlock, error) {
+	parts, err := openAIParts(content)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]anthropicBlock, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				return nil, invalid("image_url part without a url")
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: imageSource(part.ImageURL.URL)})
+		default:
+			return nil, invalid("unsupported content part type %q", part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// imageSource turns an image URL, possibly a data URL, into an image source
+func imageSource(url string) *anthropicSource {
+	if rest := strings.TrimPrefix(url, "data:"); rest != url {
+		if meta, data, ok := strings.Cut(rest, ","); ok && strings.HasSuffix(meta, ";base64") {
+			return &anthropicSource{Type: "base64", MediaType: strings.TrimSuffix(meta, ";base64"), Data: data}
+		}
+	}
+	return &anthropicSource{Type: "url", URL: url}
+}
+
+// appendMessage adds a turn, merging it into the previous one when both come
+// from the same role, since Anthropic requires turns to alternate
+func appendMessage(messages []anthropicMessage, role string, blocks []anthropicBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if last := len(messages) - 1; last >= 0 && messages[last].Role == role {
+		messages[last].Content = append(messages[last].Content, blocks...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// anthropicChoice converts tool_choice: "auto", "required", "none" or a named function
+func anthropicChoice(raw json.RawMessage) (*anthropicToolChoice, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var mode string
+	if json.Unmarshal(raw, &mode) == nil {
+		switch mode {
+		case "auto":
+			return &anthropicToolChoice{Type: "auto"}, nil
+		case "required":
+			return &anthropicToolChoice{Type: "any"}, nil
+		case "none":
+			return &anthropicToolChoice{Type: "none"}, nil
+		}
+		return nil, invalid("unsupported tool_choice %q", mode)
+	}
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil || named.Function.Name == "" {
+		return nil, invalid("tool_choice must name a function")
+	}
+	return &anthropicToolChoice{Type: "tool", Name: named.Function.Name}, nil
+}
+
+func (t openAIToAnthropic) Response(resp *http.Response) error {
+	return rewrite(resp, t.completion, openAIError, func(body io.ReadCloser) io.ReadCloser {
+		s := &chunkStream{created: time.Now().Unix(), tools: make(map[int]int)}
+		return newEventStream(body, s.convert, func() []byte { return nil })
+	})
+}
+
+// completion converts a Messages API response to a chat completion
+func (openAIToAnthropic) completion(body []byte) ([]byte, error) {
+	var in anthropicResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	message := openAIMessage{Role: "assistant"}
+	var text strings.Builder
+	for _, block := range in.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, openAIToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.Name, Arguments: string(jsonObject(block.Input))},
+			})
+		}
+	}
+	if text.Len() > 0 || len(message.ToolCalls) == 0 {
+		message.Content = jsonString(text.String())
+	}
+
+	finish := finishReason(in.StopReason)
+	return json.Marshal(openAIResponse{
+		ID:      in.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   in.Model,
+		Choices: []openAIChoice{{Message: &message, FinishReason: &finish}},
+		Usage:   openAIUsageOf(in.Usage),
+	})
+}
+
+// finishReason maps an Anthropic stop reason to an OpenAI finish reason
+func finishReason(stopReason *string) string {
+	if stopReason == nil {
+		return "stop"
+	}
+	switch *stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default: // end_turn, stop_sequence, pause_turn
+		return "stop"
+	}
+}
+
+// openAIUsageOf counts cached prompt tokens as prompt tokens, as OpenAI does
+func openAIUsageOf(usage anthropicUsage) *openAIUsage {
+	prompt := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	return &openAIUsage{
+		PromptTokens:        prompt,
+		CompletionTokens:    usage.OutputTokens,
+		TotalTokens:         prompt + usage.OutputTokens,
+		PromptTokensDetails: &openAITokensDetails{CachedTokens: usage.CacheReadInputTokens},
+	}
+}
+
+// openAIError converts an Anthropic error body to the OpenAI error format
+func openAIError(body []byte) []byte {
+	var in struct {
+		Error apiError `json:"error"`
+	}
+	if json.Unmarshal(body, &in) != nil || in.Error.Message == "" {
+		return body
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": in.Error.Message,
+			"type":    in.Error.Type,
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+	return out
+}
+
+// chunkStream converts Messages API stream events to chat completion chunks
+type chunkStream struct {
+	id      string
+	model   string
+	created int64
+	usage   anthropicUsage
+	tools   map[int]int // content block index -> tool call index
+}
+
+func (s *chunkStream) convert(_ string, data []byte) []byte {
+	var event struct {
+		Type         string             `json:"type"`
+		Message      *anthropicResponse `json:"message"`
+		Index        int                `json:"index"`
+		ContentBlock *anthropicBlock    `json:"content_block"`
+		Delta        struct {
+			Type        string  `json:"type"`
+			Text        string  `json:"text"`
+			PartialJSON string  `json:"partial_json"`
+			StopReason  *string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage *anthropicUsage `json:"usage"`
+		Error *apiError       `json:"error"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return nil
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			s.id, s.model, s.usage = event.Message.ID, event.Message.Model, event.Message.Usage
+		}
+		return s.chunk(openAIMessage{Role: "assistant", Content: jsonString("")}, nil)
+	case "content_block_start":
+		if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" {
+			return nil
+		}
+		index := len(s.tools)
+		s.tools[event.Index] = index
+		return s.chunk(openAIMessage{ToolCalls: []openAIToolCall{{
+			Index:    &index,
+			ID:       event.ContentBlock.ID,
+			Type:     "function",
+			Function: openAIFunctionCall{Name: event.ContentBlock.Name},
+		}}}, nil)
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			return s.chunk(openAIMessage{Content: jsonString(event.Delta.Text)}, nil)
+		case "input_json_delta":
+			index, ok := s.tools[event.Index]
+			if !ok {
+				return nil
+			}
+			return s.chunk(openAIMessage{ToolCalls: []openAIToolCall{{
+				Index:    &index,
+				Function: openAIFunctionCall{Arguments: event.Delta.PartialJSON},
+			}}}, nil)
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			s.usage.OutputTokens = event.Usage.OutputTokens
+			if event.Usage.InputTokens > 0 {
+				s.usage.InputTokens = event.Usage.InputTokens
+			}
+		}
+		// Usage rides on the final chunk, where cost tracking looks for it
+		finish := finishReason(event.Delta.StopReason)
+		return s.chunk(openAIMessage{}, &finish)
+	case "message_stop":
+		return []byte("data: [DONE]\n\n")
+	case "error":
+		if event.Error != nil {
+			return sseData(map[string]interface{}{"error": event.Error})
+		}
+	}
+	return nil
+}
+
+// chunk formats a chat completion chunk; the final one carries usage
+func (s *chunkStream) chunk(delta openAIMessage, finish *string) []byte {
+	out := openAIResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   s.model,
+		Choices: []openAIChoice{{Delta: &delta, FinishReason: finish}},
+	}
+	if finish != nil {
+		out.Usage = openAIUsageOf(s.usage)
+	}
+	return sseData(out)
+}
@@ -0,0 +1,381 @@
+package translate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// anthropicToOpenAI serves Anthropic Messages API clients from OpenAI's chat
+// completions API
+type anthropicToOpenAI struct{}
+
+func (anthropicToOpenAI) Path() string {
+	return chatCompletionsPath
+}
+
+// Header moves an x-api-key to a bearer token and drops Anthropic's version
+// headers
+func (anthropicToOpenAI) Header(header http.Header) {
+	if key := header.Get("X-Api-Key"); key != "" && header.Get("Authorization") == "" {
+		header.Set("Authorization", "Bearer "+key)
+	}
+	header.Del("X-Api-Key")
+	header.Del("Anthropic-Version")
+	header.Del("Anthropic-Beta")
+}
+
+func (anthropicToOpenAI) Request(body []byte) ([]byte, error) {
+	var in anthropicRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, invalid("request body is not a Messages API request: %v", err)
+	}
+
+	out := openAIRequest{
+		Model:       in.Model,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		Stream:      in.Stream,
+	}
+	if in.MaxTokens > 0 {
+		out.MaxTokens = &in.MaxTokens
+	}
+	if in.Stream {
+		// Anthropic streams always report usage; OpenAI only does when asked
+		out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if len(in.StopSequences) > 0 {
+		out.Stop, _ = json.Marshal(in.StopSequences)
+	}
+	if in.Metadata != nil {
+		out.User = in.Metadata.UserID
+	}
+
+	if system := anthropicText(in.System); system != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: jsonString(system)})
+	}
+	for _, m := range in.Messages {
+		switch m.Role {
+		case "user":
+			messages, err := userMessages(m.Content)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = append(out.Messages, messages...)
+		case "assistant":
+			message := openAIMessage{Role: "assistant"}
+			var text []string
+			for _, block := range m.Content {
+				switch block.Type {
+				case "text":
+					text = append(text, block.Text)
+				case "tool_use":
+					message.ToolCalls = append(message.ToolCalls, openAIToolCall{
+						ID:       block.ID,
+						Type:     "function",
+						Function: openAIFunctionCall{Name: block.Name, Arguments: string(jsonObject(block.Input))},
+					})
+				}
+			}
+			if len(text) > 0 || len(message.ToolCalls) == 0 {
+				message.Content = jsonString(strings.Join(text, ""))
+			}
+			out.Messages = append(out.Messages, message)
+		default:
+			return nil, invalid("unsupported message role %q", m.Role)
+		}
+	}
+
+	for _, tool := range in.Tools {
+		schema := tool.InputSchema
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: tool.Name, Description: tool.Description, Parameters: schema},
+		})
+	}
+	if len(out.Tools) > 0 && in.ToolChoice != nil {
+		switch in.ToolChoice.Type {
+		case "auto", "none":
+			out.ToolChoice = jsonString(in.ToolChoice.Type)
+		case "any":
+			out.ToolChoice = jsonString("required")
+		case "tool":
+			out.ToolChoice, _ = json.Marshal(map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": in.ToolChoice.Name},
+			})
+		default:
+			return nil, invalid("unsupported tool_choice type %q", in.ToolChoice.Type)
+		}
+		if in.ToolChoice.DisableParallelToolUse {
+			parallel := false
+			out.ParallelToolCalls = &parallel
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// userMessages converts a user turn. Tool results become tool messages, which
+// OpenAI expects right after the assistant's tool calls, so they come first.
+func userMessages(content anthropicContent) ([]openAIMessage, error) {
+	var messages []openAIMessage
+	var parts []openAIPart
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, openAIPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				return nil, invalid("image block without a source")
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+			parts = append(parts, openAIPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+		case "tool_result":
+			messages = append(messages, openAIMessage{
+				Role:       "tool",
+				ToolCallID: block.ToolUseID,
+				Content:    jsonString(anthropicText(block.Content)),
+			})
+		default:
+			return nil, invalid("unsupported content block type %q", block.Type)
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0].Type == "text":
+		messages = append(messages, openAIMessage{Role: "user", Content: jsonString(parts[0].Text)})
+	case len(parts) > 0:
+		encoded, _ := json.Marshal(parts)
+		messages = append(messages, openAIMessage{Role: "user", Content: encoded})
+	}
+	return messages, nil
+}
+
+func (t anthropicToOpenAI) Response(resp *http.Response) error {
+	return rewrite(resp, t.message, anthropicError, func(body io.ReadCloser) io.ReadCloser {
+		s := &messageStream{block: -1}
+		return newEventStream(body, s.convert, s.finish)
+	})
+}
+
+// message converts a chat completion to a Messages API response
+func (anthropicToOpenAI) message(body []byte) ([]byte, error) {
+	var in openAIResponse
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	out := anthropicResponse{
+		ID:      in.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   in.Model,
+		Content: []anthropicBlock{},
+	}
+	var finish *string
+	if len(in.Choices) > 0 && in.Choices[0].Message != nil {
+		message := in.Choices[0].Message
+		finish = in.Choices[0].FinishReason
+		text := openAIText(message.Content)
+		if text == "" {
+			text = message.Refusal
+		}
+		if text != "" {
+			out.Content = append(out.Content, anthropicBlock{Type: "text", Text: text})
+		}
+		for _, call := range message.ToolCalls {
+			out.Content = append(out.Content, anthropicBlock{
+				Type:  "tool_use",
+				ID:    call.ID,
+				Name:  call.Function.Name,
+				Input: jsonObject([]byte(call.Function.Arguments)),
+			})
+		}
+	}
+	stopReason := stopReason(finish)
+	out.StopReason = &stopReason
+	if in.Usage != nil {
+		out.Usage = anthropicUsageOf(*in.Usage)
+	}
+	return json.Marshal(out)
+}
+
+// stopReason maps an OpenAI finish reason to an Anthropic stop reason
+func stopReason(finishReason *string) string {
+	if finishReason == nil {
+		return "end_turn"
+	}
+	switch *finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicUsageOf reports cached prompt tokens separately, as Anthropic does
+func anthropicUsageOf(usage openAIUsage) anthropicUsage {
+	var cached int
+	if usage.PromptTokensDetails != nil {
+		cached = usage.PromptTokensDetails.CachedTokens
+	}
+	return anthropicUsage{
+		InputTokens:          usage.PromptTokens - cached,
+		OutputTokens:         usage.CompletionTokens,
+		CacheReadInputTokens: cached,
+	}
+}
+
+// anthropicError converts an OpenAI error body to the Anthropic error format
+func anthropicError(body []byte) []byte {
+	var in struct {
+		Error apiError `json:"error"`
+	}
+	if json.Unmarshal(body, &in) != nil || in.Error.Message == "" {
+		return body
+	}
+	if in.Error.Type == "" {
+		in.Error.Type = "api_error"
+	}
+	out, _ := json.Marshal(map[string]interface{}{"type": "error", "error": in.Error})
+	return out
+}
+
+// messageStream converts chat completion chunks to Messages API stream
+// events. OpenAI sends usage after the finish reason, so the closing events
+// wait for the end of the stream.
+type messageStream struct {
+	started    bool
+	ended      bool
+	block      int    // index of the open content block, -1 for none
+	blockType  string // "text" or "tool_use"
+	stopReason string
+	usage      anthropicUsage
+}
+
+func (s *messageStream) convert(_ string, data []byte) []byte {
+	if s.ended {
+		return nil
+	}
+	if string(data) == "[DONE]" {
+		return s.finish()
+	}
+
+	var chunk struct {
+		openAIResponse
+		Error *apiError `json:"error"`
+	}
+	if json.Unmarshal(data, &chunk) != nil {
+		return nil
+	}
+	if chunk.Error != nil {
+		return sseEvent("error", map[string]interface{}{"type": "error", "error": chunk.Error})
+	}
+
+	var out []byte
+	if !s.started {
+		s.started = true
+		out = append(out, sseEvent("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": anthropicResponse{
+				ID:      chunk.ID,
+				Type:    "message",
+				Role:    "assistant",
+				Model:   chunk.Model,
+				Content: []anthropicBlock{},
+			},
+		})...)
+	}
+
+	if len(chunk.Choices) > 0 {
+		choice := chunk.Choices[0]
+		if delta := choice.Delta; delta != nil {
+			if text := openAIText(delta.Content); text != "" {
+				if s.blockType != "text" {
+					out = append(out, s.open(map[string]string{"type": "text", "text": ""}, "text")...)
+				}
+				out = append(out, sseEvent("content_block_delta", map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": s.block,
+					"delta": map[string]string{"type": "text_delta", "text": text},
+				})...)
+			}
+			for _, call := range delta.ToolCalls {
+				if call.ID != "" {
+					out = append(out, s.open(anthropicBlock{
+						Type:  "tool_use",
+						ID:    call.ID,
+						Name:  call.Function.Name,
+						Input: emptyObject,
+					}, "tool_use")...)
+				}
+				if call.Function.Arguments != "" && s.blockType == "tool_use" {
+					out = append(out, sseEvent("content_block_delta", map[string]interface{}{
+						"type":  "content_block_delta",
+						"index": s.block,
+						"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+					})...)
+				}
+			}
+		}
+		if choice.FinishReason != nil {
+			s.stopReason = stopReason(choice.FinishReason)
+		}
+	}
+	if chunk.Usage != nil {
+		s.usage = anthropicUsageOf(*chunk.Usage)
+	}
+	return out
+}
+
+// open closes the current content block and starts another
+func (s *messageStream) open(block interface{}, blockType string) []byte {
+	out := s.close()
+	s.block++
+	s.blockType = blockType
+	return append(out, sseEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.block,
+		"content_block": block,
+	})...)
+}
+
+// close ends the open content block, if any
+func (s *messageStream) close() []byte {
+	if s.blockType == "" {
+		return nil
+	}
+	s.blockType = ""
+	return sseEvent("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": s.block})
+}
+
+// finish sends the stop reason and usage and ends the message
+func (s *messageStream) finish() []byte {
+	if !s.started || s.ended {
+		return nil
+	}
+	s.ended = true
+	if s.stopReason == "" {
+		s.stopReason = "end_turn"
+	}
+	out := s.close()
+	out = append(out, sseEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": s.stopReason, "stop_sequence": nil},
+		"usage": s.usage,
+	})...)
+	return append(out, sseEvent("message_stop", map[string]string{"type": "message_stop"})...)
+}
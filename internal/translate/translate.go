@@ -0,0 +1,182 @@
+// Package translate converts traffic between the OpenAI chat completions and
+// Anthropic Messages APIs, so clients written against one can be served by a
+// provider speaking the other. Messages, images, tool calls, stop reasons,
+// usage, errors and streamed events are all mapped.
+package translate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Translation directions, named client format first
+const (
+	OpenAIToAnthropic = "openai-to-anthropic" // OpenAI clients, Anthropic upstream
+	AnthropicToOpenAI = "anthropic-to-openai" // Anthropic clients, OpenAI upstream
+)
+
+// Upstream paths translated requests are sent to
+const (
+	chatCompletionsPath = "/v1/chat/completions"
+	messagesPath        = "/v1/messages"
+)
+
+// anthropicVersion is sent to Anthropic when the client didn't pick a version
+const anthropicVersion = "2023-06-01"
+
+// defaultMaxTokens fills Anthropic's required max_tokens when an OpenAI client
+// left it out
+const defaultMaxTokens = 4096
+
+// ErrInvalidRequest is returned for requests that can't be expressed in the
+// upstream format
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Translator converts one endpoint's requests to the upstream format and its
+// responses back to the client's
+type Translator interface {
+	// Path is the upstream endpoint requests are sent to
+	Path() string
+
+	// Request converts a request body
+	Request(body []byte) ([]byte, error)
+
+	// Header adapts authentication and version headers
+	Header(header http.Header)
+
+	// Response converts a response, streamed or not, in place
+	Response(resp *http.Response) error
+}
+
+// New returns the translator for a direction
+func New(direction string) (Translator, error) {
+	switch direction {
+	case OpenAIToAnthropic:
+		return openAIToAnthropic{}, nil
+	case AnthropicToOpenAI:
+		return anthropicToOpenAI{}, nil
+	default:
+		return nil, fmt.Errorf("unknown translation %q (want %s or %s)", direction, OpenAIToAnthropic, AnthropicToOpenAI)
+	}
+}
+
+// invalid reports a request the translation can't handle
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+// rewrite converts a response body with the function for its kind: success,
+// failure or an event stream
+func rewrite(resp *http.Response, success func([]byte) ([]byte, error), failure func([]byte) []byte, stream func(io.ReadCloser) io.ReadCloser) error {
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return fmt.Errorf("cannot translate a %s encoded response", encoding)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" && resp.StatusCode < 300 {
+		resp.Body = stream(resp.Body)
+		resp.ContentLength = -1
+		middleware.ResetBodyHeaders(resp.Header)
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if body, err = success(body); err != nil {
+			return err
+		}
+	} else {
+		body = failure(body)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	middleware.ResetBodyHeaders(resp.Header)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// eventStream translates a server-sent event stream one event at a time, so
+// translated events reach the client as soon as the upstream sends them
+type eventStream struct {
+	source  io.ReadCloser
+	reader  *bufio.Reader
+	convert func(event string, data []byte) []byte // translated events, if any
+	finish  func() []byte                          // written once the upstream ends
+	out     bytes.Buffer
+	event   string
+	data    []byte
+	err     error
+}
+
+func newEventStream(source io.ReadCloser, convert func(string, []byte) []byte, finish func() []byte) *eventStream {
+	return &eventStream{source: source, reader: bufio.NewReader(source), convert: convert, finish: finish}
+}
+
+func (s *eventStream) Read(p []byte) (int, error) {
+	for s.out.Len() == 0 && s.err == nil {
+		s.next()
+	}
+	if s.out.Len() > 0 {
+		return s.out.Read(p)
+	}
+	return 0, s.err
+}
+
+func (s *eventStream) Close() error {
+	return s.source.Close()
+}
+
+// next reads one line, translating the event it completes
+func (s *eventStream) next() {
+	line, err := s.reader.ReadBytes('\n')
+	line = bytes.TrimRight(line, "\r\n")
+	switch {
+	case len(line) == 0:
+		s.dispatch()
+	case bytes.HasPrefix(line, []byte("event:")):
+		s.event = string(bytes.TrimSpace(line[len("event:"):]))
+	case bytes.HasPrefix(line, []byte("data:")):
+		s.data = append(s.data, bytes.TrimSpace(line[len("data:"):])...)
+	}
+
+	if err != nil {
+		s.dispatch()
+		if err == io.EOF {
+			s.out.Write(s.finish())
+		}
+		s.err = err
+	}
+}
+
+// dispatch translates the event read so far
+func (s *eventStream) dispatch() {
+	if len(s.data) > 0 {
+		s.out.Write(s.convert(s.event, s.data))
+	}
+	s.event = ""
+	s.data = nil
+}
+
+// sseEvent formats a named event, as Anthropic streams use
+func sseEvent(name string, v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data))
+}
+
+// sseData formats an unnamed event, as OpenAI streams use
+func sseData(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return []byte(fmt.Sprintf("data: %s\n\n", data))
+}
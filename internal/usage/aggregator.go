@@ -0,0 +1,222 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+)
+
+// logWriteDelay covers logs that are buffered before being written, so a run
+// recomputes periods those logs may still land in
+const logWriteDelay = time.Minute
+
+// Period is the granularity of a usage table
+type Period string
+
+const (
+	Hour Period = "hour"
+	Day  Period = "day"
+)
+
+// table returns the usage table holding a period's rollups
+func (p Period) table() string {
+	if p == Hour {
+		return "usage_hourly"
+	}
+	return "usage_daily"
+}
+
+// length returns how long one period lasts
+func (p Period) length() time.Duration {
+	if p == Hour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// rollupQuery re-aggregates every period starting at or after $1. Whole
+// periods are recomputed and upserted, so running it again is harmless and
+// picks up logs written late. Token counts and cost come from the cost
+// breakdown, and the API key from its fingerprint, in log metadata.
+const rollupQuery = `
+	INSERT INTO %[1]s (
+		period_start, api_key_id, model, provider,
+		requests, errors, prompt_tokens, completion_tokens, cost, updated_at
+	)
+	SELECT
+		date_trunc('%[2]s', timestamp, 'UTC'),
+		COALESCE(metadata->>'api_key_id', ''),
+		COALESCE(metadata->'cost'->>'model', metadata->'token_estimate'->>'model', ''),
+		COALESCE(provider, ''),
+		COUNT(*),
+		COUNT(*) FILTER (WHERE error IS NOT NULL OR status_code >= 400),
+		COALESCE(SUM((metadata->'cost'->>'prompt_tokens')::BIGINT), 0),
+		COALESCE(SUM((metadata->'cost'->>'completion_tokens')::BIGINT), 0),
+		COALESCE(SUM((metadata->'cost'->>'total_cost')::NUMERIC), 0),
+		NOW()
+	FROM request_logs
+	WHERE timestamp >= $1
+	GROUP BY 1, 2, 3, 4
+	ON CONFLICT (period_start, api_key_id, model, provider) DO UPDATE SET
+		requests = EXCLUDED.requests,
+		errors = EXCLUDED.errors,
+		prompt_tokens = EXCLUDED.prompt_tokens,
+		completion_tokens = EXCLUDED.completion_tokens,
+		cost = EXCLUDED.cost,
+		updated_at = EXCLUDED.updated_at`
+
+// Aggregator periodically rolls request logs up into hourly and daily usage
+// tables, which the reporting API reads from
+type Aggregator struct {
+	db       *sql.DB
+	interval time.Duration
+	backfill time.Duration
+	leader   func() bool // Whether this instance runs the aggregation, with leader election
+
+	mu         sync.Mutex
+	aggregated time.Time // Logs before this have been rolled up
+	lastRun    time.Time
+	lastError  string
+	runs       int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New creates a usage aggregator from configuration
+func New(db *sql.DB, cfg config.UsageConfig) (*Aggregator, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid usage interval: %q", cfg.Interval)
+	}
+	backfill, err := time.ParseDuration(cfg.Backfill)
+	if err != nil || backfill < 0 {
+		return nil, fmt.Errorf("invalid usage backfill: %q", cfg.Backfill)
+	}
+
+	return &Aggregator{
+		db:       db,
+		interval: interval,
+		backfill: backfill,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Start aggregates immediately and then on every interval
+func (a *Aggregator) Start() {
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			if a.leader == nil || a.leader() {
+				ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+				if err := a.Run(ctx); err != nil {
+					log.Printf("[USAGE] Aggregation failed: %v", err)
+				}
+				cancel()
+			} else {
+				a.standby()
+			}
+
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SetLeader has only the elected leader aggregate, leaving the tables to
+// it while leader returns false. Call it before Start.
+func (a *Aggregator) SetLeader(leader func() bool) {
+	a.leader = leader
+}
+
+// standby forgets how far this instance aggregated while another instance
+// leads, so that on taking over it reaches back over the backfill window and
+// covers any runs the previous leader missed
+func (a *Aggregator) standby() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aggregated = time.Time{}
+}
+
+// Stop ends periodic aggregation, waiting for a run in progress
+func (a *Aggregator) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		if a.done != nil {
+			<-a.done
+		}
+	})
+}
+
+// Run rolls up every period touched since the last run, reaching back over
+// the backfill window on the first one
+func (a *Aggregator) Run(ctx context.Context) error {
+	start := time.Now().UTC()
+
+	a.mu.Lock()
+	since := a.aggregated
+	a.mu.Unlock()
+	if since.IsZero() {
+		since = start.Add(-a.backfill)
+	}
+
+	var err error
+	for _, period := range []Period{Hour, Day} {
+		// Recompute from the start of the period the last run ended in
+		from := since.Add(-logWriteDelay).Truncate(period.length())
+		query := fmt.Sprintf(rollupQuery, period.table(), period)
+		if _, err = a.db.ExecContext(ctx, query, from); err != nil {
+			err = fmt.Errorf("%s rollup: %w", period, err)
+			break
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastRun = start
+	a.runs++
+	if err != nil {
+		a.lastError = err.Error()
+		return err
+	}
+	a.lastError = ""
+	a.aggregated = start
+	return nil
+}
+
+// Status returns the aggregator state for status endpoints
+func (a *Aggregator) Status() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := map[string]interface{}{
+		"interval": a.interval.String(),
+		"runs":     a.runs,
+	}
+	if !a.lastRun.IsZero() {
+		status["last_run"] = a.lastRun
+	}
+	if !a.aggregated.IsZero() {
+		status["aggregated_until"] = a.aggregated
+	}
+	if a.lastError != "" {
+		status["last_error"] = a.lastError
+	}
+	if a.leader != nil {
+		status["leader"] = a.leader()
+	}
+	return status
+}
@@ -0,0 +1,171 @@
+package usage
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+)
+
+// dimensions maps group_by values to usage table columns
+var dimensions = map[string]string{
+	"key":      "api_key_id",
+	"model":    "model",
+	"provider": "provider",
+}
+
+// Row is the usage of one group in one period
+type Row struct {
+	PeriodStart      time.Time `json:"period_start"`
+	APIKeyID         *string   `json:"api_key_id,omitempty"`
+	Model            *string   `json:"model,omitempty"`
+	Provider         *string   `json:"provider,omitempty"`
+	Requests         int64     `json:"requests"`
+	Errors           int64     `json:"errors"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// Register mounts the usage report and aggregator state on the admin server
+func (a *Aggregator) Register(server *admin.Server) {
+	server.HandleFunc("/admin/usage", a.usageHandler)
+	server.AddStatus("usage", func() interface{} { return a.Status() })
+}
+
+// usageHandler reports aggregated usage, e.g.
+// GET /admin/usage?group_by=key,model&period=day&start=2025-01-01&end=2025-02-01&key=key_ab12...
+// Start and end accept RFC 3339 times or dates and default to the last 7 days,
+// or the last 24 hours for hourly periods.
+func (a *Aggregator) usageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+
+	period := Period(query.Get("period"))
+	switch period {
+	case "":
+		period = Day
+	case Hour, Day:
+	default:
+		admin.WriteError(w, http.StatusBadRequest, "period must be hour or day")
+		return
+	}
+
+	groupBy := []string{"model"}
+	if value := query.Get("group_by"); value != "" {
+		groupBy = strings.Split(value, ",")
+	}
+	columns := make([]string, 0, len(groupBy))
+	for _, group := range groupBy {
+		column, ok := dimensions[strings.TrimSpace(group)]
+		if !ok {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("cannot group by %q, use key, model or provider", group))
+			return
+		}
+		columns = append(columns, column)
+	}
+
+	end := time.Now().UTC()
+	start := end.Add(-7 * 24 * time.Hour)
+	if period == Hour {
+		start = end.Add(-24 * time.Hour)
+	}
+	var err error
+	if value := query.Get("start"); value != "" {
+		if start, err = parseTime(value); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, "invalid start: "+err.Error())
+			return
+		}
+	}
+	if value := query.Get("end"); value != "" {
+		if end, err = parseTime(value); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, "invalid end: "+err.Error())
+			return
+		}
+	}
+
+	// Columns come from the dimensions whitelist, so they are safe to interpolate
+	stmt := "SELECT period_start"
+	for _, column := range columns {
+		stmt += ", " + column
+	}
+	stmt += fmt.Sprintf(`, SUM(requests), SUM(errors), SUM(prompt_tokens), SUM(completion_tokens), SUM(cost)
+		FROM %s WHERE period_start >= $1 AND period_start < $2`, period.table())
+	args := []interface{}{start.Truncate(period.length()), end}
+	if key := query.Get("key"); key != "" {
+		stmt += " AND api_key_id = $3"
+		args = append(args, key)
+	}
+	group := strings.Join(append([]string{"period_start"}, columns...), ", ")
+	stmt += " GROUP BY " + group + " ORDER BY " + group
+
+	rows, err := a.db.QueryContext(r.Context(), stmt, args...)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	report := []Row{}
+	var totals Row
+	for rows.Next() {
+		var row Row
+		dest := []interface{}{&row.PeriodStart}
+		for _, column := range columns {
+			value := new(string)
+			switch column {
+			case "api_key_id":
+				row.APIKeyID = value
+			case "model":
+				row.Model = value
+			case "provider":
+				row.Provider = value
+			}
+			dest = append(dest, value)
+		}
+		dest = append(dest, &row.Requests, &row.Errors, &row.PromptTokens, &row.CompletionTokens, &row.Cost)
+		if err := rows.Scan(dest...); err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		totals.Requests += row.Requests
+		totals.Errors += row.Errors
+		totals.PromptTokens += row.PromptTokens
+		totals.CompletionTokens += row.CompletionTokens
+		totals.Cost += row.Cost
+		report = append(report, row)
+	}
+	if err := rows.Err(); err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"period":   period,
+		"group_by": groupBy,
+		"start":    start,
+		"end":      end,
+		"rows":     report,
+		"totals": map[string]interface{}{
+			"requests":          totals.Requests,
+			"errors":            totals.Errors,
+			"prompt_tokens":     totals.PromptTokens,
+			"completion_tokens": totals.CompletionTokens,
+			"cost":              totals.Cost,
+		},
+	})
+}
+
+// parseTime accepts an RFC 3339 time or a date
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -0,0 +1,125 @@
+package userlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/ratelimit"
+)
+
+// defaultMaxBodySize is the largest body searched for a user unless configured otherwise
+const defaultMaxBodySize = 1 << 20
+
+// Limiter gives every end user behind an API key their own request rate, so
+// one user of a shared key can't use up its quota for everyone. Users are
+// named by the OpenAI "user" field of the request body; requests without one
+// share the limit of their API key.
+type Limiter struct {
+	limiter     *ratelimit.Keyed
+	endpoints   guardrails.Applicability
+	maxBodySize int64
+	retryAfter  string
+}
+
+// New creates a per-user limiter from configuration
+func New(cfg config.UserLimitConfig) (*Limiter, error) {
+	if cfg.RequestsPerMinute <= 0 {
+		return nil, fmt.Errorf("requests_per_minute must be positive")
+	}
+	if cfg.Burst < 0 || cfg.MaxBodySize < 0 {
+		return nil, fmt.Errorf("burst and max_body_size must not be negative")
+	}
+	l := &Limiter{
+		limiter:     ratelimit.NewKeyed(cfg.RequestsPerMinute, cfg.Burst),
+		endpoints:   guardrails.Applicability{Endpoints: cfg.Endpoints},
+		maxBodySize: cfg.MaxBodySize,
+		// Time for one request's worth of the rate to refill
+		retryAfter: strconv.Itoa(int(math.Ceil(60 / float64(cfg.RequestsPerMinute)))),
+	}
+	if l.maxBodySize == 0 {
+		l.maxBodySize = defaultMaxBodySize
+	}
+	return l, nil
+}
+
+// Middleware rejects requests whose user, or API key when they name no user,
+// is over the limit with a 429
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.endpoints.IsEmpty() && !l.endpoints.Matches(guardrails.Scope{Endpoint: r.URL.Path}) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keyID := middleware.APIKeyID(r)
+		user, err := l.user(r)
+		if err != nil {
+			log.Printf("[RATELIMIT] Error reading request body: %v", err)
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+
+		// Users are only distinct within a key; two applications may both have an "alice"
+		bucket := keyID
+		if user != "" {
+			bucket = keyID + "\x00" + user
+		}
+		if l.limiter.Allow(bucket) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limited := map[string]interface{}{"api_key_id": keyID}
+		if user != "" {
+			limited["user"] = user
+		}
+		middleware.AddLogMetadata(r.Context(), "user_rate_limited", limited)
+		w.Header().Set("Retry-After", l.retryAfter)
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	})
+}
+
+// user returns the "user" field of a JSON request body. Bodies of unknown
+// length or over the size limit aren't read, and are limited by API key.
+func (l *Limiter) user(r *http.Request) (string, error) {
+	if r.Method != http.MethodPost || r.ContentLength <= 0 || r.ContentLength > l.maxBodySize ||
+		!middleware.IsTextContent(r.Header.Get("Content-Type")) {
+		return "", nil
+	}
+	body, err := middleware.ReadBody(r, l.maxBodySize)
+	if err != nil {
+		return "", err
+	}
+	if middleware.RequestBodyFromContext(r.Context()) == nil {
+		// Without capture the body isn't shared, so hand the handler a fresh copy
+		r.Body = io.NopCloser(strings.NewReader(body))
+	}
+
+	var payload struct {
+		User string `json:"user"`
+	}
+	if json.Unmarshal([]byte(body), &payload) != nil {
+		return "", nil
+	}
+	return strings.TrimSpace(payload.User), nil
+}
+
+// ShareLimits keeps users' rate limits in backend, so they hold across
+// gateway instances
+func (l *Limiter) ShareLimits(backend ratelimit.Backend) {
+	l.limiter.Share(backend, "user:")
+}
+
+// Status returns the limit, tracked users and rejections for status endpoints
+func (l *Limiter) Status() map[string]interface{} {
+	return l.limiter.Status()
+}
@@ -0,0 +1,180 @@
+package vkeys
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/audit"
+)
+
+// createRequest is the body of POST /admin/keys
+type createRequest struct {
+	Name      string     `json:"name"`
+	ExpiresIn string     `json:"expires_in"` // duration string, e.g. "720h"
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Register adds the key management routes to the admin API
+func (a *Authenticator) Register(server *admin.Server) {
+	server.AddStatus("virtual_keys", func() interface{} { return a.Status() })
+	server.HandleFunc("/admin/keys", a.adminHandler)
+	server.HandleFunc("/admin/keys/", a.adminHandler)
+}
+
+// adminHandler lists keys on GET /admin/keys and creates one on POST,
+// returning the key once. On /admin/keys/{id}, GET shows a key and DELETE
+// revokes it.
+func (a *Authenticator) adminHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		keys, err := a.list(r)
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+
+	case id == "" && r.Method == http.MethodPost:
+		a.create(w, r)
+
+	case id != "" && r.Method == http.MethodGet:
+		key, err := a.lookup(r.Context(), id)
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if key == nil {
+			admin.WriteError(w, http.StatusNotFound, fmt.Sprintf("no key %s", id))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, a.describe(key, time.Now()))
+
+	case id != "" && r.Method == http.MethodDelete:
+		if _, ok := a.static[id]; ok {
+			admin.WriteError(w, http.StatusConflict, fmt.Sprintf("key %s is defined in the config file; add it to auth.virtual_keys.revoked", id))
+			return
+		}
+		if a.store == nil {
+			admin.WriteError(w, http.StatusNotFound, fmt.Sprintf("no key %s", id))
+			return
+		}
+		found, err := a.store.Revoke(r.Context(), id, time.Now().UTC())
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !found {
+			admin.WriteError(w, http.StatusNotFound, fmt.Sprintf("no key %s", id))
+			return
+		}
+		a.forget(id)
+		audit.Record(r.Context(), "key.revoked", id, nil, nil)
+		key, err := a.lookup(r.Context(), id)
+		if err != nil || key == nil {
+			admin.WriteJSON(w, http.StatusOK, map[string]interface{}{"id": id, "status": "revoked"})
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, a.describe(key, time.Now()))
+
+	default:
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// create issues a key from a POST /admin/keys request
+func (a *Authenticator) create(w http.ResponseWriter, r *http.Request) {
+	if a.store == nil {
+		admin.WriteError(w, http.StatusConflict, "no key store is configured")
+		return
+	}
+
+	var req createRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		if expiresAt != nil {
+			admin.WriteError(w, http.StatusBadRequest, "set expires_in or expires_at, not both")
+			return
+		}
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid expires_in %q", req.ExpiresIn))
+			return
+		}
+		at := time.Now().Add(ttl).UTC()
+		expiresAt = &at
+	}
+
+	plaintext, key, err := Generate(req.Name, expiresAt)
+	if err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	key.Source = "store"
+	if err := a.store.Create(r.Context(), key); err != nil {
+		admin.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	audit.Record(r.Context(), "key.created", key.ID, nil, a.describe(key, time.Now()))
+	response := a.describe(key, time.Now())
+	response["key"] = plaintext // Shown this once; only the hash is kept
+	admin.WriteJSON(w, http.StatusCreated, response)
+}
+
+// list returns every key in the config file and the store
+func (a *Authenticator) list(r *http.Request) ([]map[string]interface{}, error) {
+	keys := make([]*Key, 0, len(a.static))
+	for _, key := range a.static {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	if a.store != nil {
+		stored, err := a.store.List(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, stored...)
+	}
+
+	now := time.Now()
+	described := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		described = append(described, a.describe(key, now))
+	}
+	return described, nil
+}
+
+// describe renders a key without its hash
+func (a *Authenticator) describe(key *Key, now time.Time) map[string]interface{} {
+	status := key.Status(now)
+	if a.revoked[key.ID] {
+		status = "revoked"
+	}
+	described := map[string]interface{}{
+		"id":     key.ID,
+		"name":   key.Name,
+		"source": key.Source,
+		"status": status,
+	}
+	if !key.CreatedAt.IsZero() {
+		described["created_at"] = key.CreatedAt
+	}
+	if key.ExpiresAt != nil {
+		described["expires_at"] = key.ExpiresAt
+	}
+	if key.RevokedAt != nil {
+		described["revoked_at"] = key.RevokedAt
+	}
+	return described
+}
@@ -0,0 +1,161 @@
+package vkeys
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store keeps virtual keys created on the admin API
+type Store interface {
+	// Get returns a key by ID, or nil when there is none
+	Get(ctx context.Context, id string) (*Key, error)
+	Create(ctx context.Context, key *Key) error
+	// Revoke marks a key revoked, reporting false when there is no such key
+	Revoke(ctx context.Context, id string, at time.Time) (bool, error)
+	List(ctx context.Context) ([]*Key, error)
+}
+
+// MemoryStore keeps keys for the life of the process, for gateways without
+// PostgreSQL storage
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]*Key
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]*Key)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[id]; ok {
+		copied := *key
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key.ID]; ok {
+		return fmt.Errorf("key %s already exists", key.ID)
+	}
+	copied := *key
+	s.keys[key.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, id string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// PostgresStore keeps keys in the virtual_keys table
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a store on the gateway's database
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (*Key, error) {
+	key, err := scanKey(s.db.QueryRowContext(ctx, `
+		SELECT id, name, salt, hash, created_at, expires_at, revoked_at
+		FROM virtual_keys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get virtual key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, key *Key) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO virtual_keys (id, name, salt, hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, key.Name, key.Salt, key.Hash, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual key: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Revoke(ctx context.Context, id string, at time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE virtual_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`, id, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke virtual key: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke virtual key: %w", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]*Key, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, salt, hash, created_at, expires_at, revoked_at
+		FROM virtual_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*Key
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list virtual keys: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// scanKey reads a virtual_keys row
+func scanKey(row interface{ Scan(...interface{}) error }) (*Key, error) {
+	key := &Key{Source: "store"}
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Salt, &key.Hash, &key.CreatedAt, &expiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,317 @@
+// Package vkeys authenticates clients with virtual keys: API keys the
+// gateway issues itself, so clients never hold provider keys. Keys are stored
+// only as salted hashes and found by the ID embedded in them, so a lookup
+// never needs the plaintext key.
+package vkeys
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/jwtauth"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+)
+
+// Prefix starts every virtual key: fgw_<id>_<secret>
+const Prefix = "fgw_"
+
+// keyContextKey is the context key under which a verified key is stored
+const keyContextKey = "virtual_key"
+
+// Errors returned by Verify
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpiredKey = errors.New("API key has expired")
+	ErrRevokedKey = errors.New("API key has been revoked")
+)
+
+// Key is a virtual key's stored record. The key itself is never kept.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Source    string     `json:"source"` // "config" or "store"
+
+	Salt string `json:"-"` // Hex
+	Hash string `json:"-"` // Hex HMAC-SHA256 of the key, keyed by the salt
+}
+
+// Generate creates a key, returning the plaintext to hand to the client once
+// and the record to store
+func Generate(name string, expiresAt *time.Time) (string, *Key, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	salt := make([]byte, 16)
+	for _, b := range [][]byte{id, secret, salt} {
+		if _, err := rand.Read(b); err != nil {
+			return "", nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+
+	key := &Key{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+		Salt:      hex.EncodeToString(salt),
+	}
+	plaintext := Prefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashKey(salt, plaintext)
+	return plaintext, key, nil
+}
+
+// ConfigHash returns the key's salt and hash as written in the config file
+func (k *Key) ConfigHash() string {
+	return k.Salt + ":" + k.Hash
+}
+
+// Status describes whether the key is usable
+func (k *Key) Status(now time.Time) string {
+	switch {
+	case k.RevokedAt != nil:
+		return "revoked"
+	case k.ExpiresAt != nil && now.After(*k.ExpiresAt):
+		return "expired"
+	}
+	return "active"
+}
+
+// matches reports whether plaintext is this key. Keys are long and random,
+// so a single salted HMAC is enough; a slow password hash would only slow
+// every request down.
+func (k *Key) matches(plaintext string) bool {
+	salt, err := hex.DecodeString(k.Salt)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(hashKey(salt, plaintext)), []byte(k.Hash))
+}
+
+func hashKey(salt []byte, plaintext string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseID returns the ID embedded in a virtual key
+func parseID(plaintext string) (string, bool) {
+	rest := strings.TrimPrefix(plaintext, Prefix)
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" || len(rest) == len(plaintext) {
+		return "", false
+	}
+	return id, true
+}
+
+// cachedKey is a key read from the store and when it was read
+type cachedKey struct {
+	key     *Key
+	fetched time.Time
+}
+
+// Authenticator verifies virtual keys against those in the config file and
+// the store
+type Authenticator struct {
+	static   map[string]*Key
+	revoked  map[string]bool
+	store    Store
+	required bool
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// New creates an authenticator from configuration. store holds keys created
+// on the admin API and may be nil, leaving only the config file's keys.
+func New(cfg config.VirtualKeysConfig, store Store) (*Authenticator, error) {
+	a := &Authenticator{
+		static:   make(map[string]*Key, len(cfg.Keys)),
+		revoked:  make(map[string]bool, len(cfg.Revoked)),
+		store:    store,
+		required: cfg.Required,
+		cacheTTL: 30 * time.Second,
+		cache:    make(map[string]cachedKey),
+	}
+	if cfg.CacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid cache_ttl %q", cfg.CacheTTL)
+		}
+		a.cacheTTL = ttl
+	}
+
+	for i, kc := range cfg.Keys {
+		if kc.ID == "" {
+			return nil, fmt.Errorf("key %d: id is required", i+1)
+		}
+		if a.static[kc.ID] != nil {
+			return nil, fmt.Errorf("duplicate key id %s", kc.ID)
+		}
+		salt, hash, ok := strings.Cut(kc.Hash, ":")
+		if _, err := hex.DecodeString(salt); !ok || err != nil || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("key %s: hash must be salt:hash as printed by -new-virtual-key", kc.ID)
+		}
+		key := &Key{ID: kc.ID, Name: kc.Name, Salt: salt, Hash: hash, Source: "config"}
+		if kc.ExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, kc.ExpiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: invalid expires_at: %w", kc.ID, err)
+			}
+			key.ExpiresAt = &expiresAt
+		}
+		a.static[kc.ID] = key
+	}
+	for _, id := range cfg.Revoked {
+		a.revoked[id] = true
+	}
+	return a, nil
+}
+
+// Verify returns the record of a valid, unexpired and unrevoked key. The key
+// must match before its expiry or revocation is reported, so the state of a
+// key ID can't be probed without the key.
+func (a *Authenticator) Verify(ctx context.Context, plaintext string) (*Key, error) {
+	id, ok := parseID(plaintext)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	key, err := a.lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || !key.matches(plaintext) {
+		return nil, ErrInvalidKey
+	}
+	if a.revoked[id] || key.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+	return key, nil
+}
+
+// lookup finds a key by ID in the config file, the cache or the store
+func (a *Authenticator) lookup(ctx context.Context, id string) (*Key, error) {
+	if key, ok := a.static[id]; ok {
+		return key, nil
+	}
+	if a.store == nil {
+		return nil, nil
+	}
+
+	a.mu.Lock()
+	cached, ok := a.cache[id]
+	a.mu.Unlock()
+	if ok && time.Since(cached.fetched) < a.cacheTTL {
+		return cached.key, nil
+	}
+
+	key, err := a.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		a.mu.Lock()
+		a.cache[id] = cachedKey{key: key, fetched: time.Now()}
+		a.mu.Unlock()
+	}
+	return key, nil
+}
+
+// forget drops a key from the cache after it changes
+func (a *Authenticator) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.cache, id)
+}
+
+// Middleware verifies virtual keys sent as a bearer token or in x-api-key.
+// Other requests pass through untouched unless virtual keys are required, in
+// which case only JWT callers may go without one.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := keyFromRequest(r)
+		if !strings.HasPrefix(token, Prefix) {
+			if a.required && jwtauth.FromContext(r.Context()) == nil {
+				http.Error(w, "A virtual API key is required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := a.Verify(r.Context(), token)
+		switch {
+		case errors.Is(err, ErrInvalidKey), errors.Is(err, ErrExpiredKey), errors.Is(err, ErrRevokedKey):
+			if id, ok := parseID(token); ok {
+				log.Printf("[AUTH] Rejected virtual key %s for %s %s: %v", id, r.Method, r.URL.Path, err)
+			}
+			http.Error(w, capitalize(err.Error()), http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Printf("[AUTH] Failed to verify virtual key: %v", err)
+			http.Error(w, "Could not verify API key", http.StatusServiceUnavailable)
+			return
+		}
+
+		middleware.AddLogMetadata(r.Context(), "virtual_key", key.ID)
+		next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key)))
+	})
+}
+
+// keyFromRequest returns the client's API key from either header
+func keyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("x-api-key")
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Status returns the authenticator's settings for status endpoints
+func (a *Authenticator) Status() map[string]interface{} {
+	a.mu.Lock()
+	cached := len(a.cache)
+	a.mu.Unlock()
+	return map[string]interface{}{
+		"required":    a.required,
+		"config_keys": len(a.static),
+		"revoked":     len(a.revoked),
+		"store":       a.store != nil,
+		"cached_keys": cached,
+		"cache_ttl":   a.cacheTTL.String(),
+	}
+}
+
+// WithKey attaches a verified key to a request context
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyContextKey, key)
+}
+
+// FromContext returns the request's virtual key, or nil when it has none
+func FromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyContextKey).(*Key)
+	return key
+}
@@ -0,0 +1,188 @@
+// Package websocket reads and writes WebSocket frames (RFC 6455), which is
+// all the gateway needs to relay a connection message by message and look at
+// what each message carries.
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Frame opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005 // Reported, never sent, for a close frame without a code
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// maxControlPayload is the largest payload a control frame may carry
+const maxControlPayload = 125
+
+// ErrTooLarge is returned when a frame or message is larger than allowed
+var ErrTooLarge = errors.New("websocket message too large")
+
+// Frame is a single WebSocket frame with its payload unmasked
+type Frame struct {
+	Fin     bool
+	RSV     byte // Reserved bits, only set by extensions
+	Opcode  byte
+	Payload []byte
+}
+
+// IsControl reports whether the frame is a close, ping or pong
+func (f *Frame) IsControl() bool {
+	return f.Opcode&0x8 != 0
+}
+
+// IsUpgrade reports whether a request asks to switch to the WebSocket protocol
+func IsUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadFrame reads one frame, unmasking its payload. Frames with a payload
+// longer than limit are refused with ErrTooLarge.
+func ReadFrame(r io.Reader, limit int64) (*Frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	f := &Frame{
+		Fin:    header[0]&0x80 != 0,
+		RSV:    header[0] & 0x70,
+		Opcode: header[0] & 0x0F,
+	}
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if f.IsControl() && (length > maxControlPayload || !f.Fin) {
+		return nil, fmt.Errorf("invalid control frame")
+	}
+	if length > uint64(limit) {
+		return nil, ErrTooLarge
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		maskBytes(key, f.Payload)
+	}
+	return f, nil
+}
+
+// WriteFrame writes a frame in a single write. Clients must mask what they
+// send, so the gateway masks frames it relays upstream.
+func WriteFrame(w io.Writer, f *Frame, mask bool) error {
+	length := len(f.Payload)
+	buf := make([]byte, 0, 14+length)
+
+	first := f.RSV | f.Opcode
+	if f.Fin {
+		first |= 0x80
+	}
+	buf = append(buf, first)
+
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
+	}
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if !mask {
+		buf = append(buf, f.Payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, f.Payload...)
+		maskBytes(key, buf[start:])
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// CloseFrame builds a close frame with a status code and reason
+func CloseFrame(code int, reason string) *Frame {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return &Frame{Fin: true, Opcode: OpClose, Payload: append(payload, reason...)}
+}
+
+// CloseCode returns the status code in a close frame's payload
+func CloseCode(payload []byte) int {
+	if len(payload) < 2 {
+		return CloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+// maskBytes applies (or removes) a frame's masking key
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
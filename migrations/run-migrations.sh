@@ -49,15 +49,21 @@ TABLE_EXISTS=$($PSQL_CMD -t -c "SELECT EXISTS (
 );" 2>/dev/null | tr -d ' ')
 
 if [ "$TABLE_EXISTS" = "t" ]; then
-    echo "✅ Database schema already exists, skipping migration"
-    exit 0
-fi
+    echo "✅ Database schema already exists, skipping base schema"
+else
+    echo "📦 Running database migrations..."
 
-echo "📦 Running database migrations..."
+    # Run the combined schema migration
+    if ! $PSQL_CMD -f /root/migrations/schema.sql; then
+        echo "❌ Migration failed"
+        exit 1
+    fi
+fi
 
-# Run the combined schema migration
-if ! $PSQL_CMD -f /root/migrations/schema.sql; then
-    echo "❌ Migration failed"
+# Upgrades are idempotent, so they bring new and existing databases up to date
+echo "📦 Applying schema upgrades..."
+if ! $PSQL_CMD -v ON_ERROR_STOP=1 -f /root/migrations/upgrades.sql > /dev/null; then
+    echo "❌ Schema upgrade failed"
     exit 1
 fi
 
@@ -0,0 +1,98 @@
+-- Flash Gateway schema upgrades
+-- Applied after schema.sql on every start. Every statement must be idempotent,
+-- so fresh databases and ones created by an older schema.sql end up the same.
+
+-- Conversation threading for request logs
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS conversation_id VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_request_logs_conversation ON request_logs(conversation_id, timestamp) WHERE conversation_id IS NOT NULL;
+
+-- Usage rolled up from request logs per API key, model and provider.
+-- Empty strings stand in for unknown values so they can be part of the key.
+CREATE TABLE IF NOT EXISTS usage_hourly (
+    period_start TIMESTAMPTZ NOT NULL,
+    api_key_id VARCHAR(64) NOT NULL DEFAULT '',
+    model VARCHAR(255) NOT NULL DEFAULT '',
+    provider VARCHAR(50) NOT NULL DEFAULT '',
+    requests BIGINT NOT NULL DEFAULT 0,
+    errors BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    cost NUMERIC(20, 8) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (period_start, api_key_id, model, provider)
+);
+
+CREATE TABLE IF NOT EXISTS usage_daily (LIKE usage_hourly INCLUDING ALL);
+
+-- Tenant partitioning for request logs
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100);
+CREATE INDEX IF NOT EXISTS idx_request_logs_tenant ON request_logs(tenant_id, timestamp) WHERE tenant_id IS NOT NULL;
+
+-- Full-text search over logged bodies. The expression must match the one in
+-- storage's log filter for the index to be used; without it, search falls back to ILIKE.
+CREATE INDEX IF NOT EXISTS idx_request_logs_body_search ON request_logs
+    USING GIN (to_tsvector('simple', COALESCE(request_body, '') || ' ' || COALESCE(response_body, '')));
+
+-- Client feedback on responses, one row per request. Not a foreign key to
+-- request_logs: feedback can arrive before the async log writer stores the log.
+CREATE TABLE IF NOT EXISTS request_feedback (
+    request_id UUID PRIMARY KEY,
+    rating SMALLINT CHECK (rating IN (-1, 1)),
+    score DOUBLE PRECISION,
+    comment TEXT,
+    metadata JSONB,
+    api_key_id VARCHAR(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_request_feedback_updated_at ON request_feedback(updated_at);
+
+-- Virtual keys issued on the admin API. Only a salted hash of each key is
+-- stored; the ID embedded in the key finds its row.
+CREATE TABLE IF NOT EXISTS virtual_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    salt VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+-- Admin API actions: who did what, when, and what changed. Rows are never
+-- changed or removed; the triggers below reject updates, deletes and truncation.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor VARCHAR(255) NOT NULL,
+    auth_method VARCHAR(20),
+    role VARCHAR(20),
+    action VARCHAR(255) NOT NULL,
+    target VARCHAR(255),
+    method VARCHAR(10),
+    path TEXT,
+    status INTEGER,
+    remote_addr VARCHAR(255),
+    diff JSONB,
+    details JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_time ON admin_audit_log(time);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_actor ON admin_audit_log(actor, time);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_action ON admin_audit_log(action, time);
+
+CREATE OR REPLACE FUNCTION admin_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS admin_audit_log_append_only ON admin_audit_log;
+CREATE TRIGGER admin_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON admin_audit_log
+    FOR EACH ROW EXECUTE FUNCTION admin_audit_log_append_only();
+
+DROP TRIGGER IF EXISTS admin_audit_log_no_truncate ON admin_audit_log;
+CREATE TRIGGER admin_audit_log_no_truncate
+    BEFORE TRUNCATE ON admin_audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION admin_audit_log_append_only();
@@ -0,0 +1,171 @@
+// Package client is a Go SDK for the gateway's admin API: request logs and
+// their statistics, usage reports and virtual key management.
+//
+//	c, err := client.New(client.Config{
+//		BaseURL: "http://gateway.internal:9090",
+//		Token:   os.Getenv("ADMIN_TOKEN"),
+//	})
+//	logs, err := c.Logs(ctx, client.LogFilter{Model: "gpt-4o", Hours: 1})
+//
+// Calls are authorized by the token's admin role like any other admin
+// client, and failures the gateway reports are returned as *APIError.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/usage"
+)
+
+// Types returned by the admin API, re-exported from the gateway's internal packages
+type (
+	RequestLog    = storage.RequestLog
+	LogStats      = storage.LogStats
+	EndpointStats = storage.EndpointStats
+	UsageRow      = usage.Row
+)
+
+// Config holds what a client needs to reach the admin listener
+type Config struct {
+	BaseURL    string       // Admin listener, e.g. "http://localhost:9090"
+	Token      string       // Admin token or OIDC token
+	HTTPClient *http.Client // Default: a client with a 30s timeout
+}
+
+// Client calls the gateway's admin API. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	token   string
+	http    *http.Client
+}
+
+// New creates a client for the admin listener at cfg.BaseURL
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.BaseURL)
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{baseURL: base, token: cfg.Token, http: httpClient}, nil
+}
+
+// APIError is an error response from the admin API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gateway admin API: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound reports whether err is the admin API answering 404
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Whoami returns the caller's name and admin role as the gateway sees them
+func (c *Client) Whoami(ctx context.Context) (*Principal, error) {
+	var principal Principal
+	if err := c.getJSON(ctx, "/admin/whoami", nil, &principal); err != nil {
+		return nil, err
+	}
+	return &principal, nil
+}
+
+// Principal is who an admin token belongs to
+type Principal struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`   // "read-only", "analyst" or "admin"
+	Method string `json:"method"` // "token" or "oidc"
+}
+
+// State decodes one section of /admin/state, such as "budgets" or
+// "virtual_keys", into v
+func (c *Client) State(ctx context.Context, section string, v interface{}) error {
+	return c.getJSON(ctx, "/admin/state/"+url.PathEscape(section), nil, v)
+}
+
+// getJSON sends a GET and decodes the JSON response into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.doJSON(ctx, http.MethodGet, path, query, nil, v)
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON response into v
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body, v interface{}) error {
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request and returns the response when it succeeded, and an
+// *APIError otherwise. The caller closes the body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	// Admin errors are {"error": status text, "message": details}
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if json.Unmarshal(data, &payload) == nil && payload.Message != "" {
+		apiErr.Message = payload.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return nil, apiErr
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Key is a virtual key as the admin API shows it, without its secret
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Source    string     `json:"source"` // "config" or "store"
+	Status    string     `json:"status"` // "active", "expired" or "revoked"
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedKey is a new virtual key along with its secret, which the gateway
+// returns this once and never stores
+type CreatedKey struct {
+	Key
+	Secret string `json:"key"`
+}
+
+// CreateKeyRequest describes a virtual key to issue. Set ExpiresIn or
+// ExpiresAt, or neither for a key that doesn't expire.
+type CreateKeyRequest struct {
+	Name      string     `json:"name"`
+	ExpiresIn string     `json:"expires_in,omitempty"` // Duration, e.g. "720h"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ListKeys returns the virtual keys in the config file and the key store
+func (c *Client) ListKeys(ctx context.Context) ([]Key, error) {
+	var response struct {
+		Keys []Key `json:"keys"`
+	}
+	if err := c.getJSON(ctx, "/admin/keys", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Keys, nil
+}
+
+// GetKey returns one virtual key; IsNotFound reports a missing one
+func (c *Client) GetKey(ctx context.Context, id string) (*Key, error) {
+	var key Key
+	if err := c.getJSON(ctx, "/admin/keys/"+url.PathEscape(id), nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateKey issues a virtual key in the key store
+func (c *Client) CreateKey(ctx context.Context, req CreateKeyRequest) (*CreatedKey, error) {
+	var key CreatedKey
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/keys", nil, req, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeKey revokes a stored virtual key. Keys from the config file are
+// revoked in the config instead, and return a 409 APIError.
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/keys/"+url.PathEscape(id), nil, nil, nil)
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Guardrail outcomes LogFilter.Guardrail can select
+const (
+	GuardrailPassed   = "passed"   // Neither blocked nor rewritten by a guardrail
+	GuardrailModified = "modified" // Response rewritten by an output guardrail
+	GuardrailBlocked  = "blocked"  // Refused by an input or output guardrail
+)
+
+// LogFilter selects request logs. Zero fields don't filter. Logs and Stats
+// look back Hours; ExportLogs reads between Start and End instead.
+type LogFilter struct {
+	Tenant    string
+	Endpoint  string
+	Provider  string
+	Model     string
+	Search    string // Words to find in request or response bodies
+	Guardrail string // GuardrailPassed, GuardrailModified or GuardrailBlocked
+	Rating    string // Client feedback: "up", "down" or "none"
+	MinScore  *float64
+	MinTokens *int64 // Prompt plus completion tokens
+	MaxTokens *int64
+	MinCost   *float64
+	MaxCost   *float64
+
+	Hours int // Logs and Stats: how far back to look (default 24)
+	Limit int // Logs: newest logs returned (default 200, at most 1000); ExportLogs: logs written
+
+	Start time.Time // ExportLogs: oldest log
+	End   time.Time // ExportLogs: newest log
+	After string    // ExportLogs: cursor of the last log already read
+
+	WithoutBodies bool // ExportLogs: leave out request and response bodies
+}
+
+// values encodes the filter as the admin API's query parameters
+func (f LogFilter) values() url.Values {
+	query := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	set("tenant", f.Tenant)
+	set("endpoint", f.Endpoint)
+	set("provider", f.Provider)
+	set("model", f.Model)
+	set("q", f.Search)
+	set("guardrail", f.Guardrail)
+	set("rating", f.Rating)
+	if f.MinScore != nil {
+		set("min_score", strconv.FormatFloat(*f.MinScore, 'f', -1, 64))
+	}
+	if f.MinTokens != nil {
+		set("min_tokens", strconv.FormatInt(*f.MinTokens, 10))
+	}
+	if f.MaxTokens != nil {
+		set("max_tokens", strconv.FormatInt(*f.MaxTokens, 10))
+	}
+	if f.MinCost != nil {
+		set("min_cost", strconv.FormatFloat(*f.MinCost, 'f', -1, 64))
+	}
+	if f.MaxCost != nil {
+		set("max_cost", strconv.FormatFloat(*f.MaxCost, 'f', -1, 64))
+	}
+	if f.Limit > 0 {
+		set("limit", strconv.Itoa(f.Limit))
+	}
+	return query
+}
+
+// Logs returns the newest request logs matching filter, without headers and
+// bodies. Needs the analyst role.
+func (c *Client) Logs(ctx context.Context, filter LogFilter) ([]*RequestLog, error) {
+	query := filter.values()
+	if filter.Hours > 0 {
+		query.Set("hours", strconv.Itoa(filter.Hours))
+	}
+	var logs []*RequestLog
+	if err := c.getJSON(ctx, "/dashboard/api/logs", query, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Stats returns request counts, latency and error rates over the logs
+// matching filter
+func (c *Client) Stats(ctx context.Context, filter LogFilter) (*LogStats, error) {
+	query := filter.values()
+	query.Del("limit")
+	if filter.Hours > 0 {
+		query.Set("hours", strconv.Itoa(filter.Hours))
+	}
+	var stats LogStats
+	if err := c.getJSON(ctx, "/dashboard/api/stats", query, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ExportLogs streams the logs matching filter, oldest first, to fn, which
+// may stop the export by returning an error. Storage is paged by cursor, so
+// exports of any size are fine; with filter.Limit set, the returned cursor
+// continues from the last log written when more remain. Needs the analyst role.
+func (c *Client) ExportLogs(ctx context.Context, filter LogFilter, fn func(*RequestLog) error) (next string, err error) {
+	query := filter.values()
+	query.Set("format", "ndjson")
+	if filter.WithoutBodies {
+		query.Set("bodies", "false")
+	}
+	if !filter.Start.IsZero() {
+		query.Set("start", filter.Start.UTC().Format(time.RFC3339))
+	}
+	if !filter.End.IsZero() {
+		query.Set("end", filter.End.UTC().Format(time.RFC3339))
+	}
+	if filter.After != "" {
+		query.Set("after", filter.After)
+	}
+
+	// Exports outlast the default client timeout; the context bounds them instead
+	httpClient := *c.http
+	httpClient.Timeout = 0
+	exporter := &Client{baseURL: c.baseURL, token: c.token, http: &httpClient}
+	resp, err := exporter.do(ctx, http.MethodGet, "/admin/logs/export", query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20) // Bodies make lines long
+	for scanner.Scan() {
+		var entry RequestLog
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("failed to decode exported log: %w", err)
+		}
+		if err := fn(&entry); err != nil {
+			return "", err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read export: %w", err)
+	}
+	// The cursor is a trailer, known once the body has been read
+	return resp.Trailer.Get("X-Next-Cursor"), nil
+}
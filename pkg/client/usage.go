@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Usage periods and groupings of UsageQuery
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+
+	GroupByKey      = "key"
+	GroupByModel    = "model"
+	GroupByProvider = "provider"
+)
+
+// UsageQuery selects a usage report. Usage is rolled up from request logs by
+// the gateway's usage aggregator, which needs PostgreSQL storage.
+type UsageQuery struct {
+	Period  string    // PeriodHour or PeriodDay (default)
+	GroupBy []string  // GroupByKey, GroupByModel and/or GroupByProvider (default: model)
+	Start   time.Time // Default: 7 days ago, or 24 hours for hourly periods
+	End     time.Time // Default: now
+	Key     string    // Only this API key fingerprint, e.g. "key_3f9a1c0b7e2d4a68"
+}
+
+// UsageReport is usage per group and period, with totals across them
+type UsageReport struct {
+	Period  string      `json:"period"`
+	GroupBy []string    `json:"group_by"`
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Rows    []UsageRow  `json:"rows"`
+	Totals  UsageTotals `json:"totals"`
+}
+
+// UsageTotals sums a usage report
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Usage returns requests, errors, tokens and cost per group and period
+func (c *Client) Usage(ctx context.Context, q UsageQuery) (*UsageReport, error) {
+	query := url.Values{}
+	if q.Period != "" {
+		query.Set("period", q.Period)
+	}
+	if len(q.GroupBy) > 0 {
+		query.Set("group_by", strings.Join(q.GroupBy, ","))
+	}
+	if !q.Start.IsZero() {
+		query.Set("start", q.Start.UTC().Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		query.Set("end", q.End.UTC().Format(time.RFC3339))
+	}
+	if q.Key != "" {
+		query.Set("key", q.Key)
+	}
+	var report UsageReport
+	if err := c.getJSON(ctx, "/admin/usage", query, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,478 @@
+// Package gateway runs the gateway inside another Go program. It does what
+// the server binary does: New builds storage, guardrails, routing and the
+// admin API from a config, Start serves them and Stop shuts them down.
+//
+//	cfg, err := gateway.LoadConfig("configs/providers.yaml")
+//	gateway.RegisterGuardrail("acme_pii", newPIIGuardrail)
+//	gw, err := gateway.New(cfg)
+//	if err := gw.Start(); err != nil { ... }
+//	defer gw.Stop(ctx)
+//
+// Guardrail types and providers registered before New can be used by the
+// config like built-in ones. To serve the gateway from an existing HTTP
+// server instead of its own listener, set Server.Port to "" and mount Handler.
+// Registries are shared by the process, so run one gateway at a time.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/admin"
+	"github.com/NamanArora/flash-gateway/internal/batch"
+	"github.com/NamanArora/flash-gateway/internal/brownout"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/conversation"
+	"github.com/NamanArora/flash-gateway/internal/dashboard"
+	"github.com/NamanArora/flash-gateway/internal/feedback"
+	"github.com/NamanArora/flash-gateway/internal/grpc"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/leader"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+	"github.com/NamanArora/flash-gateway/internal/replay"
+	"github.com/NamanArora/flash-gateway/internal/router"
+	"github.com/NamanArora/flash-gateway/internal/secrets"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/usage"
+	"github.com/NamanArora/flash-gateway/pkg/plugins"
+)
+
+// Types embedders configure and extend the gateway with, re-exported from
+// its internal packages
+type (
+	Config              = config.Config
+	GuardrailConfig     = config.GuardrailConfig
+	Guardrail           = guardrails.Guardrail
+	StructuredGuardrail = guardrails.StructuredGuardrail
+	ImageGuardrail      = guardrails.ImageGuardrail
+	GuardrailFactory    = guardrails.GuardrailFactory
+	GuardrailInput      = guardrails.GuardrailInput
+	GuardrailResult     = guardrails.Result
+	Image               = guardrails.Image
+	Provider            = providers.Provider
+	ProviderFactory     = providers.Factory
+	ProviderConfig      = config.ProviderConfig
+	Middleware          = func(http.Handler) http.Handler
+)
+
+// LoadConfig reads a config file over the defaults. An empty path returns
+// the defaults alone, to be filled in from code.
+func LoadConfig(path string) (*Config, error) {
+	return config.LoadConfig(path)
+}
+
+// RegisterGuardrail makes a guardrail type available to guardrail configs.
+// Call it before New.
+func RegisterGuardrail(guardrailType string, factory GuardrailFactory) {
+	guardrails.Register(guardrailType, factory)
+}
+
+// RegisterProvider makes a provider implementation available to providers
+// configured with its type. Call it before New.
+func RegisterProvider(providerType string, factory ProviderFactory) {
+	providers.Register(providerType, factory)
+}
+
+// RegisterMiddleware adds a middleware to every proxied request. Call it
+// before New.
+func RegisterMiddleware(name string, m Middleware) {
+	middleware.Register(name, m)
+}
+
+// Gateway is a configured gateway and the background services it runs
+type Gateway struct {
+	cfg     *Config
+	handler http.Handler
+
+	secrets    *secrets.Manager
+	logWriter  *storage.AsyncLogWriter
+	usage      *usage.Aggregator
+	elector    *leader.Elector
+	executor   *guardrails.Executor
+	router     *router.Router
+	batches    *batch.Manager
+	admin      *admin.Server
+	server     *http.Server
+	grpcServer *grpc.Server
+	feedback   *feedback.Store
+}
+
+// New builds a gateway from cfg without serving it. Secret references in
+// cfg are resolved in place.
+func New(cfg *Config) (*Gateway, error) {
+	g := &Gateway{cfg: cfg}
+	var err error
+
+	// Replace secret:// references with secrets from the environment, files
+	// and secret managers before anything reads the config
+	g.secrets, err = secrets.New(cfg.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup secrets: %w", err)
+	}
+	resolveCtx, cancelResolve := context.WithTimeout(context.Background(), time.Minute)
+	err = g.secrets.ResolveConfig(resolveCtx, cfg)
+	cancelResolve()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	// Let compiled-in plugins register guardrails, providers and middleware
+	// before anything that uses them is built
+	if err := plugins.Init(cfg.Plugins); err != nil {
+		return nil, fmt.Errorf("failed to initialize plugins: %w", err)
+	}
+
+	// Initialize storage backend
+	var storageBackend storage.StorageBackend
+	if cfg.Logging.Enabled {
+		storageBackend, err = setupStorage(cfg)
+		if err != nil {
+			if !cfg.Logging.SkipOnError {
+				return nil, fmt.Errorf("failed to setup storage: %w", err)
+			}
+			log.Printf("Warning: Failed to setup storage, logging disabled: %v", err)
+			storageBackend = nil
+		} else {
+			log.Println("✅ Storage backend initialized successfully")
+		}
+	}
+
+	// From here on, a failure closes whatever was already built
+	built := false
+	defer func() {
+		if !built {
+			g.release(storageBackend)
+		}
+	}()
+
+	// Initialize async log writer
+	if storageBackend != nil {
+		if g.logWriter, err = setupLogWriter(cfg, storageBackend); err != nil {
+			return nil, err
+		}
+		log.Printf("✅ Async log writer initialized with %d workers", cfg.Logging.Workers)
+	}
+
+	// Roll request logs up into usage tables for billing and chargeback
+	if cfg.Usage.Enabled {
+		if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+			g.usage, err = usage.New(pgStorage.GetDB(), cfg.Usage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to setup usage aggregation: %w", err)
+			}
+		} else {
+			log.Println("Warning: Usage aggregation requires PostgreSQL storage, disabled")
+		}
+	}
+
+	// Elect one instance sharing the database to run cluster-wide jobs
+	if cfg.Leader.Enabled {
+		if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+			g.elector, err = leader.New(pgStorage.GetDB(), cfg.Leader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to setup leader election: %w", err)
+			}
+			if g.usage != nil {
+				g.usage.SetLeader(g.elector.IsLeader)
+			}
+		} else {
+			log.Println("Warning: Leader election requires PostgreSQL storage, disabled")
+		}
+	}
+
+	// Let clients rate responses by request ID
+	if cfg.Feedback.Enabled {
+		if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+			g.feedback = feedback.New(pgStorage.GetDB(), cfg.Feedback)
+		} else {
+			log.Println("Warning: Feedback requires PostgreSQL storage, disabled")
+		}
+	}
+
+	// Initialize guardrails system
+	if cfg.Guardrails.Enabled {
+		g.executor, err = setupGuardrails(cfg, storageBackend)
+		if err != nil {
+			log.Printf("Warning: Failed to setup guardrails: %v", err)
+		} else {
+			inputCount := len(cfg.Guardrails.InputGuardrails)
+			outputCount := len(cfg.Guardrails.OutputGuardrails)
+			log.Printf("✅ Guardrails system initialized (%d input, %d output)", inputCount, outputCount)
+		}
+	}
+
+	// Initialize router with logging
+	g.router = router.New(cfg, g.logWriter)
+	g.router.SetSecrets(g.secrets)
+	if cfg.Auth.VirtualKeys.Enabled {
+		g.router.SetKeyStore(setupKeyStore(storageBackend))
+	}
+	if err := g.router.Initialize(); err != nil {
+		return nil, fmt.Errorf("failed to initialize router: %w", err)
+	}
+	if g.executor != nil {
+		g.router.SetGuardrailExecutor(g.executor)
+	}
+	if g.feedback != nil {
+		g.router.SetFeedback(g.feedback)
+	}
+
+	// Run OpenAI-compatible batches through the same pipeline as live traffic
+	if cfg.Batches.Enabled {
+		g.batches, err = batch.New(cfg.Batches, g.router.InternalHandler())
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup batches: %w", err)
+		}
+		g.router.SetBatches(g.batches)
+	}
+
+	// Admin API on its own listener
+	if cfg.Admin.Enabled {
+		g.admin, err = admin.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup admin API: %w", err)
+		}
+		g.admin.SetAuditLog(setupAuditLog(storageBackend))
+		g.admin.SetSecretPaths(g.secrets.ResolvedPaths())
+		g.router.RegisterAdmin(g.admin)
+		g.admin.AddStatus("plugins", plugins.Status)
+		if storageBackend != nil {
+			dashboard.New(storageBackend).Register(g.admin)
+			conversation.NewAPI(storageBackend).Register(g.admin)
+			replay.New(storageBackend, g.router.InternalHandler(), g.router.Tenants()).Register(g.admin)
+		}
+		if g.usage != nil {
+			g.usage.Register(g.admin)
+		}
+		if g.elector != nil {
+			g.admin.AddStatus("leader", func() interface{} { return g.elector.Status() })
+		}
+		if g.feedback != nil {
+			g.feedback.Register(g.admin)
+		}
+	}
+
+	g.handler = g.router.Handler()
+	if cfg.Server.Port != "" {
+		g.server = &http.Server{
+			Addr:         cfg.Server.Port,
+			Handler:      g.handler,
+			ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+			IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+		}
+	}
+
+	// Serve chat completions over gRPC for internal services, through the same routes
+	if cfg.GRPC.Enabled {
+		g.grpcServer = grpc.New(cfg.GRPC, g.router.GRPCHandler())
+	}
+	built = true
+	return g, nil
+}
+
+// release closes what a failed New had built. Nothing is serving yet, so
+// only background workers and connections need stopping.
+func (g *Gateway) release(storageBackend storage.StorageBackend) {
+	if g.batches != nil {
+		g.batches.Close()
+	}
+	if g.router != nil {
+		g.router.Close()
+	}
+	if g.executor != nil {
+		if err := g.executor.Close(); err != nil {
+			log.Printf("Error closing guardrails: %v", err)
+		}
+	}
+	if g.logWriter != nil {
+		if err := g.logWriter.Close(); err != nil {
+			log.Printf("Error closing log writer: %v", err)
+		}
+	} else if storageBackend != nil {
+		if err := storageBackend.Close(); err != nil {
+			log.Printf("Error closing storage backend: %v", err)
+		}
+	}
+}
+
+// Handler returns the gateway's proxy handler with its whole middleware
+// chain, for mounting in another HTTP server
+func (g *Gateway) Handler() http.Handler {
+	return g.handler
+}
+
+// Start begins serving: the proxy listener, unless Server.Port is empty, the
+// admin and gRPC APIs, and background work such as secret refreshes and
+// plugins. It returns once the proxy port is bound. Plugins start first, so
+// if one fails nothing has been served yet.
+func (g *Gateway) Start() error {
+	if err := plugins.Start(context.Background()); err != nil {
+		return fmt.Errorf("failed to start plugins: %w", err)
+	}
+
+	var listener net.Listener
+	if g.server != nil {
+		var err error
+		listener, err = net.Listen("tcp", g.server.Addr)
+		if err != nil {
+			if stopErr := plugins.Stop(context.Background()); stopErr != nil {
+				log.Printf("Error stopping plugins: %v", stopErr)
+			}
+			return fmt.Errorf("failed to listen on %s: %w", g.server.Addr, err)
+		}
+	}
+
+	g.secrets.Start()
+	if g.elector != nil {
+		g.elector.Start()
+	}
+	if g.usage != nil {
+		g.usage.Start()
+		log.Println("✅ Usage aggregation started")
+	}
+	if g.admin != nil {
+		g.admin.Start()
+	}
+	if g.grpcServer != nil {
+		g.grpcServer.Start()
+	}
+
+	if listener != nil {
+		go func() {
+			if err := g.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Printf("Server failed: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// ToggleBrownout forces brownout on, or back to automatic when it already
+// is, reporting whether it is now forced on. A no-op without brownout enabled.
+func (g *Gateway) ToggleBrownout() bool {
+	controller := g.router.Brownout()
+	if controller == nil {
+		return false
+	}
+	if controller.Mode() == brownout.ModeOn {
+		controller.SetMode(brownout.ModeAuto)
+		return false
+	}
+	controller.SetMode(brownout.ModeOn)
+	return true
+}
+
+// Stop drains in-flight requests and shuts everything down, flushing logs
+// and metrics. ctx bounds how long requests may take to finish.
+func (g *Gateway) Stop(ctx context.Context) error {
+	var errs []error
+
+	// Stop taking proxy requests and let in-flight ones finish (a no-op wait
+	// if POST /admin/drain already ran)
+	if err := g.router.Drain(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+	}
+
+	// Shutdown HTTP server
+	if g.server != nil {
+		if err := g.server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("server shutdown: %w", err))
+		}
+	}
+
+	// Shutdown gRPC API
+	if g.grpcServer != nil {
+		if err := g.grpcServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("gRPC server shutdown: %w", err))
+		}
+	}
+
+	// Shutdown admin API
+	if g.admin != nil {
+		if err := g.admin.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("admin server shutdown: %w", err))
+		}
+	}
+
+	// Let batch requests in flight finish before closing transports and logs
+	if g.batches != nil {
+		g.batches.Close()
+	}
+
+	g.router.Close()
+	g.secrets.Stop()
+
+	if err := plugins.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("stopping plugins: %w", err))
+	}
+
+	// Write out any remaining guardrail metrics
+	if g.executor != nil {
+		if err := g.executor.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing guardrails: %w", err))
+		}
+	}
+
+	if g.usage != nil {
+		g.usage.Stop()
+	}
+
+	// Hand leadership over once this instance's jobs have stopped
+	if g.elector != nil {
+		g.elector.Stop()
+	}
+
+	// Shutdown logging system
+	if g.logWriter != nil {
+		log.Println("🔄 Shutting down logging system...")
+		if err := g.logWriter.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing log writer: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Endpoints describes the routes the proxy handler serves, one per line
+// (e.g. "POST /v1/chat/completions - openai API"), for banners and
+// diagnostics
+func (g *Gateway) Endpoints() []string {
+	cfg := g.cfg
+	endpoints := []string{
+		"GET  /health - Health check",
+		"GET  /status - Server status",
+	}
+	if g.LoggingEnabled() {
+		endpoints = append(endpoints, "GET  /metrics - Logging metrics")
+	}
+	if cfg.SLO.Enabled {
+		endpoints = append(endpoints, "GET  /metrics/prometheus - Latency and error SLO metrics")
+	}
+	if g.feedback != nil {
+		endpoints = append(endpoints, "POST "+feedback.Endpoint+" - Response feedback")
+	}
+	if g.batches != nil {
+		endpoints = append(endpoints, "POST "+batch.FilesPath+", "+batch.BatchesPath+" - Batch API")
+	}
+
+	for _, provider := range cfg.Providers {
+		for _, endpoint := range provider.Endpoints {
+			for _, method := range endpoint.Methods {
+				endpoints = append(endpoints, fmt.Sprintf("%s %s - %s API", method, endpoint.Path, provider.Name))
+			}
+		}
+	}
+	return endpoints
+}
+
+// LoggingEnabled reports whether requests are being logged to storage. It is
+// false when logging is configured but storage failed to come up.
+func (g *Gateway) LoggingEnabled() bool {
+	return g.cfg.Logging.Enabled && g.logWriter != nil
+}
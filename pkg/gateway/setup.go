@@ -0,0 +1,286 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NamanArora/flash-gateway/internal/audit"
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/grpc"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/examples"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/expr"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/language"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/onnx"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/openai"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/tokenlimit"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/topic"
+	"github.com/NamanArora/flash-gateway/internal/guardrails/vision"
+	"github.com/NamanArora/flash-gateway/internal/storage"
+	"github.com/NamanArora/flash-gateway/internal/vkeys"
+)
+
+// setupStorage initializes the storage backend based on configuration
+func setupStorage(cfg *config.Config) (storage.StorageBackend, error) {
+	switch cfg.Storage.Type {
+	case "postgres":
+		return setupPostgreSQL(cfg)
+	case "memory":
+		return setupMemory(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
+	}
+}
+
+// setupLogWriter batches request logs into the storage backend in the background
+func setupLogWriter(cfg *config.Config, storageBackend storage.StorageBackend) (*storage.AsyncLogWriter, error) {
+	flushInterval, err := time.ParseDuration(cfg.Logging.FlushInterval)
+	if err != nil {
+		log.Printf("Invalid flush interval, using default 1s: %v", err)
+		flushInterval = time.Second
+	}
+
+	// Overflowing logs go to disk instead of being dropped
+	var spill *storage.Spill
+	replayInterval := 5 * time.Second
+	if cfg.Logging.Spill.Enabled {
+		spill, err = storage.OpenSpill(cfg.Logging.Spill.Dir, int64(cfg.Logging.Spill.MaxSizeMB)<<20)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log spill: %w", err)
+		}
+		if interval, err := time.ParseDuration(cfg.Logging.Spill.ReplayInterval); err == nil {
+			replayInterval = interval
+		} else {
+			log.Printf("Invalid spill replay interval, using default 5s: %v", err)
+		}
+		if pending := spill.Pending(); pending > 0 {
+			log.Printf("📼 Log spill has %d logs from a previous run to replay", pending)
+		}
+	}
+
+	return storage.NewAsyncLogWriter(storage.AsyncLogWriterConfig{
+		Backend:        storageBackend,
+		BufferSize:     cfg.Logging.BufferSize,
+		BatchSize:      cfg.Logging.BatchSize,
+		FlushInterval:  flushInterval,
+		Workers:        cfg.Logging.Workers,
+		Enabled:        cfg.Logging.Enabled,
+		SkipOnError:    cfg.Logging.SkipOnError,
+		Spill:          spill,
+		ReplayInterval: replayInterval,
+	}), nil
+}
+
+// setupKeyStore keeps virtual keys created on the admin API in PostgreSQL,
+// or in memory until restart without it
+func setupKeyStore(storageBackend storage.StorageBackend) vkeys.Store {
+	if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+		return vkeys.NewPostgresStore(pgStorage.GetDB())
+	}
+	log.Println("Warning: Virtual keys created on the admin API are kept in memory without PostgreSQL storage")
+	return vkeys.NewMemoryStore()
+}
+
+// setupAuditLog records admin actions in PostgreSQL, or in memory until
+// restart without it
+func setupAuditLog(storageBackend storage.StorageBackend) audit.Log {
+	if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+		return audit.NewPostgresLog(pgStorage.GetDB())
+	}
+	log.Println("Warning: The admin audit log is kept in memory without PostgreSQL storage")
+	return audit.NewMemoryLog(0)
+}
+
+// setupMemory initializes the in-memory storage backend
+func setupMemory(cfg *config.Config) (storage.StorageBackend, error) {
+	var maxAge time.Duration
+	if cfg.Storage.Memory.MaxAge != "" {
+		var err error
+		maxAge, err = time.ParseDuration(cfg.Storage.Memory.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory max_age: %w", err)
+		}
+	}
+	log.Println("Warning: Request logs are kept in memory and lost on restart")
+	return storage.NewMemoryStorage(storage.MemoryConfig{
+		MaxLogs: cfg.Storage.Memory.MaxLogs,
+		MaxAge:  maxAge,
+	}), nil
+}
+
+// setupPostgreSQL initializes PostgreSQL storage backend
+func setupPostgreSQL(cfg *config.Config) (storage.StorageBackend, error) {
+	pgCfg := cfg.Storage.Postgres
+
+	// Build connection URL
+	var connectionURL string
+	if pgCfg.URL != "" && !strings.Contains(pgCfg.URL, "${") {
+		connectionURL = pgCfg.URL
+	} else if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		connectionURL = dbURL
+	} else {
+		// Build URL from individual components
+		sslMode := pgCfg.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		connectionURL = fmt.Sprintf(
+			"postgres://%s:%s@%s:%d/%s?sslmode=%s",
+			pgCfg.Username,
+			pgCfg.Password,
+			pgCfg.Host,
+			pgCfg.Port,
+			pgCfg.Database,
+			sslMode,
+		)
+	}
+
+	log.Printf("Connecting to PostgreSQL database...")
+
+	// Create storage backend
+	return storage.NewPostgreSQLStorage(storage.PostgreSQLConfig{
+		ConnectionURL:   connectionURL,
+		MaxConnections:  pgCfg.MaxConnections,
+		MaxIdleConns:    pgCfg.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(pgCfg.ConnMaxLifetime) * time.Minute,
+	})
+}
+
+// exampleGuardrailFactory creates example guardrails
+func exampleGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	switch name {
+	case "input_example":
+		return examples.NewInputExampleGuardrail(name, priority, config), nil
+	case "output_example":
+		return examples.NewOutputExampleGuardrail(name, priority, config), nil
+	default:
+		return nil, fmt.Errorf("unknown example guardrail: %s", name)
+	}
+}
+
+// openaiGuardrailFactory creates OpenAI-based guardrails
+func openaiGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return openai.NewModerationGuardrail(name, priority, config), nil
+}
+
+// grpcGuardrailFactory creates guardrails that call an external GuardrailService
+func grpcGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return grpc.NewGuardrail(name, priority, config)
+}
+
+// onnxGuardrailFactory creates local ONNX classification guardrails
+func onnxGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return onnx.NewClassifierGuardrail(name, priority, config)
+}
+
+// maxTokensGuardrailFactory creates token limit guardrails
+func maxTokensGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return tokenlimit.NewMaxTokensGuardrail(name, priority, config)
+}
+
+// languageGuardrailFactory creates language allow-list guardrails
+func languageGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return language.NewLanguageGuardrail(name, priority, config)
+}
+
+// topicGuardrailFactory creates banned topic / brand-safety guardrails
+func topicGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return topic.NewTopicGuardrail(name, priority, config)
+}
+
+// exprGuardrailFactory creates guardrails from operator-written expression rules
+func exprGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return expr.NewGuardrail(name, priority, config)
+}
+
+// imageGuardrailFactory creates image validation and moderation guardrails
+func imageGuardrailFactory(name string, priority int, config map[string]interface{}) (guardrails.Guardrail, error) {
+	return vision.NewImageGuardrail(name, priority, config)
+}
+
+// setupGuardrails initializes the guardrails system
+func setupGuardrails(cfg *config.Config, storageBackend storage.StorageBackend) (*guardrails.Executor, error) {
+	if !cfg.Guardrails.Enabled {
+		return nil, fmt.Errorf("guardrails not enabled")
+	}
+
+	// Register example guardrails factory
+	guardrails.Register("example", exampleGuardrailFactory)
+
+	// Register OpenAI guardrails factory
+	guardrails.Register("openai_moderation", openaiGuardrailFactory)
+
+	// Register token limit guardrail factory
+	guardrails.Register("max_tokens", maxTokensGuardrailFactory)
+
+	// Register language allow-list guardrail factory
+	guardrails.Register("language", languageGuardrailFactory)
+
+	// Register banned topic guardrail factory
+	guardrails.Register("topic", topicGuardrailFactory)
+
+	// Register image validation and moderation guardrail factory
+	guardrails.Register("image", imageGuardrailFactory)
+
+	// Register local ONNX classifier factory (requires -tags onnx)
+	guardrails.Register("onnx_classifier", onnxGuardrailFactory)
+
+	// Register external gRPC guardrail service factory
+	guardrails.Register("grpc", grpcGuardrailFactory)
+
+	// Register expression rule guardrail factory
+	guardrails.Register("expr", exprGuardrailFactory)
+
+	// Parse timeout
+	timeout, err := time.ParseDuration(cfg.Guardrails.Timeout)
+	if err != nil {
+		timeout = 5 * time.Second // Default timeout
+		log.Printf("Invalid guardrails timeout, using default 5s: %v", err)
+	}
+
+	// Load input guardrails
+	inputGuardrails, err := guardrails.LoadAll(cfg.Guardrails.InputGuardrails)
+	if err != nil {
+		log.Printf("Warning: Some input guardrails failed to load: %v", err)
+	}
+
+	// Load output guardrails
+	outputGuardrails, err := guardrails.LoadAll(cfg.Guardrails.OutputGuardrails)
+	if err != nil {
+		log.Printf("Warning: Some output guardrails failed to load: %v", err)
+	}
+
+	// Per-guardrail timeouts and error handling; guardrails with invalid
+	// policies keep the defaults
+	policies, err := guardrails.NewPolicies(cfg.Guardrails)
+	if err != nil {
+		log.Printf("Warning: Some guardrail policies are invalid: %v", err)
+	}
+
+	// Create metrics writer if storage is available
+	var metricsWriter *guardrails.MetricsWriter
+	if storageBackend != nil {
+		if pgStorage, ok := storageBackend.(*storage.PostgreSQLStorage); ok && pgStorage != nil {
+			metricsWriter = guardrails.NewMetricsWriter(guardrails.MetricsWriterConfig{
+				DB:         pgStorage.GetDB(), // We need to add this method to expose the DB
+				BufferSize: cfg.Guardrails.MetricsBufferSize,
+				BatchSize:  cfg.Guardrails.MetricsBatchSize,
+				Workers:    cfg.Guardrails.MetricsWorkers,
+			})
+		}
+	}
+
+	// Create executor
+	executor := guardrails.NewExecutor(guardrails.ExecutorConfig{
+		InputGuardrails:  inputGuardrails,
+		OutputGuardrails: outputGuardrails,
+		MetricsWriter:    metricsWriter,
+		Timeout:          timeout,
+		Policies:         policies,
+	})
+
+	return executor, nil
+}
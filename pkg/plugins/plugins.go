@@ -0,0 +1,260 @@
+// Package plugins lets other Go modules compile extensions into the gateway.
+// A plugin registers itself from an init function:
+//
+//	func init() {
+//		plugins.Register(&acmePlugin{})
+//	}
+//
+// and is compiled in by a blank import in cmd/server/plugins.go. At startup
+// the gateway calls each enabled plugin's Init, where it adds guardrail
+// types, providers and middleware, then Start once the gateway is serving
+// and Stop when it shuts down.
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/NamanArora/flash-gateway/internal/config"
+	"github.com/NamanArora/flash-gateway/internal/guardrails"
+	"github.com/NamanArora/flash-gateway/internal/middleware"
+	"github.com/NamanArora/flash-gateway/internal/providers"
+)
+
+// Types plugins implement or receive, re-exported from the gateway's
+// internal packages
+type (
+	Guardrail           = guardrails.Guardrail
+	StructuredGuardrail = guardrails.StructuredGuardrail
+	ImageGuardrail      = guardrails.ImageGuardrail
+	GuardrailFactory    = guardrails.GuardrailFactory
+	GuardrailInput      = guardrails.GuardrailInput
+	GuardrailResult     = guardrails.Result
+	Image               = guardrails.Image
+	Provider            = providers.Provider
+	ProviderFactory     = providers.Factory
+	ProviderConfig      = config.ProviderConfig
+	Middleware          = func(http.Handler) http.Handler
+)
+
+// Plugin is a bundle of extensions compiled into the gateway
+type Plugin interface {
+	// Name identifies the plugin in the plugins configuration section
+	Name() string
+
+	// Init registers the plugin's extensions. It runs before guardrails,
+	// providers and the middleware chain are built.
+	Init(r *Registrar) error
+}
+
+// Starter is implemented by plugins with background work, started once the
+// gateway is serving
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is implemented by plugins that release resources on shutdown
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// Plugin lifecycle states
+const (
+	StateRegistered  = "registered"
+	StateDisabled    = "disabled"
+	StateInitialized = "initialized"
+	StateStarted     = "started"
+	StateStopped     = "stopped"
+	StateFailed      = "failed"
+)
+
+// entry is a registered plugin and its progress through the lifecycle
+type entry struct {
+	plugin   Plugin
+	state    string
+	provides []string
+	err      string
+}
+
+var (
+	plugins = make(map[string]*entry)
+	order   []string // Registration order, which Init and Start follow
+	mu      sync.Mutex
+)
+
+// Register compiles a plugin into the gateway. It should be called from the
+// plugin package's init function; registering two plugins with the same name
+// panics.
+func Register(p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p == nil {
+		panic("plugin is nil")
+	}
+	name := p.Name()
+	if _, exists := plugins[name]; exists {
+		panic(fmt.Sprintf("plugin %s registered twice", name))
+	}
+	plugins[name] = &entry{plugin: p, state: StateRegistered}
+	order = append(order, name)
+}
+
+// Registrar is handed to a plugin's Init to register its extensions
+type Registrar struct {
+	entry  *entry
+	config map[string]interface{}
+}
+
+// Config returns the plugin's config block from the plugins section
+func (r *Registrar) Config() map[string]interface{} {
+	return r.config
+}
+
+// Decode unmarshals the plugin's config block into v, a pointer to a struct
+// with json tags, the same way guardrail configs are read
+func (r *Registrar) Decode(v interface{}) error {
+	data, err := json.Marshal(r.config)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Guardrail adds a guardrail type usable in guardrail configurations
+func (r *Registrar) Guardrail(guardrailType string, factory GuardrailFactory) {
+	guardrails.Register(guardrailType, factory)
+	r.entry.provides = append(r.entry.provides, "guardrail:"+guardrailType)
+}
+
+// Provider adds the implementation used by providers whose type is name
+func (r *Registrar) Provider(name string, factory ProviderFactory) {
+	providers.Register(name, factory)
+	r.entry.provides = append(r.entry.provides, "provider:"+name)
+}
+
+// Middleware adds a middleware to every proxied request
+func (r *Registrar) Middleware(name string, m Middleware) {
+	middleware.Register(name, m)
+	r.entry.provides = append(r.entry.provides, "middleware:"+name)
+}
+
+// Init runs Init on every compiled-in plugin the configuration doesn't
+// disable, in registration order. Configuring a plugin that isn't compiled in
+// is an error.
+func Init(cfg map[string]config.PluginConfig) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var unknown []string
+	for name := range cfg {
+		if _, ok := plugins[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("plugins configured but not compiled in: %v", unknown)
+	}
+
+	for _, name := range order {
+		e := plugins[name]
+		pluginCfg := cfg[name]
+		if pluginCfg.Enabled != nil && !*pluginCfg.Enabled {
+			e.state = StateDisabled
+			continue
+		}
+		if err := e.plugin.Init(&Registrar{entry: e, config: pluginCfg.Config}); err != nil {
+			e.state, e.err = StateFailed, err.Error()
+			return fmt.Errorf("plugin %s: init: %w", name, err)
+		}
+		e.state = StateInitialized
+		log.Printf("[PLUGIN] Initialized %s %v", name, e.provides)
+	}
+	return nil
+}
+
+// Start starts initialized plugins in registration order. If one fails, the
+// ones already started are stopped again.
+func Start(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var started []*entry
+	for _, name := range order {
+		e := plugins[name]
+		if e.state != StateInitialized {
+			continue
+		}
+		if starter, ok := e.plugin.(Starter); ok {
+			if err := starter.Start(ctx); err != nil {
+				e.state, e.err = StateFailed, err.Error()
+				stopAll(ctx, started)
+				return fmt.Errorf("plugin %s: start: %w", name, err)
+			}
+		}
+		e.state = StateStarted
+		started = append(started, e)
+	}
+	return nil
+}
+
+// Stop stops started plugins in reverse registration order
+func Stop(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var started []*entry
+	for _, name := range order {
+		if e := plugins[name]; e.state == StateStarted {
+			started = append(started, e)
+		}
+	}
+	return stopAll(ctx, started)
+}
+
+// stopAll stops plugins last to first, collecting their errors
+func stopAll(ctx context.Context, started []*entry) error {
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		e := started[i]
+		e.state = StateStopped
+		stopper, ok := e.plugin.(Stopper)
+		if !ok {
+			continue
+		}
+		if err := stopper.Stop(ctx); err != nil {
+			e.err = err.Error()
+			errs = append(errs, fmt.Errorf("plugin %s: stop: %w", e.plugin.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status describes each compiled-in plugin, its lifecycle state and what it
+// registered, for the admin API
+func Status() interface{} {
+	mu.Lock()
+	defer mu.Unlock()
+
+	status := make([]map[string]interface{}, 0, len(order))
+	for _, name := range order {
+		e := plugins[name]
+		described := map[string]interface{}{
+			"name":     name,
+			"state":    e.state,
+			"provides": append([]string{}, e.provides...),
+		}
+		if e.err != "" {
+			described["error"] = e.err
+		}
+		status = append(status, described)
+	}
+	return status
+}